@@ -0,0 +1,51 @@
+// migrate-metadata converts object metadata between the metadata stores
+// supported by stupid-simple-s3. Run this offline while stupid-simple-s3 is
+// stopped, then start it with STUPID_METADATA_STORE set to the new store.
+//
+// Stores:
+//   - json: one meta.json file next to each object's data file (default).
+//   - kv: one append-only metadata log per bucket, which avoids an extra
+//     inode per object and walking the bucket to list it.
+//
+// Object data files are not touched. The metadata_store marker in the data
+// directory is switched once all metadata has been copied, so an interrupted
+// migration can be rerun. Buckets stored under another path are found
+// through STUPID_BUCKET_PATHS, which must be set as for the service.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/espen/stupid-simple-s3/internal/config"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+func main() {
+	dataPath := flag.String("data", "", "path to the data directory (required)")
+	to := flag.String("to", "", "metadata store to migrate to, \"json\" or \"kv\" (required)")
+	flag.Parse()
+
+	if *dataPath == "" || *to == "" {
+		fmt.Fprintln(os.Stderr, "Usage: migrate-metadata -data /path/to/data -to json|kv")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(*dataPath); err != nil {
+		log.Fatalf("Data directory not found: %s", *dataPath)
+	}
+	bucketPaths, err := config.LoadBucketPaths()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	migrated, err := storage.MigrateMetadataStore(*dataPath, bucketPaths, *to)
+	if err != nil {
+		log.Fatalf("Migration failed after %d objects: %v", migrated, err)
+	}
+
+	fmt.Printf("Migrated metadata of %d objects to the %q metadata store\n", migrated, *to)
+}
@@ -15,6 +15,12 @@
 //
 // The original S3 key is recovered from meta.json, not from the directory
 // name, so switching to a hash is safe.
+//
+// Buckets stored under another path are found through STUPID_BUCKET_PATHS,
+// which must be set as for the service.
+//
+// After a successful migration the layout_version marker in the data
+// directory is set to 2 so that stupid-simple-s3 will start again.
 package main
 
 import (
@@ -26,8 +32,13 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+
+	"github.com/espen/stupid-simple-s3/internal/config"
 )
 
+// layoutVersion is the storage layout version produced by this migration
+const layoutVersion = "2"
+
 type objectMeta struct {
 	Key string `json:"key"`
 }
@@ -47,17 +58,34 @@ func main() {
 	if _, err := os.Stat(bucketsPath); os.IsNotExist(err) {
 		log.Fatalf("Buckets directory not found: %s", bucketsPath)
 	}
+	bucketPaths, err := config.LoadBucketPaths()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Buckets with an override live in their own directory; a directory of
+	// the same name in the data directory is not used by the service
+	roots := []string{bucketsPath}
+	for bucket, path := range bucketPaths {
+		roots = append(roots, filepath.Join(path, "buckets", bucket))
+	}
 
 	var migrated, skipped, errors int
 
-	err := filepath.WalkDir(bucketsPath, func(path string, d os.DirEntry, err error) error {
+	walk := func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			log.Printf("Error accessing %s: %v", path, err)
 			errors++
 			return nil
 		}
 
-		if d.IsDir() || d.Name() != "meta.json" {
+		if d.IsDir() {
+			if _, overridden := bucketPaths[d.Name()]; overridden && filepath.Dir(path) == bucketsPath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "meta.json" {
 			return nil
 		}
 
@@ -132,22 +160,38 @@ func main() {
 		_ = os.Remove(prefixDir)
 
 		return nil
-	})
-
-	if err != nil {
-		log.Fatalf("Walk error: %v", err)
+	}
+	for _, root := range roots {
+		if _, err := os.Stat(root); os.IsNotExist(err) && root != bucketsPath {
+			log.Printf("Bucket directory not found: %s", root)
+			continue
+		}
+		if err := filepath.WalkDir(root, walk); err != nil {
+			log.Fatalf("Walk error: %v", err)
+		}
 	}
 
 	// Clean up empty prefix directories
 	if !*dryRun {
-		_ = filepath.WalkDir(bucketsPath, func(path string, d os.DirEntry, err error) error {
-			if err != nil || !d.IsDir() {
+		for _, root := range roots {
+			_ = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+				if err != nil || !d.IsDir() {
+					return nil
+				}
+				// Try to remove — only succeeds if empty
+				_ = os.Remove(path)
 				return nil
-			}
-			// Try to remove — only succeeds if empty
-			_ = os.Remove(path)
-			return nil
-		})
+			})
+		}
+	}
+
+	// Mark the data directory as migrated once every object has been moved
+	if !*dryRun && errors == 0 {
+		markerPath := filepath.Join(*dataPath, "layout_version")
+		if err := os.WriteFile(markerPath, []byte(layoutVersion+"\n"), 0600); err != nil {
+			log.Fatalf("Error writing %s: %v", markerPath, err)
+		}
+		fmt.Printf("Wrote layout version %s to %s\n", layoutVersion, markerPath)
 	}
 
 	fmt.Printf("\nMigration summary:\n")
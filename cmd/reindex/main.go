@@ -0,0 +1,53 @@
+// reindex rebuilds the object index of one or all buckets in a
+// stupid-simple-s3 data directory from the files on disk. Use it after
+// out-of-band changes such as restoring objects or metadata from a backup.
+//
+// Metadata is reloaded from disk, entries whose object data is missing are
+// dropped and the metadata store is compacted. The metadata store is taken
+// from the data directory. Buckets stored under another path are found
+// through STUPID_BUCKET_PATHS, which must be set as for the service.
+//
+// Run this offline while stupid-simple-s3 is stopped. To reindex a running
+// service, use POST /admin/reindex/{bucket} instead.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/espen/stupid-simple-s3/internal/config"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+func main() {
+	dataPath := flag.String("data", "", "path to the data directory (required)")
+	bucket := flag.String("bucket", "", "bucket to reindex (default: all buckets)")
+	flag.Parse()
+
+	if *dataPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: reindex -data /path/to/data [-bucket name]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(*dataPath); err != nil {
+		log.Fatalf("Data directory not found: %s", *dataPath)
+	}
+	bucketPaths, err := config.LoadBucketPaths()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	results, err := storage.ReindexDataDirectory(*dataPath, bucketPaths, *bucket, func(bucket string, checked int) {
+		fmt.Printf("%s: checked %d objects\n", bucket, checked)
+	})
+	for _, result := range results {
+		fmt.Printf("Reindexed bucket %s: %d objects, %d stale entries removed in %s\n",
+			result.Bucket, result.Objects, result.Removed, result.Duration)
+	}
+	if err != nil {
+		log.Fatalf("Reindex failed: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/espen/stupid-simple-s3/internal/config"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// runExport writes the objects of a bucket to a tar archive. It returns the
+// exit status. Opening the storage may write index and metadata files as at
+// service startup, so it must run with the service stopped.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	bucket := flags.String("bucket", "", "bucket to export (required)")
+	out := flags.String("out", "", "path of the tar archive to write (required)")
+	dryRun := flags.Bool("dry-run", false, "count the objects without writing the archive")
+	flags.Parse(args)
+
+	if *bucket == "" || (*out == "" && !*dryRun) {
+		fmt.Fprintln(os.Stderr, "Usage: stupid-simple-s3 export -bucket name -out backup.tar [-dry-run]")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	store, err := openConfiguredStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *dryRun {
+		result, err := storage.ExportBucket(ctx, store, *bucket, nil, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("[dry-run] would export %d objects (%d bytes) from %s\n", result.Objects, result.Bytes, *bucket)
+		return 0
+	}
+
+	// Write to a temporary file, so that a failed export does not leave a
+	// truncated archive behind
+	tmpPath := *out + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	result, err := storage.ExportBucket(ctx, store, *bucket, f, false)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, *out)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Exported %d objects (%d bytes) from %s to %s\n", result.Objects, result.Bytes, *bucket, *out)
+	return 0
+}
+
+// runImport restores the objects in a tar archive written by export into a
+// bucket. It returns the exit status.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	bucket := flags.String("bucket", "", "bucket to import into, created if missing (required)")
+	in := flags.String("in", "", "path of the tar archive to read (required)")
+	dryRun := flags.Bool("dry-run", false, "check the archive without importing anything")
+	flags.Parse(args)
+
+	if *bucket == "" || *in == "" {
+		fmt.Fprintln(os.Stderr, "Usage: stupid-simple-s3 import -bucket name -in backup.tar [-dry-run]")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	store, err := openConfiguredStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	result, err := storage.ImportBucket(ctx, store, *bucket, f, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed after %d objects: %v\n", result.Objects, err)
+		return 1
+	}
+
+	if *dryRun {
+		fmt.Printf("[dry-run] would import %d objects (%d bytes) into %s\n", result.Objects, result.Bytes, *bucket)
+		return 0
+	}
+	fmt.Printf("Imported %d objects (%d bytes) into %s\n", result.Objects, result.Bytes, *bucket)
+	return 0
+}
+
+// openConfiguredStorage opens the storage configured by the environment,
+// as the server does
+func openConfiguredStorage() (storage.MultipartStorage, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("loading configuration: %w", err)
+	}
+	return openStorage(cfg)
+}
@@ -8,7 +8,6 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
@@ -21,6 +20,16 @@ import (
 )
 
 func main() {
+	// Subcommands work on the configured storage instead of serving it
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		}
+	}
+
 	showVersion := flag.Bool("version", false, "Print version information and exit")
 	flag.Parse()
 
@@ -29,6 +38,9 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Log configuration errors in the requested format
+	configureLogger(os.Getenv("STUPID_LOG_FORMAT"), os.Getenv("STUPID_LOG_LEVEL"))
+
 	// Load configuration from environment variables
 	cfg, err := config.Load()
 	if err != nil {
@@ -44,21 +56,19 @@ func main() {
 	cfg.LogConfiguration()
 
 	// Initialize storage (creates directories if they don't exist)
-	store, err := storage.NewFilesystemStorage(cfg.Storage.Path, cfg.Storage.MultipartPath)
+	store, err := openStorage(cfg)
 	if err != nil {
 		slog.Error("failed to initialize storage", "error", err)
 		os.Exit(1)
 	}
 
-	// Count existing buckets for metrics
-	bucketsPath := filepath.Join(cfg.Storage.Path, "buckets")
-	if entries, err := os.ReadDir(bucketsPath); err == nil {
-		for _, entry := range entries {
-			if entry.IsDir() {
-				metrics.BucketsTotal.Inc()
-			}
+	// Count existing buckets, and the objects and bytes in each, for metrics
+	if buckets, err := store.BucketNames(); err == nil {
+		metrics.BucketsTotal.Add(float64(len(buckets)))
+		slog.Info("found existing buckets", "count", len(buckets))
+		for _, bucket := range buckets {
+			api.UpdateBucketUsageMetrics(store, bucket)
 		}
-		slog.Info("found existing buckets", "count", len(entries))
 	}
 
 	// Auto-create bucket at startup if configured
@@ -76,6 +86,7 @@ func main() {
 			slog.Info("created bucket", "bucket", cfg.Bucket.Name)
 			metrics.BucketCreationsTotal.Inc()
 			metrics.BucketsTotal.Inc()
+			api.UpdateBucketUsageMetrics(store, cfg.Bucket.Name)
 		}
 	}
 
@@ -84,9 +95,37 @@ func main() {
 		go runCleanupJob(store, cfg.Cleanup.GetInterval(), cfg.Cleanup.GetMaxAge())
 	}
 
+	// The memory backend has no disk to monitor
+	if cfg.Storage.Backend != storage.BackendMemory {
+		go runDiskSpaceMonitor(store, diskSpaceInterval)
+	}
+
+	// Export the metadata cache counters when the cache is enabled
+	if cacher, ok := store.(storage.MetadataCacher); ok {
+		if _, enabled := cacher.MetadataCacheStats(); enabled {
+			go runMetadataCacheMonitor(cacher, metadataCacheInterval)
+		}
+	}
+
+	// Reload credentials file on change if configured
+	if cfg.Auth.CredentialsFile != "" {
+		go cfg.WatchCredentialsFile(cfg.Auth.CredentialsReloadInterval)
+	}
+
 	// Create server
 	server := api.NewServer(cfg, store)
 
+	// Open the audit log if configured
+	if cfg.Log.AuditLog != "" {
+		audit, err := api.OpenAuditLog(cfg.Log.AuditLog, cfg.Storage.Durable)
+		if err != nil {
+			slog.Error("failed to open audit log", "path", cfg.Log.AuditLog, "error", err)
+			os.Exit(1)
+		}
+		defer audit.Close()
+		server.SetAuditLog(audit)
+	}
+
 	// Start server in goroutine
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -113,6 +152,20 @@ func main() {
 	slog.Info("server stopped")
 }
 
+// openStorage creates the configured storage backend
+func openStorage(cfg *config.Config) (storage.MultipartStorage, error) {
+	return storage.New(cfg.Storage.Backend, storage.BackendOptions{
+		Path:                 cfg.Storage.Path,
+		MultipartPath:        cfg.Storage.MultipartPath,
+		MetadataStore:        cfg.Storage.MetadataStore,
+		BucketPaths:          cfg.Storage.BucketPaths,
+		DisableSync:          !cfg.Storage.Durable,
+		ImmutabilityWindows:  cfg.Storage.ImmutabilityWindows,
+		MetadataCacheEntries: int(cfg.Storage.MetadataCacheEntries),
+		TrashRetention:       cfg.Storage.TrashRetention,
+	})
+}
+
 // configureLogger sets up the default slog logger
 func configureLogger(format, level string) {
 	opts := &slog.HandlerOptions{
@@ -143,7 +196,54 @@ func parseLogLevel(level string) slog.Level {
 	}
 }
 
-// runCleanupJob periodically cleans up stale multipart uploads
+// diskSpaceInterval is how often the disk space metrics are refreshed
+const diskSpaceInterval = 30 * time.Second
+
+// runDiskSpaceMonitor periodically records the free and total space of the
+// storage filesystems
+func runDiskSpaceMonitor(store storage.MultipartStorage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		data, multipart, err := store.DiskSpace("")
+		if err != nil {
+			slog.Error("disk space check error", "error", err)
+		} else {
+			metrics.DiskFreeBytes.WithLabelValues("data").Set(float64(data.Free))
+			metrics.DiskTotalBytes.WithLabelValues("data").Set(float64(data.Total))
+			metrics.DiskFreeBytes.WithLabelValues("multipart").Set(float64(multipart.Free))
+			metrics.DiskTotalBytes.WithLabelValues("multipart").Set(float64(multipart.Total))
+		}
+		<-ticker.C
+	}
+}
+
+// metadataCacheInterval is how often the metadata cache metrics are refreshed
+const metadataCacheInterval = 15 * time.Second
+
+// runMetadataCacheMonitor periodically records the lookups served by the
+// metadata cache
+func runMetadataCacheMonitor(cacher storage.MetadataCacher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last storage.MetadataCacheStats
+	for {
+		stats, _ := cacher.MetadataCacheStats()
+		metrics.MetadataCacheLookupsTotal.WithLabelValues("hit").Add(float64(stats.Hits - last.Hits))
+		metrics.MetadataCacheLookupsTotal.WithLabelValues("miss").Add(float64(stats.Misses - last.Misses))
+		if lookups := stats.Hits + stats.Misses; lookups > 0 {
+			metrics.MetadataCacheHitRatio.Set(float64(stats.Hits) / float64(lookups))
+		}
+		metrics.MetadataCacheEntries.Set(float64(stats.Entries))
+		last = stats
+		<-ticker.C
+	}
+}
+
+// runCleanupJob periodically cleans up stale multipart uploads and deletes
+// objects expired by bucket lifecycle rules
 func runCleanupJob(store storage.MultipartStorage, interval, maxAge time.Duration) {
 	slog.Info("starting multipart upload cleanup job",
 		"interval", interval.String(),
@@ -151,6 +251,19 @@ func runCleanupJob(store storage.MultipartStorage, interval, maxAge time.Duratio
 	)
 
 	// Run immediately on startup
+	runCleanup(store, maxAge)
+
+	// Then run periodically
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		runCleanup(store, maxAge)
+	}
+}
+
+// runCleanup runs one pass of the cleanup job
+func runCleanup(store storage.MultipartStorage, maxAge time.Duration) {
 	cleaned, err := store.CleanupStaleUploads(maxAge)
 	if err != nil {
 		slog.Error("cleanup error", "error", err)
@@ -158,16 +271,26 @@ func runCleanupJob(store storage.MultipartStorage, interval, maxAge time.Duratio
 		slog.Info("cleaned up stale multipart uploads", "count", cleaned)
 	}
 
-	// Then run periodically
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	expired, err := store.ExpireObjects(time.Now())
+	buckets := make(map[string]bool)
+	for _, exp := range expired {
+		slog.Info("lifecycle expired object", "bucket", exp.Bucket, "key", exp.Key, "rule_id", exp.RuleID)
+		metrics.LifecycleExpirationsTotal.Inc()
+		buckets[exp.Bucket] = true
+	}
+	for bucket := range buckets {
+		api.UpdateBucketUsageMetrics(store, bucket)
+	}
+	if err != nil {
+		slog.Error("lifecycle expiration error", "error", err)
+	}
 
-	for range ticker.C {
-		cleaned, err := store.CleanupStaleUploads(maxAge)
+	if trash, ok := store.(storage.TrashStorage); ok {
+		purged, err := trash.PurgeTrash(time.Now())
 		if err != nil {
-			slog.Error("cleanup error", "error", err)
-		} else if cleaned > 0 {
-			slog.Info("cleaned up stale multipart uploads", "count", cleaned)
+			slog.Error("trash purge error", "error", err)
+		} else if purged > 0 {
+			slog.Info("purged deleted objects from trash", "count", purged)
 		}
 	}
 }
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// adminError is the error body of the admin API. Admin endpoints are called
+// by operators' tooling rather than S3 clients, so errors are JSON instead
+// of S3 XML, with the same codes and status.
+type adminError struct {
+	Code    s3.ErrorCode      `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// writeAdminError writes the S3 error code as an admin API error with the
+// code's status and default message
+func writeAdminError(w http.ResponseWriter, code s3.ErrorCode, details map[string]string) {
+	writeAdminErrorWithMessage(w, code, s3.NewError(code, "").Message, details)
+}
+
+// writeAdminErrorWithMessage writes the S3 error code as an admin API error
+// with the code's status and the given message
+func writeAdminErrorWithMessage(w http.ResponseWriter, code s3.ErrorCode, message string, details map[string]string) {
+	s3Err := s3.NewError(code, "")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(s3Err.StatusCode())
+	_ = json.NewEncoder(w).Encode(adminError{
+		Code:    code,
+		Message: message,
+		Details: details,
+	})
+}
+
+// isAdminRequest reports whether r is a request to the admin API
+func isAdminRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/admin/")
+}
+
+// writeRequestError writes an error from a middleware shared by the S3 and
+// admin APIs, as S3 XML or as an admin API error depending on the request
+func writeRequestError(w http.ResponseWriter, r *http.Request, code s3.ErrorCode) {
+	writeRequestErrorWithMessage(w, r, code, s3.NewError(code, "").Message)
+}
+
+// writeRequestErrorWithMessage is writeRequestError with a custom message
+func writeRequestErrorWithMessage(w http.ResponseWriter, r *http.Request, code s3.ErrorCode, message string) {
+	if isAdminRequest(r) {
+		writeAdminErrorWithMessage(w, code, message, nil)
+		return
+	}
+	s3.WriteErrorResponseWithMessage(w, code, message)
+}
+
+// ReindexBucket handles POST /admin/reindex/{bucket}. The index is rebuilt
+// while the bucket stays available, and the counts are returned as JSON.
+// Progress is logged for large buckets.
+func (h *Handlers) ReindexBucket(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	requestID := GetRequestID(r)
+
+	details := map[string]string{"bucket": bucket, "request_id": requestID}
+
+	reindexer, ok := h.storage.(storage.Reindexer)
+	if !ok {
+		writeAdminError(w, s3.ErrNotImplemented, details)
+		return
+	}
+
+	slog.Info("reindexing bucket", "bucket", bucket, "request_id", requestID)
+	result, err := reindexer.Reindex(bucket, func(checked int) {
+		slog.Info("reindex progress", "bucket", bucket, "checked", checked, "request_id", requestID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBucketNotFound):
+			writeAdminError(w, s3.ErrNoSuchBucket, details)
+		case errors.Is(err, storage.ErrInvalidBucketName):
+			writeAdminError(w, s3.ErrInvalidBucketName, details)
+		case errors.Is(err, storage.ErrReindexInProgress):
+			writeAdminError(w, s3.ErrOperationAborted, details)
+		default:
+			slog.Error("failed to reindex bucket", "bucket", bucket, "error", err, "request_id", requestID)
+			writeAdminError(w, s3.ErrInternalError, details)
+		}
+		return
+	}
+
+	slog.Info("reindexed bucket", "bucket", bucket, "objects", result.Objects, "removed", result.Removed,
+		"duration", result.Duration.String(), "request_id", requestID)
+	UpdateBucketUsageMetrics(h.storage, bucket)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// RestoreObject handles POST /admin/restore/{bucket}/{key...}. The most
+// recently deleted copy of the key is moved back from the bucket's trash,
+// and its metadata is returned as JSON.
+func (h *Handlers) RestoreObject(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	key := r.PathValue("key")
+	requestID := GetRequestID(r)
+
+	details := map[string]string{"bucket": bucket, "key": key, "request_id": requestID}
+
+	trash, ok := h.storage.(storage.TrashStorage)
+	if !ok {
+		writeAdminError(w, s3.ErrNotImplemented, details)
+		return
+	}
+
+	meta, err := trash.RestoreObject(bucket, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBucketNotFound):
+			writeAdminError(w, s3.ErrNoSuchBucket, details)
+		case errors.Is(err, storage.ErrInvalidBucketName):
+			writeAdminError(w, s3.ErrInvalidBucketName, details)
+		case errors.Is(err, storage.ErrInvalidKey):
+			writeAdminError(w, invalidKeyError(err), details)
+		case errors.Is(err, storage.ErrObjectNotFound):
+			writeAdminError(w, s3.ErrNoSuchKey, details)
+		case errors.Is(err, storage.ErrObjectAlreadyExists):
+			// Restoring never replaces an object written since the delete
+			writeAdminError(w, s3.ErrPreconditionFailed, details)
+		default:
+			slog.Error("failed to restore object", "bucket", bucket, "key", key, "error", err, "request_id", requestID)
+			writeAdminError(w, s3.ErrInternalError, details)
+		}
+		return
+	}
+
+	slog.Info("restored object from trash", "bucket", bucket, "key", key, "request_id", requestID)
+	UpdateBucketUsageMetrics(h.storage, bucket)
+	auditObjectChange(r, key, meta)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(meta)
+}
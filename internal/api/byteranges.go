@@ -0,0 +1,146 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// maxRanges is the maximum number of ranges accepted in a single Range header
+const maxRanges = 100
+
+// byteRange is an inclusive byte range. Before resolving against the object
+// size, a negative start is a suffix length and an end of -1 is open-ended.
+type byteRange struct {
+	start, end int64
+}
+
+// resolveRanges converts parsed ranges to absolute offsets within an object
+// of the given size, dropping ranges that cannot be satisfied and merging
+// ranges that overlap or are adjacent. whole reports that the requested
+// ranges add up to more than the object, in which case the Range header is
+// to be ignored and the whole object sent, as net/http does, rather than
+// sending the same bytes many times over.
+func resolveRanges(specs []byteRange, size int64) (ranges []byteRange, whole bool) {
+	ranges = make([]byteRange, 0, len(specs))
+	var total int64
+	for _, ra := range specs {
+		start, end := ra.start, ra.end
+
+		// Handle suffix range (bytes=-N means last N bytes)
+		if start < 0 {
+			start = size + start
+			if start < 0 {
+				start = 0
+			}
+			end = size - 1
+		}
+
+		// Handle open-ended range (bytes=N-)
+		if end < 0 || end >= size {
+			end = size - 1
+		}
+
+		if start > end || start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
+		total += end - start + 1
+	}
+	if total > size {
+		return nil, true
+	}
+	return coalesceRanges(ranges), false
+}
+
+// coalesceRanges sorts ranges by offset and merges those that overlap or
+// are adjacent
+func coalesceRanges(ranges []byteRange) []byteRange {
+	if len(ranges) < 2 {
+		return ranges
+	}
+	slices.SortFunc(ranges, func(a, b byteRange) int { return cmp.Compare(a.start, b.start) })
+	merged := ranges[:1]
+	for _, ra := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if ra.start <= last.end+1 {
+			last.end = max(last.end, ra.end)
+			continue
+		}
+		merged = append(merged, ra)
+	}
+	return merged
+}
+
+// partHeader returns the MIME header of the body part for the range
+func (ra byteRange) partHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {contentType},
+		"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", ra.start, ra.end, size)},
+	}
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// byteRangesLength picks a boundary for a multipart/byteranges body and
+// returns it with the length of the body. The part headers are encoded on
+// their own, so a body written with the same boundary has exactly that length.
+func byteRangesLength(ranges []byteRange, contentType string, size int64) (string, int64) {
+	var encoded countingWriter
+	sizer := multipart.NewWriter(&encoded)
+	var length int64
+	for _, ra := range ranges {
+		_, _ = sizer.CreatePart(ra.partHeader(contentType, size))
+		length += ra.end - ra.start + 1
+	}
+	_ = sizer.Close()
+	return sizer.Boundary(), length + int64(encoded)
+}
+
+// writeByteRanges writes a 206 multipart/byteranges response with one body
+// part per range of an open object
+func writeByteRanges(w http.ResponseWriter, r *http.Request, reader io.ReadSeeker, meta *s3.ObjectMetadata, ranges []byteRange) {
+	// Set response headers
+	setObjectHeaders(w, meta)
+
+	// Apply response header overrides for presigned URLs. An overridden
+	// content type applies to the body parts.
+	applyResponseHeaderOverrides(w, r)
+	partType := w.Header().Get("Content-Type")
+
+	boundary, contentLength := byteRangesLength(ranges, partType, meta.Size)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	mw := multipart.NewWriter(w)
+	_ = mw.SetBoundary(boundary)
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(ra.partHeader(partType, meta.Size))
+		if err != nil {
+			return
+		}
+		if _, err := reader.Seek(ra.start, io.SeekStart); err != nil {
+			slog.Error("failed to seek object for range request", "error", err, "bucket", r.PathValue("bucket"), "key", r.PathValue("key"), "start", ra.start, "request_id", GetRequestID(r))
+			return
+		}
+		if _, err := io.CopyN(part, reader, ra.end-ra.start+1); err != nil {
+			return
+		}
+	}
+	_ = mw.Close()
+}
@@ -2,10 +2,14 @@ package api
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"strconv"
 	"strings"
+
+	"github.com/espen/stupid-simple-s3/internal/auth"
 )
 
 // awsChunkedReader decodes AWS chunked transfer encoding
@@ -16,14 +20,25 @@ type awsChunkedReader struct {
 	eof          bool
 	totalRead    int64
 	maxChunkSize int64
+
+	// verifier checks chunk signatures when set; nil skips verification
+	verifier  *auth.ChunkVerifier
+	signature string
+	hash      hash.Hash
 }
 
-// newAWSChunkedReader creates a new AWS chunked reader
-func newAWSChunkedReader(r io.Reader, maxChunkSize int64) *awsChunkedReader {
-	return &awsChunkedReader{
+// newAWSChunkedReader creates a new AWS chunked reader.
+// If verifier is non-nil, every chunk signature is verified as the chunk is read.
+func newAWSChunkedReader(r io.Reader, maxChunkSize int64, verifier *auth.ChunkVerifier) *awsChunkedReader {
+	cr := &awsChunkedReader{
 		reader:       bufio.NewReader(r),
 		maxChunkSize: maxChunkSize,
+		verifier:     verifier,
 	}
+	if verifier != nil {
+		cr.hash = sha256.New()
+	}
+	return cr
 }
 
 func (r *awsChunkedReader) Read(p []byte) (n int, err error) {
@@ -40,6 +55,11 @@ func (r *awsChunkedReader) Read(p []byte) (n int, err error) {
 			}
 
 			if chunkSize == 0 {
+				// The final chunk is signed over empty data
+				if err := r.verifyChunk(); err != nil {
+					return n, err
+				}
+
 				// Final chunk - read trailing CRLF
 				if _, err := r.reader.ReadString('\n'); err != nil && err != io.EOF {
 					return n, err
@@ -58,6 +78,9 @@ func (r *awsChunkedReader) Read(p []byte) (n int, err error) {
 		}
 
 		read, err := r.reader.Read(p[n : n+int(toRead)])
+		if r.hash != nil {
+			r.hash.Write(p[n : n+read])
+		}
 		n += read
 		r.remaining -= int64(read)
 		r.totalRead += int64(read)
@@ -66,8 +89,12 @@ func (r *awsChunkedReader) Read(p []byte) (n int, err error) {
 			return n, err
 		}
 
-		// If we've read the entire chunk, consume the trailing CRLF
+		// If we've read the entire chunk, verify it and consume the trailing CRLF
 		if r.remaining == 0 {
+			if err := r.verifyChunk(); err != nil {
+				return n, err
+			}
+
 			if _, err := r.reader.ReadString('\n'); err != nil && err != io.EOF {
 				return n, err
 			}
@@ -93,6 +120,11 @@ func (r *awsChunkedReader) readChunkHeader() (int64, error) {
 		return 0, fmt.Errorf("invalid chunk header")
 	}
 
+	r.signature = ""
+	if len(parts) == 2 {
+		r.signature = strings.TrimPrefix(parts[1], "chunk-signature=")
+	}
+
 	size, err := strconv.ParseInt(parts[0], 16, 64)
 	if err != nil {
 		return 0, fmt.Errorf("invalid chunk size: %w", err)
@@ -109,6 +141,17 @@ func (r *awsChunkedReader) readChunkHeader() (int64, error) {
 	return size, nil
 }
 
+// verifyChunk checks the signature of the chunk that was just read.
+// Does nothing when signature verification is disabled.
+func (r *awsChunkedReader) verifyChunk() error {
+	if r.verifier == nil {
+		return nil
+	}
+	err := r.verifier.VerifyChunk(r.signature, r.hash.Sum(nil))
+	r.hash.Reset()
+	return err
+}
+
 // TotalRead returns the total bytes of actual data read (excluding headers)
 func (r *awsChunkedReader) TotalRead() int64 {
 	return r.totalRead
@@ -128,9 +171,10 @@ func isAWSChunkedEncoding(contentEncoding, contentSha256 string) bool {
 
 // wrapBodyIfChunked wraps the request body if it uses AWS chunked encoding
 // Returns the wrapped reader. maxChunkSize limits the maximum allowed chunk size (0 = unlimited).
-func wrapBodyIfChunked(body io.ReadCloser, contentEncoding, contentSha256 string, maxChunkSize int64) io.Reader {
+// verifier checks chunk signatures of STREAMING-AWS4-HMAC-SHA256-PAYLOAD bodies (nil = no verification).
+func wrapBodyIfChunked(body io.ReadCloser, contentEncoding, contentSha256 string, maxChunkSize int64, verifier *auth.ChunkVerifier) io.Reader {
 	if isAWSChunkedEncoding(contentEncoding, contentSha256) {
-		return newAWSChunkedReader(body, maxChunkSize)
+		return newAWSChunkedReader(body, maxChunkSize, verifier)
 	}
 	return body
 }
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// Copy source conditional headers
+const (
+	copySourceIfMatchHeader           = "X-Amz-Copy-Source-If-Match"
+	copySourceIfNoneMatchHeader       = "X-Amz-Copy-Source-If-None-Match"
+	copySourceIfModifiedSinceHeader   = "X-Amz-Copy-Source-If-Modified-Since"
+	copySourceIfUnmodifiedSinceHeader = "X-Amz-Copy-Source-If-Unmodified-Since"
+)
+
+// errInvalidConditionCombination is returned when conditional headers are combined
+// in a way S3 does not allow
+var errInvalidConditionCombination = errors.New("invalid combination of conditional headers")
+
+// conditionResult is the outcome of evaluating request conditions
+type conditionResult int
+
+const (
+	// conditionPassed means the request may proceed
+	conditionPassed conditionResult = iota
+	// conditionFailed means if-match or if-unmodified-since did not hold
+	conditionFailed
+	// conditionNotModified means if-none-match or if-modified-since did not hold
+	conditionNotModified
+)
+
+// conditions holds the conditional headers of a request.
+// Empty strings mean the condition was not supplied.
+type conditions struct {
+	ifMatch           string
+	ifNoneMatch       string
+	ifModifiedSince   string
+	ifUnmodifiedSince string
+}
+
+// parseConditions extracts the standard If-* headers used by GET and HEAD
+func parseConditions(h http.Header) *conditions {
+	return &conditions{
+		ifMatch:           h.Get("If-Match"),
+		ifNoneMatch:       h.Get("If-None-Match"),
+		ifModifiedSince:   h.Get("If-Modified-Since"),
+		ifUnmodifiedSince: h.Get("If-Unmodified-Since"),
+	}
+}
+
+// parseCopySourceConditions extracts the x-amz-copy-source-if-* headers.
+// S3 only allows the entity tag and date conditions to be combined in matching
+// pairs: if-match with if-unmodified-since, and if-none-match with if-modified-since.
+// Any other combination returns errInvalidConditionCombination.
+func parseCopySourceConditions(h http.Header) (*conditions, error) {
+	c := &conditions{
+		ifMatch:           h.Get(copySourceIfMatchHeader),
+		ifNoneMatch:       h.Get(copySourceIfNoneMatchHeader),
+		ifModifiedSince:   h.Get(copySourceIfModifiedSinceHeader),
+		ifUnmodifiedSince: h.Get(copySourceIfUnmodifiedSinceHeader),
+	}
+
+	positive := c.ifMatch != "" || c.ifUnmodifiedSince != ""
+	negative := c.ifNoneMatch != "" || c.ifModifiedSince != ""
+	if positive && negative {
+		return nil, errInvalidConditionCombination
+	}
+
+	return c, nil
+}
+
+// evaluate checks the conditions against the object metadata.
+//
+// When if-match and if-unmodified-since are both present, a matching ETag
+// takes precedence and the date is ignored. When if-none-match and
+// if-modified-since are both present, both must hold.
+func (c *conditions) evaluate(meta *s3.ObjectMetadata) conditionResult {
+	lastModified := meta.LastModified.UTC().Truncate(time.Second)
+
+	if c.ifMatch != "" {
+		if !etagMatches(c.ifMatch, meta.ETag) {
+			return conditionFailed
+		}
+	} else if c.ifUnmodifiedSince != "" {
+		// Unparseable dates are ignored, as S3 does
+		if t, err := http.ParseTime(c.ifUnmodifiedSince); err == nil && lastModified.After(t) {
+			return conditionFailed
+		}
+	}
+
+	if c.ifNoneMatch != "" && etagMatches(c.ifNoneMatch, meta.ETag) {
+		return conditionNotModified
+	}
+	if c.ifModifiedSince != "" {
+		if t, err := http.ParseTime(c.ifModifiedSince); err == nil && !lastModified.After(t) {
+			return conditionNotModified
+		}
+	}
+
+	return conditionPassed
+}
+
+// ifRangeMatches reports whether the If-Range validator still matches the
+// object, so that the requested range may be served. An entity tag must match
+// the object's ETag, quoted or not, as some clients drop the quotes; weak tags
+// never match, as If-Range requires a strong comparison. A date matches if it
+// is not older than the object's Last-Modified. Any other value does not match.
+func ifRangeMatches(value string, meta *s3.ObjectMetadata) bool {
+	if value == "" {
+		return true
+	}
+	if strings.HasPrefix(value, "W/") {
+		return false
+	}
+	if strings.HasPrefix(value, "\"") {
+		return normalizeETag(value) == normalizeETag(meta.ETag)
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return !meta.LastModified.UTC().Truncate(time.Second).After(t)
+	}
+	return value == normalizeETag(meta.ETag)
+}
+
+// writeConditionResult writes the response for a request whose conditions did
+// not pass. Returns false if the request may proceed.
+func writeConditionResult(w http.ResponseWriter, result conditionResult, meta *s3.ObjectMetadata) bool {
+	switch result {
+	case conditionFailed:
+		s3.WriteErrorResponse(w, s3.ErrPreconditionFailed)
+		return true
+	case conditionNotModified:
+		setETagHeader(w, meta)
+		w.Header().Set("Last-Modified", meta.LastModified.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// etagMatches reports whether any entity tag in a comma-separated condition
+// value matches the given ETag. "*" matches any ETag. Quotes and weak
+// validator prefixes are ignored when comparing.
+func etagMatches(condition, etag string) bool {
+	etag = normalizeETag(etag)
+	for _, candidate := range strings.Split(condition, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if candidate != "" && normalizeETag(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setETagHeader sets the ETag of an object response. The stored ETag is a
+// strong validator for the stored bytes. Objects are stored without a
+// Content-Encoding, so one already set on the response means a layer in front
+// of the handler transforms the body (such as compressing it on the fly); the
+// ETag is then sent in weak form, so that caches do not treat the transformed
+// bytes as identical to the stored ones.
+func setETagHeader(w http.ResponseWriter, meta *s3.ObjectMetadata) {
+	etag := meta.ETag
+	if w.Header().Get("Content-Encoding") != "" && !strings.HasPrefix(etag, "W/") {
+		etag = "W/" + etag
+	}
+	w.Header().Set("ETag", etag)
+}
+
+// normalizeETag strips the weak validator prefix and surrounding quotes
+func normalizeETag(etag string) string {
+	etag = strings.TrimPrefix(etag, "W/")
+	return strings.Trim(etag, "\"")
+}
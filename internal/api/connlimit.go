@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/espen/stupid-simple-s3/internal/metrics"
+)
+
+// limitListener counts open connections and holds them to a maximum.
+// Connections beyond the maximum are closed as soon as they are accepted,
+// so that a connection flood is turned away instead of exhausting file
+// descriptors, and clients fail fast rather than wait in the backlog.
+type limitListener struct {
+	net.Listener
+	max    int64 // 0 = unlimited
+	active atomic.Int64
+}
+
+// newLimitListener wraps l to allow at most max concurrent connections
+func newLimitListener(l net.Listener, max int64) *limitListener {
+	return &limitListener{Listener: l, max: max}
+}
+
+// Accept waits for the next connection within the limit
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if n := l.active.Add(1); l.max > 0 && n > l.max {
+			l.active.Add(-1)
+			metrics.ConnectionsRejectedTotal.Inc()
+			_ = conn.Close()
+			continue
+		}
+		metrics.ConnectionsOpen.Inc()
+		return &limitConn{Conn: conn, release: l.release}, nil
+	}
+}
+
+func (l *limitListener) release() {
+	l.active.Add(-1)
+	metrics.ConnectionsOpen.Dec()
+}
+
+// limitConn releases its slot in the listener when it is closed
+type limitConn struct {
+	net.Conn
+	release   func()
+	closeOnce sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(c.release)
+	return err
+}
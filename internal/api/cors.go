@@ -0,0 +1,326 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// maxCORSRules is the maximum number of rules in a CORS configuration, as in S3
+const maxCORSRules = 100
+
+// corsMethods are the methods a CORS rule may allow
+var corsMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPut:    true,
+	http.MethodHead:   true,
+	http.MethodPost:   true,
+	http.MethodDelete: true,
+}
+
+var (
+	errMalformedCORS = errors.New("malformed CORS configuration")
+	errInvalidCORS   = errors.New("invalid CORS configuration")
+)
+
+// PutBucketCors handles PUT /{bucket}?cors. The new rules replace any
+// existing ones.
+func (h *Handlers) PutBucketCors(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	const maxXMLBodySize = 64 * 1024
+	var config s3.CORSConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&config); err != nil {
+		s3.WriteErrorResponse(w, s3.ErrMalformedXML)
+		return
+	}
+
+	rules, err := parseCORSRules(config.CORSRules)
+	if err != nil {
+		if errors.Is(err, errMalformedCORS) {
+			s3.WriteErrorResponse(w, s3.ErrMalformedXML)
+		} else {
+			s3.WriteErrorResponse(w, s3.ErrInvalidRequest)
+		}
+		return
+	}
+
+	if err := h.storage.PutBucketCORS(bucket, rules); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBucketNotFound):
+			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+		case errors.Is(err, storage.ErrInvalidBucketName):
+			s3.WriteErrorResponse(w, s3.ErrInvalidBucketName)
+		default:
+			slog.Error("failed to put bucket CORS", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+			s3.WriteErrorResponse(w, s3.ErrInternalError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetBucketCors handles GET /{bucket}?cors
+func (h *Handlers) GetBucketCors(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	rules, err := h.storage.GetBucketCORS(bucket)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNoSuchCORSConfiguration):
+			s3.WriteErrorResponse(w, s3.ErrNoSuchCORSConfiguration)
+		case errors.Is(err, storage.ErrBucketNotFound):
+			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+		default:
+			slog.Error("failed to get bucket CORS", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+			s3.WriteErrorResponse(w, s3.ErrInternalError)
+		}
+		return
+	}
+
+	result := s3.CORSConfiguration{
+		Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/",
+	}
+	for _, rule := range rules {
+		result.CORSRules = append(result.CORSRules, s3.CORSRule{
+			ID:             rule.ID,
+			AllowedHeaders: rule.AllowedHeaders,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedOrigins: rule.AllowedOrigins,
+			ExposeHeaders:  rule.ExposeHeaders,
+			MaxAgeSeconds:  rule.MaxAgeSeconds,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// DeleteBucketCors handles DELETE /{bucket}?cors
+func (h *Handlers) DeleteBucketCors(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	if err := h.storage.DeleteBucketCORS(bucket); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBucketNotFound):
+			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+		case errors.Is(err, storage.ErrInvalidBucketName):
+			s3.WriteErrorResponse(w, s3.ErrInvalidBucketName)
+		default:
+			slog.Error("failed to delete bucket CORS", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+			s3.WriteErrorResponse(w, s3.ErrInternalError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseCORSRules validates CORS rules from a request. Missing elements
+// return errMalformedCORS, unsupported values errInvalidCORS.
+func parseCORSRules(rules []s3.CORSRule) ([]storage.CORSRule, error) {
+	if len(rules) == 0 || len(rules) > maxCORSRules {
+		return nil, errMalformedCORS
+	}
+
+	parsed := make([]storage.CORSRule, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return nil, errMalformedCORS
+		}
+		for _, method := range rule.AllowedMethods {
+			if !corsMethods[method] {
+				return nil, errInvalidCORS
+			}
+		}
+		for _, pattern := range append(append([]string(nil), rule.AllowedOrigins...), rule.AllowedHeaders...) {
+			if strings.Count(pattern, "*") > 1 {
+				return nil, errInvalidCORS
+			}
+		}
+		if rule.MaxAgeSeconds != nil && *rule.MaxAgeSeconds < 0 {
+			return nil, errInvalidCORS
+		}
+
+		parsed = append(parsed, storage.CORSRule{
+			ID:             rule.ID,
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedHeaders: rule.AllowedHeaders,
+			ExposeHeaders:  rule.ExposeHeaders,
+			MaxAgeSeconds:  rule.MaxAgeSeconds,
+		})
+	}
+	return parsed, nil
+}
+
+// wildcardMatch matches s against a pattern with at most one "*"
+func wildcardMatch(pattern, s string) bool {
+	before, after, found := strings.Cut(pattern, "*")
+	if !found {
+		return pattern == s
+	}
+	return len(s) >= len(before)+len(after) && strings.HasPrefix(s, before) && strings.HasSuffix(s, after)
+}
+
+// matchCORSRule returns the first rule allowing a request from origin with
+// method and the given request headers, or nil
+func matchCORSRule(rules []storage.CORSRule, origin, method string, headers []string) *storage.CORSRule {
+	for i := range rules {
+		if corsRuleAllows(&rules[i], origin, method, headers) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// corsRuleAllows reports whether a rule allows a request
+func corsRuleAllows(rule *storage.CORSRule, origin, method string, headers []string) bool {
+	if !anyMatch(rule.AllowedOrigins, origin, false) || !anyMatch(rule.AllowedMethods, method, false) {
+		return false
+	}
+	for _, header := range headers {
+		if !anyMatch(rule.AllowedHeaders, header, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// anyMatch reports whether any pattern matches s. Header names are
+// matched case-insensitively.
+func anyMatch(patterns []string, s string, foldCase bool) bool {
+	if foldCase {
+		s = strings.ToLower(s)
+	}
+	for _, pattern := range patterns {
+		if foldCase {
+			pattern = strings.ToLower(pattern)
+		}
+		if wildcardMatch(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRequestHeaders splits an Access-Control-Request-Headers value
+func parseRequestHeaders(value string) []string {
+	var headers []string
+	for _, header := range strings.Split(value, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// setCORSHeaders sets the response headers for a request allowed by rule.
+// Credentialed requests are only allowed from origins the rule lists
+// explicitly: a rule matching any origin with "*" answers with a wildcard,
+// and one matching by a pattern echoes the origin without allowing
+// credentials.
+func setCORSHeaders(w http.ResponseWriter, rule *storage.CORSRule, origin string) {
+	header := w.Header()
+	switch {
+	case slices.Contains(rule.AllowedOrigins, origin):
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	case slices.Contains(rule.AllowedOrigins, "*"):
+		header.Set("Access-Control-Allow-Origin", "*")
+	default:
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(rule.ExposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+	if rule.MaxAgeSeconds != nil {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(*rule.MaxAgeSeconds))
+	}
+}
+
+// Options handles OPTIONS requests without an Origin header, which the CORS
+// middleware passes on as they are not preflight requests
+func (h *Handlers) Options(w http.ResponseWriter, r *http.Request) {
+	s3.WriteErrorResponseWithMessage(w, s3.ErrInvalidRequest, "Insufficient information. Origin request header needed.")
+}
+
+// CORSMiddleware applies the CORS rules of the bucket named by the first
+// path segment. It answers preflight OPTIONS requests itself and adds the
+// Access-Control-* headers to other requests from an allowed origin.
+// Requests without an Origin header, and requests to buckets without CORS
+// rules, are passed through unchanged; preflight requests to those buckets
+// are rejected with 403, as in S3.
+func CORSMiddleware(store storage.CORSStorage) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			bucket, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+			preflight := r.Method == http.MethodOptions
+			rules, err := store.GetBucketCORS(bucket)
+			if err != nil && !preflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The response depends on the CORS request headers, whether or
+			// not they are allowed
+			w.Header().Add("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+
+			if preflight && err != nil {
+				switch {
+				case errors.Is(err, storage.ErrNoSuchCORSConfiguration):
+					s3.WriteErrorResponseWithMessage(w, s3.ErrCORSForbidden, "CORSResponse: CORS is not enabled for this bucket.")
+				case errors.Is(err, storage.ErrBucketNotFound), errors.Is(err, storage.ErrInvalidBucketName):
+					s3.WriteErrorResponseWithMessage(w, s3.ErrCORSForbidden, "CORSResponse: Bucket not found.")
+				default:
+					slog.Error("failed to get bucket CORS", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+					s3.WriteErrorResponse(w, s3.ErrInternalError)
+				}
+				return
+			}
+
+			if !preflight {
+				if rule := matchCORSRule(rules, origin, r.Method, nil); rule != nil {
+					setCORSHeaders(w, rule, origin)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			if method == "" {
+				s3.WriteErrorResponse(w, s3.ErrInvalidRequest)
+				return
+			}
+			requested := parseRequestHeaders(r.Header.Get("Access-Control-Request-Headers"))
+			rule := matchCORSRule(rules, origin, method, requested)
+			if rule == nil {
+				s3.WriteErrorResponse(w, s3.ErrCORSForbidden)
+				return
+			}
+
+			setCORSHeaders(w, rule, origin)
+			if len(requested) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// diskSpaceCacheTTL is how long a free space reading is reused, so that
+// writes do not each cost a statfs
+const diskSpaceCacheTTL = 5 * time.Second
+
+// diskSpaceCache holds the most recent free space reading of each bucket,
+// as buckets may be stored on different volumes
+type diskSpaceCache struct {
+	mu       sync.Mutex
+	readings map[string]diskSpaceReading
+}
+
+// diskSpaceReading is a free space reading of the volumes of a bucket
+type diskSpaceReading struct {
+	checkedAt time.Time
+	free      uint64
+	err       error
+}
+
+// freeBytes returns the free bytes on the fuller of the filesystems holding
+// the data of bucket and the multipart path, reading them again when the
+// cached value is stale
+func (c *diskSpaceCache) freeBytes(store storage.MultipartStorage, bucket string) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if reading, ok := c.readings[bucket]; ok && time.Since(reading.checkedAt) < diskSpaceCacheTTL {
+		return reading.free, reading.err
+	}
+	data, multipart, err := store.DiskSpace(bucket)
+	reading := diskSpaceReading{checkedAt: time.Now(), free: min(data.Free, multipart.Free), err: err}
+	if c.readings == nil {
+		c.readings = make(map[string]diskSpaceReading)
+	}
+	c.readings[bucket] = reading
+	return reading.free, reading.err
+}
+
+// rejectLowDiskSpace writes ServiceUnavailable and returns true if free
+// space on the volumes storing the request's bucket is below the configured
+// minimum, so that a full disk fails writes up front rather than in the
+// middle of an upload
+func (h *Handlers) rejectLowDiskSpace(w http.ResponseWriter, r *http.Request) bool {
+	limit := h.cfg.Limits.MinFreeBytes
+	if limit <= 0 {
+		return false
+	}
+
+	bucket := r.PathValue("bucket")
+	free, err := h.diskSpace.freeBytes(h.storage, bucket)
+	if err != nil {
+		slog.Error("failed to check free disk space", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return true
+	}
+	if free < uint64(limit) {
+		slog.Warn("rejecting write, low on disk space", "bucket", bucket, "free_bytes", free, "min_free_bytes", limit, "request_id", GetRequestID(r))
+		s3.WriteErrorResponseWithMessage(w, s3.ErrServiceUnavailable, "Not enough free disk space to accept writes.")
+		return true
+	}
+	return false
+}
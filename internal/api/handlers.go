@@ -1,6 +1,9 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -9,8 +12,11 @@ import (
 	"math"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/espen/stupid-simple-s3/internal/auth"
 	"github.com/espen/stupid-simple-s3/internal/config"
@@ -22,17 +28,43 @@ import (
 // Maximum allowed value for max-keys parameter
 const maxKeysLimit = 1000
 
+// maxKeysWithMetadata is the max-keys limit for listings with
+// metadata=true, whose entries are much larger
+const maxKeysWithMetadata = 100
+
+// maxDeleteObjects is the maximum number of keys in a DeleteObjects request
+const maxDeleteObjects = 1000
+
+// maxParts is the maximum number of parts in a multipart upload
+const maxParts = 10000
+
 // ErrInvalidMetadata is returned when metadata contains invalid characters
 var ErrInvalidMetadata = errors.New("invalid metadata")
 
 // drainRequestBody discards remaining request body to prevent connection hangs.
 // Should be called on error paths where the body may not have been fully consumed.
+// The body of a timed out or canceled request is left unread; the server
+// closes the connection instead. So is the body of a client still waiting
+// for 100 Continue, which would otherwise be asked to send the whole upload
+// only for it to be discarded.
 func drainRequestBody(r *http.Request) {
-	if r.Body != nil {
+	if r.Body != nil && r.Context().Err() == nil && !awaitingContinue(r) {
 		_, _ = io.Copy(io.Discard, r.Body)
 	}
 }
 
+// awaitingContinue reports whether the client sent Expect: 100-continue and
+// none of the body has been read, so net/http has not sent 100 Continue yet
+// and the client has not started sending the body. It relies on the body
+// counting of AccessLogMiddleware.
+func awaitingContinue(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		return false
+	}
+	cr, ok := r.Body.(*countingReader)
+	return ok && cr.bytesRead == 0
+}
+
 // isASCIIPrintable checks if a byte is ASCII printable (space through tilde)
 func isASCIIPrintable(c byte) bool {
 	return c >= 0x20 && c <= 0x7E
@@ -89,6 +121,32 @@ func extractAndValidateMetadata(headers http.Header) (map[string]string, error)
 	return userMetadata, nil
 }
 
+// maxMetadataHeaderValue is the longest metadata value returned as a
+// header. Longer values are stored in full, but many clients and proxies
+// reject header lines beyond about 8 KB, so they are left out of responses
+// rather than risking a failed or truncated response.
+const maxMetadataHeaderValue = 8 * 1024
+
+// missingMetaHeader reports the number of metadata entries not returned as
+// x-amz-meta-* headers
+const missingMetaHeader = "X-Amz-Missing-Meta"
+
+// setUserMetadataHeaders sets an x-amz-meta-* header for each user metadata
+// value, counting values too long to send in x-amz-missing-meta
+func setUserMetadataHeaders(w http.ResponseWriter, meta *s3.ObjectMetadata) {
+	missing := 0
+	for k, v := range meta.UserMetadata {
+		if len(v) > maxMetadataHeaderValue {
+			missing++
+			continue
+		}
+		w.Header().Set("x-amz-meta-"+k, v)
+	}
+	if missing > 0 {
+		w.Header().Set(missingMetaHeader, strconv.Itoa(missing))
+	}
+}
+
 // limitedReader wraps an io.Reader to enforce a maximum read size.
 // Returns an error when the limit is exceeded.
 type limitedReader struct {
@@ -118,18 +176,94 @@ func (l *limitedReader) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// errIncompleteBody is returned when a request body is shorter than the
+// number of bytes declared in Content-Length, or when the decoded payload of
+// an aws-chunked body differs from x-amz-decoded-content-length
+var errIncompleteBody = errors.New("request body length does not match the declared length")
+
+// isContextError reports whether a storage operation stopped because the
+// request timed out or the client went away
+func isContextError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// contentLengthReader fails with errIncompleteBody if the body ends early or
+// runs past its declared length
+type contentLengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// checkContentLength wraps the body of an upload so that a body that does
+// not match its declared length fails instead of being stored. A
+// fixed-length body is checked against Content-Length; the decoded payload
+// of an aws-chunked body, whose framing counts towards Content-Length, is
+// checked against x-amz-decoded-content-length when the client sent it.
+func checkContentLength(r *http.Request, body io.Reader) io.Reader {
+	length := r.ContentLength
+	if isAWSChunkedEncoding(r.Header.Get("Content-Encoding"), r.Header.Get("X-Amz-Content-Sha256")) {
+		decoded, err := strconv.ParseInt(r.Header.Get(decodedContentLengthHeader), 10, 64)
+		if err != nil {
+			return body
+		}
+		length = decoded
+	}
+	if length < 0 {
+		return body
+	}
+	return &contentLengthReader{r: body, remaining: length}
+}
+
+func (c *contentLengthReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining < 0 {
+		return n, errIncompleteBody
+	}
+	// net/http reports a body cut short by the client as io.ErrUnexpectedEOF
+	if (err == io.EOF && c.remaining > 0) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, errIncompleteBody
+	}
+	return n, err
+}
+
 // Handlers contains all S3 API handlers
 type Handlers struct {
 	cfg     *config.Config
 	storage storage.MultipartStorage
+
+	// tokenKey signs list continuation tokens
+	tokenKey []byte
+	// diskSpace caches free space readings for the write threshold
+	diskSpace diskSpaceCache
+	// activeDownloads and activeUploads count object transfers in
+	// progress, which a shutdown waits for up to the drain timeout
+	activeDownloads atomic.Int64
+	activeUploads   atomic.Int64
 }
 
 // NewHandlers creates a new Handlers instance
 func NewHandlers(cfg *config.Config, store storage.MultipartStorage) *Handlers {
 	return &Handlers{
-		cfg:     cfg,
-		storage: store,
+		cfg:      cfg,
+		storage:  store,
+		tokenKey: newContinuationTokenKey(cfg.ContinuationTokenKey()),
+	}
+}
+
+// owner returns the owner reported for buckets and objects: the configured
+// owner, or one derived from the request's credential. A derived ID is the
+// SHA-256 of the access key ID, shaped like an S3 canonical user ID.
+func (h *Handlers) owner(r *http.Request) s3.Owner {
+	if h.cfg.Owner.ID != "" {
+		return s3.Owner{ID: h.cfg.Owner.ID, DisplayName: h.cfg.Owner.DisplayName}
+	}
+	accessKeyID := "anonymous"
+	if cred := GetCredential(r); cred != nil {
+		accessKeyID = cred.AccessKeyID
 	}
+	sum := sha256.Sum256([]byte(accessKeyID))
+	return s3.Owner{ID: hex.EncodeToString(sum[:]), DisplayName: accessKeyID}
 }
 
 // validateBucketExists checks if the bucket exists
@@ -144,10 +278,138 @@ func (h *Handlers) validateBucketExists(bucket string) error {
 	return nil
 }
 
+// writeNoSuchKey reports a missing object in bucket. When auth.hide_not_found
+// is enabled, requests that are not authenticated or whose credential may not
+// access the bucket get AccessDenied instead, so that they cannot probe for
+// existing keys. Authorized callers still see NoSuchKey.
+func (h *Handlers) writeNoSuchKey(w http.ResponseWriter, r *http.Request, bucket string) {
+	if h.cfg.Auth.HideNotFound && !authorizedForBucket(r, bucket) {
+		s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+		return
+	}
+	s3.WriteErrorResponse(w, s3.ErrNoSuchKey)
+}
+
+// authorizedForBucket reports whether the request was authenticated with a
+// credential that may access bucket. Public reads are not authenticated.
+func authorizedForBucket(r *http.Request, bucket string) bool {
+	cred := GetCredential(r)
+	return cred != nil && cred.AccessKeyID != anonymousAccessKeyID && cred.CanAccessBucket(bucket)
+}
+
+// invalidKeyError returns the S3 error for a key rejected by storage
+// validation
+func invalidKeyError(err error) s3.ErrorCode {
+	if errors.Is(err, storage.ErrKeyTooLong) {
+		return s3.ErrKeyTooLong
+	}
+	return s3.ErrInvalidArgument
+}
+
+// rejectOverObjectLimit writes TooManyObjects and returns true if creating
+// key would exceed the bucket's object limit. Overwriting an existing key
+// does not add an object. The check is not atomic with the write, so
+// concurrent creates may overshoot the limit slightly.
+func (h *Handlers) rejectOverObjectLimit(w http.ResponseWriter, r *http.Request, bucket, key string) bool {
+	limit := h.cfg.Bucket.ObjectLimit(bucket)
+	if limit <= 0 {
+		return false
+	}
+
+	count, err := h.storage.CountObjects(bucket)
+	if err == nil && int64(count) >= limit {
+		var exists bool
+		if exists, err = h.storage.ObjectExists(bucket, key); err == nil && !exists {
+			s3.WriteErrorResponse(w, s3.ErrTooManyObjects)
+			return true
+		}
+	}
+	// Invalid keys are rejected by the write itself
+	if err != nil && !errors.Is(err, storage.ErrInvalidKey) {
+		slog.Error("failed to check bucket object limit", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return true
+	}
+	return false
+}
+
+// decodedContentLengthHeader is the size of the payload of an aws-chunked
+// upload, without the chunk signatures counted in Content-Length
+const decodedContentLengthHeader = "X-Amz-Decoded-Content-Length"
+
+// rejectOversizedBody writes EntityTooLarge and returns true if the declared
+// size of the upload exceeds limit, before any of the body is read. Bodies
+// without a declared size, or that are longer than declared, are stopped by
+// newLimitedReader while they are stored. A limit of 0 disables the check.
+func rejectOversizedBody(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	if limit <= 0 {
+		return false
+	}
+	size := r.ContentLength
+	if isAWSChunkedEncoding(r.Header.Get("Content-Encoding"), r.Header.Get("X-Amz-Content-Sha256")) {
+		decoded, err := strconv.ParseInt(r.Header.Get(decodedContentLengthHeader), 10, 64)
+		if err != nil {
+			return false
+		}
+		size = decoded
+	}
+	if size <= limit {
+		return false
+	}
+	// The body is not drained, so the server closes the connection after
+	// the response rather than reading the whole upload
+	s3.WriteErrorResponse(w, s3.ErrEntityTooLarge)
+	return true
+}
+
+// rejectOverBucketLimit writes TooManyBuckets and returns true if creating
+// bucket would exceed the bucket limit. Like the object limit, the check is
+// not atomic with the create.
+func (h *Handlers) rejectOverBucketLimit(w http.ResponseWriter, r *http.Request, bucket string) bool {
+	limit := h.cfg.Limits.MaxBuckets
+	if limit <= 0 {
+		return false
+	}
+
+	names, err := h.storage.BucketNames()
+	if err != nil {
+		slog.Error("failed to check bucket limit", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return true
+	}
+	// An existing bucket is reported as such by the create
+	if int64(len(names)) >= limit && !slices.Contains(names, bucket) {
+		s3.WriteErrorResponse(w, s3.ErrTooManyBuckets)
+		return true
+	}
+	return false
+}
+
 // CreateBucket handles PUT /{bucket}
 func (h *Handlers) CreateBucket(w http.ResponseWriter, r *http.Request) {
 	bucket := r.PathValue("bucket")
 
+	if rejectUnsupportedSubresource(w, r, unsupportedBucketSubresources) {
+		return
+	}
+
+	if r.URL.Query().Has("versioning") {
+		h.PutBucketVersioning(w, r)
+		return
+	}
+	if r.URL.Query().Has("lifecycle") {
+		h.PutBucketLifecycleConfiguration(w, r)
+		return
+	}
+	if r.URL.Query().Has("cors") {
+		h.PutBucketCors(w, r)
+		return
+	}
+
+	if h.rejectOverBucketLimit(w, r, bucket) {
+		return
+	}
+
 	err := h.storage.CreateBucket(bucket)
 	if err != nil {
 		if errors.Is(err, storage.ErrBucketAlreadyExists) {
@@ -165,14 +427,33 @@ func (h *Handlers) CreateBucket(w http.ResponseWriter, r *http.Request) {
 
 	metrics.BucketCreationsTotal.Inc()
 	metrics.BucketsTotal.Inc()
+	UpdateBucketUsageMetrics(h.storage, bucket)
 	w.WriteHeader(http.StatusOK)
 }
 
+// forceDeleteHeader requests deletion of a non-empty bucket
+const forceDeleteHeader = "X-Sss-Force"
+
 // DeleteBucket handles DELETE /{bucket}
 func (h *Handlers) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 	bucket := r.PathValue("bucket")
 
-	err := h.storage.DeleteBucket(bucket)
+	if rejectUnsupportedSubresource(w, r, unsupportedBucketSubresources) {
+		return
+	}
+
+	if r.URL.Query().Has("lifecycle") {
+		h.DeleteBucketLifecycle(w, r)
+		return
+	}
+	if r.URL.Query().Has("cors") {
+		h.DeleteBucketCors(w, r)
+		return
+	}
+
+	// x-sss-force: true removes a non-empty bucket with all its objects
+	force := r.Header.Get(forceDeleteHeader) == "true"
+	err := h.storage.DeleteBucketWithOptions(bucket, storage.DeleteBucketOptions{Force: force})
 	if err != nil {
 		if errors.Is(err, storage.ErrBucketNotFound) {
 			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
@@ -182,6 +463,10 @@ func (h *Handlers) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 			s3.WriteErrorResponse(w, s3.ErrBucketNotEmpty)
 			return
 		}
+		if errors.Is(err, storage.ErrObjectLocked) {
+			s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+			return
+		}
 		if errors.Is(err, storage.ErrInvalidBucketName) {
 			s3.WriteErrorResponse(w, s3.ErrInvalidBucketName)
 			return
@@ -191,8 +476,17 @@ func (h *Handlers) DeleteBucket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if force {
+		accessKeyID := ""
+		if cred := GetCredential(r); cred != nil {
+			accessKeyID = cred.AccessKeyID
+		}
+		slog.Warn("force deleted bucket", "bucket", bucket, "access_key_id", accessKeyID, "request_id", GetRequestID(r))
+	}
+
 	metrics.BucketDeletionsTotal.Inc()
 	metrics.BucketsTotal.Dec()
+	UpdateBucketUsageMetrics(h.storage, bucket)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -206,6 +500,29 @@ func (h *Handlers) HeadBucket(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// GetBucketLocation handles GET /{bucket}?location. As in S3, the location
+// is empty for us-east-1. A server accepting any region reports us-east-1.
+func (h *Handlers) GetBucketLocation(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	if err := h.validateBucketExists(bucket); err != nil {
+		s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+		return
+	}
+
+	location := h.cfg.Server.Region
+	if location == "*" || location == config.DefaultRegion {
+		location = ""
+	}
+	result := s3.LocationConstraint{
+		Xmlns:    "http://s3.amazonaws.com/doc/2006-03-01/",
+		Location: location,
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
 // PutObject handles PUT /{bucket}/{key...}
 func (h *Handlers) PutObject(w http.ResponseWriter, r *http.Request) {
 	bucket := r.PathValue("bucket")
@@ -218,6 +535,10 @@ func (h *Handlers) PutObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rejectUnsupportedSubresource(w, r, unsupportedObjectSubresources) {
+		return
+	}
+
 	if err := h.validateBucketExists(bucket); err != nil {
 		s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
 		return
@@ -230,6 +551,15 @@ func (h *Handlers) PutObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if query.Has("legal-hold") {
+		h.PutObjectLegalHold(w, r)
+		return
+	}
+
+	if h.rejectLowDiskSpace(w, r) {
+		return
+	}
+
 	// Check for copy operation
 	copySource := r.Header.Get("X-Amz-Copy-Source")
 	if copySource != "" {
@@ -237,9 +567,16 @@ func (h *Handlers) PutObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rejectOversizedBody(w, r, h.cfg.Limits.MaxObjectSize) {
+		return
+	}
+
+	if h.rejectOverObjectLimit(w, r, bucket, key) {
+		return
+	}
+
 	// Track active upload
-	metrics.UploadsActive.Inc()
-	defer metrics.UploadsActive.Dec()
+	defer h.trackUpload()()
 
 	// Regular put object
 	contentType := r.Header.Get("Content-Type")
@@ -254,31 +591,65 @@ func (h *Handlers) PutObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sse, err := parseServerSideEncryption(r.Header.Get(serverSideEncryptionHeader))
+	if err != nil {
+		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+		return
+	}
+
+	tags, err := parseTagging(r.Header.Get(taggingHeader))
+	if err != nil {
+		s3.WriteErrorResponse(w, s3.ErrInvalidTag)
+		return
+	}
+
 	// Handle AWS chunked encoding (used by Minio SDK and some AWS SDK configurations)
-	var body io.Reader = wrapBodyIfChunked(r.Body, r.Header.Get("Content-Encoding"), r.Header.Get("X-Amz-Content-Sha256"), h.cfg.Limits.MaxChunkSize)
+	var body io.Reader = wrapBodyIfChunked(r.Body, r.Header.Get("Content-Encoding"), r.Header.Get("X-Amz-Content-Sha256"), h.cfg.Limits.MaxChunkSize, getChunkVerifier(r))
+	body = checkContentLength(r, body)
 
 	// Enforce maximum object size limit
 	if h.cfg.Limits.MaxObjectSize > 0 {
 		body = newLimitedReader(body, h.cfg.Limits.MaxObjectSize)
 	}
 
-	meta, err := h.storage.PutObject(bucket, key, contentType, userMetadata, body)
+	meta, err := h.storage.PutObjectWithOptions(r.Context(), bucket, key, contentType, userMetadata, storage.PutObjectOptions{ServerSideEncryption: sse, Tags: tags}, body)
 	if err != nil {
 		drainRequestBody(r)
 		if errors.Is(err, storage.ErrEntityTooLarge) {
 			s3.WriteErrorResponse(w, s3.ErrEntityTooLarge)
 			return
 		}
+		if errors.Is(err, errIncompleteBody) {
+			s3.WriteErrorResponse(w, s3.ErrIncompleteBody)
+			return
+		}
 		if errors.Is(err, storage.ErrInvalidKey) {
-			s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+			s3.WriteErrorResponse(w, invalidKeyError(err))
+			return
+		}
+		if errors.Is(err, storage.ErrObjectLocked) {
+			s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+			return
+		}
+		if errors.Is(err, auth.ErrChunkSignatureMismatch) {
+			s3.WriteErrorResponse(w, s3.ErrSignatureDoesNotMatch)
+			return
+		}
+		if isContextError(err) {
+			s3.WriteErrorResponse(w, s3.ErrRequestTimeout)
 			return
 		}
 		slog.Error("failed to put object", "error", err, "bucket", bucket, "key", key, "request_id", GetRequestID(r))
 		s3.WriteErrorResponse(w, s3.ErrInternalError)
 		return
 	}
+	metrics.ObjectSizeBytes.WithLabelValues(metrics.OpPutObject).Observe(float64(meta.Size))
+	UpdateBucketUsageMetrics(h.storage, bucket)
+	auditObjectChange(r, key, meta)
 
 	w.Header().Set("ETag", meta.ETag)
+	setServerSideEncryptionHeader(w, meta)
+	setVersionIDHeader(w, meta)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -308,17 +679,114 @@ func (h *Handlers) CopyObject(w http.ResponseWriter, r *http.Request) {
 	srcBucket := parts[0]
 	srcKey := parts[1]
 
+	// The route only checks the destination bucket against the credential
+	if cred := GetCredential(r); cred != nil && !cred.CanAccessBucket(srcBucket) {
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonAccessDenied).Inc()
+		s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+		return
+	}
+
 	// Validate source bucket exists
 	if err := h.validateBucketExists(srcBucket); err != nil {
 		s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
 		return
 	}
 
-	// Copy the object
-	meta, err := h.storage.CopyObject(srcBucket, srcKey, dstBucket, dstKey)
+	// Evaluate x-amz-copy-source-if-* conditions against the source object
+	conds, err := parseCopySourceConditions(r.Header)
+	if err != nil {
+		s3.WriteErrorResponse(w, s3.ErrInvalidRequest)
+		return
+	}
+
+	srcMeta, err := h.storage.HeadObject(srcBucket, srcKey)
 	if err != nil {
 		if errors.Is(err, storage.ErrObjectNotFound) {
-			s3.WriteErrorResponse(w, s3.ErrNoSuchKey)
+			h.writeNoSuchKey(w, r, srcBucket)
+			return
+		}
+		slog.Error("failed to head copy source", "error", err, "src_bucket", srcBucket, "src_key", srcKey, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return
+	}
+
+	// A copy has no 304 response; every unmet condition is a failed precondition
+	if conds.evaluate(srcMeta) != conditionPassed {
+		s3.WriteErrorResponse(w, s3.ErrPreconditionFailed)
+		return
+	}
+
+	// Metadata and tags are copied from the source unless their directive
+	// is REPLACE, in which case they are taken from this request
+	var opts storage.CopyObjectOptions
+	if opts.ReplaceMetadata, err = parseCopyDirective(r.Header.Get(metadataDirectiveHeader)); err != nil {
+		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+		return
+	}
+	if opts.ReplaceMetadata {
+		opts.ContentType = r.Header.Get("Content-Type")
+		if opts.ContentType == "" {
+			opts.ContentType = "application/octet-stream"
+		}
+		if opts.Metadata, err = extractAndValidateMetadata(r.Header); err != nil {
+			s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+			return
+		}
+	}
+	if opts.ReplaceTags, err = parseCopyDirective(r.Header.Get(taggingDirectiveHeader)); err != nil {
+		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+		return
+	}
+	if opts.ReplaceTags {
+		if opts.Tags, err = parseTagging(r.Header.Get(taggingHeader)); err != nil {
+			s3.WriteErrorResponse(w, s3.ErrInvalidTag)
+			return
+		}
+	}
+	// The copy keeps the source's encryption unless the request sets one
+	if opts.ServerSideEncryption, err = parseServerSideEncryption(r.Header.Get(serverSideEncryptionHeader)); err != nil {
+		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+		return
+	}
+
+	// Copying an object onto itself is how its metadata is updated, and is
+	// pointless without a change
+	if srcBucket == dstBucket && srcKey == dstKey && !opts.ReplaceMetadata && !opts.ReplaceTags && opts.ServerSideEncryption == "" {
+		s3.WriteErrorResponseWithMessage(w, s3.ErrInvalidRequest, "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata.")
+		return
+	}
+
+	if h.rejectOverObjectLimit(w, r, dstBucket, dstKey) {
+		return
+	}
+
+	var meta *s3.ObjectMetadata
+	if updater, ok := h.inPlaceUpdater(srcBucket, srcKey, dstBucket, dstKey, opts); ok {
+		// Replacing the metadata of an object leaves its data in place
+		contentType, metadata, tags := srcMeta.ContentType, srcMeta.UserMetadata, srcMeta.Tags
+		if opts.ReplaceMetadata {
+			contentType, metadata = opts.ContentType, opts.Metadata
+		}
+		if opts.ReplaceTags {
+			tags = opts.Tags
+		}
+		meta, err = updater.UpdateMetadata(dstBucket, dstKey, contentType, metadata, tags)
+	} else {
+		// Copy the object, which writes its data like an upload
+		defer h.trackUpload()()
+		meta, err = h.storage.CopyObjectWithOptions(r.Context(), srcBucket, srcKey, dstBucket, dstKey, opts)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			h.writeNoSuchKey(w, r, srcBucket)
+			return
+		}
+		if errors.Is(err, storage.ErrObjectLocked) {
+			s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+			return
+		}
+		if isContextError(err) {
+			s3.WriteErrorResponse(w, s3.ErrRequestTimeout)
 			return
 		}
 		slog.Error("failed to copy object", "error", err, "src_bucket", srcBucket, "src_key", srcKey, "dst_bucket", dstBucket, "dst_key", dstKey, "request_id", GetRequestID(r))
@@ -326,16 +794,96 @@ func (h *Handlers) CopyObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	UpdateBucketUsageMetrics(h.storage, dstBucket)
+	auditObjectChange(r, dstKey, meta)
+
 	result := s3.CopyObjectResult{
 		ETag:         meta.ETag,
 		LastModified: meta.LastModified,
 	}
 
 	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Last-Modified", meta.LastModified.UTC().Format(http.TimeFormat))
+	setVersionIDHeader(w, meta)
+	setServerSideEncryptionHeader(w, meta)
 	w.WriteHeader(http.StatusOK)
 	_ = xml.NewEncoder(w).Encode(result)
 }
 
+// inPlaceUpdater returns the storage to update the metadata of an object
+// with, when a copy replaces the metadata of the object onto itself. A
+// versioned bucket keeps the previous version, so the copy is a real one. A
+// change of encryption is left to the storage's own copy.
+func (h *Handlers) inPlaceUpdater(srcBucket, srcKey, dstBucket, dstKey string, opts storage.CopyObjectOptions) (storage.MetadataUpdater, bool) {
+	if srcBucket != dstBucket || srcKey != dstKey || !opts.ReplaceMetadata || opts.ServerSideEncryption != "" {
+		return nil, false
+	}
+	updater, ok := h.storage.(storage.MetadataUpdater)
+	if !ok {
+		return nil, false
+	}
+	if status, err := h.storage.GetBucketVersioning(dstBucket); err != nil || status != "" {
+		return nil, false
+	}
+	return updater, true
+}
+
+// createdHeader reports when an object's key was first written. Unlike
+// Last-Modified it is kept when the object is overwritten.
+const createdHeader = "X-Sss-Created"
+
+// setCreatedHeader sets the creation time of an object, if it was recorded
+func setCreatedHeader(w http.ResponseWriter, meta *s3.ObjectMetadata) {
+	if !meta.Created.IsZero() {
+		w.Header().Set(createdHeader, meta.Created.UTC().Format(http.TimeFormat))
+	}
+}
+
+// setObjectHeaders sets the headers describing an object that every GET and
+// HEAD of it returns, ranged or not
+func setObjectHeaders(w http.ResponseWriter, meta *s3.ObjectMetadata) {
+	w.Header().Set("Content-Type", meta.ContentType)
+	setETagHeader(w, meta)
+	w.Header().Set("Last-Modified", meta.LastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	setUserMetadataHeaders(w, meta)
+
+	setObjectLockHeaders(w, meta)
+	setServerSideEncryptionHeader(w, meta)
+	setTaggingCountHeader(w, meta)
+	setCreatedHeader(w, meta)
+	setVersionIDHeader(w, meta)
+	setPartsCountHeader(w, meta)
+}
+
+// trackDownload counts a download as active until the returned function is
+// called
+func (h *Handlers) trackDownload() func() {
+	metrics.DownloadsActive.Inc()
+	h.activeDownloads.Add(1)
+	return func() {
+		metrics.DownloadsActive.Dec()
+		h.activeDownloads.Add(-1)
+	}
+}
+
+// transferring reports whether any upload or download is in progress
+func (h *Handlers) transferring() bool {
+	return h.activeUploads.Load() > 0 || h.activeDownloads.Load() > 0
+}
+
+// trackUpload counts a write of object data as an active upload until the
+// returned function is called
+func (h *Handlers) trackUpload() func() {
+	metrics.UploadsActive.Inc()
+	h.activeUploads.Add(1)
+	return func() {
+		metrics.UploadsActive.Dec()
+		h.activeUploads.Add(-1)
+	}
+}
+
 // GetObject handles GET /{bucket}/{key...}
 func (h *Handlers) GetObject(w http.ResponseWriter, r *http.Request) {
 	bucket := r.PathValue("bucket")
@@ -348,26 +896,43 @@ func (h *Handlers) GetObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rejectUnsupportedSubresource(w, r, unsupportedObjectSubresources) {
+		return
+	}
+
 	if err := h.validateBucketExists(bucket); err != nil {
 		s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
 		return
 	}
 
+	if r.URL.Query().Has("legal-hold") {
+		h.GetObjectLegalHold(w, r)
+		return
+	}
+
 	// Check for Range header
 	rangeHeader := r.Header.Get("Range")
-	if rangeHeader != "" {
-		h.GetObjectRange(w, r)
+
+	// S3 does not allow a part number and a range in the same request
+	if rangeHeader != "" && r.URL.Query().Has("partNumber") {
+		s3.WriteErrorResponse(w, s3.ErrInvalidRequest)
+		return
+	}
+
+	if r.URL.Query().Has("versionId") {
+		h.getObjectVersion(w, r)
 		return
 	}
 
 	// Track active download
-	metrics.DownloadsActive.Inc()
-	defer metrics.DownloadsActive.Dec()
+	defer h.trackDownload()()
 
-	reader, meta, err := h.storage.GetObject(bucket, key)
+	// The metadata and the data file are read in one pass, and both the
+	// full and the ranged response are served from them
+	reader, meta, err := h.storage.OpenObject(bucket, key)
 	if err != nil {
 		if errors.Is(err, storage.ErrObjectNotFound) {
-			s3.WriteErrorResponse(w, s3.ErrNoSuchKey)
+			h.writeNoSuchKey(w, r, bucket)
 			return
 		}
 		slog.Error("failed to get object", "error", err, "bucket", bucket, "key", key, "request_id", GetRequestID(r))
@@ -376,105 +941,164 @@ func (h *Handlers) GetObject(w http.ResponseWriter, r *http.Request) {
 	}
 	defer reader.Close()
 
-	// Set response headers
-	w.Header().Set("Content-Type", meta.ContentType)
-	w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
-	w.Header().Set("ETag", meta.ETag)
-	w.Header().Set("Last-Modified", meta.LastModified.UTC().Format(http.TimeFormat))
-	w.Header().Set("Accept-Ranges", "bytes")
-
-	// Set user metadata headers
-	for k, v := range meta.UserMetadata {
-		w.Header().Set("x-amz-meta-"+k, v)
+	// A stale If-Range validator means the client's partial copy is outdated,
+	// so the whole object is sent instead of the range
+	if rangeHeader != "" && !ifRangeMatches(r.Header.Get("If-Range"), meta) {
+		r.Header.Del("Range")
+		rangeHeader = ""
 	}
 
-	// Apply response header overrides for presigned URLs
-	applyResponseHeaderOverrides(w, r)
+	if rangeHeader != "" {
+		h.serveObjectRange(w, r, reader, meta)
+		return
+	}
+	if r.URL.Query().Has("partNumber") {
+		serveObjectPart(w, r, reader, meta)
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
-	_, _ = io.Copy(w, reader)
+	serveObject(w, r, reader, meta)
 }
 
-// GetObjectRange handles GET with Range header
-func (h *Handlers) GetObjectRange(w http.ResponseWriter, r *http.Request) {
-	// Track active download
-	metrics.DownloadsActive.Inc()
-	defer metrics.DownloadsActive.Dec()
+// serveObject evaluates the request's conditions and writes the object
+func serveObject(w http.ResponseWriter, r *http.Request, reader io.ReadSeeker, meta *s3.ObjectMetadata) {
+	// Evaluate If-Match, If-None-Match, If-Modified-Since and If-Unmodified-Since
+	if writeConditionResult(w, parseConditions(r.Header).evaluate(meta), meta) {
+		return
+	}
 
-	// Note: bucket validation is done in GetObject before calling this handler
-	bucket := r.PathValue("bucket")
-	key := r.PathValue("key")
+	// Set response headers; Content-Length is set by http.ServeContent
+	setObjectHeaders(w, meta)
 
-	rangeHeader := r.Header.Get("Range")
+	// Apply response header overrides for presigned URLs
+	applyResponseHeaderOverrides(w, r)
 
-	// Parse range header: bytes=start-end or bytes=start- or bytes=-suffix
-	start, end, err := parseRangeHeader(rangeHeader)
-	if err != nil {
-		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
-		return
+	// Conditions were evaluated above against the stored metadata, so they
+	// are removed before ServeContent, which would otherwise evaluate them
+	// again with its own rules: a strong If-Match against a weak ETag always
+	// fails there. The zero modtime keeps it from comparing dates against the
+	// data file. It copies the file with sendfile where possible.
+	for _, header := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		r.Header.Del(header)
 	}
+	http.ServeContent(w, r, "", time.Time{}, reader)
+}
 
-	// Get object metadata first to validate range
-	meta, err := h.storage.HeadObject(bucket, key)
+// serveObjectRange writes the ranges of an open object requested by the
+// Range header of a GET
+func (h *Handlers) serveObjectRange(w http.ResponseWriter, r *http.Request, reader io.ReadSeeker, meta *s3.ObjectMetadata) {
+	// Parse range header: bytes=start-end or bytes=start- or bytes=-suffix,
+	// optionally several separated by commas
+	specs, err := parseRangeHeader(r.Header.Get("Range"))
 	if err != nil {
-		if errors.Is(err, storage.ErrObjectNotFound) {
-			s3.WriteErrorResponse(w, s3.ErrNoSuchKey)
-			return
-		}
-		slog.Error("failed to get object metadata for range request", "error", err, "bucket", bucket, "key", key, "request_id", GetRequestID(r))
-		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
 		return
 	}
 
-	// Handle suffix range (bytes=-N means last N bytes)
-	if start < 0 {
-		start = meta.Size + start
-		if start < 0 {
-			start = 0
-		}
-		end = meta.Size - 1
+	if writeConditionResult(w, parseConditions(r.Header).evaluate(meta), meta) {
+		return
 	}
 
-	// Handle open-ended range (bytes=N-)
-	if end < 0 || end >= meta.Size {
-		end = meta.Size - 1
+	// Unsatisfiable ranges are dropped; the request fails if none remain
+	ranges, whole := resolveRanges(specs, meta.Size)
+	if whole {
+		r.Header.Del("Range")
+		serveObject(w, r, reader, meta)
+		return
 	}
-
-	// Validate range
-	if start > end || start >= meta.Size {
+	if len(ranges) == 0 {
 		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", meta.Size))
 		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
 		return
 	}
+	if len(ranges) > 1 {
+		writeByteRanges(w, r, reader, meta, ranges)
+		return
+	}
+	start, end := ranges[0].start, ranges[0].end
 
-	reader, _, err := h.storage.GetObjectRange(bucket, key, start, end)
-	if err != nil {
-		slog.Error("failed to get object range", "error", err, "bucket", bucket, "key", key, "start", start, "end", end, "request_id", GetRequestID(r))
+	if _, err := reader.Seek(start, io.SeekStart); err != nil {
+		slog.Error("failed to seek object for range request", "error", err, "bucket", r.PathValue("bucket"), "key", r.PathValue("key"), "start", start, "request_id", GetRequestID(r))
 		s3.WriteErrorResponse(w, s3.ErrInternalError)
 		return
 	}
-	defer reader.Close()
 
 	contentLength := end - start + 1
 
 	// Set response headers
-	w.Header().Set("Content-Type", meta.ContentType)
+	setObjectHeaders(w, meta)
 	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
 	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, meta.Size))
-	w.Header().Set("ETag", meta.ETag)
-	w.Header().Set("Last-Modified", meta.LastModified.UTC().Format(http.TimeFormat))
-	w.Header().Set("Accept-Ranges", "bytes")
 
-	// Set user metadata headers
-	for k, v := range meta.UserMetadata {
-		w.Header().Set("x-amz-meta-"+k, v)
+	// Apply response header overrides for presigned URLs
+	applyResponseHeaderOverrides(w, r)
+
+	w.WriteHeader(http.StatusPartialContent)
+	_, _ = io.CopyN(w, reader, contentLength)
+}
+
+// partsCountHeader reports the number of parts of a multipart object
+const partsCountHeader = "X-Amz-Mp-Parts-Count"
+
+// setPartsCountHeader reports the number of parts of an object written by a
+// multipart upload. It is left out when the part sizes were not recorded,
+// since the parts of such an object cannot be read with partNumber.
+func setPartsCountHeader(w http.ResponseWriter, meta *s3.ObjectMetadata) {
+	if len(meta.PartSizes) > 0 {
+		w.Header().Set(partsCountHeader, strconv.Itoa(len(meta.PartSizes)))
+	}
+}
+
+// serveObjectPart writes the part of an open object selected by the
+// partNumber query parameter of a GET. An object uploaded in one piece, or
+// completed before its part sizes were recorded, is its own only part.
+func serveObjectPart(w http.ResponseWriter, r *http.Request, reader io.ReadSeeker, meta *s3.ObjectMetadata) {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxParts {
+		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+		return
+	}
+	parts := meta.PartSizes
+	if len(parts) == 0 {
+		if partNumber != 1 {
+			s3.WriteErrorResponse(w, s3.ErrInvalidPartNumber)
+			return
+		}
+		serveObject(w, r, reader, meta)
+		return
+	}
+	if partNumber > len(parts) {
+		s3.WriteErrorResponse(w, s3.ErrInvalidPartNumber)
+		return
+	}
+
+	if writeConditionResult(w, parseConditions(r.Header).evaluate(meta), meta) {
+		return
+	}
+
+	// The part starts where the parts before it end in the data file
+	var start int64
+	for _, size := range parts[:partNumber-1] {
+		start += size
+	}
+	contentLength := parts[partNumber-1]
+
+	if _, err := reader.Seek(start, io.SeekStart); err != nil {
+		slog.Error("failed to seek object for part request", "error", err, "bucket", r.PathValue("bucket"), "key", r.PathValue("key"), "part_number", partNumber, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return
+	}
+
+	setObjectHeaders(w, meta)
+	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	if contentLength > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+contentLength-1, meta.Size))
 	}
 
-	// Apply response header overrides for presigned URLs
 	applyResponseHeaderOverrides(w, r)
 
 	w.WriteHeader(http.StatusPartialContent)
-	_, _ = io.Copy(w, reader)
+	_, _ = io.CopyN(w, reader, contentLength)
 }
 
 // applyResponseHeaderOverrides applies response header overrides from presigned URL query parameters.
@@ -504,21 +1128,36 @@ func applyResponseHeaderOverrides(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// parseRangeHeader parses a Range header value
-// Returns start, end (-1 means unspecified)
-// Validates that values are within safe bounds to prevent integer overflow
-func parseRangeHeader(rangeHeader string) (start, end int64, err error) {
+// parseRangeHeader parses a Range header value into one or more ranges
+// Rejects headers with more than maxRanges ranges
+func parseRangeHeader(rangeHeader string) ([]byteRange, error) {
 	if !strings.HasPrefix(rangeHeader, "bytes=") {
-		return 0, 0, fmt.Errorf("invalid range header: must start with 'bytes='")
+		return nil, fmt.Errorf("invalid range header: must start with 'bytes='")
 	}
 
-	rangeSpec := strings.TrimPrefix(rangeHeader, "bytes=")
+	specs := strings.Split(strings.TrimPrefix(rangeHeader, "bytes="), ",")
+	if len(specs) > maxRanges {
+		return nil, fmt.Errorf("invalid range: more than %d ranges", maxRanges)
+	}
 
-	// Reject multiple ranges (not supported)
-	if strings.Contains(rangeSpec, ",") {
-		return 0, 0, fmt.Errorf("invalid range: multiple ranges not supported")
+	ranges := make([]byteRange, 0, len(specs))
+	for _, spec := range specs {
+		if len(specs) > 1 {
+			spec = strings.TrimSpace(spec)
+		}
+		start, end, err := parseRangeSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
 	}
+	return ranges, nil
+}
 
+// parseRangeSpec parses a single range of a Range header
+// Returns start, end (-1 means unspecified)
+// Validates that values are within safe bounds to prevent integer overflow
+func parseRangeSpec(rangeSpec string) (start, end int64, err error) {
 	parts := strings.Split(rangeSpec, "-")
 	if len(parts) != 2 {
 		return 0, 0, fmt.Errorf("invalid range format")
@@ -590,10 +1229,21 @@ func (h *Handlers) HeadObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	meta, err := h.storage.HeadObject(bucket, key)
+	// Keys are flat: a key ending in "/" only exists if a marker object was
+	// stored under it, regardless of any objects below that prefix
+	var meta *s3.ObjectMetadata
+	var err error
+	if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+		meta, err = h.storage.HeadObjectVersion(bucket, key, versionID)
+	} else {
+		meta, err = h.storage.HeadObject(bucket, key)
+	}
 	if err != nil {
 		if errors.Is(err, storage.ErrObjectNotFound) {
-			s3.WriteErrorResponse(w, s3.ErrNoSuchKey)
+			h.writeNoSuchKey(w, r, bucket)
+			return
+		}
+		if writeVersionError(w, err) {
 			return
 		}
 		slog.Error("failed to head object", "error", err, "bucket", bucket, "key", key, "request_id", GetRequestID(r))
@@ -601,18 +1251,54 @@ func (h *Handlers) HeadObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A delete marker has no data to describe
+	if meta.DeleteMarker {
+		setVersionIDHeader(w, meta)
+		w.Header().Set(deleteMarkerHeader, "true")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if writeConditionResult(w, parseConditions(r.Header).evaluate(meta), meta) {
+		return
+	}
+
+	// A Range header is resolved as in a ranged GetObject, so clients can probe
+	// what a ranged GET would return
+	var ranges []byteRange
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && ifRangeMatches(r.Header.Get("If-Range"), meta) {
+		specs, err := parseRangeHeader(rangeHeader)
+		if err != nil {
+			s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+			return
+		}
+		var whole bool
+		ranges, whole = resolveRanges(specs, meta.Size)
+		if len(ranges) == 0 && !whole {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", meta.Size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+
 	// Set response headers
-	w.Header().Set("Content-Type", meta.ContentType)
+	setObjectHeaders(w, meta)
 	w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
-	w.Header().Set("ETag", meta.ETag)
-	w.Header().Set("Last-Modified", meta.LastModified.UTC().Format(http.TimeFormat))
 
-	// Set user metadata headers
-	for k, v := range meta.UserMetadata {
-		w.Header().Set("x-amz-meta-"+k, v)
+	switch len(ranges) {
+	case 0:
+		w.WriteHeader(http.StatusOK)
+	case 1:
+		start, end := ranges[0].start, ranges[0].end
+		w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, meta.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	default:
+		boundary, contentLength := byteRangesLength(ranges, meta.ContentType, meta.Size)
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+		w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+		w.WriteHeader(http.StatusPartialContent)
 	}
-
-	w.WriteHeader(http.StatusOK)
 }
 
 // DeleteObject handles DELETE /{bucket}/{key...}
@@ -627,6 +1313,10 @@ func (h *Handlers) DeleteObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rejectUnsupportedSubresource(w, r, unsupportedObjectSubresources) {
+		return
+	}
+
 	if err := h.validateBucketExists(bucket); err != nil {
 		s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
 		return
@@ -639,13 +1329,30 @@ func (h *Handlers) DeleteObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.storage.DeleteObject(bucket, key)
+	// Without a version ID, a versioned bucket keeps the object behind a
+	// delete marker
+	deleted, err := h.storage.DeleteObjectVersion(bucket, key, query.Get("versionId"))
 	if err != nil {
+		if errors.Is(err, storage.ErrObjectLocked) {
+			s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+			return
+		}
+		if writeVersionError(w, err) {
+			return
+		}
 		slog.Error("failed to delete object", "error", err, "bucket", bucket, "key", key, "request_id", GetRequestID(r))
 		s3.WriteErrorResponse(w, s3.ErrInternalError)
 		return
 	}
+	UpdateBucketUsageMetrics(h.storage, bucket)
+	auditObjectChange(r, key, nil)
 
+	if deleted != nil {
+		setVersionIDHeader(w, deleted)
+		if deleted.DeleteMarker {
+			w.Header().Set(deleteMarkerHeader, "true")
+		}
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -659,6 +1366,10 @@ func (h *Handlers) CreateMultipartUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if h.rejectLowDiskSpace(w, r) {
+		return
+	}
+
 	contentType := r.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = "application/octet-stream"
@@ -671,10 +1382,14 @@ func (h *Handlers) CreateMultipartUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if h.rejectOverObjectLimit(w, r, bucket, key) {
+		return
+	}
+
 	uploadID, err := h.storage.CreateMultipartUpload(bucket, key, contentType, userMetadata)
 	if err != nil {
 		if errors.Is(err, storage.ErrInvalidKey) {
-			s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+			s3.WriteErrorResponse(w, invalidKeyError(err))
 			return
 		}
 		slog.Error("failed to create multipart upload", "error", err, "bucket", bucket, "key", key, "request_id", GetRequestID(r))
@@ -704,6 +1419,10 @@ func (h *Handlers) UploadPart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.rejectLowDiskSpace(w, r) {
+		return
+	}
+
 	query := r.URL.Query()
 	uploadID := query.Get("uploadId")
 	partNumberStr := query.Get("partNumber")
@@ -714,14 +1433,15 @@ func (h *Handlers) UploadPart(w http.ResponseWriter, r *http.Request) {
 	}
 
 	partNumber, err := strconv.Atoi(partNumberStr)
-	if err != nil || partNumber < 1 || partNumber > 10000 {
+	if err != nil || partNumber < 1 || partNumber > maxParts {
 		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
 		return
 	}
 
-	// Verify upload exists and key matches
+	// Verify upload exists and key matches. An upload in another bucket
+	// does not exist as far as this bucket is concerned.
 	uploadMeta, err := h.storage.GetMultipartUpload(uploadID)
-	if err != nil {
+	if err != nil || uploadMeta.Bucket != bucket {
 		s3.WriteErrorResponse(w, s3.ErrNoSuchUpload)
 		return
 	}
@@ -731,29 +1451,45 @@ func (h *Handlers) UploadPart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rejectOversizedBody(w, r, h.cfg.Limits.MaxPartSize) {
+		return
+	}
+
 	// Track active upload
-	metrics.UploadsActive.Inc()
-	defer metrics.UploadsActive.Dec()
+	defer h.trackUpload()()
 
 	// Handle AWS chunked encoding
-	var body io.Reader = wrapBodyIfChunked(r.Body, r.Header.Get("Content-Encoding"), r.Header.Get("X-Amz-Content-Sha256"), h.cfg.Limits.MaxChunkSize)
+	var body io.Reader = wrapBodyIfChunked(r.Body, r.Header.Get("Content-Encoding"), r.Header.Get("X-Amz-Content-Sha256"), h.cfg.Limits.MaxChunkSize, getChunkVerifier(r))
+	body = checkContentLength(r, body)
 
 	// Enforce maximum part size limit
 	if h.cfg.Limits.MaxPartSize > 0 {
 		body = newLimitedReader(body, h.cfg.Limits.MaxPartSize)
 	}
 
-	partMeta, err := h.storage.UploadPart(uploadID, partNumber, body)
+	partMeta, err := h.storage.UploadPart(r.Context(), uploadID, partNumber, body)
 	if err != nil {
 		drainRequestBody(r)
 		if errors.Is(err, storage.ErrEntityTooLarge) {
 			s3.WriteErrorResponse(w, s3.ErrEntityTooLarge)
 			return
 		}
+		if errors.Is(err, errIncompleteBody) {
+			s3.WriteErrorResponse(w, s3.ErrIncompleteBody)
+			return
+		}
 		if errors.Is(err, storage.ErrUploadNotFound) {
 			s3.WriteErrorResponse(w, s3.ErrNoSuchUpload)
 			return
 		}
+		if errors.Is(err, auth.ErrChunkSignatureMismatch) {
+			s3.WriteErrorResponse(w, s3.ErrSignatureDoesNotMatch)
+			return
+		}
+		if isContextError(err) {
+			s3.WriteErrorResponse(w, s3.ErrRequestTimeout)
+			return
+		}
 		slog.Error("failed to upload part", "error", err, "bucket", bucket, "key", key, "upload_id", uploadID, "part_number", partNumber, "request_id", GetRequestID(r))
 		s3.WriteErrorResponse(w, s3.ErrInternalError)
 		return
@@ -763,6 +1499,18 @@ func (h *Handlers) UploadPart(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// uploadCreatedBeforeHeader makes CompleteMultipartUpload fail with
+// NoSuchUpload unless the upload was created before the given time
+const uploadCreatedBeforeHeader = "X-Sss-Upload-Created-Before"
+
+// parseUploadCreatedBefore parses an HTTP date or RFC 3339 timestamp
+func parseUploadCreatedBefore(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return http.ParseTime(value)
+}
+
 // CompleteMultipartUpload handles POST /{bucket}/{key}?uploadId=X
 func (h *Handlers) CompleteMultipartUpload(w http.ResponseWriter, r *http.Request) {
 	bucket := r.PathValue("bucket")
@@ -781,9 +1529,10 @@ func (h *Handlers) CompleteMultipartUpload(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Verify upload exists and key matches
+	// Verify upload exists and key matches. An upload in another bucket
+	// does not exist as far as this bucket is concerned.
 	uploadMeta, err := h.storage.GetMultipartUpload(uploadID)
-	if err != nil {
+	if err != nil || uploadMeta.Bucket != bucket {
 		s3.WriteErrorResponse(w, s3.ErrNoSuchUpload)
 		return
 	}
@@ -793,18 +1542,38 @@ func (h *Handlers) CompleteMultipartUpload(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// A client resuming an upload can require that it was created before a
+	// point in time, so that it never completes an upload started by
+	// another session
+	if v := r.Header.Get(uploadCreatedBeforeHeader); v != "" {
+		createdBefore, err := parseUploadCreatedBefore(v)
+		if err != nil {
+			s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+			return
+		}
+		if !uploadMeta.Created.Before(createdBefore) {
+			s3.WriteErrorResponse(w, s3.ErrNoSuchUpload)
+			return
+		}
+	}
+
 	// Parse request body with size limit to prevent XML bomb attacks
-	// Limit to 1MB which is more than enough for 10,000 parts
+	// Limit to 1MB which is more than enough for 10,000 parts, and stop
+	// decoding past 10,000 parts, so that a body of tiny parts cannot
+	// allocate more
 	const maxXMLBodySize = 1 * 1024 * 1024
-	limitedBody := io.LimitReader(r.Body, maxXMLBodySize)
 	var completeReq s3.CompleteMultipartUpload
-	if err := xml.NewDecoder(limitedBody).Decode(&completeReq); err != nil {
+	if err := decodeXMLItems(r.Body, maxXMLBodySize, maxParts, &completeReq); err != nil {
 		s3.WriteErrorResponse(w, s3.ErrMalformedXML)
 		return
 	}
 
+	// Assembling the parts of a large object takes a while, so a shutdown
+	// waits for it like for other uploads
+	defer h.trackUpload()()
+
 	// Complete the upload
-	objMeta, err := h.storage.CompleteMultipartUpload(uploadID, completeReq.Parts)
+	objMeta, err := h.storage.CompleteMultipartUpload(r.Context(), uploadID, completeReq.Parts)
 	if err != nil {
 		if errors.Is(err, storage.ErrPartNotFound) {
 			s3.WriteErrorResponse(w, s3.ErrInvalidPart)
@@ -818,10 +1587,21 @@ func (h *Handlers) CompleteMultipartUpload(w http.ResponseWriter, r *http.Reques
 			s3.WriteErrorResponse(w, s3.ErrNoSuchUpload)
 			return
 		}
+		if errors.Is(err, storage.ErrObjectLocked) {
+			s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+			return
+		}
+		if isContextError(err) {
+			s3.WriteErrorResponse(w, s3.ErrRequestTimeout)
+			return
+		}
 		slog.Error("failed to complete multipart upload", "error", err, "bucket", bucket, "key", key, "upload_id", uploadID, "request_id", GetRequestID(r))
 		s3.WriteErrorResponse(w, s3.ErrInternalError)
 		return
 	}
+	metrics.ObjectSizeBytes.WithLabelValues(metrics.OpCompleteMultipartUpload).Observe(float64(objMeta.Size))
+	UpdateBucketUsageMetrics(h.storage, bucket)
+	auditObjectChange(r, key, objMeta)
 
 	result := s3.CompleteMultipartUploadResult{
 		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
@@ -833,6 +1613,7 @@ func (h *Handlers) CompleteMultipartUpload(w http.ResponseWriter, r *http.Reques
 	}
 
 	w.Header().Set("Content-Type", "application/xml")
+	setVersionIDHeader(w, objMeta)
 	w.WriteHeader(http.StatusOK)
 	_ = xml.NewEncoder(w).Encode(result)
 }
@@ -855,9 +1636,9 @@ func (h *Handlers) AbortMultipartUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Verify upload exists
+	// Verify upload exists in this bucket
 	uploadMeta, err := h.storage.GetMultipartUpload(uploadID)
-	if err != nil {
+	if err != nil || uploadMeta.Bucket != bucket {
 		s3.WriteErrorResponse(w, s3.ErrNoSuchUpload)
 		return
 	}
@@ -888,6 +1669,10 @@ func (h *Handlers) PostObject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rejectUnsupportedSubresource(w, r, unsupportedObjectSubresources) {
+		return
+	}
+
 	query := r.URL.Query()
 
 	if query.Has("uploads") {
@@ -912,8 +1697,33 @@ func (h *Handlers) GetBucket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rejectUnsupportedSubresource(w, r, unsupportedBucketSubresources) {
+		return
+	}
+
 	query := r.URL.Query()
 
+	if query.Has("versioning") {
+		h.GetBucketVersioning(w, r)
+		return
+	}
+	if query.Has("versions") {
+		h.ListObjectVersions(w, r)
+		return
+	}
+	if query.Has("lifecycle") {
+		h.GetBucketLifecycleConfiguration(w, r)
+		return
+	}
+	if query.Has("cors") {
+		h.GetBucketCors(w, r)
+		return
+	}
+	if query.Has("location") {
+		h.GetBucketLocation(w, r)
+		return
+	}
+
 	// ListObjectsV2 (list-type=2) or ListObjects (no list-type)
 	if query.Get("list-type") == "2" {
 		h.ListObjectsV2(w, r)
@@ -940,56 +1750,75 @@ func (h *Handlers) ListObjectsV2(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	opts := storage.ListObjectsOptions{
-		Prefix:            query.Get("prefix"),
-		Delimiter:         query.Get("delimiter"),
-		MaxKeys:           maxKeys,
-		StartAfter:        query.Get("start-after"),
-		ContinuationToken: query.Get("continuation-token"),
+	// metadata=true is a MinIO extension adding user metadata to each object
+	withMetadata := h.cfg.Limits.ListMetadata && query.Get("metadata") == "true"
+	if withMetadata && maxKeys > maxKeysWithMetadata {
+		maxKeys = maxKeysWithMetadata
 	}
 
-	result, err := h.storage.ListObjects(bucket, opts)
-	if err != nil {
-		slog.Error("failed to list objects", "error", err, "bucket", bucket, "prefix", opts.Prefix, "request_id", GetRequestID(r))
-		s3.WriteErrorResponse(w, s3.ErrInternalError)
+	encodingType := query.Get("encoding-type")
+	if encodingType != "" && encodingType != encodingTypeURL {
+		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
 		return
 	}
 
-	// Build response
-	var objects []s3.Object
-	for _, obj := range result.Objects {
-		objects = append(objects, s3.Object{
-			Key:          obj.Key,
-			LastModified: obj.LastModified,
-			ETag:         obj.ETag,
-			Size:         obj.Size,
-			StorageClass: "STANDARD",
-		})
+	opts := storage.ListObjectsOptions{
+		Prefix:     query.Get("prefix"),
+		Delimiter:  query.Get("delimiter"),
+		MaxKeys:    maxKeys,
+		StartAfter: query.Get("start-after"),
+	}
+	continuationToken := query.Get("continuation-token")
+	if continuationToken != "" {
+		position, err := h.verifyContinuationToken(continuationToken, opts.Prefix, opts.Delimiter)
+		if err != nil {
+			s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+			return
+		}
+		opts.ContinuationToken = position
 	}
 
-	var commonPrefixes []s3.Prefix
-	for _, prefix := range result.CommonPrefixes {
-		commonPrefixes = append(commonPrefixes, s3.Prefix{Prefix: prefix})
+	lw := newListWriter(w, s3.ListBucketResultV2{
+		Xmlns:             "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:              bucket,
+		Prefix:            opts.Prefix,
+		Delimiter:         opts.Delimiter,
+		MaxKeys:           maxKeys,
+		StartAfter:        opts.StartAfter,
+		ContinuationToken: continuationToken,
+		EncodingType:      encodingType,
+	})
+	if query.Get("fetch-owner") == "true" {
+		owner := h.owner(r)
+		lw.owner = &owner
+	}
+	lw.withMetadata = withMetadata
+
+	// Objects are encoded as they are read, so the listing is never held in memory
+	result, err := h.storage.ListObjectsFunc(bucket, opts, lw.entry)
+	if err == nil {
+		// S3 only returns a token on truncated pages, and some clients keep
+		// listing for as long as one is present
+		if !result.IsTruncated {
+			result.NextContinuationToken = ""
+		} else if result.NextContinuationToken != "" {
+			result.NextContinuationToken = h.signContinuationToken(listPosition{
+				Position:  result.NextContinuationToken,
+				Prefix:    opts.Prefix,
+				Delimiter: opts.Delimiter,
+			})
+		}
+		lw.finish(result)
+		return
 	}
-
-	response := s3.ListBucketResultV2{
-		Xmlns:                 "http://s3.amazonaws.com/doc/2006-03-01/",
-		Name:                  bucket,
-		Prefix:                opts.Prefix,
-		Delimiter:             opts.Delimiter,
-		MaxKeys:               maxKeys,
-		KeyCount:              len(objects),
-		IsTruncated:           result.IsTruncated,
-		StartAfter:            opts.StartAfter,
-		ContinuationToken:     opts.ContinuationToken,
-		NextContinuationToken: result.NextContinuationToken,
-		Contents:              objects,
-		CommonPrefixes:        commonPrefixes,
+	if lw.writeErr != nil {
+		return
 	}
-
-	w.Header().Set("Content-Type", "application/xml")
-	w.WriteHeader(http.StatusOK)
-	_ = xml.NewEncoder(w).Encode(response)
+	slog.Error("failed to list objects", "error", err, "bucket", bucket, "prefix", opts.Prefix, "request_id", GetRequestID(r))
+	if !lw.started {
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+	}
+	// Otherwise the response is under way and the client sees a truncated body
 }
 
 // PostBucket handles POST /{bucket} for bucket-level operations like DeleteObjects
@@ -1001,6 +1830,10 @@ func (h *Handlers) PostBucket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if rejectUnsupportedSubresource(w, r, unsupportedBucketSubresources) {
+		return
+	}
+
 	query := r.URL.Query()
 
 	if query.Has("delete") {
@@ -1016,12 +1849,18 @@ func (h *Handlers) DeleteObjects(w http.ResponseWriter, r *http.Request) {
 	// Note: bucket validation is done in PostBucket before calling this handler
 	bucket := r.PathValue("bucket")
 
-	// Parse request body with size limit to prevent XML bomb attacks
-	// Limit to 1MB which is more than enough for batch delete requests
-	const maxXMLBodySize = 1 * 1024 * 1024
-	limitedBody := io.LimitReader(r.Body, maxXMLBodySize)
+	// Parse request body with size limit to prevent XML bomb attacks. The
+	// limit fits a full batch of maximum length keys, even with every
+	// character escaped, so a larger body cannot be a valid request.
+	const maxXMLBodySize = maxDeleteObjects * (6*1024 + 256)
 	var deleteReq s3.Delete
-	if err := xml.NewDecoder(limitedBody).Decode(&deleteReq); err != nil {
+	// Decoding stops past a full batch of objects and the Quiet element
+	if err := decodeXMLItems(r.Body, maxXMLBodySize, maxDeleteObjects+1, &deleteReq); err != nil {
+		s3.WriteErrorResponse(w, s3.ErrMalformedXML)
+		return
+	}
+	// As in S3, a batch has between 1 and 1000 objects
+	if len(deleteReq.Objects) == 0 || len(deleteReq.Objects) > maxDeleteObjects {
 		s3.WriteErrorResponse(w, s3.ErrMalformedXML)
 		return
 	}
@@ -1031,22 +1870,58 @@ func (h *Handlers) DeleteObjects(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, obj := range deleteReq.Objects {
-		err := h.storage.DeleteObject(bucket, obj.Key)
-		if err != nil {
+		// Without a version ID, a versioned bucket keeps the object behind a
+		// delete marker, as in DeleteObject
+		var deleted *s3.ObjectMetadata
+		err := storage.ValidateKey(obj.Key)
+		if err == nil {
+			deleted, err = h.storage.DeleteObjectVersion(bucket, obj.Key, obj.VersionId)
+		}
+		// As in S3, deleting a key that does not exist succeeds
+		if err == nil || errors.Is(err, storage.ErrObjectNotFound) {
+			auditObjectChange(r, obj.Key, nil)
+			if !deleteReq.Quiet {
+				result.Deleted = append(result.Deleted, deletedObject(obj, deleted))
+			}
+			continue
+		}
+
+		deleteErr := s3.DeleteError{Key: obj.Key, VersionId: obj.VersionId}
+		switch {
+		case errors.Is(err, storage.ErrInvalidKey):
+			// The validation message says what is wrong with the key
+			deleteErr.Code, deleteErr.Message = string(invalidKeyError(err)), err.Error()
+		case errors.Is(err, storage.ErrObjectImmutable):
+			deleteErr.Code, deleteErr.Message = string(s3.ErrAccessDenied), "Object is within the immutability window of its bucket"
+		case errors.Is(err, storage.ErrObjectRetained):
+			deleteErr.Code, deleteErr.Message = string(s3.ErrAccessDenied), "Object is within its object lock retention period"
+		case errors.Is(err, storage.ErrObjectLocked):
+			deleteErr.Code, deleteErr.Message = string(s3.ErrAccessDenied), "Object is under legal hold"
+		case errors.Is(err, storage.ErrNoSuchVersion):
+			deleteErr.Code, deleteErr.Message = string(s3.ErrNoSuchVersion), s3.NewError(s3.ErrNoSuchVersion, "").Message
+		case errors.Is(err, storage.ErrBucketNotFound):
+			deleteErr.Code, deleteErr.Message = string(s3.ErrNoSuchBucket), s3.NewError(s3.ErrNoSuchBucket, "").Message
+		default:
 			slog.Error("failed to delete object in batch", "error", err, "bucket", bucket, "key", obj.Key, "request_id", GetRequestID(r))
-			result.Error = append(result.Error, s3.DeleteError{
-				Key:     obj.Key,
-				Code:    string(s3.ErrInternalError),
-				Message: "Failed to delete object",
-			})
-		} else if !deleteReq.Quiet {
-			result.Deleted = append(result.Deleted, s3.DeletedObject{
-				Key: obj.Key,
-			})
+			deleteErr.Code, deleteErr.Message = string(s3.ErrInternalError), s3.NewError(s3.ErrInternalError, "").Message
 		}
+		result.Error = append(result.Error, deleteErr)
 	}
+	UpdateBucketUsageMetrics(h.storage, bucket)
 
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
 	_ = xml.NewEncoder(w).Encode(result)
 }
+
+// deletedObject reports the deletion of obj in a DeleteObjects response.
+// deleted is the removed version or the delete marker that was added, nil
+// in a bucket without versioning.
+func deletedObject(obj s3.ObjectToDelete, deleted *s3.ObjectMetadata) s3.DeletedObject {
+	result := s3.DeletedObject{Key: obj.Key, VersionId: obj.VersionId}
+	if deleted != nil && deleted.DeleteMarker {
+		result.DeleteMarker = true
+		result.DeleteMarkerVersionId = deleted.VersionID
+	}
+	return result
+}
@@ -1,18 +1,39 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/espen/stupid-simple-s3/internal/config"
+	"github.com/espen/stupid-simple-s3/internal/metrics"
 	"github.com/espen/stupid-simple-s3/internal/s3"
 	"github.com/espen/stupid-simple-s3/internal/storage"
 )
@@ -135,7 +156,7 @@ func TestDeleteBucket(t *testing.T) {
 		if err := store.CreateBucket("nonempty"); err != nil {
 			t.Fatalf("CreateBucket failed: %v", err)
 		}
-		_, err := store.PutObject("nonempty", "test-key", "text/plain", nil, strings.NewReader("content"))
+		_, err := store.PutObject(context.Background(), "nonempty", "test-key", "text/plain", nil, strings.NewReader("content"))
 		if err != nil {
 			t.Fatalf("PutObject failed: %v", err)
 		}
@@ -157,6 +178,29 @@ func TestDeleteBucket(t *testing.T) {
 		}
 	})
 
+	t.Run("force delete non-empty bucket", func(t *testing.T) {
+		if err := store.CreateBucket("forced"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if _, err := store.PutObject(context.Background(), "forced", "dir/test-key", "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+
+		req := httptest.NewRequest("DELETE", "/forced", nil)
+		req.SetPathValue("bucket", "forced")
+		req.Header.Set("x-sss-force", "true")
+		w := httptest.NewRecorder()
+
+		handlers.DeleteBucket(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("status = %d, want %d, body = %s", w.Code, http.StatusNoContent, w.Body.String())
+		}
+		if exists, _ := store.BucketExists("forced"); exists {
+			t.Error("bucket should not exist after forced deletion")
+		}
+	})
+
 	t.Run("delete non-existent bucket returns not found", func(t *testing.T) {
 		req := httptest.NewRequest("DELETE", "/nonexistent", nil)
 		req.SetPathValue("bucket", "nonexistent")
@@ -296,6 +340,55 @@ func TestGetObjectNotFound(t *testing.T) {
 	}
 }
 
+func TestGetObjectNotFoundHidden(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	authorized := &config.Credential{AccessKeyID: "AKIAOWNER", Buckets: []string{"test-bucket"}}
+	otherTenant := &config.Credential{AccessKeyID: "AKIAOTHER", Buckets: []string{"other-bucket"}}
+	publicRead := &config.Credential{AccessKeyID: anonymousAccessKeyID, Buckets: []string{"test-bucket"}}
+
+	tests := []struct {
+		name         string
+		hideNotFound bool
+		cred         *config.Credential
+		wantStatus   int
+		wantCode     s3.ErrorCode
+	}{
+		{"default returns NoSuchKey", false, nil, http.StatusNotFound, s3.ErrNoSuchKey},
+		{"hide_not_found unauthenticated returns AccessDenied", true, nil, http.StatusForbidden, s3.ErrAccessDenied},
+		{"hide_not_found public read returns AccessDenied", true, publicRead, http.StatusForbidden, s3.ErrAccessDenied},
+		{"hide_not_found other tenant returns AccessDenied", true, otherTenant, http.StatusForbidden, s3.ErrAccessDenied},
+		{"hide_not_found authorized returns NoSuchKey", true, authorized, http.StatusNotFound, s3.ErrNoSuchKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers.cfg.Auth.HideNotFound = tt.hideNotFound
+
+			req := httptest.NewRequest("GET", "/test-bucket/nonexistent.txt", nil)
+			req.SetPathValue("bucket", "test-bucket")
+			req.SetPathValue("key", "nonexistent.txt")
+			if tt.cred != nil {
+				req = req.WithContext(context.WithValue(req.Context(), credentialContextKey, tt.cred))
+			}
+			w := httptest.NewRecorder()
+
+			handlers.GetObject(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			var errResp s3.Error
+			_ = xml.NewDecoder(w.Body).Decode(&errResp)
+			if errResp.Code != tt.wantCode {
+				t.Errorf("error code = %q, want %q", errResp.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
 func TestHeadObject(t *testing.T) {
 	handlers, _, cleanup := setupTestHandlers(t)
 	defer cleanup()
@@ -336,6 +429,151 @@ func TestHeadObject(t *testing.T) {
 	}
 }
 
+func TestHeadObjectFolderPrefix(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	put := func(key string, content []byte) {
+		t.Helper()
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, bytes.NewReader(content))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		handlers.PutObject(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: status = %d", key, w.Code)
+		}
+	}
+	head := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("HEAD", "/test-bucket/"+key, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		handlers.HeadObject(w, req)
+		return w
+	}
+
+	put("folder/file.txt", []byte("content"))
+
+	// Descendant objects do not make the prefix itself an object
+	if w := head("folder/"); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD folder/ without marker: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := head("folder"); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD folder without marker: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// A zero-byte marker is an ordinary object
+	put("folder/", nil)
+	w := head("folder/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("HEAD folder/ with marker: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w.Header().Get("Content-Length") != "0" {
+		t.Errorf("Content-Length = %q, want %q", w.Header().Get("Content-Length"), "0")
+	}
+	if w := head("folder"); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD folder with marker: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHeadObjectRange(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	key := "head-range.txt"
+	content := []byte("0123456789ABCDEF")
+	putReq := httptest.NewRequest("PUT", "/test-bucket/"+key, bytes.NewReader(content))
+	putReq.SetPathValue("bucket", "test-bucket")
+	putReq.SetPathValue("key", key)
+	handlers.PutObject(httptest.NewRecorder(), putReq)
+
+	head := func(rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("HEAD", "/test-bucket/"+key, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		req.Header.Set("Range", rangeHeader)
+		w := httptest.NewRecorder()
+		handlers.HeadObject(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name              string
+		rangeHeader       string
+		wantStatus        int
+		wantContentLength string
+		wantContentRange  string
+	}{
+		{"bounded range", "bytes=0-4", http.StatusPartialContent, "5", "bytes 0-4/16"},
+		{"open-ended range", "bytes=10-", http.StatusPartialContent, "6", "bytes 10-15/16"},
+		{"suffix range", "bytes=-5", http.StatusPartialContent, "5", "bytes 11-15/16"},
+		{"end beyond size", "bytes=8-100", http.StatusPartialContent, "8", "bytes 8-15/16"},
+		{"unsatisfiable range", "bytes=16-20", http.StatusRequestedRangeNotSatisfiable, "", "bytes */16"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := head(tt.rangeHeader)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantContentLength != "" && w.Header().Get("Content-Length") != tt.wantContentLength {
+				t.Errorf("Content-Length = %q, want %q", w.Header().Get("Content-Length"), tt.wantContentLength)
+			}
+			if w.Header().Get("Content-Range") != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", w.Header().Get("Content-Range"), tt.wantContentRange)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("HEAD response should have no body, got %d bytes", w.Body.Len())
+			}
+		})
+	}
+
+	t.Run("matches GET for multiple ranges", func(t *testing.T) {
+		w := head("bytes=0-1,4-5")
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+		}
+
+		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		req.Header.Set("Range", "bytes=0-1,4-5")
+		getW := httptest.NewRecorder()
+		handlers.GetObject(getW, req)
+
+		if w.Header().Get("Content-Length") != getW.Header().Get("Content-Length") {
+			t.Errorf("HEAD Content-Length = %q, GET Content-Length = %q", w.Header().Get("Content-Length"), getW.Header().Get("Content-Length"))
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges; boundary=") {
+			t.Errorf("Content-Type = %q", w.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("invalid range", func(t *testing.T) {
+		if w := head("bytes=abc"); w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("no range", func(t *testing.T) {
+		req := httptest.NewRequest("HEAD", "/test-bucket/"+key, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		handlers.HeadObject(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if w.Header().Get("Content-Length") != "16" || w.Header().Get("Content-Range") != "" {
+			t.Errorf("Content-Length = %q, Content-Range = %q", w.Header().Get("Content-Length"), w.Header().Get("Content-Range"))
+		}
+	})
+}
+
 func TestDeleteObject(t *testing.T) {
 	handlers, _, cleanup := setupTestHandlers(t)
 	defer cleanup()
@@ -551,8 +789,10 @@ func TestMultipartUploadHandlers(t *testing.T) {
 		if result.Key != key {
 			t.Errorf("Key = %q, want %q", result.Key, key)
 		}
-		if !strings.Contains(result.ETag, "-2") {
-			t.Errorf("ETag should indicate 2 parts: %q", result.ETag)
+		// The MD5 of the concatenated binary MD5s of "Part 1 content"
+		// (6a34b001...) and "Part 2 content" (c5ffbab6...), as S3 computes it
+		if want := `"85e2e377b6948073c31d2a2053b7d2ae-2"`; result.ETag != want {
+			t.Errorf("ETag = %s, want %s", result.ETag, want)
 		}
 	})
 
@@ -573,9 +813,106 @@ func TestMultipartUploadHandlers(t *testing.T) {
 		if w.Body.String() != expectedContent {
 			t.Errorf("content = %q, want %q", w.Body.String(), expectedContent)
 		}
+		if got := w.Header().Get("x-amz-mp-parts-count"); got != "2" {
+			t.Errorf("GET x-amz-mp-parts-count = %q, want 2", got)
+		}
+	})
+
+	t.Run("head reports parts count", func(t *testing.T) {
+		req := httptest.NewRequest("HEAD", "/test-bucket/"+key, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+
+		handlers.HeadObject(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("HEAD status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("x-amz-mp-parts-count"); got != "2" {
+			t.Errorf("HEAD x-amz-mp-parts-count = %q, want 2", got)
+		}
 	})
 }
 
+func TestGetObjectPartNumber(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	uploadID, err := store.CreateMultipartUpload("test-bucket", "multipart", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	var completed []s3.CompletedPartInput
+	for i, body := range []string{"first part", "second", "third part!"} {
+		part, err := store.UploadPart(ctx, uploadID, i+1, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		completed = append(completed, s3.CompletedPartInput{PartNumber: i + 1, ETag: part.ETag})
+	}
+	if _, err := store.CompleteMultipartUpload(ctx, uploadID, completed); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	if _, err := store.PutObject(ctx, "test-bucket", "single", "text/plain", nil, strings.NewReader("one piece")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	get := func(key, partNumber string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?partNumber="+partNumber, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		maps.Copy(req.Header, header)
+		w := httptest.NewRecorder()
+		handlers.GetObject(w, req)
+		return w
+	}
+
+	for _, tc := range []struct {
+		partNumber string
+		body       string
+		rng        string
+	}{
+		{"1", "first part", "bytes 0-9/27"},
+		{"2", "second", "bytes 10-15/27"},
+		{"3", "third part!", "bytes 16-26/27"},
+	} {
+		w := get("multipart", tc.partNumber, nil)
+		if w.Code != http.StatusPartialContent || w.Body.String() != tc.body {
+			t.Errorf("part %s: status = %d, body = %q, want %d and %q", tc.partNumber, w.Code, w.Body.String(), http.StatusPartialContent, tc.body)
+		}
+		if got := w.Header().Get("Content-Range"); got != tc.rng {
+			t.Errorf("part %s: Content-Range = %q, want %q", tc.partNumber, got, tc.rng)
+		}
+		if got := w.Header().Get("x-amz-mp-parts-count"); got != "3" {
+			t.Errorf("part %s: x-amz-mp-parts-count = %q, want 3", tc.partNumber, got)
+		}
+	}
+
+	// An object uploaded in one piece has a single part, and no parts count
+	if w := get("single", "1", nil); w.Code != http.StatusOK || w.Body.String() != "one piece" || w.Header().Get("x-amz-mp-parts-count") != "" {
+		t.Errorf("single part 1: status = %d, body = %q, headers = %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	for _, tc := range []struct {
+		key, partNumber string
+		header          http.Header
+		want            int
+	}{
+		{"multipart", "4", nil, http.StatusRequestedRangeNotSatisfiable},
+		{"single", "2", nil, http.StatusRequestedRangeNotSatisfiable},
+		{"multipart", "0", nil, http.StatusBadRequest},
+		{"multipart", "one", nil, http.StatusBadRequest},
+		{"multipart", "1", http.Header{"Range": {"bytes=0-1"}}, http.StatusBadRequest},
+		{"multipart", "1", http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+	} {
+		if w := get(tc.key, tc.partNumber, tc.header); w.Code != tc.want {
+			t.Errorf("%s part %s with %v: status = %d, want %d", tc.key, tc.partNumber, tc.header, w.Code, tc.want)
+		}
+	}
+}
+
 func TestAbortMultipartUpload(t *testing.T) {
 	handlers, _, cleanup := setupTestHandlers(t)
 	defer cleanup()
@@ -624,6 +961,45 @@ func TestAbortMultipartUpload(t *testing.T) {
 	}
 }
 
+func TestMultipartUploadOtherBucket(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	if err := store.CreateBucket("other-bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	const key = "shared-key.txt"
+	uploadID, err := store.CreateMultipartUpload("test-bucket", key, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+
+	// The credential may only access other-bucket, and names the upload of
+	// test-bucket through it
+	cred := &config.Credential{AccessKeyID: "AKIAOTHER", Privileges: config.PrivilegeReadWrite, Buckets: []string{"other-bucket"}}
+	send := func(method string, handler http.HandlerFunc, query string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/other-bucket/"+key+"?"+query, body)
+		req.SetPathValue("bucket", "other-bucket")
+		req.SetPathValue("key", key)
+		req = req.WithContext(context.WithValue(req.Context(), credentialContextKey, cred))
+		w := httptest.NewRecorder()
+		RequireBucketAccess(handler).ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("PUT", handlers.UploadPart, "partNumber=1&uploadId="+uploadID, strings.NewReader("part")); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchUpload") {
+		t.Errorf("UploadPart: status = %d, body = %s; want 404 NoSuchUpload", w.Code, w.Body.String())
+	}
+	if w := send("DELETE", handlers.AbortMultipartUpload, "uploadId="+uploadID, nil); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchUpload") {
+		t.Errorf("AbortMultipartUpload: status = %d, body = %s; want 404 NoSuchUpload", w.Code, w.Body.String())
+	}
+
+	// The upload was not aborted
+	if _, err := store.GetMultipartUpload(uploadID); err != nil {
+		t.Errorf("GetMultipartUpload after the requests failed: %v", err)
+	}
+}
+
 func TestMetricsBasicAuth(t *testing.T) {
 	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -882,9 +1258,10 @@ func TestListObjectsV2(t *testing.T) {
 		var result s3.ListBucketResultV2
 		_ = xml.NewDecoder(w.Body).Decode(&result)
 
-		// Should have 1 root object and 2 common prefixes (a/, b/)
-		if result.KeyCount != 1 {
-			t.Errorf("KeyCount = %d, want 1", result.KeyCount)
+		// Should have 1 root object and 2 common prefixes (a/, b/), which
+		// KeyCount includes as in S3
+		if result.KeyCount != 3 {
+			t.Errorf("KeyCount = %d, want 3", result.KeyCount)
 		}
 		if len(result.CommonPrefixes) != 2 {
 			t.Errorf("CommonPrefixes count = %d, want 2", len(result.CommonPrefixes))
@@ -911,7 +1288,7 @@ func TestListObjectsV2(t *testing.T) {
 }
 
 func TestDeleteObjects(t *testing.T) {
-	handlers, _, cleanup := setupTestHandlers(t)
+	handlers, store, cleanup := setupTestHandlers(t)
 	defer cleanup()
 
 	// Create objects to delete
@@ -988,135 +1365,313 @@ func TestDeleteObjects(t *testing.T) {
 			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
 		}
 	})
-}
-
-func TestPostBucket(t *testing.T) {
-	handlers, _, cleanup := setupTestHandlers(t)
-	defer cleanup()
 
-	t.Run("wrong bucket returns error", func(t *testing.T) {
-		req := httptest.NewRequest("POST", "/wrong-bucket?delete", nil)
-		req.SetPathValue("bucket", "wrong-bucket")
-		w := httptest.NewRecorder()
-
-		handlers.PostBucket(w, req)
-
-		if w.Code != http.StatusNotFound {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	deleteKeys := func(keys []string) *httptest.ResponseRecorder {
+		var body strings.Builder
+		body.WriteString("<Delete>")
+		for _, key := range keys {
+			body.WriteString("<Object><Key>")
+			_ = xml.EscapeText(&body, []byte(key))
+			body.WriteString("</Key></Object>")
 		}
-	})
-
-	t.Run("invalid operation returns error", func(t *testing.T) {
-		req := httptest.NewRequest("POST", "/test-bucket", nil)
+		body.WriteString("</Delete>")
+		req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader(body.String()))
 		req.SetPathValue("bucket", "test-bucket")
 		w := httptest.NewRecorder()
+		handlers.DeleteObjects(w, req)
+		return w
+	}
 
-		handlers.PostBucket(w, req)
+	t.Run("batch size limits", func(t *testing.T) {
+		// A full batch of maximum length keys needing escaping is accepted
+		keys := make([]string, maxDeleteObjects)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("%04d", i) + strings.Repeat("&", 1020)
+		}
+		if w := deleteKeys(keys); w.Code != http.StatusOK {
+			t.Errorf("full batch: status = %d, want %d", w.Code, http.StatusOK)
+		}
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		for name, keys := range map[string][]string{
+			"empty":     nil,
+			"over 1000": make([]string, maxDeleteObjects+1),
+		} {
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key-%d", i)
+			}
+			w := deleteKeys(keys)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>MalformedXML</Code>") {
+				t.Errorf("%s: status = %d, body = %s", name, w.Code, w.Body.String())
+			}
 		}
 	})
-}
-
-func TestCopyObject(t *testing.T) {
-	handlers, _, cleanup := setupTestHandlers(t)
-	defer cleanup()
-
-	// Create source object
-	srcKey := "source.txt"
-	content := []byte("copy me")
-	putReq := httptest.NewRequest("PUT", "/test-bucket/"+srcKey, bytes.NewReader(content))
-	putReq.SetPathValue("bucket", "test-bucket")
-	putReq.SetPathValue("key", srcKey)
-	putReq.Header.Set("Content-Type", "text/plain")
-	putReq.Header.Set("X-Amz-Meta-Custom", "value")
-	handlers.PutObject(httptest.NewRecorder(), putReq)
-
-	t.Run("copy object successfully", func(t *testing.T) {
-		req := httptest.NewRequest("PUT", "/test-bucket/destination.txt", nil)
-		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", "destination.txt")
-		req.Header.Set("X-Amz-Copy-Source", "/test-bucket/source.txt")
-		w := httptest.NewRecorder()
 
-		handlers.CopyObject(w, req)
+	t.Run("invalid keys are reported", func(t *testing.T) {
+		putReq := httptest.NewRequest("PUT", "/test-bucket/valid.txt", bytes.NewReader([]byte("content")))
+		putReq.SetPathValue("bucket", "test-bucket")
+		putReq.SetPathValue("key", "valid.txt")
+		handlers.PutObject(httptest.NewRecorder(), putReq)
 
+		invalid := []string{"../escape", "/absolute", strings.Repeat("k", 1025)}
+		codes := []s3.ErrorCode{s3.ErrInvalidArgument, s3.ErrInvalidArgument, s3.ErrKeyTooLong}
+		w := deleteKeys(append([]string{"valid.txt"}, invalid...))
 		if w.Code != http.StatusOK {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
 		}
 
-		var result s3.CopyObjectResult
+		var result s3.DeleteObjectsResult
 		if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
 			t.Fatalf("failed to decode response: %v", err)
 		}
-
-		if result.ETag == "" {
-			t.Error("ETag should not be empty")
+		if len(result.Deleted) != 1 || result.Deleted[0].Key != "valid.txt" {
+			t.Errorf("Deleted = %+v, want valid.txt", result.Deleted)
 		}
-
-		// Verify the copy exists
-		getReq := httptest.NewRequest("GET", "/test-bucket/destination.txt", nil)
-		getReq.SetPathValue("bucket", "test-bucket")
-		getReq.SetPathValue("key", "destination.txt")
-		getW := httptest.NewRecorder()
-		handlers.GetObject(getW, getReq)
-
-		if !bytes.Equal(getW.Body.Bytes(), content) {
-			t.Errorf("copied content = %q, want %q", getW.Body.String(), string(content))
+		if len(result.Error) != len(invalid) {
+			t.Fatalf("Error = %+v, want %d entries", result.Error, len(invalid))
+		}
+		for i, e := range result.Error {
+			if e.Key != invalid[i] || e.Code != string(codes[i]) || !strings.HasPrefix(e.Message, "invalid object key: ") {
+				t.Errorf("Error[%d] = %+v", i, e)
+			}
 		}
 	})
 
-	t.Run("copy with URL encoded source", func(t *testing.T) {
-		req := httptest.NewRequest("PUT", "/test-bucket/encoded-dest.txt", nil)
-		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", "encoded-dest.txt")
-		req.Header.Set("X-Amz-Copy-Source", "test-bucket%2Fsource.txt")
-		w := httptest.NewRecorder()
-
-		handlers.CopyObject(w, req)
+	t.Run("per-key errors", func(t *testing.T) {
+		for _, key := range []string{"held.txt", "broken.txt"} {
+			if _, err := store.PutObject(context.Background(), "test-bucket", key, "text/plain", nil, strings.NewReader("content")); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+		}
+		if err := store.PutObjectLegalHold("test-bucket", "held.txt", s3.LegalHoldOn); err != nil {
+			t.Fatalf("PutObjectLegalHold failed: %v", err)
+		}
+		// Replacing the object directory with a file makes the delete fail
+		sum := sha256.Sum256([]byte("broken.txt"))
+		objPath := filepath.Join(handlers.cfg.Storage.Path, "buckets", "test-bucket", "objects", hex.EncodeToString(sum[:2]), hex.EncodeToString(sum[:]))
+		if err := os.RemoveAll(objPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(objPath, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
 
-		if w.Code != http.StatusOK {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		w := deleteKeys([]string{"never-existed.txt", "held.txt", "broken.txt", ""})
+		var result s3.DeleteObjectsResult
+		if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(result.Deleted) != 1 || result.Deleted[0].Key != "never-existed.txt" {
+			t.Errorf("Deleted = %+v, want never-existed.txt", result.Deleted)
+		}
+		want := map[string]s3.ErrorCode{
+			"held.txt":   s3.ErrAccessDenied,
+			"broken.txt": s3.ErrInternalError,
+			"":           s3.ErrInvalidArgument,
+		}
+		if len(result.Error) != len(want) {
+			t.Fatalf("Error = %+v", result.Error)
+		}
+		for _, e := range result.Error {
+			if e.Code != string(want[e.Key]) || e.Message == "" {
+				t.Errorf("Error for %q = %+v, want code %s", e.Key, e, want[e.Key])
+			}
 		}
 	})
 
-	t.Run("copy from nonexistent source", func(t *testing.T) {
-		req := httptest.NewRequest("PUT", "/test-bucket/dest.txt", nil)
+	t.Run("immutability window is reported separately", func(t *testing.T) {
+		store := storage.NewMemoryStorageWithOptions(storage.MemoryOptions{
+			ImmutabilityWindows: map[string]time.Duration{"test-bucket": time.Hour},
+		})
+		if err := store.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if _, err := store.PutObject(context.Background(), "test-bucket", "new.txt", "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader("<Delete><Object><Key>new.txt</Key></Object></Delete>"))
 		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", "dest.txt")
-		req.Header.Set("X-Amz-Copy-Source", "/test-bucket/nonexistent.txt")
 		w := httptest.NewRecorder()
+		NewHandlers(&config.Config{}, store).DeleteObjects(w, req)
 
-		handlers.CopyObject(w, req)
+		var result s3.DeleteObjectsResult
+		if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(result.Error) != 1 || result.Error[0].Code != string(s3.ErrAccessDenied) || result.Error[0].Message != "Object is within the immutability window of its bucket" {
+			t.Errorf("Error = %+v, want AccessDenied for the immutability window", result.Error)
+		}
+	})
 
-		if w.Code != http.StatusNotFound {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	t.Run("retention is reported separately", func(t *testing.T) {
+		h, store, cleanup := setupTestHandlers(t)
+		defer cleanup()
+		until := time.Now().Add(time.Hour)
+		meta := &s3.ObjectMetadata{
+			Key:                       "retained.txt",
+			Size:                      4,
+			ETag:                      `"8d777f385d3dfec8815d20f7496026dc"`,
+			LastModified:              time.Now().UTC(),
+			ObjectLockMode:            s3.ObjectLockModeCompliance,
+			ObjectLockRetainUntilDate: &until,
+		}
+		if err := store.(*storage.FilesystemStorage).ImportObject(context.Background(), "test-bucket", meta, strings.NewReader("data")); err != nil {
+			t.Fatalf("ImportObject failed: %v", err)
+		}
+		req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader("<Delete><Object><Key>retained.txt</Key></Object></Delete>"))
+		req.SetPathValue("bucket", "test-bucket")
+		w := httptest.NewRecorder()
+		h.DeleteObjects(w, req)
+
+		var result s3.DeleteObjectsResult
+		if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(result.Error) != 1 || result.Error[0].Code != string(s3.ErrAccessDenied) || result.Error[0].Message != "Object is within its object lock retention period" {
+			t.Errorf("Error = %+v, want AccessDenied for the retention period", result.Error)
 		}
 	})
 
-	t.Run("copy from wrong bucket", func(t *testing.T) {
-		req := httptest.NewRequest("PUT", "/test-bucket/dest.txt", nil)
+	t.Run("versions in a versioned bucket", func(t *testing.T) {
+		store := storage.NewMemoryStorage()
+		if err := store.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if err := store.PutBucketVersioning("test-bucket", storage.VersioningEnabled); err != nil {
+			t.Fatalf("PutBucketVersioning failed: %v", err)
+		}
+		var versions []string
+		for _, body := range []string{"first", "second"} {
+			meta, err := store.PutObject(context.Background(), "test-bucket", "doc.txt", "text/plain", nil, strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			versions = append(versions, meta.VersionID)
+		}
+
+		deleteXML := "<Delete>" +
+			"<Object><Key>doc.txt</Key><VersionId>" + versions[0] + "</VersionId></Object>" +
+			"<Object><Key>doc.txt</Key></Object>" +
+			"<Object><Key>doc.txt</Key><VersionId>0123456789abcdef0123456789abcdef</VersionId></Object>" +
+			"</Delete>"
+		req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader(deleteXML))
 		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", "dest.txt")
-		req.Header.Set("X-Amz-Copy-Source", "/wrong-bucket/source.txt")
 		w := httptest.NewRecorder()
+		NewHandlers(&config.Config{}, store).DeleteObjects(w, req)
 
-		handlers.CopyObject(w, req)
+		var result s3.DeleteObjectsResult
+		if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(result.Deleted) != 2 {
+			t.Fatalf("Deleted = %+v, want 2 entries", result.Deleted)
+		}
+		if got := result.Deleted[0]; got.VersionId != versions[0] || got.DeleteMarker {
+			t.Errorf("Deleted[0] = %+v, want version %s removed", got, versions[0])
+		}
+		if got := result.Deleted[1]; got.VersionId != "" || !got.DeleteMarker || got.DeleteMarkerVersionId == "" {
+			t.Errorf("Deleted[1] = %+v, want a new delete marker", got)
+		}
+		if len(result.Error) != 1 || result.Error[0].Code != string(s3.ErrNoSuchVersion) || result.Error[0].VersionId == "" {
+			t.Errorf("Error = %+v, want NoSuchVersion for the unknown version", result.Error)
+		}
+
+		// The first version is gone for good; the second is kept behind the
+		// delete marker
+		if _, err := store.HeadObjectVersion("test-bucket", "doc.txt", versions[0]); !errors.Is(err, storage.ErrNoSuchVersion) {
+			t.Errorf("HeadObjectVersion(first) err = %v, want ErrNoSuchVersion", err)
+		}
+		if _, err := store.HeadObjectVersion("test-bucket", "doc.txt", versions[1]); err != nil {
+			t.Errorf("HeadObjectVersion(second) failed: %v", err)
+		}
+	})
+}
+
+func TestDeleteObjectVersionImmutable(t *testing.T) {
+	windows := map[string]time.Duration{"test-bucket": time.Hour}
+	backends := map[string]func(t *testing.T) storage.MultipartStorage{
+		"filesystem": func(t *testing.T) storage.MultipartStorage {
+			tmpDir := t.TempDir()
+			store, err := storage.NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), storage.FilesystemOptions{
+				ImmutabilityWindows: windows,
+			})
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+			return store
+		},
+		"memory": func(t *testing.T) storage.MultipartStorage {
+			return storage.NewMemoryStorageWithOptions(storage.MemoryOptions{ImmutabilityWindows: windows})
+		},
+	}
+
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			for _, versioning := range []string{"", storage.VersioningEnabled, storage.VersioningSuspended} {
+				store := newStore(t)
+				if err := store.CreateBucket("test-bucket"); err != nil {
+					t.Fatalf("CreateBucket failed: %v", err)
+				}
+				if versioning != "" {
+					if err := store.PutBucketVersioning("test-bucket", versioning); err != nil {
+						t.Fatalf("PutBucketVersioning failed: %v", err)
+					}
+				}
+				put, err := store.PutObject(context.Background(), "test-bucket", "new.txt", "text/plain", nil, strings.NewReader("content"))
+				if err != nil {
+					t.Fatalf("PutObject failed: %v", err)
+				}
+				versionID := put.VersionID
+				if versionID == "" {
+					versionID = storage.NullVersionID
+				}
+
+				// A suspended bucket replaces the null version even without a
+				// version ID
+				targets := []string{"?versionId=" + versionID}
+				if versioning == storage.VersioningSuspended {
+					targets = append(targets, "")
+				}
+				for _, target := range targets {
+					req := httptest.NewRequest("DELETE", "/test-bucket/new.txt"+target, nil)
+					req.SetPathValue("bucket", "test-bucket")
+					req.SetPathValue("key", "new.txt")
+					w := httptest.NewRecorder()
+					NewHandlers(&config.Config{}, store).DeleteObject(w, req)
+
+					if w.Code != http.StatusForbidden {
+						t.Errorf("versioning %q, DELETE %q: status = %d, want %d", versioning, target, w.Code, http.StatusForbidden)
+					}
+					if _, err := store.HeadObjectVersion("test-bucket", "new.txt", versionID); err != nil {
+						t.Errorf("versioning %q, DELETE %q: version removed within the immutability window: %v", versioning, target, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPostBucket(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	t.Run("wrong bucket returns error", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/wrong-bucket?delete", nil)
+		req.SetPathValue("bucket", "wrong-bucket")
+		w := httptest.NewRecorder()
+
+		handlers.PostBucket(w, req)
 
 		if w.Code != http.StatusNotFound {
 			t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
 		}
 	})
 
-	t.Run("copy with invalid source format", func(t *testing.T) {
-		req := httptest.NewRequest("PUT", "/test-bucket/dest.txt", nil)
+	t.Run("invalid operation returns error", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/test-bucket", nil)
 		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", "dest.txt")
-		req.Header.Set("X-Amz-Copy-Source", "invalid")
 		w := httptest.NewRecorder()
 
-		handlers.CopyObject(w, req)
+		handlers.PostBucket(w, req)
 
 		if w.Code != http.StatusBadRequest {
 			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
@@ -1124,665 +1679,4393 @@ func TestCopyObject(t *testing.T) {
 	})
 }
 
-func TestGetObjectRange(t *testing.T) {
+func TestCopyObject(t *testing.T) {
 	handlers, _, cleanup := setupTestHandlers(t)
 	defer cleanup()
 
-	// Create a test object
-	key := "range-test.txt"
-	content := []byte("0123456789ABCDEF") // 16 bytes
-	putReq := httptest.NewRequest("PUT", "/test-bucket/"+key, bytes.NewReader(content))
+	// Create source object
+	srcKey := "source.txt"
+	content := []byte("copy me")
+	putReq := httptest.NewRequest("PUT", "/test-bucket/"+srcKey, bytes.NewReader(content))
 	putReq.SetPathValue("bucket", "test-bucket")
-	putReq.SetPathValue("key", key)
+	putReq.SetPathValue("key", srcKey)
+	putReq.Header.Set("Content-Type", "text/plain")
+	putReq.Header.Set("X-Amz-Meta-Custom", "value")
 	handlers.PutObject(httptest.NewRecorder(), putReq)
 
-	t.Run("range bytes=0-4", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
+	t.Run("source bucket not allowed for credential", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/destination.txt", nil)
 		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", key)
-		req.Header.Set("Range", "bytes=0-4")
+		req.SetPathValue("key", "destination.txt")
+		req.Header.Set("X-Amz-Copy-Source", "/test-bucket/source.txt")
+		cred := &config.Credential{Privileges: config.PrivilegeReadWrite, Buckets: []string{"other-bucket"}}
+		req = req.WithContext(context.WithValue(req.Context(), credentialContextKey, cred))
 		w := httptest.NewRecorder()
 
-		handlers.GetObject(w, req)
+		handlers.CopyObject(w, req)
 
-		if w.Code != http.StatusPartialContent {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusPartialContent)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
 		}
+	})
 
-		if w.Body.String() != "01234" {
-			t.Errorf("body = %q, want %q", w.Body.String(), "01234")
+	t.Run("copy object successfully", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/destination.txt", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "destination.txt")
+		req.Header.Set("X-Amz-Copy-Source", "/test-bucket/source.txt")
+		w := httptest.NewRecorder()
+
+		handlers.CopyObject(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
 		}
 
-		contentRange := w.Header().Get("Content-Range")
-		if contentRange != "bytes 0-4/16" {
-			t.Errorf("Content-Range = %q, want %q", contentRange, "bytes 0-4/16")
+		var result s3.CopyObjectResult
+		if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
 		}
-	})
 
-	t.Run("range bytes=10-", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
-		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", key)
-		req.Header.Set("Range", "bytes=10-")
-		w := httptest.NewRecorder()
+		if result.ETag == "" {
+			t.Error("ETag should not be empty")
+		}
 
-		handlers.GetObject(w, req)
+		if w.Header().Get("Last-Modified") == "" {
+			t.Error("Last-Modified header should be set")
+		}
 
-		if w.Code != http.StatusPartialContent {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusPartialContent)
+		// ETag in the result must match the destination object's ETag
+		headReq := httptest.NewRequest("HEAD", "/test-bucket/destination.txt", nil)
+		headReq.SetPathValue("bucket", "test-bucket")
+		headReq.SetPathValue("key", "destination.txt")
+		headW := httptest.NewRecorder()
+		handlers.HeadObject(headW, headReq)
+
+		if got := headW.Header().Get("ETag"); got != result.ETag {
+			t.Errorf("HEAD ETag = %q, want %q", got, result.ETag)
+		}
+		if got := headW.Header().Get("Last-Modified"); got != w.Header().Get("Last-Modified") {
+			t.Errorf("HEAD Last-Modified = %q, want %q", got, w.Header().Get("Last-Modified"))
 		}
 
-		if w.Body.String() != "ABCDEF" {
-			t.Errorf("body = %q, want %q", w.Body.String(), "ABCDEF")
+		// Verify the copy exists
+		getReq := httptest.NewRequest("GET", "/test-bucket/destination.txt", nil)
+		getReq.SetPathValue("bucket", "test-bucket")
+		getReq.SetPathValue("key", "destination.txt")
+		getW := httptest.NewRecorder()
+		handlers.GetObject(getW, getReq)
+
+		if !bytes.Equal(getW.Body.Bytes(), content) {
+			t.Errorf("copied content = %q, want %q", getW.Body.String(), string(content))
 		}
 	})
 
-	t.Run("range bytes=-5 (last 5 bytes)", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
+	t.Run("copy with URL encoded source", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/encoded-dest.txt", nil)
 		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", key)
-		req.Header.Set("Range", "bytes=-5")
+		req.SetPathValue("key", "encoded-dest.txt")
+		req.Header.Set("X-Amz-Copy-Source", "test-bucket%2Fsource.txt")
 		w := httptest.NewRecorder()
 
-		handlers.GetObject(w, req)
+		handlers.CopyObject(w, req)
 
-		if w.Code != http.StatusPartialContent {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusPartialContent)
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
 		}
+	})
 
-		if w.Body.String() != "BCDEF" {
-			t.Errorf("body = %q, want %q", w.Body.String(), "BCDEF")
+	t.Run("copy from nonexistent source", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/dest.txt", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "dest.txt")
+		req.Header.Set("X-Amz-Copy-Source", "/test-bucket/nonexistent.txt")
+		w := httptest.NewRecorder()
+
+		handlers.CopyObject(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
 		}
 	})
 
-	t.Run("invalid range format", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
+	t.Run("copy from wrong bucket", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/dest.txt", nil)
 		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", key)
-		req.Header.Set("Range", "invalid")
+		req.SetPathValue("key", "dest.txt")
+		req.Header.Set("X-Amz-Copy-Source", "/wrong-bucket/source.txt")
 		w := httptest.NewRecorder()
 
-		handlers.GetObject(w, req)
+		handlers.CopyObject(w, req)
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
 		}
 	})
 
-	t.Run("range beyond file size", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
+	t.Run("copy with invalid source format", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/dest.txt", nil)
 		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", key)
-		req.Header.Set("Range", "bytes=100-200")
+		req.SetPathValue("key", "dest.txt")
+		req.Header.Set("X-Amz-Copy-Source", "invalid")
 		w := httptest.NewRecorder()
 
-		handlers.GetObject(w, req)
+		handlers.CopyObject(w, req)
 
-		if w.Code != http.StatusRequestedRangeNotSatisfiable {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusRequestedRangeNotSatisfiable)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
 		}
 	})
 }
 
-func TestParseRangeHeader(t *testing.T) {
+func TestCopyObjectConditions(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	meta, err := store.PutObject(context.Background(), "test-bucket", "source.txt", "text/plain", nil, strings.NewReader("copy me"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	etag := meta.ETag
+	before := meta.LastModified.Add(-time.Hour).Format(http.TimeFormat)
+	after := meta.LastModified.Add(time.Hour).Format(http.TimeFormat)
+
 	tests := []struct {
-		name      string
-		header    string
-		wantStart int64
-		wantEnd   int64
-		wantErr   bool
+		name       string
+		headers    map[string]string
+		wantStatus int
 	}{
-		{"simple range", "bytes=0-99", 0, 99, false},
-		{"open ended", "bytes=100-", 100, -1, false},
-		{"suffix range", "bytes=-50", -50, -1, false},
-		{"invalid prefix", "chars=0-99", 0, 0, true},
-		{"invalid format", "bytes=0", 0, 0, true},
-		{"invalid start", "bytes=abc-99", 0, 0, true},
-		{"invalid end", "bytes=0-xyz", 0, 0, true},
+		{"no conditions", nil, http.StatusOK},
+		{"if-match true", map[string]string{"If-Match": etag}, http.StatusOK},
+		{"if-match wildcard", map[string]string{"If-Match": "*"}, http.StatusOK},
+		{"if-match false", map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+		{"if-none-match true", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"if-none-match false", map[string]string{"If-None-Match": etag}, http.StatusPreconditionFailed},
+		{"if-modified-since true", map[string]string{"If-Modified-Since": before}, http.StatusOK},
+		{"if-modified-since false", map[string]string{"If-Modified-Since": after}, http.StatusPreconditionFailed},
+		{"if-unmodified-since true", map[string]string{"If-Unmodified-Since": after}, http.StatusOK},
+		{"if-unmodified-since false", map[string]string{"If-Unmodified-Since": before}, http.StatusPreconditionFailed},
+		{"invalid date is ignored", map[string]string{"If-Modified-Since": "not a date"}, http.StatusOK},
+
+		// if-match + if-unmodified-since: a matching ETag wins over the date
+		{"if-match true, if-unmodified-since true", map[string]string{"If-Match": etag, "If-Unmodified-Since": after}, http.StatusOK},
+		{"if-match true, if-unmodified-since false", map[string]string{"If-Match": etag, "If-Unmodified-Since": before}, http.StatusOK},
+		{"if-match false, if-unmodified-since true", map[string]string{"If-Match": `"other"`, "If-Unmodified-Since": after}, http.StatusPreconditionFailed},
+		{"if-match false, if-unmodified-since false", map[string]string{"If-Match": `"other"`, "If-Unmodified-Since": before}, http.StatusPreconditionFailed},
+
+		// if-none-match + if-modified-since: both must hold
+		{"if-none-match true, if-modified-since true", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": before}, http.StatusOK},
+		{"if-none-match true, if-modified-since false", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": after}, http.StatusPreconditionFailed},
+		{"if-none-match false, if-modified-since true", map[string]string{"If-None-Match": etag, "If-Modified-Since": before}, http.StatusPreconditionFailed},
+		{"if-none-match false, if-modified-since false", map[string]string{"If-None-Match": etag, "If-Modified-Since": after}, http.StatusPreconditionFailed},
+
+		// Disallowed combinations
+		{"if-match with if-none-match", map[string]string{"If-Match": etag, "If-None-Match": `"other"`}, http.StatusBadRequest},
+		{"if-match with if-modified-since", map[string]string{"If-Match": etag, "If-Modified-Since": before}, http.StatusBadRequest},
+		{"if-none-match with if-unmodified-since", map[string]string{"If-None-Match": `"other"`, "If-Unmodified-Since": after}, http.StatusBadRequest},
+		{"if-modified-since with if-unmodified-since", map[string]string{"If-Modified-Since": before, "If-Unmodified-Since": after}, http.StatusBadRequest},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			start, end, err := parseRangeHeader(tt.header)
-
-			if (err != nil) != tt.wantErr {
-				t.Errorf("parseRangeHeader() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			req := httptest.NewRequest("PUT", "/test-bucket/destination.txt", nil)
+			req.SetPathValue("bucket", "test-bucket")
+			req.SetPathValue("key", "destination.txt")
+			req.Header.Set("X-Amz-Copy-Source", "/test-bucket/source.txt")
+			for k, v := range tt.headers {
+				req.Header.Set("X-Amz-Copy-Source-"+k, v)
 			}
+			w := httptest.NewRecorder()
 
-			if !tt.wantErr {
-				if start != tt.wantStart {
-					t.Errorf("start = %d, want %d", start, tt.wantStart)
-				}
-				if end != tt.wantEnd {
-					t.Errorf("end = %d, want %d", end, tt.wantEnd)
-				}
+			handlers.CopyObject(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
 			}
 		})
 	}
 }
 
-func TestRequireWritePrivilege(t *testing.T) {
-	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("success"))
-	})
+func TestCopyObjectConditionFailedDoesNotCopy(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
 
-	t.Run("allows write privilege", func(t *testing.T) {
-		handler := RequireWritePrivilege(dummyHandler)
+	if _, err := store.PutObject(context.Background(), "test-bucket", "source.txt", "text/plain", nil, strings.NewReader("copy me")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
 
-		req := httptest.NewRequest("PUT", "/test", nil)
-		cred := &config.Credential{Privileges: config.PrivilegeReadWrite}
-		ctx := context.WithValue(req.Context(), credentialContextKey, cred)
-		req = req.WithContext(ctx)
+	req := httptest.NewRequest("PUT", "/test-bucket/destination.txt", nil)
+	req.SetPathValue("bucket", "test-bucket")
+	req.SetPathValue("key", "destination.txt")
+	req.Header.Set("X-Amz-Copy-Source", "/test-bucket/source.txt")
+	req.Header.Set("X-Amz-Copy-Source-If-Match", `"other"`)
+	w := httptest.NewRecorder()
 
-		w := httptest.NewRecorder()
-		handler.ServeHTTP(w, req)
+	handlers.CopyObject(w, req)
 
-		if w.Code != http.StatusOK {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+
+	var errResp s3.Error
+	_ = xml.NewDecoder(w.Body).Decode(&errResp)
+	if errResp.Code != s3.ErrPreconditionFailed {
+		t.Errorf("error code = %q, want %q", errResp.Code, s3.ErrPreconditionFailed)
+	}
+
+	if exists, _ := store.ObjectExists("test-bucket", "destination.txt"); exists {
+		t.Error("destination should not exist after failed precondition")
+	}
+}
+
+func TestCopyObjectOntoItself(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	if _, err := store.PutObject(context.Background(), "test-bucket", "doc.bin", "application/octet-stream", nil, strings.NewReader(strings.Repeat("x", 1<<20))); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	original, _ := store.HeadObject("test-bucket", "doc.bin")
+	dataFile := findObjectDataFile(t, handlers.cfg.Storage.Path)
+	dataBefore, err := os.Stat(dataFile)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	update := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/test-bucket/doc.bin", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "doc.bin")
+		req.Header.Set("X-Amz-Copy-Source", "/test-bucket/doc.bin")
+		for k, v := range headers {
+			req.Header.Set(k, v)
 		}
+		w := httptest.NewRecorder()
+		handlers.PutObject(w, req)
+		return w
+	}
+
+	w := update(nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>InvalidRequest</Code>") {
+		t.Errorf("copy onto itself without REPLACE: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	w = update(map[string]string{
+		"X-Amz-Metadata-Directive": "REPLACE",
+		"Content-Type":             "application/pdf",
+		"X-Amz-Meta-Reviewed":      "true",
 	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var result s3.CopyObjectResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if result.ETag != original.ETag {
+		t.Errorf("ETag = %s, want %s", result.ETag, original.ETag)
+	}
 
-	t.Run("denies read-only privilege", func(t *testing.T) {
-		handler := RequireWritePrivilege(dummyHandler)
+	meta, err := store.HeadObject("test-bucket", "doc.bin")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if meta.ContentType != "application/pdf" || meta.UserMetadata["reviewed"] != "true" || meta.Size != original.Size {
+		t.Errorf("metadata = %+v", meta)
+	}
 
-		req := httptest.NewRequest("PUT", "/test", nil)
-		cred := &config.Credential{Privileges: config.PrivilegeRead}
-		ctx := context.WithValue(req.Context(), credentialContextKey, cred)
-		req = req.WithContext(ctx)
+	// Only the metadata is written; the data file is left as it was
+	dataAfter, err := os.Stat(dataFile)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !os.SameFile(dataBefore, dataAfter) || !dataAfter.ModTime().Equal(dataBefore.ModTime()) {
+		t.Error("data file was rewritten by a copy that only replaces metadata")
+	}
+}
 
-		w := httptest.NewRecorder()
-		handler.ServeHTTP(w, req)
+// findObjectDataFile returns the path of the data file of the only object
+// stored under root
+func findObjectDataFile(t *testing.T, root string) string {
+	t.Helper()
 
-		if w.Code != http.StatusForbidden {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	var found []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == "data" {
+			found = append(found, path)
 		}
+		return nil
 	})
+	if err != nil {
+		t.Fatalf("WalkDir failed: %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("found data files %v, want exactly one", found)
+	}
+	return found[0]
+}
 
-	t.Run("denies missing credential", func(t *testing.T) {
-		handler := RequireWritePrivilege(dummyHandler)
+func TestCopyObjectDirectives(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
 
-		req := httptest.NewRequest("PUT", "/test", nil)
+	putReq := httptest.NewRequest("PUT", "/test-bucket/source.txt", strings.NewReader("copy me"))
+	putReq.SetPathValue("bucket", "test-bucket")
+	putReq.SetPathValue("key", "source.txt")
+	putReq.Header.Set("Content-Type", "text/plain")
+	putReq.Header.Set("X-Amz-Meta-Custom", "source")
+	putReq.Header.Set("X-Amz-Tagging", "project=blue&team=infra")
+	putW := httptest.NewRecorder()
+	handlers.PutObject(putW, putReq)
+	if putW.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, body = %s", putW.Code, putW.Body.String())
+	}
+
+	copyObject := func(dstKey string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+dstKey, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", dstKey)
+		req.Header.Set("X-Amz-Copy-Source", "/test-bucket/source.txt")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
 		w := httptest.NewRecorder()
-		handler.ServeHTTP(w, req)
+		handlers.CopyObject(w, req)
+		return w
+	}
 
-		if w.Code != http.StatusForbidden {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	t.Run("copy keeps tags and metadata", func(t *testing.T) {
+		w := copyObject("copied.txt", map[string]string{"X-Amz-Tagging": "ignored=true"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
 		}
-	})
-}
 
-func TestAccessLogMiddleware(t *testing.T) {
-	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("hello"))
+		meta, err := store.HeadObject("test-bucket", "copied.txt")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if want := map[string]string{"project": "blue", "team": "infra"}; !reflect.DeepEqual(meta.Tags, want) {
+			t.Errorf("tags = %v, want %v", meta.Tags, want)
+		}
+		if meta.UserMetadata["custom"] != "source" {
+			t.Errorf("user metadata = %v, want source's", meta.UserMetadata)
+		}
 	})
 
-	t.Run("logs request and passes through", func(t *testing.T) {
-		handler := AccessLogMiddleware(nil)(dummyHandler)
+	t.Run("tagging directive replace", func(t *testing.T) {
+		w := copyObject("retagged.txt", map[string]string{
+			"X-Amz-Tagging-Directive": "REPLACE",
+			"X-Amz-Tagging":           "project=green",
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
 
-		req := httptest.NewRequest("GET", "/test", nil)
-		w := httptest.NewRecorder()
+		meta, err := store.HeadObject("test-bucket", "retagged.txt")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if want := map[string]string{"project": "green"}; !reflect.DeepEqual(meta.Tags, want) {
+			t.Errorf("tags = %v, want %v", meta.Tags, want)
+		}
+		// The metadata directive is independent of the tagging directive
+		if meta.ContentType != "text/plain" || meta.UserMetadata["custom"] != "source" {
+			t.Errorf("metadata = %q %v, want source's", meta.ContentType, meta.UserMetadata)
+		}
 
-		handler.ServeHTTP(w, req)
+		headReq := httptest.NewRequest("HEAD", "/test-bucket/retagged.txt", nil)
+		headReq.SetPathValue("bucket", "test-bucket")
+		headReq.SetPathValue("key", "retagged.txt")
+		headW := httptest.NewRecorder()
+		handlers.HeadObject(headW, headReq)
+		if got := headW.Header().Get("X-Amz-Tagging-Count"); got != "1" {
+			t.Errorf("x-amz-tagging-count = %q, want %q", got, "1")
+		}
+	})
 
+	t.Run("metadata directive replace", func(t *testing.T) {
+		w := copyObject("remeta.txt", map[string]string{
+			"X-Amz-Metadata-Directive": "REPLACE",
+			"Content-Type":             "application/json",
+			"X-Amz-Meta-Other":         "dest",
+		})
 		if w.Code != http.StatusOK {
-			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
 		}
-		if w.Body.String() != "hello" {
-			t.Errorf("body = %q, want %q", w.Body.String(), "hello")
+
+		meta, err := store.HeadObject("test-bucket", "remeta.txt")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if meta.ContentType != "application/json" {
+			t.Errorf("content type = %q, want %q", meta.ContentType, "application/json")
+		}
+		if want := map[string]string{"other": "dest"}; !reflect.DeepEqual(meta.UserMetadata, want) {
+			t.Errorf("user metadata = %v, want %v", meta.UserMetadata, want)
+		}
+		if len(meta.Tags) != 2 {
+			t.Errorf("tags = %v, want source's", meta.Tags)
 		}
 	})
 
-	t.Run("extracts client IP from X-Forwarded-For when trusted", func(t *testing.T) {
-		// httptest.NewRequest sets RemoteAddr to "192.0.2.1:1234"
-		handler := AccessLogMiddleware([]string{"192.0.2.1"})(dummyHandler)
+	t.Run("invalid replacement tags", func(t *testing.T) {
+		w := copyObject("badtags.txt", map[string]string{
+			"X-Amz-Tagging-Directive": "REPLACE",
+			"X-Amz-Tagging":           "aws:reserved=x",
+		})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidTag") {
+			t.Errorf("status = %d, body = %s, want InvalidTag", w.Code, w.Body.String())
+		}
+		if exists, _ := store.ObjectExists("test-bucket", "badtags.txt"); exists {
+			t.Error("destination should not exist after invalid tags")
+		}
+	})
 
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.Header.Set("X-Forwarded-For", "192.168.1.100, 10.0.0.1")
+	t.Run("invalid directive", func(t *testing.T) {
+		w := copyObject("baddirective.txt", map[string]string{"X-Amz-Tagging-Directive": "MERGE"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestBucketMaxObjects(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	handlers.cfg.Bucket.MaxObjects = 2
+
+	put := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader("data"))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
 		w := httptest.NewRecorder()
+		handlers.PutObject(w, req)
+		return w
+	}
+	assertTooManyObjects := func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "TooManyObjects") {
+			t.Errorf("status = %d, body = %s, want TooManyObjects", w.Code, w.Body.String())
+		}
+	}
 
-		handler.ServeHTTP(w, req)
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if w := put(key); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: status = %d", key, w.Code)
+		}
+	}
 
-		if w.Code != http.StatusOK {
+	t.Run("put beyond limit", func(t *testing.T) {
+		assertTooManyObjects(t, put("c.txt"))
+		if exists, _ := store.ObjectExists("test-bucket", "c.txt"); exists {
+			t.Error("rejected object should not exist")
+		}
+	})
+
+	t.Run("overwrite at limit", func(t *testing.T) {
+		if w := put("a.txt"); w.Code != http.StatusOK {
 			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
 		}
 	})
 
-	t.Run("extracts client IP from X-Real-IP when trusted", func(t *testing.T) {
-		// httptest.NewRequest sets RemoteAddr to "192.0.2.1:1234"
-		handler := AccessLogMiddleware([]string{"192.0.2.1"})(dummyHandler)
+	t.Run("copy beyond limit", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/copy.txt", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "copy.txt")
+		req.Header.Set("X-Amz-Copy-Source", "/test-bucket/a.txt")
+		w := httptest.NewRecorder()
+		handlers.CopyObject(w, req)
+		assertTooManyObjects(t, w)
+	})
 
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.Header.Set("X-Real-IP", "192.168.1.100")
+	t.Run("multipart upload beyond limit", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/test-bucket/multi.txt?uploads", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "multi.txt")
 		w := httptest.NewRecorder()
+		handlers.CreateMultipartUpload(w, req)
+		assertTooManyObjects(t, w)
+	})
 
-		handler.ServeHTTP(w, req)
+	t.Run("delete frees room", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/test-bucket/b.txt", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "b.txt")
+		handlers.DeleteObject(httptest.NewRecorder(), req)
 
-		if w.Code != http.StatusOK {
+		if w := put("c.txt"); w.Code != http.StatusOK {
 			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
 		}
+		assertTooManyObjects(t, put("d.txt"))
+	})
+
+	t.Run("per-bucket override", func(t *testing.T) {
+		handlers.cfg.Bucket.ObjectLimits = map[string]int64{"test-bucket": 0}
+		defer func() { handlers.cfg.Bucket.ObjectLimits = nil }()
+
+		if w := put("d.txt"); w.Code != http.StatusOK {
+			t.Errorf("unlimited bucket: status = %d, want %d", w.Code, http.StatusOK)
+		}
+		handlers.cfg.Bucket.ObjectLimits["test-bucket"] = 3
+		assertTooManyObjects(t, put("e.txt"))
 	})
 }
 
-func TestGetClientIP(t *testing.T) {
-	// getClientIP should always return RemoteAddr, ignoring proxy headers
-	// This is a security measure - proxy headers are only trusted via getClientIPWithTrust
+func TestParseTagging(t *testing.T) {
 	tests := []struct {
-		name       string
-		xff        string
-		xri        string
-		remoteAddr string
-		want       string
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
 	}{
-		{"ignores X-Forwarded-For", "192.168.1.1", "", "10.0.0.1:1234", "10.0.0.1"},
-		{"ignores X-Real-IP", "", "192.168.1.1", "10.0.0.1:1234", "10.0.0.1"},
-		{"RemoteAddr with port", "", "", "10.0.0.1:1234", "10.0.0.1"},
-		{"RemoteAddr without port", "", "", "10.0.0.1", "10.0.0.1"},
+		{"empty", "", nil, false},
+		{"single tag", "project=blue", map[string]string{"project": "blue"}, false},
+		{"encoded values", "name=a%20b&empty=", map[string]string{"name": "a b", "empty": ""}, false},
+		{"duplicate key", "a=1&a=2", nil, true},
+		{"reserved prefix", "aws:tag=1", nil, true},
+		{"key too long", strings.Repeat("k", maxTagKeyLength+1) + "=v", nil, true},
+		{"value too long", "k=" + strings.Repeat("v", maxTagValueLength+1), nil, true},
+		{"too many tags", "a=1&b=2&c=3&d=4&e=5&f=6&g=7&h=8&i=9&j=10&k=11", nil, true},
+		{"malformed escape", "a=%zz", nil, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest("GET", "/test", nil)
-			if tt.xff != "" {
-				req.Header.Set("X-Forwarded-For", tt.xff)
-			}
-			if tt.xri != "" {
-				req.Header.Set("X-Real-IP", tt.xri)
+			got, err := parseTagging(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTagging(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
 			}
-			req.RemoteAddr = tt.remoteAddr
-
-			got := getClientIP(req)
-			if got != tt.want {
-				t.Errorf("getClientIP() = %q, want %q", got, tt.want)
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTagging(%q) = %v, want %v", tt.value, got, tt.want)
 			}
 		})
 	}
 }
 
-func TestGetClientIPWithTrust(t *testing.T) {
-	// Test proxy header handling with trusted proxy configuration
-	trustedChecker := newTrustedProxyChecker([]string{"10.0.0.1", "172.16.0.0/12"})
-	untrustedChecker := newTrustedProxyChecker(nil)
+func TestGetObjectConditions(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
 
-	t.Run("trusts X-Forwarded-For from trusted proxy", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.Header.Set("X-Forwarded-For", "192.168.1.1")
-		req.RemoteAddr = "10.0.0.1:1234"
+	meta, err := store.PutObject(context.Background(), "test-bucket", "cond.txt", "text/plain", nil, strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
 
-		got := getClientIPWithTrust(req, trustedChecker)
-		if got != "192.168.1.1" {
-			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "192.168.1.1")
-		}
-	})
+	before := meta.LastModified.Add(-time.Hour).Format(http.TimeFormat)
+	after := meta.LastModified.Add(time.Hour).Format(http.TimeFormat)
 
-	t.Run("trusts X-Real-IP from trusted proxy", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.Header.Set("X-Real-IP", "192.168.1.1")
-		req.RemoteAddr = "10.0.0.1:1234"
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"if-match true", map[string]string{"If-Match": meta.ETag}, http.StatusOK},
+		{"if-match false", map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+		{"if-none-match true", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"if-none-match false", map[string]string{"If-None-Match": meta.ETag}, http.StatusNotModified},
+		{"if-modified-since true", map[string]string{"If-Modified-Since": before}, http.StatusOK},
+		{"if-modified-since false", map[string]string{"If-Modified-Since": after}, http.StatusNotModified},
+		{"if-unmodified-since true", map[string]string{"If-Unmodified-Since": after}, http.StatusOK},
+		{"if-unmodified-since false", map[string]string{"If-Unmodified-Since": before}, http.StatusPreconditionFailed},
+	}
 
-		got := getClientIPWithTrust(req, trustedChecker)
-		if got != "192.168.1.1" {
-			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "192.168.1.1")
-		}
-	})
+	for _, tt := range tests {
+		t.Run("GET "+tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test-bucket/cond.txt", nil)
+			req.SetPathValue("bucket", "test-bucket")
+			req.SetPathValue("key", "cond.txt")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
 
-	t.Run("ignores proxy headers from untrusted source", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.Header.Set("X-Forwarded-For", "192.168.1.1")
-		req.RemoteAddr = "203.0.113.1:1234" // Not in trusted list
+			handlers.GetObject(w, req)
 
-		got := getClientIPWithTrust(req, trustedChecker)
-		if got != "203.0.113.1" {
-			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "203.0.113.1")
-		}
-	})
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 response should have no body, got %q", w.Body.String())
+			}
+		})
 
-	t.Run("ignores proxy headers when no trusted proxies configured", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.Header.Set("X-Forwarded-For", "192.168.1.1")
-		req.RemoteAddr = "10.0.0.1:1234"
+		t.Run("HEAD "+tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("HEAD", "/test-bucket/cond.txt", nil)
+			req.SetPathValue("bucket", "test-bucket")
+			req.SetPathValue("key", "cond.txt")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
 
-		got := getClientIPWithTrust(req, untrustedChecker)
-		if got != "10.0.0.1" {
-			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "10.0.0.1")
+			handlers.HeadObject(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("range request honors conditions", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/cond.txt", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "cond.txt")
+		req.Header.Set("Range", "bytes=0-4")
+		req.Header.Set("If-Match", `"other"`)
+		w := httptest.NewRecorder()
+
+		handlers.GetObject(w, req)
+
+		if w.Code != http.StatusPreconditionFailed {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusPreconditionFailed)
 		}
 	})
+}
 
-	t.Run("trusts proxy from CIDR range", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.Header.Set("X-Forwarded-For", "192.168.1.1")
-		req.RemoteAddr = "172.20.0.5:1234" // In 172.16.0.0/12
-
-		got := getClientIPWithTrust(req, trustedChecker)
-		if got != "192.168.1.1" {
-			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "192.168.1.1")
-		}
-	})
-
-	t.Run("X-Forwarded-For takes precedence over X-Real-IP", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test", nil)
-		req.Header.Set("X-Forwarded-For", "192.168.1.1")
-		req.Header.Set("X-Real-IP", "192.168.2.2")
-		req.RemoteAddr = "10.0.0.1:1234"
-
-		got := getClientIPWithTrust(req, trustedChecker)
-		if got != "192.168.1.1" {
-			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "192.168.1.1")
-		}
-	})
-}
-
-func TestPresignedResponseHeaderOverrides(t *testing.T) {
+func TestGetObjectRange(t *testing.T) {
 	handlers, _, cleanup := setupTestHandlers(t)
 	defer cleanup()
 
-	// Create a test object first
-	key := "presigned-header-test.txt"
-	content := []byte("test content")
+	// Create a test object
+	key := "range-test.txt"
+	content := []byte("0123456789ABCDEF") // 16 bytes
 	putReq := httptest.NewRequest("PUT", "/test-bucket/"+key, bytes.NewReader(content))
 	putReq.SetPathValue("bucket", "test-bucket")
 	putReq.SetPathValue("key", key)
-	putReq.Header.Set("Content-Type", "text/plain")
 	handlers.PutObject(httptest.NewRecorder(), putReq)
 
-	t.Run("presigned request with response-content-type", func(t *testing.T) {
-		// Simulate a presigned request by adding the required query parameters
-		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test&X-Amz-Signature=test&response-content-type=application/octet-stream", nil)
+	t.Run("range bytes=0-4", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
 		req.SetPathValue("bucket", "test-bucket")
 		req.SetPathValue("key", key)
+		req.Header.Set("Range", "bytes=0-4")
 		w := httptest.NewRecorder()
 
 		handlers.GetObject(w, req)
 
-		if w.Code != http.StatusOK {
-			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		if w.Code != http.StatusPartialContent {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusPartialContent)
 		}
-		if got := w.Header().Get("Content-Type"); got != "application/octet-stream" {
-			t.Errorf("Content-Type = %q, want %q", got, "application/octet-stream")
+
+		if w.Body.String() != "01234" {
+			t.Errorf("body = %q, want %q", w.Body.String(), "01234")
+		}
+
+		contentRange := w.Header().Get("Content-Range")
+		if contentRange != "bytes 0-4/16" {
+			t.Errorf("Content-Range = %q, want %q", contentRange, "bytes 0-4/16")
 		}
 	})
 
-	t.Run("presigned request with response-content-disposition", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test&X-Amz-Signature=test&response-content-disposition=attachment%3Bfilename%3Ddownload.txt", nil)
+	t.Run("range bytes=10-", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
 		req.SetPathValue("bucket", "test-bucket")
 		req.SetPathValue("key", key)
+		req.Header.Set("Range", "bytes=10-")
 		w := httptest.NewRecorder()
 
 		handlers.GetObject(w, req)
 
-		if w.Code != http.StatusOK {
-			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		if w.Code != http.StatusPartialContent {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusPartialContent)
 		}
-		if got := w.Header().Get("Content-Disposition"); got != "attachment;filename=download.txt" {
-			t.Errorf("Content-Disposition = %q, want %q", got, "attachment;filename=download.txt")
+
+		if w.Body.String() != "ABCDEF" {
+			t.Errorf("body = %q, want %q", w.Body.String(), "ABCDEF")
 		}
 	})
 
-	t.Run("presigned request with response-cache-control", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test&X-Amz-Signature=test&response-cache-control=max-age=3600", nil)
+	t.Run("range bytes=-5 (last 5 bytes)", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
 		req.SetPathValue("bucket", "test-bucket")
 		req.SetPathValue("key", key)
+		req.Header.Set("Range", "bytes=-5")
 		w := httptest.NewRecorder()
 
 		handlers.GetObject(w, req)
 
-		if w.Code != http.StatusOK {
-			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		if w.Code != http.StatusPartialContent {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusPartialContent)
 		}
-		if got := w.Header().Get("Cache-Control"); got != "max-age=3600" {
-			t.Errorf("Cache-Control = %q, want %q", got, "max-age=3600")
+
+		if w.Body.String() != "BCDEF" {
+			t.Errorf("body = %q, want %q", w.Body.String(), "BCDEF")
 		}
 	})
 
-	t.Run("presigned request with all response headers", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test&X-Amz-Signature=test&response-content-type=image/png&response-content-disposition=inline&response-cache-control=no-cache", nil)
+	t.Run("invalid range format", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
 		req.SetPathValue("bucket", "test-bucket")
 		req.SetPathValue("key", key)
+		req.Header.Set("Range", "invalid")
 		w := httptest.NewRecorder()
 
 		handlers.GetObject(w, req)
 
-		if w.Code != http.StatusOK {
-			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
-		}
-		if got := w.Header().Get("Content-Type"); got != "image/png" {
-			t.Errorf("Content-Type = %q, want %q", got, "image/png")
-		}
-		if got := w.Header().Get("Content-Disposition"); got != "inline" {
-			t.Errorf("Content-Disposition = %q, want %q", got, "inline")
-		}
-		if got := w.Header().Get("Cache-Control"); got != "no-cache" {
-			t.Errorf("Cache-Control = %q, want %q", got, "no-cache")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
 		}
 	})
 
-	t.Run("non-presigned request ignores response header overrides", func(t *testing.T) {
-		// Request without presigned URL parameters
-		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?response-content-type=application/octet-stream&response-content-disposition=attachment", nil)
+	t.Run("range beyond file size", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
 		req.SetPathValue("bucket", "test-bucket")
 		req.SetPathValue("key", key)
+		req.Header.Set("Range", "bytes=100-200")
 		w := httptest.NewRecorder()
 
 		handlers.GetObject(w, req)
 
-		if w.Code != http.StatusOK {
-			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
-		}
-		// Should use the original content type, not the override
-		if got := w.Header().Get("Content-Type"); got != "text/plain" {
-			t.Errorf("Content-Type = %q, want %q (should not be overridden)", got, "text/plain")
-		}
-		// Should not have Content-Disposition set
-		if got := w.Header().Get("Content-Disposition"); got != "" {
-			t.Errorf("Content-Disposition = %q, want empty (should not be set)", got)
+		if w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusRequestedRangeNotSatisfiable)
 		}
 	})
-}
 
-// Security Tests
+	t.Run("range with partNumber", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?partNumber=1", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		req.Header.Set("Range", "bytes=0-4")
+		w := httptest.NewRecorder()
 
-func TestMetadataValidation(t *testing.T) {
-	t.Run("valid metadata values", func(t *testing.T) {
-		validValues := []string{
-			"normal value",
-			"value with spaces",
-			"123456",
-			"special-chars_allowed.here",
+		handlers.GetObject(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
 		}
-		for _, v := range validValues {
-			if err := validateMetadataValue(v); err != nil {
-				t.Errorf("validateMetadataValue(%q) = %v, want nil", v, err)
-			}
+		if !strings.Contains(w.Body.String(), "<Code>InvalidRequest</Code>") {
+			t.Errorf("body = %q, want InvalidRequest error", w.Body.String())
 		}
 	})
+}
 
-	t.Run("invalid metadata with CRLF injection", func(t *testing.T) {
-		invalidValues := []string{
-			"value\r\nInjected-Header: malicious",
-			"value\rcarriage-return",
-			"value\nnewline",
-			"value\x00null-byte",
-		}
-		for _, v := range invalidValues {
-			if err := validateMetadataValue(v); err == nil {
-				t.Errorf("validateMetadataValue(%q) = nil, want error", v)
+func TestGetObjectIfRange(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	key := "if-range.txt"
+	content := []byte("0123456789ABCDEF")
+	putReq := httptest.NewRequest("PUT", "/test-bucket/"+key, bytes.NewReader(content))
+	putReq.SetPathValue("bucket", "test-bucket")
+	putReq.SetPathValue("key", key)
+	putW := httptest.NewRecorder()
+	handlers.PutObject(putW, putReq)
+	// Clients echo the ETag exactly as they received it, quotes included
+	returned := putW.Header().Get("ETag")
+	if !strings.HasPrefix(returned, `"`) {
+		t.Fatalf("returned ETag %q is not quoted", returned)
+	}
+
+	meta, err := store.HeadObject("test-bucket", key)
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	lastModified := meta.LastModified.UTC()
+
+	tests := []struct {
+		name       string
+		ifRange    string
+		wantStatus int
+		wantBody   string
+	}{
+		{"matching etag", `"` + strings.Trim(meta.ETag, `"`) + `"`, http.StatusPartialContent, "01234"},
+		{"returned etag", returned, http.StatusPartialContent, "01234"},
+		{"unquoted etag", strings.Trim(meta.ETag, `"`), http.StatusPartialContent, "01234"},
+		{"mismatched unquoted etag", "0000000000000000", http.StatusOK, string(content)},
+		{"mismatched etag", `"0000000000000000"`, http.StatusOK, string(content)},
+		{"weak etag", `W/"` + strings.Trim(meta.ETag, `"`) + `"`, http.StatusOK, string(content)},
+		{"current date", lastModified.Add(time.Second).Format(http.TimeFormat), http.StatusPartialContent, "01234"},
+		{"older date", lastModified.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK, string(content)},
+		{"invalid value", "yesterday", http.StatusOK, string(content)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
+			req.SetPathValue("bucket", "test-bucket")
+			req.SetPathValue("key", key)
+			req.Header.Set("Range", "bytes=0-4")
+			req.Header.Set("If-Range", tt.ifRange)
+			w := httptest.NewRecorder()
+
+			handlers.GetObject(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, w.Code)
 			}
-		}
-	})
+			if w.Body.String() != tt.wantBody {
+				t.Errorf("expected body %q, got %q", tt.wantBody, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && w.Header().Get("Content-Range") != "" {
+				t.Errorf("unexpected Content-Range %q on full response", w.Header().Get("Content-Range"))
+			}
+		})
+	}
+}
 
-	t.Run("PutObject rejects invalid metadata", func(t *testing.T) {
-		handlers, _, cleanup := setupTestHandlers(t)
-		defer cleanup()
+// countingStore counts the reads of object metadata and data
+type countingStore struct {
+	storage.MultipartStorage
+	heads, opens, ranges int
+}
 
-		req := httptest.NewRequest("PUT", "/test-bucket/test.txt", bytes.NewReader([]byte("content")))
-		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", "test.txt")
-		req.Header.Set("Content-Type", "text/plain")
-		req.Header.Set("X-Amz-Meta-Malicious", "value\r\nX-Injected: evil")
-		w := httptest.NewRecorder()
+func (s *countingStore) HeadObject(bucket, key string) (*s3.ObjectMetadata, error) {
+	s.heads++
+	return s.MultipartStorage.HeadObject(bucket, key)
+}
 
-		handlers.PutObject(w, req)
+func (s *countingStore) OpenObject(bucket, key string) (io.ReadSeekCloser, *s3.ObjectMetadata, error) {
+	s.opens++
+	return s.MultipartStorage.OpenObject(bucket, key)
+}
 
-		if w.Code != http.StatusBadRequest {
-			t.Errorf("status = %d, want %d for CRLF injection attempt", w.Code, http.StatusBadRequest)
-		}
-	})
+func (s *countingStore) GetObjectRange(ctx context.Context, bucket, key string, start, end int64) (io.ReadCloser, *s3.ObjectMetadata, error) {
+	s.ranges++
+	return s.MultipartStorage.GetObjectRange(ctx, bucket, key, start, end)
 }
 
-func TestRangeHeaderValidation(t *testing.T) {
+func TestGetObjectOpensObjectOnce(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	key := "once.txt"
+	meta, err := store.PutObject(context.Background(), "test-bucket", key, "text/plain", nil, strings.NewReader("0123456789ABCDEF"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
 	tests := []struct {
-		name        string
-		rangeHeader string
-		wantErr     bool
+		name       string
+		header     map[string]string
+		wantStatus int
 	}{
-		// Valid ranges
-		{"valid full range", "bytes=0-100", false},
-		{"valid suffix range", "bytes=-100", false},
-		{"valid open-ended range", "bytes=100-", false},
+		{"full", nil, http.StatusOK},
+		{"single range", map[string]string{"Range": "bytes=0-4"}, http.StatusPartialContent},
+		{"multiple ranges", map[string]string{"Range": "bytes=0-1,4-5"}, http.StatusPartialContent},
+		{"matching If-Range", map[string]string{"Range": "bytes=0-4", "If-Range": meta.ETag}, http.StatusPartialContent},
+		{"stale If-Range", map[string]string{"Range": "bytes=0-4", "If-Range": `"stale"`}, http.StatusOK},
+		{"unsatisfiable range", map[string]string{"Range": "bytes=100-"}, http.StatusRequestedRangeNotSatisfiable},
+	}
 
-		// Invalid ranges
-		{"missing bytes prefix", "0-100", true},
-		{"multiple ranges", "bytes=0-100,200-300", true},
-		{"empty range spec", "bytes=-", true},
-		{"invalid start", "bytes=abc-100", true},
-		{"invalid end", "bytes=0-xyz", true},
-		{"start > end", "bytes=100-50", true},
-		{"very large value", "bytes=0-9999999999999999999999", true},
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counting := &countingStore{MultipartStorage: store}
+			handlers.storage = counting
+
+			req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
+			req.SetPathValue("bucket", "test-bucket")
+			req.SetPathValue("key", key)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+
+			handlers.GetObject(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if counting.opens != 1 || counting.heads != 0 || counting.ranges != 0 {
+				t.Errorf("OpenObject/HeadObject/GetObjectRange calls = %d/%d/%d, want 1/0/0", counting.opens, counting.heads, counting.ranges)
+			}
+		})
+	}
+}
+
+func TestParseRangeHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    []byteRange
+		wantErr bool
+	}{
+		{"simple range", "bytes=0-99", []byteRange{{0, 99}}, false},
+		{"open ended", "bytes=100-", []byteRange{{100, -1}}, false},
+		{"suffix range", "bytes=-50", []byteRange{{-50, -1}}, false},
+		{"multiple ranges", "bytes=0-9, 20-29,-5", []byteRange{{0, 9}, {20, 29}, {-5, -1}}, false},
+		{"invalid prefix", "chars=0-99", nil, true},
+		{"invalid format", "bytes=0", nil, true},
+		{"invalid start", "bytes=abc-99", nil, true},
+		{"invalid end", "bytes=0-xyz", nil, true},
+		{"empty range in list", "bytes=0-9,,20-29", nil, true},
+		{"too many ranges", "bytes=" + strings.Repeat("0-0,", maxRanges) + "0-0", nil, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, _, err := parseRangeHeader(tt.rangeHeader)
+			ranges, err := parseRangeHeader(tt.header)
+
 			if (err != nil) != tt.wantErr {
-				t.Errorf("parseRangeHeader(%q) error = %v, wantErr %v", tt.rangeHeader, err, tt.wantErr)
+				t.Errorf("parseRangeHeader() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(ranges, tt.want) {
+				t.Errorf("ranges = %v, want %v", ranges, tt.want)
 			}
 		})
 	}
 }
 
-func TestMaxKeysValidation(t *testing.T) {
+func TestGetObjectMultiRange(t *testing.T) {
 	handlers, _, cleanup := setupTestHandlers(t)
 	defer cleanup()
 
-	// Create a few test objects
-	for i := 0; i < 5; i++ {
-		key := "test-" + string(rune('a'+i)) + ".txt"
-		req := httptest.NewRequest("PUT", "/test-bucket/"+key, bytes.NewReader([]byte("content")))
+	key := "multi-range.txt"
+	content := []byte("0123456789ABCDEF")
+	putReq := httptest.NewRequest("PUT", "/test-bucket/"+key, bytes.NewReader(content))
+	putReq.SetPathValue("bucket", "test-bucket")
+	putReq.SetPathValue("key", key)
+	putReq.Header.Set("Content-Type", "text/plain")
+	handlers.PutObject(httptest.NewRecorder(), putReq)
+
+	get := func(rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key, nil)
 		req.SetPathValue("bucket", "test-bucket")
 		req.SetPathValue("key", key)
-		handlers.PutObject(httptest.NewRecorder(), req)
+		req.Header.Set("Range", rangeHeader)
+		w := httptest.NewRecorder()
+		handlers.GetObject(w, req)
+		return w
 	}
 
-	t.Run("max-keys capped at 1000", func(t *testing.T) {
-		// Request with max-keys > 1000 should be capped
-		req := httptest.NewRequest("GET", "/test-bucket?list-type=2&max-keys=9999999", nil)
+	t.Run("multipart/byteranges", func(t *testing.T) {
+		w := get("bytes=0-3,10-12,-2")
+
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+		}
+		if w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+			t.Errorf("Content-Length = %q, body is %d bytes", w.Header().Get("Content-Length"), w.Body.Len())
+		}
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Content-Type = %q", w.Header().Get("Content-Type"))
+		}
+
+		want := []struct{ contentRange, body string }{
+			{"bytes 0-3/16", "0123"},
+			{"bytes 10-12/16", "ABC"},
+			{"bytes 14-15/16", "EF"},
+		}
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		for i, wantPart := range want {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("part %d: %v", i, err)
+			}
+			if got := part.Header.Get("Content-Range"); got != wantPart.contentRange {
+				t.Errorf("part %d Content-Range = %q, want %q", i, got, wantPart.contentRange)
+			}
+			if got := part.Header.Get("Content-Type"); got != "text/plain" {
+				t.Errorf("part %d Content-Type = %q, want %q", i, got, "text/plain")
+			}
+			body, _ := io.ReadAll(part)
+			if string(body) != wantPart.body {
+				t.Errorf("part %d body = %q, want %q", i, body, wantPart.body)
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Errorf("expected end of multipart body, got %v", err)
+		}
+	})
+
+	t.Run("single satisfiable range is not multipart", func(t *testing.T) {
+		w := get("bytes=2-4,100-200")
+
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+		}
+		if w.Header().Get("Content-Range") != "bytes 2-4/16" {
+			t.Errorf("Content-Range = %q, want %q", w.Header().Get("Content-Range"), "bytes 2-4/16")
+		}
+		if w.Body.String() != "234" {
+			t.Errorf("body = %q, want %q", w.Body.String(), "234")
+		}
+	})
+
+	t.Run("overlapping and adjacent ranges are merged", func(t *testing.T) {
+		w := get("bytes=8-9,0-2,2-3,4-5")
+
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if w.Code != http.StatusPartialContent || err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+		}
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		for i, want := range []string{"bytes 0-5/16", "bytes 8-9/16"} {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("part %d: %v", i, err)
+			}
+			if got := part.Header.Get("Content-Range"); got != want {
+				t.Errorf("part %d Content-Range = %q, want %q", i, got, want)
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Errorf("expected end of multipart body, got %v", err)
+		}
+
+		// Ranges merging into one are sent as a single range
+		w = get("bytes=4-7,0-4")
+		if w.Code != http.StatusPartialContent || w.Header().Get("Content-Range") != "bytes 0-7/16" || w.Body.String() != "01234567" {
+			t.Errorf("status = %d, Content-Range = %q, body = %q", w.Code, w.Header().Get("Content-Range"), w.Body.String())
+		}
+	})
+
+	t.Run("ranges larger than the object return the whole object", func(t *testing.T) {
+		w := get("bytes=0-9,0-9")
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if w.Header().Get("Content-Range") != "" || w.Body.String() != string(content) {
+			t.Errorf("Content-Range = %q, body = %q", w.Header().Get("Content-Range"), w.Body.String())
+		}
+	})
+
+	t.Run("no satisfiable range", func(t *testing.T) {
+		w := get("bytes=100-200,300-")
+
+		if w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusRequestedRangeNotSatisfiable)
+		}
+	})
+
+	t.Run("too many ranges", func(t *testing.T) {
+		w := get("bytes=" + strings.Repeat("0-0,", maxRanges) + "0-0")
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		if !strings.Contains(w.Body.String(), "InvalidArgument") {
+			t.Errorf("expected InvalidArgument, got %s", w.Body.String())
+		}
+	})
+}
+
+func TestRequireWritePrivilege(t *testing.T) {
+	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("success"))
+	})
+
+	t.Run("allows write privilege", func(t *testing.T) {
+		handler := RequireWritePrivilege(dummyHandler)
+
+		req := httptest.NewRequest("PUT", "/test", nil)
+		cred := &config.Credential{Privileges: config.PrivilegeReadWrite}
+		ctx := context.WithValue(req.Context(), credentialContextKey, cred)
+		req = req.WithContext(ctx)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("denies read-only privilege", func(t *testing.T) {
+		handler := RequireWritePrivilege(dummyHandler)
+
+		req := httptest.NewRequest("PUT", "/test", nil)
+		cred := &config.Credential{Privileges: config.PrivilegeRead}
+		ctx := context.WithValue(req.Context(), credentialContextKey, cred)
+		req = req.WithContext(ctx)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("denies missing credential", func(t *testing.T) {
+		handler := RequireWritePrivilege(dummyHandler)
+
+		req := httptest.NewRequest("PUT", "/test", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+		}
+	})
+}
+
+func TestRequireBucketAccess(t *testing.T) {
+	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := RequireBucketAccess(dummyHandler)
+
+	tests := []struct {
+		name       string
+		cred       *config.Credential
+		bucket     string
+		wantStatus int
+	}{
+		{"unrestricted credential", &config.Credential{}, "any-bucket", http.StatusOK},
+		{"allowed bucket", &config.Credential{Buckets: []string{"tenant-a"}}, "tenant-a", http.StatusOK},
+		{"allowed prefix", &config.Credential{Buckets: []string{"tenant-a-*"}}, "tenant-a-logs", http.StatusOK},
+		{"other bucket", &config.Credential{Buckets: []string{"tenant-a"}}, "tenant-b", http.StatusForbidden},
+		{"missing credential", nil, "tenant-a", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/"+tt.bucket, nil)
+			req.SetPathValue("bucket", tt.bucket)
+			if tt.cred != nil {
+				req = req.WithContext(context.WithValue(req.Context(), credentialContextKey, tt.cred))
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	t.Run("logs request and passes through", func(t *testing.T) {
+		handler := AccessLogMiddleware(nil, 1)(dummyHandler)
+
+		req := httptest.NewRequest("GET", "/test", nil)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if w.Body.String() != "hello" {
+			t.Errorf("body = %q, want %q", w.Body.String(), "hello")
+		}
+	})
+
+	t.Run("extracts client IP from X-Forwarded-For when trusted", func(t *testing.T) {
+		// httptest.NewRequest sets RemoteAddr to "192.0.2.1:1234"
+		handler := AccessLogMiddleware([]string{"192.0.2.1"}, 1)(dummyHandler)
+
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Forwarded-For", "192.168.1.100, 10.0.0.1")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("extracts client IP from X-Real-IP when trusted", func(t *testing.T) {
+		// httptest.NewRequest sets RemoteAddr to "192.0.2.1:1234"
+		handler := AccessLogMiddleware([]string{"192.0.2.1"}, 1)(dummyHandler)
+
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Real-IP", "192.168.1.100")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+}
+
+func TestAccessLogMiddlewareSampling(t *testing.T) {
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(orig)
+
+	status := http.StatusOK
+	handler := AccessLogMiddleware(nil, 5)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+
+	serve := func(n int) {
+		for i := 0; i < n; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test-bucket/key", nil))
+		}
+	}
+
+	t.Run("successes are sampled", func(t *testing.T) {
+		buf.Reset()
+		serve(10)
+		if got := strings.Count(buf.String(), "status=200"); got != 2 {
+			t.Errorf("logged %d of 10 successful requests, want 2", got)
+		}
+	})
+
+	t.Run("errors are always logged", func(t *testing.T) {
+		buf.Reset()
+		status = http.StatusNotFound
+		serve(10)
+		if got := strings.Count(buf.String(), "status=404"); got != 10 {
+			t.Errorf("logged %d of 10 failed requests, want 10", got)
+		}
+	})
+}
+
+func TestAccessLogAccessKey(t *testing.T) {
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(orig)
+
+	cfg := &config.Config{}
+	authenticated := AccessLogMiddleware(nil, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logAuthentication(r, &config.Credential{AccessKeyID: "AKIALOGGED", SecretAccessKey: "secret-never-logged"})
+		w.WriteHeader(http.StatusOK)
+	}))
+	failed := AccessLogMiddleware(nil, 1)(AuthMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unauthenticated request reached the handler")
+	})))
+
+	t.Run("authenticated", func(t *testing.T) {
+		buf.Reset()
+		authenticated.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test-bucket/key", nil))
+		if !strings.Contains(buf.String(), "access_key_id=AKIALOGGED") || !strings.Contains(buf.String(), "presigned=false") {
+			t.Errorf("log = %s", buf.String())
+		}
+		if strings.Contains(buf.String(), "secret-never-logged") {
+			t.Errorf("secret logged: %s", buf.String())
+		}
+	})
+
+	t.Run("presigned", func(t *testing.T) {
+		buf.Reset()
+		authenticated.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test-bucket/key?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test&X-Amz-Signature=test", nil))
+		if !strings.Contains(buf.String(), "presigned=true") {
+			t.Errorf("log = %s", buf.String())
+		}
+	})
+
+	t.Run("failed authentication", func(t *testing.T) {
+		buf.Reset()
+		failed.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test-bucket/key", nil))
+		if !strings.Contains(buf.String(), "access_key_id=anonymous") || !strings.Contains(buf.String(), "status=403") {
+			t.Errorf("log = %s", buf.String())
+		}
+	})
+}
+
+func TestGetClientIP(t *testing.T) {
+	// getClientIP should always return RemoteAddr, ignoring proxy headers
+	// This is a security measure - proxy headers are only trusted via getClientIPWithTrust
+	tests := []struct {
+		name       string
+		xff        string
+		xri        string
+		remoteAddr string
+		want       string
+	}{
+		{"ignores X-Forwarded-For", "192.168.1.1", "", "10.0.0.1:1234", "10.0.0.1"},
+		{"ignores X-Real-IP", "", "192.168.1.1", "10.0.0.1:1234", "10.0.0.1"},
+		{"RemoteAddr with port", "", "", "10.0.0.1:1234", "10.0.0.1"},
+		{"RemoteAddr without port", "", "", "10.0.0.1", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.xri != "" {
+				req.Header.Set("X-Real-IP", tt.xri)
+			}
+			req.RemoteAddr = tt.remoteAddr
+
+			got := getClientIP(req)
+			if got != tt.want {
+				t.Errorf("getClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetClientIPWithTrust(t *testing.T) {
+	// Test proxy header handling with trusted proxy configuration
+	trustedChecker := newTrustedProxyChecker([]string{"10.0.0.1", "172.16.0.0/12"})
+	untrustedChecker := newTrustedProxyChecker(nil)
+
+	t.Run("trusts X-Forwarded-For from trusted proxy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Forwarded-For", "192.168.1.1")
+		req.RemoteAddr = "10.0.0.1:1234"
+
+		got := getClientIPWithTrust(req, trustedChecker)
+		if got != "192.168.1.1" {
+			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "192.168.1.1")
+		}
+	})
+
+	t.Run("trusts X-Real-IP from trusted proxy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Real-IP", "192.168.1.1")
+		req.RemoteAddr = "10.0.0.1:1234"
+
+		got := getClientIPWithTrust(req, trustedChecker)
+		if got != "192.168.1.1" {
+			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "192.168.1.1")
+		}
+	})
+
+	t.Run("ignores proxy headers from untrusted source", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Forwarded-For", "192.168.1.1")
+		req.RemoteAddr = "203.0.113.1:1234" // Not in trusted list
+
+		got := getClientIPWithTrust(req, trustedChecker)
+		if got != "203.0.113.1" {
+			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "203.0.113.1")
+		}
+	})
+
+	t.Run("ignores proxy headers when no trusted proxies configured", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Forwarded-For", "192.168.1.1")
+		req.RemoteAddr = "10.0.0.1:1234"
+
+		got := getClientIPWithTrust(req, untrustedChecker)
+		if got != "10.0.0.1" {
+			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "10.0.0.1")
+		}
+	})
+
+	t.Run("trusts proxy from CIDR range", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Forwarded-For", "192.168.1.1")
+		req.RemoteAddr = "172.20.0.5:1234" // In 172.16.0.0/12
+
+		got := getClientIPWithTrust(req, trustedChecker)
+		if got != "192.168.1.1" {
+			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "192.168.1.1")
+		}
+	})
+
+	t.Run("X-Forwarded-For takes precedence over X-Real-IP", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Forwarded-For", "192.168.1.1")
+		req.Header.Set("X-Real-IP", "192.168.2.2")
+		req.RemoteAddr = "10.0.0.1:1234"
+
+		got := getClientIPWithTrust(req, trustedChecker)
+		if got != "192.168.1.1" {
+			t.Errorf("getClientIPWithTrust() = %q, want %q", got, "192.168.1.1")
+		}
+	})
+}
+
+func TestPresignedResponseHeaderOverrides(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	// Create a test object first
+	key := "presigned-header-test.txt"
+	content := []byte("test content")
+	putReq := httptest.NewRequest("PUT", "/test-bucket/"+key, bytes.NewReader(content))
+	putReq.SetPathValue("bucket", "test-bucket")
+	putReq.SetPathValue("key", key)
+	putReq.Header.Set("Content-Type", "text/plain")
+	handlers.PutObject(httptest.NewRecorder(), putReq)
+
+	t.Run("presigned request with response-content-type", func(t *testing.T) {
+		// Simulate a presigned request by adding the required query parameters
+		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test&X-Amz-Signature=test&response-content-type=application/octet-stream", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+
+		handlers.GetObject(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/octet-stream" {
+			t.Errorf("Content-Type = %q, want %q", got, "application/octet-stream")
+		}
+	})
+
+	t.Run("presigned request with response-content-disposition", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test&X-Amz-Signature=test&response-content-disposition=attachment%3Bfilename%3Ddownload.txt", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+
+		handlers.GetObject(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != "attachment;filename=download.txt" {
+			t.Errorf("Content-Disposition = %q, want %q", got, "attachment;filename=download.txt")
+		}
+	})
+
+	t.Run("presigned request with response-cache-control", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test&X-Amz-Signature=test&response-cache-control=max-age=3600", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+
+		handlers.GetObject(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "max-age=3600" {
+			t.Errorf("Cache-Control = %q, want %q", got, "max-age=3600")
+		}
+	})
+
+	t.Run("presigned request with all response headers", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=test&X-Amz-Signature=test&response-content-type=image/png&response-content-disposition=inline&response-cache-control=no-cache", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+
+		handlers.GetObject(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Content-Type"); got != "image/png" {
+			t.Errorf("Content-Type = %q, want %q", got, "image/png")
+		}
+		if got := w.Header().Get("Content-Disposition"); got != "inline" {
+			t.Errorf("Content-Disposition = %q, want %q", got, "inline")
+		}
+		if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+			t.Errorf("Cache-Control = %q, want %q", got, "no-cache")
+		}
+	})
+
+	t.Run("non-presigned request ignores response header overrides", func(t *testing.T) {
+		// Request without presigned URL parameters
+		req := httptest.NewRequest("GET", "/test-bucket/"+key+"?response-content-type=application/octet-stream&response-content-disposition=attachment", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+
+		handlers.GetObject(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		// Should use the original content type, not the override
+		if got := w.Header().Get("Content-Type"); got != "text/plain" {
+			t.Errorf("Content-Type = %q, want %q (should not be overridden)", got, "text/plain")
+		}
+		// Should not have Content-Disposition set
+		if got := w.Header().Get("Content-Disposition"); got != "" {
+			t.Errorf("Content-Disposition = %q, want empty (should not be set)", got)
+		}
+	})
+}
+
+// Security Tests
+
+func TestMetadataValidation(t *testing.T) {
+	t.Run("valid metadata values", func(t *testing.T) {
+		validValues := []string{
+			"normal value",
+			"value with spaces",
+			"123456",
+			"special-chars_allowed.here",
+		}
+		for _, v := range validValues {
+			if err := validateMetadataValue(v); err != nil {
+				t.Errorf("validateMetadataValue(%q) = %v, want nil", v, err)
+			}
+		}
+	})
+
+	t.Run("invalid metadata with CRLF injection", func(t *testing.T) {
+		invalidValues := []string{
+			"value\r\nInjected-Header: malicious",
+			"value\rcarriage-return",
+			"value\nnewline",
+			"value\x00null-byte",
+		}
+		for _, v := range invalidValues {
+			if err := validateMetadataValue(v); err == nil {
+				t.Errorf("validateMetadataValue(%q) = nil, want error", v)
+			}
+		}
+	})
+
+	t.Run("PutObject rejects invalid metadata", func(t *testing.T) {
+		handlers, _, cleanup := setupTestHandlers(t)
+		defer cleanup()
+
+		req := httptest.NewRequest("PUT", "/test-bucket/test.txt", bytes.NewReader([]byte("content")))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "test.txt")
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Amz-Meta-Malicious", "value\r\nX-Injected: evil")
+		w := httptest.NewRecorder()
+
+		handlers.PutObject(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d for CRLF injection attempt", w.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestRangeHeaderValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		rangeHeader string
+		wantErr     bool
+	}{
+		// Valid ranges
+		{"valid full range", "bytes=0-100", false},
+		{"valid suffix range", "bytes=-100", false},
+		{"valid open-ended range", "bytes=100-", false},
+		{"valid multiple ranges", "bytes=0-100,200-300", false},
+
+		// Invalid ranges
+		{"missing bytes prefix", "0-100", true},
+		{"multiple ranges with invalid part", "bytes=0-100,300-200", true},
+		{"empty range spec", "bytes=-", true},
+		{"invalid start", "bytes=abc-100", true},
+		{"invalid end", "bytes=0-xyz", true},
+		{"start > end", "bytes=100-50", true},
+		{"very large value", "bytes=0-9999999999999999999999", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRangeHeader(tt.rangeHeader)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseRangeHeader(%q) error = %v, wantErr %v", tt.rangeHeader, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaxKeysValidation(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	// Create a few test objects
+	for i := 0; i < 5; i++ {
+		key := "test-" + string(rune('a'+i)) + ".txt"
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, bytes.NewReader([]byte("content")))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		handlers.PutObject(httptest.NewRecorder(), req)
+	}
+
+	t.Run("max-keys capped at 1000", func(t *testing.T) {
+		// Request with max-keys > 1000 should be capped
+		req := httptest.NewRequest("GET", "/test-bucket?list-type=2&max-keys=9999999", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		w := httptest.NewRecorder()
+
+		handlers.ListObjectsV2(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+
+		var result s3.ListBucketResultV2
+		if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+
+		// MaxKeys in response should be capped at 1000
+		if result.MaxKeys > 1000 {
+			t.Errorf("MaxKeys = %d, want <= 1000", result.MaxKeys)
+		}
+	})
+}
+
+func TestErrorResponseNoResourcePath(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	// Request a nonexistent object
+	req := httptest.NewRequest("GET", "/test-bucket/secret/path/file.txt", nil)
+	req.SetPathValue("bucket", "test-bucket")
+	req.SetPathValue("key", "secret/path/file.txt")
+	w := httptest.NewRecorder()
+
+	handlers.GetObject(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	var errResp s3.Error
+	if err := xml.NewDecoder(w.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+
+	// Resource field should be empty to prevent information disclosure
+	if errResp.Resource != "" {
+		t.Errorf("Resource = %q, want empty (information disclosure)", errResp.Resource)
+	}
+}
+
+func TestUploadSizeLimits(t *testing.T) {
+	// Create handlers with a small size limit for testing
+	tmpDir, err := os.MkdirTemp("", "sss-limit-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := &config.Config{
+		Bucket: config.Bucket{Name: "test-bucket"},
+		Storage: config.Storage{
+			Path:          filepath.Join(tmpDir, "data"),
+			MultipartPath: filepath.Join(tmpDir, "multipart"),
+		},
+		Limits: config.Limits{
+			MaxObjectSize: 100, // 100 bytes limit for testing
+			MaxPartSize:   100,
+		},
+	}
+
+	store, err := storage.NewFilesystemStorage(cfg.Storage.Path, cfg.Storage.MultipartPath)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	// Create the test bucket
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("failed to create test bucket: %v", err)
+	}
+
+	handlers := NewHandlers(cfg, store)
+
+	t.Run("object within size limit succeeds", func(t *testing.T) {
+		content := bytes.Repeat([]byte("a"), 50) // 50 bytes, under limit
+		req := httptest.NewRequest("PUT", "/test-bucket/small.txt", bytes.NewReader(content))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "small.txt")
+		w := httptest.NewRecorder()
+
+		handlers.PutObject(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d for object under size limit", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("object exceeding size limit fails", func(t *testing.T) {
+		content := bytes.Repeat([]byte("a"), 150) // 150 bytes, over limit
+		req := httptest.NewRequest("PUT", "/test-bucket/large.txt", bytes.NewReader(content))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "large.txt")
+		w := httptest.NewRecorder()
+
+		handlers.PutObject(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want %d for object over size limit", w.Code, http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	// oversized returns a 150 byte body that counts the bytes read from it
+	oversized := func() *countingReader {
+		return &countingReader{ReadCloser: io.NopCloser(bytes.NewReader(bytes.Repeat([]byte("a"), 150)))}
+	}
+
+	t.Run("oversized Content-Length is rejected without reading the body", func(t *testing.T) {
+		body := oversized()
+		req := httptest.NewRequest("PUT", "/test-bucket/declared.txt", body)
+		req.ContentLength = 5 << 30
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "declared.txt")
+		w := httptest.NewRecorder()
+
+		handlers.PutObject(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge || body.bytesRead != 0 {
+			t.Errorf("status = %d, read %d bytes, want %d without reading", w.Code, body.bytesRead, http.StatusRequestEntityTooLarge)
+		}
+		if _, err := store.HeadObject("test-bucket", "declared.txt"); err == nil {
+			t.Error("oversized object was stored")
+		}
+	})
+
+	t.Run("oversized decoded length of aws-chunked upload is rejected", func(t *testing.T) {
+		body := oversized()
+		req := httptest.NewRequest("PUT", "/test-bucket/chunked.txt", body)
+		req.ContentLength = 200
+		req.Header.Set("Content-Encoding", "aws-chunked")
+		req.Header.Set("X-Amz-Content-Sha256", "STREAMING-UNSIGNED-PAYLOAD-TRAILER")
+		req.Header.Set("X-Amz-Decoded-Content-Length", "150")
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "chunked.txt")
+		w := httptest.NewRecorder()
+
+		handlers.PutObject(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge || body.bytesRead != 0 {
+			t.Errorf("status = %d, read %d bytes, want %d without reading", w.Code, body.bytesRead, http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	t.Run("body without Content-Length is limited while streaming", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/test-bucket/streamed.txt", oversized())
+		req.ContentLength = -1
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "streamed.txt")
+		w := httptest.NewRecorder()
+
+		handlers.PutObject(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	t.Run("oversized part Content-Length is rejected", func(t *testing.T) {
+		uploadID, err := store.CreateMultipartUpload("test-bucket", "parts.txt", "text/plain", nil)
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
+		body := oversized()
+		req := httptest.NewRequest("PUT", "/test-bucket/parts.txt?partNumber=1&uploadId="+uploadID, body)
+		req.ContentLength = 150
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "parts.txt")
+		w := httptest.NewRecorder()
+
+		handlers.PutObject(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge || body.bytesRead != 0 {
+			t.Errorf("status = %d, read %d bytes, want %d without reading", w.Code, body.bytesRead, http.StatusRequestEntityTooLarge)
+		}
+	})
+}
+
+func TestObjectLockHeaders(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	t.Run("retained object", func(t *testing.T) {
+		retainUntil := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+		meta := &s3.ObjectMetadata{
+			ObjectLockMode:            s3.ObjectLockModeCompliance,
+			ObjectLockRetainUntilDate: &retainUntil,
+			ObjectLockLegalHold:       s3.LegalHoldOn,
+		}
+		w := httptest.NewRecorder()
+
+		setObjectLockHeaders(w, meta)
+
+		if got := w.Header().Get("X-Amz-Object-Lock-Mode"); got != "COMPLIANCE" {
+			t.Errorf("X-Amz-Object-Lock-Mode = %q, want %q", got, "COMPLIANCE")
+		}
+		if got := w.Header().Get("X-Amz-Object-Lock-Retain-Until-Date"); got != "2030-01-02T03:04:05.000Z" {
+			t.Errorf("X-Amz-Object-Lock-Retain-Until-Date = %q, want %q", got, "2030-01-02T03:04:05.000Z")
+		}
+		if got := w.Header().Get("X-Amz-Object-Lock-Legal-Hold"); got != "ON" {
+			t.Errorf("X-Amz-Object-Lock-Legal-Hold = %q, want %q", got, "ON")
+		}
+	})
+
+	t.Run("normal object", func(t *testing.T) {
+		key := "unlocked.txt"
+		putReq := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader("content"))
+		putReq.SetPathValue("bucket", "test-bucket")
+		putReq.SetPathValue("key", key)
+		handlers.PutObject(httptest.NewRecorder(), putReq)
+
+		for _, method := range []string{"GET", "HEAD"} {
+			req := httptest.NewRequest(method, "/test-bucket/"+key, nil)
+			req.SetPathValue("bucket", "test-bucket")
+			req.SetPathValue("key", key)
+			w := httptest.NewRecorder()
+
+			if method == "GET" {
+				handlers.GetObject(w, req)
+			} else {
+				handlers.HeadObject(w, req)
+			}
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s status = %d, want %d", method, w.Code, http.StatusOK)
+			}
+			for _, header := range []string{"X-Amz-Object-Lock-Mode", "X-Amz-Object-Lock-Retain-Until-Date", "X-Amz-Object-Lock-Legal-Hold"} {
+				if got := w.Header().Get(header); got != "" {
+					t.Errorf("%s %s = %q, want empty", method, header, got)
+				}
+			}
+		}
+	})
+}
+
+func TestObjectLegalHold(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	key := "held.txt"
+	newRequest := func(method, query string, body io.Reader) *http.Request {
+		req := httptest.NewRequest(method, "/test-bucket/"+key+query, body)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		return req
+	}
+	putLegalHold := func(status string) int {
+		body := `<LegalHold xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>` + status + `</Status></LegalHold>`
+		w := httptest.NewRecorder()
+		handlers.PutObject(w, newRequest("PUT", "?legal-hold", strings.NewReader(body)))
+		return w.Code
+	}
+
+	handlers.PutObject(httptest.NewRecorder(), newRequest("PUT", "", strings.NewReader("content")))
+
+	// No hold has been set yet
+	w := httptest.NewRecorder()
+	handlers.GetObject(w, newRequest("GET", "?legal-hold", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GetObjectLegalHold status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if code := putLegalHold("INVALID"); code != http.StatusBadRequest {
+		t.Errorf("invalid status: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Enable the hold
+	if code := putLegalHold("ON"); code != http.StatusOK {
+		t.Fatalf("PutObjectLegalHold ON status = %d, want %d", code, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	handlers.GetObject(w, newRequest("GET", "?legal-hold", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GetObjectLegalHold status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var legalHold s3.LegalHold
+	if err := xml.Unmarshal(w.Body.Bytes(), &legalHold); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if legalHold.Status != "ON" {
+		t.Errorf("Status = %q, want %q", legalHold.Status, "ON")
+	}
+
+	// Delete and overwrite are blocked while the hold is on
+	w = httptest.NewRecorder()
+	handlers.DeleteObject(w, newRequest("DELETE", "", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("DeleteObject status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = httptest.NewRecorder()
+	handlers.PutObject(w, newRequest("PUT", "", strings.NewReader("overwrite")))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("PutObject status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	// Disable the hold and delete
+	if code := putLegalHold("OFF"); code != http.StatusOK {
+		t.Fatalf("PutObjectLegalHold OFF status = %d, want %d", code, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	handlers.DeleteObject(w, newRequest("DELETE", "", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DeleteObject status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestServerSideEncryptionHeader(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	newRequest := func(method, key string, body io.Reader) *http.Request {
+		req := httptest.NewRequest(method, "/test-bucket/"+key, body)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		return req
+	}
+
+	t.Run("echoed on PUT, GET and HEAD", func(t *testing.T) {
+		putReq := newRequest("PUT", "encrypted.txt", strings.NewReader("content"))
+		putReq.Header.Set("X-Amz-Server-Side-Encryption", "AES256")
+		w := httptest.NewRecorder()
+		handlers.PutObject(w, putReq)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("PUT status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+			t.Errorf("PUT X-Amz-Server-Side-Encryption = %q, want %q", got, "AES256")
+		}
+
+		w = httptest.NewRecorder()
+		handlers.GetObject(w, newRequest("GET", "encrypted.txt", nil))
+		if got := w.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+			t.Errorf("GET X-Amz-Server-Side-Encryption = %q, want %q", got, "AES256")
+		}
+
+		w = httptest.NewRecorder()
+		handlers.HeadObject(w, newRequest("HEAD", "encrypted.txt", nil))
+		if got := w.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+			t.Errorf("HEAD X-Amz-Server-Side-Encryption = %q, want %q", got, "AES256")
+		}
+	})
+
+	t.Run("absent when not requested", func(t *testing.T) {
+		handlers.PutObject(httptest.NewRecorder(), newRequest("PUT", "plain.txt", strings.NewReader("content")))
+
+		w := httptest.NewRecorder()
+		handlers.HeadObject(w, newRequest("HEAD", "plain.txt", nil))
+		if got := w.Header().Get("X-Amz-Server-Side-Encryption"); got != "" {
+			t.Errorf("X-Amz-Server-Side-Encryption = %q, want empty", got)
+		}
+	})
+
+	t.Run("kept and echoed on copy", func(t *testing.T) {
+		copyReq := newRequest("PUT", "encrypted-copy.txt", nil)
+		copyReq.Header.Set("X-Amz-Copy-Source", "/test-bucket/encrypted.txt")
+		w := httptest.NewRecorder()
+		handlers.CopyObject(w, copyReq)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("copy status = %d, body = %s", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+			t.Errorf("copy X-Amz-Server-Side-Encryption = %q, want %q", got, "AES256")
+		}
+
+		w = httptest.NewRecorder()
+		handlers.HeadObject(w, newRequest("HEAD", "encrypted-copy.txt", nil))
+		if got := w.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+			t.Errorf("HEAD X-Amz-Server-Side-Encryption = %q, want %q", got, "AES256")
+		}
+	})
+
+	t.Run("set by copying an object onto itself", func(t *testing.T) {
+		copyReq := newRequest("PUT", "plain.txt", nil)
+		copyReq.Header.Set("X-Amz-Copy-Source", "/test-bucket/plain.txt")
+		copyReq.Header.Set("X-Amz-Server-Side-Encryption", "AES256")
+		w := httptest.NewRecorder()
+		handlers.CopyObject(w, copyReq)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("copy status = %d, body = %s", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+			t.Errorf("copy X-Amz-Server-Side-Encryption = %q, want %q", got, "AES256")
+		}
+
+		w = httptest.NewRecorder()
+		handlers.HeadObject(w, newRequest("HEAD", "plain.txt", nil))
+		if got := w.Header().Get("X-Amz-Server-Side-Encryption"); got != "AES256" {
+			t.Errorf("HEAD X-Amz-Server-Side-Encryption = %q, want %q", got, "AES256")
+		}
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		putReq := newRequest("PUT", "kms.txt", strings.NewReader("content"))
+		putReq.Header.Set("X-Amz-Server-Side-Encryption", "aws:kms")
+		w := httptest.NewRecorder()
+		handlers.PutObject(w, putReq)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestReindexBucket(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if _, err := store.PutObject(context.Background(), "test-bucket", key, "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+
+	newRequest := func(bucket string) *http.Request {
+		req := httptest.NewRequest("POST", "/admin/reindex/"+bucket, nil)
+		req.SetPathValue("bucket", bucket)
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	handlers.ReindexBucket(w, newRequest("test-bucket"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var result storage.ReindexResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if result.Bucket != "test-bucket" || result.Objects != 2 || result.Removed != 0 {
+		t.Errorf("result = %+v, want 2 objects in test-bucket", result)
+	}
+
+	w = httptest.NewRecorder()
+	req := newRequest("missing-bucket")
+	req = req.WithContext(context.WithValue(req.Context(), requestIDContextKey, "req-1"))
+	handlers.ReindexBucket(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing bucket status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("error Content-Type = %q, want application/json", ct)
+	}
+	var errResp map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("failed to parse error response: %v", err)
+	}
+	want := map[string]any{
+		"code":    "NoSuchBucket",
+		"message": "The specified bucket does not exist",
+		"details": map[string]any{"bucket": "missing-bucket", "request_id": "req-1"},
+	}
+	if !reflect.DeepEqual(errResp, want) {
+		t.Errorf("error response = %v, want %v", errResp, want)
+	}
+}
+
+func TestAdminAuthErrors(t *testing.T) {
+	cfg := &config.Config{Credentials: []config.Credential{{AccessKeyID: "AKIA", SecretAccessKey: "secret", Privileges: config.PrivilegeRead}}}
+	handler := AuthMiddleware(cfg)(RequireWritePrivilege(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	for path, wantType := range map[string]string{
+		"/admin/reindex/test-bucket": "application/json",
+		"/test-bucket/key":           "application/xml",
+	} {
+		// Unauthenticated
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != wantType {
+			t.Errorf("%s unauthenticated: status = %d, Content-Type = %q, want %s", path, w.Code, w.Header().Get("Content-Type"), wantType)
+		}
+
+		// Authenticated without write privilege
+		req := httptest.NewRequest("POST", path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), credentialContextKey, &cfg.Credentials[0]))
+		w = httptest.NewRecorder()
+		RequireWritePrivilege(http.NotFoundHandler()).ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != wantType {
+			t.Errorf("%s read-only: status = %d, Content-Type = %q, want %s", path, w.Code, w.Header().Get("Content-Type"), wantType)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/admin/reindex/test-bucket", nil))
+	var errResp adminError
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil || errResp.Code != s3.ErrAccessDenied || errResp.Message == "" {
+		t.Errorf("error response = %s, want JSON AccessDenied", w.Body.String())
+	}
+}
+
+func TestRestoreObject(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := storage.NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), storage.FilesystemOptions{
+		TrashRetention: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	handlers := NewHandlers(&config.Config{}, store)
+
+	put, err := store.PutObject(context.Background(), "test-bucket", "doc.txt", "text/plain", nil, strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := store.DeleteObject("test-bucket", "doc.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+
+	restore := func(bucket, key string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest("POST", "/admin/restore/"+bucket+"/"+key, nil)
+		req.SetPathValue("bucket", bucket)
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		handlers.RestoreObject(w, req)
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		return w, body
+	}
+
+	w, body := restore("test-bucket", "doc.txt")
+	if w.Code != http.StatusOK || body["etag"] != put.ETag {
+		t.Fatalf("restore: status = %d, body = %v; want 200 with etag %s", w.Code, body, put.ETag)
+	}
+	if _, err := store.HeadObject("test-bucket", "doc.txt"); err != nil {
+		t.Errorf("HeadObject after restore failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		bucket, key string
+		status      int
+		code        string
+	}{
+		{"test-bucket", "doc.txt", http.StatusPreconditionFailed, "PreconditionFailed"},
+		{"test-bucket", "never-deleted", http.StatusNotFound, "NoSuchKey"},
+		{"missing-bucket", "doc.txt", http.StatusNotFound, "NoSuchBucket"},
+	} {
+		w, body := restore(tc.bucket, tc.key)
+		if w.Code != tc.status || body["code"] != tc.code {
+			t.Errorf("restore %s/%s: status = %d, body = %v; want %d %s", tc.bucket, tc.key, w.Code, body, tc.status, tc.code)
+		}
+	}
+
+	// Backends without trash do not support restores
+	handlers = NewHandlers(&config.Config{}, storage.NewMemoryStorage())
+	if w, body := restore("test-bucket", "doc.txt"); w.Code != http.StatusNotImplemented || body["code"] != "NotImplemented" {
+		t.Errorf("memory backend: status = %d, body = %v; want 501 NotImplemented", w.Code, body)
+	}
+}
+
+func TestGetObjectServeContent(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1MB
+	if _, err := store.PutObject(context.Background(), "test-bucket", "large.bin", "application/octet-stream", nil, bytes.NewReader(content)); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// Serve through the access log middleware so that the body is copied via
+	// the wrapped writer's ReadFrom, as in the real server
+	server := httptest.NewServer(AccessLogMiddleware(nil, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("bucket", "test-bucket")
+		r.SetPathValue("key", "large.bin")
+		handlers.GetObject(w, r)
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/test-bucket/large.bin")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if resp.ContentLength != int64(len(content)) {
+		t.Errorf("Content-Length = %d, want %d", resp.ContentLength, len(content))
+	}
+	if resp.Header.Get("ETag") == "" || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("missing headers: %v", resp.Header)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	if !bytes.Equal(body, content) {
+		t.Errorf("body differs from stored content (%d bytes, want %d)", len(body), len(content))
+	}
+}
+
+func TestResponseWriterReadFrom(t *testing.T) {
+	w := httptest.NewRecorder()
+	rw := newResponseWriter(w)
+
+	n, err := io.Copy(rw, strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatalf("io.Copy failed: %v", err)
+	}
+	if n != 11 || rw.bytesWritten != 11 {
+		t.Errorf("copied %d bytes, counted %d, want 11", n, rw.bytesWritten)
+	}
+	if w.Body.String() != "hello world" {
+		t.Errorf("body = %q, want %q", w.Body.String(), "hello world")
+	}
+}
+
+func TestCompleteMultipartUploadCreatedBefore(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	key := "guarded.txt"
+	complete := func(bucket, uploadID, createdBefore string) int {
+		part, err := store.UploadPart(context.Background(), uploadID, 1, strings.NewReader("content"))
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
+		body := `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>` + part.ETag + `</ETag></Part></CompleteMultipartUpload>`
+		req := httptest.NewRequest("POST", "/"+bucket+"/"+key+"?uploadId="+uploadID, strings.NewReader(body))
+		req.SetPathValue("bucket", bucket)
+		req.SetPathValue("key", key)
+		if createdBefore != "" {
+			req.Header.Set(uploadCreatedBeforeHeader, createdBefore)
+		}
+		w := httptest.NewRecorder()
+		handlers.CompleteMultipartUpload(w, req)
+		return w.Code
+	}
+	newUpload := func() string {
+		uploadID, err := store.CreateMultipartUpload("test-bucket", key, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
+		return uploadID
+	}
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name          string
+		createdBefore string
+		want          int
+	}{
+		{"created after guard", past.UTC().Format(http.TimeFormat), http.StatusNotFound},
+		{"created after RFC 3339 guard", past.Format(time.RFC3339), http.StatusNotFound},
+		{"invalid guard", "yesterday", http.StatusBadRequest},
+		{"created before guard", future.UTC().Format(http.TimeFormat), http.StatusOK},
+		{"created before RFC 3339 guard", future.Format(time.RFC3339Nano), http.StatusOK},
+		{"no guard", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := complete("test-bucket", newUpload(), tt.createdBefore); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+
+	t.Run("upload in another bucket", func(t *testing.T) {
+		if err := store.CreateBucket("other-bucket"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if code := complete("other-bucket", newUpload(), ""); code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestPutObjectIncompleteBody(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	newPut := func(key, body string, contentLength int64) *http.Request {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader(body))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		req.ContentLength = contentLength
+		return req
+	}
+
+	t.Run("body shorter than Content-Length", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handlers.PutObject(w, newPut("truncated.txt", "only part", 100))
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		if !strings.Contains(w.Body.String(), "IncompleteBody") {
+			t.Errorf("body = %s, want IncompleteBody", w.Body.String())
+		}
+		if _, err := store.HeadObject("test-bucket", "truncated.txt"); !errors.Is(err, storage.ErrObjectNotFound) {
+			t.Errorf("truncated object was stored: %v", err)
+		}
+	})
+
+	t.Run("body matches Content-Length", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handlers.PutObject(w, newPut("complete.txt", "complete", 8))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("truncated upload part", func(t *testing.T) {
+		uploadID, err := store.CreateMultipartUpload("test-bucket", "parts.txt", "text/plain", nil)
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
+		req := httptest.NewRequest("PUT", "/test-bucket/parts.txt?partNumber=1&uploadId="+uploadID, strings.NewReader("short"))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "parts.txt")
+		req.ContentLength = 1024
+		w := httptest.NewRecorder()
+
+		handlers.UploadPart(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("client closes connection early", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.SetPathValue("bucket", "test-bucket")
+			r.SetPathValue("key", "cut.txt")
+			handlers.PutObject(w, r)
+		}))
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		defer conn.Close()
+
+		_, _ = io.WriteString(conn, "PUT /test-bucket/cut.txt HTTP/1.1\r\nHost: localhost\r\nContent-Length: 100\r\n\r\nonly part")
+		_ = conn.(*net.TCPConn).CloseWrite()
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+		if _, err := store.HeadObject("test-bucket", "cut.txt"); !errors.Is(err, storage.ErrObjectNotFound) {
+			t.Errorf("truncated object was stored: %v", err)
+		}
+	})
+}
+
+func TestBucketVersioning(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	do := func(method, target string, body io.Reader, handler http.HandlerFunc, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, body)
+		req.SetPathValue("bucket", "test-bucket")
+		if key != "" {
+			req.SetPathValue("key", key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	put := func(content string) string {
+		w := do("PUT", "/test-bucket/doc.txt", strings.NewReader(content), handlers.PutObject, "doc.txt")
+		if w.Code != http.StatusOK {
+			t.Fatalf("PUT: status = %d, body = %s", w.Code, w.Body.String())
+		}
+		return w.Header().Get("X-Amz-Version-Id")
+	}
+
+	config := `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`
+	if w := do("PUT", "/test-bucket?versioning", strings.NewReader(config), handlers.CreateBucket, ""); w.Code != http.StatusOK {
+		t.Fatalf("PutBucketVersioning: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	w := do("GET", "/test-bucket?versioning", nil, handlers.GetBucket, "")
+	if !strings.Contains(w.Body.String(), "<Status>Enabled</Status>") {
+		t.Errorf("GetBucketVersioning body = %s, want Enabled status", w.Body.String())
+	}
+
+	v1 := put("first")
+	v2 := put("second")
+	if v1 == "" || v1 == v2 {
+		t.Fatalf("version IDs = %q, %q; want distinct IDs", v1, v2)
+	}
+
+	t.Run("get version", func(t *testing.T) {
+		w := do("GET", "/test-bucket/doc.txt?versionId="+v1, nil, handlers.GetObject, "doc.txt")
+		if w.Code != http.StatusOK || w.Body.String() != "first" {
+			t.Errorf("status = %d, body = %q, want first", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("X-Amz-Version-Id"); got != v1 {
+			t.Errorf("X-Amz-Version-Id = %q, want %q", got, v1)
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		w := do("HEAD", "/test-bucket/doc.txt?versionId="+strings.Repeat("0", 32), nil, handlers.HeadObject, "doc.txt")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	var marker string
+	t.Run("delete inserts marker", func(t *testing.T) {
+		w := do("DELETE", "/test-bucket/doc.txt", nil, handlers.DeleteObject, "doc.txt")
+		if w.Code != http.StatusNoContent || w.Header().Get("X-Amz-Delete-Marker") != "true" {
+			t.Fatalf("status = %d, headers = %v", w.Code, w.Header())
+		}
+		marker = w.Header().Get("X-Amz-Version-Id")
+
+		if w := do("GET", "/test-bucket/doc.txt", nil, handlers.GetObject, "doc.txt"); w.Code != http.StatusNotFound {
+			t.Errorf("GET after delete: status = %d, want %d", w.Code, http.StatusNotFound)
+		}
+		w = do("GET", "/test-bucket/doc.txt?versionId="+marker, nil, handlers.GetObject, "doc.txt")
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("X-Amz-Delete-Marker") != "true" {
+			t.Errorf("GET marker: status = %d, headers = %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("list versions", func(t *testing.T) {
+		w := do("GET", "/test-bucket?versions", nil, handlers.GetBucket, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		var result struct {
+			Entries []struct {
+				XMLName   xml.Name
+				VersionId string
+				IsLatest  bool
+			} `xml:",any"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		var got []string
+		for _, e := range result.Entries {
+			if e.VersionId != "" {
+				got = append(got, e.XMLName.Local+":"+e.VersionId+":"+strconv.FormatBool(e.IsLatest))
+			}
+		}
+		want := []string{"DeleteMarker:" + marker + ":true", "Version:" + v2 + ":false", "Version:" + v1 + ":false"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("versions = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("delete marker restores object", func(t *testing.T) {
+		if w := do("DELETE", "/test-bucket/doc.txt?versionId="+marker, nil, handlers.DeleteObject, "doc.txt"); w.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		w := do("GET", "/test-bucket/doc.txt", nil, handlers.GetObject, "doc.txt")
+		if w.Code != http.StatusOK || w.Body.String() != "second" {
+			t.Errorf("status = %d, body = %q, want second", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("invalid status", func(t *testing.T) {
+		config := `<VersioningConfiguration><Status>On</Status></VersioningConfiguration>`
+		w := do("PUT", "/test-bucket?versioning", strings.NewReader(config), handlers.CreateBucket, "")
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "IllegalVersioningConfigurationException") {
+			t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestBucketLifecycle(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	do := func(method, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/test-bucket?lifecycle", strings.NewReader(body))
+		req.SetPathValue("bucket", "test-bucket")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if w := do("GET", "", handlers.GetBucket); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchLifecycleConfiguration") {
+		t.Errorf("GET without configuration: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	config := `<LifecycleConfiguration>
+  <Rule>
+    <ID>logs</ID>
+    <Filter><And><Prefix>logs/</Prefix><Tag><Key>class</Key><Value>temp</Value></Tag></And></Filter>
+    <Status>Enabled</Status>
+    <Expiration><Days>30</Days></Expiration>
+  </Rule>
+  <Rule>
+    <ID>uploads</ID>
+    <Prefix></Prefix>
+    <Status>Disabled</Status>
+    <AbortIncompleteMultipartUpload><DaysAfterInitiation>7</DaysAfterInitiation></AbortIncompleteMultipartUpload>
+  </Rule>
+</LifecycleConfiguration>`
+	if w := do("PUT", config, handlers.CreateBucket); w.Code != http.StatusOK {
+		t.Fatalf("PUT: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	w := do("GET", "", handlers.GetBucket)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var got s3.LifecycleConfiguration
+	if err := xml.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(got.Rules) != 2 {
+		t.Fatalf("rules = %+v, want 2", got.Rules)
+	}
+	logs := got.Rules[0]
+	if logs.ID != "logs" || logs.Status != "Enabled" || logs.Filter.And == nil || logs.Filter.And.Prefix != "logs/" ||
+		len(logs.Filter.And.Tags) != 1 || logs.Expiration == nil || logs.Expiration.Days != 30 {
+		t.Errorf("first rule = %+v", logs)
+	}
+	uploads := got.Rules[1]
+	if uploads.Status != "Disabled" || uploads.AbortIncompleteMultipartUpload == nil || uploads.AbortIncompleteMultipartUpload.DaysAfterInitiation != 7 {
+		t.Errorf("second rule = %+v", uploads)
+	}
+
+	invalid := []struct {
+		name string
+		rule string
+		code string
+	}{
+		{"bad status", `<Status>On</Status><Expiration><Days>1</Days></Expiration>`, "MalformedXML"},
+		{"no action", `<Status>Enabled</Status>`, "InvalidArgument"},
+		{"days and date", `<Status>Enabled</Status><Expiration><Days>1</Days><Date>2030-01-01T00:00:00Z</Date></Expiration>`, "MalformedXML"},
+		{"date not midnight", `<Status>Enabled</Status><Expiration><Date>2030-01-01T12:00:00Z</Date></Expiration>`, "InvalidArgument"},
+		{"negative days", `<Status>Enabled</Status><Expiration><Days>-1</Days></Expiration>`, "InvalidArgument"},
+		{"tag filter on abort", `<Filter><Tag><Key>a</Key><Value>b</Value></Tag></Filter><Status>Enabled</Status><AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload>`, "InvalidArgument"},
+		{"prefix and filter", `<Prefix>a</Prefix><Filter><Prefix>b</Prefix></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration>`, "MalformedXML"},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			w := do("PUT", "<LifecycleConfiguration><Rule>"+tc.rule+"</Rule></LifecycleConfiguration>", handlers.CreateBucket)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tc.code) {
+				t.Errorf("status = %d, body = %s, want %s", w.Code, w.Body.String(), tc.code)
+			}
+		})
+	}
+
+	if w := do("DELETE", "", handlers.DeleteBucket); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "", handlers.GetBucket); w.Code != http.StatusNotFound {
+		t.Errorf("GET after delete: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// chunkRecorder records the size of each write to the response body
+type chunkRecorder struct {
+	*httptest.ResponseRecorder
+	chunks []int
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.chunks = append(c.chunks, len(p))
+	return c.ResponseRecorder.Write(p)
+}
+
+func TestListObjectsV2Streaming(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	const objectCount = 1500
+	for i := 0; i < objectCount; i++ {
+		key := fmt.Sprintf("logs/%05d.log", i)
+		if _, err := store.PutObject(context.Background(), "test-bucket", key, "text/plain", nil, strings.NewReader("x")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+
+	list := func(query string) (*chunkRecorder, s3.ListBucketResultV2) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/test-bucket?list-type=2&"+query, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		w := &chunkRecorder{ResponseRecorder: httptest.NewRecorder()}
+		handlers.ListObjectsV2(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		var result s3.ListBucketResultV2
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return w, result
+	}
+
+	w, first := list("prefix=logs/")
+	if first.KeyCount != maxKeysLimit || len(first.Contents) != maxKeysLimit || !first.IsTruncated || first.NextContinuationToken == "" {
+		t.Fatalf("first page: KeyCount = %d, contents = %d, truncated = %v", first.KeyCount, len(first.Contents), first.IsTruncated)
+	}
+	if first.Name != "test-bucket" || first.Prefix != "logs/" || first.MaxKeys != maxKeysLimit {
+		t.Errorf("first page header = %q, %q, %d", first.Name, first.Prefix, first.MaxKeys)
+	}
+
+	// The body is written in bounded chunks as objects are listed rather
+	// than encoded in one piece at the end
+	largest := 0
+	for _, n := range w.chunks {
+		largest = max(largest, n)
+	}
+	if len(w.chunks) < maxKeysLimit || largest > 4096 {
+		t.Errorf("body written in %d chunks of up to %d bytes, want streamed output", len(w.chunks), largest)
+	}
+
+	_, second := list("prefix=logs/&continuation-token=" + url.QueryEscape(first.NextContinuationToken))
+	if second.KeyCount != objectCount-maxKeysLimit || second.IsTruncated {
+		t.Errorf("second page: KeyCount = %d, truncated = %v", second.KeyCount, second.IsTruncated)
+	}
+	if second.Contents[0].Key != fmt.Sprintf("logs/%05d.log", maxKeysLimit) {
+		t.Errorf("second page starts at %q", second.Contents[0].Key)
+	}
+}
+
+func BenchmarkListObjectsV2(b *testing.B) {
+	tmpDir := b.TempDir()
+	store, err := storage.NewFilesystemStorage(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "tmp"))
+	if err != nil {
+		b.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateBucket("bench-bucket"); err != nil {
+		b.Fatalf("failed to create bucket: %v", err)
+	}
+	for i := 0; i < maxKeysLimit; i++ {
+		if _, err := store.PutObject(context.Background(), "bench-bucket", fmt.Sprintf("object-%05d", i), "text/plain", nil, strings.NewReader("x")); err != nil {
+			b.Fatalf("PutObject failed: %v", err)
+		}
+	}
+	handlers := NewHandlers(&config.Config{}, store)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("GET", "/bench-bucket?list-type=2", nil)
+		req.SetPathValue("bucket", "bench-bucket")
+		w := httptest.NewRecorder()
+		handlers.ListObjectsV2(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("status = %d", w.Code)
+		}
+	}
+}
+
+func TestObjectCreatedHeader(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	head := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("HEAD", "/test-bucket/doc.txt", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "doc.txt")
+		w := httptest.NewRecorder()
+		handlers.HeadObject(w, req)
+		return w
+	}
+
+	first, err := store.PutObject(context.Background(), "test-bucket", "doc.txt", "text/plain", nil, strings.NewReader("v1"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	want := first.Created.UTC().Format(http.TimeFormat)
+	if got := head().Header().Get("X-Sss-Created"); got != want {
+		t.Errorf("X-Sss-Created = %q, want %q", got, want)
+	}
+
+	// An overwrite changes Last-Modified but keeps the creation time
+	req := httptest.NewRequest("PUT", "/test-bucket/doc.txt", strings.NewReader("v2"))
+	req.SetPathValue("bucket", "test-bucket")
+	req.SetPathValue("key", "doc.txt")
+	handlers.PutObject(httptest.NewRecorder(), req)
+
+	w := head()
+	if got := w.Header().Get("X-Sss-Created"); got != want {
+		t.Errorf("X-Sss-Created after overwrite = %q, want %q", got, want)
+	}
+	meta, err := store.HeadObject("test-bucket", "doc.txt")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if !meta.LastModified.After(first.LastModified) {
+		t.Errorf("LastModified = %v, want after %v", meta.LastModified, first.LastModified)
+	}
+}
+
+func TestBucketCors(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	do := func(method, body string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/test-bucket?cors", strings.NewReader(body))
+		req.SetPathValue("bucket", "test-bucket")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if w := do("GET", "", handlers.GetBucket); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchCORSConfiguration") {
+		t.Errorf("GET without configuration: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	for name, body := range map[string]string{
+		"no rules":       `<CORSConfiguration></CORSConfiguration>`,
+		"no origin":      `<CORSConfiguration><CORSRule><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>`,
+		"bad method":     `<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>PATCH</AllowedMethod></CORSRule></CORSConfiguration>`,
+		"two wildcards":  `<CORSConfiguration><CORSRule><AllowedOrigin>https://*.*.com</AllowedOrigin><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>`,
+		"malformed body": `<CORSConfiguration>`,
+	} {
+		if w := do("PUT", body, handlers.CreateBucket); w.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status = %d, want 400", name, w.Code)
+		}
+	}
+
+	config := `<CORSConfiguration>
+  <CORSRule>
+    <ID>web</ID>
+    <AllowedOrigin>https://*.example.com</AllowedOrigin>
+    <AllowedMethod>GET</AllowedMethod>
+    <AllowedMethod>PUT</AllowedMethod>
+    <AllowedHeader>*</AllowedHeader>
+    <ExposeHeader>ETag</ExposeHeader>
+    <MaxAgeSeconds>3000</MaxAgeSeconds>
+  </CORSRule>
+</CORSConfiguration>`
+	if w := do("PUT", config, handlers.CreateBucket); w.Code != http.StatusOK {
+		t.Fatalf("PUT: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	w := do("GET", "", handlers.GetBucket)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var got s3.CORSConfiguration
+	if err := xml.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(got.CORSRules) != 1 {
+		t.Fatalf("rules = %+v, want 1 rule", got.CORSRules)
+	}
+	rule := got.CORSRules[0]
+	if rule.ID != "web" || len(rule.AllowedMethods) != 2 || rule.ExposeHeaders[0] != "ETag" || rule.MaxAgeSeconds == nil || *rule.MaxAgeSeconds != 3000 {
+		t.Errorf("rule = %+v", rule)
+	}
+
+	if w := do("DELETE", "", handlers.DeleteBucket); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "", handlers.GetBucket); w.Code != http.StatusNotFound {
+		t.Errorf("GET after delete: status = %d, want 404", w.Code)
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	_, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	maxAge := 600
+	err := store.PutBucketCORS("test-bucket", []storage.CORSRule{{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"Content-*", "x-amz-*"},
+		ExposeHeaders:  []string{"ETag"},
+		MaxAgeSeconds:  &maxAge,
+	}})
+	if err != nil {
+		t.Fatalf("PutBucketCORS failed: %v", err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := CORSMiddleware(store)(next)
+	do := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("preflight allowed", func(t *testing.T) {
+		w := do("OPTIONS", "/test-bucket/key", map[string]string{
+			"Origin":                         "https://app.example.com",
+			"Access-Control-Request-Method":  "PUT",
+			"Access-Control-Request-Headers": "content-type, X-Amz-Meta-Owner",
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		for header, want := range map[string]string{
+			"Access-Control-Allow-Origin":   "https://app.example.com",
+			"Access-Control-Allow-Methods":  "GET, PUT",
+			"Access-Control-Allow-Headers":  "content-type, X-Amz-Meta-Owner",
+			"Access-Control-Expose-Headers": "ETag",
+			"Access-Control-Max-Age":        "600",
+		} {
+			if got := w.Header().Get(header); got != want {
+				t.Errorf("%s = %q, want %q", header, got, want)
+			}
+		}
+	})
+
+	t.Run("preflight denied", func(t *testing.T) {
+		for name, headers := range map[string]map[string]string{
+			"origin": {"Origin": "https://example.org", "Access-Control-Request-Method": "GET"},
+			"method": {"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"},
+			"header": {"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "Authorization"},
+		} {
+			w := do("OPTIONS", "/test-bucket/key", headers)
+			if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("%s: status = %d, headers = %v", name, w.Code, w.Header())
+			}
+		}
+	})
+
+	t.Run("actual request", func(t *testing.T) {
+		w := do("GET", "/test-bucket/key", map[string]string{"Origin": "https://app.example.com"})
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("Access-Control-Allow-Origin = %q", got)
+		}
+
+		w = do("DELETE", "/test-bucket/key", map[string]string{"Origin": "https://app.example.com"})
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("disallowed method: status = %d, headers = %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("credentials only for listed origins", func(t *testing.T) {
+		if err := store.CreateBucket("public-bucket"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		err := store.PutBucketCORS("public-bucket", []storage.CORSRule{
+			{AllowedOrigins: []string{"https://trusted.example.org"}, AllowedMethods: []string{"GET"}},
+			{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}},
+		})
+		if err != nil {
+			t.Fatalf("PutBucketCORS failed: %v", err)
+		}
+
+		tests := []struct {
+			path, origin          string
+			wantOrigin, wantCreds string
+		}{
+			{"/public-bucket/key", "https://trusted.example.org", "https://trusted.example.org", "true"},
+			{"/public-bucket/key", "https://other.example.net", "*", ""},
+			{"/test-bucket/key", "https://app.example.com", "https://app.example.com", ""},
+		}
+		for _, tt := range tests {
+			w := do("GET", tt.path, map[string]string{"Origin": tt.origin})
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("%s: Access-Control-Allow-Credentials = %q, want %q", tt.origin, got, tt.wantCreds)
+			}
+		}
+	})
+
+	t.Run("no configuration", func(t *testing.T) {
+		if err := store.CreateBucket("plain-bucket"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		w := do("GET", "/plain-bucket/key", map[string]string{"Origin": "https://app.example.com"})
+		if w.Code != http.StatusOK || len(w.Header()) != 0 {
+			t.Errorf("status = %d, headers = %v, want no CORS headers", w.Code, w.Header())
+		}
+	})
+}
+
+func TestOptionsWithoutCORS(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	handler := NewServer(handlers.cfg, store).Handler()
+
+	do := func(path string, headers map[string]string) (*httptest.ResponseRecorder, s3.Error) {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var errResp s3.Error
+		_ = xml.Unmarshal(w.Body.Bytes(), &errResp)
+		return w, errResp
+	}
+	preflight := map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET"}
+
+	for _, path := range []string{"/test-bucket", "/test-bucket/key"} {
+		w, errResp := do(path, preflight)
+		if w.Code != http.StatusForbidden || errResp.Code != s3.ErrCORSForbidden || !strings.Contains(errResp.Message, "CORS is not enabled") {
+			t.Errorf("preflight %s: status = %d, body = %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	w, errResp := do("/missing-bucket/key", preflight)
+	if w.Code != http.StatusForbidden || !strings.Contains(errResp.Message, "Bucket not found") {
+		t.Errorf("preflight to missing bucket: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	w, errResp = do("/test-bucket/key", nil)
+	if w.Code != http.StatusBadRequest || errResp.Code != s3.ErrInvalidRequest || !strings.Contains(errResp.Message, "Origin") {
+		t.Errorf("OPTIONS without Origin: status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	handler := NewServer(handlers.cfg, store).Handler()
+
+	readyz := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w
+	}
+
+	if w := readyz(); w.Code != http.StatusOK {
+		t.Fatalf("readyz status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// A file in place of the multipart directory cannot be written to, even as root
+	multipartPath := handlers.cfg.Storage.MultipartPath
+	if err := os.RemoveAll(multipartPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(multipartPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := readyz()
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "storage not writable" {
+		t.Errorf("readyz with unwritable storage: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("healthz status = %d, want 200", w.Code)
+	}
+}
+
+func TestGetBucketLocation(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	for _, tc := range []struct {
+		region string
+		want   string
+	}{
+		{"us-east-1", ""},
+		{"*", ""},
+		{"", ""},
+		{"eu-north-1", "eu-north-1"},
+	} {
+		handlers.cfg.Server.Region = tc.region
+		req := httptest.NewRequest("GET", "/test-bucket?location", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		w := httptest.NewRecorder()
+		handlers.GetBucket(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("region %q: status = %d, body = %s", tc.region, w.Code, w.Body.String())
+		}
+		var got s3.LocationConstraint
+		if err := xml.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if got.Location != tc.want {
+			t.Errorf("region %q: location = %q, want %q", tc.region, got.Location, tc.want)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/missing-bucket?location", nil)
+	req.SetPathValue("bucket", "missing-bucket")
+	w := httptest.NewRecorder()
+	handlers.GetBucket(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing bucket: status = %d, want 404", w.Code)
+	}
+}
+
+// gzipResponseWriter compresses the body on the fly, as a compressing layer
+// in front of the handlers would
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	g.Header().Del("Content-Length")
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	return g.gz.Write(p)
+}
+
+func TestObjectWeakETag(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	meta, err := store.PutObject(context.Background(), "test-bucket", "page.html", "text/html", nil, strings.NewReader("<html>hello</html>"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	get := func(compress bool, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test-bucket/page.html", nil)
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "page.html")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		if !compress {
+			handlers.GetObject(w, req)
+			return w
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		handlers.GetObject(&gzipResponseWriter{ResponseWriter: w, gz: gz}, req)
+		if w.Code == http.StatusNotModified {
+			return w
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatalf("failed to close gzip writer: %v", err)
+		}
+		return w
+	}
+
+	if got := get(false, nil).Header().Get("ETag"); got != meta.ETag {
+		t.Errorf("verbatim ETag = %q, want strong %q", got, meta.ETag)
+	}
+
+	w := get(true, nil)
+	if got := w.Header().Get("ETag"); got != "W/"+meta.ETag {
+		t.Errorf("compressed ETag = %q, want weak W/%s", got, meta.ETag)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("response is not gzip: %v", err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != "<html>hello</html>" {
+		t.Errorf("decompressed body = %q", body)
+	}
+
+	// If-None-Match uses weak comparison, so either form revalidates
+	for _, etag := range []string{meta.ETag, "W/" + meta.ETag} {
+		w := get(true, map[string]string{"If-None-Match": etag})
+		if w.Code != http.StatusNotModified || w.Header().Get("ETag") != "W/"+meta.ETag {
+			t.Errorf("If-None-Match %s: status = %d, ETag = %q", etag, w.Code, w.Header().Get("ETag"))
+		}
+	}
+
+	// If-Match is evaluated against the stored ETag, as S3 does, and is not
+	// evaluated again against the weak ETag of the response
+	for _, etag := range []string{meta.ETag, "W/" + meta.ETag} {
+		if w := get(true, map[string]string{"If-Match": etag}); w.Code != http.StatusOK {
+			t.Errorf("If-Match %s: status = %d, want %d", etag, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestListObjectsV2EncodingTypeURL(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	for _, key := range []string{"dir one/a&b <c>.txt", "dir one/sub/x", "café+tea.txt"} {
+		if _, err := store.PutObject(context.Background(), "test-bucket", key, "text/plain", nil, strings.NewReader("x")); err != nil {
+			t.Fatalf("PutObject(%q) failed: %v", key, err)
+		}
+	}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test-bucket?list-type=2&"+query, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		w := httptest.NewRecorder()
+		handlers.GetBucket(w, req)
+		return w
+	}
+
+	w := list("encoding-type=url&prefix=dir%20one/&delimiter=/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var result s3.ListBucketResultV2
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if result.EncodingType != "url" || result.Prefix != "dir+one/" || result.Delimiter != "/" {
+		t.Errorf("EncodingType = %q, Prefix = %q, Delimiter = %q", result.EncodingType, result.Prefix, result.Delimiter)
+	}
+	if len(result.Contents) != 1 || result.Contents[0].Key != "dir+one/a%26b+%3Cc%3E.txt" {
+		t.Errorf("Contents = %+v", result.Contents)
+	}
+	if len(result.CommonPrefixes) != 1 || result.CommonPrefixes[0].Prefix != "dir+one/sub/" {
+		t.Errorf("CommonPrefixes = %+v", result.CommonPrefixes)
+	}
+
+	result = s3.ListBucketResultV2{}
+	if err := xml.Unmarshal(list("encoding-type=url&start-after=caf&max-keys=1").Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if len(result.Contents) != 1 || result.Contents[0].Key != "caf%C3%A9%2Btea.txt" || result.StartAfter != "caf" {
+		t.Errorf("Contents = %+v, StartAfter = %q", result.Contents, result.StartAfter)
+	}
+	if key, err := url.QueryUnescape(result.Contents[0].Key); err != nil || key != "café+tea.txt" {
+		t.Errorf("decoded key = %q, %v", key, err)
+	}
+
+	// Without encoding-type the names are sent as they are
+	result = s3.ListBucketResultV2{}
+	if err := xml.Unmarshal(list("prefix=dir%20one/a").Body.Bytes(), &result); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+	if result.EncodingType != "" || len(result.Contents) != 1 || result.Contents[0].Key != "dir one/a&b <c>.txt" {
+		t.Errorf("EncodingType = %q, Contents = %+v", result.EncodingType, result.Contents)
+	}
+
+	if w := list("encoding-type=base64"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidArgument") {
+		t.Errorf("unsupported encoding-type: status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestListObjectsV2FetchOwner(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	if _, err := store.PutObject(context.Background(), "test-bucket", "key.txt", "text/plain", nil, strings.NewReader("x")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	list := func(query string) (string, s3.ListBucketResultV2) {
+		req := httptest.NewRequest("GET", "/test-bucket?list-type=2"+query, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		cred := &config.Credential{AccessKeyID: "AKIAEXAMPLE", Privileges: config.PrivilegeReadWrite}
+		req = req.WithContext(context.WithValue(req.Context(), credentialContextKey, cred))
+		w := httptest.NewRecorder()
+		handlers.GetBucket(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		var result s3.ListBucketResultV2
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		if len(result.Contents) != 1 {
+			t.Fatalf("Contents = %+v", result.Contents)
+		}
+		return w.Body.String(), result
+	}
+
+	for _, query := range []string{"", "&fetch-owner=false"} {
+		if body, _ := list(query); strings.Contains(body, "<Owner>") {
+			t.Errorf("query %q: response has an Owner: %s", query, body)
+		}
+	}
+
+	_, result := list("&fetch-owner=true")
+	owner := result.Contents[0].Owner
+	if owner == nil || owner.DisplayName != "AKIAEXAMPLE" || len(owner.ID) != 64 {
+		t.Errorf("derived Owner = %+v", owner)
+	}
+
+	handlers.cfg.Owner = config.Owner{ID: "owner-id", DisplayName: "Owner"}
+	_, result = list("&fetch-owner=true")
+	if owner := result.Contents[0].Owner; owner == nil || *owner != (s3.Owner{ID: "owner-id", DisplayName: "Owner"}) {
+		t.Errorf("configured Owner = %+v", owner)
+	}
+}
+
+func TestCreateBucketLimit(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	// The test bucket counts towards the limit
+	handlers.cfg.Limits.MaxBuckets = 3
+
+	bucketsGauge := func() string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		promhttp.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if value, ok := strings.CutPrefix(line, "stupid_simple_s3_buckets_total "); ok {
+				return value
+			}
+		}
+		t.Fatal("stupid_simple_s3_buckets_total not exported")
+		return ""
+	}
+	request := func(method, bucket string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/"+bucket, nil)
+		req.SetPathValue("bucket", bucket)
+		w := httptest.NewRecorder()
+		if method == "PUT" {
+			handlers.CreateBucket(w, req)
+		} else {
+			handlers.DeleteBucket(w, req)
+		}
+		return w
+	}
+
+	metrics.BucketsTotal.Set(1)
+	for _, bucket := range []string{"bucket-1", "bucket-2"} {
+		if w := request("PUT", bucket); w.Code != http.StatusOK {
+			t.Fatalf("create %s: status = %d, body = %s", bucket, w.Code, w.Body.String())
+		}
+	}
+	if got := bucketsGauge(); got != "3" {
+		t.Errorf("buckets gauge = %s, want 3", got)
+	}
+
+	w := request("PUT", "bucket-3")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>TooManyBuckets</Code>") {
+		t.Errorf("create over limit: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if exists, _ := store.BucketExists("bucket-3"); exists {
+		t.Error("bucket over the limit was created")
+	}
+
+	// Creating an existing bucket reports it as such
+	if w := request("PUT", "bucket-1"); !strings.Contains(w.Body.String(), "<Code>BucketAlreadyOwnedByYou</Code>") {
+		t.Errorf("create existing bucket: status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	if w := request("DELETE", "bucket-2"); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if got := bucketsGauge(); got != "2" {
+		t.Errorf("buckets gauge after delete = %s, want 2", got)
+	}
+	if w := request("PUT", "bucket-3"); w.Code != http.StatusOK {
+		t.Errorf("create after delete: status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestListObjectsV2ContinuationToken(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	for _, key := range []string{"docs/a.txt", "docs/b.txt", "docs/c.txt"} {
+		if _, err := store.PutObject(context.Background(), "test-bucket", key, "text/plain", nil, strings.NewReader("x")); err != nil {
+			t.Fatalf("PutObject(%q) failed: %v", key, err)
+		}
+	}
+
+	list := func(query string) (*httptest.ResponseRecorder, s3.ListBucketResultV2) {
+		req := httptest.NewRequest("GET", "/test-bucket?list-type=2&max-keys=1&"+query, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		w := httptest.NewRecorder()
+		handlers.GetBucket(w, req)
+		var result s3.ListBucketResultV2
+		if w.Code == http.StatusOK {
+			if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+		}
+		return w, result
+	}
+
+	_, first := list("prefix=docs/")
+	token := first.NextContinuationToken
+	if !first.IsTruncated || token == "" {
+		t.Fatalf("first page = %+v", first)
+	}
+
+	w, second := list("prefix=docs/&continuation-token=" + url.QueryEscape(token))
+	if w.Code != http.StatusOK || len(second.Contents) != 1 || second.Contents[0].Key != "docs/b.txt" {
+		t.Fatalf("second page: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if second.ContinuationToken != token {
+		t.Errorf("ContinuationToken = %q, want the token as sent", second.ContinuationToken)
+	}
+
+	// The final page echoes its own token and has no next one
+	lastToken := second.NextContinuationToken
+	w, last := list("prefix=docs/&continuation-token=" + url.QueryEscape(lastToken))
+	if w.Code != http.StatusOK || len(last.Contents) != 1 || last.Contents[0].Key != "docs/c.txt" || last.IsTruncated {
+		t.Fatalf("last page: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if last.ContinuationToken != lastToken {
+		t.Errorf("ContinuationToken = %q, want the token as sent", last.ContinuationToken)
+	}
+	if strings.Contains(w.Body.String(), "<NextContinuationToken>") {
+		t.Errorf("last page has a NextContinuationToken: %s", w.Body.String())
+	}
+
+	payload, mac, _ := strings.Cut(token, ".")
+	forged := handlers.signContinuationToken(listPosition{Position: "x", Prefix: "docs/"})
+	_, forgedMAC, _ := strings.Cut(forged, ".")
+	for name, query := range map[string]string{
+		"garbage":             "prefix=docs/&continuation-token=not-a-token",
+		"plain base64 key":    "prefix=docs/&continuation-token=" + url.QueryEscape("ZG9jcy9hLnR4dA=="),
+		"altered signature":   "prefix=docs/&continuation-token=" + url.QueryEscape(payload+"."+forgedMAC),
+		"truncated":           "prefix=docs/&continuation-token=" + url.QueryEscape(payload+"."+mac[:10]),
+		"different prefix":    "prefix=doc&continuation-token=" + url.QueryEscape(token),
+		"different delimiter": "prefix=docs/&delimiter=/&continuation-token=" + url.QueryEscape(token),
+	} {
+		w, _ := list(query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>InvalidArgument</Code>") {
+			t.Errorf("%s: status = %d, body = %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	// Tokens stay valid for a new handler with the same configuration
+	handlers.cfg.Auth.ContinuationTokenSecret = "secret"
+	signed := NewHandlers(handlers.cfg, store).signContinuationToken(listPosition{Position: "x"})
+	if got := NewHandlers(handlers.cfg, store).signContinuationToken(listPosition{Position: "x"}); got != signed {
+		t.Errorf("token signed after restart = %q, want %q", got, signed)
+	}
+}
+
+func TestListObjectsV2Metadata(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	if _, err := store.PutObject(context.Background(), "test-bucket", "report.csv", "text/csv", map[string]string{"author": "alice", "odd*name": "x"}, strings.NewReader("x")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	for i := 0; i < maxKeysWithMetadata; i++ {
+		if _, err := store.PutObject(context.Background(), "test-bucket", fmt.Sprintf("zz/%03d", i), "text/plain", nil, strings.NewReader("x")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+
+	list := func(query string) (string, s3.ListBucketResultV2) {
+		req := httptest.NewRequest("GET", "/test-bucket?list-type=2&"+query, nil)
+		req.SetPathValue("bucket", "test-bucket")
+		w := httptest.NewRecorder()
+		handlers.GetBucket(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		var result s3.ListBucketResultV2
+		if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+		return w.Body.String(), result
+	}
+
+	// The extension is disabled by default
+	if body, _ := list("metadata=true&prefix=report"); strings.Contains(body, "UserMetadata") {
+		t.Errorf("metadata listed while disabled: %s", body)
+	}
+
+	handlers.cfg.Limits.ListMetadata = true
+	body, result := list("metadata=true&prefix=report")
+	if len(result.Contents) != 1 {
+		t.Fatalf("Contents = %+v", result.Contents)
+	}
+	want := s3.UserMetadata{"content-type": "text/csv", "X-Amz-Meta-Author": "alice"}
+	if got := result.Contents[0].UserMetadata; !maps.Equal(got, want) {
+		t.Errorf("UserMetadata = %v, want %v (body %s)", got, want, body)
+	}
+
+	if body, _ := list("prefix=report"); strings.Contains(body, "UserMetadata") {
+		t.Errorf("metadata listed without metadata=true: %s", body)
+	}
+
+	_, result = list("metadata=true&max-keys=1000")
+	if result.MaxKeys != maxKeysWithMetadata || len(result.Contents) != maxKeysWithMetadata || !result.IsTruncated {
+		t.Errorf("MaxKeys = %d with %d objects, truncated %v, want %d", result.MaxKeys, len(result.Contents), result.IsTruncated, maxKeysWithMetadata)
+	}
+}
+
+func TestMinFreeBytes(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	request := func(method, target string, handle func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader("hello"))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "key.txt")
+		w := httptest.NewRecorder()
+		handle(w, req)
+		return w
+	}
+
+	// No volume has this much free space
+	handlers.cfg.Limits.MinFreeBytes = math.MaxInt64
+	for _, tc := range []struct {
+		name   string
+		method string
+		target string
+		handle func(http.ResponseWriter, *http.Request)
+	}{
+		{"put object", "PUT", "/test-bucket/key.txt", handlers.PutObject},
+		{"create multipart upload", "POST", "/test-bucket/key.txt?uploads", handlers.CreateMultipartUpload},
+		{"upload part", "PUT", "/test-bucket/key.txt?uploadId=upload&partNumber=1", handlers.UploadPart},
+	} {
+		w := request(tc.method, tc.target, tc.handle)
+		var errResp s3.Error
+		_ = xml.Unmarshal(w.Body.Bytes(), &errResp)
+		if w.Code != http.StatusServiceUnavailable || errResp.Code != s3.ErrServiceUnavailable {
+			t.Errorf("%s: status = %d, body = %s", tc.name, w.Code, w.Body.String())
+		}
+	}
+
+	handlers.cfg.Limits.MinFreeBytes = 1
+	if w := request("PUT", "/test-bucket/key.txt", handlers.PutObject); w.Code != http.StatusOK {
+		t.Errorf("put object with free space: status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLongMetadataValues(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	// Send the values through a real server, so that net/http's header
+	// handling is exercised on the way in and out
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("bucket", "test-bucket")
+		r.SetPathValue("key", "meta.txt")
+		switch r.Method {
+		case "PUT":
+			handlers.PutObject(w, r)
+		case "HEAD":
+			handlers.HeadObject(w, r)
+		default:
+			handlers.GetObject(w, r)
+		}
+	}))
+	defer server.Close()
+
+	long := strings.Repeat("a", maxMetadataHeaderValue)
+	tooLong := strings.Repeat("b", 64*1024)
+
+	req, _ := http.NewRequest("PUT", server.URL+"/test-bucket/meta.txt", strings.NewReader("hello"))
+	req.Header.Set("x-amz-meta-long", long)
+	req.Header.Set("x-amz-meta-too-long", tooLong)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT status = %d", resp.StatusCode)
+	}
+
+	meta, err := store.HeadObject("test-bucket", "meta.txt")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if meta.UserMetadata["long"] != long || meta.UserMetadata["too-long"] != tooLong {
+		t.Errorf("stored metadata lengths = %d, %d, want %d, %d",
+			len(meta.UserMetadata["long"]), len(meta.UserMetadata["too-long"]), len(long), len(tooLong))
+	}
+
+	for _, method := range []string{"GET", "HEAD"} {
+		req, _ := http.NewRequest(method, server.URL+"/test-bucket/meta.txt", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("x-amz-meta-long"); got != long {
+			t.Errorf("%s x-amz-meta-long has %d bytes, want %d", method, len(got), len(long))
+		}
+		if got := resp.Header.Get("x-amz-meta-too-long"); got != "" {
+			t.Errorf("%s returned x-amz-meta-too-long with %d bytes, want none", method, len(got))
+		}
+		if got := resp.Header.Get(missingMetaHeader); got != "1" {
+			t.Errorf("%s %s = %q, want 1", method, missingMetaHeader, got)
+		}
+	}
+}
+
+// slowStore delays the first read of every object it opens, and every
+// multipart completion
+type slowStore struct {
+	storage.MultipartStorage
+	delay time.Duration
+}
+
+func (s *slowStore) OpenObject(bucket, key string) (io.ReadSeekCloser, *s3.ObjectMetadata, error) {
+	reader, meta, err := s.MultipartStorage.OpenObject(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &slowReader{ReadSeekCloser: reader, delay: s.delay}, meta, nil
+}
+
+func (s *slowStore) CompleteMultipartUpload(ctx context.Context, uploadID string, parts []s3.CompletedPartInput) (*s3.ObjectMetadata, error) {
+	time.Sleep(s.delay)
+	return s.MultipartStorage.CompleteMultipartUpload(ctx, uploadID, parts)
+}
+
+type slowReader struct {
+	io.ReadSeekCloser
+	delay time.Duration
+	slept bool
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if !r.slept {
+		time.Sleep(r.delay)
+		r.slept = true
+	}
+	return r.ReadSeekCloser.Read(p)
+}
+
+func TestShutdownDrainsDownloads(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		drainTimeout time.Duration
+		complete     bool
+	}{
+		{"download completes within drain timeout", 5 * time.Second, true},
+		{"download is cut off at drain timeout", 200 * time.Millisecond, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handlers, store, cleanup := setupTestHandlers(t)
+			defer cleanup()
+			content := strings.Repeat("x", 1024)
+			if _, err := store.PutObject(context.Background(), "test-bucket", "slow.txt", "text/plain", nil, strings.NewReader(content)); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+
+			cfg := handlers.cfg
+			cfg.Server.ShutdownTimeout = 50 * time.Millisecond
+			cfg.Server.DrainTimeout = tc.drainTimeout
+			server := NewServer(cfg, &slowStore{MultipartStorage: store, delay: time.Second})
+			server.httpServer = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.SetPathValue("bucket", "test-bucket")
+				r.SetPathValue("key", "slow.txt")
+				server.handlers.GetObject(w, r)
+			})}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go func() { _ = server.httpServer.Serve(listener) }()
+
+			type result struct {
+				body string
+				err  error
+			}
+			done := make(chan result, 1)
+			go func() {
+				resp, err := http.Get("http://" + listener.Addr().String() + "/test-bucket/slow.txt")
+				if err != nil {
+					done <- result{err: err}
+					return
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				done <- result{string(body), err}
+			}()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for server.handlers.activeDownloads.Load() == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("download did not start")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+			defer cancel()
+			shutdownErr := server.Shutdown(ctx)
+			res := <-done
+
+			if tc.complete {
+				if shutdownErr != nil {
+					t.Errorf("Shutdown returned %v, want nil", shutdownErr)
+				}
+				if res.err != nil || res.body != content {
+					t.Errorf("download got %d bytes, err %v, want complete body", len(res.body), res.err)
+				}
+			} else {
+				if shutdownErr == nil {
+					t.Error("Shutdown returned nil, want an error for the cut off download")
+				}
+				if res.err == nil && res.body == content {
+					t.Error("download completed, want it cut off")
+				}
+			}
+		})
+	}
+}
+
+func TestShutdownDrainsUploads(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	uploadID, err := store.CreateMultipartUpload("test-bucket", "slow.txt", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	part, err := store.UploadPart(context.Background(), uploadID, 1, strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+
+	cfg := handlers.cfg
+	cfg.Server.ShutdownTimeout = 50 * time.Millisecond
+	cfg.Server.DrainTimeout = 5 * time.Second
+	server := NewServer(cfg, &slowStore{MultipartStorage: store, delay: time.Second})
+	server.httpServer = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("bucket", "test-bucket")
+		r.SetPathValue("key", "slow.txt")
+		server.handlers.CompleteMultipartUpload(w, r)
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.httpServer.Serve(listener) }()
+
+	done := make(chan int, 1)
+	go func() {
+		body := fmt.Sprintf("<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>", part.ETag)
+		resp, err := http.Post("http://"+listener.Addr().String()+"/test-bucket/slow.txt?uploadId="+uploadID, "application/xml", strings.NewReader(body))
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.handlers.activeUploads.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("completion did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown returned %v, want nil", err)
+	}
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("CompleteMultipartUpload status = %d, want 200", status)
+	}
+	if _, err := store.HeadObject("test-bucket", "slow.txt"); err != nil {
+		t.Errorf("HeadObject after shutdown: %v", err)
+	}
+}
+
+func TestKeyTooLong(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	handler := RejectLongKeys(http.HandlerFunc(handlers.PutObject))
+	put := func(key string) (*httptest.ResponseRecorder, s3.Error) {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader("content"))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var errResp s3.Error
+		_ = xml.Unmarshal(w.Body.Bytes(), &errResp)
+		return w, errResp
+	}
+
+	if w, _ := put(strings.Repeat("k", 1024)); w.Code != http.StatusOK {
+		t.Errorf("1024-byte key: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	w, errResp := put(strings.Repeat("k", 1025))
+	if w.Code != http.StatusBadRequest || errResp.Code != s3.ErrKeyTooLong {
+		t.Errorf("1025-byte key: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if _, err := store.HeadObject("test-bucket", strings.Repeat("k", 1025)); !errors.Is(err, storage.ErrKeyTooLong) {
+		t.Errorf("HeadObject err = %v, want ErrKeyTooLong", err)
+	}
+
+	// Keys in request bodies are validated by storage
+	deleteXML := "<Delete><Object><Key>" + strings.Repeat("k", 1025) + "</Key></Object></Delete>"
+	req := httptest.NewRequest("POST", "/test-bucket?delete", strings.NewReader(deleteXML))
+	req.SetPathValue("bucket", "test-bucket")
+	w = httptest.NewRecorder()
+	handlers.DeleteObjects(w, req)
+	var result s3.DeleteObjectsResult
+	if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(result.Error) != 1 || result.Error[0].Code != string(s3.ErrKeyTooLong) {
+		t.Errorf("DeleteObjects errors = %+v, want KeyTooLongError", result.Error)
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	handlers.cfg.Server.MaxConnections = 1
+	server := NewServer(handlers.cfg, store)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve(listener) }()
+	defer func() { _ = server.Shutdown(context.Background()) }()
+
+	// healthz sends a request on conn and reports whether it was answered
+	healthz := func(conn net.Conn) error {
+		if _, err := conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: localhost\r\n\r\n")); err != nil {
+			return err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("status = %d", resp.StatusCode)
+		}
+		return nil
+	}
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		return conn
+	}
+
+	first := dial()
+	if err := healthz(first); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+
+	second := dial()
+	if err := healthz(second); err == nil {
+		t.Error("connection beyond the limit was served")
+	}
+	second.Close()
+
+	// The open connection keeps working
+	if err := healthz(first); err != nil {
+		t.Errorf("first connection after the limit was reached: %v", err)
+	}
+
+	// Closing it frees its slot
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		third := dial()
+		err := healthz(third)
+		third.Close()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection after the first was closed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUnsupportedSubresources(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	do := func(handler http.HandlerFunc, method, target, key string) (*httptest.ResponseRecorder, s3.Error) {
+		req := httptest.NewRequest(method, target, strings.NewReader("content"))
 		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
 		w := httptest.NewRecorder()
+		handler(w, req)
+		var errResp s3.Error
+		_ = xml.Unmarshal(w.Body.Bytes(), &errResp)
+		return w, errResp
+	}
+
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		method  string
+		target  string
+		key     string
+	}{
+		{handlers.GetBucket, "GET", "/test-bucket?acl", ""},
+		{handlers.GetBucket, "GET", "/test-bucket?policy", ""},
+		{handlers.GetBucket, "GET", "/test-bucket?uploads", ""},
+		{handlers.CreateBucket, "PUT", "/test-bucket?website", ""},
+		{handlers.DeleteBucket, "DELETE", "/test-bucket?tagging", ""},
+		{handlers.PostBucket, "POST", "/test-bucket?replication", ""},
+		{handlers.GetObject, "GET", "/test-bucket/doc.txt?tagging", "doc.txt"},
+		{handlers.PutObject, "PUT", "/test-bucket/doc.txt?acl", "doc.txt"},
+		{handlers.DeleteObject, "DELETE", "/test-bucket/doc.txt?retention", "doc.txt"},
+		{handlers.PostObject, "POST", "/test-bucket/doc.txt?restore", "doc.txt"},
+	} {
+		w, errResp := do(tc.handler, tc.method, tc.target, tc.key)
+		if w.Code != http.StatusNotImplemented || errResp.Code != s3.ErrNotImplemented {
+			t.Errorf("%s %s: status = %d, body = %s", tc.method, tc.target, w.Code, w.Body.String())
+		}
+	}
+
+	// The request was not handled as a plain write
+	if _, err := store.HeadObject("test-bucket", "doc.txt"); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("PUT ?acl stored the object: err = %v", err)
+	}
+
+	// Unknown calls are still malformed requests
+	w, errResp := do(handlers.PostBucket, "POST", "/test-bucket?bogus", "")
+	if w.Code != http.StatusBadRequest || errResp.Code != s3.ErrInvalidRequest {
+		t.Errorf("POST ?bogus: status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDecodeXMLItems(t *testing.T) {
+	var complete s3.CompleteMultipartUpload
+	body := `<CompleteMultipartUpload xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Part><PartNumber>1</PartNumber><ETag>"a"</ETag></Part><Part><PartNumber>2</PartNumber><ETag>"b"</ETag></Part></CompleteMultipartUpload>`
+	if err := decodeXMLItems(strings.NewReader(body), 1<<20, 2, &complete); err != nil {
+		t.Fatalf("decodeXMLItems failed: %v", err)
+	}
+	if len(complete.Parts) != 2 || complete.Parts[1].PartNumber != 2 || complete.Parts[1].ETag != `"b"` {
+		t.Errorf("parts = %+v", complete.Parts)
+	}
+
+	if err := decodeXMLItems(strings.NewReader(body), 1<<20, 1, &complete); !errors.Is(err, errTooManyXMLItems) {
+		t.Errorf("err = %v, want errTooManyXMLItems", err)
+	}
+
+	// A body that fits the size limit with far more items than allowed is
+	// rejected once the limit is passed, not after decoding everything
+	huge := "<CompleteMultipartUpload>" + strings.Repeat("<Part></Part>", 70000) + "</CompleteMultipartUpload>"
+	reader := &countingReader{ReadCloser: io.NopCloser(strings.NewReader(huge))}
+	if err := decodeXMLItems(reader, 1<<20, maxParts, &complete); !errors.Is(err, errTooManyXMLItems) {
+		t.Fatalf("err = %v, want errTooManyXMLItems", err)
+	}
+	if reader.bytesRead >= int64(len(huge))/2 {
+		t.Errorf("read %d of %d bytes before rejecting the body", reader.bytesRead, len(huge))
+	}
 
-		handlers.ListObjectsV2(w, req)
+	// Mismatched elements are still malformed
+	if err := decodeXMLItems(strings.NewReader("<Delete><Object></Delete>"), 1<<20, 10, &s3.Delete{}); err == nil || errors.Is(err, errTooManyXMLItems) {
+		t.Errorf("err = %v, want a syntax error", err)
+	}
+}
 
-		if w.Code != http.StatusOK {
-			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
-		}
+func TestCompleteMultipartUploadTooManyParts(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
 
-		var result s3.ListBucketResultV2
-		if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
-			t.Fatalf("failed to decode response: %v", err)
-		}
+	uploadID, err := store.CreateMultipartUpload("test-bucket", "parts.bin", "application/octet-stream", nil)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
 
-		// MaxKeys in response should be capped at 1000
-		if result.MaxKeys > 1000 {
-			t.Errorf("MaxKeys = %d, want <= 1000", result.MaxKeys)
+	var body strings.Builder
+	body.WriteString("<CompleteMultipartUpload>")
+	for i := 1; i <= maxParts+1; i++ {
+		fmt.Fprintf(&body, "<Part><PartNumber>%d</PartNumber><ETag>x</ETag></Part>", i)
+	}
+	body.WriteString("</CompleteMultipartUpload>")
+
+	req := httptest.NewRequest("POST", "/test-bucket/parts.bin?uploadId="+uploadID, strings.NewReader(body.String()))
+	req.SetPathValue("bucket", "test-bucket")
+	req.SetPathValue("key", "parts.bin")
+	w := httptest.NewRecorder()
+	handlers.CompleteMultipartUpload(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>MalformedXML</Code>") {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+// slowZeroReader returns zeros forever, sleeping before the first read
+type slowZeroReader struct {
+	delay time.Duration
+}
+
+func (r *slowZeroReader) Read(p []byte) (int, error) {
+	if r.delay > 0 {
+		time.Sleep(r.delay)
+		r.delay = 0
+	}
+	clear(p)
+	return len(p), nil
+}
+
+func TestRequestTimeout(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	handler := TimeoutMiddleware(time.Millisecond)(http.HandlerFunc(handlers.PutObject))
+	req := httptest.NewRequest("PUT", "/test-bucket/slow.bin", &slowZeroReader{delay: 20 * time.Millisecond})
+	req.SetPathValue("bucket", "test-bucket")
+	req.SetPathValue("key", "slow.bin")
+	req.ContentLength = 1 << 30
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>RequestTimeout</Code>") {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if _, err := store.HeadObject("test-bucket", "slow.bin"); !errors.Is(err, storage.ErrObjectNotFound) {
+		t.Errorf("HeadObject error = %v, want ErrObjectNotFound", err)
+	}
+
+	// A zero timeout leaves the request context alone
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("request context has a deadline")
 		}
 	})
+	TimeoutMiddleware(0)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
 }
 
-func TestErrorResponseNoResourcePath(t *testing.T) {
+func TestBucketUsageMetrics(t *testing.T) {
 	handlers, _, cleanup := setupTestHandlers(t)
 	defer cleanup()
 
-	// Request a nonexistent object
-	req := httptest.NewRequest("GET", "/test-bucket/secret/path/file.txt", nil)
+	gauge := func(name string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		promhttp.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if value, ok := strings.CutPrefix(line, name+`{bucket="test-bucket"} `); ok {
+				return value
+			}
+		}
+		return ""
+	}
+	put := func(key, body string) {
+		t.Helper()
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader(body))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", key)
+		w := httptest.NewRecorder()
+		handlers.PutObject(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("PutObject: status = %d, body = %s", w.Code, w.Body.String())
+		}
+	}
+
+	put("a.txt", "hello")
+	put("b.txt", "world!")
+	put("a.txt", "hi")
+	if objects, bytes := gauge("stupid_simple_s3_objects_total"), gauge("stupid_simple_s3_bytes_stored_total"); objects != "2" || bytes != "8" {
+		t.Errorf("after puts: objects = %q, bytes = %q, want 2 and 8", objects, bytes)
+	}
+
+	req := httptest.NewRequest("DELETE", "/test-bucket/b.txt", nil)
 	req.SetPathValue("bucket", "test-bucket")
-	req.SetPathValue("key", "secret/path/file.txt")
+	req.SetPathValue("key", "b.txt")
 	w := httptest.NewRecorder()
+	handlers.DeleteObject(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DeleteObject: status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if objects, bytes := gauge("stupid_simple_s3_objects_total"), gauge("stupid_simple_s3_bytes_stored_total"); objects != "1" || bytes != "2" {
+		t.Errorf("after delete: objects = %q, bytes = %q, want 1 and 2", objects, bytes)
+	}
+}
 
-	handlers.GetObject(w, req)
+func TestAcceptRangesHeader(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
 
-	if w.Code != http.StatusNotFound {
-		t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+	if _, err := store.PutObject(context.Background(), "test-bucket", "ranges.txt", "text/plain", nil, strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
 	}
 
-	var errResp s3.Error
-	if err := xml.NewDecoder(w.Body).Decode(&errResp); err != nil {
-		t.Fatalf("failed to decode error response: %v", err)
+	tests := []struct {
+		name       string
+		method     string
+		rangeValue string
+		wantStatus int
+	}{
+		{"HEAD", "HEAD", "", http.StatusOK},
+		{"ranged HEAD", "HEAD", "bytes=0-3", http.StatusPartialContent},
+		{"GET", "GET", "", http.StatusOK},
+		{"ranged GET", "GET", "bytes=0-3", http.StatusPartialContent},
+		{"multi-range GET", "GET", "bytes=0-1,5-6", http.StatusPartialContent},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/test-bucket/ranges.txt", nil)
+			req.SetPathValue("bucket", "test-bucket")
+			req.SetPathValue("key", "ranges.txt")
+			if tt.rangeValue != "" {
+				req.Header.Set("Range", tt.rangeValue)
+			}
+			w := httptest.NewRecorder()
+			if tt.method == "HEAD" {
+				handlers.HeadObject(w, req)
+			} else {
+				handlers.GetObject(w, req)
+			}
 
-	// Resource field should be empty to prevent information disclosure
-	if errResp.Resource != "" {
-		t.Errorf("Resource = %q, want empty (information disclosure)", errResp.Resource)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", got)
+			}
+			if tt.wantStatus == http.StatusOK && w.Header().Get("Content-Range") != "" {
+				t.Errorf("Content-Range = %q on a full response", w.Header().Get("Content-Range"))
+			}
+		})
 	}
 }
 
-func TestUploadSizeLimits(t *testing.T) {
-	// Create handlers with a small size limit for testing
-	tmpDir, err := os.MkdirTemp("", "sss-limit-test-*")
-	if err != nil {
-		t.Fatalf("failed to create temp dir: %v", err)
+func TestObjectSizeHistogram(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	// sample returns a series of the histogram, which other tests add to
+	sample := func(series string) float64 {
+		t.Helper()
+		w := httptest.NewRecorder()
+		promhttp.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if value, ok := strings.CutPrefix(line, series+" "); ok {
+				f, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					t.Fatalf("parsing %s: %v", line, err)
+				}
+				return f
+			}
+		}
+		return 0
+	}
+	const (
+		count = `stupid_simple_s3_object_size_bytes_count{operation="PutObject"}`
+		sum   = `stupid_simple_s3_object_size_bytes_sum{operation="PutObject"}`
+		small = `stupid_simple_s3_object_size_bytes_bucket{operation="PutObject",le="4096"}`
+	)
+	countBefore, sumBefore, smallBefore := sample(count), sample(sum), sample(small)
+
+	for _, body := range []string{"hello", strings.Repeat("x", 5000)} {
+		req := httptest.NewRequest("PUT", "/test-bucket/sized.txt", strings.NewReader(body))
+		req.SetPathValue("bucket", "test-bucket")
+		req.SetPathValue("key", "sized.txt")
+		w := httptest.NewRecorder()
+		handlers.PutObject(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("PutObject: status = %d, body = %s", w.Code, w.Body.String())
+		}
 	}
-	defer os.RemoveAll(tmpDir)
 
-	cfg := &config.Config{
-		Bucket: config.Bucket{Name: "test-bucket"},
-		Storage: config.Storage{
-			Path:          filepath.Join(tmpDir, "data"),
-			MultipartPath: filepath.Join(tmpDir, "multipart"),
-		},
-		Limits: config.Limits{
-			MaxObjectSize: 100, // 100 bytes limit for testing
-			MaxPartSize:   100,
-		},
+	if got := sample(count) - countBefore; got != 2 {
+		t.Errorf("observations = %v, want 2", got)
+	}
+	if got := sample(sum) - sumBefore; got != 5005 {
+		t.Errorf("observed bytes = %v, want 5005", got)
 	}
+	if got := sample(small) - smallBefore; got != 1 {
+		t.Errorf("observations up to 4KB = %v, want 1", got)
+	}
+}
 
-	store, err := storage.NewFilesystemStorage(cfg.Storage.Path, cfg.Storage.MultipartPath)
-	if err != nil {
-		t.Fatalf("failed to create storage: %v", err)
+func TestRequestDurationStatusClass(t *testing.T) {
+	for status, want := range map[int]string{200: "2xx", 206: "2xx", 304: "3xx", 404: "4xx", 503: "5xx", 101: "other"} {
+		if got := statusClass(status); got != want {
+			t.Errorf("statusClass(%d) = %q, want %q", status, got, want)
+		}
 	}
 
-	// Create the test bucket
-	if err := store.CreateBucket("test-bucket"); err != nil {
-		t.Fatalf("failed to create test bucket: %v", err)
+	handler := MetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	req := httptest.NewRequest("HEAD", "/missing-bucket", nil)
+	req.SetPathValue("bucket", "missing-bucket")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	series := `stupid_simple_s3_http_request_duration_seconds_count{method="HEAD",operation="HeadBucket",status_class="4xx"}`
+	if !strings.Contains(w.Body.String(), series) {
+		t.Errorf("%s not exported", series)
 	}
+}
 
-	handlers := NewHandlers(cfg, store)
+func TestAuditLog(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
 
-	t.Run("object within size limit succeeds", func(t *testing.T) {
-		content := bytes.Repeat([]byte("a"), 50) // 50 bytes, under limit
-		req := httptest.NewRequest("PUT", "/test-bucket/small.txt", bytes.NewReader(content))
-		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", "small.txt")
-		w := httptest.NewRecorder()
+	var buf bytes.Buffer
+	server := &Server{cfg: handlers.cfg, audit: NewAuditLog(&buf)}
+	authenticated := func(next http.HandlerFunc) http.Handler {
+		return server.auditMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logAuthentication(r, &config.Credential{AccessKeyID: "AKIAAUDIT", SecretAccessKey: "secret"})
+			next(w, r)
+		}))
+	}
+	mux := http.NewServeMux()
+	mux.Handle("PUT /{bucket}/{key...}", authenticated(handlers.PutObject))
+	mux.Handle("GET /{bucket}/{key...}", authenticated(handlers.GetObject))
+	mux.Handle("DELETE /{bucket}/{key...}", authenticated(handlers.DeleteObject))
+
+	// records decodes the audit records written by a request
+	records := func(method, path, body string) []map[string]any {
+		t.Helper()
+		buf.Reset()
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, strings.NewReader(body)))
+		var records []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var record map[string]any
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("invalid audit record %q: %v", line, err)
+			}
+			records = append(records, record)
+		}
+		return records
+	}
 
-		handlers.PutObject(w, req)
+	t.Run("put", func(t *testing.T) {
+		got := records("PUT", "/test-bucket/audited", "secret object contents")
+		if len(got) != 1 {
+			t.Fatalf("records = %v, want 1", got)
+		}
+		r := got[0]
+		if r["operation"] != "PutObject" || r["bucket"] != "test-bucket" || r["key"] != "audited" ||
+			r["access_key_id"] != "AKIAAUDIT" || r["result"] != "success" || r["size"] != float64(22) || r["etag"] == nil {
+			t.Errorf("record = %v", r)
+		}
+		if strings.Contains(buf.String(), "secret object contents") {
+			t.Errorf("object contents logged: %s", buf.String())
+		}
+	})
 
-		if w.Code != http.StatusOK {
-			t.Errorf("status = %d, want %d for object under size limit", w.Code, http.StatusOK)
+	t.Run("get is not audited", func(t *testing.T) {
+		if got := records("GET", "/test-bucket/audited", ""); len(got) != 0 {
+			t.Errorf("records = %v, want none", got)
 		}
 	})
 
-	t.Run("object exceeding size limit fails", func(t *testing.T) {
-		content := bytes.Repeat([]byte("a"), 150) // 150 bytes, over limit
-		req := httptest.NewRequest("PUT", "/test-bucket/large.txt", bytes.NewReader(content))
+	t.Run("delete", func(t *testing.T) {
+		got := records("DELETE", "/test-bucket/audited", "")
+		if len(got) != 1 || got[0]["operation"] != "DeleteObject" || got[0]["key"] != "audited" || got[0]["result"] != "success" {
+			t.Errorf("records = %v", got)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		got := records("PUT", "/missing-bucket/key", "data")
+		if len(got) != 1 || got[0]["result"] != "failure" || got[0]["status"] != float64(http.StatusNotFound) || got[0]["key"] != "key" {
+			t.Errorf("records = %v", got)
+		}
+	})
+}
+
+func TestAuditLogRestore(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := storage.NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), storage.FilesystemOptions{
+		TrashRetention: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	put, err := store.PutObject(context.Background(), "test-bucket", "doc.txt", "text/plain", nil, strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := store.DeleteObject("test-bucket", "doc.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+
+	handlers := NewHandlers(&config.Config{}, store)
+	var buf bytes.Buffer
+	server := &Server{cfg: handlers.cfg, audit: NewAuditLog(&buf)}
+	mux := http.NewServeMux()
+	mux.Handle("POST /admin/restore/{bucket}/{key...}", server.auditMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logAuthentication(r, &config.Credential{AccessKeyID: "AKIAAUDIT", SecretAccessKey: "secret"})
+		handlers.RestoreObject(w, r)
+	})))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/restore/test-bucket/doc.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid audit record %q: %v", buf.String(), err)
+	}
+	if record["operation"] != "RestoreObject" || record["bucket"] != "test-bucket" || record["key"] != "doc.txt" ||
+		record["access_key_id"] != "AKIAAUDIT" || record["result"] != "success" || record["etag"] != put.ETag {
+		t.Errorf("record = %v", record)
+	}
+}
+
+func TestAmzRequestIDHeaders(t *testing.T) {
+	handlers, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("bucket", "test-bucket")
+		r.SetPathValue("key", "missing")
+		handlers.GetObject(w, r)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/test-bucket/missing", nil))
+
+	requestID := w.Header().Get("x-amz-request-id")
+	hostID := w.Header().Get("x-amz-id-2")
+	if requestID == "" || requestID != w.Header().Get("X-Request-ID") {
+		t.Errorf("x-amz-request-id = %q, X-Request-ID = %q", requestID, w.Header().Get("X-Request-ID"))
+	}
+	if hostID == "" || hostID == requestID {
+		t.Errorf("x-amz-id-2 = %q", hostID)
+	}
+
+	var errResp s3.Error
+	if err := xml.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if errResp.Code != s3.ErrNoSuchKey || errResp.RequestID != requestID || errResp.HostID != hostID {
+		t.Errorf("error = %+v", errResp)
+	}
+
+	// Each request gets its own extended request ID
+	w2 := httptest.NewRecorder()
+	handler.ServeHTTP(w2, httptest.NewRequest("GET", "/test-bucket/missing", nil))
+	if w2.Header().Get("x-amz-id-2") == hostID {
+		t.Error("x-amz-id-2 reused across requests")
+	}
+}
+
+func TestErrorResponseRequestIDs(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	handler := NewServer(handlers.cfg, store).Handler()
+
+	// The request is rejected by authentication, before reaching a handler
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/test-bucket/secret/path/file.txt", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	var errResp s3.Error
+	if err := xml.NewDecoder(w.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if errResp.RequestID == "" || errResp.RequestID != w.Header().Get("x-amz-request-id") {
+		t.Errorf("RequestId = %q, x-amz-request-id = %q", errResp.RequestID, w.Header().Get("x-amz-request-id"))
+	}
+	if errResp.HostID == "" || errResp.HostID != w.Header().Get("x-amz-id-2") {
+		t.Errorf("HostId = %q, x-amz-id-2 = %q", errResp.HostID, w.Header().Get("x-amz-id-2"))
+	}
+	if errResp.Resource != "" {
+		t.Errorf("Resource = %q, want empty (information disclosure)", errResp.Resource)
+	}
+}
+
+func TestChunkedDecodedContentLength(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	// The payload is sent in two chunks of 5 bytes
+	body := "5;chunk-signature=0\r\nhello\r\n5;chunk-signature=0\r\nworld\r\n0;chunk-signature=0\r\n\r\n"
+	put := func(key, decodedLength string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/test-bucket/"+key, strings.NewReader(body))
+		req.Header.Set("Content-Encoding", "aws-chunked")
+		if decodedLength != "" {
+			req.Header.Set("X-Amz-Decoded-Content-Length", decodedLength)
+		}
 		req.SetPathValue("bucket", "test-bucket")
-		req.SetPathValue("key", "large.txt")
+		req.SetPathValue("key", key)
 		w := httptest.NewRecorder()
-
 		handlers.PutObject(w, req)
+		return w
+	}
 
-		if w.Code != http.StatusRequestEntityTooLarge {
-			t.Errorf("status = %d, want %d for object over size limit", w.Code, http.StatusRequestEntityTooLarge)
+	for _, tt := range []struct {
+		name, decodedLength string
+		wantStatus          int
+	}{
+		{"matching length", "10", http.StatusOK},
+		{"without decoded length", "", http.StatusOK},
+		{"payload shorter than declared", "11", http.StatusBadRequest},
+		{"payload longer than declared", "9", http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			key := strings.ReplaceAll(tt.name, " ", "-")
+			w := put(key, tt.decodedLength)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			_, err := store.HeadObject("test-bucket", key)
+			if tt.wantStatus != http.StatusOK {
+				var errResp s3.Error
+				_ = xml.Unmarshal(w.Body.Bytes(), &errResp)
+				if errResp.Code != s3.ErrIncompleteBody {
+					t.Errorf("code = %s, want %s", errResp.Code, s3.ErrIncompleteBody)
+				}
+				if err == nil {
+					t.Error("object with mismatched length was stored")
+				}
+			} else if err != nil {
+				t.Errorf("HeadObject failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestPublicRead(t *testing.T) {
+	handlers, store, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	if err := store.CreateBucket("private-bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	for _, obj := range []struct{ bucket, key string }{
+		{"test-bucket", "assets/app.js"},
+		{"test-bucket", "private/report.pdf"},
+		{"private-bucket", "assets/app.js"},
+	} {
+		if _, err := store.PutObject(context.Background(), obj.bucket, obj.key, "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
 		}
-	})
+	}
+	handlers.cfg.Auth.PublicRead = []string{"test-bucket/assets/"}
+	handler := NewServer(handlers.cfg, store).Handler()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"GET public object", "GET", "/test-bucket/assets/app.js", http.StatusOK},
+		{"GET public object with query", "GET", "/test-bucket/assets/app.js?v=2", http.StatusOK},
+		{"HEAD public object", "HEAD", "/test-bucket/assets/app.js", http.StatusOK},
+		{"GET missing public object", "GET", "/test-bucket/assets/missing.js", http.StatusNotFound},
+		{"GET object outside prefix", "GET", "/test-bucket/private/report.pdf", http.StatusForbidden},
+		{"GET object in other bucket", "GET", "/private-bucket/assets/app.js", http.StatusForbidden},
+		{"GET object version", "GET", "/test-bucket/assets/app.js?versionId=null", http.StatusForbidden},
+		{"GET legal hold", "GET", "/test-bucket/assets/app.js?legal-hold", http.StatusForbidden},
+		{"list bucket", "GET", "/test-bucket?prefix=assets/", http.StatusForbidden},
+		{"PUT public object", "PUT", "/test-bucket/assets/app.js", http.StatusForbidden},
+		{"DELETE public object", "DELETE", "/test-bucket/assets/app.js", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(""))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && tt.method == "GET" && w.Body.String() != "content" {
+				t.Errorf("body = %q, want %q", w.Body.String(), "content")
+			}
+		})
+	}
+
+	// Writes that reach the handlers with the public credential are denied
+	req := httptest.NewRequest("GET", "/test-bucket/assets/app.js", nil)
+	req.SetPathValue("bucket", "test-bucket")
+	req.SetPathValue("key", "assets/app.js")
+	var cred *config.Credential
+	handlePublicRead(httptest.NewRecorder(), req, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cred = GetCredential(r)
+	}))
+	if cred == nil || cred.CanWrite() || cred.CanAccessBucket("private-bucket") || !cred.CanAccessBucket("test-bucket") {
+		t.Errorf("public read credential = %+v, want read of test-bucket only", cred)
+	}
 }
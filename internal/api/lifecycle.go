@@ -0,0 +1,271 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// Lifecycle rule states
+const (
+	lifecycleEnabled  = "Enabled"
+	lifecycleDisabled = "Disabled"
+)
+
+// Lifecycle configuration limits, as in S3
+const (
+	maxLifecycleRules  = 1000
+	maxLifecycleRuleID = 255
+)
+
+var (
+	errMalformedLifecycle = errors.New("malformed lifecycle configuration")
+	errInvalidLifecycle   = errors.New("invalid lifecycle configuration")
+)
+
+// PutBucketLifecycleConfiguration handles PUT /{bucket}?lifecycle. The new
+// rules replace any existing ones.
+func (h *Handlers) PutBucketLifecycleConfiguration(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	const maxXMLBodySize = 1 * 1024 * 1024
+	var config s3.LifecycleConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&config); err != nil {
+		s3.WriteErrorResponse(w, s3.ErrMalformedXML)
+		return
+	}
+
+	rules, err := parseLifecycleRules(config.Rules)
+	if err != nil {
+		if errors.Is(err, errMalformedLifecycle) {
+			s3.WriteErrorResponse(w, s3.ErrMalformedXML)
+		} else {
+			s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+		}
+		return
+	}
+
+	if err := h.storage.PutBucketLifecycle(bucket, rules); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBucketNotFound):
+			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+		case errors.Is(err, storage.ErrInvalidBucketName):
+			s3.WriteErrorResponse(w, s3.ErrInvalidBucketName)
+		default:
+			slog.Error("failed to put bucket lifecycle", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+			s3.WriteErrorResponse(w, s3.ErrInternalError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetBucketLifecycleConfiguration handles GET /{bucket}?lifecycle
+func (h *Handlers) GetBucketLifecycleConfiguration(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	rules, err := h.storage.GetBucketLifecycle(bucket)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNoSuchLifecycleConfiguration):
+			s3.WriteErrorResponse(w, s3.ErrNoSuchLifecycleConfiguration)
+		case errors.Is(err, storage.ErrBucketNotFound):
+			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+		default:
+			slog.Error("failed to get bucket lifecycle", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+			s3.WriteErrorResponse(w, s3.ErrInternalError)
+		}
+		return
+	}
+
+	result := s3.LifecycleConfiguration{
+		Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/",
+	}
+	for _, rule := range rules {
+		result.Rules = append(result.Rules, formatLifecycleRule(rule))
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// DeleteBucketLifecycle handles DELETE /{bucket}?lifecycle
+func (h *Handlers) DeleteBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	if err := h.storage.DeleteBucketLifecycle(bucket); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrBucketNotFound):
+			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+		case errors.Is(err, storage.ErrInvalidBucketName):
+			s3.WriteErrorResponse(w, s3.ErrInvalidBucketName)
+		default:
+			slog.Error("failed to delete bucket lifecycle", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+			s3.WriteErrorResponse(w, s3.ErrInternalError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseLifecycleRules validates lifecycle rules from a request. Structural
+// problems return errMalformedLifecycle, unsupported values
+// errInvalidLifecycle.
+func parseLifecycleRules(rules []s3.LifecycleRule) ([]storage.LifecycleRule, error) {
+	if len(rules) == 0 || len(rules) > maxLifecycleRules {
+		return nil, errMalformedLifecycle
+	}
+
+	parsed := make([]storage.LifecycleRule, 0, len(rules))
+	ids := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if len(rule.ID) > maxLifecycleRuleID || (rule.ID != "" && ids[rule.ID]) {
+			return nil, errInvalidLifecycle
+		}
+		ids[rule.ID] = true
+
+		if rule.Status != lifecycleEnabled && rule.Status != lifecycleDisabled {
+			return nil, errMalformedLifecycle
+		}
+		out := storage.LifecycleRule{
+			ID:      rule.ID,
+			Enabled: rule.Status == lifecycleEnabled,
+		}
+
+		if err := parseLifecycleFilter(rule, &out); err != nil {
+			return nil, err
+		}
+
+		if rule.Expiration == nil && rule.AbortIncompleteMultipartUpload == nil {
+			return nil, errInvalidLifecycle
+		}
+		if exp := rule.Expiration; exp != nil {
+			switch {
+			case exp.Days != 0 && exp.Date != "":
+				return nil, errMalformedLifecycle
+			case exp.Days != 0:
+				if exp.Days < 0 {
+					return nil, errInvalidLifecycle
+				}
+				out.ExpirationDays = exp.Days
+			case exp.Date != "":
+				// As in S3, the date must be midnight UTC
+				date, err := time.Parse(time.RFC3339, exp.Date)
+				if err != nil || !date.Equal(date.UTC().Truncate(24*time.Hour)) {
+					return nil, errInvalidLifecycle
+				}
+				out.ExpirationDate = date.UTC()
+			default:
+				return nil, errMalformedLifecycle
+			}
+		}
+		if abort := rule.AbortIncompleteMultipartUpload; abort != nil {
+			// Uploads have no tags, so a tag filter cannot select them
+			if abort.DaysAfterInitiation <= 0 || len(out.Tags) > 0 {
+				return nil, errInvalidLifecycle
+			}
+			out.AbortIncompleteUploadDays = abort.DaysAfterInitiation
+		}
+
+		parsed = append(parsed, out)
+	}
+	return parsed, nil
+}
+
+// parseLifecycleFilter sets the prefix and tags of a rule from either its
+// Filter or the deprecated top-level Prefix
+func parseLifecycleFilter(rule s3.LifecycleRule, out *storage.LifecycleRule) error {
+	if rule.Filter == nil {
+		if rule.Prefix != nil {
+			out.Prefix = *rule.Prefix
+		}
+		return nil
+	}
+	if rule.Prefix != nil {
+		return errMalformedLifecycle
+	}
+
+	filter := rule.Filter
+	set := 0
+	for _, present := range []bool{filter.Prefix != nil, filter.Tag != nil, filter.And != nil} {
+		if present {
+			set++
+		}
+	}
+	if set > 1 {
+		return errMalformedLifecycle
+	}
+
+	var tags []s3.Tag
+	switch {
+	case filter.Prefix != nil:
+		out.Prefix = *filter.Prefix
+	case filter.Tag != nil:
+		tags = []s3.Tag{*filter.Tag}
+	case filter.And != nil:
+		out.Prefix = filter.And.Prefix
+		tags = filter.And.Tags
+	}
+	for _, tag := range tags {
+		if out.Tags == nil {
+			out.Tags = make(map[string]string, len(tags))
+		}
+		if tag.Key == "" {
+			return errInvalidLifecycle
+		}
+		if _, dup := out.Tags[tag.Key]; dup {
+			return errInvalidLifecycle
+		}
+		out.Tags[tag.Key] = tag.Value
+	}
+	return nil
+}
+
+// formatLifecycleRule converts a stored rule to its XML form
+func formatLifecycleRule(rule storage.LifecycleRule) s3.LifecycleRule {
+	out := s3.LifecycleRule{
+		ID:     rule.ID,
+		Status: lifecycleDisabled,
+		Filter: &s3.LifecycleFilter{},
+	}
+	if rule.Enabled {
+		out.Status = lifecycleEnabled
+	}
+
+	switch {
+	case len(rule.Tags) == 1 && rule.Prefix == "":
+		for k, v := range rule.Tags {
+			out.Filter.Tag = &s3.Tag{Key: k, Value: v}
+		}
+	case len(rule.Tags) > 0:
+		and := &s3.LifecycleAnd{Prefix: rule.Prefix}
+		for _, k := range slices.Sorted(maps.Keys(rule.Tags)) {
+			and.Tags = append(and.Tags, s3.Tag{Key: k, Value: rule.Tags[k]})
+		}
+		out.Filter.And = and
+	default:
+		prefix := rule.Prefix
+		out.Filter.Prefix = &prefix
+	}
+
+	if rule.ExpirationDays > 0 {
+		out.Expiration = &s3.LifecycleExpiration{Days: rule.ExpirationDays}
+	} else if !rule.ExpirationDate.IsZero() {
+		out.Expiration = &s3.LifecycleExpiration{Date: rule.ExpirationDate.UTC().Format(time.RFC3339)}
+	}
+	if rule.AbortIncompleteUploadDays > 0 {
+		out.AbortIncompleteMultipartUpload = &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: rule.AbortIncompleteUploadDays}
+	}
+	return out
+}
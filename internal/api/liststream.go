@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// encodingTypeURL is the only encoding-type S3 supports for listings
+const encodingTypeURL = "url"
+
+// listWriter streams a ListObjectsV2 response, encoding each entry as the
+// storage produces it rather than buffering the whole listing. The status
+// line and leading elements are written with the first entry, so an error
+// before that can still be sent as an S3 error response. Elements that are
+// only known at the end (KeyCount, IsTruncated, NextContinuationToken)
+// follow the entries; S3 clients do not depend on element order.
+//
+// With encoding-type=url (head.EncodingType), keys, prefixes, the delimiter
+// and StartAfter are URL-encoded, so that keys with characters XML cannot
+// carry survive the response.
+type listWriter struct {
+	w        http.ResponseWriter
+	enc      *xml.Encoder
+	head     s3.ListBucketResultV2
+	started  bool
+	keyCount int
+
+	// owner is added to each object with fetch-owner=true
+	owner *s3.Owner
+	// withMetadata adds the content type and user metadata to each object
+	withMetadata bool
+
+	// writeErr is the first error writing the response, usually because
+	// the client went away
+	writeErr error
+}
+
+func newListWriter(w http.ResponseWriter, head s3.ListBucketResultV2) *listWriter {
+	return &listWriter{w: w, enc: xml.NewEncoder(w), head: head}
+}
+
+// name returns a key or prefix as it is written to the response
+func (lw *listWriter) name(s string) string {
+	if lw.head.EncodingType == encodingTypeURL {
+		return s3URLEncode(s)
+	}
+	return s
+}
+
+// element encodes a simple element, omitting it if omitEmpty is set and
+// value is empty
+func (lw *listWriter) element(name string, value any, omitEmpty bool) error {
+	if s, ok := value.(string); ok && omitEmpty && s == "" {
+		return nil
+	}
+	return lw.enc.EncodeElement(value, xml.StartElement{Name: xml.Name{Local: name}})
+}
+
+// start writes the status line and the elements preceding the entries
+func (lw *listWriter) start() error {
+	if lw.started {
+		return nil
+	}
+	lw.started = true
+
+	lw.w.Header().Set("Content-Type", "application/xml")
+	lw.w.WriteHeader(http.StatusOK)
+
+	root := xml.StartElement{
+		Name: xml.Name{Local: "ListBucketResult"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: lw.head.Xmlns}},
+	}
+	if err := lw.enc.EncodeToken(root); err != nil {
+		return err
+	}
+	for _, el := range []struct {
+		name      string
+		value     any
+		omitEmpty bool
+	}{
+		{"Name", lw.head.Name, false},
+		{"Prefix", lw.name(lw.head.Prefix), true},
+		{"StartAfter", lw.name(lw.head.StartAfter), true},
+		{"MaxKeys", lw.head.MaxKeys, false},
+		{"Delimiter", lw.name(lw.head.Delimiter), true},
+		{"ContinuationToken", lw.head.ContinuationToken, true},
+		{"EncodingType", lw.head.EncodingType, true},
+	} {
+		if err := lw.element(el.name, el.value, el.omitEmpty); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry writes a Contents or CommonPrefixes element
+func (lw *listWriter) entry(entry storage.ListEntry) error {
+	if err := lw.writeEntry(entry); err != nil {
+		lw.writeErr = err
+		return err
+	}
+	return nil
+}
+
+func (lw *listWriter) writeEntry(entry storage.ListEntry) error {
+	if err := lw.start(); err != nil {
+		return err
+	}
+
+	// KeyCount includes common prefixes, as MaxKeys does
+	lw.keyCount++
+	if entry.Object == nil {
+		return lw.enc.EncodeElement(s3.Prefix{Prefix: lw.name(entry.CommonPrefix)}, xml.StartElement{Name: xml.Name{Local: "CommonPrefixes"}})
+	}
+
+	obj := s3.Object{
+		Key:          lw.name(entry.Object.Key),
+		LastModified: entry.Object.LastModified,
+		ETag:         entry.Object.ETag,
+		Size:         entry.Object.Size,
+		StorageClass: "STANDARD",
+		Owner:        lw.owner,
+	}
+	if lw.withMetadata {
+		obj.UserMetadata = s3.UserMetadata{"content-type": entry.Object.ContentType}
+		for k, v := range entry.Object.UserMetadata {
+			obj.UserMetadata[http.CanonicalHeaderKey("x-amz-meta-"+k)] = v
+		}
+	}
+	// EncodeElement flushes, so each entry goes out as it is encoded
+	return lw.enc.EncodeElement(obj, xml.StartElement{Name: xml.Name{Local: "Contents"}})
+}
+
+// finish writes the trailing elements and closes the response
+func (lw *listWriter) finish(result *storage.ListObjectsResult) {
+	if err := lw.writeFinish(result); err != nil {
+		lw.writeErr = err
+	}
+}
+
+func (lw *listWriter) writeFinish(result *storage.ListObjectsResult) error {
+	if err := lw.start(); err != nil {
+		return err
+	}
+	if err := lw.element("KeyCount", lw.keyCount, false); err != nil {
+		return err
+	}
+	if err := lw.element("IsTruncated", result.IsTruncated, false); err != nil {
+		return err
+	}
+	if err := lw.element("NextContinuationToken", result.NextContinuationToken, true); err != nil {
+		return err
+	}
+	if err := lw.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "ListBucketResult"}}); err != nil {
+		return err
+	}
+	return lw.enc.Flush()
+}
+
+// s3URLEncode encodes a key as S3 does for encoding-type=url: unreserved
+// characters and "/" are kept, spaces become "+" and all other bytes are
+// percent-encoded
+func s3URLEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		case c == ' ':
+			b.WriteByte('+')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0x0f])
+		}
+	}
+	return b.String()
+}
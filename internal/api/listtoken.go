@@ -0,0 +1,76 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var errInvalidContinuationToken = errors.New("invalid continuation token")
+
+// listPosition is the content of a continuation token: the storage's
+// position in the listing and the parameters it belongs to
+type listPosition struct {
+	Position  string `json:"k"`
+	Prefix    string `json:"p,omitempty"`
+	Delimiter string `json:"d,omitempty"`
+}
+
+// newContinuationTokenKey returns the configured key for signing
+// continuation tokens, or a random one if none is configured. Tokens signed
+// with a random key are invalid after a restart.
+func newContinuationTokenKey(configured []byte) []byte {
+	if configured != nil {
+		return configured
+	}
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic("generating continuation token key: " + err.Error())
+	}
+	return key
+}
+
+// signContinuationToken returns the opaque token handed to clients for
+// the storage position pos
+func (h *Handlers) signContinuationToken(pos listPosition) string {
+	payload, _ := json.Marshal(pos)
+	mac := hmac.New(sha256.New, h.tokenKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyContinuationToken returns the storage position in a token from
+// signContinuationToken. Tokens that were altered, or are used with a
+// different prefix or delimiter than they were issued for, are rejected.
+func (h *Handlers) verifyContinuationToken(token, prefix, delimiter string) (string, error) {
+	encodedPayload, encodedMAC, found := strings.Cut(token, ".")
+	if !found {
+		return "", errInvalidContinuationToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", errInvalidContinuationToken
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return "", errInvalidContinuationToken
+	}
+	mac := hmac.New(sha256.New, h.tokenKey)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return "", errInvalidContinuationToken
+	}
+
+	var pos listPosition
+	if err := json.Unmarshal(payload, &pos); err != nil {
+		return "", errInvalidContinuationToken
+	}
+	if pos.Prefix != prefix || pos.Delimiter != delimiter {
+		return "", errInvalidContinuationToken
+	}
+	return pos.Position, nil
+}
@@ -3,31 +3,43 @@ package api
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/espen/stupid-simple-s3/internal/auth"
 	"github.com/espen/stupid-simple-s3/internal/config"
 	"github.com/espen/stupid-simple-s3/internal/metrics"
 	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/espen/stupid-simple-s3/internal/storage"
 )
 
 type contextKey string
 
 const (
-	credentialContextKey contextKey = "credential"
-	operationContextKey  contextKey = "operation"
-	requestIDContextKey  contextKey = "request_id"
+	credentialContextKey    contextKey = "credential"
+	operationContextKey     contextKey = "operation"
+	requestIDContextKey     contextKey = "request_id"
+	chunkVerifierContextKey contextKey = "chunk_verifier"
+	loggedAuthContextKey    contextKey = "logged_auth"
+	auditRecordContextKey   contextKey = "audit_record"
 )
 
 const requestIDHeader = "X-Request-ID"
 
+// securityTokenHeader carries the session token of temporary credentials.
+// Presigned URLs carry it as a query parameter of the same name.
+const securityTokenHeader = "X-Amz-Security-Token"
+
 // authFailureDelay is the duration to sleep on authentication failures
 // This slows down brute-force attacks without complex rate limiting
 const authFailureDelay = 100 * time.Millisecond
@@ -111,6 +123,20 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// ReadFrom passes io.Copy through to the wrapped writer, so that file
+// bodies are still sent with sendfile when the connection supports it
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	var n int64
+	var err error
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(rw.ResponseWriter, src)
+	}
+	rw.bytesWritten += n
+	return n, err
+}
+
 // countingReader wraps io.ReadCloser to count bytes read
 type countingReader struct {
 	io.ReadCloser
@@ -132,7 +158,20 @@ func generateRequestID() string {
 	return hex.EncodeToString(b)
 }
 
-// RequestIDMiddleware adds a request ID to the context and response header
+// generateExtendedRequestID generates a random extended request ID. S3 uses
+// it to identify the host that served the request; here it is only a second
+// token for correlating requests with client logs.
+func generateExtendedRequestID() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// RequestIDMiddleware adds a request ID to the context and response headers.
+// The ID is sent both as X-Request-ID and as S3's x-amz-request-id, along
+// with an x-amz-id-2 extended request ID.
 func RequestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestID := r.Header.Get(requestIDHeader)
@@ -140,8 +179,10 @@ func RequestIDMiddleware(next http.Handler) http.Handler {
 			requestID = generateRequestID()
 		}
 
-		// Set request ID in response header
+		// Set request ID in response headers
 		w.Header().Set(requestIDHeader, requestID)
+		w.Header().Set(s3.RequestIDHeader, requestID)
+		w.Header().Set(s3.ExtendedRequestIDHeader, generateExtendedRequestID())
 
 		// Add to context
 		ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
@@ -149,6 +190,21 @@ func RequestIDMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// TimeoutMiddleware cancels the request context after timeout, which stops
+// storage operations still copying object data. A timeout of 0 disables it.
+func TimeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 // GetRequestID retrieves the request ID from the request context
 func GetRequestID(r *http.Request) string {
 	if id, ok := r.Context().Value(requestIDContextKey).(string); ok {
@@ -173,7 +229,7 @@ func MetricsMiddleware(next http.Handler) http.Handler {
 		status := strconv.Itoa(rw.statusCode)
 
 		metrics.RequestsTotal.WithLabelValues(r.Method, operation, status).Inc()
-		metrics.RequestDuration.WithLabelValues(r.Method, operation).Observe(duration)
+		metrics.RequestDuration.WithLabelValues(r.Method, operation, statusClass(rw.statusCode)).Observe(duration)
 
 		if rw.bytesWritten > 0 {
 			metrics.BytesSent.WithLabelValues(operation).Add(float64(rw.bytesWritten))
@@ -191,9 +247,12 @@ func MetricsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// AccessLogMiddleware logs HTTP requests using structured logging
-func AccessLogMiddleware(trustedProxies []string) func(http.Handler) http.Handler {
+// AccessLogMiddleware logs HTTP requests using structured logging.
+// When sampleRate is greater than 1, only one in sampleRate successful requests
+// is logged. Requests with a 4xx or 5xx status are always logged.
+func AccessLogMiddleware(trustedProxies []string, sampleRate int64) func(http.Handler) http.Handler {
 	proxyChecker := newTrustedProxyChecker(trustedProxies)
+	var successCount atomic.Uint64
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -201,11 +260,13 @@ func AccessLogMiddleware(trustedProxies []string) func(http.Handler) http.Handle
 			rw := newResponseWriter(w)
 			cr := &countingReader{ReadCloser: r.Body}
 			r.Body = cr
+			loggedAuth := &loggedAuth{}
+			r = r.WithContext(context.WithValue(r.Context(), loggedAuthContextKey, loggedAuth))
 
 			next.ServeHTTP(rw, r)
 
 			duration := time.Since(start)
-				clientIP := getClientIPWithTrust(r, proxyChecker)
+			clientIP := getClientIPWithTrust(r, proxyChecker)
 			requestID := GetRequestID(r)
 
 			// Log health and metrics endpoints at debug level to reduce noise
@@ -220,7 +281,18 @@ func AccessLogMiddleware(trustedProxies []string) func(http.Handler) http.Handle
 				return
 			}
 
+			// Sample successful requests to limit log volume
+			if sampleRate > 1 && rw.statusCode < http.StatusBadRequest {
+				if successCount.Add(1)%uint64(sampleRate) != 1 {
+					return
+				}
+			}
+
 			operation := getOperationFromContext(r)
+			accessKeyID := loggedAuth.accessKeyID
+			if accessKeyID == "" {
+				accessKeyID = anonymousAccessKeyID
+			}
 
 			slog.Info("request",
 				"client_ip", clientIP,
@@ -232,11 +304,37 @@ func AccessLogMiddleware(trustedProxies []string) func(http.Handler) http.Handle
 				"duration", duration.Seconds(),
 				"request_id", requestID,
 				"operation", operation,
+				"access_key_id", accessKeyID,
+				"presigned", auth.IsPresignedRequest(r),
 			)
 		})
 	}
 }
 
+// anonymousAccessKeyID is logged for requests that were not authenticated,
+// including those that failed authentication
+const anonymousAccessKeyID = "anonymous"
+
+// loggedAuth records the access key a request was authenticated with for
+// the access log. The access log middleware adds it to the request context
+// for authentication to fill in, as it cannot see the context
+// authentication passes on.
+type loggedAuth struct {
+	accessKeyID string
+}
+
+// logAuthentication records the credential a request was authenticated
+// with in the access and audit logs. Only the access key ID is kept, never
+// the secret.
+func logAuthentication(r *http.Request, cred *config.Credential) {
+	if logged, ok := r.Context().Value(loggedAuthContextKey).(*loggedAuth); ok {
+		logged.accessKeyID = cred.AccessKeyID
+	}
+	if record, ok := r.Context().Value(auditRecordContextKey).(*auditRecord); ok {
+		record.accessKeyID = cred.AccessKeyID
+	}
+}
+
 // isInternalEndpoint returns true for health check and metrics endpoints
 func isInternalEndpoint(path string) bool {
 	return path == "/healthz" || path == "/readyz" || path == "/metrics"
@@ -305,21 +403,66 @@ func detectOperation(r *http.Request) string {
 		return metrics.OpHeadBucket
 
 	case "GET":
+		if query.Has("legal-hold") {
+			return metrics.OpGetObjectLegalHold
+		}
+		if query.Has("versioning") {
+			return metrics.OpGetBucketVersioning
+		}
+		if query.Has("versions") {
+			return metrics.OpListObjectVersions
+		}
+		if query.Has("lifecycle") {
+			return metrics.OpGetBucketLifecycle
+		}
+		if query.Has("cors") {
+			return metrics.OpGetBucketCors
+		}
+		if query.Has("location") {
+			return metrics.OpGetBucketLocation
+		}
 		return metrics.OpGetObject
 
 	case "PUT":
 		if query.Has("uploadId") && query.Has("partNumber") {
 			return metrics.OpUploadPart
 		}
+		if query.Has("legal-hold") {
+			return metrics.OpPutObjectLegalHold
+		}
+		if query.Has("versioning") {
+			return metrics.OpPutBucketVersioning
+		}
+		if query.Has("lifecycle") {
+			return metrics.OpPutBucketLifecycle
+		}
+		if query.Has("cors") {
+			return metrics.OpPutBucketCors
+		}
 		return metrics.OpPutObject
 
 	case "DELETE":
 		if query.Has("uploadId") {
 			return metrics.OpAbortMultipartUpload
 		}
+		if query.Has("lifecycle") {
+			return metrics.OpDeleteBucketLifecycle
+		}
+		if query.Has("cors") {
+			return metrics.OpDeleteBucketCors
+		}
 		return metrics.OpDeleteObject
 
 	case "POST":
+		if strings.HasPrefix(r.URL.Path, "/admin/reindex/") {
+			return metrics.OpReindex
+		}
+		if strings.HasPrefix(r.URL.Path, "/admin/restore/") {
+			return metrics.OpRestoreObject
+		}
+		if isFormUpload(r) {
+			return metrics.OpPostObject
+		}
 		if query.Has("uploads") {
 			return metrics.OpCreateMultipartUpload
 		}
@@ -331,6 +474,22 @@ func detectOperation(r *http.Request) string {
 	return metrics.OpUnknown
 }
 
+// statusClass returns the class of an HTTP status code, such as "2xx"
+func statusClass(status int) string {
+	switch {
+	case status >= 200 && status < 300:
+		return "2xx"
+	case status >= 300 && status < 400:
+		return "3xx"
+	case status >= 400 && status < 500:
+		return "4xx"
+	case status >= 500 && status < 600:
+		return "5xx"
+	default:
+		return "other"
+	}
+}
+
 func getErrorCodeFromStatus(status int) string {
 	switch status {
 	case http.StatusNotFound:
@@ -348,7 +507,10 @@ func getErrorCodeFromStatus(status int) string {
 
 // AuthMiddleware creates middleware that verifies AWS Signature v4 authentication
 func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
-	sigv4 := &auth.SignatureV4{}
+	sigv4 := &auth.SignatureV4{Region: cfg.Server.Region, LenientDate: cfg.Auth.LenientDateParsing, ClockSkew: cfg.Server.MaxClockSkew}
+	if cfg.Auth.ReplayProtection {
+		sigv4.Replays = auth.NewReplayCache(sigv4.AllowedClockSkew())
+	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -358,12 +520,17 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				return
 			}
 
+			if isPublicRead(cfg, r) {
+				handlePublicRead(w, r, next)
+				return
+			}
+
 			// Parse authorization header to get access key ID
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
 				time.Sleep(authFailureDelay) // Slow down brute-force attempts
 				metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonMissingHeader).Inc()
-				s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+				writeRequestError(w, r, s3.ErrAccessDenied)
 				return
 			}
 
@@ -371,7 +538,7 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 			if err != nil {
 				time.Sleep(authFailureDelay)
 				metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonMalformedHeader).Inc()
-				s3.WriteErrorResponse(w, s3.ErrAuthorizationHeaderMalformed)
+				writeRequestError(w, r, s3.ErrAuthorizationHeaderMalformed)
 				return
 			}
 
@@ -380,32 +547,86 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 			if cred == nil {
 				time.Sleep(authFailureDelay)
 				metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonInvalidAccessKey).Inc()
-				s3.WriteErrorResponse(w, s3.ErrInvalidAccessKeyId)
+				writeRequestError(w, r, s3.ErrInvalidAccessKeyId)
 				return
 			}
 
 			// Verify signature
-			_, err = sigv4.VerifyRequest(r, cred.SecretAccessKey)
+			result, err := sigv4.VerifyRequest(r, cred.SecretAccessKey)
 			if err != nil {
 				time.Sleep(authFailureDelay)
-				// Check for specific error types
-				if strings.Contains(err.Error(), "skewed") {
-					metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonTimeSkew).Inc()
-					s3.WriteErrorResponse(w, s3.ErrRequestTimeTooSkewed)
+				if writeRegionMismatch(w, r, err) {
+					return
+				}
+				if errors.Is(err, auth.ErrReplayedRequest) {
+					slog.Warn("replayed request rejected", "access_key_id", parsed.AccessKeyID, "request_id", GetRequestID(r))
+					metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonReplay).Inc()
+					writeRequestError(w, r, s3.ErrSignatureDoesNotMatch)
+					return
+				}
+				if writeClockSkew(w, r, err) {
 					return
 				}
 				metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonSignatureMismatch).Inc()
-				s3.WriteErrorResponse(w, s3.ErrSignatureDoesNotMatch)
+				writeRequestError(w, r, s3.ErrSignatureDoesNotMatch)
+				return
+			}
+
+			// Temporary credentials require a matching session token
+			if !checkSessionToken(w, r, cred, r.Header.Get(securityTokenHeader)) {
 				return
 			}
 
 			// Store credential in context for handlers to check privileges
+			logAuthentication(r, cred)
 			ctx := context.WithValue(r.Context(), credentialContextKey, cred)
+			if result.ChunkVerifier != nil {
+				ctx = context.WithValue(ctx, chunkVerifierContextKey, result.ChunkVerifier)
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// publicReadDeniedParams are the query parameters that make a GET or HEAD
+// something other than a read of the current object, such as a read of an
+// older version or of the legal hold. Those always require a credential.
+var publicReadDeniedParams = []string{"versionId", "legal-hold"}
+
+// isPublicRead returns true for an unauthenticated GET or HEAD of an object
+// the configuration marks as public-read
+func isPublicRead(cfg *config.Config, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	query := r.URL.Query()
+	for _, name := range publicReadDeniedParams {
+		if query.Has(name) {
+			return false
+		}
+	}
+	return cfg.Auth.IsPublicRead(r.PathValue("bucket"), r.PathValue("key"))
+}
+
+// handlePublicRead serves an unauthenticated read of a public object with a
+// credential that has no privileges and is limited to the bucket, so that
+// any check for write access or access to other buckets denies the request
+func handlePublicRead(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	bucket := r.PathValue("bucket")
+	slog.Info("public read",
+		"method", r.Method,
+		"bucket", bucket,
+		"key", r.PathValue("key"),
+		"request_id", GetRequestID(r),
+	)
+	cred := &config.Credential{AccessKeyID: anonymousAccessKeyID, Buckets: []string{bucket}}
+	ctx := context.WithValue(r.Context(), credentialContextKey, cred)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
 // handlePresignedAuth handles authentication for presigned URL requests
 func handlePresignedAuth(w http.ResponseWriter, r *http.Request, cfg *config.Config, sigv4 *auth.SignatureV4, next http.Handler) {
 	// Get access key ID from presigned URL
@@ -413,7 +634,7 @@ func handlePresignedAuth(w http.ResponseWriter, r *http.Request, cfg *config.Con
 	if accessKeyID == "" {
 		time.Sleep(authFailureDelay)
 		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonMalformedHeader).Inc()
-		s3.WriteErrorResponse(w, s3.ErrAuthorizationHeaderMalformed)
+		writeRequestError(w, r, s3.ErrAuthorizationHeaderMalformed)
 		return
 	}
 
@@ -422,7 +643,7 @@ func handlePresignedAuth(w http.ResponseWriter, r *http.Request, cfg *config.Con
 	if cred == nil {
 		time.Sleep(authFailureDelay)
 		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonInvalidAccessKey).Inc()
-		s3.WriteErrorResponse(w, s3.ErrInvalidAccessKeyId)
+		writeRequestError(w, r, s3.ErrInvalidAccessKeyId)
 		return
 	}
 
@@ -430,27 +651,94 @@ func handlePresignedAuth(w http.ResponseWriter, r *http.Request, cfg *config.Con
 	_, err := sigv4.VerifyPresignedRequest(r, cred.SecretAccessKey)
 	if err != nil {
 		time.Sleep(authFailureDelay)
+		if writeRegionMismatch(w, r, err) {
+			return
+		}
+		var queryErr *auth.QueryParametersError
+		if errors.As(err, &queryErr) {
+			metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonMalformedHeader).Inc()
+			writeRequestErrorWithMessage(w, r, s3.ErrAuthorizationQueryParameters, queryErr.Message)
+			return
+		}
 		// Check for specific error types
 		if strings.Contains(err.Error(), "expired") {
 			metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonTimeSkew).Inc()
-			s3.WriteErrorResponse(w, s3.ErrExpiredToken)
+			writeRequestError(w, r, s3.ErrExpiredToken)
 			return
 		}
-		if strings.Contains(err.Error(), "future") {
-			metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonTimeSkew).Inc()
-			s3.WriteErrorResponse(w, s3.ErrRequestTimeTooSkewed)
+		if writeClockSkew(w, r, err) {
 			return
 		}
 		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonSignatureMismatch).Inc()
-		s3.WriteErrorResponse(w, s3.ErrSignatureDoesNotMatch)
+		writeRequestError(w, r, s3.ErrSignatureDoesNotMatch)
+		return
+	}
+
+	// Temporary credentials require a matching session token
+	if !checkSessionToken(w, r, cred, r.URL.Query().Get(securityTokenHeader)) {
 		return
 	}
 
 	// Store credential in context for handlers to check privileges
+	logAuthentication(r, cred)
 	ctx := context.WithValue(r.Context(), credentialContextKey, cred)
 	next.ServeHTTP(w, r.WithContext(ctx))
 }
 
+// writeRegionMismatch writes an AuthorizationHeaderMalformed response naming the
+// expected region if err is a region mismatch. Returns false for other errors.
+func writeRegionMismatch(w http.ResponseWriter, r *http.Request, err error) bool {
+	var regionErr *auth.RegionMismatchError
+	if !errors.As(err, &regionErr) {
+		return false
+	}
+	slog.Warn("request signed for wrong region",
+		"region", regionErr.Region,
+		"expected_region", regionErr.Expected,
+		"request_id", GetRequestID(r),
+	)
+	metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonMalformedHeader).Inc()
+	writeRequestErrorWithMessage(w, r, s3.ErrAuthorizationHeaderMalformed,
+		"The authorization header is malformed; "+regionErr.Error())
+	return true
+}
+
+// writeClockSkew writes a RequestTimeTooSkewed response if err is a clock
+// skew error. Returns false for other errors.
+func writeClockSkew(w http.ResponseWriter, r *http.Request, err error) bool {
+	var skewErr *auth.ClockSkewError
+	if !errors.As(err, &skewErr) {
+		return false
+	}
+	slog.Warn("request time too skewed",
+		"request_time", skewErr.RequestTime,
+		"skew", skewErr.Skew().String(),
+		"max_skew", skewErr.Max.String(),
+		"request_id", GetRequestID(r),
+	)
+	metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonTimeSkew).Inc()
+	writeRequestError(w, r, s3.ErrRequestTimeTooSkewed)
+	return true
+}
+
+// checkSessionToken validates the session token and expiry of a credential
+// after its signature has been verified. Writes an error response and returns
+// false if the credential may not be used.
+func checkSessionToken(w http.ResponseWriter, r *http.Request, cred *config.Credential, token string) bool {
+	if cred.RequiresSessionToken() && subtle.ConstantTimeCompare([]byte(token), []byte(cred.SessionToken)) != 1 {
+		time.Sleep(authFailureDelay)
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonInvalidToken).Inc()
+		writeRequestError(w, r, s3.ErrInvalidToken)
+		return false
+	}
+	if cred.Expired(time.Now()) {
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonExpiredToken).Inc()
+		writeRequestError(w, r, s3.ErrExpiredToken)
+		return false
+	}
+	return true
+}
+
 // GetCredential retrieves the authenticated credential from the request context
 func GetCredential(r *http.Request) *config.Credential {
 	cred, ok := r.Context().Value(credentialContextKey).(*config.Credential)
@@ -460,13 +748,49 @@ func GetCredential(r *http.Request) *config.Credential {
 	return cred
 }
 
+// getChunkVerifier retrieves the chunk signature verifier from the request context.
+// Returns nil if the request body does not use signed chunked encoding.
+func getChunkVerifier(r *http.Request) *auth.ChunkVerifier {
+	verifier, ok := r.Context().Value(chunkVerifierContextKey).(*auth.ChunkVerifier)
+	if !ok {
+		return nil
+	}
+	return verifier
+}
+
 // RequireWritePrivilege middleware checks if the credential has write privilege
 func RequireWritePrivilege(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cred := GetCredential(r)
 		if cred == nil || !cred.CanWrite() {
 			metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonAccessDenied).Inc()
-			s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+			writeRequestError(w, r, s3.ErrAccessDenied)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RejectLongKeys middleware rejects requests whose object key exceeds the
+// S3 limit with KeyTooLongError, whatever the operation
+func RejectLongKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.PathValue("key")) > storage.MaxKeyLength {
+			writeRequestError(w, r, s3.ErrKeyTooLong)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireBucketAccess middleware checks if the credential may access the
+// bucket in the request path
+func RequireBucketAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cred := GetCredential(r)
+		if cred == nil || !cred.CanAccessBucket(r.PathValue("bucket")) {
+			metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonAccessDenied).Inc()
+			writeRequestError(w, r, s3.ErrAccessDenied)
 			return
 		}
 		next.ServeHTTP(w, r)
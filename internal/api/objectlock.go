@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// Object lock response headers
+const (
+	objectLockModeHeader            = "X-Amz-Object-Lock-Mode"
+	objectLockRetainUntilDateHeader = "X-Amz-Object-Lock-Retain-Until-Date"
+	objectLockLegalHoldHeader       = "X-Amz-Object-Lock-Legal-Hold"
+)
+
+// objectLockDateFormat is the ISO 8601 format S3 uses for retain-until dates
+const objectLockDateFormat = "2006-01-02T15:04:05.000Z"
+
+// setObjectLockHeaders sets the object lock headers for GET and HEAD responses.
+// Headers are only set for the parts of the lock state that are present.
+func setObjectLockHeaders(w http.ResponseWriter, meta *s3.ObjectMetadata) {
+	if meta.ObjectLockMode != "" {
+		w.Header().Set(objectLockModeHeader, meta.ObjectLockMode)
+	}
+	if meta.ObjectLockRetainUntilDate != nil {
+		w.Header().Set(objectLockRetainUntilDateHeader, meta.ObjectLockRetainUntilDate.UTC().Format(objectLockDateFormat))
+	}
+	if meta.ObjectLockLegalHold != "" {
+		w.Header().Set(objectLockLegalHoldHeader, meta.ObjectLockLegalHold)
+	}
+}
+
+// PutObjectLegalHold handles PUT /{bucket}/{key}?legal-hold
+func (h *Handlers) PutObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	key := r.PathValue("key")
+
+	const maxXMLBodySize = 64 * 1024
+	var legalHold s3.LegalHold
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&legalHold); err != nil {
+		s3.WriteErrorResponse(w, s3.ErrMalformedXML)
+		return
+	}
+	if legalHold.Status != s3.LegalHoldOn && legalHold.Status != s3.LegalHoldOff {
+		s3.WriteErrorResponse(w, s3.ErrMalformedXML)
+		return
+	}
+
+	if err := h.storage.PutObjectLegalHold(bucket, key, legalHold.Status); err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			h.writeNoSuchKey(w, r, bucket)
+			return
+		}
+		if errors.Is(err, storage.ErrInvalidKey) {
+			s3.WriteErrorResponse(w, invalidKeyError(err))
+			return
+		}
+		slog.Error("failed to put object legal hold", "error", err, "bucket", bucket, "key", key, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetObjectLegalHold handles GET /{bucket}/{key}?legal-hold
+func (h *Handlers) GetObjectLegalHold(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	key := r.PathValue("key")
+
+	meta, err := h.storage.HeadObject(bucket, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			h.writeNoSuchKey(w, r, bucket)
+			return
+		}
+		slog.Error("failed to get object legal hold", "error", err, "bucket", bucket, "key", key, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return
+	}
+
+	// An object that never had a legal hold set has no lock configuration
+	if meta.ObjectLockLegalHold == "" {
+		s3.WriteErrorResponse(w, s3.ErrNoSuchObjectLockConfiguration)
+		return
+	}
+
+	result := s3.LegalHold{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Status: meta.ObjectLockLegalHold,
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/auth"
+	"github.com/espen/stupid-simple-s3/internal/metrics"
+	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// maxFormFieldSize limits the size of a single non-file form field in a POST upload
+const maxFormFieldSize = 64 * 1024
+
+// maxFormFields limits the number of form fields preceding the file in a POST upload
+const maxFormFields = 100
+
+// errEntityTooSmall is returned when a POST upload is smaller than the policy allows
+var errEntityTooSmall = errors.New("entity too small")
+
+// isFormUpload checks if the request is a browser-based POST upload
+func isFormUpload(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// FormUploadRouter sends browser-based POST uploads to formHandler and all other
+// requests to next. Form uploads authenticate with a signed policy document in
+// the request body instead of an Authorization header, so they must bypass the
+// SigV4 middleware.
+func FormUploadRouter(formHandler http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.PathValue("key") == "" && isFormUpload(r) {
+				formHandler.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// minSizeReader returns errEntityTooSmall at EOF if fewer than min bytes were read
+type minSizeReader struct {
+	r    io.Reader
+	min  int64
+	read int64
+}
+
+func (m *minSizeReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.read += int64(n)
+	if err == io.EOF && m.read < m.min {
+		return n, errEntityTooSmall
+	}
+	return n, err
+}
+
+// PostPolicyUpload handles POST /{bucket} with multipart/form-data (browser-based upload)
+func (h *Handlers) PostPolicyUpload(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	if h.rejectLowDiskSpace(w, r) {
+		drainRequestBody(r)
+		return
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		s3.WriteErrorResponse(w, s3.ErrMalformedPOSTRequest)
+		return
+	}
+
+	// Read form fields up to the file field. S3 requires the file to be the
+	// last field, so the object data can be streamed to storage.
+	fields := make(map[string]string)
+	var submitted []string
+	var filePart *multipart.Part
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			drainRequestBody(r)
+			s3.WriteErrorResponse(w, s3.ErrMalformedPOSTRequest)
+			return
+		}
+
+		name := strings.ToLower(part.FormName())
+		if name == "" {
+			part.Close()
+			continue
+		}
+		if name == "file" {
+			filePart = part
+			break
+		}
+
+		if len(submitted) >= maxFormFields {
+			drainRequestBody(r)
+			s3.WriteErrorResponse(w, s3.ErrMalformedPOSTRequest)
+			return
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+		part.Close()
+		if err != nil || len(value) > maxFormFieldSize {
+			drainRequestBody(r)
+			s3.WriteErrorResponse(w, s3.ErrMalformedPOSTRequest)
+			return
+		}
+
+		if _, exists := fields[name]; !exists {
+			submitted = append(submitted, name)
+		}
+		fields[name] = string(value)
+	}
+
+	if filePart == nil || fields["key"] == "" {
+		drainRequestBody(r)
+		s3.WriteErrorResponse(w, s3.ErrMalformedPOSTRequest)
+		return
+	}
+	defer filePart.Close()
+
+	if !h.authenticatePostPolicy(w, r, fields) {
+		drainRequestBody(r)
+		return
+	}
+
+	policy, err := auth.ParsePostPolicy(fields["policy"])
+	if err != nil {
+		drainRequestBody(r)
+		s3.WriteErrorResponse(w, s3.ErrInvalidPolicyDocument)
+		return
+	}
+
+	if err := policy.CheckExpiration(time.Now().UTC()); err != nil {
+		drainRequestBody(r)
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonAccessDenied).Inc()
+		s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+		return
+	}
+
+	// The key may reference the uploaded file name
+	key := strings.ReplaceAll(fields["key"], "${filename}", filePart.FileName())
+
+	if v, ok := fields["bucket"]; ok && v != bucket {
+		drainRequestBody(r)
+		s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+		return
+	}
+
+	values := make(map[string]string, len(fields)+1)
+	for k, v := range fields {
+		values[k] = v
+	}
+	values["key"] = key
+	values["bucket"] = bucket
+
+	if err := policy.CheckFields(values, submitted); err != nil {
+		drainRequestBody(r)
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonAccessDenied).Inc()
+		s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+		return
+	}
+
+	// Only checked once the request is authorized, so that unauthenticated
+	// callers cannot probe which buckets exist
+	if err := h.validateBucketExists(bucket); err != nil {
+		drainRequestBody(r)
+		s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+		return
+	}
+
+	contentType := fields["content-type"]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Extract and validate user metadata (x-amz-meta-* fields)
+	userMetadata := make(map[string]string)
+	for name, value := range fields {
+		if !strings.HasPrefix(name, "x-amz-meta-") {
+			continue
+		}
+		metaKey := strings.TrimPrefix(name, "x-amz-meta-")
+		if validateMetadataKey(metaKey) != nil || validateMetadataValue(value) != nil {
+			drainRequestBody(r)
+			s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+			return
+		}
+		userMetadata[metaKey] = value
+	}
+
+	sse, err := parseServerSideEncryption(fields["x-amz-server-side-encryption"])
+	if err != nil {
+		drainRequestBody(r)
+		s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+		return
+	}
+
+	if h.rejectOverObjectLimit(w, r, bucket, key) {
+		drainRequestBody(r)
+		return
+	}
+
+	// Track active upload
+	defer h.trackUpload()()
+
+	// Enforce the policy's content-length-range and the global object size limit
+	var body io.Reader = filePart
+	maxSize := h.cfg.Limits.MaxObjectSize
+	if minSize, policyMax, ok := policy.ContentLengthRange(); ok {
+		if maxSize <= 0 || policyMax < maxSize {
+			maxSize = policyMax
+		}
+		body = &minSizeReader{r: body, min: minSize}
+	}
+	if maxSize > 0 {
+		body = newLimitedReader(body, maxSize)
+	}
+
+	meta, err := h.storage.PutObjectWithOptions(r.Context(), bucket, key, contentType, userMetadata, storage.PutObjectOptions{ServerSideEncryption: sse}, body)
+	if err != nil {
+		drainRequestBody(r)
+		if errors.Is(err, storage.ErrEntityTooLarge) {
+			s3.WriteErrorResponse(w, s3.ErrEntityTooLarge)
+			return
+		}
+		if errors.Is(err, errEntityTooSmall) {
+			s3.WriteErrorResponse(w, s3.ErrEntityTooSmall)
+			return
+		}
+		if errors.Is(err, storage.ErrInvalidKey) {
+			s3.WriteErrorResponse(w, invalidKeyError(err))
+			return
+		}
+		if errors.Is(err, storage.ErrObjectLocked) {
+			s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+			return
+		}
+		if isContextError(err) {
+			s3.WriteErrorResponse(w, s3.ErrRequestTimeout)
+			return
+		}
+		slog.Error("failed to put object from form upload", "error", err, "bucket", bucket, "key", key, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return
+	}
+	metrics.ObjectSizeBytes.WithLabelValues(metrics.OpPostObject).Observe(float64(meta.Size))
+	UpdateBucketUsageMetrics(h.storage, bucket)
+	auditObjectChange(r, key, meta)
+
+	w.Header().Set("ETag", meta.ETag)
+	setServerSideEncryptionHeader(w, meta)
+
+	switch fields["success_action_status"] {
+	case "200":
+		w.WriteHeader(http.StatusOK)
+	case "201":
+		result := s3.PostResponse{
+			Bucket: bucket,
+			Key:    key,
+			ETag:   meta.ETag,
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusCreated)
+		_ = xml.NewEncoder(w).Encode(result)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// authenticatePostPolicy verifies the policy signature in the form fields and
+// checks that the credential may write to the bucket. Writes an error response and returns
+// false on failure.
+func (h *Handlers) authenticatePostPolicy(w http.ResponseWriter, r *http.Request, fields map[string]string) bool {
+	if fields["x-amz-algorithm"] != auth.Algorithm || fields["policy"] == "" ||
+		fields["x-amz-credential"] == "" || fields["x-amz-date"] == "" || fields["x-amz-signature"] == "" {
+		time.Sleep(authFailureDelay)
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonMalformedHeader).Inc()
+		s3.WriteErrorResponse(w, s3.ErrInvalidPolicyDocument)
+		return false
+	}
+
+	parsed, err := auth.ParseCredential(fields["x-amz-credential"])
+	if err != nil {
+		time.Sleep(authFailureDelay)
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonMalformedHeader).Inc()
+		s3.WriteErrorResponse(w, s3.ErrAuthorizationHeaderMalformed)
+		return false
+	}
+
+	cred := h.cfg.GetCredential(parsed.AccessKeyID)
+	if cred == nil {
+		time.Sleep(authFailureDelay)
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonInvalidAccessKey).Inc()
+		s3.WriteErrorResponse(w, s3.ErrInvalidAccessKeyId)
+		return false
+	}
+
+	sigv4 := &auth.SignatureV4{Region: h.cfg.Server.Region, LenientDate: h.cfg.Auth.LenientDateParsing}
+	if _, err := sigv4.VerifyPostPolicy(fields["policy"], fields["x-amz-credential"], fields["x-amz-date"], fields["x-amz-signature"], cred.SecretAccessKey); err != nil {
+		time.Sleep(authFailureDelay)
+		if writeRegionMismatch(w, r, err) {
+			return false
+		}
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonSignatureMismatch).Inc()
+		s3.WriteErrorResponse(w, s3.ErrSignatureDoesNotMatch)
+		return false
+	}
+
+	if !checkSessionToken(w, r, cred, fields["x-amz-security-token"]) {
+		return false
+	}
+	logAuthentication(r, cred)
+
+	if !cred.CanWrite() || !cred.CanAccessBucket(r.PathValue("bucket")) {
+		metrics.AuthFailuresTotal.WithLabelValues(metrics.AuthReasonAccessDenied).Inc()
+		s3.WriteErrorResponse(w, s3.ErrAccessDenied)
+		return false
+	}
+
+	return true
+}
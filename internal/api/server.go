@@ -3,12 +3,15 @@ package api
 import (
 	"context"
 	"log/slog"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/espen/stupid-simple-s3/internal/config"
+	"github.com/espen/stupid-simple-s3/internal/metrics"
 	"github.com/espen/stupid-simple-s3/internal/storage"
 )
 
@@ -31,6 +34,10 @@ type Server struct {
 	handlers   *Handlers
 	mux        *http.ServeMux
 	httpServer *http.Server
+	// audit records data changes, nil when the audit log is disabled
+	audit *AuditLog
+	// activeRequests counts requests being served, for the shutdown logs
+	activeRequests atomic.Int64
 }
 
 // NewServer creates a new S3 server
@@ -47,22 +54,32 @@ func NewServer(cfg *config.Config, store storage.MultipartStorage) *Server {
 // setupRoutes configures the HTTP routes
 func (s *Server) setupRoutes() {
 	authMiddleware := AuthMiddleware(s.cfg)
+	formUploadRouter := FormUploadRouter(http.HandlerFunc(s.handlers.PostPolicyUpload))
 
 	// Bucket operations
-	s.mux.Handle("HEAD /{bucket}", MetricsMiddleware(authMiddleware(http.HandlerFunc(s.handlers.HeadBucket))))
-	s.mux.Handle("GET /{bucket}", MetricsMiddleware(authMiddleware(http.HandlerFunc(s.handlers.GetBucket))))
-	s.mux.Handle("PUT /{bucket}", MetricsMiddleware(authMiddleware(RequireWritePrivilege(http.HandlerFunc(s.handlers.CreateBucket)))))
-	s.mux.Handle("DELETE /{bucket}", MetricsMiddleware(authMiddleware(RequireWritePrivilege(http.HandlerFunc(s.handlers.DeleteBucket)))))
-	s.mux.Handle("POST /{bucket}", MetricsMiddleware(authMiddleware(RequireWritePrivilege(http.HandlerFunc(s.handlers.PostBucket)))))
+	s.mux.Handle("HEAD /{bucket}", MetricsMiddleware(authMiddleware(RequireBucketAccess(http.HandlerFunc(s.handlers.HeadBucket)))))
+	s.mux.Handle("GET /{bucket}", MetricsMiddleware(authMiddleware(RequireBucketAccess(http.HandlerFunc(s.handlers.GetBucket)))))
+	s.mux.Handle("PUT /{bucket}", MetricsMiddleware(s.auditMiddleware(authMiddleware(RequireBucketAccess(RequireWritePrivilege(http.HandlerFunc(s.handlers.CreateBucket)))))))
+	s.mux.Handle("DELETE /{bucket}", MetricsMiddleware(s.auditMiddleware(authMiddleware(RequireBucketAccess(RequireWritePrivilege(http.HandlerFunc(s.handlers.DeleteBucket)))))))
+	s.mux.Handle("POST /{bucket}", MetricsMiddleware(s.auditMiddleware(formUploadRouter(authMiddleware(RequireBucketAccess(RequireWritePrivilege(http.HandlerFunc(s.handlers.PostBucket))))))))
 
 	// Object operations (read)
-	s.mux.Handle("GET /{bucket}/{key...}", MetricsMiddleware(authMiddleware(http.HandlerFunc(s.handlers.GetObject))))
-	s.mux.Handle("HEAD /{bucket}/{key...}", MetricsMiddleware(authMiddleware(http.HandlerFunc(s.handlers.HeadObject))))
+	s.mux.Handle("GET /{bucket}/{key...}", MetricsMiddleware(authMiddleware(RequireBucketAccess(RejectLongKeys(http.HandlerFunc(s.handlers.GetObject))))))
+	s.mux.Handle("HEAD /{bucket}/{key...}", MetricsMiddleware(authMiddleware(RequireBucketAccess(RejectLongKeys(http.HandlerFunc(s.handlers.HeadObject))))))
 
 	// Object operations (write) - require write privilege
-	s.mux.Handle("PUT /{bucket}/{key...}", MetricsMiddleware(authMiddleware(RequireWritePrivilege(http.HandlerFunc(s.handlers.PutObject)))))
-	s.mux.Handle("DELETE /{bucket}/{key...}", MetricsMiddleware(authMiddleware(RequireWritePrivilege(http.HandlerFunc(s.handlers.DeleteObject)))))
-	s.mux.Handle("POST /{bucket}/{key...}", MetricsMiddleware(authMiddleware(RequireWritePrivilege(http.HandlerFunc(s.handlers.PostObject)))))
+	s.mux.Handle("PUT /{bucket}/{key...}", MetricsMiddleware(s.auditMiddleware(authMiddleware(RequireBucketAccess(RequireWritePrivilege(RejectLongKeys(http.HandlerFunc(s.handlers.PutObject))))))))
+	s.mux.Handle("DELETE /{bucket}/{key...}", MetricsMiddleware(s.auditMiddleware(authMiddleware(RequireBucketAccess(RequireWritePrivilege(RejectLongKeys(http.HandlerFunc(s.handlers.DeleteObject))))))))
+	s.mux.Handle("POST /{bucket}/{key...}", MetricsMiddleware(s.auditMiddleware(formUploadRouter(authMiddleware(RequireBucketAccess(RequireWritePrivilege(RejectLongKeys(http.HandlerFunc(s.handlers.PostObject)))))))))
+
+	// Preflight requests are answered by CORSMiddleware; other OPTIONS
+	// requests get an S3 error rather than the router's 405
+	s.mux.Handle("OPTIONS /{bucket}", http.HandlerFunc(s.handlers.Options))
+	s.mux.Handle("OPTIONS /{bucket}/{key...}", http.HandlerFunc(s.handlers.Options))
+
+	// Admin operations
+	s.mux.Handle("POST /admin/reindex/{bucket}", MetricsMiddleware(authMiddleware(RequireBucketAccess(RequireWritePrivilege(http.HandlerFunc(s.handlers.ReindexBucket))))))
+	s.mux.Handle("POST /admin/restore/{bucket}/{key...}", MetricsMiddleware(s.auditMiddleware(authMiddleware(RequireBucketAccess(RequireWritePrivilege(RejectLongKeys(http.HandlerFunc(s.handlers.RestoreObject))))))))
 }
 
 // Handler returns the HTTP handler that includes metrics endpoint
@@ -71,8 +88,12 @@ func (s *Server) Handler() http.Handler {
 	// This avoids Go 1.24+ routing conflicts between /metrics and /{bucket}
 	metricsAuth := MetricsBasicAuth(s.cfg.MetricsAuth.Username, s.cfg.MetricsAuth.Password)
 	metricsHandler := metricsAuth(promhttp.Handler())
+	s3Handler := TimeoutMiddleware(s.cfg.Server.RequestTimeout)(CORSMiddleware(s.handlers.storage)(s.mux))
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.activeRequests.Add(1)
+		defer s.activeRequests.Add(-1)
+
 		switch r.URL.Path {
 		case "/metrics":
 			metricsHandler.ServeHTTP(w, r)
@@ -82,23 +103,32 @@ func (s *Server) Handler() http.Handler {
 			_, _ = w.Write([]byte("ok"))
 			return
 		case "/readyz":
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte("ok"))
+			s.readyz(w, r)
 			return
 		case "/favicon.ico":
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		s.mux.ServeHTTP(w, r)
+		s3Handler.ServeHTTP(w, r)
 	})
 	// Apply middlewares: RequestID first, then AccessLog
-	return RequestIDMiddleware(AccessLogMiddleware(s.cfg.Server.TrustedProxies)(handler))
+	return RequestIDMiddleware(AccessLogMiddleware(s.cfg.Server.TrustedProxies, s.cfg.Log.AccessLogSampleRate)(handler))
 }
 
 // ListenAndServe starts the server with security-hardened timeouts
 func (s *Server) ListenAndServe() error {
-	slog.Info("starting S3 server", "address", s.cfg.Server.Address)
+	slog.Info("starting S3 server", "address", s.cfg.Server.Address, "tls", s.cfg.Server.TLSEnabled(), "max_connections", s.cfg.Server.MaxConnections)
+
+	ln, err := net.Listen("tcp", s.cfg.Server.Address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
 
+// Serve accepts connections on ln, at most Server.MaxConnections at a time
+func (s *Server) Serve(ln net.Listener) error {
+	ln = newLimitListener(ln, s.cfg.Server.MaxConnections)
 	s.httpServer = &http.Server{
 		Addr:              s.cfg.Server.Address,
 		Handler:           s.Handler(),
@@ -109,11 +139,73 @@ func (s *Server) ListenAndServe() error {
 		MaxHeaderBytes:    MaxHeaderBytes,
 	}
 
-	return s.httpServer.ListenAndServe()
+	if s.cfg.Server.TLSEnabled() {
+		reloader, err := newCertificateReloader(s.cfg.Server.TLSCertFile, s.cfg.Server.TLSKeyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		s.httpServer.TLSConfig = newTLSConfig(reloader.GetCertificate)
+		return s.httpServer.ServeTLS(ln, "", "")
+	}
+
+	return s.httpServer.Serve(ln)
 }
 
-// Shutdown gracefully shuts down the server without interrupting active connections
+// Shutdown gracefully shuts down the server without interrupting active
+// connections. If ctx expires while uploads or downloads are still in
+// progress, the server keeps waiting until the drain timeout, counted from
+// the start of the shutdown, so that large transfers and multipart
+// completions can finish. The remaining connections are closed once the
+// drain timeout, or ctx when there is nothing to drain, expires.
 func (s *Server) Shutdown(ctx context.Context) error {
-	slog.Info("shutting down server gracefully")
-	return s.httpServer.Shutdown(ctx)
+	start := time.Now()
+	slog.Info("shutting down server gracefully", s.activity()...)
+
+	err := s.httpServer.Shutdown(ctx)
+	if err == nil {
+		return nil
+	}
+	drainDeadline := start.Add(s.cfg.Server.DrainTimeout)
+	if !s.handlers.transferring() || !time.Now().Before(drainDeadline) {
+		slog.Warn("shutdown timeout reached, closing remaining connections", s.activity()...)
+		_ = s.httpServer.Close()
+		return err
+	}
+
+	slog.Info("draining active transfers", append(s.activity(), "drain_timeout", s.cfg.Server.DrainTimeout.String())...)
+	drainCtx, cancel := context.WithDeadline(context.Background(), drainDeadline)
+	defer cancel()
+	if err := s.httpServer.Shutdown(drainCtx); err != nil {
+		slog.Warn("drain timeout reached, closing remaining connections", s.activity()...)
+		_ = s.httpServer.Close()
+		return err
+	}
+	return nil
+}
+
+// activity returns the log attributes of the requests and transfers in
+// progress
+func (s *Server) activity() []any {
+	return []any{
+		"active_requests", s.activeRequests.Load(),
+		"active_uploads", s.handlers.activeUploads.Load(),
+		"active_downloads", s.handlers.activeDownloads.Load(),
+	}
+}
+
+// readyz reports whether the server can serve writes. It writes and removes
+// a file in each storage path, so a full or read-only disk takes the
+// instance out of rotation while /healthz keeps reporting it alive.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.handlers.storage.CheckWritable(); err != nil {
+		metrics.StorageWritable.Set(0)
+		slog.Error("readiness check failed", "error", err, "request_id", GetRequestID(r))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("storage not writable"))
+		return
+	}
+	metrics.StorageWritable.Set(1)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
 }
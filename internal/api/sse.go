@@ -0,0 +1,34 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// serverSideEncryptionHeader is the request and response header for SSE-S3
+const serverSideEncryptionHeader = "X-Amz-Server-Side-Encryption"
+
+// errUnsupportedEncryption is returned for encryption algorithms other than AES256
+var errUnsupportedEncryption = errors.New("unsupported server-side encryption algorithm")
+
+// parseServerSideEncryption validates a requested server-side encryption
+// algorithm. Only SSE-S3 (AES256) is accepted. The request is acknowledged
+// and recorded, but object data is stored unencrypted.
+func parseServerSideEncryption(value string) (string, error) {
+	switch value {
+	case "", s3.ServerSideEncryptionAES256:
+		return value, nil
+	default:
+		return "", errUnsupportedEncryption
+	}
+}
+
+// setServerSideEncryptionHeader echoes the server-side encryption recorded
+// for an object
+func setServerSideEncryptionHeader(w http.ResponseWriter, meta *s3.ObjectMetadata) {
+	if meta.ServerSideEncryption != "" {
+		w.Header().Set(serverSideEncryptionHeader, meta.ServerSideEncryption)
+	}
+}
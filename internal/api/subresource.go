@@ -0,0 +1,60 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// unsupportedBucketSubresources are the S3 bucket subresources this server
+// does not implement
+var unsupportedBucketSubresources = []string{
+	"accelerate",
+	"acl",
+	"analytics",
+	"encryption",
+	"intelligent-tiering",
+	"inventory",
+	"logging",
+	"metrics",
+	"notification",
+	"object-lock",
+	"ownershipControls",
+	"policy",
+	"policyStatus",
+	"publicAccessBlock",
+	"replication",
+	"requestPayment",
+	"tagging",
+	"uploads",
+	"website",
+}
+
+// unsupportedObjectSubresources are the S3 object subresources this server
+// does not implement
+var unsupportedObjectSubresources = []string{
+	"acl",
+	"attributes",
+	"restore",
+	"retention",
+	"select",
+	"tagging",
+	"torrent",
+}
+
+// rejectUnsupportedSubresource writes NotImplemented and returns true if the
+// request names one of the given subresources. Without it such requests
+// would be handled as the plain bucket or object operation. The
+// subresource is logged, but not repeated in the response.
+func rejectUnsupportedSubresource(w http.ResponseWriter, r *http.Request, subresources []string) bool {
+	query := r.URL.Query()
+	for _, name := range subresources {
+		if query.Has(name) {
+			slog.Info("unsupported subresource", "subresource", name, "method", r.Method, "bucket", r.PathValue("bucket"), "request_id", GetRequestID(r))
+			s3.WriteErrorResponse(w, s3.ErrNotImplemented)
+			return true
+		}
+	}
+	return false
+}
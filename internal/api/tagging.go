@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// Tagging request and response headers
+const (
+	taggingHeader           = "X-Amz-Tagging"
+	taggingDirectiveHeader  = "X-Amz-Tagging-Directive"
+	taggingCountHeader      = "X-Amz-Tagging-Count"
+	metadataDirectiveHeader = "X-Amz-Metadata-Directive"
+)
+
+// Object tag limits, as in S3
+const (
+	maxObjectTags     = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// Copy directives for x-amz-metadata-directive and x-amz-tagging-directive
+const (
+	directiveCopy    = "COPY"
+	directiveReplace = "REPLACE"
+)
+
+var (
+	errInvalidTag       = errors.New("invalid object tag")
+	errInvalidDirective = errors.New("invalid copy directive")
+)
+
+// parseTagging parses an x-amz-tagging header, a URL-encoded query string
+// such as "project=blue&team=infra". An empty header yields no tags.
+func parseTagging(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	values, err := url.ParseQuery(value)
+	if err != nil {
+		return nil, errInvalidTag
+	}
+	if len(values) > maxObjectTags {
+		return nil, errInvalidTag
+	}
+
+	tags := make(map[string]string, len(values))
+	for key, vals := range values {
+		if len(vals) != 1 {
+			return nil, errInvalidTag
+		}
+		if key == "" || utf8.RuneCountInString(key) > maxTagKeyLength || strings.HasPrefix(key, "aws:") {
+			return nil, errInvalidTag
+		}
+		if utf8.RuneCountInString(vals[0]) > maxTagValueLength {
+			return nil, errInvalidTag
+		}
+		tags[key] = vals[0]
+	}
+	return tags, nil
+}
+
+// parseCopyDirective parses x-amz-metadata-directive or
+// x-amz-tagging-directive, reporting whether the source's value is replaced
+func parseCopyDirective(value string) (replace bool, err error) {
+	switch value {
+	case "", directiveCopy:
+		return false, nil
+	case directiveReplace:
+		return true, nil
+	default:
+		return false, errInvalidDirective
+	}
+}
+
+// setTaggingCountHeader reports the number of tags on an object
+func setTaggingCountHeader(w http.ResponseWriter, meta *s3.ObjectMetadata) {
+	if len(meta.Tags) > 0 {
+		w.Header().Set(taggingCountHeader, strconv.Itoa(len(meta.Tags)))
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certificateCheckInterval is how often the certificate files are checked for changes
+const certificateCheckInterval = 30 * time.Second
+
+// newTLSConfig returns the TLS configuration for the listener: TLS 1.2 or
+// newer, AEAD cipher suites with forward secrecy for TLS 1.2, and the
+// certificate served by getCertificate.
+func newTLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+	}
+}
+
+// certificateReloader serves a certificate loaded from disk and reloads it
+// when the certificate or key file changes, so rotated certificates are
+// picked up without a restart.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+// newCertificateReloader loads the certificate and key. An error is returned
+// if they cannot be loaded at startup.
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the certificate and key from disk. Callers other than the
+// constructor must hold mu.
+func (r *certificateReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("reading TLS certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("reading TLS key: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. The files are checked
+// at most once per certificateCheckInterval. If a changed certificate cannot
+// be loaded, for example while only one of the files has been replaced, the
+// previous certificate is kept.
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastCheck) < certificateCheckInterval {
+		return r.cert, nil
+	}
+	r.lastCheck = now
+
+	certInfo, certErr := os.Stat(r.certFile)
+	keyInfo, keyErr := os.Stat(r.keyFile)
+	if certErr != nil || keyErr != nil {
+		slog.Warn("failed to stat TLS certificate, keeping previous certificate", "cert_file", r.certFile, "key_file", r.keyFile)
+		return r.cert, nil
+	}
+	if certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return r.cert, nil
+	}
+
+	if err := r.reload(); err != nil {
+		slog.Error("failed to reload TLS certificate, keeping previous certificate", "error", err)
+		return r.cert, nil
+	}
+	slog.Info("reloaded TLS certificate", "cert_file", r.certFile)
+	return r.cert, nil
+}
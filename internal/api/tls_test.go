@@ -0,0 +1,168 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate and key for commonName
+func writeTestCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "first")
+
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertificateReloader failed: %v", err)
+	}
+
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if got := commonName(t, cert); got != "first" {
+		t.Errorf("CommonName = %q, want %q", got, "first")
+	}
+
+	// Rotate the certificate and force the next call to check the files
+	writeTestCertificate(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+	reloader.lastCheck = time.Time{}
+
+	cert, _ = reloader.GetCertificate(nil)
+	if got := commonName(t, cert); got != "second" {
+		t.Errorf("CommonName after rotation = %q, want %q", got, "second")
+	}
+
+	// A broken key keeps the previous certificate
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	later := future.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	reloader.lastCheck = time.Time{}
+
+	cert, err = reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	if got := commonName(t, cert); got != "second" {
+		t.Errorf("CommonName after failed reload = %q, want %q", got, "second")
+	}
+
+	t.Run("missing files at startup", func(t *testing.T) {
+		if _, err := newCertificateReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestTLSConfigMinVersion(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "localhost")
+
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertificateReloader failed: %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", newTLSConfig(reloader.GetCertificate))
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		version uint16
+		wantErr bool
+	}{
+		{"TLS 1.1 rejected", tls.VersionTLS11, true},
+		{"TLS 1.2 accepted", tls.VersionTLS12, false},
+		{"TLS 1.3 accepted", tls.VersionTLS13, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+				InsecureSkipVerify: true,
+				MinVersion:         tt.version,
+				MaxVersion:         tt.version,
+			})
+			if conn != nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Dial error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
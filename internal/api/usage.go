@@ -0,0 +1,26 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/espen/stupid-simple-s3/internal/metrics"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// UpdateBucketUsageMetrics sets the object count and stored bytes gauges of
+// a bucket from its usage in store, removing them if the bucket is gone
+func UpdateBucketUsageMetrics(store storage.BucketStorage, bucket string) {
+	usage, err := store.BucketUsage(bucket)
+	if err != nil {
+		if errors.Is(err, storage.ErrBucketNotFound) {
+			metrics.ObjectsTotal.DeleteLabelValues(bucket)
+			metrics.BytesStoredTotal.DeleteLabelValues(bucket)
+			return
+		}
+		slog.Warn("failed to read bucket usage", "bucket", bucket, "error", err)
+		return
+	}
+	metrics.ObjectsTotal.WithLabelValues(bucket).Set(float64(usage.Objects))
+	metrics.BytesStoredTotal.WithLabelValues(bucket).Set(float64(usage.Bytes))
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/espen/stupid-simple-s3/internal/storage"
+)
+
+// Versioning response headers
+const (
+	versionIDHeader    = "X-Amz-Version-Id"
+	deleteMarkerHeader = "X-Amz-Delete-Marker"
+)
+
+// maxVersioningConfigSize bounds the PutBucketVersioning request body
+const maxVersioningConfigSize = 64 * 1024
+
+// PutBucketVersioning handles PUT /{bucket}?versioning
+func (h *Handlers) PutBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	var config s3.VersioningConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxVersioningConfigSize)).Decode(&config); err != nil {
+		s3.WriteErrorResponse(w, s3.ErrMalformedXML)
+		return
+	}
+
+	if err := h.storage.PutBucketVersioning(bucket, config.Status); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidVersioningStatus):
+			s3.WriteErrorResponse(w, s3.ErrIllegalVersioningConfiguration)
+		case errors.Is(err, storage.ErrBucketNotFound):
+			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+		case errors.Is(err, storage.ErrInvalidBucketName):
+			s3.WriteErrorResponse(w, s3.ErrInvalidBucketName)
+		default:
+			slog.Error("failed to put bucket versioning", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+			s3.WriteErrorResponse(w, s3.ErrInternalError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetBucketVersioning handles GET /{bucket}?versioning. A bucket that never
+// had versioning enabled reports no status.
+func (h *Handlers) GetBucketVersioning(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	status, err := h.storage.GetBucketVersioning(bucket)
+	if err != nil {
+		if errors.Is(err, storage.ErrBucketNotFound) {
+			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+			return
+		}
+		slog.Error("failed to get bucket versioning", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return
+	}
+
+	result := s3.VersioningConfiguration{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Status: status,
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// ListObjectVersions handles GET /{bucket}?versions
+func (h *Handlers) ListObjectVersions(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	query := r.URL.Query()
+
+	maxKeys := maxKeysLimit
+	if mk := query.Get("max-keys"); mk != "" {
+		parsed, err := strconv.Atoi(mk)
+		if err != nil || parsed < 0 {
+			s3.WriteErrorResponse(w, s3.ErrInvalidArgument)
+			return
+		}
+		if parsed < maxKeys {
+			maxKeys = parsed
+		}
+	}
+
+	opts := storage.ListObjectVersionsOptions{
+		Prefix:          query.Get("prefix"),
+		KeyMarker:       query.Get("key-marker"),
+		VersionIDMarker: query.Get("version-id-marker"),
+		MaxKeys:         maxKeys,
+	}
+
+	listed, err := h.storage.ListObjectVersions(bucket, opts)
+	if err != nil {
+		if errors.Is(err, storage.ErrBucketNotFound) {
+			s3.WriteErrorResponse(w, s3.ErrNoSuchBucket)
+			return
+		}
+		slog.Error("failed to list object versions", "error", err, "bucket", bucket, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return
+	}
+
+	result := s3.ListVersionsResult{
+		Xmlns:               "http://s3.amazonaws.com/doc/2006-03-01/",
+		Name:                bucket,
+		Prefix:              opts.Prefix,
+		KeyMarker:           opts.KeyMarker,
+		VersionIdMarker:     opts.VersionIDMarker,
+		NextKeyMarker:       listed.NextKeyMarker,
+		NextVersionIdMarker: listed.NextVersionIDMarker,
+		MaxKeys:             maxKeys,
+		IsTruncated:         listed.IsTruncated,
+	}
+	for _, v := range listed.Versions {
+		entry := s3.ObjectVersion{
+			XMLName:      xml.Name{Local: "Version"},
+			Key:          v.Key,
+			VersionId:    v.VersionID,
+			IsLatest:     v.IsLatest,
+			LastModified: v.LastModified,
+		}
+		if v.DeleteMarker {
+			entry.XMLName.Local = "DeleteMarker"
+		} else {
+			size := v.Size
+			entry.ETag = v.ETag
+			entry.Size = &size
+			entry.StorageClass = "STANDARD"
+		}
+		result.Versions = append(result.Versions, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// getObjectVersion handles GET /{bucket}/{key}?versionId=X. Range requests
+// are served by http.ServeContent.
+func (h *Handlers) getObjectVersion(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	key := r.PathValue("key")
+	versionID := r.URL.Query().Get("versionId")
+
+	defer h.trackDownload()()
+
+	reader, meta, err := h.storage.OpenObjectVersion(bucket, key, versionID)
+	if err != nil {
+		if errors.Is(err, storage.ErrDeleteMarker) {
+			setVersionIDHeader(w, meta)
+			w.Header().Set(deleteMarkerHeader, "true")
+			s3.WriteErrorResponse(w, s3.ErrMethodNotAllowed)
+			return
+		}
+		if writeVersionError(w, err) {
+			return
+		}
+		slog.Error("failed to get object version", "error", err, "bucket", bucket, "key", key, "version_id", versionID, "request_id", GetRequestID(r))
+		s3.WriteErrorResponse(w, s3.ErrInternalError)
+		return
+	}
+	defer reader.Close()
+
+	if r.URL.Query().Has("partNumber") {
+		serveObjectPart(w, r, reader, meta)
+		return
+	}
+	serveObject(w, r, reader, meta)
+}
+
+// writeVersionError writes the response for errors specific to object
+// versions. Returns false if err is not one of them.
+func writeVersionError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, storage.ErrNoSuchVersion):
+		s3.WriteErrorResponse(w, s3.ErrNoSuchVersion)
+	case errors.Is(err, storage.ErrInvalidKey):
+		s3.WriteErrorResponse(w, invalidKeyError(err))
+	default:
+		return false
+	}
+	return true
+}
+
+// setVersionIDHeader reports the version of an object in a versioned bucket
+func setVersionIDHeader(w http.ResponseWriter, meta *s3.ObjectMetadata) {
+	if meta.VersionID != "" {
+		w.Header().Set(versionIDHeader, meta.VersionID)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+// errTooManyXMLItems is returned when a request body has more items than
+// the request allows
+var errTooManyXMLItems = errors.New("too many items in XML body")
+
+// decodeXMLItems decodes an XML request body of at most maxBytes into v,
+// allowing at most maxItems child elements of the root element, such as the
+// parts of CompleteMultipartUpload. Decoding stops with errTooManyXMLItems
+// as soon as the limit is passed, so an oversized request is rejected
+// before its items are allocated.
+func decodeXMLItems(body io.Reader, maxBytes int64, maxItems int, v any) error {
+	counter := &xmlItemCounter{dec: xml.NewDecoder(io.LimitReader(body, maxBytes)), max: maxItems}
+	return xml.NewTokenDecoder(counter).Decode(v)
+}
+
+// xmlItemCounter passes on the raw tokens of dec, counting the elements
+// directly below the root. The decoder reading from it checks and
+// translates the tokens.
+type xmlItemCounter struct {
+	dec   *xml.Decoder
+	max   int
+	depth int
+	items int
+}
+
+func (c *xmlItemCounter) Token() (xml.Token, error) {
+	tok, err := c.dec.RawToken()
+	switch tok.(type) {
+	case xml.StartElement:
+		c.depth++
+		if c.depth == 2 {
+			if c.items++; c.items > c.max {
+				return nil, errTooManyXMLItems
+			}
+		}
+	case xml.EndElement:
+		c.depth--
+	}
+	return tok, err
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Post policy condition operators
+const (
+	PolicyOpEq                 = "eq"
+	PolicyOpStartsWith         = "starts-with"
+	PolicyOpContentLengthRange = "content-length-range"
+)
+
+// maxPolicySize is the maximum size of a base64-encoded policy document
+const maxPolicySize = 20 * 1024
+
+// ParsedCredential contains the components of an X-Amz-Credential value
+type ParsedCredential struct {
+	AccessKeyID string
+	Date        string
+	Region      string
+	Service     string
+}
+
+// PostPolicy is a decoded browser-based upload (POST) policy document
+type PostPolicy struct {
+	Expiration time.Time
+	Conditions []PolicyCondition
+}
+
+// PolicyCondition is a single condition from a POST policy document.
+// Field is lowercased and has the leading "$" removed.
+type PolicyCondition struct {
+	Operator string
+	Field    string
+	Value    string
+	Min      int64
+	Max      int64
+}
+
+// ParseCredential parses an X-Amz-Credential value: access-key/date/region/service/aws4_request
+func ParseCredential(credential string) (*ParsedCredential, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return nil, fmt.Errorf("invalid credential format")
+	}
+	for i, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid credential format: empty component at position %d", i)
+		}
+	}
+
+	return &ParsedCredential{
+		AccessKeyID: parts[0],
+		Date:        parts[1],
+		Region:      parts[2],
+		Service:     parts[3],
+	}, nil
+}
+
+// VerifyPostPolicy verifies the signature of a base64-encoded POST policy.
+// The signature is the hex HMAC-SHA256 of the encoded policy using the SigV4 signing key.
+func (s *SignatureV4) VerifyPostPolicy(policy, credential, amzDate, signature, secretKey string) (*AuthResult, error) {
+	parsed, err := ParseCredential(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkRegion(parsed.Region); err != nil {
+		return nil, err
+	}
+
+	requestTime, err := s.parseAmzDate(amzDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X-Amz-Date format: %w", err)
+	}
+
+	// Verify date in credential matches X-Amz-Date
+	if parsed.Date != requestTime.Format(DateFormat) {
+		return nil, fmt.Errorf("date mismatch between credential and X-Amz-Date")
+	}
+
+	signingKey := s.deriveSigningKey(secretKey, parsed.Date, parsed.Region, parsed.Service)
+	expectedSignature := hex.EncodeToString(hmacSHA256(signingKey, []byte(policy)))
+
+	if !hmac.Equal([]byte(expectedSignature), []byte(strings.ToLower(signature))) {
+		return nil, fmt.Errorf("signature mismatch")
+	}
+
+	return &AuthResult{
+		AccessKeyID: parsed.AccessKeyID,
+		Region:      parsed.Region,
+		Service:     parsed.Service,
+	}, nil
+}
+
+// ParsePostPolicy decodes a base64-encoded POST policy document
+func ParsePostPolicy(policy string) (*PostPolicy, error) {
+	if len(policy) > maxPolicySize {
+		return nil, fmt.Errorf("policy exceeds maximum size")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy encoding: %w", err)
+	}
+
+	var doc struct {
+		Expiration string            `json:"expiration"`
+		Conditions []json.RawMessage `json:"conditions"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid policy document: %w", err)
+	}
+
+	if doc.Expiration == "" {
+		return nil, fmt.Errorf("policy is missing expiration")
+	}
+	expiration, err := time.Parse(time.RFC3339Nano, doc.Expiration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy expiration: %w", err)
+	}
+
+	result := &PostPolicy{Expiration: expiration}
+	for _, raw := range doc.Conditions {
+		conds, err := parsePolicyCondition(raw)
+		if err != nil {
+			return nil, err
+		}
+		result.Conditions = append(result.Conditions, conds...)
+	}
+
+	return result, nil
+}
+
+// parsePolicyCondition parses either the {"field": "value"} exact-match form
+// or the ["operator", "$field", value] array form
+func parsePolicyCondition(raw json.RawMessage) ([]PolicyCondition, error) {
+	var exact map[string]string
+	if err := json.Unmarshal(raw, &exact); err == nil {
+		var conds []PolicyCondition
+		for field, value := range exact {
+			conds = append(conds, PolicyCondition{
+				Operator: PolicyOpEq,
+				Field:    strings.ToLower(field),
+				Value:    value,
+			})
+		}
+		return conds, nil
+	}
+
+	var arr []json.RawMessage
+	if err := json.Unmarshal(raw, &arr); err != nil || len(arr) != 3 {
+		return nil, fmt.Errorf("invalid policy condition: %s", raw)
+	}
+
+	var op string
+	if err := json.Unmarshal(arr[0], &op); err != nil {
+		return nil, fmt.Errorf("invalid policy condition operator: %s", raw)
+	}
+	op = strings.ToLower(op)
+
+	switch op {
+	case PolicyOpEq, PolicyOpStartsWith:
+		var field, value string
+		if err := json.Unmarshal(arr[1], &field); err != nil || !strings.HasPrefix(field, "$") {
+			return nil, fmt.Errorf("invalid policy condition field: %s", raw)
+		}
+		if err := json.Unmarshal(arr[2], &value); err != nil {
+			return nil, fmt.Errorf("invalid policy condition value: %s", raw)
+		}
+		return []PolicyCondition{{
+			Operator: op,
+			Field:    strings.ToLower(strings.TrimPrefix(field, "$")),
+			Value:    value,
+		}}, nil
+
+	case PolicyOpContentLengthRange:
+		minSize, err := parsePolicyInt(arr[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid content-length-range: %s", raw)
+		}
+		maxSize, err := parsePolicyInt(arr[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid content-length-range: %s", raw)
+		}
+		if minSize < 0 || maxSize < minSize {
+			return nil, fmt.Errorf("invalid content-length-range: %s", raw)
+		}
+		return []PolicyCondition{{
+			Operator: op,
+			Min:      minSize,
+			Max:      maxSize,
+		}}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported policy condition operator: %s", op)
+}
+
+// parsePolicyInt accepts an integer given either as a JSON number or a string
+func parsePolicyInt(raw json.RawMessage) (int64, error) {
+	var n int64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// CheckExpiration returns an error if the policy has expired
+func (p *PostPolicy) CheckExpiration(now time.Time) error {
+	if now.After(p.Expiration) {
+		return fmt.Errorf("policy expired")
+	}
+	return nil
+}
+
+// CheckFields verifies that the form fields satisfy every eq and starts-with
+// condition, and that every submitted field is covered by a condition.
+// Field names must be lowercased. Fields that are not user-supplied form
+// fields (such as bucket) may be passed in values without being listed in submitted.
+func (p *PostPolicy) CheckFields(values map[string]string, submitted []string) error {
+	covered := make(map[string]bool)
+
+	for _, cond := range p.Conditions {
+		switch cond.Operator {
+		case PolicyOpEq:
+			if values[cond.Field] != cond.Value {
+				return fmt.Errorf("policy condition failed: [\"eq\", \"$%s\", %q]", cond.Field, cond.Value)
+			}
+			covered[cond.Field] = true
+		case PolicyOpStartsWith:
+			if !strings.HasPrefix(values[cond.Field], cond.Value) {
+				return fmt.Errorf("policy condition failed: [\"starts-with\", \"$%s\", %q]", cond.Field, cond.Value)
+			}
+			covered[cond.Field] = true
+		}
+	}
+
+	for _, field := range submitted {
+		if isPolicyExemptField(field) {
+			continue
+		}
+		if !covered[field] {
+			return fmt.Errorf("extra input field: %s", field)
+		}
+	}
+
+	return nil
+}
+
+// ContentLengthRange returns the allowed upload size range, if the policy specifies one
+func (p *PostPolicy) ContentLengthRange() (minSize, maxSize int64, ok bool) {
+	for _, cond := range p.Conditions {
+		if cond.Operator == PolicyOpContentLengthRange {
+			return cond.Min, cond.Max, true
+		}
+	}
+	return 0, 0, false
+}
+
+// isPolicyExemptField reports whether a form field does not need a policy condition
+func isPolicyExemptField(field string) bool {
+	switch field {
+	case "x-amz-signature", "file", "policy":
+		return true
+	}
+	return strings.HasPrefix(field, "x-ignore-")
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReplayedRequest is returned for a signed request whose signature was
+// already seen
+var ErrReplayedRequest = errors.New("request signature was already used")
+
+// replaySweepInterval is how often expired signatures are removed
+const replaySweepInterval = time.Minute
+
+// ReplayCache remembers the signatures of recently verified requests, so
+// that a captured request cannot be sent again. A request is only accepted
+// within the clock skew window around its X-Amz-Date, so a signature has to
+// be kept no longer than that; older replays fail the skew check instead.
+type ReplayCache struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // signature to when it can be forgotten
+	nextSweep time.Time
+}
+
+// NewReplayCache creates a cache that remembers signatures for window after
+// their request time
+func NewReplayCache(window time.Duration) *ReplayCache {
+	return &ReplayCache{window: window, seen: make(map[string]time.Time)}
+}
+
+// check records the signature of a request signed at requestTime, and
+// returns ErrReplayedRequest if it was already recorded
+func (c *ReplayCache) check(signature string, requestTime, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.After(c.nextSweep) {
+		for sig, expiry := range c.seen {
+			if now.After(expiry) {
+				delete(c.seen, sig)
+			}
+		}
+		c.nextSweep = now.Add(replaySweepInterval)
+	}
+
+	if expiry, ok := c.seen[signature]; ok && !now.After(expiry) {
+		return ErrReplayedRequest
+	}
+	c.seen[signature] = requestTime.Add(c.window)
+	return nil
+}
@@ -22,14 +22,114 @@ const (
 	TimeFormat = "20060102T150405Z"
 	// DateFormat is the format used for the date in the credential scope
 	DateFormat = "20060102"
-	// MaxClockSkew is the maximum allowed time difference between request and server
+	// MaxClockSkew is the default maximum time difference between request
+	// and server
 	MaxClockSkew = 15 * time.Minute
-	// MaxPresignedExpiry is the maximum allowed expiry time for presigned URLs (7 days)
+	// MaxPresignedExpiry is the maximum allowed X-Amz-Expires of presigned
+	// URLs in seconds (7 days)
 	MaxPresignedExpiry = 7 * 24 * 60 * 60
 )
 
 // SignatureV4 handles AWS Signature Version 4 verification
-type SignatureV4 struct{}
+type SignatureV4 struct {
+	// Region is the region requests must be signed for. Empty or "*" accepts any region.
+	Region string
+	// LenientDate accepts common non-standard X-Amz-Date formats in addition
+	// to TimeFormat, for clients that do not format the timestamp exactly.
+	LenientDate bool
+	// Replays, when set, rejects requests with an Authorization header whose
+	// signature was already used. Presigned URLs may be used repeatedly.
+	Replays *ReplayCache
+	// ClockSkew is the maximum time difference between the request time and
+	// the server clock. Zero means MaxClockSkew.
+	ClockSkew time.Duration
+}
+
+// AllowedClockSkew returns the maximum allowed clock skew
+func (s *SignatureV4) AllowedClockSkew() time.Duration {
+	if s.ClockSkew > 0 {
+		return s.ClockSkew
+	}
+	return MaxClockSkew
+}
+
+// lenientTimeFormats are the X-Amz-Date formats accepted when LenientDate is
+// set. Timestamps without a zone are interpreted as UTC.
+var lenientTimeFormats = []string{
+	"20060102T150405",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	time.RFC1123,
+	time.RFC1123Z,
+}
+
+// parseAmzDate parses an X-Amz-Date value. Only TimeFormat is accepted
+// unless LenientDate is set.
+func (s *SignatureV4) parseAmzDate(value string) (time.Time, error) {
+	t, err := time.Parse(TimeFormat, value)
+	if err == nil || !s.LenientDate {
+		return t, err
+	}
+
+	// Timestamps are sometimes sent in lowercase; month and day names
+	// in RFC 1123 dates are matched case-insensitively either way
+	upper := strings.ToUpper(value)
+	for _, layout := range append([]string{TimeFormat}, lenientTimeFormats...) {
+		if t, err := time.Parse(layout, upper); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// RegionMismatchError is returned when the credential scope region does not
+// match the configured region
+type RegionMismatchError struct {
+	Region   string
+	Expected string
+}
+
+func (e *RegionMismatchError) Error() string {
+	return fmt.Sprintf("the region '%s' is wrong; expecting '%s'", e.Region, e.Expected)
+}
+
+// ClockSkewError is returned when the request time is further from the
+// server clock than the allowed clock skew
+type ClockSkewError struct {
+	RequestTime time.Time
+	ServerTime  time.Time
+	Max         time.Duration
+}
+
+// Skew returns the difference between the request time and the server clock
+func (e *ClockSkewError) Skew() time.Duration {
+	return e.ServerTime.Sub(e.RequestTime).Abs()
+}
+
+func (e *ClockSkewError) Error() string {
+	if e.RequestTime.After(e.ServerTime) {
+		return fmt.Sprintf("request time is in the future: %v ahead, allowed %v", e.Skew(), e.Max)
+	}
+	return fmt.Sprintf("request time too skewed: %v behind, allowed %v", e.Skew(), e.Max)
+}
+
+// QueryParametersError is returned when a presigned URL query parameter is
+// malformed or out of range
+type QueryParametersError struct {
+	Message string
+}
+
+func (e *QueryParametersError) Error() string {
+	return e.Message
+}
+
+// checkRegion verifies the credential scope region against the configured region
+func (s *SignatureV4) checkRegion(region string) error {
+	if s.Region == "" || s.Region == "*" || region == s.Region {
+		return nil
+	}
+	return &RegionMismatchError{Region: region, Expected: s.Region}
+}
 
 // AuthResult contains the result of signature verification
 type AuthResult struct {
@@ -37,6 +137,8 @@ type AuthResult struct {
 	Region        string
 	Service       string
 	SignedHeaders []string
+	// ChunkVerifier is set when the request body uses signed chunked encoding
+	ChunkVerifier *ChunkVerifier
 }
 
 // ParsedAuthorization contains parsed authorization header components
@@ -103,25 +205,25 @@ func (s *SignatureV4) VerifyRequest(r *http.Request, secretKey string) (*AuthRes
 		return nil, err
 	}
 
+	if err := s.checkRegion(parsed.Region); err != nil {
+		return nil, err
+	}
+
 	// Get and validate timestamp
 	amzDate := r.Header.Get("X-Amz-Date")
 	if amzDate == "" {
 		return nil, fmt.Errorf("missing X-Amz-Date header")
 	}
 
-	requestTime, err := time.Parse(TimeFormat, amzDate)
+	requestTime, err := s.parseAmzDate(amzDate)
 	if err != nil {
 		return nil, fmt.Errorf("invalid X-Amz-Date format: %w", err)
 	}
 
 	// Check clock skew
 	now := time.Now().UTC()
-	diff := now.Sub(requestTime)
-	if diff < 0 {
-		diff = -diff
-	}
-	if diff > MaxClockSkew {
-		return nil, fmt.Errorf("request time too skewed: %v", diff)
+	if now.Sub(requestTime).Abs() > s.AllowedClockSkew() {
+		return nil, &ClockSkewError{RequestTime: requestTime, ServerTime: now, Max: s.AllowedClockSkew()}
 	}
 
 	// Verify date in Authorization matches X-Amz-Date
@@ -129,6 +231,10 @@ func (s *SignatureV4) VerifyRequest(r *http.Request, secretKey string) (*AuthRes
 		return nil, fmt.Errorf("date mismatch between Authorization and X-Amz-Date")
 	}
 
+	if err := checkSignedHeaders(r, parsed.SignedHeaders); err != nil {
+		return nil, err
+	}
+
 	// Build canonical request
 	canonicalRequest, err := s.buildCanonicalRequest(r, parsed.SignedHeaders)
 	if err != nil {
@@ -148,12 +254,54 @@ func (s *SignatureV4) VerifyRequest(r *http.Request, secretKey string) (*AuthRes
 		return nil, fmt.Errorf("signature mismatch")
 	}
 
-	return &AuthResult{
+	if s.Replays != nil {
+		if err := s.Replays.check(parsed.Signature, requestTime, now); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &AuthResult{
 		AccessKeyID:   parsed.AccessKeyID,
 		Region:        parsed.Region,
 		Service:       parsed.Service,
 		SignedHeaders: parsed.SignedHeaders,
-	}, nil
+	}
+
+	// Chunk signatures are chained from the request signature
+	if r.Header.Get("X-Amz-Content-Sha256") == StreamingPayload {
+		result.ChunkVerifier = &ChunkVerifier{
+			signingKey:      signingKey,
+			amzDate:         amzDate,
+			credentialScope: credentialScope,
+			prevSignature:   parsed.Signature,
+		}
+	}
+
+	return result, nil
+}
+
+// checkSignedHeaders verifies that the signature covers the host and every
+// x-amz-* header of the request, as AWS requires. Otherwise such headers
+// could be added or altered after signing without invalidating the signature.
+func checkSignedHeaders(r *http.Request, signedHeaders []string) error {
+	signed := make(map[string]bool, len(signedHeaders))
+	for _, h := range signedHeaders {
+		signed[strings.ToLower(h)] = true
+	}
+	if !signed["host"] {
+		return fmt.Errorf("host header is not signed")
+	}
+	var unsigned []string
+	for name := range r.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") && !signed[name] {
+			unsigned = append(unsigned, name)
+		}
+	}
+	if len(unsigned) > 0 {
+		sort.Strings(unsigned)
+		return fmt.Errorf("headers present in the request are not signed: %s", strings.Join(unsigned, ", "))
+	}
+	return nil
 }
 
 // buildCanonicalRequest creates the canonical request string
@@ -357,14 +505,16 @@ func (s *SignatureV4) ParsePresignedURL(r *http.Request) (*ParsedPresignedURL, e
 		}
 	}
 
-	// Parse expires
-	expiresStr := query.Get("X-Amz-Expires")
-	expires, err := strconv.Atoi(expiresStr)
-	if err != nil || expires <= 0 {
-		return nil, fmt.Errorf("invalid expires value")
+	// Parse expires: 1 <= X-Amz-Expires <= MaxPresignedExpiry
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil {
+		return nil, &QueryParametersError{Message: "X-Amz-Expires should be a number"}
+	}
+	if expires < 1 {
+		return nil, &QueryParametersError{Message: "X-Amz-Expires must be at least 1 second"}
 	}
 	if expires > MaxPresignedExpiry {
-		return nil, fmt.Errorf("expires exceeds maximum allowed value")
+		return nil, &QueryParametersError{Message: fmt.Sprintf("X-Amz-Expires must be less than a week (in seconds) that is; %d", MaxPresignedExpiry)}
 	}
 
 	// Get date
@@ -415,8 +565,12 @@ func (s *SignatureV4) VerifyPresignedRequest(r *http.Request, secretKey string)
 		return nil, err
 	}
 
+	if err := s.checkRegion(parsed.Region); err != nil {
+		return nil, err
+	}
+
 	// Parse and validate timestamp
-	requestTime, err := time.Parse(TimeFormat, parsed.AmzDate)
+	requestTime, err := s.parseAmzDate(parsed.AmzDate)
 	if err != nil {
 		return nil, fmt.Errorf("invalid X-Amz-Date format: %w", err)
 	}
@@ -426,7 +580,8 @@ func (s *SignatureV4) VerifyPresignedRequest(r *http.Request, secretKey string)
 		return nil, fmt.Errorf("date mismatch between credential and X-Amz-Date")
 	}
 
-	// Check if the presigned URL has expired
+	// Check if the presigned URL has expired. The expiry counts from the
+	// signed X-Amz-Date, not from when the request is received.
 	now := time.Now().UTC()
 	expiryTime := requestTime.Add(time.Duration(parsed.Expires) * time.Second)
 	if now.After(expiryTime) {
@@ -434,8 +589,8 @@ func (s *SignatureV4) VerifyPresignedRequest(r *http.Request, secretKey string)
 	}
 
 	// Check if the request time is in the future (with some tolerance)
-	if requestTime.After(now.Add(MaxClockSkew)) {
-		return nil, fmt.Errorf("request time is in the future")
+	if requestTime.After(now.Add(s.AllowedClockSkew())) {
+		return nil, &ClockSkewError{RequestTime: requestTime, ServerTime: now, Max: s.AllowedClockSkew()}
 	}
 
 	// Build canonical request for presigned URL
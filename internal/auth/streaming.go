@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const (
+	// StreamingPayload is the X-Amz-Content-Sha256 value for chunked uploads
+	// where every chunk carries its own signature
+	StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// streamingAlgorithm is the algorithm in the string to sign for a chunk
+	streamingAlgorithm = "AWS4-HMAC-SHA256-PAYLOAD"
+)
+
+// emptySHA256 is the hex SHA-256 digest of an empty string
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// ErrChunkSignatureMismatch is returned when a chunk signature does not verify
+var ErrChunkSignatureMismatch = errors.New("chunk signature mismatch")
+
+// ChunkVerifier verifies the chained chunk signatures of a
+// STREAMING-AWS4-HMAC-SHA256-PAYLOAD body. Each chunk is signed over the
+// previous signature, starting from the seed signature of the request.
+type ChunkVerifier struct {
+	signingKey      []byte
+	amzDate         string
+	credentialScope string
+	prevSignature   string
+}
+
+// VerifyChunk checks the signature of the next chunk given the SHA-256 digest
+// of its data. Chunks must be verified in order, including the final empty chunk.
+func (v *ChunkVerifier) VerifyChunk(signature string, dataHash []byte) error {
+	stringToSign := strings.Join([]string{
+		streamingAlgorithm,
+		v.amzDate,
+		v.credentialScope,
+		v.prevSignature,
+		emptySHA256,
+		hex.EncodeToString(dataHash),
+	}, "\n")
+
+	expectedSignature := hex.EncodeToString(hmacSHA256(v.signingKey, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expectedSignature), []byte(signature)) {
+		return ErrChunkSignatureMismatch
+	}
+
+	v.prevSignature = expectedSignature
+	return nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// newExampleChunkVerifier returns a verifier for the chunked upload example
+// in the AWS Signature Version 4 documentation
+func newExampleChunkVerifier() *ChunkVerifier {
+	sigv4 := &SignatureV4{}
+	return &ChunkVerifier{
+		signingKey:      sigv4.deriveSigningKey("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY", "20130524", "us-east-1", "s3"),
+		amzDate:         "20130524T000000Z",
+		credentialScope: "20130524/us-east-1/s3/aws4_request",
+		prevSignature:   "4f232c4386841ef735655705268965c44a0e4690baa4adea153f7db9fa80a0a9",
+	}
+}
+
+func TestChunkVerifier(t *testing.T) {
+	chunks := []struct {
+		data      []byte
+		signature string
+	}{
+		{bytes.Repeat([]byte("a"), 65536), "ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648"},
+		{bytes.Repeat([]byte("a"), 1024), "0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497"},
+		{nil, "b6c6ea8a5354eaf15b3cb7646744f4275b71ea724fed81ceb9323e279d449df9"},
+	}
+
+	t.Run("valid chain", func(t *testing.T) {
+		v := newExampleChunkVerifier()
+		for i, c := range chunks {
+			sum := sha256.Sum256(c.data)
+			if err := v.VerifyChunk(c.signature, sum[:]); err != nil {
+				t.Fatalf("chunk %d: unexpected error: %v", i, err)
+			}
+		}
+	})
+
+	t.Run("tampered data", func(t *testing.T) {
+		v := newExampleChunkVerifier()
+		sum := sha256.Sum256(bytes.Repeat([]byte("b"), 65536))
+		if err := v.VerifyChunk(chunks[0].signature, sum[:]); !errors.Is(err, ErrChunkSignatureMismatch) {
+			t.Errorf("error = %v, want ErrChunkSignatureMismatch", err)
+		}
+	})
+
+	t.Run("chunks out of order", func(t *testing.T) {
+		v := newExampleChunkVerifier()
+		sum := sha256.Sum256(chunks[1].data)
+		if err := v.VerifyChunk(chunks[1].signature, sum[:]); !errors.Is(err, ErrChunkSignatureMismatch) {
+			t.Errorf("error = %v, want ErrChunkSignatureMismatch", err)
+		}
+	})
+
+	t.Run("missing signature", func(t *testing.T) {
+		v := newExampleChunkVerifier()
+		sum := sha256.Sum256(chunks[0].data)
+		if err := v.VerifyChunk("", sum[:]); !errors.Is(err, ErrChunkSignatureMismatch) {
+			t.Errorf("error = %v, want ErrChunkSignatureMismatch", err)
+		}
+	})
+}
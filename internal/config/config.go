@@ -1,11 +1,16 @@
 package config
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,15 +25,49 @@ type Credential struct {
 	AccessKeyID     string
 	SecretAccessKey string
 	Privileges      Privilege
+	SessionToken    string    // Required X-Amz-Security-Token for temporary credentials (optional)
+	Expiration      time.Time // Time after which the credential is rejected (zero = never)
+	Buckets         []string  // Bucket names the credential may access, "prefix*" matches a prefix (empty = all)
 }
 
 type Bucket struct {
-	Name string
+	Name       string
+	MaxObjects int64 // Maximum number of objects in each bucket (0 = unlimited)
+	// ObjectLimits overrides MaxObjects for individual buckets (0 = unlimited)
+	ObjectLimits map[string]int64
+}
+
+// ObjectLimit returns the maximum number of objects in bucket, or 0 if it
+// is unlimited
+func (b *Bucket) ObjectLimit(bucket string) int64 {
+	if limit, ok := b.ObjectLimits[bucket]; ok {
+		return limit
+	}
+	return b.MaxObjects
 }
 
 type Storage struct {
+	// Backend selects where objects are stored, "filesystem" or "memory".
+	// The memory backend loses all data on restart. Backend-specific
+	// settings are validated when the backend is created.
+	Backend       string
 	Path          string
 	MultipartPath string
+	MetadataStore string // "json" (meta.json per object) or "kv" (one metadata log per bucket)
+	// BucketPaths maps bucket names to alternate storage paths used instead
+	// of Path, such as a separate volume for a large bucket
+	BucketPaths map[string]string
+	// ImmutabilityWindows maps bucket names to a period after creation
+	// during which objects cannot be overwritten or deleted
+	ImmutabilityWindows map[string]time.Duration
+	// Durable fsyncs object data and metadata before acknowledging a write
+	Durable bool
+	// MetadataCacheEntries is the number of objects whose metadata is kept
+	// in memory (0 = no cache)
+	MetadataCacheEntries int64
+	// TrashRetention is how long objects deleted from unversioned buckets
+	// can be restored before the cleanup job purges them (0 = no trash)
+	TrashRetention time.Duration
 }
 
 // Limits contains resource limits for the service
@@ -36,6 +75,9 @@ type Limits struct {
 	MaxObjectSize int64 // Maximum size of a single object in bytes (0 = unlimited)
 	MaxPartSize   int64 // Maximum size of a single multipart part in bytes (0 = unlimited)
 	MaxChunkSize  int64 // Maximum size of a single AWS chunked encoding chunk in bytes
+	MaxBuckets    int64 // Maximum number of buckets (0 = unlimited)
+	ListMetadata  bool  // Enables the metadata=true listing extension
+	MinFreeBytes  int64 // Free disk space below which writes are rejected (0 = no minimum)
 }
 
 // DefaultMaxObjectSize is 5GB (S3's maximum for single PUT)
@@ -80,12 +122,27 @@ func (c *Cleanup) GetMaxAge() time.Duration {
 
 type Server struct {
 	Address         string
+	Region          string        // Region requests must be signed for ("" or "*" accepts any region)
 	TrustedProxies  []string      // List of trusted proxy IPs/CIDRs that can set X-Forwarded-For
 	ReadTimeout     time.Duration // Maximum duration for reading entire request
 	WriteTimeout    time.Duration // Maximum duration for writing response
 	ShutdownTimeout time.Duration // Maximum duration for graceful shutdown
+	DrainTimeout    time.Duration // Maximum duration transfers may continue during shutdown (0 = ShutdownTimeout)
+	RequestTimeout  time.Duration // Maximum duration of a request's storage operations (0 = unlimited)
+	MaxClockSkew    time.Duration // Maximum difference between a request's signing time and the server clock
+	MaxConnections  int64         // Maximum number of concurrent client connections (0 = unlimited)
+	TLSCertFile     string        // PEM certificate for HTTPS (optional, requires TLSKeyFile)
+	TLSKeyFile      string        // PEM private key for HTTPS (optional, requires TLSCertFile)
 }
 
+// TLSEnabled returns true if the server should serve HTTPS
+func (s *Server) TLSEnabled() bool {
+	return s.TLSCertFile != "" && s.TLSKeyFile != ""
+}
+
+// DefaultRegion is the region requests are expected to be signed for
+const DefaultRegion = "us-east-1"
+
 // DefaultReadTimeout is 30 minutes to allow large uploads
 const DefaultReadTimeout = 30 * time.Minute
 
@@ -95,6 +152,10 @@ const DefaultWriteTimeout = 30 * time.Minute
 // DefaultShutdownTimeout is the default maximum time to wait for graceful shutdown
 const DefaultShutdownTimeout = 30 * time.Second
 
+// DefaultMaxClockSkew is the default maximum difference between a request's
+// signing time and the server clock, as in AWS
+const DefaultMaxClockSkew = 15 * time.Minute
+
 type MetricsAuth struct {
 	Username string
 	Password string
@@ -105,10 +166,64 @@ func (m *MetricsAuth) Enabled() bool {
 	return m.Username != "" && m.Password != ""
 }
 
+// Owner is the bucket and object owner reported in S3 responses. When ID is
+// empty the owner is derived from the credential of each request.
+type Owner struct {
+	ID          string
+	DisplayName string
+}
+
+// Auth contains authorization behavior settings
+type Auth struct {
+	// HideNotFound returns AccessDenied instead of NoSuchKey for missing objects
+	// to unauthenticated requests and credentials without access to the
+	// bucket, so that they cannot probe for the existence of keys
+	HideNotFound bool
+
+	// LenientDateParsing accepts common non-standard X-Amz-Date formats
+	// (RFC 1123, ISO 8601, missing "Z", lowercase) from hand-rolled clients
+	LenientDateParsing bool
+
+	// CredentialsFile is an optional JSON or YAML file with additional
+	// credentials. It is reloaded every CredentialsReloadInterval when it changes.
+	CredentialsFile           string
+	CredentialsReloadInterval time.Duration
+
+	// ContinuationTokenSecret is the secret list continuation tokens are
+	// signed with. When empty, the key is derived from Credentials.
+	ContinuationTokenSecret string
+
+	// ReplayProtection rejects signed requests whose signature was already
+	// used within the clock skew window
+	ReplayProtection bool
+
+	// PublicRead lists the buckets ("bucket") and key prefixes
+	// ("bucket/prefix") whose objects can be read without authentication
+	PublicRead []string
+}
+
+// IsPublicRead returns true if the object may be read without
+// authentication. Only object keys are matched, so listing a public bucket
+// still requires a credential.
+func (a *Auth) IsPublicRead(bucket, key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, entry := range a.PublicRead {
+		publicBucket, prefix, _ := strings.Cut(entry, "/")
+		if publicBucket == bucket && strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // LogConfig holds logging configuration
 type LogConfig struct {
-	Format string // "json" or "text"
-	Level  string // "debug", "info", "warn", "error"
+	Format              string // "json" or "text"
+	Level               string // "debug", "info", "warn", "error"
+	AccessLogSampleRate int64  // Log 1 in N successful requests; errors are always logged (<= 1 = log all)
+	AuditLog            string // Audit log file path, or "stdout" (empty = disabled)
 }
 
 type Config struct {
@@ -120,15 +235,31 @@ type Config struct {
 	MetricsAuth MetricsAuth
 	Limits      Limits
 	Log         LogConfig
+	Auth        Auth
+	Owner       Owner
+
+	// fileCredentials holds the credentials loaded from Auth.CredentialsFile.
+	// It is swapped atomically on reload.
+	fileCredentials atomic.Pointer[[]Credential]
 }
 
 // Load creates a configuration from environment variables.
 // Environment variables:
 //   - STUPID_HOST: Listen host (default: all interfaces)
 //   - STUPID_PORT: Listen port (default: "5553")
+//   - STUPID_REGION: Region requests must be signed for, "*" accepts any region (default: "us-east-1")
 //   - STUPID_BUCKET_NAME: Bucket name to auto-create at startup (optional)
+//   - STUPID_BUCKET_MAX_OBJECTS: Maximum number of objects in each bucket (default: 0, unlimited)
+//   - STUPID_BUCKET_OBJECT_LIMITS: Comma-separated bucket=count pairs overriding STUPID_BUCKET_MAX_OBJECTS, 0 is unlimited (optional)
+//   - STUPID_STORAGE_BACKEND: Storage backend, "filesystem" or "memory" (default: "filesystem")
 //   - STUPID_STORAGE_PATH: Storage path (default: "/var/lib/stupid-simple-s3/data")
 //   - STUPID_MULTIPART_PATH: Multipart storage path (default: "/var/lib/stupid-simple-s3/tmp")
+//   - STUPID_METADATA_STORE: Object metadata store, "json" or "kv" (default: "json")
+//   - STUPID_BUCKET_PATHS: Comma-separated "bucket=/path" pairs storing buckets outside the storage path (optional)
+//   - STUPID_STORAGE_DURABLE: Fsync objects before acknowledging writes (default: "true")
+//   - STUPID_METADATA_CACHE_ENTRIES: Number of objects whose metadata is cached in memory (default: 0, disabled)
+//   - STUPID_BUCKET_IMMUTABILITY_WINDOWS: Comma-separated "bucket=duration" pairs protecting new objects from overwrite and delete (optional)
+//   - STUPID_TRASH_RETENTION: How long deleted objects can be restored from the trash (default: "0", disabled)
 //   - STUPID_CLEANUP_ENABLED: Enable cleanup job (default: "true")
 //   - STUPID_CLEANUP_INTERVAL: Cleanup interval (default: "1h")
 //   - STUPID_CLEANUP_MAX_AGE: Max age for stale uploads (default: "24h")
@@ -136,17 +267,38 @@ type Config struct {
 //   - STUPID_RO_SECRET_KEY: Read-only user secret key
 //   - STUPID_RW_ACCESS_KEY: Read-write user access key
 //   - STUPID_RW_SECRET_KEY: Read-write user secret key
+//   - STUPID_RO_SESSION_TOKEN, STUPID_RW_SESSION_TOKEN: Session token required with the credential (optional)
+//   - STUPID_RO_EXPIRATION, STUPID_RW_EXPIRATION: RFC 3339 time after which the credential expires (optional)
+//   - STUPID_RO_BUCKETS, STUPID_RW_BUCKETS: Comma-separated bucket names or "prefix*" patterns the credential may access (optional)
+//   - STUPID_CREDENTIALS_FILE: JSON or YAML file with additional credentials, reloaded on change (optional)
+//   - STUPID_CREDENTIALS_RELOAD_INTERVAL: How often the credentials file is checked for changes (default: "10s")
 //   - STUPID_METRICS_USERNAME: Username for /metrics basic auth (optional)
 //   - STUPID_METRICS_PASSWORD: Password for /metrics basic auth (optional)
 //   - STUPID_MAX_OBJECT_SIZE: Maximum object size in bytes (default: 5GB)
 //   - STUPID_MAX_PART_SIZE: Maximum multipart part size in bytes (default: 5GB)
 //   - STUPID_MAX_CHUNK_SIZE: Maximum AWS chunked encoding chunk size in bytes (default: 5GB)
+//   - STUPID_MAX_BUCKETS: Maximum number of buckets (default: 0, unlimited)
+//   - STUPID_LIST_METADATA: Include user metadata in listings requested with metadata=true (default: "false")
+//   - STUPID_MIN_FREE_BYTES: Free disk space below which uploads are rejected (default: 0, no minimum)
 //   - STUPID_TRUSTED_PROXIES: Comma-separated list of trusted proxy IPs/CIDRs (optional)
 //   - STUPID_READ_TIMEOUT: Maximum duration for reading requests (default: "30m")
 //   - STUPID_WRITE_TIMEOUT: Maximum duration for writing responses (default: "30m")
 //   - STUPID_SHUTDOWN_TIMEOUT: Maximum duration for graceful shutdown (default: "30s")
+//   - STUPID_DRAIN_TIMEOUT: Maximum duration uploads and downloads may continue during shutdown (default: "0", same as the shutdown timeout)
+//   - STUPID_REQUEST_TIMEOUT: Maximum duration of a request's storage operations (default: "0", unlimited)
+//   - STUPID_MAX_CLOCK_SKEW: Maximum difference between a request's signing time and the server clock (default: "15m")
+//   - STUPID_MAX_CONNECTIONS: Maximum number of concurrent client connections (default: 0, unlimited)
+//   - STUPID_TLS_CERT_FILE, STUPID_TLS_KEY_FILE: PEM certificate and key to serve HTTPS, reloaded on change (optional)
 //   - STUPID_LOG_FORMAT: Log output format, "json" or "text" (default: "text")
 //   - STUPID_LOG_LEVEL: Log level, "debug", "info", "warn", "error" (default: "info")
+//   - STUPID_ACCESS_LOG_SAMPLE_RATE: Log 1 in N successful requests, errors are always logged (default: 1)
+//   - STUPID_AUDIT_LOG: Audit log file path for data-changing requests, or "stdout" (default: disabled)
+//   - STUPID_OWNER_ID, STUPID_OWNER_DISPLAY_NAME: Owner reported in listings (default: derived from the request credential)
+//   - STUPID_CONTINUATION_TOKEN_SECRET: Secret for signing list continuation tokens (default: derived from the credentials)
+//   - STUPID_HIDE_NOT_FOUND: Return AccessDenied instead of NoSuchKey for missing objects to unauthenticated or unauthorized requests (default: "false")
+//   - STUPID_LENIENT_DATE_PARSING: Accept non-standard X-Amz-Date formats from hand-rolled clients (default: "false")
+//   - STUPID_REPLAY_PROTECTION: Reject signed requests whose signature was already used (default: "false")
+//   - STUPID_PUBLIC_READ: Comma-separated buckets or "bucket/prefix" entries whose objects can be read without authentication (optional)
 func Load() (*Config, error) {
 	host := os.Getenv("STUPID_HOST")
 	port := os.Getenv("STUPID_PORT")
@@ -167,30 +319,51 @@ func Load() (*Config, error) {
 	}
 
 	// Parse trusted proxies
-	var trustedProxies []string
-	if proxyList := os.Getenv("STUPID_TRUSTED_PROXIES"); proxyList != "" {
-		for _, proxy := range strings.Split(proxyList, ",") {
-			proxy = strings.TrimSpace(proxy)
-			if proxy != "" {
-				trustedProxies = append(trustedProxies, proxy)
-			}
-		}
+	trustedProxies := parseEnvList("STUPID_TRUSTED_PROXIES")
+
+	bucketPaths, err := LoadBucketPaths()
+	if err != nil {
+		return nil, err
+	}
+	immutabilityWindows, err := parseEnvDurationMap("STUPID_BUCKET_IMMUTABILITY_WINDOWS")
+	if err != nil {
+		return nil, err
+	}
+	objectLimits, err := parseEnvInt64Map("STUPID_BUCKET_OBJECT_LIMITS")
+	if err != nil {
+		return nil, err
 	}
 
 	cfg := &Config{
 		Bucket: Bucket{
-			Name: os.Getenv("STUPID_BUCKET_NAME"),
+			Name:         os.Getenv("STUPID_BUCKET_NAME"),
+			MaxObjects:   parseEnvInt64("STUPID_BUCKET_MAX_OBJECTS", 0),
+			ObjectLimits: objectLimits,
 		},
 		Storage: Storage{
-			Path:          storagePath,
-			MultipartPath: multipartPath,
+			Backend:              getEnvOrDefault("STUPID_STORAGE_BACKEND", "filesystem"),
+			Path:                 storagePath,
+			MultipartPath:        multipartPath,
+			MetadataStore:        getEnvOrDefault("STUPID_METADATA_STORE", "json"),
+			BucketPaths:          bucketPaths,
+			ImmutabilityWindows:  immutabilityWindows,
+			Durable:              os.Getenv("STUPID_STORAGE_DURABLE") != "false",
+			MetadataCacheEntries: parseEnvInt64("STUPID_METADATA_CACHE_ENTRIES", 0),
+			TrashRetention:       parseEnvDuration("STUPID_TRASH_RETENTION", 0),
 		},
 		Server: Server{
 			Address:         address,
+			Region:          getEnvOrDefault("STUPID_REGION", DefaultRegion),
 			TrustedProxies:  trustedProxies,
 			ReadTimeout:     parseEnvDuration("STUPID_READ_TIMEOUT", DefaultReadTimeout),
 			WriteTimeout:    parseEnvDuration("STUPID_WRITE_TIMEOUT", DefaultWriteTimeout),
 			ShutdownTimeout: parseEnvDuration("STUPID_SHUTDOWN_TIMEOUT", DefaultShutdownTimeout),
+			DrainTimeout:    parseEnvDuration("STUPID_DRAIN_TIMEOUT", 0),
+			RequestTimeout:  parseEnvDuration("STUPID_REQUEST_TIMEOUT", 0),
+			MaxClockSkew:    parseEnvDuration("STUPID_MAX_CLOCK_SKEW", DefaultMaxClockSkew),
+			MaxConnections:  parseEnvInt64("STUPID_MAX_CONNECTIONS", 0),
+			TLSCertFile:     os.Getenv("STUPID_TLS_CERT_FILE"),
+			TLSKeyFile:      os.Getenv("STUPID_TLS_KEY_FILE"),
 		},
 		Cleanup: Cleanup{
 			Enabled:  os.Getenv("STUPID_CLEANUP_ENABLED") != "false",
@@ -205,10 +378,28 @@ func Load() (*Config, error) {
 			MaxObjectSize: parseEnvInt64("STUPID_MAX_OBJECT_SIZE", DefaultMaxObjectSize),
 			MaxPartSize:   parseEnvInt64("STUPID_MAX_PART_SIZE", DefaultMaxPartSize),
 			MaxChunkSize:  parseEnvInt64("STUPID_MAX_CHUNK_SIZE", DefaultMaxChunkSize),
+			MaxBuckets:    parseEnvInt64("STUPID_MAX_BUCKETS", 0),
+			ListMetadata:  os.Getenv("STUPID_LIST_METADATA") == "true",
+			MinFreeBytes:  parseEnvInt64("STUPID_MIN_FREE_BYTES", 0),
 		},
 		Log: LogConfig{
-			Format: getEnvOrDefault("STUPID_LOG_FORMAT", "text"),
-			Level:  getEnvOrDefault("STUPID_LOG_LEVEL", "info"),
+			Format:              getEnvOrDefault("STUPID_LOG_FORMAT", "text"),
+			Level:               getEnvOrDefault("STUPID_LOG_LEVEL", "info"),
+			AccessLogSampleRate: parseEnvInt64("STUPID_ACCESS_LOG_SAMPLE_RATE", 1),
+			AuditLog:            os.Getenv("STUPID_AUDIT_LOG"),
+		},
+		Auth: Auth{
+			HideNotFound:              os.Getenv("STUPID_HIDE_NOT_FOUND") == "true",
+			LenientDateParsing:        os.Getenv("STUPID_LENIENT_DATE_PARSING") == "true",
+			ReplayProtection:          os.Getenv("STUPID_REPLAY_PROTECTION") == "true",
+			PublicRead:                parseEnvList("STUPID_PUBLIC_READ"),
+			CredentialsFile:           os.Getenv("STUPID_CREDENTIALS_FILE"),
+			CredentialsReloadInterval: parseEnvDuration("STUPID_CREDENTIALS_RELOAD_INTERVAL", DefaultCredentialsReloadInterval),
+			ContinuationTokenSecret:   os.Getenv("STUPID_CONTINUATION_TOKEN_SECRET"),
+		},
+		Owner: Owner{
+			ID:          os.Getenv("STUPID_OWNER_ID"),
+			DisplayName: os.Getenv("STUPID_OWNER_DISPLAY_NAME"),
 		},
 	}
 
@@ -216,10 +407,17 @@ func Load() (*Config, error) {
 	roAccessKey := os.Getenv("STUPID_RO_ACCESS_KEY")
 	roSecretKey := os.Getenv("STUPID_RO_SECRET_KEY")
 	if roAccessKey != "" && roSecretKey != "" {
+		roExpiration, err := parseEnvTime("STUPID_RO_EXPIRATION")
+		if err != nil {
+			return nil, err
+		}
 		cfg.Credentials = append(cfg.Credentials, Credential{
 			AccessKeyID:     roAccessKey,
 			SecretAccessKey: roSecretKey,
 			Privileges:      PrivilegeRead,
+			SessionToken:    os.Getenv("STUPID_RO_SESSION_TOKEN"),
+			Expiration:      roExpiration,
+			Buckets:         parseEnvList("STUPID_RO_BUCKETS"),
 		})
 	}
 
@@ -227,13 +425,26 @@ func Load() (*Config, error) {
 	rwAccessKey := os.Getenv("STUPID_RW_ACCESS_KEY")
 	rwSecretKey := os.Getenv("STUPID_RW_SECRET_KEY")
 	if rwAccessKey != "" && rwSecretKey != "" {
+		rwExpiration, err := parseEnvTime("STUPID_RW_EXPIRATION")
+		if err != nil {
+			return nil, err
+		}
 		cfg.Credentials = append(cfg.Credentials, Credential{
 			AccessKeyID:     rwAccessKey,
 			SecretAccessKey: rwSecretKey,
 			Privileges:      PrivilegeReadWrite,
+			SessionToken:    os.Getenv("STUPID_RW_SESSION_TOKEN"),
+			Expiration:      rwExpiration,
+			Buckets:         parseEnvList("STUPID_RW_BUCKETS"),
 		})
 	}
 
+	if cfg.Auth.CredentialsFile != "" {
+		if err := cfg.ReloadCredentialsFile(); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := cfg.validate(); err != nil {
 		return nil, fmt.Errorf("validating config: %w", err)
 	}
@@ -266,6 +477,108 @@ func parseEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+// parseEnvList parses a comma-separated list, dropping empty entries
+func parseEnvList(key string) []string {
+	var list []string
+	if value := os.Getenv(key); value != "" {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// LoadBucketPaths parses STUPID_BUCKET_PATHS on its own, for the offline
+// tools that work on a data directory without the rest of the
+// configuration
+func LoadBucketPaths() (map[string]string, error) {
+	return parseEnvMap("STUPID_BUCKET_PATHS")
+}
+
+// parseEnvMap parses a comma-separated list of key=value pairs, dropping
+// empty entries. An entry without "=" is an error.
+func parseEnvMap(key string) (map[string]string, error) {
+	list := parseEnvList(key)
+	if len(list) == 0 {
+		return nil, nil
+	}
+	parsed := make(map[string]string, len(list))
+	for _, item := range list {
+		k, v, ok := strings.Cut(item, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("parsing %s: entry %q is not key=value", key, item)
+		}
+		if _, dup := parsed[k]; dup {
+			return nil, fmt.Errorf("parsing %s: duplicate key %q", key, k)
+		}
+		parsed[k] = v
+	}
+	return parsed, nil
+}
+
+// parseEnvDurationMap parses a comma-separated list of key=duration pairs.
+// Unlike parseEnvDuration an invalid value is an error, since ignoring it
+// would silently drop a protection.
+func parseEnvDurationMap(key string) (map[string]time.Duration, error) {
+	entries, err := parseEnvMap(key)
+	if err != nil || entries == nil {
+		return nil, err
+	}
+	parsed := make(map[string]time.Duration, len(entries))
+	for k, v := range entries {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", key, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("parsing %s: duration for %q must be positive", key, k)
+		}
+		parsed[k] = d
+	}
+	return parsed, nil
+}
+
+// parseEnvInt64Map parses a comma-separated list of key=count pairs. Unlike
+// parseEnvInt64 an invalid value is an error, since ignoring it would
+// silently fall back to another limit.
+func parseEnvInt64Map(key string) (map[string]int64, error) {
+	entries, err := parseEnvMap(key)
+	if err != nil || entries == nil {
+		return nil, err
+	}
+	parsed := make(map[string]int64, len(entries))
+	for k, v := range entries {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", key, err)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("parsing %s: count for %q must not be negative", key, k)
+		}
+		parsed[k] = n
+	}
+	return parsed, nil
+}
+
+// parseEnvTime parses an optional RFC 3339 timestamp. Unlike the other parsers
+// an invalid value is an error, since silently dropping an expiry would keep
+// a credential valid forever.
+func parseEnvTime(key string) (time.Time, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing %s: %w", key, err)
+	}
+	return parsed, nil
+}
+
 func (c *Config) validate() error {
 	// Bucket name is optional - buckets can be created via API
 	if c.Storage.Path == "" {
@@ -274,34 +587,111 @@ func (c *Config) validate() error {
 	if c.Storage.MultipartPath == "" {
 		return fmt.Errorf("storage.multipart_path is required")
 	}
+	if c.Storage.MetadataStore != "json" && c.Storage.MetadataStore != "kv" {
+		return fmt.Errorf("storage.metadata_store must be 'json' or 'kv'")
+	}
+	if c.Storage.Backend == "" {
+		return fmt.Errorf("storage.backend is required")
+	}
+	if c.Storage.MetadataCacheEntries < 0 {
+		return fmt.Errorf("storage.metadata_cache_entries must not be negative")
+	}
+	if c.Storage.TrashRetention < 0 {
+		return fmt.Errorf("storage.trash_retention must not be negative")
+	}
+	if c.Storage.Backend == "memory" && c.Limits.MinFreeBytes > 0 {
+		return fmt.Errorf("limits.min_free_bytes requires the filesystem storage backend")
+	}
+	for bucket, path := range c.Storage.BucketPaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("storage.bucket_paths[%s] must be an absolute path", bucket)
+		}
+	}
 	if c.Server.Address == "" {
 		return fmt.Errorf("server.address is required")
 	}
-	if len(c.Credentials) == 0 {
+	if c.Server.DrainTimeout != 0 && c.Server.DrainTimeout < c.Server.ShutdownTimeout {
+		return fmt.Errorf("server.drain_timeout must not be shorter than server.shutdown_timeout")
+	}
+	if c.Server.RequestTimeout < 0 {
+		return fmt.Errorf("server.request_timeout must not be negative")
+	}
+	if c.Server.MaxClockSkew <= 0 {
+		return fmt.Errorf("server.max_clock_skew must be positive")
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		return fmt.Errorf("server.tls_cert_file and server.tls_key_file must be set together")
+	}
+	if c.Owner.ID == "" && c.Owner.DisplayName != "" {
+		return fmt.Errorf("owner.id is required with owner.display_name")
+	}
+	if len(c.Credentials) == 0 && len(c.getFileCredentials()) == 0 {
 		return fmt.Errorf("at least one credential is required")
 	}
+	for _, entry := range c.Auth.PublicRead {
+		if bucket, _, _ := strings.Cut(entry, "/"); bucket == "" {
+			return fmt.Errorf("auth.public_read: entry %q has no bucket", entry)
+		}
+	}
 
 	for i, cred := range c.Credentials {
-		if cred.AccessKeyID == "" {
-			return fmt.Errorf("credentials[%d].access_key_id is required", i)
-		}
-		if cred.SecretAccessKey == "" {
-			return fmt.Errorf("credentials[%d].secret_access_key is required", i)
-		}
-		if cred.Privileges != PrivilegeRead && cred.Privileges != PrivilegeReadWrite {
-			return fmt.Errorf("credentials[%d].privileges must be 'read' or 'read-write'", i)
+		if err := cred.validate(); err != nil {
+			return fmt.Errorf("credentials[%d]: %w", i, err)
 		}
 	}
 
 	return nil
 }
 
+func (c *Credential) validate() error {
+	if c.AccessKeyID == "" {
+		return fmt.Errorf("access_key_id is required")
+	}
+	if c.SecretAccessKey == "" {
+		return fmt.Errorf("secret_access_key is required")
+	}
+	if c.Privileges != PrivilegeRead && c.Privileges != PrivilegeReadWrite {
+		return fmt.Errorf("privileges must be 'read' or 'read-write'")
+	}
+	return nil
+}
+
+// ContinuationTokenKey returns the HMAC key for list continuation tokens,
+// derived from Auth.ContinuationTokenSecret or else from the secret keys of
+// Credentials, so that tokens stay valid across restarts. Credentials from
+// the credentials file are not used, as they change on reload. Returns nil
+// if there is nothing to derive the key from.
+func (c *Config) ContinuationTokenKey() []byte {
+	secret := c.Auth.ContinuationTokenSecret
+	if secret == "" {
+		creds := slices.Clone(c.Credentials)
+		slices.SortFunc(creds, func(a, b Credential) int { return strings.Compare(a.AccessKeyID, b.AccessKeyID) })
+		for _, cred := range creds {
+			secret += cred.AccessKeyID + "\x00" + cred.SecretAccessKey + "\x00"
+		}
+	}
+	if secret == "" {
+		return nil
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("continuation-token"))
+	return mac.Sum(nil)
+}
+
+// GetCredential looks up a credential by access key. Credentials from the
+// environment take precedence over those from the credentials file. It is
+// safe to call while the credentials file is being reloaded.
 func (c *Config) GetCredential(accessKeyID string) *Credential {
 	for _, cred := range c.Credentials {
 		if cred.AccessKeyID == accessKeyID {
 			return &cred
 		}
 	}
+	for _, cred := range c.getFileCredentials() {
+		if cred.AccessKeyID == accessKeyID {
+			return &cred
+		}
+	}
 	return nil
 }
 
@@ -309,13 +699,52 @@ func (c *Credential) CanWrite() bool {
 	return c.Privileges == PrivilegeReadWrite
 }
 
+// CanAccessBucket returns true if the credential is allowed to access the
+// bucket. A credential without a bucket list may access every bucket.
+func (c *Credential) CanAccessBucket(bucket string) bool {
+	if len(c.Buckets) == 0 {
+		return true
+	}
+	for _, pattern := range c.Buckets {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(bucket, prefix) {
+				return true
+			}
+		} else if bucket == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresSessionToken returns true for temporary credentials that must be
+// used together with a matching X-Amz-Security-Token
+func (c *Credential) RequiresSessionToken() bool {
+	return c.SessionToken != ""
+}
+
+// Expired returns true if the credential has an expiration that has passed
+func (c *Credential) Expired(now time.Time) bool {
+	return !c.Expiration.IsZero() && now.After(c.Expiration)
+}
+
 // LogConfiguration prints the configuration using structured logging, excluding secret values
 func (c *Config) LogConfiguration() {
 	slog.Info("configuration loaded",
 		"server_address", c.Server.Address,
+		"region", c.Server.Region,
 		"bucket_name", c.Bucket.Name,
+		"bucket_max_objects", c.Bucket.MaxObjects,
+		"bucket_object_limits", c.Bucket.ObjectLimits,
+		"storage_backend", c.Storage.Backend,
 		"storage_path", c.Storage.Path,
 		"multipart_path", c.Storage.MultipartPath,
+		"metadata_store", c.Storage.MetadataStore,
+		"bucket_paths", c.Storage.BucketPaths,
+		"bucket_immutability_windows", c.Storage.ImmutabilityWindows,
+		"storage_durable", c.Storage.Durable,
+		"metadata_cache_entries", c.Storage.MetadataCacheEntries,
+		"trash_retention", c.Storage.TrashRetention.String(),
 		"cleanup_enabled", c.Cleanup.Enabled,
 		"cleanup_interval", c.Cleanup.GetInterval().String(),
 		"cleanup_max_age", c.Cleanup.GetMaxAge().String(),
@@ -323,19 +752,44 @@ func (c *Config) LogConfiguration() {
 		"max_object_size", c.Limits.MaxObjectSize,
 		"max_part_size", c.Limits.MaxPartSize,
 		"max_chunk_size", c.Limits.MaxChunkSize,
+		"max_buckets", c.Limits.MaxBuckets,
+		"min_free_bytes", c.Limits.MinFreeBytes,
+		"list_metadata", c.Limits.ListMetadata,
 		"trusted_proxies_count", len(c.Server.TrustedProxies),
 		"read_timeout", c.Server.ReadTimeout.String(),
 		"write_timeout", c.Server.WriteTimeout.String(),
 		"shutdown_timeout", c.Server.ShutdownTimeout.String(),
+		"drain_timeout", c.Server.DrainTimeout.String(),
+		"request_timeout", c.Server.RequestTimeout.String(),
+		"max_clock_skew", c.Server.MaxClockSkew.String(),
+		"max_connections", c.Server.MaxConnections,
+		"tls_enabled", c.Server.TLSEnabled(),
 		"credentials_count", len(c.Credentials),
+		"owner_id", c.Owner.ID,
 		"log_format", c.Log.Format,
 		"log_level", c.Log.Level,
+		"access_log_sample_rate", c.Log.AccessLogSampleRate,
+		"audit_log", c.Log.AuditLog,
+		"hide_not_found", c.Auth.HideNotFound,
+		"lenient_date_parsing", c.Auth.LenientDateParsing,
+		"replay_protection", c.Auth.ReplayProtection,
+		"public_read", c.Auth.PublicRead,
+		"credentials_file", c.Auth.CredentialsFile,
+		"file_credentials_count", len(c.getFileCredentials()),
 	)
 	for i, cred := range c.Credentials {
-		slog.Info("credential configured",
+		attrs := []any{
 			"index", i,
 			"access_key", cred.AccessKeyID,
 			"privileges", cred.Privileges,
-		)
+			"session_token", cred.RequiresSessionToken(),
+		}
+		if len(cred.Buckets) > 0 {
+			attrs = append(attrs, "buckets", strings.Join(cred.Buckets, ","))
+		}
+		if !cred.Expiration.IsZero() {
+			attrs = append(attrs, "expiration", cred.Expiration.UTC().Format(time.RFC3339))
+		}
+		slog.Info("credential configured", attrs...)
 	}
 }
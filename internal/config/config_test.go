@@ -1,25 +1,57 @@
 package config
 
 import (
+	"maps"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
 	// Save original environment and restore after test
 	origEnv := map[string]string{
-		"STUPID_HOST":             os.Getenv("STUPID_HOST"),
-		"STUPID_PORT":             os.Getenv("STUPID_PORT"),
-		"STUPID_BUCKET_NAME":      os.Getenv("STUPID_BUCKET_NAME"),
-		"STUPID_STORAGE_PATH":     os.Getenv("STUPID_STORAGE_PATH"),
-		"STUPID_MULTIPART_PATH":   os.Getenv("STUPID_MULTIPART_PATH"),
-		"STUPID_CLEANUP_ENABLED":  os.Getenv("STUPID_CLEANUP_ENABLED"),
-		"STUPID_CLEANUP_INTERVAL": os.Getenv("STUPID_CLEANUP_INTERVAL"),
-		"STUPID_CLEANUP_MAX_AGE":  os.Getenv("STUPID_CLEANUP_MAX_AGE"),
-		"STUPID_RO_ACCESS_KEY":    os.Getenv("STUPID_RO_ACCESS_KEY"),
-		"STUPID_RO_SECRET_KEY":    os.Getenv("STUPID_RO_SECRET_KEY"),
-		"STUPID_RW_ACCESS_KEY":    os.Getenv("STUPID_RW_ACCESS_KEY"),
-		"STUPID_RW_SECRET_KEY":    os.Getenv("STUPID_RW_SECRET_KEY"),
+		"STUPID_HOST":                        os.Getenv("STUPID_HOST"),
+		"STUPID_PORT":                        os.Getenv("STUPID_PORT"),
+		"STUPID_REGION":                      os.Getenv("STUPID_REGION"),
+		"STUPID_BUCKET_NAME":                 os.Getenv("STUPID_BUCKET_NAME"),
+		"STUPID_STORAGE_PATH":                os.Getenv("STUPID_STORAGE_PATH"),
+		"STUPID_MULTIPART_PATH":              os.Getenv("STUPID_MULTIPART_PATH"),
+		"STUPID_CLEANUP_ENABLED":             os.Getenv("STUPID_CLEANUP_ENABLED"),
+		"STUPID_CLEANUP_INTERVAL":            os.Getenv("STUPID_CLEANUP_INTERVAL"),
+		"STUPID_CLEANUP_MAX_AGE":             os.Getenv("STUPID_CLEANUP_MAX_AGE"),
+		"STUPID_RO_ACCESS_KEY":               os.Getenv("STUPID_RO_ACCESS_KEY"),
+		"STUPID_RO_SECRET_KEY":               os.Getenv("STUPID_RO_SECRET_KEY"),
+		"STUPID_RW_ACCESS_KEY":               os.Getenv("STUPID_RW_ACCESS_KEY"),
+		"STUPID_RW_SECRET_KEY":               os.Getenv("STUPID_RW_SECRET_KEY"),
+		"STUPID_HIDE_NOT_FOUND":              os.Getenv("STUPID_HIDE_NOT_FOUND"),
+		"STUPID_REPLAY_PROTECTION":           os.Getenv("STUPID_REPLAY_PROTECTION"),
+		"STUPID_PUBLIC_READ":                 os.Getenv("STUPID_PUBLIC_READ"),
+		"STUPID_RW_SESSION_TOKEN":            os.Getenv("STUPID_RW_SESSION_TOKEN"),
+		"STUPID_RW_EXPIRATION":               os.Getenv("STUPID_RW_EXPIRATION"),
+		"STUPID_RO_BUCKETS":                  os.Getenv("STUPID_RO_BUCKETS"),
+		"STUPID_RW_BUCKETS":                  os.Getenv("STUPID_RW_BUCKETS"),
+		"STUPID_CREDENTIALS_FILE":            os.Getenv("STUPID_CREDENTIALS_FILE"),
+		"STUPID_METADATA_STORE":              os.Getenv("STUPID_METADATA_STORE"),
+		"STUPID_STORAGE_BACKEND":             os.Getenv("STUPID_STORAGE_BACKEND"),
+		"STUPID_MIN_FREE_BYTES":              os.Getenv("STUPID_MIN_FREE_BYTES"),
+		"STUPID_METADATA_CACHE_ENTRIES":      os.Getenv("STUPID_METADATA_CACHE_ENTRIES"),
+		"STUPID_TRASH_RETENTION":             os.Getenv("STUPID_TRASH_RETENTION"),
+		"STUPID_TLS_CERT_FILE":               os.Getenv("STUPID_TLS_CERT_FILE"),
+		"STUPID_TLS_KEY_FILE":                os.Getenv("STUPID_TLS_KEY_FILE"),
+		"STUPID_BUCKET_PATHS":                os.Getenv("STUPID_BUCKET_PATHS"),
+		"STUPID_BUCKET_IMMUTABILITY_WINDOWS": os.Getenv("STUPID_BUCKET_IMMUTABILITY_WINDOWS"),
+		"STUPID_BUCKET_MAX_OBJECTS":          os.Getenv("STUPID_BUCKET_MAX_OBJECTS"),
+		"STUPID_BUCKET_OBJECT_LIMITS":        os.Getenv("STUPID_BUCKET_OBJECT_LIMITS"),
+		"STUPID_STORAGE_DURABLE":             os.Getenv("STUPID_STORAGE_DURABLE"),
+		"STUPID_SHUTDOWN_TIMEOUT":            os.Getenv("STUPID_SHUTDOWN_TIMEOUT"),
+		"STUPID_DRAIN_TIMEOUT":               os.Getenv("STUPID_DRAIN_TIMEOUT"),
+		"STUPID_REQUEST_TIMEOUT":             os.Getenv("STUPID_REQUEST_TIMEOUT"),
+		"STUPID_MAX_CLOCK_SKEW":              os.Getenv("STUPID_MAX_CLOCK_SKEW"),
+		"STUPID_MAX_CONNECTIONS":             os.Getenv("STUPID_MAX_CONNECTIONS"),
+		"STUPID_OWNER_ID":                    os.Getenv("STUPID_OWNER_ID"),
+		"STUPID_OWNER_DISPLAY_NAME":          os.Getenv("STUPID_OWNER_DISPLAY_NAME"),
+		"STUPID_AUDIT_LOG":                   os.Getenv("STUPID_AUDIT_LOG"),
 	}
 	defer func() {
 		for k, v := range origEnv {
@@ -113,6 +145,415 @@ func TestLoad(t *testing.T) {
 		}
 	})
 
+	t.Run("region", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Server.Region != DefaultRegion {
+			t.Errorf("Server.Region = %q, want %q", cfg.Server.Region, DefaultRegion)
+		}
+
+		os.Setenv("STUPID_REGION", "*")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Server.Region != "*" {
+			t.Errorf("Server.Region = %q, want %q", cfg.Server.Region, "*")
+		}
+	})
+
+	t.Run("metadata store", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.MetadataStore != "json" {
+			t.Errorf("Storage.MetadataStore = %q, want %q", cfg.Storage.MetadataStore, "json")
+		}
+
+		os.Setenv("STUPID_METADATA_STORE", "kv")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.MetadataStore != "kv" {
+			t.Errorf("Storage.MetadataStore = %q, want %q", cfg.Storage.MetadataStore, "kv")
+		}
+
+		os.Setenv("STUPID_METADATA_STORE", "sqlite")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for unknown metadata store")
+		}
+	})
+
+	t.Run("storage backend", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.Backend != "filesystem" {
+			t.Errorf("Storage.Backend = %q, want %q", cfg.Storage.Backend, "filesystem")
+		}
+
+		os.Setenv("STUPID_STORAGE_BACKEND", "memory")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.Backend != "memory" {
+			t.Errorf("Storage.Backend = %q, want %q", cfg.Storage.Backend, "memory")
+		}
+
+		os.Setenv("STUPID_MIN_FREE_BYTES", "1024")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for min free bytes with the memory backend")
+		}
+
+		os.Unsetenv("STUPID_MIN_FREE_BYTES")
+		os.Setenv("STUPID_STORAGE_BACKEND", "")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.Backend != "filesystem" {
+			t.Errorf("Storage.Backend = %q, want %q", cfg.Storage.Backend, "filesystem")
+		}
+	})
+
+	t.Run("metadata cache", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.MetadataCacheEntries != 0 {
+			t.Errorf("Storage.MetadataCacheEntries = %d, want 0", cfg.Storage.MetadataCacheEntries)
+		}
+
+		os.Setenv("STUPID_METADATA_CACHE_ENTRIES", "10000")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.MetadataCacheEntries != 10000 {
+			t.Errorf("Storage.MetadataCacheEntries = %d, want 10000", cfg.Storage.MetadataCacheEntries)
+		}
+
+		os.Setenv("STUPID_METADATA_CACHE_ENTRIES", "-1")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for negative metadata cache entries")
+		}
+	})
+
+	t.Run("trash retention", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.TrashRetention != 0 {
+			t.Errorf("Storage.TrashRetention = %v, want 0", cfg.Storage.TrashRetention)
+		}
+
+		os.Setenv("STUPID_TRASH_RETENTION", "72h")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.TrashRetention != 72*time.Hour {
+			t.Errorf("Storage.TrashRetention = %v, want 72h", cfg.Storage.TrashRetention)
+		}
+
+		os.Setenv("STUPID_TRASH_RETENTION", "-1h")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for negative trash retention")
+		}
+	})
+
+	t.Run("bucket path overrides", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.BucketPaths != nil {
+			t.Errorf("Storage.BucketPaths = %v, want nil", cfg.Storage.BucketPaths)
+		}
+
+		os.Setenv("STUPID_BUCKET_PATHS", "media=/mnt/media, logs = /mnt/logs,")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		want := map[string]string{"media": "/mnt/media", "logs": "/mnt/logs"}
+		if !maps.Equal(cfg.Storage.BucketPaths, want) {
+			t.Errorf("Storage.BucketPaths = %v, want %v", cfg.Storage.BucketPaths, want)
+		}
+
+		for _, value := range []string{"media", "media=", "=/mnt/media", "media=relative/path", "media=/a,media=/b"} {
+			os.Setenv("STUPID_BUCKET_PATHS", value)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for STUPID_BUCKET_PATHS=%q", value)
+			}
+		}
+	})
+
+	t.Run("drain timeout", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_DRAIN_TIMEOUT", "10m")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Server.DrainTimeout != 10*time.Minute {
+			t.Errorf("Server.DrainTimeout = %v, want 10m", cfg.Server.DrainTimeout)
+		}
+
+		os.Setenv("STUPID_SHUTDOWN_TIMEOUT", "1h")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for drain timeout shorter than shutdown timeout")
+		}
+	})
+
+	t.Run("request timeout", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_REQUEST_TIMEOUT", "5m")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Server.RequestTimeout != 5*time.Minute {
+			t.Errorf("Server.RequestTimeout = %v, want 5m", cfg.Server.RequestTimeout)
+		}
+
+		os.Setenv("STUPID_REQUEST_TIMEOUT", "-1s")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for negative request timeout")
+		}
+	})
+
+	t.Run("max clock skew", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Server.MaxClockSkew != DefaultMaxClockSkew {
+			t.Errorf("Server.MaxClockSkew = %v, want %v", cfg.Server.MaxClockSkew, DefaultMaxClockSkew)
+		}
+
+		os.Setenv("STUPID_MAX_CLOCK_SKEW", "5m")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Server.MaxClockSkew != 5*time.Minute {
+			t.Errorf("Server.MaxClockSkew = %v, want 5m", cfg.Server.MaxClockSkew)
+		}
+
+		os.Setenv("STUPID_MAX_CLOCK_SKEW", "0s")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for zero max clock skew")
+		}
+	})
+
+	t.Run("max connections", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Server.MaxConnections != 0 {
+			t.Errorf("Server.MaxConnections = %d, want 0 (unlimited) by default", cfg.Server.MaxConnections)
+		}
+
+		os.Setenv("STUPID_MAX_CONNECTIONS", "500")
+		if cfg, err = Load(); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Server.MaxConnections != 500 {
+			t.Errorf("Server.MaxConnections = %d, want 500", cfg.Server.MaxConnections)
+		}
+	})
+
+	t.Run("storage durable", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if !cfg.Storage.Durable {
+			t.Error("Storage.Durable = false, want true by default")
+		}
+
+		os.Setenv("STUPID_STORAGE_DURABLE", "false")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Storage.Durable {
+			t.Error("Storage.Durable = true, want false")
+		}
+	})
+
+	t.Run("bucket immutability windows", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_BUCKET_IMMUTABILITY_WINDOWS", "ingest=10m,logs=1h")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		want := map[string]time.Duration{"ingest": 10 * time.Minute, "logs": time.Hour}
+		if !maps.Equal(cfg.Storage.ImmutabilityWindows, want) {
+			t.Errorf("Storage.ImmutabilityWindows = %v, want %v", cfg.Storage.ImmutabilityWindows, want)
+		}
+
+		for _, value := range []string{"ingest", "ingest=ten", "ingest=0s", "ingest=-1m"} {
+			os.Setenv("STUPID_BUCKET_IMMUTABILITY_WINDOWS", value)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for STUPID_BUCKET_IMMUTABILITY_WINDOWS=%q", value)
+			}
+		}
+	})
+
+	t.Run("bucket object limits", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_BUCKET_MAX_OBJECTS", "1000")
+		os.Setenv("STUPID_BUCKET_OBJECT_LIMITS", "logs=0,media=50")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		for bucket, want := range map[string]int64{"logs": 0, "media": 50, "other": 1000} {
+			if got := cfg.Bucket.ObjectLimit(bucket); got != want {
+				t.Errorf("ObjectLimit(%s) = %d, want %d", bucket, got, want)
+			}
+		}
+
+		for _, value := range []string{"logs", "logs=many", "logs=-1"} {
+			os.Setenv("STUPID_BUCKET_OBJECT_LIMITS", value)
+			if _, err := Load(); err == nil {
+				t.Errorf("expected error for STUPID_BUCKET_OBJECT_LIMITS=%q", value)
+			}
+		}
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_OWNER_ID", "owner-id")
+		os.Setenv("STUPID_OWNER_DISPLAY_NAME", "Owner")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Owner != (Owner{ID: "owner-id", DisplayName: "Owner"}) {
+			t.Errorf("Owner = %+v", cfg.Owner)
+		}
+
+		os.Unsetenv("STUPID_OWNER_ID")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for display name without owner ID")
+		}
+	})
+
+	t.Run("audit log", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Log.AuditLog != "" {
+			t.Errorf("AuditLog = %q, want disabled by default", cfg.Log.AuditLog)
+		}
+
+		os.Setenv("STUPID_AUDIT_LOG", "/var/log/sss/audit.log")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Log.AuditLog != "/var/log/sss/audit.log" {
+			t.Errorf("AuditLog = %q", cfg.Log.AuditLog)
+		}
+	})
+
+	t.Run("tls requires certificate and key", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Server.TLSEnabled() {
+			t.Error("TLS should be disabled by default")
+		}
+
+		os.Setenv("STUPID_TLS_CERT_FILE", "/etc/sss/cert.pem")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for certificate without key")
+		}
+
+		os.Setenv("STUPID_TLS_KEY_FILE", "/etc/sss/key.pem")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if !cfg.Server.TLSEnabled() {
+			t.Error("TLS should be enabled")
+		}
+	})
+
 	t.Run("cleanup enabled by default", func(t *testing.T) {
 		clearEnv()
 		os.Setenv("STUPID_BUCKET_NAME", "test-bucket")
@@ -167,6 +608,169 @@ func TestLoad(t *testing.T) {
 		}
 	})
 
+	t.Run("hide not found", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Auth.HideNotFound {
+			t.Error("Auth.HideNotFound = true, want false by default")
+		}
+
+		os.Setenv("STUPID_HIDE_NOT_FOUND", "true")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if !cfg.Auth.HideNotFound {
+			t.Error("Auth.HideNotFound = false, want true")
+		}
+	})
+
+	t.Run("replay protection", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cfg.Auth.ReplayProtection {
+			t.Error("Auth.ReplayProtection = true, want false by default")
+		}
+
+		os.Setenv("STUPID_REPLAY_PROTECTION", "true")
+		cfg, err = Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if !cfg.Auth.ReplayProtection {
+			t.Error("Auth.ReplayProtection = false, want true")
+		}
+	})
+
+	t.Run("public read", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_PUBLIC_READ", "static, site/public/")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		for _, tt := range []struct {
+			bucket, key string
+			want        bool
+		}{
+			{"static", "app.js", true},
+			{"static", "", false},
+			{"site", "public/index.html", true},
+			{"site", "private/index.html", false},
+			{"other", "app.js", false},
+		} {
+			if got := cfg.Auth.IsPublicRead(tt.bucket, tt.key); got != tt.want {
+				t.Errorf("IsPublicRead(%q, %q) = %v, want %v", tt.bucket, tt.key, got, tt.want)
+			}
+		}
+
+		os.Setenv("STUPID_PUBLIC_READ", "/prefix")
+		if _, err := Load(); err == nil {
+			t.Error("expected error for public read entry without bucket")
+		}
+	})
+
+	t.Run("session credential", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "ASIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_RW_SESSION_TOKEN", "token")
+		os.Setenv("STUPID_RW_EXPIRATION", "2030-01-01T00:00:00Z")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+
+		cred := cfg.GetCredential("ASIA")
+		if !cred.RequiresSessionToken() || cred.SessionToken != "token" {
+			t.Errorf("SessionToken = %q, want %q", cred.SessionToken, "token")
+		}
+		if cred.Expired(time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC)) {
+			t.Error("Expired() = true before expiration")
+		}
+		if !cred.Expired(time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)) {
+			t.Error("Expired() = false after expiration")
+		}
+	})
+
+	t.Run("bucket scoped credentials", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RO_ACCESS_KEY", "ro")
+		os.Setenv("STUPID_RO_SECRET_KEY", "secret")
+		os.Setenv("STUPID_RW_ACCESS_KEY", "rw")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_RW_BUCKETS", "tenant-a, shared-*,")
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+
+		if buckets := cfg.GetCredential("ro").Buckets; len(buckets) != 0 {
+			t.Errorf("ro Buckets = %v, want none", buckets)
+		}
+		buckets := cfg.GetCredential("rw").Buckets
+		if len(buckets) != 2 || buckets[0] != "tenant-a" || buckets[1] != "shared-*" {
+			t.Errorf("rw Buckets = %v, want [tenant-a shared-*]", buckets)
+		}
+	})
+
+	t.Run("credentials file only", func(t *testing.T) {
+		clearEnv()
+		path := filepath.Join(t.TempDir(), "credentials.yaml")
+		content := "credentials:\n  - access_key_id: filekey\n    secret_access_key: secret\n    privileges: read-write\n"
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write credentials file: %v", err)
+		}
+		os.Setenv("STUPID_CREDENTIALS_FILE", path)
+
+		cfg, err := Load()
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if cred := cfg.GetCredential("filekey"); cred == nil || !cred.CanWrite() {
+			t.Errorf("GetCredential(filekey) = %+v, want read-write credential", cred)
+		}
+	})
+
+	t.Run("invalid credentials file", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "rw")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+
+		if _, err := Load(); err == nil {
+			t.Error("expected error for missing credentials file")
+		}
+	})
+
+	t.Run("invalid expiration", func(t *testing.T) {
+		clearEnv()
+		os.Setenv("STUPID_RW_ACCESS_KEY", "ASIA")
+		os.Setenv("STUPID_RW_SECRET_KEY", "secret")
+		os.Setenv("STUPID_RW_EXPIRATION", "tomorrow")
+
+		if _, err := Load(); err == nil {
+			t.Error("expected error for invalid expiration")
+		}
+	})
+
 	t.Run("missing bucket name is allowed", func(t *testing.T) {
 		clearEnv()
 		os.Setenv("STUPID_RW_ACCESS_KEY", "AKIA")
@@ -294,6 +898,31 @@ func TestCanWrite(t *testing.T) {
 	}
 }
 
+func TestCanAccessBucket(t *testing.T) {
+	tests := []struct {
+		name      string
+		buckets   []string
+		bucket    string
+		canAccess bool
+	}{
+		{"no restriction", nil, "any-bucket", true},
+		{"exact match", []string{"tenant-a"}, "tenant-a", true},
+		{"exact mismatch", []string{"tenant-a"}, "tenant-ab", false},
+		{"prefix match", []string{"tenant-a-*"}, "tenant-a-logs", true},
+		{"prefix mismatch", []string{"tenant-a-*"}, "tenant-b-logs", false},
+		{"any of several", []string{"tenant-a", "tenant-b"}, "tenant-b", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := &Credential{Buckets: tt.buckets}
+			if got := cred.CanAccessBucket(tt.bucket); got != tt.canAccess {
+				t.Errorf("CanAccessBucket(%q) = %v, want %v", tt.bucket, got, tt.canAccess)
+			}
+		})
+	}
+}
+
 func TestCleanupGetInterval(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -389,3 +1018,28 @@ func TestConfigLog(t *testing.T) {
 	// LogConfiguration() should not panic - just verify it runs
 	cfg.LogConfiguration()
 }
+
+func TestContinuationTokenKey(t *testing.T) {
+	var empty Config
+	if key := empty.ContinuationTokenKey(); key != nil {
+		t.Errorf("key without secret or credentials = %x, want nil", key)
+	}
+
+	creds := Config{Credentials: []Credential{
+		{AccessKeyID: "AKIA1", SecretAccessKey: "secret1"},
+		{AccessKeyID: "AKIA2", SecretAccessKey: "secret2"},
+	}}
+	reordered := Config{Credentials: []Credential{creds.Credentials[1], creds.Credentials[0]}}
+	rotated := Config{Credentials: []Credential{creds.Credentials[0], {AccessKeyID: "AKIA2", SecretAccessKey: "rotated"}}}
+	if key := creds.ContinuationTokenKey(); len(key) != 32 || string(key) != string(reordered.ContinuationTokenKey()) {
+		t.Errorf("key from credentials = %x, want 32 bytes independent of order", key)
+	}
+	if string(creds.ContinuationTokenKey()) == string(rotated.ContinuationTokenKey()) {
+		t.Error("key did not change with a rotated secret key")
+	}
+
+	secret := Config{Credentials: creds.Credentials, Auth: Auth{ContinuationTokenSecret: "token-secret"}}
+	if string(secret.ContinuationTokenKey()) == string(creds.ContinuationTokenKey()) {
+		t.Error("dedicated secret did not replace the credentials")
+	}
+}
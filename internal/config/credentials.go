@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// DefaultCredentialsReloadInterval is how often the credentials file is checked for changes
+const DefaultCredentialsReloadInterval = 10 * time.Second
+
+// credentialsFile is the on-disk format of STUPID_CREDENTIALS_FILE.
+// JSON is a subset of YAML, so the same parser reads both.
+type credentialsFile struct {
+	Credentials []credentialsFileEntry `yaml:"credentials"`
+}
+
+type credentialsFileEntry struct {
+	AccessKeyID     string   `yaml:"access_key_id"`
+	SecretAccessKey string   `yaml:"secret_access_key"`
+	Privileges      string   `yaml:"privileges"`
+	SessionToken    string   `yaml:"session_token"`
+	Expiration      string   `yaml:"expiration"`
+	Buckets         []string `yaml:"buckets"`
+}
+
+// loadCredentialsFile reads and validates the credentials in a JSON or YAML file
+func loadCredentialsFile(path string) ([]Credential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials file: %w", err)
+	}
+
+	var file credentialsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing credentials file: %w", err)
+	}
+
+	creds := make([]Credential, 0, len(file.Credentials))
+	seen := make(map[string]bool, len(file.Credentials))
+	for i, entry := range file.Credentials {
+		cred := Credential{
+			AccessKeyID:     entry.AccessKeyID,
+			SecretAccessKey: entry.SecretAccessKey,
+			Privileges:      Privilege(entry.Privileges),
+			SessionToken:    entry.SessionToken,
+			Buckets:         entry.Buckets,
+		}
+		if entry.Expiration != "" {
+			cred.Expiration, err = time.Parse(time.RFC3339, entry.Expiration)
+			if err != nil {
+				return nil, fmt.Errorf("credentials file: credentials[%d].expiration: %w", i, err)
+			}
+		}
+		if err := cred.validate(); err != nil {
+			return nil, fmt.Errorf("credentials file: credentials[%d]: %w", i, err)
+		}
+		if seen[cred.AccessKeyID] {
+			return nil, fmt.Errorf("credentials file: duplicate access key %q", cred.AccessKeyID)
+		}
+		seen[cred.AccessKeyID] = true
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+// ReloadCredentialsFile re-reads the credentials file and atomically replaces
+// the file credentials. On error the previous credentials are kept.
+func (c *Config) ReloadCredentialsFile() error {
+	creds, err := loadCredentialsFile(c.Auth.CredentialsFile)
+	if err != nil {
+		return err
+	}
+	c.fileCredentials.Store(&creds)
+	return nil
+}
+
+// WatchCredentialsFile polls the credentials file and reloads it when its
+// modification time or size changes. Invalid files are logged and ignored.
+// It runs until the process exits.
+func (c *Config) WatchCredentialsFile(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultCredentialsReloadInterval
+	}
+
+	path := c.Auth.CredentialsFile
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("failed to stat credentials file", "path", path, "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		current, err := os.Stat(path)
+		if err != nil {
+			slog.Warn("failed to stat credentials file", "path", path, "error", err)
+			continue
+		}
+		if info != nil && current.ModTime().Equal(info.ModTime()) && current.Size() == info.Size() {
+			continue
+		}
+		info = current
+
+		if err := c.ReloadCredentialsFile(); err != nil {
+			slog.Error("failed to reload credentials file, keeping previous credentials", "path", path, "error", err)
+			continue
+		}
+		slog.Info("reloaded credentials file", "path", path, "credentials_count", len(c.getFileCredentials()))
+	}
+}
+
+// getFileCredentials returns the credentials currently loaded from the credentials file
+func (c *Config) getFileCredentials() []Credential {
+	if creds := c.fileCredentials.Load(); creds != nil {
+		return *creds
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeCredentialsFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write credentials file: %v", err)
+	}
+}
+
+func TestLoadCredentialsFile(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("yaml", func(t *testing.T) {
+		path := filepath.Join(dir, "credentials.yaml")
+		writeCredentialsFile(t, path, `
+credentials:
+  - access_key_id: reader
+    secret_access_key: secret1
+    privileges: read
+  - access_key_id: tenant
+    secret_access_key: secret2
+    privileges: read-write
+    buckets: [tenant-a, "shared-*"]
+    expiration: "2030-01-01T00:00:00Z"
+`)
+
+		creds, err := loadCredentialsFile(path)
+		if err != nil {
+			t.Fatalf("loadCredentialsFile failed: %v", err)
+		}
+		if len(creds) != 2 {
+			t.Fatalf("got %d credentials, want 2", len(creds))
+		}
+		if creds[0].AccessKeyID != "reader" || creds[0].Privileges != PrivilegeRead {
+			t.Errorf("creds[0] = %+v", creds[0])
+		}
+		if len(creds[1].Buckets) != 2 || !creds[1].Expiration.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("creds[1] = %+v", creds[1])
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		path := filepath.Join(dir, "credentials.json")
+		writeCredentialsFile(t, path, `{"credentials": [{"access_key_id": "writer", "secret_access_key": "secret", "privileges": "read-write"}]}`)
+
+		creds, err := loadCredentialsFile(path)
+		if err != nil {
+			t.Fatalf("loadCredentialsFile failed: %v", err)
+		}
+		if len(creds) != 1 || creds[0].AccessKeyID != "writer" || !creds[0].CanWrite() {
+			t.Errorf("creds = %+v", creds)
+		}
+	})
+
+	invalid := map[string]string{
+		"not yaml":             `credentials: [`,
+		"missing secret":       `{"credentials": [{"access_key_id": "a", "privileges": "read"}]}`,
+		"invalid privileges":   `{"credentials": [{"access_key_id": "a", "secret_access_key": "s", "privileges": "admin"}]}`,
+		"invalid expiration":   `{"credentials": [{"access_key_id": "a", "secret_access_key": "s", "privileges": "read", "expiration": "tomorrow"}]}`,
+		"duplicate access key": `{"credentials": [{"access_key_id": "a", "secret_access_key": "s", "privileges": "read"}, {"access_key_id": "a", "secret_access_key": "t", "privileges": "read"}]}`,
+	}
+	for name, content := range invalid {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, "invalid.json")
+			writeCredentialsFile(t, path, content)
+			if _, err := loadCredentialsFile(path); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := loadCredentialsFile(filepath.Join(dir, "missing.json")); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestReloadCredentialsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	writeCredentialsFile(t, path, `{"credentials": [{"access_key_id": "old", "secret_access_key": "secret", "privileges": "read"}]}`)
+
+	cfg := &Config{
+		Credentials: []Credential{{AccessKeyID: "env", SecretAccessKey: "secret", Privileges: PrivilegeReadWrite}},
+		Auth:        Auth{CredentialsFile: path},
+	}
+	if err := cfg.ReloadCredentialsFile(); err != nil {
+		t.Fatalf("ReloadCredentialsFile failed: %v", err)
+	}
+	if cfg.GetCredential("old") == nil || cfg.GetCredential("env") == nil {
+		t.Fatal("expected both file and environment credentials")
+	}
+
+	// Rotate the key
+	writeCredentialsFile(t, path, `{"credentials": [{"access_key_id": "new", "secret_access_key": "secret", "privileges": "read"}]}`)
+	if err := cfg.ReloadCredentialsFile(); err != nil {
+		t.Fatalf("ReloadCredentialsFile failed: %v", err)
+	}
+	if cfg.GetCredential("old") != nil {
+		t.Error("old credential should be removed after reload")
+	}
+	if cfg.GetCredential("new") == nil {
+		t.Error("new credential should be present after reload")
+	}
+
+	// An invalid file keeps the previous credentials
+	writeCredentialsFile(t, path, `{"credentials": [{"access_key_id": "broken"}]}`)
+	if err := cfg.ReloadCredentialsFile(); err == nil {
+		t.Error("expected error for invalid file")
+	}
+	if cfg.GetCredential("new") == nil {
+		t.Error("previous credentials should be kept after a failed reload")
+	}
+}
+
+func TestGetCredentialConcurrentReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	writeCredentialsFile(t, path, `{"credentials": [{"access_key_id": "key", "secret_access_key": "secret", "privileges": "read"}]}`)
+
+	cfg := &Config{Auth: Auth{CredentialsFile: path}}
+	if err := cfg.ReloadCredentialsFile(); err != nil {
+		t.Fatalf("ReloadCredentialsFile failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if cfg.GetCredential("key") == nil {
+					t.Error("credential missing during reload")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := cfg.ReloadCredentialsFile(); err != nil {
+			t.Errorf("ReloadCredentialsFile failed: %v", err)
+		}
+	}
+	wg.Wait()
+}
@@ -23,14 +23,15 @@ var (
 		[]string{"method", "operation", "status"},
 	)
 
-	// RequestDuration tracks request latency in seconds
+	// RequestDuration tracks request latency in seconds. The status class
+	// (2xx, 3xx, 4xx or 5xx) keeps fast errors out of success latencies.
 	RequestDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "stupid_simple_s3_http_request_duration_seconds",
 			Help:    "HTTP request duration in seconds",
 			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
 		},
-		[]string{"method", "operation"},
+		[]string{"method", "operation", "status_class"},
 	)
 
 	// BytesReceived counts bytes received in request bodies
@@ -51,6 +52,16 @@ var (
 		[]string{"operation"},
 	)
 
+	// ObjectSizeBytes tracks the size of written objects by operation
+	ObjectSizeBytes = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "stupid_simple_s3_object_size_bytes",
+			Help:    "Size in bytes of objects written",
+			Buckets: prometheus.ExponentialBuckets(4<<10, 4, 12), // 4KB to 16GB
+		},
+		[]string{"operation"},
+	)
+
 	// ErrorsTotal counts errors by type
 	ErrorsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -84,6 +95,23 @@ var (
 		},
 	)
 
+	// ConnectionsOpen tracks the number of open client connections
+	ConnectionsOpen = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stupid_simple_s3_connections_open",
+			Help: "Number of open client connections",
+		},
+	)
+
+	// ConnectionsRejectedTotal counts connections closed because the
+	// connection limit was reached
+	ConnectionsRejectedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "stupid_simple_s3_connections_rejected_total",
+			Help: "Total number of connections rejected at the connection limit",
+		},
+	)
+
 	// AuthFailuresTotal counts authentication failures
 	AuthFailuresTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -93,6 +121,33 @@ var (
 		[]string{"reason"},
 	)
 
+	// StorageWritable is 1 when the last readiness check could write to all
+	// storage paths and 0 when it failed
+	StorageWritable = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stupid_simple_s3_storage_writable",
+			Help: "Whether the last readiness check could write to storage (1) or not (0)",
+		},
+	)
+
+	// DiskFreeBytes tracks the bytes available on the storage filesystems
+	DiskFreeBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stupid_simple_s3_disk_free_bytes",
+			Help: "Bytes available to the server on the filesystem holding each storage path",
+		},
+		[]string{"path"},
+	)
+
+	// DiskTotalBytes tracks the size of the storage filesystems
+	DiskTotalBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stupid_simple_s3_disk_total_bytes",
+			Help: "Size in bytes of the filesystem holding each storage path",
+		},
+		[]string{"path"},
+	)
+
 	// BucketsTotal tracks the current number of buckets
 	BucketsTotal = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -101,6 +156,24 @@ var (
 		},
 	)
 
+	// ObjectsTotal tracks the current number of objects in each bucket
+	ObjectsTotal = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stupid_simple_s3_objects_total",
+			Help: "Current number of objects in each bucket",
+		},
+		[]string{"bucket"},
+	)
+
+	// BytesStoredTotal tracks the total size of the objects in each bucket
+	BytesStoredTotal = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "stupid_simple_s3_bytes_stored_total",
+			Help: "Total size in bytes of the objects in each bucket",
+		},
+		[]string{"bucket"},
+	)
+
 	// BucketCreationsTotal counts total bucket creations
 	BucketCreationsTotal = promauto.NewCounter(
 		prometheus.CounterOpts{
@@ -109,6 +182,14 @@ var (
 		},
 	)
 
+	// LifecycleExpirationsTotal counts objects deleted by lifecycle rules
+	LifecycleExpirationsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "stupid_simple_s3_lifecycle_expirations_total",
+			Help: "Total number of objects deleted by lifecycle expiration rules",
+		},
+	)
+
 	// BucketDeletionsTotal counts total bucket deletions
 	BucketDeletionsTotal = promauto.NewCounter(
 		prometheus.CounterOpts{
@@ -116,6 +197,33 @@ var (
 			Help: "Total number of bucket deletions",
 		},
 	)
+
+	// MetadataCacheLookupsTotal counts object metadata lookups by result
+	// (hit or miss) when the metadata cache is enabled
+	MetadataCacheLookupsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stupid_simple_s3_metadata_cache_lookups_total",
+			Help: "Total number of object metadata cache lookups",
+		},
+		[]string{"result"},
+	)
+
+	// MetadataCacheHitRatio tracks the share of metadata lookups served from
+	// the cache since startup
+	MetadataCacheHitRatio = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stupid_simple_s3_metadata_cache_hit_ratio",
+			Help: "Share of object metadata lookups served from the cache since startup",
+		},
+	)
+
+	// MetadataCacheEntries tracks the number of objects in the metadata cache
+	MetadataCacheEntries = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stupid_simple_s3_metadata_cache_entries",
+			Help: "Number of objects whose metadata is cached",
+		},
+	)
 )
 
 // Operation names for consistent labeling
@@ -134,6 +242,21 @@ const (
 	OpListObjects             = "ListObjects"
 	OpCopyObject              = "CopyObject"
 	OpDeleteObjects           = "DeleteObjects"
+	OpPostObject              = "PostObject"
+	OpPutObjectLegalHold      = "PutObjectLegalHold"
+	OpGetObjectLegalHold      = "GetObjectLegalHold"
+	OpReindex                 = "Reindex"
+	OpRestoreObject           = "RestoreObject"
+	OpGetBucketVersioning     = "GetBucketVersioning"
+	OpPutBucketVersioning     = "PutBucketVersioning"
+	OpListObjectVersions      = "ListObjectVersions"
+	OpGetBucketLifecycle      = "GetBucketLifecycleConfiguration"
+	OpPutBucketLifecycle      = "PutBucketLifecycleConfiguration"
+	OpDeleteBucketLifecycle   = "DeleteBucketLifecycle"
+	OpGetBucketCors           = "GetBucketCors"
+	OpPutBucketCors           = "PutBucketCors"
+	OpDeleteBucketCors        = "DeleteBucketCors"
+	OpGetBucketLocation       = "GetBucketLocation"
 	OpUnknown                 = "Unknown"
 )
 
@@ -145,4 +268,7 @@ const (
 	AuthReasonSignatureMismatch = "signature_mismatch"
 	AuthReasonTimeSkew          = "time_skew"
 	AuthReasonAccessDenied      = "access_denied"
+	AuthReasonInvalidToken      = "invalid_token"
+	AuthReasonExpiredToken      = "expired_token"
+	AuthReasonReplay            = "replay"
 )
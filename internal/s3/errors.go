@@ -8,92 +8,160 @@ import (
 type ErrorCode string
 
 const (
-	ErrAccessDenied                 ErrorCode = "AccessDenied"
-	ErrBucketAlreadyOwnedByYou      ErrorCode = "BucketAlreadyOwnedByYou"
-	ErrBucketNotEmpty               ErrorCode = "BucketNotEmpty"
-	ErrInternalError                ErrorCode = "InternalError"
-	ErrInvalidAccessKeyId           ErrorCode = "InvalidAccessKeyId"
-	ErrInvalidArgument              ErrorCode = "InvalidArgument"
-	ErrInvalidBucketName            ErrorCode = "InvalidBucketName"
-	ErrInvalidPart                  ErrorCode = "InvalidPart"
-	ErrInvalidPartOrder             ErrorCode = "InvalidPartOrder"
-	ErrInvalidRequest               ErrorCode = "InvalidRequest"
-	ErrMalformedXML                 ErrorCode = "MalformedXML"
-	ErrMethodNotAllowed             ErrorCode = "MethodNotAllowed"
-	ErrMissingContentLength         ErrorCode = "MissingContentLength"
-	ErrNoSuchBucket                 ErrorCode = "NoSuchBucket"
-	ErrNoSuchKey                    ErrorCode = "NoSuchKey"
-	ErrNoSuchUpload                 ErrorCode = "NoSuchUpload"
-	ErrRequestTimeTooSkewed         ErrorCode = "RequestTimeTooSkewed"
-	ErrSignatureDoesNotMatch        ErrorCode = "SignatureDoesNotMatch"
-	ErrEntityTooSmall               ErrorCode = "EntityTooSmall"
-	ErrIncompleteBody               ErrorCode = "IncompleteBody"
-	ErrAuthorizationHeaderMalformed ErrorCode = "AuthorizationHeaderMalformed"
-	ErrExpiredToken                 ErrorCode = "ExpiredToken"
-	ErrEntityTooLarge               ErrorCode = "EntityTooLarge"
-	ErrInvalidRange                 ErrorCode = "InvalidRange"
+	ErrAccessDenied                   ErrorCode = "AccessDenied"
+	ErrBucketAlreadyOwnedByYou        ErrorCode = "BucketAlreadyOwnedByYou"
+	ErrBucketNotEmpty                 ErrorCode = "BucketNotEmpty"
+	ErrInternalError                  ErrorCode = "InternalError"
+	ErrInvalidAccessKeyId             ErrorCode = "InvalidAccessKeyId"
+	ErrInvalidArgument                ErrorCode = "InvalidArgument"
+	ErrInvalidBucketName              ErrorCode = "InvalidBucketName"
+	ErrInvalidPart                    ErrorCode = "InvalidPart"
+	ErrInvalidPartOrder               ErrorCode = "InvalidPartOrder"
+	ErrInvalidPartNumber              ErrorCode = "InvalidPartNumber"
+	ErrInvalidRequest                 ErrorCode = "InvalidRequest"
+	ErrMalformedXML                   ErrorCode = "MalformedXML"
+	ErrMethodNotAllowed               ErrorCode = "MethodNotAllowed"
+	ErrMissingContentLength           ErrorCode = "MissingContentLength"
+	ErrNoSuchBucket                   ErrorCode = "NoSuchBucket"
+	ErrNoSuchKey                      ErrorCode = "NoSuchKey"
+	ErrNoSuchUpload                   ErrorCode = "NoSuchUpload"
+	ErrRequestTimeTooSkewed           ErrorCode = "RequestTimeTooSkewed"
+	ErrSignatureDoesNotMatch          ErrorCode = "SignatureDoesNotMatch"
+	ErrEntityTooSmall                 ErrorCode = "EntityTooSmall"
+	ErrIncompleteBody                 ErrorCode = "IncompleteBody"
+	ErrAuthorizationHeaderMalformed   ErrorCode = "AuthorizationHeaderMalformed"
+	ErrExpiredToken                   ErrorCode = "ExpiredToken"
+	ErrEntityTooLarge                 ErrorCode = "EntityTooLarge"
+	ErrInvalidRange                   ErrorCode = "InvalidRange"
+	ErrPreconditionFailed             ErrorCode = "PreconditionFailed"
+	ErrMalformedPOSTRequest           ErrorCode = "MalformedPOSTRequest"
+	ErrInvalidPolicyDocument          ErrorCode = "InvalidPolicyDocument"
+	ErrInvalidToken                   ErrorCode = "InvalidToken"
+	ErrNoSuchObjectLockConfiguration  ErrorCode = "NoSuchObjectLockConfiguration"
+	ErrOperationAborted               ErrorCode = "OperationAborted"
+	ErrNotImplemented                 ErrorCode = "NotImplemented"
+	ErrInvalidTag                     ErrorCode = "InvalidTag"
+	ErrTooManyObjects                 ErrorCode = "TooManyObjects"
+	ErrNoSuchVersion                  ErrorCode = "NoSuchVersion"
+	ErrIllegalVersioningConfiguration ErrorCode = "IllegalVersioningConfigurationException"
+	ErrNoSuchLifecycleConfiguration   ErrorCode = "NoSuchLifecycleConfiguration"
+	ErrNoSuchCORSConfiguration        ErrorCode = "NoSuchCORSConfiguration"
+	ErrCORSForbidden                  ErrorCode = "AccessForbidden"
+	ErrTooManyBuckets                 ErrorCode = "TooManyBuckets"
+	ErrServiceUnavailable             ErrorCode = "ServiceUnavailable"
+	ErrKeyTooLong                     ErrorCode = "KeyTooLongError"
+	ErrRequestTimeout                 ErrorCode = "RequestTimeout"
+	ErrAuthorizationQueryParameters   ErrorCode = "AuthorizationQueryParametersError"
 )
 
 var errorStatusCodes = map[ErrorCode]int{
-	ErrAccessDenied:                 http.StatusForbidden,
-	ErrBucketAlreadyOwnedByYou:      http.StatusConflict,
-	ErrBucketNotEmpty:               http.StatusConflict,
-	ErrInternalError:                http.StatusInternalServerError,
-	ErrInvalidAccessKeyId:           http.StatusForbidden,
-	ErrInvalidArgument:              http.StatusBadRequest,
-	ErrInvalidBucketName:            http.StatusBadRequest,
-	ErrInvalidPart:                  http.StatusBadRequest,
-	ErrInvalidPartOrder:             http.StatusBadRequest,
-	ErrInvalidRequest:               http.StatusBadRequest,
-	ErrMalformedXML:                 http.StatusBadRequest,
-	ErrMethodNotAllowed:             http.StatusMethodNotAllowed,
-	ErrMissingContentLength:         http.StatusLengthRequired,
-	ErrNoSuchBucket:                 http.StatusNotFound,
-	ErrNoSuchKey:                    http.StatusNotFound,
-	ErrNoSuchUpload:                 http.StatusNotFound,
-	ErrRequestTimeTooSkewed:         http.StatusForbidden,
-	ErrSignatureDoesNotMatch:        http.StatusForbidden,
-	ErrEntityTooSmall:               http.StatusBadRequest,
-	ErrIncompleteBody:               http.StatusBadRequest,
-	ErrAuthorizationHeaderMalformed: http.StatusBadRequest,
-	ErrExpiredToken:                 http.StatusForbidden,
-	ErrEntityTooLarge:               http.StatusRequestEntityTooLarge,
-	ErrInvalidRange:                 http.StatusRequestedRangeNotSatisfiable,
+	ErrAccessDenied:                   http.StatusForbidden,
+	ErrBucketAlreadyOwnedByYou:        http.StatusConflict,
+	ErrBucketNotEmpty:                 http.StatusConflict,
+	ErrInternalError:                  http.StatusInternalServerError,
+	ErrInvalidAccessKeyId:             http.StatusForbidden,
+	ErrInvalidArgument:                http.StatusBadRequest,
+	ErrInvalidBucketName:              http.StatusBadRequest,
+	ErrInvalidPart:                    http.StatusBadRequest,
+	ErrInvalidPartOrder:               http.StatusBadRequest,
+	ErrInvalidPartNumber:              http.StatusRequestedRangeNotSatisfiable,
+	ErrInvalidRequest:                 http.StatusBadRequest,
+	ErrMalformedXML:                   http.StatusBadRequest,
+	ErrMethodNotAllowed:               http.StatusMethodNotAllowed,
+	ErrMissingContentLength:           http.StatusLengthRequired,
+	ErrNoSuchBucket:                   http.StatusNotFound,
+	ErrNoSuchKey:                      http.StatusNotFound,
+	ErrNoSuchUpload:                   http.StatusNotFound,
+	ErrRequestTimeTooSkewed:           http.StatusForbidden,
+	ErrSignatureDoesNotMatch:          http.StatusForbidden,
+	ErrEntityTooSmall:                 http.StatusBadRequest,
+	ErrIncompleteBody:                 http.StatusBadRequest,
+	ErrAuthorizationHeaderMalformed:   http.StatusBadRequest,
+	ErrExpiredToken:                   http.StatusForbidden,
+	ErrEntityTooLarge:                 http.StatusRequestEntityTooLarge,
+	ErrInvalidRange:                   http.StatusRequestedRangeNotSatisfiable,
+	ErrPreconditionFailed:             http.StatusPreconditionFailed,
+	ErrMalformedPOSTRequest:           http.StatusBadRequest,
+	ErrInvalidPolicyDocument:          http.StatusBadRequest,
+	ErrInvalidToken:                   http.StatusBadRequest,
+	ErrNoSuchObjectLockConfiguration:  http.StatusNotFound,
+	ErrOperationAborted:               http.StatusConflict,
+	ErrNotImplemented:                 http.StatusNotImplemented,
+	ErrInvalidTag:                     http.StatusBadRequest,
+	ErrTooManyObjects:                 http.StatusForbidden,
+	ErrNoSuchVersion:                  http.StatusNotFound,
+	ErrIllegalVersioningConfiguration: http.StatusBadRequest,
+	ErrNoSuchLifecycleConfiguration:   http.StatusNotFound,
+	ErrNoSuchCORSConfiguration:        http.StatusNotFound,
+	ErrCORSForbidden:                  http.StatusForbidden,
+	ErrTooManyBuckets:                 http.StatusBadRequest,
+	ErrServiceUnavailable:             http.StatusServiceUnavailable,
+	ErrKeyTooLong:                     http.StatusBadRequest,
+	ErrRequestTimeout:                 http.StatusBadRequest,
+	ErrAuthorizationQueryParameters:   http.StatusBadRequest,
 }
 
 var errorMessages = map[ErrorCode]string{
-	ErrAccessDenied:                 "Access Denied",
-	ErrBucketAlreadyOwnedByYou:      "Your previous request to create the named bucket succeeded and you already own it.",
-	ErrBucketNotEmpty:               "The bucket you tried to delete is not empty",
-	ErrInternalError:                "We encountered an internal error. Please try again.",
-	ErrInvalidAccessKeyId:           "The AWS Access Key Id you provided does not exist in our records.",
-	ErrInvalidArgument:              "Invalid Argument",
-	ErrInvalidBucketName:            "The specified bucket is not valid.",
-	ErrInvalidPart:                  "One or more of the specified parts could not be found.",
-	ErrInvalidPartOrder:             "The list of parts was not in ascending order.",
-	ErrInvalidRequest:               "Invalid Request",
-	ErrMalformedXML:                 "The XML you provided was not well-formed or did not validate against our published schema.",
-	ErrMethodNotAllowed:             "The specified method is not allowed against this resource.",
-	ErrMissingContentLength:         "You must provide the Content-Length HTTP header.",
-	ErrNoSuchBucket:                 "The specified bucket does not exist",
-	ErrNoSuchKey:                    "The specified key does not exist.",
-	ErrNoSuchUpload:                 "The specified multipart upload does not exist.",
-	ErrRequestTimeTooSkewed:         "The difference between the request time and the server's time is too large.",
-	ErrSignatureDoesNotMatch:        "The request signature we calculated does not match the signature you provided.",
-	ErrEntityTooSmall:               "Your proposed upload is smaller than the minimum allowed object size.",
-	ErrIncompleteBody:               "You did not provide the number of bytes specified by the Content-Length HTTP header.",
-	ErrAuthorizationHeaderMalformed: "The authorization header is malformed.",
-	ErrExpiredToken:                 "The provided token has expired.",
-	ErrEntityTooLarge:               "Your proposed upload exceeds the maximum allowed object size.",
-	ErrInvalidRange:                 "The requested range is not valid.",
+	ErrAccessDenied:                   "Access Denied",
+	ErrBucketAlreadyOwnedByYou:        "Your previous request to create the named bucket succeeded and you already own it.",
+	ErrBucketNotEmpty:                 "The bucket you tried to delete is not empty",
+	ErrInternalError:                  "We encountered an internal error. Please try again.",
+	ErrInvalidAccessKeyId:             "The AWS Access Key Id you provided does not exist in our records.",
+	ErrInvalidArgument:                "Invalid Argument",
+	ErrInvalidBucketName:              "The specified bucket is not valid.",
+	ErrInvalidPart:                    "One or more of the specified parts could not be found.",
+	ErrInvalidPartOrder:               "The list of parts was not in ascending order.",
+	ErrInvalidPartNumber:              "The requested partnumber is not satisfiable",
+	ErrInvalidRequest:                 "Invalid Request",
+	ErrMalformedXML:                   "The XML you provided was not well-formed or did not validate against our published schema.",
+	ErrMethodNotAllowed:               "The specified method is not allowed against this resource.",
+	ErrMissingContentLength:           "You must provide the Content-Length HTTP header.",
+	ErrNoSuchBucket:                   "The specified bucket does not exist",
+	ErrNoSuchKey:                      "The specified key does not exist.",
+	ErrNoSuchUpload:                   "The specified multipart upload does not exist.",
+	ErrRequestTimeTooSkewed:           "The difference between the request time and the server's time is too large.",
+	ErrSignatureDoesNotMatch:          "The request signature we calculated does not match the signature you provided.",
+	ErrEntityTooSmall:                 "Your proposed upload is smaller than the minimum allowed object size.",
+	ErrIncompleteBody:                 "You did not provide the number of bytes specified by the Content-Length HTTP header.",
+	ErrAuthorizationHeaderMalformed:   "The authorization header is malformed.",
+	ErrExpiredToken:                   "The provided token has expired.",
+	ErrEntityTooLarge:                 "Your proposed upload exceeds the maximum allowed object size.",
+	ErrInvalidRange:                   "The requested range is not valid.",
+	ErrPreconditionFailed:             "At least one of the pre-conditions you specified did not hold",
+	ErrMalformedPOSTRequest:           "The body of your POST request is not well-formed multipart/form-data.",
+	ErrInvalidPolicyDocument:          "The content of the form does not meet the conditions specified in the policy document.",
+	ErrInvalidToken:                   "The provided token is malformed or otherwise invalid.",
+	ErrNoSuchObjectLockConfiguration:  "The specified object does not have an ObjectLock configuration",
+	ErrOperationAborted:               "A conflicting conditional operation is currently in progress against this resource. Please try again.",
+	ErrNotImplemented:                 "A header you provided implies functionality that is not implemented.",
+	ErrInvalidTag:                     "The tag provided was not a valid tag.",
+	ErrTooManyObjects:                 "The bucket has reached its maximum number of objects.",
+	ErrNoSuchVersion:                  "The specified version does not exist.",
+	ErrIllegalVersioningConfiguration: "The versioning configuration specified in the request is invalid.",
+	ErrNoSuchLifecycleConfiguration:   "The lifecycle configuration does not exist.",
+	ErrNoSuchCORSConfiguration:        "The CORS configuration does not exist.",
+	ErrCORSForbidden:                  "CORSResponse: This CORS request is not allowed.",
+	ErrTooManyBuckets:                 "You have attempted to create more buckets than allowed.",
+	ErrServiceUnavailable:             "Reduce your request rate.",
+	ErrKeyTooLong:                     "Your key is too long",
+	ErrRequestTimeout:                 "Your socket connection to the server was not read from or written to within the timeout period.",
+	ErrAuthorizationQueryParameters:   "The presigned URL query parameters are malformed.",
 }
 
+// Response headers carrying the request ID and the extended request ID, as
+// set by S3 on every response
+const (
+	RequestIDHeader         = "X-Amz-Request-Id"
+	ExtendedRequestIDHeader = "X-Amz-Id-2"
+)
+
 type Error struct {
 	XMLName   xml.Name  `xml:"Error"`
 	Code      ErrorCode `xml:"Code"`
 	Message   string    `xml:"Message"`
 	Resource  string    `xml:"Resource,omitempty"`
 	RequestID string    `xml:"RequestId,omitempty"`
+	HostID    string    `xml:"HostId,omitempty"`
 }
 
 func NewError(code ErrorCode, resource string) *Error {
@@ -115,12 +183,31 @@ func (e *Error) Error() string {
 	return string(e.Code) + ": " + e.Message
 }
 
+// WriteResponse writes the error as an XML response. The request IDs are
+// taken from the response headers when they are not set on the error.
 func (e *Error) WriteResponse(w http.ResponseWriter) {
+	if e.RequestID == "" {
+		e.RequestID = w.Header().Get(RequestIDHeader)
+	}
+	if e.HostID == "" {
+		e.HostID = w.Header().Get(ExtendedRequestIDHeader)
+	}
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(e.StatusCode())
 	_ = xml.NewEncoder(w).Encode(e)
 }
 
+// WriteErrorResponseWithMessage writes an error response with a specific message
+// instead of the default message for the code. The message must not contain
+// sensitive information.
+func WriteErrorResponseWithMessage(w http.ResponseWriter, code ErrorCode, message string) {
+	err := &Error{
+		Code:    code,
+		Message: message,
+	}
+	err.WriteResponse(w)
+}
+
 // WriteErrorResponse writes an error response without including the resource path.
 // This prevents information disclosure in error messages.
 func WriteErrorResponse(w http.ResponseWriter, code ErrorCode) {
@@ -129,7 +216,5 @@ func WriteErrorResponse(w http.ResponseWriter, code ErrorCode) {
 		Message: errorMessages[code],
 		// Intentionally omit Resource to prevent information disclosure
 	}
-	w.Header().Set("Content-Type", "application/xml")
-	w.WriteHeader(err.StatusCode())
-	_ = xml.NewEncoder(w).Encode(err)
+	err.WriteResponse(w)
 }
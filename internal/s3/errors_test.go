@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -133,6 +134,24 @@ func TestAllErrorCodesHaveStatusCodes(t *testing.T) {
 		ErrIncompleteBody,
 		ErrAuthorizationHeaderMalformed,
 		ErrExpiredToken,
+		ErrPreconditionFailed,
+		ErrMalformedPOSTRequest,
+		ErrInvalidPolicyDocument,
+		ErrInvalidToken,
+		ErrNoSuchObjectLockConfiguration,
+		ErrOperationAborted,
+		ErrNotImplemented,
+		ErrInvalidTag,
+		ErrTooManyObjects,
+		ErrNoSuchVersion,
+		ErrIllegalVersioningConfiguration,
+		ErrNoSuchLifecycleConfiguration,
+		ErrNoSuchCORSConfiguration,
+		ErrCORSForbidden,
+		ErrTooManyBuckets,
+		ErrServiceUnavailable,
+		ErrKeyTooLong,
+		ErrRequestTimeout,
 	}
 
 	for _, code := range codes {
@@ -170,6 +189,24 @@ func TestAllErrorCodesHaveMessages(t *testing.T) {
 		ErrIncompleteBody,
 		ErrAuthorizationHeaderMalformed,
 		ErrExpiredToken,
+		ErrPreconditionFailed,
+		ErrMalformedPOSTRequest,
+		ErrInvalidPolicyDocument,
+		ErrInvalidToken,
+		ErrNoSuchObjectLockConfiguration,
+		ErrOperationAborted,
+		ErrNotImplemented,
+		ErrInvalidTag,
+		ErrTooManyObjects,
+		ErrNoSuchVersion,
+		ErrIllegalVersioningConfiguration,
+		ErrNoSuchLifecycleConfiguration,
+		ErrNoSuchCORSConfiguration,
+		ErrCORSForbidden,
+		ErrTooManyBuckets,
+		ErrServiceUnavailable,
+		ErrKeyTooLong,
+		ErrRequestTimeout,
 	}
 
 	for _, code := range codes {
@@ -181,3 +218,25 @@ func TestAllErrorCodesHaveMessages(t *testing.T) {
 		})
 	}
 }
+
+func TestErrorWriteResponseRequestIDs(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set(RequestIDHeader, "0123456789abcdef")
+	w.Header().Set(ExtendedRequestIDHeader, "extended-id")
+	WriteErrorResponse(w, ErrNoSuchKey)
+
+	var decoded Error
+	if err := xml.NewDecoder(w.Body).Decode(&decoded); err != nil {
+		t.Fatalf("failed to decode XML: %v", err)
+	}
+	if decoded.RequestID != "0123456789abcdef" || decoded.HostID != "extended-id" {
+		t.Errorf("RequestId = %q, HostId = %q", decoded.RequestID, decoded.HostID)
+	}
+
+	// Without the headers, the elements are omitted
+	w = httptest.NewRecorder()
+	WriteErrorResponse(w, ErrNoSuchKey)
+	if strings.Contains(w.Body.String(), "RequestId") || strings.Contains(w.Body.String(), "HostId") {
+		t.Errorf("body = %s", w.Body.String())
+	}
+}
@@ -2,6 +2,9 @@ package s3
 
 import (
 	"encoding/xml"
+	"io"
+	"maps"
+	"slices"
 	"time"
 )
 
@@ -43,6 +46,15 @@ type CopyObjectResult struct {
 	LastModified time.Time `xml:"LastModified"`
 }
 
+// PostResponse is the response for a browser-based POST upload with success_action_status 201
+type PostResponse struct {
+	XMLName  xml.Name `xml:"PostResponse"`
+	Location string   `xml:"Location,omitempty"` // Optional - omit to avoid host header injection
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
 // DeleteResult is the response for DeleteObject
 type DeleteResult struct {
 	XMLName xml.Name `xml:"DeleteResult"`
@@ -75,6 +87,7 @@ type ListBucketResultV2 struct {
 	IsTruncated           bool     `xml:"IsTruncated"`
 	ContinuationToken     string   `xml:"ContinuationToken,omitempty"`
 	NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+	EncodingType          string   `xml:"EncodingType,omitempty"`
 	Contents              []Object `xml:"Contents"`
 	CommonPrefixes        []Prefix `xml:"CommonPrefixes,omitempty"`
 }
@@ -102,8 +115,10 @@ type DeleteObjectsResult struct {
 
 // DeletedObject represents a successfully deleted object
 type DeletedObject struct {
-	Key       string `xml:"Key"`
-	VersionId string `xml:"VersionId,omitempty"`
+	Key                   string `xml:"Key"`
+	VersionId             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionId string `xml:"DeleteMarkerVersionId,omitempty"`
 }
 
 // DeleteError represents an error deleting an object
@@ -121,6 +136,184 @@ type Object struct {
 	ETag         string    `xml:"ETag"`
 	Size         int64     `xml:"Size"`
 	StorageClass string    `xml:"StorageClass"`
+	Owner        *Owner    `xml:"Owner,omitempty"`
+	// UserMetadata is only set for listings with metadata=true
+	UserMetadata UserMetadata `xml:"UserMetadata,omitempty"`
+}
+
+// UserMetadata holds the content type and x-amz-meta-* values of an object
+// in a listing, in the format of the MinIO metadata=true extension: one
+// element per value, named by the header. Values whose header name is not
+// a valid XML name are left out.
+type UserMetadata map[string]string
+
+// MarshalXML encodes the values in sorted order
+func (m UserMetadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		if !isXMLName(name) {
+			continue
+		}
+		if err := e.EncodeElement(m[name], xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// UnmarshalXML decodes each child element into a value
+func (m *UserMetadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*m = UserMetadata{}
+	for {
+		var e struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		}
+		err := d.Decode(&e)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		(*m)[e.XMLName.Local] = e.Value
+	}
+}
+
+// isXMLName reports whether a header name can be used as an element name
+func isXMLName(name string) bool {
+	for i, c := range name {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		case '0' <= c && c <= '9', c == '-', c == '.':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Owner identifies the owner of a bucket or object
+type Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName,omitempty"`
+}
+
+// LocationConstraint is the response body for GetBucketLocation
+type LocationConstraint struct {
+	XMLName  xml.Name `xml:"LocationConstraint"`
+	Xmlns    string   `xml:"xmlns,attr,omitempty"`
+	Location string   `xml:",chardata"`
+}
+
+// VersioningConfiguration is the request and response body for
+// PutBucketVersioning and GetBucketVersioning
+type VersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+// CORSConfiguration is the request and response body for PutBucketCors
+// and GetBucketCors
+type CORSConfiguration struct {
+	XMLName   xml.Name   `xml:"CORSConfiguration"`
+	Xmlns     string     `xml:"xmlns,attr,omitempty"`
+	CORSRules []CORSRule `xml:"CORSRule"`
+}
+
+// CORSRule is a rule in a CORS configuration
+type CORSRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedHeaders []string `xml:"AllowedHeader"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	ExposeHeaders  []string `xml:"ExposeHeader"`
+	MaxAgeSeconds  *int     `xml:"MaxAgeSeconds"`
+}
+
+// LifecycleConfiguration is the request and response body for
+// PutBucketLifecycleConfiguration and GetBucketLifecycleConfiguration
+type LifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Xmlns   string          `xml:"xmlns,attr,omitempty"`
+	Rules   []LifecycleRule `xml:"Rule"`
+}
+
+// LifecycleRule is a rule in a lifecycle configuration. Prefix is the
+// deprecated top-level form of Filter.Prefix.
+type LifecycleRule struct {
+	ID                             string                          `xml:"ID,omitempty"`
+	Status                         string                          `xml:"Status"`
+	Prefix                         *string                         `xml:"Prefix"`
+	Filter                         *LifecycleFilter                `xml:"Filter"`
+	Expiration                     *LifecycleExpiration            `xml:"Expiration"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload"`
+}
+
+// LifecycleFilter selects the objects a lifecycle rule applies to
+type LifecycleFilter struct {
+	Prefix *string       `xml:"Prefix"`
+	Tag    *Tag          `xml:"Tag"`
+	And    *LifecycleAnd `xml:"And"`
+}
+
+// LifecycleAnd combines a prefix and tags in a lifecycle filter
+type LifecycleAnd struct {
+	Prefix string `xml:"Prefix,omitempty"`
+	Tags   []Tag  `xml:"Tag"`
+}
+
+// Tag is an object tag
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// LifecycleExpiration expires objects a number of days after creation or
+// from a date on
+type LifecycleExpiration struct {
+	Days int    `xml:"Days,omitempty"`
+	Date string `xml:"Date,omitempty"`
+}
+
+// AbortIncompleteMultipartUpload aborts multipart uploads a number of days
+// after they were initiated
+type AbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+// ListVersionsResult is the response for ListObjectVersions
+type ListVersionsResult struct {
+	XMLName             xml.Name        `xml:"ListVersionsResult"`
+	Xmlns               string          `xml:"xmlns,attr"`
+	Name                string          `xml:"Name"`
+	Prefix              string          `xml:"Prefix"`
+	KeyMarker           string          `xml:"KeyMarker"`
+	VersionIdMarker     string          `xml:"VersionIdMarker"`
+	NextKeyMarker       string          `xml:"NextKeyMarker,omitempty"`
+	NextVersionIdMarker string          `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int             `xml:"MaxKeys"`
+	IsTruncated         bool            `xml:"IsTruncated"`
+	Versions            []ObjectVersion `xml:",any"`
+}
+
+// ObjectVersion is a Version or DeleteMarker entry in ListObjectVersions.
+// XMLName selects the element, as S3 interleaves both in key order.
+type ObjectVersion struct {
+	XMLName      xml.Name
+	Key          string    `xml:"Key"`
+	VersionId    string    `xml:"VersionId"`
+	IsLatest     bool      `xml:"IsLatest"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag,omitempty"`
+	Size         *int64    `xml:"Size,omitempty"`
+	StorageClass string    `xml:"StorageClass,omitempty"`
 }
 
 // Prefix represents a common prefix in list responses
@@ -136,6 +329,55 @@ type ObjectMetadata struct {
 	ETag         string            `json:"etag"`
 	LastModified time.Time         `json:"last_modified"`
 	UserMetadata map[string]string `json:"user_metadata,omitempty"`
+
+	// Sizes of the parts of an object written by a multipart upload, in
+	// order, so that a part can be read back with partNumber. Empty for
+	// objects uploaded in one piece and for multipart objects completed
+	// before the sizes were recorded.
+	PartSizes []int64 `json:"part_sizes,omitempty"`
+
+	// When the key was first written. Overwrites keep it while LastModified
+	// changes on every write. Zero for objects written before it was recorded.
+	Created time.Time `json:"created,omitzero"`
+
+	// Server-side encryption algorithm requested on upload, empty when none
+	ServerSideEncryption string `json:"server_side_encryption,omitempty"`
+
+	// Object tags set with x-amz-tagging, nil when the object has none
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Version of the object in a versioned bucket, empty for objects written
+	// before versioning was enabled. DeleteMarker is set on the versions that
+	// record a delete.
+	VersionID    string `json:"version_id,omitempty"`
+	DeleteMarker bool   `json:"delete_marker,omitempty"`
+
+	// Object lock state, empty when the object is not locked
+	ObjectLockMode            string     `json:"object_lock_mode,omitempty"`
+	ObjectLockRetainUntilDate *time.Time `json:"object_lock_retain_until_date,omitempty"`
+	ObjectLockLegalHold       string     `json:"object_lock_legal_hold,omitempty"`
+}
+
+// ServerSideEncryptionAES256 is the x-amz-server-side-encryption value for SSE-S3
+const ServerSideEncryptionAES256 = "AES256"
+
+// Object lock retention modes
+const (
+	ObjectLockModeGovernance = "GOVERNANCE"
+	ObjectLockModeCompliance = "COMPLIANCE"
+)
+
+// Object lock legal hold statuses
+const (
+	LegalHoldOn  = "ON"
+	LegalHoldOff = "OFF"
+)
+
+// LegalHold is the request and response body of the legal-hold subresource
+type LegalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Status  string   `xml:"Status"`
 }
 
 // MultipartUploadMetadata stores multipart upload metadata
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// ErrImportNotSupported is returned by ImportBucket for a backend that
+// cannot restore objects with their original metadata
+var ErrImportNotSupported = errors.New("storage backend does not support importing objects")
+
+// ErrInvalidArchive is returned by ImportBucket for an archive that was not
+// written by ExportBucket, or whose data does not match its metadata
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// Names of the archive entries of an object. Each object is stored as its
+// metadata followed by its data, in a directory named after its position in
+// the archive, so that keys which are not valid file names survive the
+// round trip. The key is taken from the metadata on import.
+const (
+	archiveMetaFile = "meta.json"
+	archiveDataFile = "data"
+)
+
+// ObjectImporter is implemented by storage that can restore exported
+// objects
+type ObjectImporter interface {
+	// ImportObject stores body as the data of the object described by meta,
+	// keeping its ETag, timestamps, tags and object lock state. The data
+	// must match the size and, for objects not uploaded in parts, the ETag
+	// in meta.
+	ImportObject(ctx context.Context, bucket string, meta *s3.ObjectMetadata, body io.Reader) error
+}
+
+// ArchiveResult reports the outcome of exporting or importing a bucket
+type ArchiveResult struct {
+	Objects int
+	Bytes   int64
+}
+
+// ExportBucket writes the current version of every object in bucket to w
+// as a tar archive that ImportBucket can restore. Object data is streamed
+// from storage. With dryRun, the objects are counted but nothing is written.
+func ExportBucket(ctx context.Context, store Storage, bucket string, w io.Writer, dryRun bool) (*ArchiveResult, error) {
+	result := &ArchiveResult{}
+	tw := tar.NewWriter(w)
+
+	opts := ListObjectsOptions{}
+	for {
+		page, err := store.ListObjects(bucket, opts)
+		if err != nil {
+			return result, err
+		}
+		for _, obj := range page.Objects {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			if dryRun {
+				result.Objects++
+				result.Bytes += obj.Size
+				continue
+			}
+			if err := exportObject(tw, store, bucket, obj.Key, result.Objects); err != nil {
+				// An object deleted since the listing is skipped
+				if errors.Is(err, ErrObjectNotFound) {
+					continue
+				}
+				return result, fmt.Errorf("exporting %s: %w", obj.Key, err)
+			}
+			result.Objects++
+			result.Bytes += obj.Size
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.ContinuationToken = page.NextContinuationToken
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, tw.Close()
+}
+
+// exportObject writes the metadata and data of an object as archive entry n
+func exportObject(tw *tar.Writer, store Storage, bucket, key string, n int) error {
+	reader, meta, err := store.OpenObject(bucket, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	dir := fmt.Sprintf("%09d", n)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    path.Join(dir, archiveMetaFile),
+		Mode:    0600,
+		Size:    int64(len(metaJSON)),
+		ModTime: meta.LastModified,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(metaJSON); err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    path.Join(dir, archiveDataFile),
+		Mode:    0600,
+		Size:    meta.Size,
+		ModTime: meta.LastModified,
+	}); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(tw, reader, meta.Size); err != nil {
+		return fmt.Errorf("reading object data: %w", err)
+	}
+	return nil
+}
+
+// ImportBucket restores the objects in a tar archive written by
+// ExportBucket into bucket, which is created if it does not exist. Objects
+// are stored at the paths of the current layout, replacing objects with the
+// same key. With dryRun, the archive is read and checked but nothing is
+// written. The returned result counts the objects imported before an error.
+func ImportBucket(ctx context.Context, store MultipartStorage, bucket string, r io.Reader, dryRun bool) (*ArchiveResult, error) {
+	result := &ArchiveResult{}
+	if err := ValidateBucketName(bucket); err != nil {
+		return result, err
+	}
+	importer, ok := store.(ObjectImporter)
+	if !ok {
+		return result, ErrImportNotSupported
+	}
+	if !dryRun {
+		if err := store.CreateBucket(bucket); err != nil && !errors.Is(err, ErrBucketAlreadyExists) {
+			return result, err
+		}
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		meta, err := readArchivedMetadata(tr)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		hdr, err := tr.Next()
+		if err != nil || path.Base(hdr.Name) != archiveDataFile || hdr.Size != meta.Size {
+			return result, fmt.Errorf("%w: no data of size %d for %s", ErrInvalidArchive, meta.Size, meta.Key)
+		}
+		if dryRun {
+			err = checkArchivedData(meta, tr)
+		} else {
+			err = importer.ImportObject(ctx, bucket, meta, tr)
+		}
+		if err != nil {
+			return result, fmt.Errorf("importing %s: %w", meta.Key, err)
+		}
+		result.Objects++
+		result.Bytes += meta.Size
+	}
+}
+
+// readArchivedMetadata reads the next metadata entry of an archive. It
+// returns io.EOF at the end of the archive.
+func readArchivedMetadata(tr *tar.Reader) (*s3.ObjectMetadata, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if path.Base(hdr.Name) != archiveMetaFile {
+		return nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, hdr.Name)
+	}
+
+	var meta s3.ObjectMetadata
+	if err := json.NewDecoder(io.LimitReader(tr, hdr.Size)).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("%w: parsing %s: %v", ErrInvalidArchive, hdr.Name, err)
+	}
+	if meta.Key == "" || meta.Size < 0 || meta.DeleteMarker {
+		return nil, fmt.Errorf("%w: %s does not describe an object", ErrInvalidArchive, hdr.Name)
+	}
+	return &meta, nil
+}
+
+// checkArchivedData reads the data of an archived object and checks it
+// against its metadata
+func checkArchivedData(meta *s3.ObjectMetadata, body io.Reader) error {
+	hash := md5.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return err
+	}
+	return verifyArchivedData(meta, size, hash.Sum(nil))
+}
+
+// verifyArchivedData checks the size and MD5 of archived data against its
+// metadata. ETags of multipart uploads are not a hash of the data and only
+// the size is checked.
+func verifyArchivedData(meta *s3.ObjectMetadata, size int64, sum []byte) error {
+	if size != meta.Size {
+		return fmt.Errorf("%w: data has %d bytes, metadata has %d", ErrInvalidArchive, size, meta.Size)
+	}
+	if etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(sum)); !strings.Contains(meta.ETag, "-") && etag != meta.ETag {
+		return fmt.Errorf("%w: data hashes to %s, metadata has ETag %s", ErrInvalidArchive, etag, meta.ETag)
+	}
+	return nil
+}
+
+// ImportObject stores an exported object with its original metadata. In a
+// versioned bucket the object being replaced is kept as a noncurrent
+// version, and the imported object gets a new version ID.
+func (fs *FilesystemStorage) ImportObject(ctx context.Context, bucket string, meta *s3.ObjectMetadata, body io.Reader) error {
+	objPath, err := fs.keyToPath(bucket, meta.Key)
+	if err != nil {
+		return err
+	}
+	if err := ValidateNewKey(meta.Key); err != nil {
+		return err
+	}
+	dataPath := filepath.Join(objPath, "data")
+
+	if err := fs.checkNotLocked(bucket, meta.Key); err != nil {
+		return err
+	}
+
+	tmpFile, tmpPath, err := fs.createObjectTemp(bucket, objPath)
+	if err != nil {
+		return err
+	}
+
+	hash := md5.New()
+	size, err := copyContext(ctx, io.MultiWriter(tmpFile, hash), body)
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("writing object data: %w", err)
+	}
+	if err := verifyArchivedData(meta, size, hash.Sum(nil)); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := fs.syncer.syncFile(tmpFile); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("syncing object data: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("closing temp file: %w", err)
+	}
+
+	versionID, unlock, err := fs.prepareVersionedWrite(bucket, meta.Key, objPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	defer unlock()
+
+	if err := os.Rename(tmpPath, dataPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("renaming temp file: %w", err)
+	}
+	if err := syncObjectDirs(fs.syncer, objPath); err != nil {
+		return fmt.Errorf("syncing object directory: %w", err)
+	}
+
+	imported := *meta
+	imported.VersionID = versionID
+	if err := fs.meta.put(bucket, objPath, &imported); err != nil {
+		os.Remove(dataPath)
+		return err
+	}
+	return nil
+}
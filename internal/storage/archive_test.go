@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+func TestExportImportBucket(t *testing.T) {
+	src, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Keys that are not valid file names survive the round trip
+	keys := []string{"plain.txt", "dir/", "a:b*c?", strings.Repeat("k", 300)}
+	for _, key := range keys {
+		if _, err := src.PutObjectWithOptions(ctx, testBucket, key, "text/plain", map[string]string{"owner": "alice"}, PutObjectOptions{Tags: map[string]string{"env": "prod"}}, strings.NewReader("data of "+key)); err != nil {
+			t.Fatalf("PutObject(%q) failed: %v", key, err)
+		}
+	}
+	uploadID, err := src.CreateMultipartUpload(testBucket, "multipart", "application/octet-stream", nil)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	part, err := src.UploadPart(ctx, uploadID, 1, strings.NewReader("parts"))
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	if _, err := src.CompleteMultipartUpload(ctx, uploadID, []s3.CompletedPartInput{{PartNumber: 1, ETag: part.ETag}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	keys = append(keys, "multipart")
+
+	var archive bytes.Buffer
+	dry, err := ExportBucket(ctx, src, testBucket, &archive, true)
+	if err != nil || archive.Len() != 0 {
+		t.Fatalf("dry-run ExportBucket = %v, %d bytes written; want nothing written", err, archive.Len())
+	}
+	exported, err := ExportBucket(ctx, src, testBucket, &archive, false)
+	if err != nil {
+		t.Fatalf("ExportBucket failed: %v", err)
+	}
+	if exported.Objects != len(keys) || *dry != *exported {
+		t.Errorf("exported = %+v, dry run = %+v, want %d objects", exported, dry, len(keys))
+	}
+
+	dst, cleanupDst := setupTestStorage(t)
+	defer cleanupDst()
+
+	if _, err := ImportBucket(ctx, dst, "restored", bytes.NewReader(archive.Bytes()), true); err != nil {
+		t.Fatalf("dry-run ImportBucket failed: %v", err)
+	}
+	if exists, _ := dst.BucketExists("restored"); exists {
+		t.Fatal("dry-run ImportBucket created the bucket")
+	}
+	imported, err := ImportBucket(ctx, dst, "restored", bytes.NewReader(archive.Bytes()), false)
+	if err != nil {
+		t.Fatalf("ImportBucket failed: %v", err)
+	}
+	if *imported != *exported {
+		t.Errorf("imported = %+v, want %+v", imported, exported)
+	}
+
+	for _, key := range keys {
+		want, err := src.HeadObject(testBucket, key)
+		if err != nil {
+			t.Fatalf("HeadObject(%q) failed: %v", key, err)
+		}
+		reader, got, err := dst.GetObject(ctx, "restored", key)
+		if err != nil {
+			t.Fatalf("GetObject(%q) failed: %v", key, err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+
+		if got.ETag != want.ETag || !got.LastModified.Equal(want.LastModified) || !maps.Equal(got.Tags, want.Tags) || !maps.Equal(got.UserMetadata, want.UserMetadata) {
+			t.Errorf("%q: metadata = %+v, want %+v", key, got, want)
+		}
+		if int64(len(data)) != want.Size {
+			t.Errorf("%q: got %d bytes, want %d", key, len(data), want.Size)
+		}
+	}
+}
+
+func TestImportBucketRejectsCorruptArchive(t *testing.T) {
+	src, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	if _, err := src.PutObject(ctx, testBucket, "key", "text/plain", nil, strings.NewReader("original data")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	var archive bytes.Buffer
+	if _, err := ExportBucket(ctx, src, testBucket, &archive, false); err != nil {
+		t.Fatalf("ExportBucket failed: %v", err)
+	}
+	corrupt := bytes.Replace(archive.Bytes(), []byte("original data"), []byte("ORIGINAL DATA"), 1)
+
+	for _, dryRun := range []bool{true, false} {
+		if _, err := ImportBucket(ctx, src, "restored", bytes.NewReader(corrupt), dryRun); !errors.Is(err, ErrInvalidArchive) {
+			t.Errorf("ImportBucket(dryRun=%v) err = %v, want ErrInvalidArchive", dryRun, err)
+		}
+	}
+	if _, err := src.HeadObject("restored", "key"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("HeadObject after failed import err = %v, want ErrObjectNotFound", err)
+	}
+
+	if _, err := ImportBucket(ctx, src, "restored", strings.NewReader("not a tar archive"), false); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("ImportBucket(garbage) err = %v, want ErrInvalidArchive", err)
+	}
+	if _, err := ImportBucket(ctx, NewMemoryStorage(), "restored", bytes.NewReader(archive.Bytes()), false); !errors.Is(err, ErrImportNotSupported) {
+		t.Errorf("ImportBucket(memory) err = %v, want ErrImportNotSupported", err)
+	}
+}
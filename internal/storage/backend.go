@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Storage backends that New can create
+const (
+	BackendFilesystem = "filesystem"
+	BackendMemory     = "memory"
+)
+
+// ErrUnknownBackend is returned by New for a backend name it does not know
+var ErrUnknownBackend = errors.New("unknown storage backend")
+
+// BackendOptions are the settings New creates a backend from. Each backend
+// uses the settings that apply to it and rejects those it cannot honor.
+type BackendOptions struct {
+	// Path and MultipartPath are the directories objects and multipart
+	// uploads are stored in
+	Path          string
+	MultipartPath string
+	// MetadataStore, BucketPaths, DisableSync and MetadataCacheEntries are
+	// passed on as in FilesystemOptions
+	MetadataStore        string
+	BucketPaths          map[string]string
+	DisableSync          bool
+	MetadataCacheEntries int
+	// ImmutabilityWindows maps bucket names to a grace period after an
+	// object's creation during which it cannot be overwritten or deleted
+	ImmutabilityWindows map[string]time.Duration
+	// TrashRetention is passed on as in FilesystemOptions
+	TrashRetention time.Duration
+}
+
+// backends maps backend names to functions that validate the options for
+// the backend and create it
+var backends = map[string]func(BackendOptions) (MultipartStorage, error){
+	BackendFilesystem: newFilesystemBackend,
+	BackendMemory:     newMemoryBackend,
+}
+
+// Backends returns the names of the backends New can create, in sorted order
+func Backends() []string {
+	return slices.Sorted(maps.Keys(backends))
+}
+
+// New creates the storage backend called name from opts
+func New(name string, opts BackendOptions) (MultipartStorage, error) {
+	create, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, must be one of: %s", ErrUnknownBackend, name, strings.Join(Backends(), ", "))
+	}
+	store, err := create(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s backend: %w", name, err)
+	}
+	return store, nil
+}
+
+func newFilesystemBackend(opts BackendOptions) (MultipartStorage, error) {
+	if opts.Path == "" || opts.MultipartPath == "" {
+		return nil, errors.New("storage and multipart paths are required")
+	}
+	store, err := NewFilesystemStorageWithOptions(opts.Path, opts.MultipartPath, FilesystemOptions{
+		MetadataStore:        opts.MetadataStore,
+		BucketPaths:          opts.BucketPaths,
+		ImmutabilityWindows:  opts.ImmutabilityWindows,
+		DisableSync:          opts.DisableSync,
+		MetadataCacheEntries: opts.MetadataCacheEntries,
+		TrashRetention:       opts.TrashRetention,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func newMemoryBackend(opts BackendOptions) (MultipartStorage, error) {
+	// Buckets cannot be placed on other volumes when nothing is on disk
+	if len(opts.BucketPaths) > 0 {
+		return nil, errors.New("bucket paths are not supported")
+	}
+	if opts.TrashRetention > 0 {
+		return nil, errors.New("trash is not supported")
+	}
+	return NewMemoryStorageWithOptions(MemoryOptions{
+		ImmutabilityWindows: opts.ImmutabilityWindows,
+	}), nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tmpDir := t.TempDir()
+	opts := BackendOptions{
+		Path:          filepath.Join(tmpDir, "data"),
+		MultipartPath: filepath.Join(tmpDir, "multipart"),
+		MetadataStore: MetadataStoreJSON,
+	}
+
+	store, err := New(BackendFilesystem, opts)
+	if err != nil {
+		t.Fatalf("New(filesystem) failed: %v", err)
+	}
+	if _, ok := store.(*FilesystemStorage); !ok {
+		t.Errorf("New(filesystem) returned %T", store)
+	}
+
+	store, err = New(BackendMemory, BackendOptions{})
+	if err != nil {
+		t.Fatalf("New(memory) failed: %v", err)
+	}
+	if _, ok := store.(*MemoryStorage); !ok {
+		t.Errorf("New(memory) returned %T", store)
+	}
+
+	t.Run("unknown backend", func(t *testing.T) {
+		if _, err := New("s3", opts); !errors.Is(err, ErrUnknownBackend) {
+			t.Errorf("err = %v, want ErrUnknownBackend", err)
+		}
+	})
+
+	t.Run("filesystem without paths", func(t *testing.T) {
+		if _, err := New(BackendFilesystem, BackendOptions{Path: opts.Path}); err == nil {
+			t.Error("expected error without a multipart path")
+		}
+	})
+
+	t.Run("memory with bucket paths", func(t *testing.T) {
+		if _, err := New(BackendMemory, BackendOptions{BucketPaths: map[string]string{"media": "/mnt/media"}}); err == nil {
+			t.Error("expected error for bucket paths")
+		}
+	})
+}
@@ -2,11 +2,13 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync/atomic"
 	"testing"
 
@@ -67,7 +69,7 @@ func BenchmarkPutObject(b *testing.B) {
 
 			for i := 0; i < b.N; i++ {
 				key := fmt.Sprintf("bench-object-%d", i)
-				_, err := storage.PutObject(benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
+				_, err := storage.PutObject(context.Background(), benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
 				if err != nil {
 					b.Fatalf("PutObject failed: %v", err)
 				}
@@ -93,7 +95,7 @@ func BenchmarkGetObject(b *testing.B) {
 			content := make([]byte, size)
 			_, _ = rand.Read(content)
 			key := "bench-get-object"
-			if _, err := storage.PutObject(benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content)); err != nil {
+			if _, err := storage.PutObject(context.Background(), benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content)); err != nil {
 				b.Fatalf("PutObject failed: %v", err)
 			}
 
@@ -101,7 +103,7 @@ func BenchmarkGetObject(b *testing.B) {
 			b.ResetTimer()
 
 			for i := 0; i < b.N; i++ {
-				reader, _, err := storage.GetObject(benchBucket, key)
+				reader, _, err := storage.GetObject(context.Background(), benchBucket, key)
 				if err != nil {
 					b.Fatalf("GetObject failed: %v", err)
 				}
@@ -118,7 +120,7 @@ func BenchmarkHeadObject(b *testing.B) {
 
 	// Create object first
 	key := "bench-head-object"
-	if _, err := storage.PutObject(benchBucket, key, "text/plain", nil, bytes.NewReader([]byte("content"))); err != nil {
+	if _, err := storage.PutObject(context.Background(), benchBucket, key, "text/plain", nil, bytes.NewReader([]byte("content"))); err != nil {
 		b.Fatalf("PutObject failed: %v", err)
 	}
 
@@ -139,7 +141,7 @@ func BenchmarkDeleteObject(b *testing.B) {
 	// Pre-create objects
 	for i := 0; i < b.N; i++ {
 		key := fmt.Sprintf("bench-delete-%d", i)
-		if _, err := storage.PutObject(benchBucket, key, "text/plain", nil, bytes.NewReader([]byte("content"))); err != nil {
+		if _, err := storage.PutObject(context.Background(), benchBucket, key, "text/plain", nil, bytes.NewReader([]byte("content"))); err != nil {
 			b.Fatalf("PutObject failed: %v", err)
 		}
 	}
@@ -160,7 +162,7 @@ func BenchmarkObjectExists(b *testing.B) {
 	defer cleanup()
 
 	key := "bench-exists-object"
-	if _, err := storage.PutObject(benchBucket, key, "text/plain", nil, bytes.NewReader([]byte("content"))); err != nil {
+	if _, err := storage.PutObject(context.Background(), benchBucket, key, "text/plain", nil, bytes.NewReader([]byte("content"))); err != nil {
 		b.Fatalf("PutObject failed: %v", err)
 	}
 
@@ -207,7 +209,7 @@ func BenchmarkMultipartUpload(b *testing.B) {
 
 					var completedParts []s3.CompletedPartInput
 					for p := 1; p <= parts; p++ {
-						partMeta, err := storage.UploadPart(uploadID, p, bytes.NewReader(partContent))
+						partMeta, err := storage.UploadPart(context.Background(), uploadID, p, bytes.NewReader(partContent))
 						if err != nil {
 							b.Fatalf("UploadPart failed: %v", err)
 						}
@@ -217,7 +219,7 @@ func BenchmarkMultipartUpload(b *testing.B) {
 						})
 					}
 
-					_, err = storage.CompleteMultipartUpload(uploadID, completedParts)
+					_, err = storage.CompleteMultipartUpload(context.Background(), uploadID, completedParts)
 					if err != nil {
 						b.Fatalf("CompleteMultipartUpload failed: %v", err)
 					}
@@ -243,7 +245,7 @@ func BenchmarkConcurrentPutObject(b *testing.B) {
 		for pb.Next() {
 			i := counter.Add(1)
 			key := fmt.Sprintf("bench-concurrent-%d", i)
-			_, err := storage.PutObject(benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
+			_, err := storage.PutObject(context.Background(), benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
 			if err != nil {
 				b.Errorf("PutObject failed: %v", err)
 			}
@@ -259,7 +261,7 @@ func BenchmarkConcurrentGetObject(b *testing.B) {
 	content := make([]byte, 64*1024) // 64KB
 	_, _ = rand.Read(content)
 	key := "bench-concurrent-get"
-	if _, err := storage.PutObject(benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content)); err != nil {
+	if _, err := storage.PutObject(context.Background(), benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content)); err != nil {
 		b.Fatalf("PutObject failed: %v", err)
 	}
 
@@ -268,7 +270,7 @@ func BenchmarkConcurrentGetObject(b *testing.B) {
 
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			reader, _, err := storage.GetObject(benchBucket, key)
+			reader, _, err := storage.GetObject(context.Background(), benchBucket, key)
 			if err != nil {
 				b.Errorf("GetObject failed: %v", err)
 				continue
@@ -289,7 +291,7 @@ func BenchmarkMixedWorkload(b *testing.B) {
 	// Pre-populate some objects
 	for i := 0; i < 100; i++ {
 		key := fmt.Sprintf("preload-%d", i)
-		if _, err := storage.PutObject(benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content)); err != nil {
+		if _, err := storage.PutObject(context.Background(), benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content)); err != nil {
 			b.Fatalf("PutObject failed: %v", err)
 		}
 	}
@@ -303,10 +305,10 @@ func BenchmarkMixedWorkload(b *testing.B) {
 			switch {
 			case op < 3: // 30% writes
 				key := fmt.Sprintf("mixed-write-%d", i)
-				_, _ = storage.PutObject(benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
+				_, _ = storage.PutObject(context.Background(), benchBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
 			case op < 8: // 50% reads
 				key := fmt.Sprintf("preload-%d", i%100)
-				reader, _, err := storage.GetObject(benchBucket, key)
+				reader, _, err := storage.GetObject(context.Background(), benchBucket, key)
 				if err == nil {
 					_, _ = io.Copy(io.Discard, reader)
 					_ = reader.Close()
@@ -319,3 +321,97 @@ func BenchmarkMixedWorkload(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkHeadObjectMetadataStore compares HeadObject latency of the
+// metadata stores in a bucket with many objects
+func BenchmarkHeadObjectMetadataStore(b *testing.B) {
+	const objectCount = 10000
+
+	for _, store := range []string{MetadataStoreJSON, MetadataStoreKV} {
+		b.Run("store="+store, func(b *testing.B) {
+			tmpDir := b.TempDir()
+			storage, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), FilesystemOptions{MetadataStore: store})
+			if err != nil {
+				b.Fatalf("failed to create storage: %v", err)
+			}
+			if err := storage.CreateBucket(benchBucket); err != nil {
+				b.Fatalf("failed to create benchmark bucket: %v", err)
+			}
+
+			for i := 0; i < objectCount; i++ {
+				key := fmt.Sprintf("bench-object-%d", i)
+				if _, err := storage.PutObject(context.Background(), benchBucket, key, "text/plain", nil, bytes.NewReader([]byte("x"))); err != nil {
+					b.Fatalf("PutObject failed: %v", err)
+				}
+			}
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				key := fmt.Sprintf("bench-object-%d", i%objectCount)
+				if _, err := storage.HeadObject(benchBucket, key); err != nil {
+					b.Fatalf("HeadObject failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkListObjects100k lists one page from the middle of a bucket with
+// 100k objects, using the key index, streaming from the key index without
+// collecting the page, and, for comparison, by walking every meta.json file
+// as listings did before the index existed
+func BenchmarkListObjects100k(b *testing.B) {
+	const objectCount = 100000
+
+	storage, cleanup := setupBenchStorage(b)
+	defer cleanup()
+
+	for i := 0; i < objectCount; i++ {
+		key := fmt.Sprintf("dir-%02d/bench-object-%06d", i%100, i)
+		if _, err := storage.PutObject(context.Background(), benchBucket, key, "text/plain", nil, bytes.NewReader([]byte("x"))); err != nil {
+			b.Fatalf("PutObject failed: %v", err)
+		}
+	}
+	startAfter := fmt.Sprintf("dir-50/bench-object-%06d", objectCount/2)
+
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			result, err := storage.ListObjects(benchBucket, ListObjectsOptions{StartAfter: startAfter})
+			if err != nil {
+				b.Fatalf("ListObjects failed: %v", err)
+			}
+			if len(result.Objects) != 1000 {
+				b.Fatalf("listed %d objects, want 1000", len(result.Objects))
+			}
+		}
+	})
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			listed := 0
+			_, err := storage.ListObjectsFunc(benchBucket, ListObjectsOptions{StartAfter: startAfter}, func(ListEntry) error {
+				listed++
+				return nil
+			})
+			if err != nil {
+				b.Fatalf("ListObjectsFunc failed: %v", err)
+			}
+			if listed != 1000 {
+				b.Fatalf("listed %d objects, want 1000", listed)
+			}
+		}
+	})
+
+	b.Run("walk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			objects, err := storage.meta.list(benchBucket)
+			if err != nil {
+				b.Fatalf("list failed: %v", err)
+			}
+			sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+		}
+	})
+}
@@ -2,11 +2,18 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/espen/stupid-simple-s3/internal/s3"
 )
@@ -34,14 +41,14 @@ func TestConcurrentUploads(t *testing.T) {
 				content[j] = byte((idx + j) % 256)
 			}
 
-			_, err := storage.PutObject(testBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
+			_, err := storage.PutObject(context.Background(), testBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
 			if err != nil {
 				errors <- fmt.Errorf("upload %d failed: %w", idx, err)
 				return
 			}
 
 			// Verify the upload
-			reader, _, err := storage.GetObject(testBucket, key)
+			reader, _, err := storage.GetObject(context.Background(), testBucket, key)
 			if err != nil {
 				errors <- fmt.Errorf("get %d failed: %w", idx, err)
 				return
@@ -83,7 +90,7 @@ func TestConcurrentOverwrites(t *testing.T) {
 			for j := 0; j < iterations; j++ {
 				content := []byte(fmt.Sprintf("writer-%d-iteration-%d", writerID, j))
 
-				_, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(content))
+				_, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content))
 				if err != nil {
 					errors <- fmt.Errorf("writer %d iteration %d failed: %w", writerID, j, err)
 				}
@@ -99,7 +106,7 @@ func TestConcurrentOverwrites(t *testing.T) {
 	}
 
 	// Verify file exists and is readable
-	reader, meta, err := storage.GetObject(testBucket, key)
+	reader, meta, err := storage.GetObject(context.Background(), testBucket, key)
 	if err != nil {
 		t.Fatalf("final GetObject failed: %v", err)
 	}
@@ -126,7 +133,7 @@ func TestConcurrentDownloads(t *testing.T) {
 		content[i] = byte(i % 256)
 	}
 
-	_, err := storage.PutObject(testBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
+	_, err := storage.PutObject(context.Background(), testBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
 	if err != nil {
 		t.Fatalf("failed to create source file: %v", err)
 	}
@@ -139,7 +146,7 @@ func TestConcurrentDownloads(t *testing.T) {
 		go func(readerID int) {
 			defer wg.Done()
 
-			reader, meta, err := storage.GetObject(testBucket, key)
+			reader, meta, err := storage.GetObject(context.Background(), testBucket, key)
 			if err != nil {
 				errors <- fmt.Errorf("reader %d GetObject failed: %w", readerID, err)
 				return
@@ -182,7 +189,7 @@ func TestConcurrentDeletions(t *testing.T) {
 	for i := 0; i < numFiles; i++ {
 		key := fmt.Sprintf("concurrent/delete-%d.txt", i)
 		content := []byte(fmt.Sprintf("content for file %d", i))
-		if _, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
 			t.Fatalf("failed to create file %d: %v", i, err)
 		}
 	}
@@ -233,7 +240,7 @@ func TestConcurrentMixedOperations(t *testing.T) {
 	for i := 0; i < numOperations; i++ {
 		key := fmt.Sprintf("mixed/existing-%d.txt", i)
 		content := []byte(fmt.Sprintf("existing content %d", i))
-		if _, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
 			t.Fatalf("failed to create file %d: %v", i, err)
 		}
 	}
@@ -251,7 +258,7 @@ func TestConcurrentMixedOperations(t *testing.T) {
 			for j := range content {
 				content[j] = byte((idx + j) % 256)
 			}
-			if _, err := storage.PutObject(testBucket, key, "application/octet-stream", nil, bytes.NewReader(content)); err != nil {
+			if _, err := storage.PutObject(context.Background(), testBucket, key, "application/octet-stream", nil, bytes.NewReader(content)); err != nil {
 				errors <- fmt.Errorf("write %d failed: %w", idx, err)
 			}
 		}(i)
@@ -263,7 +270,7 @@ func TestConcurrentMixedOperations(t *testing.T) {
 		go func(idx int) {
 			defer wg.Done()
 			key := fmt.Sprintf("mixed/existing-%d.txt", idx)
-			reader, _, err := storage.GetObject(testBucket, key)
+			reader, _, err := storage.GetObject(context.Background(), testBucket, key)
 			if err != nil {
 				// File might have been deleted by another goroutine
 				if err != ErrObjectNotFound {
@@ -350,7 +357,7 @@ func TestConcurrentMultipartUploads(t *testing.T) {
 						content[j] = byte((uploadIdx + partNum + j) % 256)
 					}
 
-					partMeta, err := storage.UploadPart(uploadID, partNum, bytes.NewReader(content))
+					partMeta, err := storage.UploadPart(context.Background(), uploadID, partNum, bytes.NewReader(content))
 					if err != nil {
 						partErrors <- fmt.Errorf("upload %d part %d failed: %w", uploadIdx, partNum, err)
 						return
@@ -379,7 +386,7 @@ func TestConcurrentMultipartUploads(t *testing.T) {
 				}
 			}
 
-			_, err = storage.CompleteMultipartUpload(uploadID, completedParts)
+			_, err = storage.CompleteMultipartUpload(context.Background(), uploadID, completedParts)
 			if err != nil {
 				errors <- fmt.Errorf("complete multipart %d failed: %w", uploadIdx, err)
 				return
@@ -416,7 +423,7 @@ func TestConcurrentReadsDuringWrite(t *testing.T) {
 
 	// Create initial file
 	initialContent := []byte("initial content")
-	if _, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(initialContent)); err != nil {
+	if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(initialContent)); err != nil {
 		t.Fatalf("failed to create initial file: %v", err)
 	}
 
@@ -429,7 +436,7 @@ func TestConcurrentReadsDuringWrite(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			for j := 0; j < 10; j++ {
-				reader, _, err := storage.GetObject(testBucket, key)
+				reader, _, err := storage.GetObject(context.Background(), testBucket, key)
 				if err != nil {
 					// Object might be in transition, this is expected
 					atomic.AddInt64(&readErrors, 1)
@@ -455,7 +462,7 @@ func TestConcurrentReadsDuringWrite(t *testing.T) {
 		go func(idx int) {
 			defer wg.Done()
 			content := []byte(fmt.Sprintf("updated content version %d with some padding to make it longer", idx))
-			if _, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
+			if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
 				atomic.AddInt64(&writeErrors, 1)
 			}
 		}(i)
@@ -470,7 +477,7 @@ func TestConcurrentReadsDuringWrite(t *testing.T) {
 	}
 
 	// Verify file is in consistent state after all operations
-	reader, _, err := storage.GetObject(testBucket, key)
+	reader, _, err := storage.GetObject(context.Background(), testBucket, key)
 	if err != nil {
 		t.Fatalf("final GetObject failed: %v", err)
 	}
@@ -511,7 +518,7 @@ func TestConcurrentMultipartPartsOnSameUpload(t *testing.T) {
 				content[j] = byte((partNum + j) % 256)
 			}
 
-			partMeta, err := storage.UploadPart(uploadID, partNum, bytes.NewReader(content))
+			partMeta, err := storage.UploadPart(context.Background(), uploadID, partNum, bytes.NewReader(content))
 			if err != nil {
 				errors <- fmt.Errorf("part %d failed: %w", partNum, err)
 				return
@@ -539,7 +546,7 @@ func TestConcurrentMultipartPartsOnSameUpload(t *testing.T) {
 		}
 	}
 
-	objMeta, err := storage.CompleteMultipartUpload(uploadID, completedParts)
+	objMeta, err := storage.CompleteMultipartUpload(context.Background(), uploadID, completedParts)
 	if err != nil {
 		t.Fatalf("CompleteMultipartUpload failed: %v", err)
 	}
@@ -549,3 +556,268 @@ func TestConcurrentMultipartPartsOnSameUpload(t *testing.T) {
 		t.Errorf("Size = %d, want %d", objMeta.Size, expectedSize)
 	}
 }
+
+// TestConcurrentOverwriteReads checks that readers never see one write's
+// data with another write's metadata while the object is overwritten
+func TestConcurrentOverwriteReads(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	const key = "concurrent/read-while-overwritten.txt"
+	if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("initial"))); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	var stop atomic.Bool
+	var writers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		writers.Add(1)
+		go func(writerID int) {
+			defer writers.Done()
+			for j := 0; !stop.Load(); j++ {
+				// Vary the length, so that torn reads show as size mismatches
+				content := bytes.Repeat([]byte(fmt.Sprintf("w%d-%d;", writerID, j)), 1+j%7)
+				if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
+					t.Errorf("PutObject failed: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	for i := 0; i < 500; i++ {
+		reader, meta, err := storage.GetObject(context.Background(), testBucket, key)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("reading object failed: %v", err)
+		}
+		if etag := fmt.Sprintf("\"%x\"", md5.Sum(content)); int64(len(content)) != meta.Size || etag != meta.ETag {
+			t.Fatalf("torn read: %d bytes with ETag %s, metadata has %d bytes with ETag %s", len(content), etag, meta.Size, meta.ETag)
+		}
+	}
+	stop.Store(true)
+	writers.Wait()
+}
+
+// TestReadsShareObjectLock checks that reads of a key do not wait for each
+// other, while writes still wait for reads in progress
+func TestReadsShareObjectLock(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	const key = "concurrent/shared-read.txt"
+	if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("content"))); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	objPath, err := storage.keyToPath(testBucket, key)
+	if err != nil {
+		t.Fatalf("keyToPath failed: %v", err)
+	}
+
+	// A read in progress
+	unlock := storage.rlockObject(objPath)
+
+	read := make(chan error, 1)
+	go func() {
+		reader, _, err := storage.OpenObject(testBucket, key)
+		if err == nil {
+			reader.Close()
+		}
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatalf("OpenObject failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OpenObject waited for another read of the same key")
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("overwritten")))
+		written <- err
+	}()
+	select {
+	case <-written:
+		t.Fatal("PutObject did not wait for the read in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if err := <-written; err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+}
+
+// crashingMetadataStore panics when metadata is written, like a crash
+// between moving an object's data into place and writing its metadata
+type crashingMetadataStore struct {
+	metadataStore
+}
+
+func (crashingMetadataStore) put(bucket, objPath string, meta *s3.ObjectMetadata) error {
+	panic("simulated crash")
+}
+
+func TestCrashBetweenDataAndMetadata(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	const key = "crash/new-object.txt"
+	meta := storage.meta
+	storage.meta = crashingMetadataStore{meta}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("PutObject did not reach the metadata write")
+			}
+		}()
+		_, _ = storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("orphaned")))
+	}()
+	storage.meta = meta
+
+	objPath, err := storage.keyToPath(testBucket, key)
+	if err != nil {
+		t.Fatalf("keyToPath failed: %v", err)
+	}
+	dataPath := filepath.Join(objPath, "data")
+	if _, err := os.Stat(dataPath); err != nil {
+		t.Fatalf("data file was not left behind by the crash: %v", err)
+	}
+
+	if _, err := storage.HeadObject(testBucket, key); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("HeadObject err = %v, want ErrObjectNotFound", err)
+	}
+	if _, _, err := storage.GetObject(context.Background(), testBucket, key); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("GetObject err = %v, want ErrObjectNotFound", err)
+	}
+	if _, err := os.Stat(dataPath); !os.IsNotExist(err) {
+		t.Errorf("orphaned data file was not removed: %v", err)
+	}
+	if keys := listKeys(t, storage); slices.Contains(keys, key) {
+		t.Errorf("orphaned object listed: %v", keys)
+	}
+
+	// The key can be written again
+	if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("written"))); err != nil {
+		t.Fatalf("PutObject after crash failed: %v", err)
+	}
+	reader, _, err := storage.GetObject(context.Background(), testBucket, key)
+	if err != nil {
+		t.Fatalf("GetObject after crash failed: %v", err)
+	}
+	defer reader.Close()
+	if content, _ := io.ReadAll(reader); string(content) != "written" {
+		t.Errorf("content = %q, want %q", content, "written")
+	}
+}
+
+// TestLegalHoldDuringOverwrites checks that a legal hold set while the
+// object is being overwritten is never lost to an overwrite in flight, and
+// that no overwrite lands once the hold is set
+func TestLegalHoldDuringOverwrites(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	const key = "concurrent/held-while-overwritten.txt"
+	if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("initial"))); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	var writers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		writers.Add(1)
+		go func(writerID int) {
+			defer writers.Done()
+			// Bounded, so that a lost hold fails the test instead of hanging it
+			for j := 0; j < 10000; j++ {
+				content := []byte(fmt.Sprintf("w%d-%d", writerID, j))
+				_, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content))
+				if errors.Is(err, ErrObjectLocked) {
+					return
+				}
+				if err != nil {
+					t.Errorf("PutObject failed: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	if err := storage.PutObjectLegalHold(testBucket, key, s3.LegalHoldOn); err != nil {
+		t.Fatalf("PutObjectLegalHold failed: %v", err)
+	}
+	held, err := storage.HeadObject(testBucket, key)
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	writers.Wait()
+
+	meta, err := storage.HeadObject(testBucket, key)
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if meta.ObjectLockLegalHold != s3.LegalHoldOn {
+		t.Errorf("legal hold = %q after overwrites, want %q", meta.ObjectLockLegalHold, s3.LegalHoldOn)
+	}
+	if meta.ETag != held.ETag {
+		t.Errorf("held object was overwritten: ETag %s, want %s", meta.ETag, held.ETag)
+	}
+}
+
+// TestConcurrentPutAndDelete checks that racing writes and deletes of one
+// key leave either a complete object or no object at all
+func TestConcurrentPutAndDelete(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	const key = "concurrent/put-and-delete.txt"
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(writerID int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				content := bytes.Repeat([]byte(fmt.Sprintf("w%d-%d;", writerID, j)), 1+j%7)
+				if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
+					t.Errorf("PutObject failed: %v", err)
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if err := storage.DeleteObject(testBucket, key); err != nil && !errors.Is(err, ErrObjectNotFound) {
+					t.Errorf("DeleteObject failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	reader, meta, err := storage.GetObject(context.Background(), testBucket, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		if keys := listKeys(t, storage); slices.Contains(keys, key) {
+			t.Errorf("deleted object listed: %v", keys)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	content, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("reading object failed: %v", err)
+	}
+	if etag := fmt.Sprintf("\"%x\"", md5.Sum(content)); int64(len(content)) != meta.Size || etag != meta.ETag {
+		t.Errorf("torn object: %d bytes with ETag %s, metadata has %d bytes with ETag %s", len(content), etag, meta.Size, meta.ETag)
+	}
+}
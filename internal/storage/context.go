@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// copyChunkSize is the number of bytes copyContext copies between checks of
+// the context
+const copyChunkSize = 8 << 20
+
+// copyContext copies from src to dst like io.Copy, but stops with the
+// context's error once ctx is done. It copies in chunks with io.CopyN, which
+// keeps the copy_file_range and sendfile paths of *os.File.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := io.CopyN(dst, src, copyChunkSize)
+		written += n
+		if errors.Is(err, io.EOF) {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// contextReadCloser fails reads with the context's error once ctx is done
+type contextReadCloser struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (c *contextReadCloser) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.ReadCloser.Read(p)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoSuchCORSConfiguration is returned when a bucket has no CORS
+// configuration
+var ErrNoSuchCORSConfiguration = errors.New("CORS configuration not found")
+
+// corsFile holds the CORS rules of a bucket
+const corsFile = "cors.json"
+
+// CORSRule is a bucket CORS rule. Origins and headers may contain one "*"
+// wildcard.
+type CORSRule struct {
+	ID             string   `json:"id,omitempty"`
+	AllowedOrigins []string `json:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods"`
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+	ExposeHeaders  []string `json:"expose_headers,omitempty"`
+	// MaxAgeSeconds is how long browsers may cache a preflight response,
+	// nil to leave it to the browser
+	MaxAgeSeconds *int `json:"max_age_seconds,omitempty"`
+}
+
+// corsConfig is the content of corsFile
+type corsConfig struct {
+	Rules []CORSRule `json:"rules"`
+}
+
+// GetBucketCORS returns the CORS rules of a bucket. The rules are cached,
+// as they are consulted on every request with an Origin header.
+func (fs *FilesystemStorage) GetBucketCORS(bucket string) ([]CORSRule, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+	if rules, ok := fs.cors.Load(bucket); ok {
+		if rules == nil {
+			return nil, ErrNoSuchCORSConfiguration
+		}
+		return rules.([]CORSRule), nil
+	}
+
+	bucketPath := fs.bucketPath(bucket)
+	var config corsConfig
+	if err := readJSONFile(filepath.Join(bucketPath, corsFile), &config); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading CORS configuration: %w", err)
+		}
+		if _, err := os.Stat(filepath.Join(bucketPath, "objects")); os.IsNotExist(err) {
+			return nil, ErrBucketNotFound
+		}
+		fs.cors.Store(bucket, nil)
+		return nil, ErrNoSuchCORSConfiguration
+	}
+
+	fs.cors.Store(bucket, config.Rules)
+	return config.Rules, nil
+}
+
+// PutBucketCORS replaces the CORS rules of a bucket
+func (fs *FilesystemStorage) PutBucketCORS(bucket string, rules []CORSRule) error {
+	bucketPath, err := fs.existingBucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(fs.syncer, filepath.Join(bucketPath, corsFile), corsConfig{Rules: rules}); err != nil {
+		return err
+	}
+	fs.cors.Store(bucket, rules)
+	return nil
+}
+
+// DeleteBucketCORS removes the CORS rules of a bucket
+func (fs *FilesystemStorage) DeleteBucketCORS(bucket string) error {
+	bucketPath, err := fs.existingBucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(bucketPath, corsFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing CORS configuration: %w", err)
+	}
+	fs.cors.Store(bucket, nil)
+	return nil
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBucketCORSConfiguration(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	if _, err := storage.GetBucketCORS(testBucket); !errors.Is(err, ErrNoSuchCORSConfiguration) {
+		t.Errorf("GetBucketCORS error = %v, want ErrNoSuchCORSConfiguration", err)
+	}
+	if _, err := storage.GetBucketCORS("missing-bucket"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("missing bucket error = %v, want ErrBucketNotFound", err)
+	}
+	if err := storage.PutBucketCORS("missing-bucket", nil); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("PutBucketCORS on missing bucket error = %v, want ErrBucketNotFound", err)
+	}
+
+	maxAge := 600
+	rules := []CORSRule{{
+		ID:             "web",
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"*"},
+		MaxAgeSeconds:  &maxAge,
+	}}
+	if err := storage.PutBucketCORS(testBucket, rules); err != nil {
+		t.Fatalf("PutBucketCORS failed: %v", err)
+	}
+
+	// A fresh storage reads the rules from disk rather than the cache
+	reopened, err := NewFilesystemStorage(storage.basePath, storage.multipartPath)
+	if err != nil {
+		t.Fatalf("NewFilesystemStorage failed: %v", err)
+	}
+	for name, s := range map[string]*FilesystemStorage{"cached": storage, "reopened": reopened} {
+		got, err := s.GetBucketCORS(testBucket)
+		if err != nil {
+			t.Fatalf("%s: GetBucketCORS failed: %v", name, err)
+		}
+		if len(got) != 1 || got[0].ID != "web" || got[0].AllowedOrigins[0] != "https://*.example.com" || *got[0].MaxAgeSeconds != 600 {
+			t.Errorf("%s: rules = %+v, want %+v", name, got, rules)
+		}
+	}
+
+	if err := storage.DeleteBucketCORS(testBucket); err != nil {
+		t.Fatalf("DeleteBucketCORS failed: %v", err)
+	}
+	if _, err := storage.GetBucketCORS(testBucket); !errors.Is(err, ErrNoSuchCORSConfiguration) {
+		t.Errorf("after delete error = %v, want ErrNoSuchCORSConfiguration", err)
+	}
+	// Deleting a missing configuration succeeds, as in S3
+	if err := storage.DeleteBucketCORS(testBucket); err != nil {
+		t.Errorf("second DeleteBucketCORS failed: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// DiskSpace is the capacity of the filesystem holding a storage path
+type DiskSpace struct {
+	Free  uint64 // Bytes available to the server
+	Total uint64 // Size of the filesystem in bytes
+}
+
+// DiskSpace returns the capacity of the filesystems holding the data of
+// bucket and the multipart path, which may be the same filesystem. The data
+// of a bucket with an override in BucketPaths is on the filesystem of its
+// own path; an empty bucket selects the data path.
+func (fs *FilesystemStorage) DiskSpace(bucket string) (data, multipart DiskSpace, err error) {
+	if data, err = statDiskSpace(fs.bucketBase(bucket)); err != nil {
+		return DiskSpace{}, DiskSpace{}, fmt.Errorf("data path: %w", err)
+	}
+	if multipart, err = statDiskSpace(fs.multipartPath); err != nil {
+		return DiskSpace{}, DiskSpace{}, fmt.Errorf("multipart path: %w", err)
+	}
+	return data, multipart, nil
+}
+
+func statDiskSpace(path string) (DiskSpace, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return DiskSpace{}, err
+	}
+	blockSize := uint64(st.Bsize)
+	return DiskSpace{
+		Free:  st.Bavail * blockSize,
+		Total: st.Blocks * blockSize,
+	}, nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// syncer flushes written files and directory entries to stable storage, so
+// that a write acknowledged to a client survives a crash
+type syncer interface {
+	syncFile(f *os.File) error
+	syncDir(dir string) error
+}
+
+// fsyncer syncs with fsync(2)
+type fsyncer struct{}
+
+func (fsyncer) syncFile(f *os.File) error {
+	return f.Sync()
+}
+
+func (fsyncer) syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// noSyncer leaves writes in the page cache, for setups where losing recent
+// writes in a crash is acceptable
+type noSyncer struct{}
+
+func (noSyncer) syncFile(*os.File) error { return nil }
+func (noSyncer) syncDir(string) error    { return nil }
+
+// syncObjectDirs persists the directory entries leading to a newly written
+// object: the object directory, and the hash prefix and objects
+// directories above it that may have been created for it
+func syncObjectDirs(s syncer, objPath string) error {
+	for dir, i := objPath, 0; i < 3; dir, i = filepath.Dir(dir), i+1 {
+		if err := s.syncDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,20 +1,22 @@
 package storage
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 
@@ -24,6 +26,13 @@ import (
 // ErrInvalidKey is returned when an object key fails validation
 var ErrInvalidKey = errors.New("invalid object key")
 
+// MaxKeyLength is the maximum length of an object key in bytes, as in S3
+const MaxKeyLength = 1024
+
+// ErrKeyTooLong is returned when an object key exceeds MaxKeyLength. It
+// wraps ErrInvalidKey.
+var ErrKeyTooLong = fmt.Errorf("%w: key cannot exceed %d bytes", ErrInvalidKey, MaxKeyLength)
+
 // ErrInvalidBucketName is returned when a bucket name fails validation
 var ErrInvalidBucketName = errors.New("invalid bucket name")
 
@@ -48,6 +57,17 @@ var ErrUploadNotFound = errors.New("upload not found")
 // ErrPartNotFound is returned when a multipart upload part does not exist
 var ErrPartNotFound = errors.New("part not found")
 
+// ErrObjectLocked is returned when deleting or overwriting an object under legal hold
+var ErrObjectLocked = errors.New("object is locked")
+
+// ErrObjectImmutable is returned when deleting or overwriting an object
+// within its bucket's immutability window. It wraps ErrObjectLocked.
+var ErrObjectImmutable = fmt.Errorf("%w: within the immutability window", ErrObjectLocked)
+
+// ErrObjectRetained is returned when deleting or overwriting an object
+// before its object lock retain-until date. It wraps ErrObjectLocked.
+var ErrObjectRetained = fmt.Errorf("%w: within the retention period", ErrObjectLocked)
+
 // ErrInvalidPartOrder is returned when parts are not in ascending order
 var ErrInvalidPartOrder = errors.New("parts must be in ascending order")
 
@@ -60,8 +80,8 @@ func ValidateKey(key string) error {
 	}
 
 	// Reject keys longer than 1024 bytes (S3 limit)
-	if len(key) > 1024 {
-		return fmt.Errorf("%w: key cannot exceed 1024 bytes", ErrInvalidKey)
+	if len(key) > MaxKeyLength {
+		return ErrKeyTooLong
 	}
 
 	// Reject keys containing null bytes
@@ -99,6 +119,29 @@ func ValidateKey(key string) error {
 	return nil
 }
 
+// ValidateNewKey checks a key that an object is about to be written under.
+// On top of ValidateKey, it rejects keys that cannot be written to XML
+// listings and logs as they are. Objects written under such keys before
+// they were rejected can still be read and deleted.
+func ValidateNewKey(key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("%w: key must be valid UTF-8", ErrInvalidKey)
+	}
+	if strings.ContainsFunc(key, isControlRune) {
+		return fmt.Errorf("%w: key cannot contain control characters", ErrInvalidKey)
+	}
+	return nil
+}
+
+// isControlRune reports whether r is an ASCII control character (0x00-0x1F
+// or 0x7F). Other characters, including spaces, are allowed in keys.
+func isControlRune(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
 // ValidateBucketName checks that a bucket name follows S3 naming rules.
 // Rules: 3-63 characters, lowercase letters, numbers, and hyphens only.
 // Must start and end with a letter or number.
@@ -125,17 +168,93 @@ func ValidateBucketName(name string) error {
 	return nil
 }
 
+// reservedBucketNames are names new buckets cannot take, because requests
+// to them are routed elsewhere: /admin/... is the admin API. Buckets
+// created before a name was reserved stay usable.
+var reservedBucketNames = []string{"admin"}
+
+// validateNewBucketName checks the name of a bucket being created
+func validateNewBucketName(name string) error {
+	if err := ValidateBucketName(name); err != nil {
+		return err
+	}
+	if slices.Contains(reservedBucketNames, name) {
+		return fmt.Errorf("%w: bucket name %q is reserved", ErrInvalidBucketName, name)
+	}
+	return nil
+}
+
 // FilesystemStorage implements Storage using the local filesystem
 type FilesystemStorage struct {
 	basePath      string
 	multipartPath string
+	// bucketPaths maps buckets to the alternate base paths they are stored
+	// under instead of basePath
+	bucketPaths map[string]string
+	// immutabilityWindows maps buckets to the period after creation during
+	// which objects cannot be overwritten or deleted
+	immutabilityWindows map[string]time.Duration
+	// trashRetention is how long deleted objects of unversioned buckets are
+	// kept in the trash, 0 to remove them on delete
+	trashRetention time.Duration
+	// syncer flushes object data and metadata to disk before a write is
+	// acknowledged
+	syncer syncer
 	// uploadMu protects multipart upload operations to prevent race conditions
 	// between concurrent uploads, aborts, and cleanup operations
 	uploadMu sync.RWMutex
+	// meta persists object metadata
+	meta metadataStore
+	// usage counts the objects and bytes in each bucket; it wraps meta
+	usage *usageMetadataStore
+	// cache keeps recently used metadata in memory, nil when disabled; usage
+	// wraps it
+	cache *cachedMetadataStore
+	// reindexing guards against concurrent reindexes of the same bucket
+	reindexing reindexGuard
+	// versioning caches the versioning status of each bucket
+	versioning sync.Map
+	// cors caches the CORS rules of each bucket, nil for none
+	cors sync.Map
+	// objectLocks serialize object writes, and reads against them
+	objectLocks [objectLockStripes]sync.RWMutex
+}
+
+// FilesystemOptions contains optional settings for FilesystemStorage
+type FilesystemOptions struct {
+	// MetadataStore selects where object metadata is kept, MetadataStoreJSON
+	// (default) or MetadataStoreKV
+	MetadataStore string
+	// BucketPaths maps bucket names to alternate base paths, such as another
+	// volume for a large bucket. A listed bucket is stored in
+	// {path}/buckets/{bucket} instead of under the main base path.
+	BucketPaths map[string]string
+	// ImmutabilityWindows maps bucket names to a grace period after an
+	// object's creation during which it cannot be overwritten or deleted
+	ImmutabilityWindows map[string]time.Duration
+	// DisableSync skips fsync of written objects, trading crash safety for
+	// write throughput. Acknowledged writes may be lost in a crash or power
+	// failure, so it is meant for ephemeral or test setups.
+	DisableSync bool
+	// MetadataCacheEntries is the number of objects whose metadata is kept
+	// in memory for reads, 0 to read the metadata store every time
+	MetadataCacheEntries int
+	// TrashRetention keeps objects deleted from unversioned buckets in the
+	// bucket's trash for this long, during which RestoreObject can bring
+	// them back. 0 removes objects on delete.
+	TrashRetention time.Duration
+
+	// syncer replaces the syncer chosen by DisableSync, for tests
+	syncer syncer
 }
 
 // NewFilesystemStorage creates a new filesystem-backed storage
 func NewFilesystemStorage(basePath, multipartPath string) (*FilesystemStorage, error) {
+	return NewFilesystemStorageWithOptions(basePath, multipartPath, FilesystemOptions{})
+}
+
+// NewFilesystemStorageWithOptions creates a new filesystem-backed storage with optional settings
+func NewFilesystemStorageWithOptions(basePath, multipartPath string, opts FilesystemOptions) (*FilesystemStorage, error) {
 	// Create base directories if they don't exist
 	bucketsPath := filepath.Join(basePath, "buckets")
 	if _, err := os.Stat(bucketsPath); os.IsNotExist(err) {
@@ -154,12 +273,141 @@ func NewFilesystemStorage(basePath, multipartPath string) (*FilesystemStorage, e
 		return nil, fmt.Errorf("checking multipart directory: %w", err)
 	}
 
+	// Refuse to serve data written under an incompatible layout
+	if err := checkLayoutVersion(basePath); err != nil {
+		return nil, err
+	}
+
+	for bucket, path := range opts.BucketPaths {
+		if err := ValidateBucketName(bucket); err != nil {
+			return nil, fmt.Errorf("bucket path override: %w", err)
+		}
+		if err := checkWritableDir(filepath.Join(path, "buckets")); err != nil {
+			return nil, fmt.Errorf("bucket path override for %s: %w", bucket, err)
+		}
+	}
+
+	s := opts.syncer
+	if s == nil {
+		s = fsyncer{}
+		if opts.DisableSync {
+			s = noSyncer{}
+		}
+	}
+
+	meta, err := newMetadataStore(basePath, opts.BucketPaths, opts.MetadataStore, s)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMetadataStore(basePath, opts.MetadataStore); err != nil {
+		return nil, err
+	}
+	var cache *cachedMetadataStore
+	if opts.MetadataCacheEntries > 0 {
+		cache = newCachedMetadataStore(meta, opts.MetadataCacheEntries)
+		meta = cache
+	}
+	usage := newUsageMetadataStore(meta)
+
 	return &FilesystemStorage{
-		basePath:      basePath,
-		multipartPath: multipartPath,
+		basePath:            basePath,
+		multipartPath:       multipartPath,
+		bucketPaths:         opts.BucketPaths,
+		meta:                usage,
+		usage:               usage,
+		cache:               cache,
+		immutabilityWindows: opts.ImmutabilityWindows,
+		trashRetention:      opts.TrashRetention,
+		syncer:              s,
 	}, nil
 }
 
+// MetadataCacheStats returns the counters of the metadata cache, and false
+// when it is disabled
+func (fs *FilesystemStorage) MetadataCacheStats() (MetadataCacheStats, bool) {
+	if fs.cache == nil {
+		return MetadataCacheStats{}, false
+	}
+	return fs.cache.stats(), true
+}
+
+// checkWritableDir creates dir if needed and verifies that files can be
+// created in it
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// CheckWritable creates and removes a file in each storage path, to detect
+// a full or read-only filesystem
+func (fs *FilesystemStorage) CheckWritable() error {
+	if err := checkWritableDir(fs.basePath); err != nil {
+		return fmt.Errorf("data path: %w", err)
+	}
+	if err := checkWritableDir(fs.multipartPath); err != nil {
+		return fmt.Errorf("multipart path: %w", err)
+	}
+	for _, bucket := range slices.Sorted(maps.Keys(fs.bucketPaths)) {
+		if err := checkWritableDir(filepath.Join(fs.bucketPaths[bucket], "buckets")); err != nil {
+			return fmt.Errorf("path for bucket %s: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+// bucketDir returns the directory of bucket: under the base path from
+// overrides if it has one, otherwise under basePath
+func bucketDir(basePath string, overrides map[string]string, bucket string) string {
+	if path, ok := overrides[bucket]; ok {
+		basePath = path
+	}
+	return filepath.Join(basePath, "buckets", bucket)
+}
+
+// bucketBase returns the base path a bucket is stored under
+func (fs *FilesystemStorage) bucketBase(bucket string) string {
+	if path, ok := fs.bucketPaths[bucket]; ok {
+		return path
+	}
+	return fs.basePath
+}
+
+// bucketPath returns the directory of a bucket
+func (fs *FilesystemStorage) bucketPath(bucket string) string {
+	return bucketDir(fs.basePath, fs.bucketPaths, bucket)
+}
+
+// BucketNames returns the names of all buckets, including those stored under
+// an alternate base path. A directory in the main base path for a bucket that
+// has an override is ignored.
+func (fs *FilesystemStorage) BucketNames() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(fs.basePath, "buckets"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading buckets directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if _, overridden := fs.bucketPaths[entry.Name()]; entry.IsDir() && !overridden {
+			names = append(names, entry.Name())
+		}
+	}
+	for bucket := range fs.bucketPaths {
+		if _, err := os.Stat(filepath.Join(fs.bucketPath(bucket), "objects")); err == nil {
+			names = append(names, bucket)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
 // keyToPath converts an object key to a filesystem path within a bucket
 // Uses a 4-character hash prefix for directory distribution (65,536 directories) and SHA-256 hex directory name
 // Returns an error if the key is invalid or the resulting path would escape the base directory.
@@ -179,11 +427,17 @@ func (fs *FilesystemStorage) keyToPath(bucket, key string) (string, error) {
 	prefix := hex.EncodeToString(keyHash[:2])
 	encodedKey := hex.EncodeToString(keyHash[:])
 
-	result := filepath.Join(fs.basePath, "buckets", bucket, "objects", prefix, encodedKey)
+	// Defense in depth: the directory names must never shadow internal files
+	if isReservedObjectName(prefix) || isReservedObjectName(encodedKey) {
+		return "", fmt.Errorf("%w: key maps to a reserved name", ErrInvalidKey)
+	}
+
+	base := fs.bucketBase(bucket)
+	result := filepath.Join(base, "buckets", bucket, "objects", prefix, encodedKey)
 
-	// Defense in depth: verify the resulting path is within basePath
+	// Defense in depth: verify the resulting path is within the base path
 	// First, resolve the base path (which should always exist)
-	absBase, err := filepath.Abs(fs.basePath)
+	absBase, err := filepath.Abs(base)
 	if err != nil {
 		return "", fmt.Errorf("%w: failed to resolve base path", ErrInvalidKey)
 	}
@@ -213,13 +467,26 @@ func (fs *FilesystemStorage) keyToPath(bucket, key string) (string, error) {
 	return result, nil
 }
 
+// reservedObjectNames are the names of the files and directories kept in
+// an object directory. Keys are hashed into directory names, so a key such
+// as "meta.json" is stored like any other; keyToPath still rejects a
+// directory name that matches one of these, so that a change of layout
+// cannot let a key collide with internal files.
+var reservedObjectNames = []string{"data", "meta.json", versionsDir, versionMetaFile}
+
+// isReservedObjectName reports whether name is used for internal files in
+// an object directory, including the temp files of writes in progress
+func isReservedObjectName(name string) bool {
+	return slices.Contains(reservedObjectNames, name) || strings.HasPrefix(name, "data.tmp")
+}
+
 // CreateBucket creates a new bucket
 func (fs *FilesystemStorage) CreateBucket(name string) error {
-	if err := ValidateBucketName(name); err != nil {
+	if err := validateNewBucketName(name); err != nil {
 		return err
 	}
 
-	bucketPath := filepath.Join(fs.basePath, "buckets", name, "objects")
+	bucketPath := filepath.Join(fs.bucketPath(name), "objects")
 
 	// Check if bucket already exists
 	if _, err := os.Stat(bucketPath); err == nil {
@@ -239,7 +506,7 @@ func (fs *FilesystemStorage) BucketExists(name string) (bool, error) {
 		return false, err
 	}
 
-	bucketPath := filepath.Join(fs.basePath, "buckets", name, "objects")
+	bucketPath := filepath.Join(fs.bucketPath(name), "objects")
 	_, err := os.Stat(bucketPath)
 	if err == nil {
 		return true, nil
@@ -252,11 +519,18 @@ func (fs *FilesystemStorage) BucketExists(name string) (bool, error) {
 
 // DeleteBucket deletes a bucket (must be empty)
 func (fs *FilesystemStorage) DeleteBucket(name string) error {
+	return fs.DeleteBucketWithOptions(name, DeleteBucketOptions{})
+}
+
+// DeleteBucketWithOptions deletes a bucket. With opts.Force a non-empty
+// bucket is removed with all its objects and versions, unless one of them
+// is under legal hold or retention.
+func (fs *FilesystemStorage) DeleteBucketWithOptions(name string, opts DeleteBucketOptions) error {
 	if err := ValidateBucketName(name); err != nil {
 		return err
 	}
 
-	bucketPath := filepath.Join(fs.basePath, "buckets", name)
+	bucketPath := fs.bucketPath(name)
 	objectsPath := filepath.Join(bucketPath, "objects")
 
 	// Check if bucket exists
@@ -270,10 +544,18 @@ func (fs *FilesystemStorage) DeleteBucket(name string) error {
 		return fmt.Errorf("reading bucket contents: %w", err)
 	}
 	if len(entries) > 0 {
-		return ErrBucketNotEmpty
+		if !opts.Force {
+			return ErrBucketNotEmpty
+		}
+		if err := fs.checkNoLockedVersions(name); err != nil {
+			return err
+		}
 	}
 
 	// Remove the bucket directory
+	fs.meta.dropBucket(name)
+	fs.versioning.Delete(name)
+	fs.cors.Delete(name)
 	if err := os.RemoveAll(bucketPath); err != nil {
 		return fmt.Errorf("removing bucket directory: %w", err)
 	}
@@ -281,64 +563,102 @@ func (fs *FilesystemStorage) DeleteBucket(name string) error {
 	return nil
 }
 
-// PutObject stores an object with the given key
-func (fs *FilesystemStorage) PutObject(bucket, key string, contentType string, metadata map[string]string, body io.Reader) (*s3.ObjectMetadata, error) {
-	objPath, err := fs.keyToPath(bucket, key)
-	if err != nil {
-		return nil, err
+// checkNoLockedVersions returns ErrObjectLocked if any version of an object
+// in the bucket, current or noncurrent, must not be deleted yet
+func (fs *FilesystemStorage) checkNoLockedVersions(bucket string) error {
+	now := time.Now()
+	opts := ListObjectVersionsOptions{}
+	for {
+		result, err := fs.ListObjectVersions(bucket, opts)
+		if err != nil {
+			return err
+		}
+		for _, version := range result.Versions {
+			if err := bucketDeleteLock(&version.ObjectMetadata, fs.immutabilityWindows[bucket], now); err != nil {
+				return fmt.Errorf("%w: %s version %s", err, version.Key, version.VersionID)
+			}
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		opts.KeyMarker, opts.VersionIDMarker = result.NextKeyMarker, result.NextVersionIDMarker
 	}
-	dataPath := filepath.Join(objPath, "data")
-	metaPath := filepath.Join(objPath, "meta.json")
+}
 
-	// Create object directory
-	if err := os.MkdirAll(objPath, 0700); err != nil {
-		return nil, fmt.Errorf("creating object directory: %w", err)
+// bucketDeleteLock returns the lockError of an object version, which a
+// forced bucket delete must respect. Delete markers hold no data and never
+// prevent the delete.
+func bucketDeleteLock(meta *s3.ObjectMetadata, window time.Duration, now time.Time) error {
+	if meta.DeleteMarker {
+		return nil
 	}
+	return lockError(meta, window, now)
+}
 
-	// Defense in depth: verify created directory is within base path (catches symlink attacks)
-	realObjPath, err := filepath.EvalSymlinks(objPath)
+// PutObject stores an object with the given key
+func (fs *FilesystemStorage) PutObject(ctx context.Context, bucket, key string, contentType string, metadata map[string]string, body io.Reader) (*s3.ObjectMetadata, error) {
+	return fs.PutObjectWithOptions(ctx, bucket, key, contentType, metadata, PutObjectOptions{}, body)
+}
+
+// PutObjectWithOptions stores an object together with optional attributes
+func (fs *FilesystemStorage) PutObjectWithOptions(ctx context.Context, bucket, key string, contentType string, metadata map[string]string, opts PutObjectOptions, body io.Reader) (*s3.ObjectMetadata, error) {
+	objPath, err := fs.keyToPath(bucket, key)
 	if err != nil {
-		return nil, fmt.Errorf("resolving object path: %w", err)
+		return nil, err
 	}
-	absBase, _ := filepath.Abs(fs.basePath)
-	if realBase, err := filepath.EvalSymlinks(absBase); err == nil {
-		absBase = realBase
+	if err := ValidateNewKey(key); err != nil {
+		return nil, err
 	}
-	if !strings.HasPrefix(realObjPath, absBase+string(filepath.Separator)) {
-		os.RemoveAll(objPath) // Clean up potentially dangerous directory
-		return nil, fmt.Errorf("%w: path escapes base directory via symlink", ErrInvalidKey)
+	dataPath := filepath.Join(objPath, "data")
+
+	if err := fs.checkNotLocked(bucket, key); err != nil {
+		return nil, err
 	}
 
 	// Write data to a temp file first, then rename
-	// Use unique temp file name to avoid conflicts with concurrent writes to same key
-	tmpID := uuid.New().String()
-	tmpPath := dataPath + ".tmp." + tmpID
-	tmpFile, err := os.Create(tmpPath)
+	tmpFile, tmpPath, err := fs.createObjectTemp(bucket, objPath)
 	if err != nil {
-		return nil, fmt.Errorf("creating temp file: %w", err)
+		return nil, err
 	}
 
 	// Calculate MD5 while writing
 	hash := md5.New()
 	writer := io.MultiWriter(tmpFile, hash)
 
-	size, err := io.Copy(writer, body)
+	size, err := copyContext(ctx, writer, body)
 	if err != nil {
 		tmpFile.Close()
 		os.Remove(tmpPath)
 		return nil, fmt.Errorf("writing object data: %w", err)
 	}
 
+	if err := fs.syncer.syncFile(tmpFile); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("syncing object data: %w", err)
+	}
+
 	if err := tmpFile.Close(); err != nil {
 		os.Remove(tmpPath)
 		return nil, fmt.Errorf("closing temp file: %w", err)
 	}
 
+	// Keep the version being replaced when the bucket is versioned
+	versionID, unlock, err := fs.prepareVersionedWrite(bucket, key, objPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	defer unlock()
+
 	// Rename temp file to final location
 	if err := os.Rename(tmpPath, dataPath); err != nil {
 		os.Remove(tmpPath)
 		return nil, fmt.Errorf("renaming temp file: %w", err)
 	}
+	if err := syncObjectDirs(fs.syncer, objPath); err != nil {
+		return nil, fmt.Errorf("syncing object directory: %w", err)
+	}
 
 	// Create metadata
 	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil)))
@@ -350,53 +670,109 @@ func (fs *FilesystemStorage) PutObject(bucket, key string, contentType string, m
 		ContentType:  contentType,
 		ETag:         etag,
 		LastModified: now,
+		Created:      fs.createdTime(bucket, key, objPath, now),
 		UserMetadata: metadata,
+
+		ServerSideEncryption: opts.ServerSideEncryption,
+		Tags:                 opts.Tags,
+		VersionID:            versionID,
 	}
 
-	// Write metadata atomically using temp file and rename
 	// If metadata write fails, roll back the data file to maintain consistency
-	metaTmpPath := metaPath + ".tmp." + tmpID
-	metaFile, err := os.Create(metaTmpPath)
+	if err := fs.meta.put(bucket, objPath, objMeta); err != nil {
+		os.Remove(dataPath)
+		return nil, err
+	}
+
+	return objMeta, nil
+}
+
+// UpdateMetadata replaces the content type, user metadata and tags of an
+// object by writing new metadata, leaving its data and ETag unchanged. This
+// is what copying an object onto itself does in a bucket without versioning.
+func (fs *FilesystemStorage) UpdateMetadata(bucket, key, contentType string, metadata, tags map[string]string) (*s3.ObjectMetadata, error) {
+	return fs.updateObjectAttributes(bucket, key, CopyObjectOptions{
+		ReplaceMetadata: true,
+		ContentType:     contentType,
+		Metadata:        metadata,
+		ReplaceTags:     true,
+		Tags:            tags,
+	})
+}
+
+// updateObjectAttributes replaces the metadata, tags and server-side
+// encryption of an object as selected by opts, leaving its data in place
+func (fs *FilesystemStorage) updateObjectAttributes(bucket, key string, opts CopyObjectOptions) (*s3.ObjectMetadata, error) {
+	objPath, err := fs.keyToPath(bucket, key)
 	if err != nil {
-		os.Remove(dataPath) // Roll back data file
-		return nil, fmt.Errorf("creating metadata file: %w", err)
+		return nil, err
 	}
 
-	if err := json.NewEncoder(metaFile).Encode(objMeta); err != nil {
-		metaFile.Close()
-		os.Remove(metaTmpPath)
-		os.Remove(dataPath) // Roll back data file
-		return nil, fmt.Errorf("writing metadata: %w", err)
+	unlock := fs.lockObject(objPath)
+	defer unlock()
+
+	if err := fs.checkNotLocked(bucket, key); err != nil {
+		return nil, err
+	}
+	meta, err := fs.meta.get(bucket, key, objPath)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := metaFile.Close(); err != nil {
-		os.Remove(metaTmpPath)
-		os.Remove(dataPath) // Roll back data file
-		return nil, fmt.Errorf("closing metadata file: %w", err)
+	if opts.ReplaceMetadata {
+		meta.ContentType, meta.UserMetadata = opts.ContentType, opts.Metadata
+	}
+	if opts.ReplaceTags {
+		meta.Tags = opts.Tags
+	}
+	if opts.ServerSideEncryption != "" {
+		meta.ServerSideEncryption = opts.ServerSideEncryption
+	}
+	// The update counts as an overwrite, which keeps the creation time
+	if meta.Created.IsZero() {
+		meta.Created = meta.LastModified
 	}
+	meta.LastModified = time.Now().UTC()
 
-	if err := os.Rename(metaTmpPath, metaPath); err != nil {
-		os.Remove(metaTmpPath)
-		os.Remove(dataPath) // Roll back data file
-		return nil, fmt.Errorf("renaming metadata file: %w", err)
+	if err := fs.meta.put(bucket, objPath, meta); err != nil {
+		return nil, err
 	}
+	return meta, nil
+}
 
-	return objMeta, nil
+// GetObject retrieves an object by key. Reads fail once ctx is done.
+func (fs *FilesystemStorage) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, *s3.ObjectMetadata, error) {
+	file, meta, err := fs.OpenObject(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &contextReadCloser{ctx: ctx, ReadCloser: file}, meta, nil
 }
 
-// GetObject retrieves an object by key
-func (fs *FilesystemStorage) GetObject(bucket, key string) (io.ReadCloser, *s3.ObjectMetadata, error) {
+// OpenObject opens an object's data file together with its metadata. The
+// returned reader is an *os.File, which lets the HTTP server send it with
+// sendfile.
+func (fs *FilesystemStorage) OpenObject(bucket, key string) (io.ReadSeekCloser, *s3.ObjectMetadata, error) {
 	objPath, err := fs.keyToPath(bucket, key)
 	if err != nil {
 		return nil, nil, err
 	}
 	dataPath := filepath.Join(objPath, "data")
 
+	// The metadata and the open data file must belong to the same write. An
+	// open file keeps its data when a later write replaces it.
+	unlock := fs.rlockObject(objPath)
+
 	// Get metadata first
 	meta, err := fs.HeadObject(bucket, key)
 	if err != nil {
+		unlock()
+		if errors.Is(err, ErrObjectNotFound) {
+			fs.removeOrphanedData(bucket, key, objPath)
+		}
 		return nil, nil, err
 	}
+	defer unlock()
 
 	// Open data file
 	file, err := os.Open(dataPath)
@@ -410,49 +786,172 @@ func (fs *FilesystemStorage) GetObject(bucket, key string) (io.ReadCloser, *s3.O
 	return file, meta, nil
 }
 
+// createObjectTemp creates the object directory and a temp file in it for
+// new data of the object at objPath. The name is unique, so concurrent
+// writes of the same key do not conflict. The object lock is held while
+// doing so, so that a concurrent delete cannot remove the directory before
+// the temp file exists; once it does, deletes leave the directory in place.
+func (fs *FilesystemStorage) createObjectTemp(bucket, objPath string) (*os.File, string, error) {
+	unlock := fs.lockObject(objPath)
+	defer unlock()
+
+	if err := os.MkdirAll(objPath, 0700); err != nil {
+		return nil, "", fmt.Errorf("creating object directory: %w", err)
+	}
+
+	// Defense in depth: verify created directory is within base path (catches symlink attacks)
+	realObjPath, err := filepath.EvalSymlinks(objPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("resolving object path: %w", err)
+	}
+	absBase, _ := filepath.Abs(fs.bucketBase(bucket))
+	if realBase, err := filepath.EvalSymlinks(absBase); err == nil {
+		absBase = realBase
+	}
+	if !strings.HasPrefix(realObjPath, absBase+string(filepath.Separator)) {
+		os.RemoveAll(objPath) // Clean up potentially dangerous directory
+		return nil, "", fmt.Errorf("%w: path escapes base directory via symlink", ErrInvalidKey)
+	}
+
+	tmpPath := filepath.Join(objPath, "data.tmp."+uuid.New().String())
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating temp file: %w", err)
+	}
+	return tmpFile, tmpPath, nil
+}
+
+// removeOrphanedData removes the data file of an object without metadata,
+// left behind by a crash between moving the data into place and writing
+// the metadata. The metadata is checked again under the object lock, so
+// that a write completed meanwhile is kept. Errors are ignored; the data is
+// unreachable either way.
+func (fs *FilesystemStorage) removeOrphanedData(bucket, key, objPath string) {
+	unlock := fs.lockObject(objPath)
+	defer unlock()
+
+	if _, err := fs.meta.get(bucket, key, objPath); !errors.Is(err, ErrObjectNotFound) {
+		return
+	}
+	if err := os.Remove(filepath.Join(objPath, "data")); err != nil {
+		return
+	}
+	// Only succeeds if no versions are kept
+	if os.Remove(objPath) == nil {
+		_ = os.Remove(filepath.Dir(objPath))
+	}
+}
+
 // HeadObject retrieves object metadata without the body
 func (fs *FilesystemStorage) HeadObject(bucket, key string) (*s3.ObjectMetadata, error) {
 	objPath, err := fs.keyToPath(bucket, key)
 	if err != nil {
 		return nil, err
 	}
-	metaPath := filepath.Join(objPath, "meta.json")
+	return fs.meta.get(bucket, key, objPath)
+}
+
+// DeleteObject removes an object by key. In a versioned bucket the object
+// is hidden behind a delete marker instead.
+func (fs *FilesystemStorage) DeleteObject(bucket, key string) error {
+	_, err := fs.DeleteObjectVersion(bucket, key, "")
+	return err
+}
+
+// deleteUnversioned removes an object and its directory, or moves it to the
+// trash when trash is enabled. It holds the object lock so that a
+// concurrent write of key cannot interleave with the removal.
+func (fs *FilesystemStorage) deleteUnversioned(bucket, key, objPath string) error {
+	unlock := fs.lockObject(objPath)
+	defer unlock()
+
+	if err := fs.checkNotLocked(bucket, key); err != nil {
+		return err
+	}
 
-	metaFile, err := os.Open(metaPath)
+	// removeCurrent removes the metadata first so that a partially deleted
+	// object is not listed. Temp files of writes in progress are left alone,
+	// so the object directory is only removed once it is empty.
+	remove := fs.removeCurrent
+	if fs.trashRetention > 0 {
+		remove = fs.moveToTrash
+	}
+	if err := remove(bucket, key, objPath); err != nil {
+		return err
+	}
+	removeEmptyObjectDirs(objPath)
+	return nil
+}
+
+// createdTime returns the creation time for a write of key at now. An
+// overwrite keeps the creation time of the object it replaces.
+func (fs *FilesystemStorage) createdTime(bucket, key, objPath string, now time.Time) time.Time {
+	current, err := fs.meta.get(bucket, key, objPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, ErrObjectNotFound
-		}
-		return nil, fmt.Errorf("opening metadata file: %w", err)
+		return now
 	}
-	defer metaFile.Close()
+	if !current.Created.IsZero() {
+		return current.Created
+	}
+	// Written before creation times were recorded; its last write is the
+	// earliest time known
+	return current.LastModified
+}
 
-	var meta s3.ObjectMetadata
-	if err := json.NewDecoder(metaFile).Decode(&meta); err != nil {
-		return nil, fmt.Errorf("parsing metadata: %w", err)
+// checkNotLocked returns ErrObjectLocked if the object exists and is under
+// legal hold, ErrObjectRetained if it is within its retention period, or
+// ErrObjectImmutable if it is within its bucket's immutability window. A missing object is not locked.
+func (fs *FilesystemStorage) checkNotLocked(bucket, key string) error {
+	meta, err := fs.HeadObject(bucket, key)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		return err
 	}
+	return lockError(meta, fs.immutabilityWindows[bucket], time.Now())
+}
 
-	return &meta, nil
+// lockError returns ErrObjectLocked if an object is under legal hold,
+// ErrObjectRetained if its object lock retention lasts past now, and
+// ErrObjectImmutable if it was created less than window before now. Every overwrite and delete of an
+// object checks it.
+func lockError(meta *s3.ObjectMetadata, window time.Duration, now time.Time) error {
+	if meta.ObjectLockLegalHold == s3.LegalHoldOn {
+		return ErrObjectLocked
+	}
+	if meta.ObjectLockRetainUntilDate != nil && now.Before(*meta.ObjectLockRetainUntilDate) {
+		return ErrObjectRetained
+	}
+	if window > 0 {
+		created := meta.Created
+		if created.IsZero() {
+			created = meta.LastModified
+		}
+		if now.Sub(created) < window {
+			return ErrObjectImmutable
+		}
+	}
+	return nil
 }
 
-// DeleteObject removes an object by key
-func (fs *FilesystemStorage) DeleteObject(bucket, key string) error {
+// PutObjectLegalHold sets the legal hold status of an object
+func (fs *FilesystemStorage) PutObjectLegalHold(bucket, key, status string) error {
 	objPath, err := fs.keyToPath(bucket, key)
 	if err != nil {
 		return err
 	}
 
-	// Remove the entire object directory
-	err = os.RemoveAll(objPath)
+	unlock := fs.lockObject(objPath)
+	defer unlock()
+
+	meta, err := fs.HeadObject(bucket, key)
 	if err != nil {
-		return fmt.Errorf("removing object: %w", err)
+		return err
 	}
+	meta.ObjectLockLegalHold = status
 
-	// Try to remove empty parent directory (ignore errors)
-	parentDir := filepath.Dir(objPath)
-	_ = os.Remove(parentDir) // only succeeds if empty
-
-	return nil
+	return fs.meta.put(bucket, objPath, meta)
 }
 
 // ObjectExists checks if an object exists
@@ -461,20 +960,18 @@ func (fs *FilesystemStorage) ObjectExists(bucket, key string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	metaPath := filepath.Join(objPath, "meta.json")
-
-	_, err = os.Stat(metaPath)
+	_, err = fs.meta.get(bucket, key, objPath)
 	if err == nil {
 		return true, nil
 	}
-	if os.IsNotExist(err) {
+	if errors.Is(err, ErrObjectNotFound) {
 		return false, nil
 	}
 	return false, fmt.Errorf("checking object existence: %w", err)
 }
 
 // GetObjectRange retrieves a range of bytes from an object
-func (fs *FilesystemStorage) GetObjectRange(bucket, key string, start, end int64) (io.ReadCloser, *s3.ObjectMetadata, error) {
+func (fs *FilesystemStorage) GetObjectRange(ctx context.Context, bucket, key string, start, end int64) (io.ReadCloser, *s3.ObjectMetadata, error) {
 	objPath, err := fs.keyToPath(bucket, key)
 	if err != nil {
 		return nil, nil, err
@@ -525,7 +1022,7 @@ func (fs *FilesystemStorage) GetObjectRange(bucket, key string, start, end int64
 		closer: file,
 	}
 
-	return limitedReader, meta, nil
+	return &contextReadCloser{ctx: ctx, ReadCloser: limitedReader}, meta, nil
 }
 
 // limitedReadCloser wraps a limited reader with a closer
@@ -544,137 +1041,248 @@ func (l *limitedReadCloser) Close() error {
 
 // ListObjects lists objects with optional prefix, delimiter, and pagination
 func (fs *FilesystemStorage) ListObjects(bucket string, opts ListObjectsOptions) (*ListObjectsResult, error) {
-	if err := ValidateBucketName(bucket); err != nil {
+	var objects []s3.ObjectMetadata
+	var commonPrefixes []string
+	result, err := fs.ListObjectsFunc(bucket, opts, func(entry ListEntry) error {
+		if entry.Object != nil {
+			objects = append(objects, *entry.Object)
+		} else {
+			commonPrefixes = append(commonPrefixes, entry.CommonPrefix)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
+	result.Objects = objects
+	result.CommonPrefixes = commonPrefixes
+	return result, nil
+}
 
-	if opts.MaxKeys <= 0 {
-		opts.MaxKeys = 1000
-	}
-	if opts.MaxKeys > 1000 {
-		opts.MaxKeys = 1000
-	}
-
-	objectsPath := filepath.Join(fs.basePath, "buckets", bucket, "objects")
-	var allObjects []s3.ObjectMetadata
+// listBatchSize is the number of keys ListObjectsFunc collects under the
+// index read lock before reading their metadata
+const listBatchSize = 100
 
-	// Walk through all hash prefix directories
-	err := filepath.WalkDir(objectsPath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+// listItem is a key or common prefix collected from the index
+type listItem struct {
+	key    string
+	prefix bool
+}
 
-		// Skip non-directories and the root
-		if !d.IsDir() {
-			return nil
-		}
+// ListObjectsFunc lists objects like ListObjects, but calls fn with each
+// object and common prefix in key order instead of collecting them. The
+// returned result has no Objects or CommonPrefixes. An error from fn ends
+// the listing and is returned.
+func (fs *FilesystemStorage) ListObjectsFunc(bucket string, opts ListObjectsOptions, fn func(ListEntry) error) (*ListObjectsResult, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
 
-		// Check if this is an object directory (contains meta.json)
-		metaPath := filepath.Join(path, "meta.json")
-		if _, statErr := os.Stat(metaPath); statErr != nil {
-			return nil
-		}
+	index, err := fs.meta.index(bucket)
+	if err != nil {
+		return nil, err
+	}
 
-		// Read metadata
-		metaFile, err := os.Open(metaPath)
+	return listObjects(index, opts, func(key string) (*s3.ObjectMetadata, error) {
+		objPath, err := fs.keyToPath(bucket, key)
 		if err != nil {
-			return nil
-		}
-		defer metaFile.Close()
-
-		var meta s3.ObjectMetadata
-		if err := json.NewDecoder(metaFile).Decode(&meta); err != nil {
-			return nil
+			return nil, err
 		}
+		return fs.meta.get(bucket, key, objPath)
+	}, fn)
+}
 
-		allObjects = append(allObjects, meta)
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("walking objects directory: %w", err)
+// listObjects lists the keys in index as ListObjectsFunc does, reading the
+// metadata of each listed key with get. Keys for which get returns
+// ErrObjectNotFound are skipped.
+func listObjects(index *keyIndex, opts ListObjectsOptions, get func(key string) (*s3.ObjectMetadata, error), fn func(ListEntry) error) (*ListObjectsResult, error) {
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = 1000
+	}
+	if opts.MaxKeys > 1000 {
+		opts.MaxKeys = 1000
 	}
 
-	// Sort objects by key
-	sortObjectsByKey(allObjects)
-
-	// Apply filtering and pagination
 	result := &ListObjectsResult{}
-	commonPrefixSet := make(map[string]bool)
 	startKey := opts.StartAfter
 	if opts.ContinuationToken != "" {
-		// Decode continuation token (it's base64 encoded key)
+		// Decode continuation token, the base64 encoded last key or common
+		// prefix of the previous page
 		decoded, err := base64.URLEncoding.DecodeString(opts.ContinuationToken)
 		if err == nil {
 			startKey = string(decoded)
 		}
 	}
 
-	count := 0
-	for _, obj := range allObjects {
-		// Skip objects before startKey
-		if startKey != "" && obj.Key <= startKey {
-			continue
-		}
+	// Seek to the first key after startKey that can match the prefix
+	from := opts.Prefix
+	if startKey != "" && startKey+"\x00" > from {
+		from = startKey + "\x00"
+	}
+
+	listed := 0
+	lastKey := ""
+	batch := make([]listItem, 0, listBatchSize)
+	for done := false; !done; {
+		// Collect a batch of keys under the index read lock and read their
+		// metadata afterwards. The scan is done unless the batch fills up.
+		done = true
+		batch = batch[:0]
+		index.scan(from, func(key string) (string, bool) {
+			// Keys are sorted, so the first key without the prefix ends the listing
+			if !strings.HasPrefix(key, opts.Prefix) {
+				return "", true
+			}
 
-		// Apply prefix filter
-		if opts.Prefix != "" && !strings.HasPrefix(obj.Key, opts.Prefix) {
-			continue
-		}
+			// Resume from this key in the next batch
+			if len(batch) == listBatchSize {
+				from = key
+				done = false
+				return "", true
+			}
+
+			item := listItem{key: key}
+
+			// Handle delimiter (for common prefixes / virtual directories)
+			if opts.Delimiter != "" {
+				// Find delimiter after prefix
+				afterPrefix := key[len(opts.Prefix):]
+				delimIdx := strings.Index(afterPrefix, opts.Delimiter)
+				if delimIdx >= 0 {
+					item = listItem{key: opts.Prefix + afterPrefix[:delimIdx+len(opts.Delimiter)], prefix: true}
+					// A common prefix sorting at or before startKey was
+					// returned by an earlier page, or precedes StartAfter;
+					// skip the rest of the keys under it
+					if item.key <= startKey {
+						next := prefixSuccessor(item.key)
+						return next, next == ""
+					}
+				}
+			}
+
+			// Objects and common prefixes both count towards MaxKeys
+			if listed >= opts.MaxKeys {
+				result.IsTruncated = true
+				return "", true
+			}
+
+			listed++
+			lastKey = item.key
+			batch = append(batch, item)
+			if item.prefix {
+				// Skip the rest of the keys under the common prefix
+				next := prefixSuccessor(item.key)
+				return next, next == ""
+			}
+			return "", false
+		})
 
-		// Handle delimiter (for common prefixes / virtual directories)
-		if opts.Delimiter != "" {
-			// Find delimiter after prefix
-			afterPrefix := obj.Key[len(opts.Prefix):]
-			delimIdx := strings.Index(afterPrefix, opts.Delimiter)
-			if delimIdx >= 0 {
-				// This is a common prefix
-				commonPrefix := opts.Prefix + afterPrefix[:delimIdx+len(opts.Delimiter)]
-				if !commonPrefixSet[commonPrefix] {
-					commonPrefixSet[commonPrefix] = true
-					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix)
+		for _, item := range batch {
+			if item.prefix {
+				if err := fn(ListEntry{CommonPrefix: item.key}); err != nil {
+					return nil, err
 				}
 				continue
 			}
-		}
 
-		// Check if we've reached the limit
-		if count >= opts.MaxKeys {
-			result.IsTruncated = true
-			if len(result.Objects) > 0 {
-				result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(result.Objects[len(result.Objects)-1].Key))
+			meta, err := get(item.key)
+			if err != nil {
+				// Deleted since it was listed, or indexed by a write that did not finish
+				if errors.Is(err, ErrObjectNotFound) {
+					continue
+				}
+				return nil, err
+			}
+			if err := fn(ListEntry{Object: meta}); err != nil {
+				return nil, err
 			}
-			break
 		}
+	}
 
-		result.Objects = append(result.Objects, obj)
-		count++
+	if result.IsTruncated && lastKey != "" {
+		result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(lastKey))
 	}
 
 	return result, nil
 }
 
+// CountObjects returns the number of objects in a bucket. The count comes
+// from the listing index and does not read any metadata.
+func (fs *FilesystemStorage) CountObjects(bucket string) (int, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return 0, err
+	}
+
+	index, err := fs.meta.index(bucket)
+	if err != nil {
+		return 0, err
+	}
+	return index.len(), nil
+}
+
+// BucketUsage returns the number of objects in a bucket and their total
+// size. The usage is counted from the bucket's metadata on first use and
+// kept up to date by later writes and deletes.
+func (fs *FilesystemStorage) BucketUsage(bucket string) (BucketUsage, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return BucketUsage{}, err
+	}
+	exists, err := fs.BucketExists(bucket)
+	if err != nil {
+		return BucketUsage{}, err
+	}
+	if !exists {
+		return BucketUsage{}, ErrBucketNotFound
+	}
+	return fs.usage.usage(bucket)
+}
+
 // CopyObject copies an object from source key to destination key
-func (fs *FilesystemStorage) CopyObject(srcBucket, srcKey, dstBucket, dstKey string) (*s3.ObjectMetadata, error) {
+func (fs *FilesystemStorage) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) (*s3.ObjectMetadata, error) {
+	return fs.CopyObjectWithOptions(ctx, srcBucket, srcKey, dstBucket, dstKey, CopyObjectOptions{})
+}
+
+// CopyObjectWithOptions copies an object, keeping the source's metadata,
+// tags and server-side encryption unless opts replaces them. Copying an
+// object onto itself in a bucket without versioning only updates these
+// attributes; the data is not rewritten.
+func (fs *FilesystemStorage) CopyObjectWithOptions(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, opts CopyObjectOptions) (*s3.ObjectMetadata, error) {
+	if srcBucket == dstBucket && srcKey == dstKey {
+		status, err := fs.GetBucketVersioning(dstBucket)
+		if err != nil {
+			return nil, err
+		}
+		// A versioned bucket keeps the previous version, which needs its own data
+		if status == "" {
+			return fs.updateObjectAttributes(dstBucket, dstKey, opts)
+		}
+	}
+
 	// Get source object
-	srcReader, srcMeta, err := fs.GetObject(srcBucket, srcKey)
+	srcReader, srcMeta, err := fs.OpenObject(srcBucket, srcKey)
 	if err != nil {
 		return nil, err
 	}
 	defer srcReader.Close()
 
+	contentType, metadata := srcMeta.ContentType, srcMeta.UserMetadata
+	if opts.ReplaceMetadata {
+		contentType, metadata = opts.ContentType, opts.Metadata
+	}
+	tags := srcMeta.Tags
+	if opts.ReplaceTags {
+		tags = opts.Tags
+	}
+	sse := srcMeta.ServerSideEncryption
+	if opts.ServerSideEncryption != "" {
+		sse = opts.ServerSideEncryption
+	}
+
 	// Copy to destination
-	dstMeta, err := fs.PutObject(dstBucket, dstKey, srcMeta.ContentType, srcMeta.UserMetadata, srcReader)
+	dstMeta, err := fs.PutObjectWithOptions(ctx, dstBucket, dstKey, contentType, metadata, PutObjectOptions{ServerSideEncryption: sse, Tags: tags}, srcReader)
 	if err != nil {
 		return nil, fmt.Errorf("copying object: %w", err)
 	}
 
 	return dstMeta, nil
 }
-
-// Helper functions
-
-func sortObjectsByKey(objects []s3.ObjectMetadata) {
-	sort.Slice(objects, func(i, j int) bool {
-		return objects[i].Key < objects[j].Key
-	})
-}
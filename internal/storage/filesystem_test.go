@@ -2,13 +2,24 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/espen/stupid-simple-s3/internal/s3"
 )
 
@@ -74,6 +85,104 @@ func TestNewFilesystemStorage(t *testing.T) {
 	}
 }
 
+func TestLayoutVersion(t *testing.T) {
+	newPaths := func(t *testing.T) (string, string) {
+		t.Helper()
+		tmpDir := t.TempDir()
+		return filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart")
+	}
+
+	t.Run("fresh directory is stamped with current version", func(t *testing.T) {
+		basePath, multipartPath := newPaths(t)
+		if _, err := NewFilesystemStorage(basePath, multipartPath); err != nil {
+			t.Fatalf("NewFilesystemStorage failed: %v", err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(basePath, layoutVersionFile))
+		if err != nil {
+			t.Fatalf("reading layout version: %v", err)
+		}
+		if got := strings.TrimSpace(string(data)); got != "2" {
+			t.Errorf("layout version = %q, want %q", got, "2")
+		}
+	})
+
+	t.Run("missing marker with current layout objects is upgraded", func(t *testing.T) {
+		basePath, multipartPath := newPaths(t)
+		storage, err := NewFilesystemStorage(basePath, multipartPath)
+		if err != nil {
+			t.Fatalf("NewFilesystemStorage failed: %v", err)
+		}
+		if err := storage.CreateBucket(testBucket); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if _, err := storage.PutObject(context.Background(), testBucket, "key", "text/plain", nil, strings.NewReader("data")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if err := os.Remove(filepath.Join(basePath, layoutVersionFile)); err != nil {
+			t.Fatalf("removing layout version: %v", err)
+		}
+
+		if _, err := NewFilesystemStorage(basePath, multipartPath); err != nil {
+			t.Fatalf("NewFilesystemStorage failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(basePath, layoutVersionFile)); err != nil {
+			t.Errorf("layout version was not written: %v", err)
+		}
+	})
+
+	t.Run("missing marker with old layout objects is refused", func(t *testing.T) {
+		basePath, multipartPath := newPaths(t)
+		oldObjPath := filepath.Join(basePath, "buckets", testBucket, "objects", "abcd", "a2V5")
+		if err := os.MkdirAll(oldObjPath, 0700); err != nil {
+			t.Fatalf("creating old object directory: %v", err)
+		}
+
+		_, err := NewFilesystemStorage(basePath, multipartPath)
+		if !errors.Is(err, ErrUnsupportedLayout) {
+			t.Fatalf("error = %v, want %v", err, ErrUnsupportedLayout)
+		}
+		if !strings.Contains(err.Error(), "migrate-sha256") {
+			t.Errorf("error %q should point to the migrate tool", err)
+		}
+		if _, err := os.Stat(filepath.Join(basePath, layoutVersionFile)); !os.IsNotExist(err) {
+			t.Error("layout version should not be written for an old layout")
+		}
+	})
+
+	t.Run("older version is refused", func(t *testing.T) {
+		basePath, multipartPath := newPaths(t)
+		if err := os.MkdirAll(basePath, 0700); err != nil {
+			t.Fatalf("creating data directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(basePath, layoutVersionFile), []byte("1\n"), 0600); err != nil {
+			t.Fatalf("writing layout version: %v", err)
+		}
+
+		_, err := NewFilesystemStorage(basePath, multipartPath)
+		if !errors.Is(err, ErrUnsupportedLayout) {
+			t.Fatalf("error = %v, want %v", err, ErrUnsupportedLayout)
+		}
+		if !strings.Contains(err.Error(), "migrate-sha256") {
+			t.Errorf("error %q should point to the migrate tool", err)
+		}
+	})
+
+	t.Run("newer version is refused", func(t *testing.T) {
+		basePath, multipartPath := newPaths(t)
+		if err := os.MkdirAll(basePath, 0700); err != nil {
+			t.Fatalf("creating data directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(basePath, layoutVersionFile), []byte("99\n"), 0600); err != nil {
+			t.Fatalf("writing layout version: %v", err)
+		}
+
+		if _, err := NewFilesystemStorage(basePath, multipartPath); !errors.Is(err, ErrUnsupportedLayout) {
+			t.Fatalf("error = %v, want %v", err, ErrUnsupportedLayout)
+		}
+	})
+}
+
 func TestValidateBucketName(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -157,6 +266,14 @@ func TestCreateBucket(t *testing.T) {
 			t.Error("expected error for invalid bucket name")
 		}
 	})
+
+	t.Run("create bucket with reserved name", func(t *testing.T) {
+		forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+			if err := storage.CreateBucket("admin"); !errors.Is(err, ErrInvalidBucketName) {
+				t.Errorf("CreateBucket(admin) error = %v, want ErrInvalidBucketName", err)
+			}
+		})
+	})
 }
 
 func TestBucketExists(t *testing.T) {
@@ -250,7 +367,7 @@ func TestDeleteBucket(t *testing.T) {
 		}
 
 		// Add an object
-		_, err := storage.PutObject("nonempty-bucket", "test-key", "text/plain", nil, bytes.NewReader([]byte("content")))
+		_, err := storage.PutObject(context.Background(), "nonempty-bucket", "test-key", "text/plain", nil, bytes.NewReader([]byte("content")))
 		if err != nil {
 			t.Fatalf("PutObject failed: %v", err)
 		}
@@ -265,6 +382,107 @@ func TestDeleteBucket(t *testing.T) {
 		}
 	})
 
+	t.Run("force delete non-empty bucket", func(t *testing.T) {
+		if err := storage.CreateBucket("force-bucket"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		for _, key := range []string{"a", "dir/b", "dir/c"} {
+			if _, err := storage.PutObject(context.Background(), "force-bucket", key, "text/plain", nil, strings.NewReader("content")); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+		}
+
+		if err := storage.DeleteBucketWithOptions("force-bucket", DeleteBucketOptions{Force: true}); err != nil {
+			t.Fatalf("DeleteBucketWithOptions failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(basePath, "buckets", "force-bucket")); !os.IsNotExist(err) {
+			t.Errorf("bucket directory still exists: %v", err)
+		}
+
+		// A bucket created again with the same name starts empty
+		if err := storage.CreateBucket("force-bucket"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if result, err := storage.ListObjects("force-bucket", ListObjectsOptions{}); err != nil || len(result.Objects) != 0 {
+			t.Errorf("recreated bucket lists %v, %v", result, err)
+		}
+	})
+
+	t.Run("force delete keeps bucket with legal hold", func(t *testing.T) {
+		if err := storage.CreateBucket("held-bucket"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if _, err := storage.PutObject(context.Background(), "held-bucket", "held", "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if err := storage.PutObjectLegalHold("held-bucket", "held", s3.LegalHoldOn); err != nil {
+			t.Fatalf("PutObjectLegalHold failed: %v", err)
+		}
+
+		err := storage.DeleteBucketWithOptions("held-bucket", DeleteBucketOptions{Force: true})
+		if !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("err = %v, want ErrObjectLocked", err)
+		}
+		if exists, _ := storage.ObjectExists("held-bucket", "held"); !exists {
+			t.Error("object under legal hold was deleted")
+		}
+	})
+
+	t.Run("force delete keeps bucket with held noncurrent version", func(t *testing.T) {
+		if err := storage.CreateBucket("versioned-held"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		if err := storage.PutBucketVersioning("versioned-held", VersioningEnabled); err != nil {
+			t.Fatalf("PutBucketVersioning failed: %v", err)
+		}
+		if _, err := storage.PutObject(context.Background(), "versioned-held", "held", "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if err := storage.PutObjectLegalHold("versioned-held", "held", s3.LegalHoldOn); err != nil {
+			t.Fatalf("PutObjectLegalHold failed: %v", err)
+		}
+		// The delete marker makes the held version noncurrent
+		if err := storage.DeleteObject("versioned-held", "held"); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+
+		err := storage.DeleteBucketWithOptions("versioned-held", DeleteBucketOptions{Force: true})
+		if !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("err = %v, want ErrObjectLocked", err)
+		}
+	})
+
+	t.Run("force delete keeps bucket with retained object", func(t *testing.T) {
+		if err := storage.CreateBucket("retained-bucket"); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+		until := time.Now().Add(time.Hour)
+		meta := &s3.ObjectMetadata{
+			Key:                       "retained",
+			Size:                      4,
+			ETag:                      `"8d777f385d3dfec8815d20f7496026dc"`,
+			LastModified:              time.Now().UTC(),
+			ObjectLockMode:            s3.ObjectLockModeCompliance,
+			ObjectLockRetainUntilDate: &until,
+		}
+		if err := storage.ImportObject(context.Background(), "retained-bucket", meta, strings.NewReader("data")); err != nil {
+			t.Fatalf("ImportObject failed: %v", err)
+		}
+
+		err := storage.DeleteBucketWithOptions("retained-bucket", DeleteBucketOptions{Force: true})
+		if !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("err = %v, want ErrObjectLocked", err)
+		}
+
+		// The object itself cannot be overwritten or deleted either
+		if _, err := storage.PutObject(context.Background(), "retained-bucket", "retained", "text/plain", nil, strings.NewReader("new")); !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("PutObject err = %v, want ErrObjectLocked", err)
+		}
+		if err := storage.DeleteObject("retained-bucket", "retained"); !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("DeleteObject err = %v, want ErrObjectLocked", err)
+		}
+	})
+
 	t.Run("delete non-existent bucket", func(t *testing.T) {
 		err := storage.DeleteBucket("nonexistent-bucket")
 		if err == nil {
@@ -284,521 +502,701 @@ func TestDeleteBucket(t *testing.T) {
 }
 
 func TestPutAndGetObject(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	key := "test/path/to/file.txt"
-	content := []byte("Hello, World!")
-	contentType := "text/plain"
-	metadata := map[string]string{"author": "test"}
-
-	// Put object
-	meta, err := storage.PutObject(testBucket, key, contentType, metadata, bytes.NewReader(content))
-	if err != nil {
-		t.Fatalf("PutObject failed: %v", err)
-	}
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "test/path/to/file.txt"
+		content := []byte("Hello, World!")
+		contentType := "text/plain"
+		metadata := map[string]string{"author": "test"}
+
+		// Put object
+		meta, err := storage.PutObject(context.Background(), testBucket, key, contentType, metadata, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
 
-	if meta.Key != key {
-		t.Errorf("Key = %q, want %q", meta.Key, key)
-	}
-	if meta.Size != int64(len(content)) {
-		t.Errorf("Size = %d, want %d", meta.Size, len(content))
-	}
-	if meta.ContentType != contentType {
-		t.Errorf("ContentType = %q, want %q", meta.ContentType, contentType)
-	}
-	if !strings.HasPrefix(meta.ETag, "\"") || !strings.HasSuffix(meta.ETag, "\"") {
-		t.Errorf("ETag should be quoted: %q", meta.ETag)
-	}
+		if meta.Key != key {
+			t.Errorf("Key = %q, want %q", meta.Key, key)
+		}
+		if meta.Size != int64(len(content)) {
+			t.Errorf("Size = %d, want %d", meta.Size, len(content))
+		}
+		if meta.ContentType != contentType {
+			t.Errorf("ContentType = %q, want %q", meta.ContentType, contentType)
+		}
+		if !strings.HasPrefix(meta.ETag, "\"") || !strings.HasSuffix(meta.ETag, "\"") {
+			t.Errorf("ETag should be quoted: %q", meta.ETag)
+		}
 
-	// Get object
-	reader, getMeta, err := storage.GetObject(testBucket, key)
-	if err != nil {
-		t.Fatalf("GetObject failed: %v", err)
-	}
-	defer reader.Close()
+		// Get object
+		reader, getMeta, err := storage.GetObject(context.Background(), testBucket, key)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer reader.Close()
 
-	gotContent, err := io.ReadAll(reader)
-	if err != nil {
-		t.Fatalf("reading object content failed: %v", err)
-	}
+		gotContent, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("reading object content failed: %v", err)
+		}
 
-	if !bytes.Equal(gotContent, content) {
-		t.Errorf("content = %q, want %q", gotContent, content)
-	}
+		if !bytes.Equal(gotContent, content) {
+			t.Errorf("content = %q, want %q", gotContent, content)
+		}
 
-	if getMeta.ETag != meta.ETag {
-		t.Errorf("ETag mismatch: get=%q, put=%q", getMeta.ETag, meta.ETag)
-	}
+		if getMeta.ETag != meta.ETag {
+			t.Errorf("ETag mismatch: get=%q, put=%q", getMeta.ETag, meta.ETag)
+		}
 
-	if getMeta.UserMetadata["author"] != "test" {
-		t.Errorf("UserMetadata[author] = %q, want %q", getMeta.UserMetadata["author"], "test")
-	}
+		if getMeta.UserMetadata["author"] != "test" {
+			t.Errorf("UserMetadata[author] = %q, want %q", getMeta.UserMetadata["author"], "test")
+		}
+	})
 }
 
 func TestHeadObject(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	key := "head-test.txt"
-	content := []byte("Test content for head")
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "head-test.txt"
+		content := []byte("Test content for head")
 
-	// Put object first
-	putMeta, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(content))
-	if err != nil {
-		t.Fatalf("PutObject failed: %v", err)
-	}
+		// Put object first
+		putMeta, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
 
-	// Head object
-	headMeta, err := storage.HeadObject(testBucket, key)
-	if err != nil {
-		t.Fatalf("HeadObject failed: %v", err)
-	}
+		// Head object
+		headMeta, err := storage.HeadObject(testBucket, key)
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
 
-	if headMeta.Key != key {
-		t.Errorf("Key = %q, want %q", headMeta.Key, key)
-	}
-	if headMeta.Size != putMeta.Size {
-		t.Errorf("Size = %d, want %d", headMeta.Size, putMeta.Size)
-	}
-	if headMeta.ETag != putMeta.ETag {
-		t.Errorf("ETag = %q, want %q", headMeta.ETag, putMeta.ETag)
-	}
+		if headMeta.Key != key {
+			t.Errorf("Key = %q, want %q", headMeta.Key, key)
+		}
+		if headMeta.Size != putMeta.Size {
+			t.Errorf("Size = %d, want %d", headMeta.Size, putMeta.Size)
+		}
+		if headMeta.ETag != putMeta.ETag {
+			t.Errorf("ETag = %q, want %q", headMeta.ETag, putMeta.ETag)
+		}
+	})
 }
 
 func TestHeadObjectNotFound(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	_, err := storage.HeadObject(testBucket, "nonexistent-key")
-	if err == nil {
-		t.Error("expected error for nonexistent object")
-	}
-	if !strings.Contains(err.Error(), "not found") {
-		t.Errorf("expected 'not found' error, got: %v", err)
-	}
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		_, err := storage.HeadObject(testBucket, "nonexistent-key")
+		if err == nil {
+			t.Error("expected error for nonexistent object")
+		}
+		if !strings.Contains(err.Error(), "not found") {
+			t.Errorf("expected 'not found' error, got: %v", err)
+		}
+	})
 }
 
 func TestDeleteObject(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "delete-test.txt"
+		content := []byte("To be deleted")
 
-	key := "delete-test.txt"
-	content := []byte("To be deleted")
-
-	// Put object
-	_, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(content))
-	if err != nil {
-		t.Fatalf("PutObject failed: %v", err)
-	}
+		// Put object
+		_, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
 
-	// Verify it exists
-	exists, err := storage.ObjectExists(testBucket, key)
-	if err != nil {
-		t.Fatalf("ObjectExists failed: %v", err)
-	}
-	if !exists {
-		t.Error("object should exist after put")
-	}
+		// Verify it exists
+		exists, err := storage.ObjectExists(testBucket, key)
+		if err != nil {
+			t.Fatalf("ObjectExists failed: %v", err)
+		}
+		if !exists {
+			t.Error("object should exist after put")
+		}
 
-	// Delete object
-	err = storage.DeleteObject(testBucket, key)
-	if err != nil {
-		t.Fatalf("DeleteObject failed: %v", err)
-	}
+		// Delete object
+		err = storage.DeleteObject(testBucket, key)
+		if err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
 
-	// Verify it's gone
-	exists, err = storage.ObjectExists(testBucket, key)
-	if err != nil {
-		t.Fatalf("ObjectExists failed: %v", err)
-	}
-	if exists {
-		t.Error("object should not exist after delete")
-	}
+		// Verify it's gone
+		exists, err = storage.ObjectExists(testBucket, key)
+		if err != nil {
+			t.Fatalf("ObjectExists failed: %v", err)
+		}
+		if exists {
+			t.Error("object should not exist after delete")
+		}
+	})
 }
 
 func TestDeleteNonexistentObject(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	// Deleting nonexistent object should not error (S3 behavior)
-	err := storage.DeleteObject(testBucket, "nonexistent-key")
-	if err != nil {
-		t.Errorf("DeleteObject on nonexistent key should not error: %v", err)
-	}
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		// Deleting nonexistent object should not error (S3 behavior)
+		err := storage.DeleteObject(testBucket, "nonexistent-key")
+		if err != nil {
+			t.Errorf("DeleteObject on nonexistent key should not error: %v", err)
+		}
+	})
 }
 
 func TestObjectExists(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "exists-test.txt"
 
-	key := "exists-test.txt"
-
-	// Should not exist initially
-	exists, err := storage.ObjectExists(testBucket, key)
-	if err != nil {
-		t.Fatalf("ObjectExists failed: %v", err)
-	}
-	if exists {
-		t.Error("object should not exist initially")
-	}
+		// Should not exist initially
+		exists, err := storage.ObjectExists(testBucket, key)
+		if err != nil {
+			t.Fatalf("ObjectExists failed: %v", err)
+		}
+		if exists {
+			t.Error("object should not exist initially")
+		}
 
-	// Put object
-	_, err = storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader([]byte("test")))
-	if err != nil {
-		t.Fatalf("PutObject failed: %v", err)
-	}
+		// Put object
+		_, err = storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("test")))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
 
-	// Should exist now
-	exists, err = storage.ObjectExists(testBucket, key)
-	if err != nil {
-		t.Fatalf("ObjectExists failed: %v", err)
-	}
-	if !exists {
-		t.Error("object should exist after put")
-	}
+		// Should exist now
+		exists, err = storage.ObjectExists(testBucket, key)
+		if err != nil {
+			t.Fatalf("ObjectExists failed: %v", err)
+		}
+		if !exists {
+			t.Error("object should exist after put")
+		}
+	})
 }
 
 func TestPutObjectOverwrite(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "overwrite-test.txt"
 
-	key := "overwrite-test.txt"
-
-	// Put initial content
-	_, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader([]byte("initial")))
-	if err != nil {
-		t.Fatalf("PutObject failed: %v", err)
-	}
+		// Put initial content
+		_, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("initial")))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
 
-	// Overwrite with new content
-	newContent := []byte("overwritten content")
-	meta, err := storage.PutObject(testBucket, key, "text/html", nil, bytes.NewReader(newContent))
-	if err != nil {
-		t.Fatalf("PutObject (overwrite) failed: %v", err)
-	}
+		// Overwrite with new content
+		newContent := []byte("overwritten content")
+		meta, err := storage.PutObject(context.Background(), testBucket, key, "text/html", nil, bytes.NewReader(newContent))
+		if err != nil {
+			t.Fatalf("PutObject (overwrite) failed: %v", err)
+		}
 
-	if meta.ContentType != "text/html" {
-		t.Errorf("ContentType = %q, want %q", meta.ContentType, "text/html")
-	}
+		if meta.ContentType != "text/html" {
+			t.Errorf("ContentType = %q, want %q", meta.ContentType, "text/html")
+		}
 
-	// Verify new content
-	reader, _, err := storage.GetObject(testBucket, key)
-	if err != nil {
-		t.Fatalf("GetObject failed: %v", err)
-	}
-	defer reader.Close()
+		// Verify new content
+		reader, _, err := storage.GetObject(context.Background(), testBucket, key)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer reader.Close()
 
-	gotContent, _ := io.ReadAll(reader)
-	if !bytes.Equal(gotContent, newContent) {
-		t.Errorf("content = %q, want %q", gotContent, newContent)
-	}
+		gotContent, _ := io.ReadAll(reader)
+		if !bytes.Equal(gotContent, newContent) {
+			t.Errorf("content = %q, want %q", gotContent, newContent)
+		}
+	})
 }
 
-func TestKeyWithSpecialCharacters(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
+func TestObjectCreatedTime(t *testing.T) {
+	jsonStorage, cleanup := setupTestStorage(t)
 	defer cleanup()
+	kvStorage, _ := setupKVStorage(t)
 
-	keys := []string{
-		"path/with spaces/file.txt",
-		"unicode/文件/test.txt",
-		"symbols/file@#$.txt",
-		"deeply/nested/path/to/some/file.txt",
-	}
-
-	for _, key := range keys {
-		t.Run(key, func(t *testing.T) {
-			content := []byte("content for " + key)
-
-			_, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(content))
+	for name, storage := range map[string]*FilesystemStorage{"json": jsonStorage, "kv": kvStorage} {
+		t.Run(name, func(t *testing.T) {
+			first, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("v1"))
 			if err != nil {
-				t.Fatalf("PutObject failed for key %q: %v", key, err)
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			if !first.Created.Equal(first.LastModified) {
+				t.Errorf("Created = %v, want LastModified %v on first write", first.Created, first.LastModified)
 			}
 
-			reader, _, err := storage.GetObject(testBucket, key)
+			time.Sleep(10 * time.Millisecond)
+			second, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("v2"))
 			if err != nil {
-				t.Fatalf("GetObject failed for key %q: %v", key, err)
+				t.Fatalf("PutObject (overwrite) failed: %v", err)
 			}
-
-			gotContent, _ := io.ReadAll(reader)
-			reader.Close()
-
-			if !bytes.Equal(gotContent, content) {
-				t.Errorf("content mismatch for key %q", key)
+			if !second.Created.Equal(first.Created) {
+				t.Errorf("Created after overwrite = %v, want %v", second.Created, first.Created)
 			}
-		})
+			if !second.LastModified.After(first.LastModified) {
+				t.Errorf("LastModified after overwrite = %v, want after %v", second.LastModified, first.LastModified)
+			}
+
+			// Overwriting by copy and by multipart upload keeps it too
+			copied, err := storage.CopyObject(context.Background(), testBucket, "doc", testBucket, "doc")
+			if err != nil {
+				t.Fatalf("CopyObject failed: %v", err)
+			}
+			uploadID, err := storage.CreateMultipartUpload(testBucket, "doc", "text/plain", nil)
+			if err != nil {
+				t.Fatalf("CreateMultipartUpload failed: %v", err)
+			}
+			part, err := storage.UploadPart(context.Background(), uploadID, 1, strings.NewReader("v3"))
+			if err != nil {
+				t.Fatalf("UploadPart failed: %v", err)
+			}
+			completed, err := storage.CompleteMultipartUpload(context.Background(), uploadID, []s3.CompletedPartInput{{PartNumber: 1, ETag: part.ETag}})
+			if err != nil {
+				t.Fatalf("CompleteMultipartUpload failed: %v", err)
+			}
+			for _, meta := range []*s3.ObjectMetadata{copied, completed} {
+				if !meta.Created.Equal(first.Created) {
+					t.Errorf("Created = %v, want %v", meta.Created, first.Created)
+				}
+			}
+
+			stored, err := storage.HeadObject(testBucket, "doc")
+			if err != nil {
+				t.Fatalf("HeadObject failed: %v", err)
+			}
+			if !stored.Created.Equal(first.Created) {
+				t.Errorf("stored Created = %v, want %v", stored.Created, first.Created)
+			}
+
+			// A new object after a delete gets a new creation time
+			if err := storage.DeleteObject(testBucket, "doc"); err != nil {
+				t.Fatalf("DeleteObject failed: %v", err)
+			}
+			recreated, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("v4"))
+			if err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			if !recreated.Created.After(first.Created) {
+				t.Errorf("Created after delete = %v, want after %v", recreated.Created, first.Created)
+			}
+		})
 	}
 }
 
-func TestEmptyObject(t *testing.T) {
+func TestKeyWithSpecialCharacters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		keys := []string{
+			"path/with spaces/file.txt",
+			"unicode/文件/test.txt",
+			"symbols/file@#$.txt",
+			"deeply/nested/path/to/some/file.txt",
+		}
+
+		for _, key := range keys {
+			t.Run(key, func(t *testing.T) {
+				content := []byte("content for " + key)
+
+				_, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content))
+				if err != nil {
+					t.Fatalf("PutObject failed for key %q: %v", key, err)
+				}
+
+				reader, _, err := storage.GetObject(context.Background(), testBucket, key)
+				if err != nil {
+					t.Fatalf("GetObject failed for key %q: %v", key, err)
+				}
+
+				gotContent, _ := io.ReadAll(reader)
+				reader.Close()
+
+				if !bytes.Equal(gotContent, content) {
+					t.Errorf("content mismatch for key %q", key)
+				}
+			})
+		}
+	})
+}
+
+// TestLongKeys checks that keys longer than a filename may be stored,
+// listed and deleted, and that they are stored at the SHA-256 path the
+// migrate-sha256 tool produces
+func TestLongKeys(t *testing.T) {
 	storage, cleanup := setupTestStorage(t)
 	defer cleanup()
 
-	key := "empty.txt"
-	content := []byte{}
-
-	meta, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(content))
-	if err != nil {
-		t.Fatalf("PutObject failed: %v", err)
+	keys := []string{
+		"long/" + strings.Repeat("a", 250),
+		strings.Repeat("nested/", 100) + "file.txt",
+		strings.Repeat("k", 1024),
 	}
+	for i, key := range keys {
+		content := []byte(fmt.Sprintf("content %d", i))
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
+			t.Fatalf("PutObject failed for %d-byte key: %v", len(key), err)
+		}
 
-	if meta.Size != 0 {
-		t.Errorf("Size = %d, want 0", meta.Size)
-	}
+		keyHash := sha256.Sum256([]byte(key))
+		objPath := filepath.Join(storage.basePath, "buckets", testBucket, "objects", hex.EncodeToString(keyHash[:2]), hex.EncodeToString(keyHash[:]))
+		if _, err := os.Stat(filepath.Join(objPath, "data")); err != nil {
+			t.Errorf("%d-byte key not stored at its SHA-256 path: %v", len(key), err)
+		}
 
-	reader, getMeta, err := storage.GetObject(testBucket, key)
-	if err != nil {
-		t.Fatalf("GetObject failed: %v", err)
+		reader, meta, err := storage.GetObject(context.Background(), testBucket, key)
+		if err != nil {
+			t.Fatalf("GetObject failed for %d-byte key: %v", len(key), err)
+		}
+		gotContent, _ := io.ReadAll(reader)
+		reader.Close()
+		if !bytes.Equal(gotContent, content) || meta.Key != key {
+			t.Errorf("%d-byte key: got %q with a %d-byte key", len(key), gotContent, len(meta.Key))
+		}
 	}
-	defer reader.Close()
 
-	gotContent, _ := io.ReadAll(reader)
-	if len(gotContent) != 0 {
-		t.Errorf("expected empty content, got %d bytes", len(gotContent))
+	// Listing pages through the long keys by continuation token
+	var listed []string
+	opts := ListObjectsOptions{MaxKeys: 1}
+	for {
+		result, err := storage.ListObjects(testBucket, opts)
+		if err != nil {
+			t.Fatalf("ListObjects failed: %v", err)
+		}
+		for _, obj := range result.Objects {
+			listed = append(listed, obj.Key)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		opts.ContinuationToken = result.NextContinuationToken
+	}
+	want := slices.Clone(keys)
+	slices.Sort(want)
+	if !slices.Equal(listed, want) {
+		t.Errorf("listed %d keys, want the %d long keys in order", len(listed), len(want))
 	}
 
-	if getMeta.Size != 0 {
-		t.Errorf("getMeta.Size = %d, want 0", getMeta.Size)
+	for _, key := range keys {
+		if err := storage.DeleteObject(testBucket, key); err != nil {
+			t.Fatalf("DeleteObject failed for %d-byte key: %v", len(key), err)
+		}
+	}
+	if keys := listKeys(t, storage); len(keys) != 0 {
+		t.Errorf("keys left after deleting: %d", len(keys))
 	}
 }
 
-func TestLargeObject(t *testing.T) {
+// TestReservedNameKeys checks that keys named like the internal files of an
+// object directory are stored like any other key
+func TestReservedNameKeys(t *testing.T) {
 	storage, cleanup := setupTestStorage(t)
 	defer cleanup()
 
-	key := "large-file.bin"
-	size := 10 * 1024 * 1024 // 10MB
-	content := make([]byte, size)
-	for i := range content {
-		content[i] = byte(i % 256)
+	keys := []string{"meta.json", "data", "data.tmp", "tags.json", "versions", "version.json", "meta.json/data"}
+	for _, key := range keys {
+		content := []byte("content for " + key)
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content)); err != nil {
+			t.Fatalf("PutObject failed for key %q: %v", key, err)
+		}
 	}
 
-	meta, err := storage.PutObject(testBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
-	if err != nil {
-		t.Fatalf("PutObject failed: %v", err)
+	for _, key := range keys {
+		reader, meta, err := storage.GetObject(context.Background(), testBucket, key)
+		if err != nil {
+			t.Fatalf("GetObject failed for key %q: %v", key, err)
+		}
+		gotContent, _ := io.ReadAll(reader)
+		reader.Close()
+		if want := "content for " + key; string(gotContent) != want || meta.Key != key {
+			t.Errorf("key %q: got %q with key %q, want %q", key, gotContent, meta.Key, want)
+		}
 	}
 
-	if meta.Size != int64(size) {
-		t.Errorf("Size = %d, want %d", meta.Size, size)
+	got := listKeys(t, storage)
+	want := slices.Clone(keys)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("listed keys = %v, want %v", got, want)
 	}
+}
 
-	reader, _, err := storage.GetObject(testBucket, key)
-	if err != nil {
-		t.Fatalf("GetObject failed: %v", err)
+func TestIsReservedObjectName(t *testing.T) {
+	tests := map[string]bool{
+		"data":             true,
+		"meta.json":        true,
+		"versions":         true,
+		"version.json":     true,
+		"data.tmp":         true,
+		"data.tmp.1234":    true,
+		"tags.json":        false,
+		"0123456789abcdef": false,
 	}
-	defer reader.Close()
-
-	gotContent, err := io.ReadAll(reader)
-	if err != nil {
-		t.Fatalf("reading content failed: %v", err)
+	for name, want := range tests {
+		if got := isReservedObjectName(name); got != want {
+			t.Errorf("isReservedObjectName(%q) = %v, want %v", name, got, want)
+		}
 	}
+}
 
-	if !bytes.Equal(gotContent, content) {
-		t.Error("content mismatch for large object")
-	}
+func TestEmptyObject(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "empty.txt"
+		content := []byte{}
+
+		meta, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+
+		if meta.Size != 0 {
+			t.Errorf("Size = %d, want 0", meta.Size)
+		}
+
+		reader, getMeta, err := storage.GetObject(context.Background(), testBucket, key)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer reader.Close()
+
+		gotContent, _ := io.ReadAll(reader)
+		if len(gotContent) != 0 {
+			t.Errorf("expected empty content, got %d bytes", len(gotContent))
+		}
+
+		if getMeta.Size != 0 {
+			t.Errorf("getMeta.Size = %d, want 0", getMeta.Size)
+		}
+	})
+}
+
+func TestLargeObject(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "large-file.bin"
+		size := 10 * 1024 * 1024 // 10MB
+		content := make([]byte, size)
+		for i := range content {
+			content[i] = byte(i % 256)
+		}
+
+		meta, err := storage.PutObject(context.Background(), testBucket, key, "application/octet-stream", nil, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+
+		if meta.Size != int64(size) {
+			t.Errorf("Size = %d, want %d", meta.Size, size)
+		}
+
+		reader, _, err := storage.GetObject(context.Background(), testBucket, key)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer reader.Close()
+
+		gotContent, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("reading content failed: %v", err)
+		}
+
+		if !bytes.Equal(gotContent, content) {
+			t.Error("content mismatch for large object")
+		}
+	})
 }
 
 // Multipart upload tests
 
 func TestMultipartUpload(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	key := "multipart-test.txt"
-	contentType := "text/plain"
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "multipart-test.txt"
+		contentType := "text/plain"
 
-	// Create multipart upload
-	uploadID, err := storage.CreateMultipartUpload(testBucket, key, contentType, nil)
-	if err != nil {
-		t.Fatalf("CreateMultipartUpload failed: %v", err)
-	}
+		// Create multipart upload
+		uploadID, err := storage.CreateMultipartUpload(testBucket, key, contentType, nil)
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
 
-	if uploadID == "" {
-		t.Fatal("uploadID is empty")
-	}
+		if uploadID == "" {
+			t.Fatal("uploadID is empty")
+		}
 
-	// Upload parts
-	part1Content := []byte("Part 1 content. ")
-	part2Content := []byte("Part 2 content. ")
-	part3Content := []byte("Part 3 content.")
+		// Upload parts
+		part1Content := []byte("Part 1 content. ")
+		part2Content := []byte("Part 2 content. ")
+		part3Content := []byte("Part 3 content.")
 
-	part1Meta, err := storage.UploadPart(uploadID, 1, bytes.NewReader(part1Content))
-	if err != nil {
-		t.Fatalf("UploadPart 1 failed: %v", err)
-	}
+		part1Meta, err := storage.UploadPart(context.Background(), uploadID, 1, bytes.NewReader(part1Content))
+		if err != nil {
+			t.Fatalf("UploadPart 1 failed: %v", err)
+		}
 
-	part2Meta, err := storage.UploadPart(uploadID, 2, bytes.NewReader(part2Content))
-	if err != nil {
-		t.Fatalf("UploadPart 2 failed: %v", err)
-	}
+		part2Meta, err := storage.UploadPart(context.Background(), uploadID, 2, bytes.NewReader(part2Content))
+		if err != nil {
+			t.Fatalf("UploadPart 2 failed: %v", err)
+		}
 
-	part3Meta, err := storage.UploadPart(uploadID, 3, bytes.NewReader(part3Content))
-	if err != nil {
-		t.Fatalf("UploadPart 3 failed: %v", err)
-	}
+		part3Meta, err := storage.UploadPart(context.Background(), uploadID, 3, bytes.NewReader(part3Content))
+		if err != nil {
+			t.Fatalf("UploadPart 3 failed: %v", err)
+		}
 
-	// Complete upload
-	parts := []s3.CompletedPartInput{
-		{PartNumber: 1, ETag: part1Meta.ETag},
-		{PartNumber: 2, ETag: part2Meta.ETag},
-		{PartNumber: 3, ETag: part3Meta.ETag},
-	}
+		// Complete upload
+		parts := []s3.CompletedPartInput{
+			{PartNumber: 1, ETag: part1Meta.ETag},
+			{PartNumber: 2, ETag: part2Meta.ETag},
+			{PartNumber: 3, ETag: part3Meta.ETag},
+		}
 
-	objMeta, err := storage.CompleteMultipartUpload(uploadID, parts)
-	if err != nil {
-		t.Fatalf("CompleteMultipartUpload failed: %v", err)
-	}
+		objMeta, err := storage.CompleteMultipartUpload(context.Background(), uploadID, parts)
+		if err != nil {
+			t.Fatalf("CompleteMultipartUpload failed: %v", err)
+		}
 
-	expectedSize := int64(len(part1Content) + len(part2Content) + len(part3Content))
-	if objMeta.Size != expectedSize {
-		t.Errorf("Size = %d, want %d", objMeta.Size, expectedSize)
-	}
+		expectedSize := int64(len(part1Content) + len(part2Content) + len(part3Content))
+		if objMeta.Size != expectedSize {
+			t.Errorf("Size = %d, want %d", objMeta.Size, expectedSize)
+		}
+		wantPartSizes := []int64{int64(len(part1Content)), int64(len(part2Content)), int64(len(part3Content))}
+		if head, err := storage.HeadObject(testBucket, key); err != nil || !slices.Equal(head.PartSizes, wantPartSizes) {
+			t.Errorf("HeadObject part sizes = %v (%v), want %v", head, err, wantPartSizes)
+		}
 
-	// Verify content
-	reader, _, err := storage.GetObject(testBucket, key)
-	if err != nil {
-		t.Fatalf("GetObject failed: %v", err)
-	}
-	defer reader.Close()
+		// Verify content
+		reader, _, err := storage.GetObject(context.Background(), testBucket, key)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer reader.Close()
 
-	expectedContent := append(append(part1Content, part2Content...), part3Content...)
-	gotContent, _ := io.ReadAll(reader)
+		expectedContent := append(append(part1Content, part2Content...), part3Content...)
+		gotContent, _ := io.ReadAll(reader)
 
-	if !bytes.Equal(gotContent, expectedContent) {
-		t.Error("multipart content mismatch")
-	}
+		if !bytes.Equal(gotContent, expectedContent) {
+			t.Error("multipart content mismatch")
+		}
 
-	// ETag should have multipart format (hash-numparts)
-	if !strings.Contains(objMeta.ETag, "-3") {
-		t.Errorf("ETag should indicate 3 parts: %q", objMeta.ETag)
-	}
+		// ETag should have multipart format (hash-numparts)
+		if !strings.Contains(objMeta.ETag, "-3") {
+			t.Errorf("ETag should indicate 3 parts: %q", objMeta.ETag)
+		}
+	})
 }
 
 func TestAbortMultipartUpload(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "abort-test.txt"
 
-	key := "abort-test.txt"
-
-	// Create upload
-	uploadID, err := storage.CreateMultipartUpload(testBucket, key, "text/plain", nil)
-	if err != nil {
-		t.Fatalf("CreateMultipartUpload failed: %v", err)
-	}
+		// Create upload
+		uploadID, err := storage.CreateMultipartUpload(testBucket, key, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
 
-	// Upload a part
-	_, err = storage.UploadPart(uploadID, 1, bytes.NewReader([]byte("part content")))
-	if err != nil {
-		t.Fatalf("UploadPart failed: %v", err)
-	}
+		// Upload a part
+		_, err = storage.UploadPart(context.Background(), uploadID, 1, bytes.NewReader([]byte("part content")))
+		if err != nil {
+			t.Fatalf("UploadPart failed: %v", err)
+		}
 
-	// Abort
-	err = storage.AbortMultipartUpload(uploadID)
-	if err != nil {
-		t.Fatalf("AbortMultipartUpload failed: %v", err)
-	}
+		// Abort
+		err = storage.AbortMultipartUpload(uploadID)
+		if err != nil {
+			t.Fatalf("AbortMultipartUpload failed: %v", err)
+		}
 
-	// Verify upload is gone
-	_, err = storage.GetMultipartUpload(uploadID)
-	if err == nil {
-		t.Error("expected error getting aborted upload")
-	}
+		// Verify upload is gone
+		_, err = storage.GetMultipartUpload(uploadID)
+		if err == nil {
+			t.Error("expected error getting aborted upload")
+		}
+	})
 }
 
 func TestGetMultipartUpload(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "get-upload-test.txt"
+		contentType := "application/json"
+		metadata := map[string]string{"custom": "value"}
 
-	key := "get-upload-test.txt"
-	contentType := "application/json"
-	metadata := map[string]string{"custom": "value"}
-
-	uploadID, err := storage.CreateMultipartUpload(testBucket, key, contentType, metadata)
-	if err != nil {
-		t.Fatalf("CreateMultipartUpload failed: %v", err)
-	}
+		uploadID, err := storage.CreateMultipartUpload(testBucket, key, contentType, metadata)
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
 
-	uploadMeta, err := storage.GetMultipartUpload(uploadID)
-	if err != nil {
-		t.Fatalf("GetMultipartUpload failed: %v", err)
-	}
+		uploadMeta, err := storage.GetMultipartUpload(uploadID)
+		if err != nil {
+			t.Fatalf("GetMultipartUpload failed: %v", err)
+		}
 
-	if uploadMeta.UploadID != uploadID {
-		t.Errorf("UploadID = %q, want %q", uploadMeta.UploadID, uploadID)
-	}
-	if uploadMeta.Key != key {
-		t.Errorf("Key = %q, want %q", uploadMeta.Key, key)
-	}
-	if uploadMeta.ContentType != contentType {
-		t.Errorf("ContentType = %q, want %q", uploadMeta.ContentType, contentType)
-	}
-	if uploadMeta.UserMetadata["custom"] != "value" {
-		t.Errorf("UserMetadata[custom] = %q, want %q", uploadMeta.UserMetadata["custom"], "value")
-	}
+		if uploadMeta.UploadID != uploadID {
+			t.Errorf("UploadID = %q, want %q", uploadMeta.UploadID, uploadID)
+		}
+		if uploadMeta.Key != key {
+			t.Errorf("Key = %q, want %q", uploadMeta.Key, key)
+		}
+		if uploadMeta.ContentType != contentType {
+			t.Errorf("ContentType = %q, want %q", uploadMeta.ContentType, contentType)
+		}
+		if uploadMeta.UserMetadata["custom"] != "value" {
+			t.Errorf("UserMetadata[custom] = %q, want %q", uploadMeta.UserMetadata["custom"], "value")
+		}
+	})
 }
 
 func TestGetMultipartUploadNotFound(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	_, err := storage.GetMultipartUpload("nonexistent-upload-id")
-	if err == nil {
-		t.Error("expected error for nonexistent upload")
-	}
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		_, err := storage.GetMultipartUpload("nonexistent-upload-id")
+		if err == nil {
+			t.Error("expected error for nonexistent upload")
+		}
+	})
 }
 
 func TestCompleteMultipartUploadInvalidPartOrder(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	key := "order-test.txt"
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "order-test.txt"
 
-	uploadID, err := storage.CreateMultipartUpload(testBucket, key, "text/plain", nil)
-	if err != nil {
-		t.Fatalf("CreateMultipartUpload failed: %v", err)
-	}
+		uploadID, err := storage.CreateMultipartUpload(testBucket, key, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
 
-	part1Meta, _ := storage.UploadPart(uploadID, 1, bytes.NewReader([]byte("part 1")))
-	part2Meta, _ := storage.UploadPart(uploadID, 2, bytes.NewReader([]byte("part 2")))
+		part1Meta, _ := storage.UploadPart(context.Background(), uploadID, 1, bytes.NewReader([]byte("part 1")))
+		part2Meta, _ := storage.UploadPart(context.Background(), uploadID, 2, bytes.NewReader([]byte("part 2")))
 
-	// Parts in wrong order
-	parts := []s3.CompletedPartInput{
-		{PartNumber: 2, ETag: part2Meta.ETag},
-		{PartNumber: 1, ETag: part1Meta.ETag},
-	}
+		// Parts in wrong order
+		parts := []s3.CompletedPartInput{
+			{PartNumber: 2, ETag: part2Meta.ETag},
+			{PartNumber: 1, ETag: part1Meta.ETag},
+		}
 
-	_, err = storage.CompleteMultipartUpload(uploadID, parts)
-	if err == nil {
-		t.Error("expected error for parts not in ascending order")
-	}
-	if !strings.Contains(err.Error(), "order") {
-		t.Errorf("expected order error, got: %v", err)
-	}
+		_, err = storage.CompleteMultipartUpload(context.Background(), uploadID, parts)
+		if err == nil {
+			t.Error("expected error for parts not in ascending order")
+		}
+		if !strings.Contains(err.Error(), "order") {
+			t.Errorf("expected order error, got: %v", err)
+		}
+	})
 }
 
 func TestCompleteMultipartUploadMissingPart(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	key := "missing-part-test.txt"
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "missing-part-test.txt"
 
-	uploadID, err := storage.CreateMultipartUpload(testBucket, key, "text/plain", nil)
-	if err != nil {
-		t.Fatalf("CreateMultipartUpload failed: %v", err)
-	}
+		uploadID, err := storage.CreateMultipartUpload(testBucket, key, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
 
-	part1Meta, _ := storage.UploadPart(uploadID, 1, bytes.NewReader([]byte("part 1")))
+		part1Meta, _ := storage.UploadPart(context.Background(), uploadID, 1, bytes.NewReader([]byte("part 1")))
 
-	// Request part 1 and 2, but only uploaded part 1
-	parts := []s3.CompletedPartInput{
-		{PartNumber: 1, ETag: part1Meta.ETag},
-		{PartNumber: 2, ETag: "\"fakeetag\""},
-	}
+		// Request part 1 and 2, but only uploaded part 1
+		parts := []s3.CompletedPartInput{
+			{PartNumber: 1, ETag: part1Meta.ETag},
+			{PartNumber: 2, ETag: "\"fakeetag\""},
+		}
 
-	_, err = storage.CompleteMultipartUpload(uploadID, parts)
-	if err == nil {
-		t.Error("expected error for missing part")
-	}
+		_, err = storage.CompleteMultipartUpload(context.Background(), uploadID, parts)
+		if err == nil {
+			t.Error("expected error for missing part")
+		}
+	})
 }
 
 func TestListParts(t *testing.T) {
@@ -813,13 +1211,13 @@ func TestListParts(t *testing.T) {
 	}
 
 	// Upload parts out of order
-	if _, err := storage.UploadPart(uploadID, 3, bytes.NewReader([]byte("part 3"))); err != nil {
+	if _, err := storage.UploadPart(context.Background(), uploadID, 3, bytes.NewReader([]byte("part 3"))); err != nil {
 		t.Fatalf("UploadPart failed: %v", err)
 	}
-	if _, err := storage.UploadPart(uploadID, 1, bytes.NewReader([]byte("part 1"))); err != nil {
+	if _, err := storage.UploadPart(context.Background(), uploadID, 1, bytes.NewReader([]byte("part 1"))); err != nil {
 		t.Fatalf("UploadPart failed: %v", err)
 	}
-	if _, err := storage.UploadPart(uploadID, 2, bytes.NewReader([]byte("part 2"))); err != nil {
+	if _, err := storage.UploadPart(context.Background(), uploadID, 2, bytes.NewReader([]byte("part 2"))); err != nil {
 		t.Fatalf("UploadPart failed: %v", err)
 	}
 
@@ -844,263 +1242,494 @@ func TestListParts(t *testing.T) {
 // Tests for ListObjects, CopyObject, and GetObjectRange
 
 func TestListObjects(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		// Create test objects
+		objects := []string{
+			"dir1/file1.txt",
+			"dir1/file2.txt",
+			"dir1/subdir/file3.txt",
+			"dir2/file4.txt",
+			"root-file.txt",
+		}
+
+		for _, key := range objects {
+			if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("content"))); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+		}
 
-	// Create test objects
-	objects := []string{
-		"dir1/file1.txt",
-		"dir1/file2.txt",
-		"dir1/subdir/file3.txt",
-		"dir2/file4.txt",
-		"root-file.txt",
-	}
+		t.Run("list all objects", func(t *testing.T) {
+			result, err := storage.ListObjects(testBucket, ListObjectsOptions{})
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
 
-	for _, key := range objects {
-		if _, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader([]byte("content"))); err != nil {
-			t.Fatalf("PutObject failed: %v", err)
-		}
-	}
+			if len(result.Objects) != 5 {
+				t.Errorf("expected 5 objects, got %d", len(result.Objects))
+			}
+		})
 
-	t.Run("list all objects", func(t *testing.T) {
-		result, err := storage.ListObjects(testBucket, ListObjectsOptions{})
-		if err != nil {
-			t.Fatalf("ListObjects failed: %v", err)
-		}
+		t.Run("list with prefix", func(t *testing.T) {
+			result, err := storage.ListObjects(testBucket, ListObjectsOptions{Prefix: "dir1/"})
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
 
-		if len(result.Objects) != 5 {
-			t.Errorf("expected 5 objects, got %d", len(result.Objects))
-		}
+			if len(result.Objects) != 3 {
+				t.Errorf("expected 3 objects with prefix 'dir1/', got %d", len(result.Objects))
+			}
+		})
+
+		t.Run("list with delimiter", func(t *testing.T) {
+			result, err := storage.ListObjects(testBucket, ListObjectsOptions{Delimiter: "/"})
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
+
+			// Should have 1 root object and 2 common prefixes (dir1/, dir2/)
+			if len(result.Objects) != 1 {
+				t.Errorf("expected 1 root object, got %d", len(result.Objects))
+			}
+			if len(result.CommonPrefixes) != 2 {
+				t.Errorf("expected 2 common prefixes, got %d", len(result.CommonPrefixes))
+			}
+		})
+
+		t.Run("list with prefix and delimiter", func(t *testing.T) {
+			result, err := storage.ListObjects(testBucket, ListObjectsOptions{Prefix: "dir1/", Delimiter: "/"})
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
+
+			// Should have 2 files and 1 common prefix (dir1/subdir/)
+			if len(result.Objects) != 2 {
+				t.Errorf("expected 2 objects, got %d", len(result.Objects))
+			}
+			if len(result.CommonPrefixes) != 1 {
+				t.Errorf("expected 1 common prefix, got %d", len(result.CommonPrefixes))
+			}
+		})
+
+		t.Run("list with max keys", func(t *testing.T) {
+			result, err := storage.ListObjects(testBucket, ListObjectsOptions{MaxKeys: 2})
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
+
+			if len(result.Objects) != 2 {
+				t.Errorf("expected 2 objects, got %d", len(result.Objects))
+			}
+			if !result.IsTruncated {
+				t.Error("expected IsTruncated to be true")
+			}
+			if result.NextContinuationToken == "" {
+				t.Error("expected NextContinuationToken to be set")
+			}
+		})
+
+		t.Run("list with continuation token", func(t *testing.T) {
+			// Get first page
+			result1, _ := storage.ListObjects(testBucket, ListObjectsOptions{MaxKeys: 2})
+
+			// Get second page
+			result2, err := storage.ListObjects(testBucket, ListObjectsOptions{
+				MaxKeys:           2,
+				ContinuationToken: result1.NextContinuationToken,
+			})
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
+
+			// Should have different objects
+			if len(result2.Objects) == 0 {
+				t.Error("expected objects in second page")
+			}
+			if len(result2.Objects) > 0 && result2.Objects[0].Key == result1.Objects[0].Key {
+				t.Error("second page should have different objects")
+			}
+		})
 	})
+}
 
-	t.Run("list with prefix", func(t *testing.T) {
-		result, err := storage.ListObjects(testBucket, ListObjectsOptions{Prefix: "dir1/"})
-		if err != nil {
-			t.Fatalf("ListObjects failed: %v", err)
+func TestListObjectsFunc(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		// More keys than one batch, with common prefixes straddling the batches
+		var want []string
+		for i := 0; i < 2*listBatchSize+50; i++ {
+			key := fmt.Sprintf("key-%04d", i)
+			if i%40 == 0 {
+				key = fmt.Sprintf("key-%04d/nested", i)
+			}
+			if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("x")); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			want = append(want, key)
+		}
+
+		collect := func(opts ListObjectsOptions) ([]string, *ListObjectsResult) {
+			t.Helper()
+			var entries []string
+			result, err := storage.ListObjectsFunc(testBucket, opts, func(entry ListEntry) error {
+				if entry.Object != nil {
+					entries = append(entries, entry.Object.Key)
+				} else {
+					entries = append(entries, "prefix:"+entry.CommonPrefix)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("ListObjectsFunc failed: %v", err)
+			}
+			return entries, result
 		}
 
-		if len(result.Objects) != 3 {
-			t.Errorf("expected 3 objects with prefix 'dir1/', got %d", len(result.Objects))
-		}
+		t.Run("all keys in order", func(t *testing.T) {
+			got, result := collect(ListObjectsOptions{})
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("listed %d keys, want %d in order", len(got), len(want))
+			}
+			if result.IsTruncated || len(result.Objects) != 0 {
+				t.Errorf("result = %+v, want untruncated and no collected objects", result)
+			}
+		})
+
+		t.Run("delimiter", func(t *testing.T) {
+			got, _ := collect(ListObjectsOptions{Delimiter: "/"})
+			if len(got) != len(want) {
+				t.Fatalf("listed %d entries, want %d", len(got), len(want))
+			}
+			for i, key := range want {
+				if strings.HasSuffix(key, "/nested") {
+					key = "prefix:" + strings.TrimSuffix(key, "nested")
+				}
+				if got[i] != key {
+					t.Errorf("entry %d = %q, want %q", i, got[i], key)
+				}
+			}
+		})
+
+		t.Run("pages across batches", func(t *testing.T) {
+			var pages []string
+			opts := ListObjectsOptions{MaxKeys: listBatchSize + 7}
+			for {
+				got, result := collect(opts)
+				pages = append(pages, got...)
+				if !result.IsTruncated {
+					break
+				}
+				opts.ContinuationToken = result.NextContinuationToken
+			}
+			if strings.Join(pages, ",") != strings.Join(want, ",") {
+				t.Errorf("paged listing returned %d keys, want %d in order", len(pages), len(want))
+			}
+		})
+
+		t.Run("callback error stops listing", func(t *testing.T) {
+			errStop := errors.New("stop")
+			calls := 0
+			_, err := storage.ListObjectsFunc(testBucket, ListObjectsOptions{}, func(ListEntry) error {
+				calls++
+				return errStop
+			})
+			if !errors.Is(err, errStop) || calls != 1 {
+				t.Errorf("err = %v after %d calls, want errStop after 1", err, calls)
+			}
+		})
 	})
+}
 
-	t.Run("list with delimiter", func(t *testing.T) {
-		result, err := storage.ListObjects(testBucket, ListObjectsOptions{Delimiter: "/"})
+func TestCopyObject(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		srcKey := "source.txt"
+		dstKey := "destination.txt"
+		content := []byte("content to copy")
+		metadata := map[string]string{"author": "test"}
+
+		// Create source object
+		srcMeta, err := storage.PutObject(context.Background(), testBucket, srcKey, "text/plain", metadata, bytes.NewReader(content))
 		if err != nil {
-			t.Fatalf("ListObjects failed: %v", err)
-		}
-
-		// Should have 1 root object and 2 common prefixes (dir1/, dir2/)
-		if len(result.Objects) != 1 {
-			t.Errorf("expected 1 root object, got %d", len(result.Objects))
-		}
-		if len(result.CommonPrefixes) != 2 {
-			t.Errorf("expected 2 common prefixes, got %d", len(result.CommonPrefixes))
+			t.Fatalf("PutObject failed: %v", err)
 		}
-	})
 
-	t.Run("list with prefix and delimiter", func(t *testing.T) {
-		result, err := storage.ListObjects(testBucket, ListObjectsOptions{Prefix: "dir1/", Delimiter: "/"})
+		// Copy object
+		dstMeta, err := storage.CopyObject(context.Background(), testBucket, srcKey, testBucket, dstKey)
 		if err != nil {
-			t.Fatalf("ListObjects failed: %v", err)
+			t.Fatalf("CopyObject failed: %v", err)
 		}
 
-		// Should have 2 files and 1 common prefix (dir1/subdir/)
-		if len(result.Objects) != 2 {
-			t.Errorf("expected 2 objects, got %d", len(result.Objects))
+		// Verify destination metadata
+		if dstMeta.Size != srcMeta.Size {
+			t.Errorf("Size = %d, want %d", dstMeta.Size, srcMeta.Size)
 		}
-		if len(result.CommonPrefixes) != 1 {
-			t.Errorf("expected 1 common prefix, got %d", len(result.CommonPrefixes))
+		if dstMeta.ContentType != srcMeta.ContentType {
+			t.Errorf("ContentType = %q, want %q", dstMeta.ContentType, srcMeta.ContentType)
 		}
-	})
 
-	t.Run("list with max keys", func(t *testing.T) {
-		result, err := storage.ListObjects(testBucket, ListObjectsOptions{MaxKeys: 2})
+		// Verify destination content
+		reader, _, err := storage.GetObject(context.Background(), testBucket, dstKey)
 		if err != nil {
-			t.Fatalf("ListObjects failed: %v", err)
+			t.Fatalf("GetObject failed: %v", err)
 		}
+		gotContent, _ := io.ReadAll(reader)
+		reader.Close()
 
-		if len(result.Objects) != 2 {
-			t.Errorf("expected 2 objects, got %d", len(result.Objects))
-		}
-		if !result.IsTruncated {
-			t.Error("expected IsTruncated to be true")
+		if !bytes.Equal(gotContent, content) {
+			t.Error("copied content doesn't match original")
 		}
-		if result.NextContinuationToken == "" {
-			t.Error("expected NextContinuationToken to be set")
+
+		// Verify source still exists
+		exists, _ := storage.ObjectExists(testBucket, srcKey)
+		if !exists {
+			t.Error("source object should still exist after copy")
 		}
 	})
+}
 
-	t.Run("list with continuation token", func(t *testing.T) {
-		// Get first page
-		result1, _ := storage.ListObjects(testBucket, ListObjectsOptions{MaxKeys: 2})
+// TestCopyObjectServerSideEncryption checks that a copy keeps the source's
+// server-side encryption unless the copy sets its own
+func TestCopyObjectServerSideEncryption(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		ctx := context.Background()
+		opts := PutObjectOptions{ServerSideEncryption: s3.ServerSideEncryptionAES256}
+		if _, err := storage.PutObjectWithOptions(ctx, testBucket, "encrypted.txt", "text/plain", nil, opts, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObjectWithOptions failed: %v", err)
+		}
+		if _, err := storage.PutObject(ctx, testBucket, "plain.txt", "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
 
-		// Get second page
-		result2, err := storage.ListObjects(testBucket, ListObjectsOptions{
-			MaxKeys:           2,
-			ContinuationToken: result1.NextContinuationToken,
-		})
+		meta, err := storage.CopyObjectWithOptions(ctx, testBucket, "encrypted.txt", testBucket, "copy.txt", CopyObjectOptions{})
 		if err != nil {
-			t.Fatalf("ListObjects failed: %v", err)
+			t.Fatalf("CopyObjectWithOptions failed: %v", err)
+		}
+		if meta.ServerSideEncryption != s3.ServerSideEncryptionAES256 {
+			t.Errorf("copy ServerSideEncryption = %q, want %q", meta.ServerSideEncryption, s3.ServerSideEncryptionAES256)
 		}
 
-		// Should have different objects
-		if len(result2.Objects) == 0 {
-			t.Error("expected objects in second page")
+		// Onto itself, only the encryption changes
+		copyOpts := CopyObjectOptions{ServerSideEncryption: s3.ServerSideEncryptionAES256}
+		if _, err := storage.CopyObjectWithOptions(ctx, testBucket, "plain.txt", testBucket, "plain.txt", copyOpts); err != nil {
+			t.Fatalf("CopyObjectWithOptions failed: %v", err)
+		}
+		meta, err = storage.HeadObject(testBucket, "plain.txt")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
 		}
-		if len(result2.Objects) > 0 && result2.Objects[0].Key == result1.Objects[0].Key {
-			t.Error("second page should have different objects")
+		if meta.ServerSideEncryption != s3.ServerSideEncryptionAES256 || meta.ContentType != "text/plain" {
+			t.Errorf("metadata = %+v, want AES256 and the original content type", meta)
 		}
 	})
 }
 
-func TestCopyObject(t *testing.T) {
+// TestCopyObjectOntoItself checks that copying an object onto itself
+// updates its metadata without rewriting its data
+func TestCopyObjectOntoItself(t *testing.T) {
 	storage, cleanup := setupTestStorage(t)
 	defer cleanup()
 
-	srcKey := "source.txt"
-	dstKey := "destination.txt"
-	content := []byte("content to copy")
-	metadata := map[string]string{"author": "test"}
-
-	// Create source object
-	srcMeta, err := storage.PutObject(testBucket, srcKey, "text/plain", metadata, bytes.NewReader(content))
+	const key = "large/update-metadata.bin"
+	content := bytes.Repeat([]byte("0123456789abcdef"), 512*1024) // 8MB
+	original, err := storage.PutObject(context.Background(), testBucket, key, "application/octet-stream", map[string]string{"old": "yes"}, bytes.NewReader(content))
 	if err != nil {
 		t.Fatalf("PutObject failed: %v", err)
 	}
+	objPath, err := storage.keyToPath(testBucket, key)
+	if err != nil {
+		t.Fatalf("keyToPath failed: %v", err)
+	}
+	dataPath := filepath.Join(objPath, "data")
+	before, err := os.Stat(dataPath)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
 
-	// Copy object
-	dstMeta, err := storage.CopyObject(testBucket, srcKey, testBucket, dstKey)
+	meta, err := storage.CopyObjectWithOptions(context.Background(), testBucket, key, testBucket, key, CopyObjectOptions{
+		ReplaceMetadata: true,
+		ContentType:     "video/mp4",
+		Metadata:        map[string]string{"new": "yes"},
+	})
 	if err != nil {
-		t.Fatalf("CopyObject failed: %v", err)
+		t.Fatalf("CopyObjectWithOptions failed: %v", err)
 	}
 
-	// Verify destination metadata
-	if dstMeta.Size != srcMeta.Size {
-		t.Errorf("Size = %d, want %d", dstMeta.Size, srcMeta.Size)
+	after, err := os.Stat(dataPath)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) {
+		t.Error("data file was rewritten")
 	}
-	if dstMeta.ContentType != srcMeta.ContentType {
-		t.Errorf("ContentType = %q, want %q", dstMeta.ContentType, srcMeta.ContentType)
+	if meta.ETag != original.ETag || meta.Size != original.Size {
+		t.Errorf("ETag, size = %s, %d, want %s, %d", meta.ETag, meta.Size, original.ETag, original.Size)
+	}
+	if !meta.Created.Equal(original.Created) {
+		t.Errorf("Created = %v, want %v", meta.Created, original.Created)
 	}
 
-	// Verify destination content
-	reader, _, err := storage.GetObject(testBucket, dstKey)
+	head, err := storage.HeadObject(testBucket, key)
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if head.ContentType != "video/mp4" || !maps.Equal(head.UserMetadata, map[string]string{"new": "yes"}) {
+		t.Errorf("metadata = %q %v, want the replacement", head.ContentType, head.UserMetadata)
+	}
+	reader, _, err := storage.GetObject(context.Background(), testBucket, key)
 	if err != nil {
 		t.Fatalf("GetObject failed: %v", err)
 	}
-	gotContent, _ := io.ReadAll(reader)
+	got, _ := io.ReadAll(reader)
 	reader.Close()
-
-	if !bytes.Equal(gotContent, content) {
-		t.Error("copied content doesn't match original")
+	if !bytes.Equal(got, content) {
+		t.Error("content changed")
 	}
 
-	// Verify source still exists
-	exists, _ := storage.ObjectExists(testBucket, srcKey)
-	if !exists {
-		t.Error("source object should still exist after copy")
+	// A versioned bucket keeps the previous version
+	if err := storage.PutBucketVersioning(testBucket, VersioningEnabled); err != nil {
+		t.Fatalf("PutBucketVersioning failed: %v", err)
 	}
-}
-
-func TestCopyObjectNotFound(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	_, err := storage.CopyObject(testBucket, "nonexistent", testBucket, "destination")
-	if err == nil {
-		t.Error("expected error when copying nonexistent object")
+	if _, err := storage.CopyObjectWithOptions(context.Background(), testBucket, key, testBucket, key, CopyObjectOptions{ReplaceMetadata: true, ContentType: "text/plain"}); err != nil {
+		t.Fatalf("CopyObjectWithOptions failed: %v", err)
 	}
-}
-
-func TestGetObjectRange(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	key := "range-test.txt"
-	content := []byte("0123456789ABCDEFGHIJ") // 20 bytes
-
-	_, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader(content))
+	versions, err := storage.ListObjectVersions(testBucket, ListObjectVersionsOptions{})
 	if err != nil {
-		t.Fatalf("PutObject failed: %v", err)
+		t.Fatalf("ListObjectVersions failed: %v", err)
 	}
+	if len(versions.Versions) != 2 || versions.Versions[1].ContentType != "video/mp4" {
+		t.Errorf("versions = %+v, want the new version and the previous one", versions.Versions)
+	}
+}
 
-	t.Run("full range", func(t *testing.T) {
-		reader, _, err := storage.GetObjectRange(testBucket, key, 0, 19)
+func TestUpdateMetadata(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		updater := storage.(MetadataUpdater)
+		original, err := storage.PutObjectWithOptions(context.Background(), testBucket, "doc", "text/plain", map[string]string{"old": "yes"}, PutObjectOptions{Tags: map[string]string{"env": "dev"}}, strings.NewReader("content"))
 		if err != nil {
-			t.Fatalf("GetObjectRange failed: %v", err)
-		}
-		got, _ := io.ReadAll(reader)
-		reader.Close()
-
-		if !bytes.Equal(got, content) {
-			t.Errorf("content = %q, want %q", got, content)
+			t.Fatalf("PutObject failed: %v", err)
 		}
-	})
 
-	t.Run("partial range from start", func(t *testing.T) {
-		reader, _, err := storage.GetObjectRange(testBucket, key, 0, 9)
+		meta, err := updater.UpdateMetadata(testBucket, "doc", "text/markdown", map[string]string{"new": "yes"}, map[string]string{"env": "prod"})
 		if err != nil {
-			t.Fatalf("GetObjectRange failed: %v", err)
+			t.Fatalf("UpdateMetadata failed: %v", err)
 		}
-		got, _ := io.ReadAll(reader)
-		reader.Close()
-
-		expected := []byte("0123456789")
-		if !bytes.Equal(got, expected) {
-			t.Errorf("content = %q, want %q", got, expected)
+		if meta.ETag != original.ETag || meta.Size != original.Size {
+			t.Errorf("ETag, size = %s, %d, want %s, %d", meta.ETag, meta.Size, original.ETag, original.Size)
 		}
-	})
 
-	t.Run("partial range from middle", func(t *testing.T) {
-		reader, _, err := storage.GetObjectRange(testBucket, key, 5, 14)
+		reader, head, err := storage.GetObject(context.Background(), testBucket, "doc")
 		if err != nil {
-			t.Fatalf("GetObjectRange failed: %v", err)
+			t.Fatalf("GetObject failed: %v", err)
 		}
-		got, _ := io.ReadAll(reader)
+		data, _ := io.ReadAll(reader)
 		reader.Close()
-
-		expected := []byte("56789ABCDE")
-		if !bytes.Equal(got, expected) {
-			t.Errorf("content = %q, want %q", got, expected)
+		if string(data) != "content" {
+			t.Errorf("data = %q, want %q", data, "content")
+		}
+		if head.ContentType != "text/markdown" || !maps.Equal(head.UserMetadata, map[string]string{"new": "yes"}) || !maps.Equal(head.Tags, map[string]string{"env": "prod"}) {
+			t.Errorf("metadata = %q %v %v, want the replacement", head.ContentType, head.UserMetadata, head.Tags)
 		}
-	})
 
-	t.Run("range past end", func(t *testing.T) {
-		reader, _, err := storage.GetObjectRange(testBucket, key, 15, 100)
-		if err != nil {
-			t.Fatalf("GetObjectRange failed: %v", err)
+		if _, err := updater.UpdateMetadata(testBucket, "missing", "text/plain", nil, nil); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("UpdateMetadata(missing) err = %v, want ErrObjectNotFound", err)
 		}
-		got, _ := io.ReadAll(reader)
-		reader.Close()
+	})
+}
 
-		expected := []byte("FGHIJ")
-		if !bytes.Equal(got, expected) {
-			t.Errorf("content = %q, want %q", got, expected)
+func TestCopyObjectNotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		_, err := storage.CopyObject(context.Background(), testBucket, "nonexistent", testBucket, "destination")
+		if err == nil {
+			t.Error("expected error when copying nonexistent object")
 		}
 	})
+}
 
-	t.Run("single byte", func(t *testing.T) {
-		reader, _, err := storage.GetObjectRange(testBucket, key, 5, 5)
+func TestGetObjectRange(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "range-test.txt"
+		content := []byte("0123456789ABCDEFGHIJ") // 20 bytes
+
+		_, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader(content))
 		if err != nil {
-			t.Fatalf("GetObjectRange failed: %v", err)
+			t.Fatalf("PutObject failed: %v", err)
 		}
-		got, _ := io.ReadAll(reader)
-		reader.Close()
 
-		if len(got) != 1 || got[0] != '5' {
-			t.Errorf("content = %q, want %q", got, "5")
-		}
+		t.Run("full range", func(t *testing.T) {
+			reader, _, err := storage.GetObjectRange(context.Background(), testBucket, key, 0, 19)
+			if err != nil {
+				t.Fatalf("GetObjectRange failed: %v", err)
+			}
+			got, _ := io.ReadAll(reader)
+			reader.Close()
+
+			if !bytes.Equal(got, content) {
+				t.Errorf("content = %q, want %q", got, content)
+			}
+		})
+
+		t.Run("partial range from start", func(t *testing.T) {
+			reader, _, err := storage.GetObjectRange(context.Background(), testBucket, key, 0, 9)
+			if err != nil {
+				t.Fatalf("GetObjectRange failed: %v", err)
+			}
+			got, _ := io.ReadAll(reader)
+			reader.Close()
+
+			expected := []byte("0123456789")
+			if !bytes.Equal(got, expected) {
+				t.Errorf("content = %q, want %q", got, expected)
+			}
+		})
+
+		t.Run("partial range from middle", func(t *testing.T) {
+			reader, _, err := storage.GetObjectRange(context.Background(), testBucket, key, 5, 14)
+			if err != nil {
+				t.Fatalf("GetObjectRange failed: %v", err)
+			}
+			got, _ := io.ReadAll(reader)
+			reader.Close()
+
+			expected := []byte("56789ABCDE")
+			if !bytes.Equal(got, expected) {
+				t.Errorf("content = %q, want %q", got, expected)
+			}
+		})
+
+		t.Run("range past end", func(t *testing.T) {
+			reader, _, err := storage.GetObjectRange(context.Background(), testBucket, key, 15, 100)
+			if err != nil {
+				t.Fatalf("GetObjectRange failed: %v", err)
+			}
+			got, _ := io.ReadAll(reader)
+			reader.Close()
+
+			expected := []byte("FGHIJ")
+			if !bytes.Equal(got, expected) {
+				t.Errorf("content = %q, want %q", got, expected)
+			}
+		})
+
+		t.Run("single byte", func(t *testing.T) {
+			reader, _, err := storage.GetObjectRange(context.Background(), testBucket, key, 5, 5)
+			if err != nil {
+				t.Fatalf("GetObjectRange failed: %v", err)
+			}
+			got, _ := io.ReadAll(reader)
+			reader.Close()
+
+			if len(got) != 1 || got[0] != '5' {
+				t.Errorf("content = %q, want %q", got, "5")
+			}
+		})
 	})
 }
 
 func TestGetObjectRangeNotFound(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
-
-	_, _, err := storage.GetObjectRange(testBucket, "nonexistent", 0, 10)
-	if err == nil {
-		t.Error("expected error for nonexistent object")
-	}
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		_, _, err := storage.GetObjectRange(context.Background(), testBucket, "nonexistent", 0, 10)
+		if err == nil {
+			t.Error("expected error for nonexistent object")
+		}
+	})
 }
 
 func TestValidateKey(t *testing.T) {
@@ -1118,6 +1747,10 @@ func TestValidateKey(t *testing.T) {
 		{"single dot path", "./file.txt", false},
 		{"hidden file", ".hidden", false},
 
+		// Length limit
+		{"max length", strings.Repeat("k", 1024), false},
+		{"too long", strings.Repeat("k", 1025), true},
+
 		// Invalid keys - empty
 		{"empty key", "", true},
 
@@ -1150,6 +1783,112 @@ func TestValidateKey(t *testing.T) {
 			}
 		})
 	}
+
+	if err := ValidateKey(strings.Repeat("k", 1025)); !errors.Is(err, ErrKeyTooLong) || !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("ValidateKey(1025 bytes) error = %v, want ErrKeyTooLong wrapping ErrInvalidKey", err)
+	}
+}
+
+func TestValidateNewKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		// Invalid keys - control characters and invalid UTF-8
+		{"tab", "file\t.txt", true},
+		{"newline", "path/\nfile.txt", true},
+		{"carriage return", "file.txt\r", true},
+		{"escape", "\x1b[31mred", true},
+		{"delete", "file\x7f.txt", true},
+		{"invalid utf-8", "file\xff.txt", true},
+		{"truncated utf-8", "文件"[:4], true},
+
+		// Special characters that are allowed
+		{"plus equals ampersand", "a+b=c&d.txt", false},
+		{"latin letters", "café/naïve.txt", false},
+		{"emoji", "photos/🐈.jpg", false},
+		{"non-breaking space", "file\u00a0name.txt", false},
+
+		// Keys rejected by ValidateKey
+		{"path traversal", "../file.txt", true},
+		{"null byte", "file\x00.txt", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateNewKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateNewKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("ValidateNewKey(%q) error = %v, want ErrInvalidKey", tt.key, err)
+			}
+		})
+	}
+}
+
+func TestControlCharacterKeys(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		const key = "report\n2026.txt"
+
+		// Every way of writing an object rejects the key
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("content")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("PutObject err = %v, want ErrInvalidKey", err)
+		}
+		if _, err := storage.CreateMultipartUpload(testBucket, key, "text/plain", nil); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("CreateMultipartUpload err = %v, want ErrInvalidKey", err)
+		}
+		if _, err := storage.PutObject(context.Background(), testBucket, "source.txt", "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if _, err := storage.CopyObject(context.Background(), testBucket, "source.txt", testBucket, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("CopyObject err = %v, want ErrInvalidKey", err)
+		}
+
+	})
+}
+
+// TestLegacyControlCharacterKey checks that an object written under a key
+// with a control character, before such keys were rejected, can still be
+// read and deleted
+func TestLegacyControlCharacterKey(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	const key = "report\n2026.txt"
+	objPath, err := storage.keyToPath(testBucket, key)
+	if err != nil {
+		t.Fatalf("keyToPath failed: %v", err)
+	}
+	if err := os.MkdirAll(objPath, 0700); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(objPath, "data"), []byte("content"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	meta := &s3.ObjectMetadata{
+		Key:          key,
+		Size:         7,
+		ETag:         fmt.Sprintf("\"%x\"", md5.Sum([]byte("content"))),
+		ContentType:  "text/plain",
+		LastModified: time.Now().UTC(),
+	}
+	if err := storage.meta.put(testBucket, objPath, meta); err != nil {
+		t.Fatalf("writing metadata failed: %v", err)
+	}
+
+	reader, _, err := storage.GetObject(context.Background(), testBucket, key)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	reader.Close()
+	if err := storage.DeleteObject(testBucket, key); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, err := storage.HeadObject(testBucket, key); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("HeadObject after delete err = %v, want ErrObjectNotFound", err)
+	}
 }
 
 func TestPathTraversalPrevention(t *testing.T) {
@@ -1168,7 +1907,7 @@ func TestPathTraversalPrevention(t *testing.T) {
 
 	for _, key := range traversalKeys {
 		t.Run("PutObject_"+key, func(t *testing.T) {
-			_, err := storage.PutObject(testBucket, key, "text/plain", nil, bytes.NewReader([]byte("test")))
+			_, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, bytes.NewReader([]byte("test")))
 			if err == nil {
 				t.Fatalf("PutObject(%q) should have failed with path traversal error", key)
 			}
@@ -1178,7 +1917,7 @@ func TestPathTraversalPrevention(t *testing.T) {
 		})
 
 		t.Run("GetObject_"+key, func(t *testing.T) {
-			_, _, err := storage.GetObject(testBucket, key)
+			_, _, err := storage.GetObject(context.Background(), testBucket, key)
 			if err == nil {
 				t.Fatalf("GetObject(%q) should have failed with path traversal error", key)
 			}
@@ -1218,7 +1957,7 @@ func TestPathTraversalPrevention(t *testing.T) {
 		})
 
 		t.Run("GetObjectRange_"+key, func(t *testing.T) {
-			_, _, err := storage.GetObjectRange(testBucket, key, 0, 10)
+			_, _, err := storage.GetObjectRange(context.Background(), testBucket, key, 0, 10)
 			if err == nil {
 				t.Fatalf("GetObjectRange(%q) should have failed with path traversal error", key)
 			}
@@ -1228,7 +1967,7 @@ func TestPathTraversalPrevention(t *testing.T) {
 		})
 
 		t.Run("CopyObject_src_"+key, func(t *testing.T) {
-			_, err := storage.CopyObject(testBucket, key, testBucket, "valid-dest.txt")
+			_, err := storage.CopyObject(context.Background(), testBucket, key, testBucket, "valid-dest.txt")
 			if err == nil {
 				t.Fatalf("CopyObject(%q, dst) should have failed with path traversal error", key)
 			}
@@ -1250,115 +1989,750 @@ func TestPathTraversalPrevention(t *testing.T) {
 }
 
 func TestCleanupStaleUploads(t *testing.T) {
-	storage, cleanup := setupTestStorage(t)
-	defer cleanup()
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		t.Run("no uploads to clean", func(t *testing.T) {
+			cleaned, err := storage.CleanupStaleUploads(time.Hour)
+			if err != nil {
+				t.Fatalf("CleanupStaleUploads failed: %v", err)
+			}
+			if cleaned != 0 {
+				t.Errorf("cleaned = %d, want 0", cleaned)
+			}
+		})
+
+		t.Run("cleans old uploads", func(t *testing.T) {
+			// Create an upload
+			uploadID, err := storage.CreateMultipartUpload(testBucket, "cleanup-test.txt", "text/plain", nil)
+			if err != nil {
+				t.Fatalf("CreateMultipartUpload failed: %v", err)
+			}
+
+			// Upload a part
+			_, err = storage.UploadPart(context.Background(), uploadID, 1, bytes.NewReader([]byte("test content")))
+			if err != nil {
+				t.Fatalf("UploadPart failed: %v", err)
+			}
+
+			// With a very short maxAge (0), it should clean up immediately
+			cleaned, err := storage.CleanupStaleUploads(0)
+			if err != nil {
+				t.Fatalf("CleanupStaleUploads failed: %v", err)
+			}
+			if cleaned != 1 {
+				t.Errorf("cleaned = %d, want 1", cleaned)
+			}
+
+			// Verify upload is gone
+			_, err = storage.GetMultipartUpload(uploadID)
+			if err == nil {
+				t.Error("expected upload to be cleaned up")
+			}
+		})
+
+		t.Run("keeps recent uploads", func(t *testing.T) {
+			// Create an upload
+			uploadID, err := storage.CreateMultipartUpload(testBucket, "keep-test.txt", "text/plain", nil)
+			if err != nil {
+				t.Fatalf("CreateMultipartUpload failed: %v", err)
+			}
+
+			// With a long maxAge, it should not clean up
+			cleaned, err := storage.CleanupStaleUploads(24 * time.Hour)
+			if err != nil {
+				t.Fatalf("CleanupStaleUploads failed: %v", err)
+			}
+			if cleaned != 0 {
+				t.Errorf("cleaned = %d, want 0", cleaned)
+			}
+
+			// Verify upload still exists
+			_, err = storage.GetMultipartUpload(uploadID)
+			if err != nil {
+				t.Error("upload should still exist")
+			}
+
+			// Clean up for next test
+			_ = storage.AbortMultipartUpload(uploadID)
+		})
+
+		t.Run("handles multiple uploads", func(t *testing.T) {
+			// Create multiple uploads
+			for i := 0; i < 3; i++ {
+				uploadID, err := storage.CreateMultipartUpload(testBucket, "multi-cleanup-"+string(rune('a'+i))+".txt", "text/plain", nil)
+				if err != nil {
+					t.Fatalf("CreateMultipartUpload failed: %v", err)
+				}
+				_, _ = storage.UploadPart(context.Background(), uploadID, 1, bytes.NewReader([]byte("content")))
+			}
+
+			// Clean all with maxAge 0
+			cleaned, err := storage.CleanupStaleUploads(0)
+			if err != nil {
+				t.Fatalf("CleanupStaleUploads failed: %v", err)
+			}
+			if cleaned != 3 {
+				t.Errorf("cleaned = %d, want 3", cleaned)
+			}
+		})
+
+		t.Run("handles nonexistent multipart directory", func(t *testing.T) {
+			// Create a fresh storage with a path that doesn't exist
+			tmpDir, _ := os.MkdirTemp("", "sss-cleanup-test-*")
+			defer os.RemoveAll(tmpDir)
+
+			basePath := filepath.Join(tmpDir, "data")
+			multipartPath := filepath.Join(tmpDir, "nonexistent-multipart")
+
+			// Create storage (creates directories)
+			s, _ := NewFilesystemStorage(basePath, multipartPath)
+
+			// Remove the multipart directory
+			os.RemoveAll(multipartPath)
+
+			// Should handle gracefully
+			cleaned, err := s.CleanupStaleUploads(time.Hour)
+			if err != nil {
+				t.Fatalf("CleanupStaleUploads should handle missing directory: %v", err)
+			}
+			if cleaned != 0 {
+				t.Errorf("cleaned = %d, want 0", cleaned)
+			}
+		})
+	})
+}
+
+func TestPutObjectLegalHold(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		key := "held.txt"
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+
+		if err := storage.PutObjectLegalHold(testBucket, key, s3.LegalHoldOn); err != nil {
+			t.Fatalf("PutObjectLegalHold failed: %v", err)
+		}
 
-	t.Run("no uploads to clean", func(t *testing.T) {
-		cleaned, err := storage.CleanupStaleUploads(time.Hour)
+		meta, err := storage.HeadObject(testBucket, key)
 		if err != nil {
-			t.Fatalf("CleanupStaleUploads failed: %v", err)
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if meta.ObjectLockLegalHold != s3.LegalHoldOn {
+			t.Errorf("ObjectLockLegalHold = %q, want %q", meta.ObjectLockLegalHold, s3.LegalHoldOn)
+		}
+
+		if err := storage.DeleteObject(testBucket, key); !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("DeleteObject error = %v, want ErrObjectLocked", err)
+		}
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("new")); !errors.Is(err, ErrObjectLocked) {
+			t.Errorf("PutObject error = %v, want ErrObjectLocked", err)
+		}
+
+		if err := storage.PutObjectLegalHold(testBucket, key, s3.LegalHoldOff); err != nil {
+			t.Fatalf("PutObjectLegalHold failed: %v", err)
+		}
+		if err := storage.DeleteObject(testBucket, key); err != nil {
+			t.Errorf("DeleteObject failed: %v", err)
 		}
-		if cleaned != 0 {
-			t.Errorf("cleaned = %d, want 0", cleaned)
+
+		if err := storage.PutObjectLegalHold(testBucket, "nonexistent", s3.LegalHoldOn); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("PutObjectLegalHold error = %v, want ErrObjectNotFound", err)
 		}
 	})
+}
 
-	t.Run("cleans old uploads", func(t *testing.T) {
-		// Create an upload
-		uploadID, err := storage.CreateMultipartUpload(testBucket, "cleanup-test.txt", "text/plain", nil)
-		if err != nil {
-			t.Fatalf("CreateMultipartUpload failed: %v", err)
+func TestOpenObject(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		if _, err := storage.PutObject(context.Background(), testBucket, "seek.txt", "text/plain", nil, strings.NewReader("0123456789")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
 		}
 
-		// Upload a part
-		_, err = storage.UploadPart(uploadID, 1, bytes.NewReader([]byte("test content")))
+		file, meta, err := storage.OpenObject(testBucket, "seek.txt")
 		if err != nil {
-			t.Fatalf("UploadPart failed: %v", err)
+			t.Fatalf("OpenObject failed: %v", err)
 		}
+		defer file.Close()
 
-		// With a very short maxAge (0), it should clean up immediately
-		cleaned, err := storage.CleanupStaleUploads(0)
-		if err != nil {
-			t.Fatalf("CleanupStaleUploads failed: %v", err)
+		if meta.Size != 10 {
+			t.Errorf("Size = %d, want 10", meta.Size)
 		}
-		if cleaned != 1 {
-			t.Errorf("cleaned = %d, want 1", cleaned)
+		if _, err := file.Seek(6, io.SeekStart); err != nil {
+			t.Fatalf("Seek failed: %v", err)
+		}
+		rest, _ := io.ReadAll(file)
+		if string(rest) != "6789" {
+			t.Errorf("read after seek = %q, want %q", rest, "6789")
 		}
 
-		// Verify upload is gone
-		_, err = storage.GetMultipartUpload(uploadID)
-		if err == nil {
-			t.Error("expected upload to be cleaned up")
+		if _, _, err := storage.OpenObject(testBucket, "missing.txt"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("OpenObject(missing) error = %v, want ErrObjectNotFound", err)
 		}
 	})
+}
+
+func TestCreateMultipartUploadIDs(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
 
-	t.Run("keeps recent uploads", func(t *testing.T) {
-		// Create an upload
-		uploadID, err := storage.CreateMultipartUpload(testBucket, "keep-test.txt", "text/plain", nil)
+	const uploads = 1000
+	seen := make(map[string]bool, uploads)
+	for i := 0; i < uploads; i++ {
+		uploadID, err := storage.CreateMultipartUpload(testBucket, "key", "text/plain", nil)
 		if err != nil {
 			t.Fatalf("CreateMultipartUpload failed: %v", err)
 		}
+		if seen[uploadID] {
+			t.Fatalf("duplicate upload ID %s after %d uploads", uploadID, i)
+		}
+		seen[uploadID] = true
 
-		// With a long maxAge, it should not clean up
-		cleaned, err := storage.CleanupStaleUploads(24 * time.Hour)
+		// Random (version 4) UUIDs carry 122 bits of entropy
+		id, err := uuid.Parse(uploadID)
 		if err != nil {
-			t.Fatalf("CleanupStaleUploads failed: %v", err)
+			t.Fatalf("upload ID %q is not a UUID: %v", uploadID, err)
 		}
-		if cleaned != 0 {
-			t.Errorf("cleaned = %d, want 0", cleaned)
+		if id.Version() != 4 || id.Variant() != uuid.RFC4122 {
+			t.Fatalf("upload ID %s is version %d variant %s, want a random RFC 4122 UUID", uploadID, id.Version(), id.Variant())
 		}
+	}
+}
 
-		// Verify upload still exists
-		_, err = storage.GetMultipartUpload(uploadID)
-		if err != nil {
-			t.Error("upload should still exist")
+func TestBucketPathOverride(t *testing.T) {
+	for _, mode := range []string{MetadataStoreJSON, MetadataStoreKV} {
+		t.Run(mode, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			basePath := filepath.Join(tmpDir, "data")
+			overridePath := filepath.Join(tmpDir, "volume2")
+
+			storage, err := NewFilesystemStorageWithOptions(basePath, filepath.Join(tmpDir, "multipart"), FilesystemOptions{
+				MetadataStore: mode,
+				BucketPaths:   map[string]string{"big-bucket": overridePath},
+			})
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+			for _, bucket := range []string{"big-bucket", "small-bucket"} {
+				if err := storage.CreateBucket(bucket); err != nil {
+					t.Fatalf("CreateBucket(%s) failed: %v", bucket, err)
+				}
+				if _, err := storage.PutObject(context.Background(), bucket, "dir/key.txt", "text/plain", nil, strings.NewReader("hello")); err != nil {
+					t.Fatalf("PutObject(%s) failed: %v", bucket, err)
+				}
+			}
+
+			objPath, err := storage.keyToPath("big-bucket", "dir/key.txt")
+			if err != nil {
+				t.Fatalf("keyToPath failed: %v", err)
+			}
+			if !strings.HasPrefix(objPath, filepath.Join(overridePath, "buckets", "big-bucket")+string(filepath.Separator)) {
+				t.Errorf("object path %s is not under the override path", objPath)
+			}
+			if data, err := os.ReadFile(filepath.Join(objPath, "data")); err != nil || string(data) != "hello" {
+				t.Errorf("data file on override path = %q, %v", data, err)
+			}
+			if _, err := os.Stat(filepath.Join(basePath, "buckets", "big-bucket")); !os.IsNotExist(err) {
+				t.Errorf("overridden bucket exists under the main path: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(basePath, "buckets", "small-bucket", "objects")); err != nil {
+				t.Errorf("bucket without override not under the main path: %v", err)
+			}
+
+			obj, _, err := storage.GetObject(context.Background(), "big-bucket", "dir/key.txt")
+			if err != nil {
+				t.Fatalf("GetObject failed: %v", err)
+			}
+			data, _ := io.ReadAll(obj)
+			obj.Close()
+			if string(data) != "hello" {
+				t.Errorf("GetObject = %q, want %q", data, "hello")
+			}
+			result, err := storage.ListObjects("big-bucket", ListObjectsOptions{})
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
+			if len(result.Objects) != 1 || result.Objects[0].Key != "dir/key.txt" {
+				t.Errorf("listed objects = %v", result.Objects)
+			}
+
+			names, err := storage.BucketNames()
+			if err != nil {
+				t.Fatalf("BucketNames failed: %v", err)
+			}
+			if want := []string{"big-bucket", "small-bucket"}; !slices.Equal(names, want) {
+				t.Errorf("BucketNames = %v, want %v", names, want)
+			}
+
+			if err := storage.DeleteObject("big-bucket", "dir/key.txt"); err != nil {
+				t.Fatalf("DeleteObject failed: %v", err)
+			}
+			if err := storage.DeleteBucket("big-bucket"); err != nil {
+				t.Fatalf("DeleteBucket failed: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(overridePath, "buckets", "big-bucket")); !os.IsNotExist(err) {
+				t.Errorf("bucket directory left on override path: %v", err)
+			}
+		})
+	}
+}
+
+func TestBucketPathOverrideNotWritable(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	tmpDir := t.TempDir()
+	readOnly := filepath.Join(tmpDir, "readonly")
+	if err := os.MkdirAll(filepath.Join(readOnly, "buckets"), 0500); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(readOnly, 0500); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(readOnly, "buckets"), 0500); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chmod(filepath.Join(readOnly, "buckets"), 0700)
+		os.Chmod(readOnly, 0700)
+	})
+
+	_, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), FilesystemOptions{
+		BucketPaths: map[string]string{"big-bucket": readOnly},
+	})
+	if err == nil {
+		t.Error("expected error for a read-only override path")
+	}
+}
+
+func TestBucketPathOverrideInvalidBucket(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), FilesystemOptions{
+		BucketPaths: map[string]string{"../escape": filepath.Join(tmpDir, "other")},
+	})
+	if !errors.Is(err, ErrInvalidBucketName) {
+		t.Errorf("err = %v, want ErrInvalidBucketName", err)
+	}
+}
+
+func TestListObjectsDelimiterPagination(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		keys := []string{"a.txt", "b/1", "b/2", "b/3", "c.txt", "d/1", "d/sub/2", "e/1", "f.txt"}
+		for _, key := range keys {
+			if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("x")); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+		}
+
+		paginate := func(opts ListObjectsOptions) []string {
+			t.Helper()
+			var entries []string
+			for page := 0; ; page++ {
+				if page > len(keys) {
+					t.Fatalf("pagination did not end")
+				}
+				result, err := storage.ListObjects(testBucket, opts)
+				if err != nil {
+					t.Fatalf("ListObjects failed: %v", err)
+				}
+				if n := len(result.Objects) + len(result.CommonPrefixes); n > opts.MaxKeys || (result.IsTruncated && n != opts.MaxKeys) {
+					t.Errorf("page %d has %d entries with max-keys %d", page, n, opts.MaxKeys)
+				}
+				for _, obj := range result.Objects {
+					entries = append(entries, obj.Key)
+				}
+				entries = append(entries, result.CommonPrefixes...)
+				if !result.IsTruncated {
+					return entries
+				}
+				opts.ContinuationToken = result.NextContinuationToken
+			}
+		}
+
+		for _, tc := range []struct {
+			prefix string
+			want   []string
+		}{
+			{"", []string{"a.txt", "b/", "c.txt", "d/", "e/", "f.txt"}},
+			{"d/", []string{"d/1", "d/sub/"}},
+		} {
+			for _, maxKeys := range []int{1, 2, 4} {
+				got := paginate(ListObjectsOptions{Prefix: tc.prefix, Delimiter: "/", MaxKeys: maxKeys})
+				slices.Sort(got)
+				if !slices.Equal(got, tc.want) {
+					t.Errorf("prefix %q, max-keys %d: listed %v, want %v", tc.prefix, maxKeys, got, tc.want)
+				}
+			}
+		}
+
+		t.Run("start-after inside a common prefix", func(t *testing.T) {
+			result, err := storage.ListObjects(testBucket, ListObjectsOptions{Delimiter: "/", StartAfter: "b/1"})
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
+			if len(result.CommonPrefixes) != 2 || result.CommonPrefixes[0] != "d/" {
+				t.Errorf("CommonPrefixes = %v, want [d/ e/]", result.CommonPrefixes)
+			}
+		})
+	})
+}
+
+func TestListObjectsFolderMarkers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		// "folder/" is a folder marker with an object below it, "empty/" one
+		// without
+		for _, key := range []string{"empty/", "folder/", "folder/a", "top"} {
+			if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("x")); err != nil {
+				t.Fatalf("PutObject(%q) failed: %v", key, err)
+			}
+		}
+
+		// list returns the objects and common prefixes of all pages, in order
+		list := func(prefix string, maxKeys int) (objects, prefixes []string) {
+			t.Helper()
+			opts := ListObjectsOptions{Prefix: prefix, Delimiter: "/", MaxKeys: maxKeys}
+			for page := 0; page < 10; page++ {
+				result, err := storage.ListObjects(testBucket, opts)
+				if err != nil {
+					t.Fatalf("ListObjects failed: %v", err)
+				}
+				for _, obj := range result.Objects {
+					objects = append(objects, obj.Key)
+				}
+				prefixes = append(prefixes, result.CommonPrefixes...)
+				if !result.IsTruncated {
+					return objects, prefixes
+				}
+				opts.ContinuationToken = result.NextContinuationToken
+			}
+			t.Fatalf("pagination did not end")
+			return nil, nil
+		}
+
+		// A marker is folded into its common prefix once, and listed as an
+		// object when the prefix is the marker itself
+		for _, tc := range []struct {
+			prefix       string
+			wantObjects  []string
+			wantPrefixes []string
+		}{
+			{"", []string{"top"}, []string{"empty/", "folder/"}},
+			{"folder", nil, []string{"folder/"}},
+			{"folder/", []string{"folder/", "folder/a"}, nil},
+			{"empty/", []string{"empty/"}, nil},
+		} {
+			for _, maxKeys := range []int{1, 1000} {
+				objects, prefixes := list(tc.prefix, maxKeys)
+				if !slices.Equal(objects, tc.wantObjects) || !slices.Equal(prefixes, tc.wantPrefixes) {
+					t.Errorf("prefix %q, max-keys %d: objects %v, prefixes %v, want %v and %v",
+						tc.prefix, maxKeys, objects, prefixes, tc.wantObjects, tc.wantPrefixes)
+				}
+			}
 		}
 
-		// Clean up for next test
-		_ = storage.AbortMultipartUpload(uploadID)
+		// The marker and the objects below it are independent
+		if err := storage.DeleteObject(testBucket, "folder/"); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+		if objects, _ := list("folder/", 1000); !slices.Equal(objects, []string{"folder/a"}) {
+			t.Errorf("after deleting the marker: objects %v, want [folder/a]", objects)
+		}
 	})
+}
 
-	t.Run("handles multiple uploads", func(t *testing.T) {
-		// Create multiple uploads
-		for i := 0; i < 3; i++ {
-			uploadID, err := storage.CreateMultipartUpload(testBucket, "multi-cleanup-"+string(rune('a'+i))+".txt", "text/plain", nil)
+func TestListObjectsETagAfterOverwrite(t *testing.T) {
+	check := func(t *testing.T, storage MultipartStorage) {
+		// etags lists the bucket and returns the ETag of every object
+		etags := func() map[string]string {
+			t.Helper()
+			result, err := storage.ListObjects(testBucket, ListObjectsOptions{})
 			if err != nil {
-				t.Fatalf("CreateMultipartUpload failed: %v", err)
+				t.Fatalf("ListObjects failed: %v", err)
+			}
+			etags := make(map[string]string)
+			for _, obj := range result.Objects {
+				etags[obj.Key] = obj.ETag
+			}
+			return etags
+		}
+
+		// Each overwrite is visible in the next listing
+		for _, body := range []string{"first", "second, longer content", "third"} {
+			if _, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader(body)); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
 			}
-			_, _ = storage.UploadPart(uploadID, 1, bytes.NewReader([]byte("content")))
+			want := fmt.Sprintf("\"%x\"", md5.Sum([]byte(body)))
+			if got := etags()["doc"]; got != want {
+				t.Errorf("after writing %q: listed ETag %s, want %s", body, got, want)
+			}
+		}
+
+		// Empty objects list with the MD5 of empty input
+		if _, err := storage.PutObject(context.Background(), testBucket, "empty", "text/plain", nil, strings.NewReader("")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
 		}
+		if got, want := etags()["empty"], `"d41d8cd98f00b204e9800998ecf8427e"`; got != want {
+			t.Errorf("empty object: listed ETag %s, want %s", got, want)
+		}
+	}
+
+	forEachBackend(t, check)
+	for name, opts := range map[string]FilesystemOptions{
+		"kv":     {MetadataStore: MetadataStoreKV},
+		"cached": {MetadataCacheEntries: 16},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			storage, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), opts)
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+			if err := storage.CreateBucket(testBucket); err != nil {
+				t.Fatalf("CreateBucket failed: %v", err)
+			}
+			check(t, storage)
+		})
+	}
+}
 
-		// Clean all with maxAge 0
-		cleaned, err := storage.CleanupStaleUploads(0)
+func TestImmutabilityWindow(t *testing.T) {
+	tmpDir := t.TempDir()
+	basePath, multipartPath := filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart")
+	open := func(window time.Duration) *FilesystemStorage {
+		t.Helper()
+		storage, err := NewFilesystemStorageWithOptions(basePath, multipartPath, FilesystemOptions{
+			ImmutabilityWindows: map[string]time.Duration{"ingest": window},
+		})
 		if err != nil {
-			t.Fatalf("CleanupStaleUploads failed: %v", err)
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		return storage
+	}
+
+	storage := open(time.Hour)
+	for _, bucket := range []string{"ingest", "other"} {
+		if err := storage.CreateBucket(bucket); err != nil {
+			t.Fatalf("CreateBucket(%s) failed: %v", bucket, err)
 		}
-		if cleaned != 3 {
-			t.Errorf("cleaned = %d, want 3", cleaned)
+		if _, err := storage.PutObject(context.Background(), bucket, "key", "text/plain", nil, strings.NewReader("v1")); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", bucket, err)
 		}
-	})
+	}
 
-	t.Run("handles nonexistent multipart directory", func(t *testing.T) {
-		// Create a fresh storage with a path that doesn't exist
-		tmpDir, _ := os.MkdirTemp("", "sss-cleanup-test-*")
-		defer os.RemoveAll(tmpDir)
+	// Within the window
+	if _, err := storage.PutObject(context.Background(), "ingest", "key", "text/plain", nil, strings.NewReader("v2")); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("overwrite within window: err = %v, want ErrObjectLocked", err)
+	}
+	if err := storage.DeleteObject("ingest", "key"); !errors.Is(err, ErrObjectImmutable) {
+		t.Errorf("delete within window: err = %v, want ErrObjectImmutable", err)
+	}
+	if err := storage.DeleteBucketWithOptions("ingest", DeleteBucketOptions{Force: true}); !errors.Is(err, ErrObjectImmutable) {
+		t.Errorf("force delete bucket within window: err = %v, want ErrObjectImmutable", err)
+	}
+	if _, err := storage.PutObject(context.Background(), "other", "key", "text/plain", nil, strings.NewReader("v2")); err != nil {
+		t.Errorf("overwrite in bucket without window failed: %v", err)
+	}
 
-		basePath := filepath.Join(tmpDir, "data")
-		multipartPath := filepath.Join(tmpDir, "nonexistent-multipart")
+	// After the window
+	storage = open(time.Nanosecond)
+	if _, err := storage.PutObject(context.Background(), "ingest", "key", "text/plain", nil, strings.NewReader("v2")); err != nil {
+		t.Errorf("overwrite after window failed: %v", err)
+	}
+	if err := storage.DeleteObject("ingest", "key"); err != nil {
+		t.Errorf("delete after window failed: %v", err)
+	}
+}
 
-		// Create storage (creates directories)
-		s, _ := NewFilesystemStorage(basePath, multipartPath)
+func TestDiskSpace(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
 
-		// Remove the multipart directory
-		os.RemoveAll(multipartPath)
+	data, multipart, err := storage.DiskSpace("")
+	if err != nil {
+		t.Fatalf("DiskSpace failed: %v", err)
+	}
+	for name, space := range map[string]DiskSpace{"data": data, "multipart": multipart} {
+		if space.Total == 0 || space.Free > space.Total {
+			t.Errorf("%s disk space = %+v, want 0 < free <= total", name, space)
+		}
+	}
 
-		// Should handle gracefully
-		cleaned, err := s.CleanupStaleUploads(time.Hour)
+	t.Run("bucket path override", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		mediaPath := filepath.Join(tmpDir, "media")
+		storage, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), FilesystemOptions{
+			BucketPaths: map[string]string{"media": mediaPath},
+		})
 		if err != nil {
-			t.Fatalf("CleanupStaleUploads should handle missing directory: %v", err)
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		// The override path is read for its bucket only
+		if err := os.RemoveAll(mediaPath); err != nil {
+			t.Fatal(err)
 		}
-		if cleaned != 0 {
-			t.Errorf("cleaned = %d, want 0", cleaned)
+		if _, _, err := storage.DiskSpace("media"); err == nil {
+			t.Error("DiskSpace(media) succeeded without the bucket path")
+		}
+		if _, _, err := storage.DiskSpace("other"); err != nil {
+			t.Errorf("DiskSpace(other) failed: %v", err)
 		}
 	})
 }
+
+// recordingSyncer records the files and directories it is asked to sync
+type recordingSyncer struct {
+	mu    sync.Mutex
+	files []string
+	dirs  []string
+}
+
+func (r *recordingSyncer) syncFile(f *os.File) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, f.Name())
+	return nil
+}
+
+func (r *recordingSyncer) syncDir(dir string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dirs = append(r.dirs, dir)
+	return nil
+}
+
+// synced reports whether a file under dir whose name starts with prefix,
+// and dir itself, were synced
+func (r *recordingSyncer) synced(dir, prefix string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.ContainsFunc(r.files, func(name string) bool {
+		return filepath.Dir(name) == dir && strings.HasPrefix(filepath.Base(name), prefix)
+	}) && slices.Contains(r.dirs, dir)
+}
+
+func TestDurableWrites(t *testing.T) {
+	for _, mode := range []string{MetadataStoreJSON, MetadataStoreKV} {
+		t.Run(mode, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			recorder := &recordingSyncer{}
+			storage, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), FilesystemOptions{
+				MetadataStore: mode,
+				syncer:        recorder,
+			})
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+			if err := storage.CreateBucket(testBucket); err != nil {
+				t.Fatalf("CreateBucket failed: %v", err)
+			}
+
+			if _, err := storage.PutObject(context.Background(), testBucket, "put.txt", "text/plain", nil, strings.NewReader("hello")); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			objPath, _ := storage.keyToPath(testBucket, "put.txt")
+			if !recorder.synced(objPath, "data.tmp.") {
+				t.Errorf("PutObject did not sync the data file and object directory")
+			}
+			if !slices.Contains(recorder.dirs, filepath.Dir(objPath)) {
+				t.Errorf("PutObject did not sync the hash prefix directory")
+			}
+			bucketPath := filepath.Join(tmpDir, "data", "buckets", testBucket)
+			if mode == MetadataStoreJSON && !recorder.synced(objPath, "meta.json.tmp.") {
+				t.Errorf("PutObject did not sync meta.json")
+			}
+			if mode == MetadataStoreJSON && !recorder.synced(bucketPath, keyIndexFile) {
+				t.Errorf("PutObject did not sync the key index")
+			}
+			if mode == MetadataStoreKV && !slices.Contains(recorder.files, filepath.Join(bucketPath, metadataLogFile)) {
+				t.Errorf("PutObject did not sync the metadata log")
+			}
+
+			uploadID, err := storage.CreateMultipartUpload(testBucket, "multipart.txt", "text/plain", nil)
+			if err != nil {
+				t.Fatalf("CreateMultipartUpload failed: %v", err)
+			}
+			uploadPath := filepath.Join(storage.multipartPath, uploadID)
+			if !recorder.synced(uploadPath, "meta.json") || !slices.Contains(recorder.dirs, storage.multipartPath) {
+				t.Errorf("CreateMultipartUpload did not sync the upload metadata")
+			}
+			part, err := storage.UploadPart(context.Background(), uploadID, 1, strings.NewReader("part"))
+			if err != nil {
+				t.Fatalf("UploadPart failed: %v", err)
+			}
+			if !recorder.synced(uploadPath, "part.00001.tmp") || !recorder.synced(uploadPath, "part.00001.meta") {
+				t.Errorf("UploadPart did not sync the part and its metadata")
+			}
+			if _, err := storage.CompleteMultipartUpload(context.Background(), uploadID, []s3.CompletedPartInput{{PartNumber: 1, ETag: part.ETag}}); err != nil {
+				t.Fatalf("CompleteMultipartUpload failed: %v", err)
+			}
+			objPath, _ = storage.keyToPath(testBucket, "multipart.txt")
+			if !recorder.synced(objPath, "data.tmp") {
+				t.Errorf("CompleteMultipartUpload did not sync the data file and object directory")
+			}
+		})
+	}
+}
+
+// cancelingReader returns zeros and cancels the context after n bytes
+type cancelingReader struct {
+	n      int64
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		r.cancel()
+	}
+	r.n -= int64(len(p))
+	clear(p)
+	return len(p), nil
+}
+
+func TestContextCancellation(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	// The body never ends; the upload stops once the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := storage.PutObject(ctx, testBucket, "canceled.bin", "application/octet-stream", nil, &cancelingReader{n: copyChunkSize, cancel: cancel})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("PutObject error = %v, want context.Canceled", err)
+	}
+	if _, err := storage.HeadObject(testBucket, "canceled.bin"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("HeadObject error = %v, want ErrObjectNotFound", err)
+	}
+	objPath, _ := storage.keyToPath(testBucket, "canceled.bin")
+	if entries, err := os.ReadDir(objPath); err == nil && len(entries) > 0 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+
+	uploadID, err := storage.CreateMultipartUpload(testBucket, "canceled-part.bin", "application/octet-stream", nil)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := storage.UploadPart(ctx, uploadID, 1, &cancelingReader{n: copyChunkSize, cancel: cancel}); !errors.Is(err, context.Canceled) {
+		t.Errorf("UploadPart error = %v, want context.Canceled", err)
+	}
+
+	content := []byte("hello, world")
+	if _, err := storage.PutObject(context.Background(), testBucket, "existing.txt", "text/plain", nil, bytes.NewReader(content)); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	reader, _, err := storage.GetObject(ctx, testBucket, "existing.txt")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer reader.Close()
+	cancel()
+	if _, err := io.ReadAll(reader); !errors.Is(err, context.Canceled) {
+		t.Errorf("read after cancel error = %v, want context.Canceled", err)
+	}
+
+	if _, err := storage.CopyObject(ctx, testBucket, "existing.txt", testBucket, "copy.txt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("CopyObject error = %v, want context.Canceled", err)
+	}
+	if _, err := storage.HeadObject(testBucket, "copy.txt"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("HeadObject error = %v, want ErrObjectNotFound", err)
+	}
+}
@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// keyIndexFile is the name of the per-bucket listing index in json mode
+const keyIndexFile = "keys.index"
+
+// keyIndex is a sorted set of the object keys in a bucket. ListObjects seeks
+// into it instead of reading the metadata of every object.
+type keyIndex struct {
+	mu   sync.RWMutex
+	keys []string
+}
+
+// add inserts key, reporting whether it was not already present
+func (x *keyIndex) add(key string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	i := sort.SearchStrings(x.keys, key)
+	if i < len(x.keys) && x.keys[i] == key {
+		return false
+	}
+	x.keys = append(x.keys, "")
+	copy(x.keys[i+1:], x.keys[i:])
+	x.keys[i] = key
+	return true
+}
+
+// remove deletes key, reporting whether it was present
+func (x *keyIndex) remove(key string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	i := sort.SearchStrings(x.keys, key)
+	if i == len(x.keys) || x.keys[i] != key {
+		return false
+	}
+	x.keys = append(x.keys[:i], x.keys[i+1:]...)
+	return true
+}
+
+func (x *keyIndex) contains(key string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	i := sort.SearchStrings(x.keys, key)
+	return i < len(x.keys) && x.keys[i] == key
+}
+
+func (x *keyIndex) len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.keys)
+}
+
+// snapshot returns a copy of the keys in order
+func (x *keyIndex) snapshot() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return append([]string(nil), x.keys...)
+}
+
+// scan calls fn with each key >= from in order while holding the read
+// lock, so fn must not modify the index. fn returns a non-empty seek to
+// skip ahead to the first key >= seek, or stop to end the scan.
+func (x *keyIndex) scan(from string, fn func(key string) (seek string, stop bool)) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	i := sort.SearchStrings(x.keys, from)
+	for i < len(x.keys) {
+		seek, stop := fn(x.keys[i])
+		if stop {
+			return
+		}
+		if seek != "" && seek > x.keys[i] {
+			i += sort.SearchStrings(x.keys[i:], seek)
+			continue
+		}
+		i++
+	}
+}
+
+// keyIndexRecord is one line of a key index file. A deleted record removes
+// the key.
+type keyIndexRecord struct {
+	Key     string `json:"key"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// persistentKeyIndex is a keyIndex backed by an append-only file. Writers
+// hold mu while appending; readers only take the keyIndex lock. Every
+// record is synced with syncer before the write it belongs to continues.
+type persistentKeyIndex struct {
+	keyIndex
+
+	mu      sync.Mutex
+	path    string
+	syncer  syncer
+	file    *os.File
+	records int
+	closed  bool
+}
+
+// errKeyIndexClosed is returned when writing to an index that was closed
+// after being dropped or rebuilt
+var errKeyIndexClosed = errors.New("key index closed")
+
+// openKeyIndex loads the key index at path. If the file does not exist it
+// is rebuilt from the keys returned by rebuild.
+func openKeyIndex(path string, s syncer, rebuild func() ([]string, error)) (*persistentKeyIndex, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0600)
+	if os.IsNotExist(err) {
+		keys, err := rebuild()
+		if err != nil {
+			return nil, fmt.Errorf("rebuilding key index: %w", err)
+		}
+		return writeKeyIndex(path, s, keys)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening key index: %w", err)
+	}
+
+	index := &persistentKeyIndex{path: path, syncer: s, file: file}
+	set := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), metadataLogMaxLine)
+	for scanner.Scan() {
+		index.records++
+		var record keyIndexRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.Deleted {
+			delete(set, record.Key)
+		} else {
+			set[record.Key] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading key index: %w", err)
+	}
+
+	index.keys = make([]string, 0, len(set))
+	for key := range set {
+		index.keys = append(index.keys, key)
+	}
+	sort.Strings(index.keys)
+	return index, nil
+}
+
+// writeKeyIndex atomically replaces the index at path with keys
+func writeKeyIndex(path string, s syncer, keys []string) (*persistentKeyIndex, error) {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	index := &persistentKeyIndex{path: path, syncer: s}
+	index.keys = sorted
+	if err := index.rewrite(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func (p *persistentKeyIndex) put(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return errKeyIndexClosed
+	}
+	if p.contains(key) {
+		return nil
+	}
+	if err := p.append(keyIndexRecord{Key: key}); err != nil {
+		return err
+	}
+	p.add(key)
+	return p.maybeCompact()
+}
+
+func (p *persistentKeyIndex) delete(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return errKeyIndexClosed
+	}
+	if !p.contains(key) {
+		return nil
+	}
+	if err := p.append(keyIndexRecord{Key: key, Deleted: true}); err != nil {
+		return err
+	}
+	p.remove(key)
+	return p.maybeCompact()
+}
+
+// append writes a record as a single line and syncs it, so that a key is
+// not lost from listings once its write is acknowledged (caller must hold
+// mu)
+func (p *persistentKeyIndex) append(record keyIndexRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding key index record: %w", err)
+	}
+	if _, err := p.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing key index: %w", err)
+	}
+	p.records++
+	if err := p.syncer.syncFile(p.file); err != nil {
+		return fmt.Errorf("syncing key index: %w", err)
+	}
+	return nil
+}
+
+// maybeCompact rewrites the index once superseded records outnumber live
+// keys (caller must hold mu)
+func (p *persistentKeyIndex) maybeCompact() error {
+	if p.records < metadataLogCompactMinRecords || p.records < 2*p.len() {
+		return nil
+	}
+	return p.rewrite()
+}
+
+// rewrite replaces the index file with one record per key (caller must
+// hold mu). The temp file becomes the live file after the rename. It is
+// synced before the rename and the directory after it, so that a crash
+// leaves either the old or the new index, never an empty one.
+func (p *persistentKeyIndex) rewrite() error {
+	tmpPath := p.path + ".tmp." + uuid.New().String()
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_APPEND|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("creating key index: %w", err)
+	}
+
+	keys := p.snapshot()
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	for _, key := range keys {
+		if err := encoder.Encode(keyIndexRecord{Key: key}); err != nil {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("writing key index: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("writing key index: %w", err)
+	}
+	if err := p.syncer.syncFile(tmpFile); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("syncing key index: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, p.path); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("renaming key index: %w", err)
+	}
+
+	if p.file != nil {
+		p.file.Close()
+	}
+	p.file = tmpFile
+	p.records = len(keys)
+
+	if err := p.syncer.syncDir(filepath.Dir(p.path)); err != nil {
+		return fmt.Errorf("syncing key index directory: %w", err)
+	}
+	return nil
+}
+
+func (p *persistentKeyIndex) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.file.Close()
+		p.closed = true
+	}
+}
+
+// prefixSuccessor returns the smallest string greater than every string
+// with the given prefix, or "" if there is none
+func prefixSuccessor(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
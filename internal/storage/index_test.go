@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeyIndex(t *testing.T) {
+	var index keyIndex
+	for _, key := range []string{"b", "a/2", "c", "a/1", "b"} {
+		index.add(key)
+	}
+	if got := strings.Join(index.snapshot(), ","); got != "a/1,a/2,b,c" {
+		t.Fatalf("keys = %s, want a/1,a/2,b,c", got)
+	}
+
+	if !index.remove("b") || index.remove("b") {
+		t.Error("remove should report whether the key was present")
+	}
+
+	var seen []string
+	index.scan("a/", func(key string) (string, bool) {
+		seen = append(seen, key)
+		if key == "a/1" {
+			return prefixSuccessor("a/"), false
+		}
+		return "", false
+	})
+	if got := strings.Join(seen, ","); got != "a/1,c" {
+		t.Errorf("scan with seek = %s, want a/1,c", got)
+	}
+}
+
+func TestPrefixSuccessor(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"a/", "a0"},
+		{"abc", "abd"},
+		{"a\xff", "b"},
+		{"\xff\xff", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := prefixSuccessor(tt.prefix); got != tt.want {
+			t.Errorf("prefixSuccessor(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestKeyIndexRebuiltWhenMissing(t *testing.T) {
+	tmpDir := t.TempDir()
+	basePath := filepath.Join(tmpDir, "data")
+	multipartPath := filepath.Join(tmpDir, "multipart")
+
+	storage, err := NewFilesystemStorage(basePath, multipartPath)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := storage.CreateBucket(testBucket); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	for _, key := range []string{"x/1", "x/2", "y"} {
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader(key)); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+	if err := storage.DeleteObject(testBucket, "x/2"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+
+	indexPath := filepath.Join(basePath, "buckets", testBucket, keyIndexFile)
+	if _, err := os.Stat(indexPath); err != nil {
+		t.Fatalf("key index was not written: %v", err)
+	}
+
+	// The index survives a restart
+	reopened, err := NewFilesystemStorage(basePath, multipartPath)
+	if err != nil {
+		t.Fatalf("failed to reopen storage: %v", err)
+	}
+	if keys := listKeys(t, reopened); strings.Join(keys, ",") != "x/1,y" {
+		t.Errorf("keys after reopen = %v, want [x/1 y]", keys)
+	}
+
+	// A missing index is rebuilt from the meta.json files
+	if err := os.Remove(indexPath); err != nil {
+		t.Fatalf("failed to remove key index: %v", err)
+	}
+	rebuilt, err := NewFilesystemStorage(basePath, multipartPath)
+	if err != nil {
+		t.Fatalf("failed to reopen storage: %v", err)
+	}
+	if keys := listKeys(t, rebuilt); strings.Join(keys, ",") != "x/1,y" {
+		t.Errorf("keys after rebuild = %v, want [x/1 y]", keys)
+	}
+	if _, err := os.Stat(indexPath); err != nil {
+		t.Errorf("key index was not rewritten: %v", err)
+	}
+}
+
+func TestKeyIndexConcurrentWrites(t *testing.T) {
+	for _, mode := range []string{MetadataStoreJSON, MetadataStoreKV} {
+		t.Run(mode, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			storage, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), FilesystemOptions{MetadataStore: mode})
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+			if err := storage.CreateBucket(testBucket); err != nil {
+				t.Fatalf("CreateBucket failed: %v", err)
+			}
+
+			const writers, perWriter = 8, 25
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWriter; i++ {
+						key := fmt.Sprintf("w%d/%03d", w, i)
+						if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader(key)); err != nil {
+							t.Errorf("PutObject(%s) failed: %v", key, err)
+							return
+						}
+						// Delete every other key again
+						if i%2 == 1 {
+							if err := storage.DeleteObject(testBucket, key); err != nil {
+								t.Errorf("DeleteObject(%s) failed: %v", key, err)
+								return
+							}
+						}
+					}
+				}(w)
+			}
+			// List concurrently with the writers
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					if _, err := storage.ListObjects(testBucket, ListObjectsOptions{Delimiter: "/"}); err != nil {
+						t.Errorf("ListObjects failed: %v", err)
+						return
+					}
+				}
+			}()
+			wg.Wait()
+
+			var all []string
+			opts := ListObjectsOptions{MaxKeys: 7}
+			for {
+				result, err := storage.ListObjects(testBucket, opts)
+				if err != nil {
+					t.Fatalf("ListObjects failed: %v", err)
+				}
+				for _, obj := range result.Objects {
+					all = append(all, obj.Key)
+				}
+				if !result.IsTruncated {
+					break
+				}
+				opts.ContinuationToken = result.NextContinuationToken
+			}
+
+			want := writers * (perWriter + 1) / 2
+			if len(all) != want {
+				t.Errorf("listed %d keys, want %d", len(all), want)
+			}
+			for i := 1; i < len(all); i++ {
+				if all[i-1] >= all[i] {
+					t.Fatalf("keys not in order: %q before %q", all[i-1], all[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCountObjects(t *testing.T) {
+	jsonStorage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	kvStorage, _ := setupKVStorage(t)
+
+	for name, storage := range map[string]*FilesystemStorage{"json": jsonStorage, "kv": kvStorage} {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"a", "b", "c", "a"} {
+				if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader(key)); err != nil {
+					t.Fatalf("PutObject(%s) failed: %v", key, err)
+				}
+			}
+			if err := storage.DeleteObject(testBucket, "b"); err != nil {
+				t.Fatalf("DeleteObject failed: %v", err)
+			}
+
+			count, err := storage.CountObjects(testBucket)
+			if err != nil {
+				t.Fatalf("CountObjects failed: %v", err)
+			}
+			if count != 2 {
+				t.Errorf("count = %d, want 2", count)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LayoutVersion is the on-disk layout version written by this code.
+//
+// Version history:
+//   - 1: object directories named by base64 URL encoding of the key, MD5 shard prefix
+//   - 2: object directories named by SHA-256 hex digest of the key, SHA-256 shard prefix
+const LayoutVersion = 2
+
+// layoutVersionFile is the name of the layout marker file in the data directory
+const layoutVersionFile = "layout_version"
+
+// ErrUnsupportedLayout is returned when the data directory uses a layout this code cannot serve
+var ErrUnsupportedLayout = errors.New("unsupported storage layout")
+
+// checkLayoutVersion verifies the layout marker in basePath, writing it when it
+// is missing and the existing data is known to match the current layout.
+func checkLayoutVersion(basePath string) error {
+	markerPath := filepath.Join(basePath, layoutVersionFile)
+
+	data, err := os.ReadFile(markerPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading layout version: %w", err)
+	}
+
+	if os.IsNotExist(err) {
+		// No marker: either a fresh data directory or one created before the
+		// marker existed. Only stamp it if every object is already in the
+		// current layout.
+		current, err := objectsMatchCurrentLayout(basePath)
+		if err != nil {
+			return fmt.Errorf("inspecting storage layout: %w", err)
+		}
+		if !current {
+			return fmt.Errorf("%w: data directory %s uses layout version 1, expected %d; stop the service and run migrate-sha256 -data %s",
+				ErrUnsupportedLayout, basePath, LayoutVersion, basePath)
+		}
+		return writeLayoutVersion(basePath)
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("%w: invalid layout version in %s", ErrUnsupportedLayout, markerPath)
+	}
+
+	if version < LayoutVersion {
+		return fmt.Errorf("%w: data directory %s uses layout version %d, expected %d; stop the service and run migrate-sha256 -data %s",
+			ErrUnsupportedLayout, basePath, version, LayoutVersion, basePath)
+	}
+	if version > LayoutVersion {
+		return fmt.Errorf("%w: data directory %s uses layout version %d, which is newer than supported version %d",
+			ErrUnsupportedLayout, basePath, version, LayoutVersion)
+	}
+
+	return nil
+}
+
+// writeLayoutVersion writes the current layout version marker to basePath
+func writeLayoutVersion(basePath string) error {
+	markerPath := filepath.Join(basePath, layoutVersionFile)
+	tmpPath := markerPath + ".tmp"
+
+	if err := os.WriteFile(tmpPath, []byte(strconv.Itoa(LayoutVersion)+"\n"), 0600); err != nil {
+		return fmt.Errorf("writing layout version: %w", err)
+	}
+	if err := os.Rename(tmpPath, markerPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("renaming layout version: %w", err)
+	}
+
+	return nil
+}
+
+// objectsMatchCurrentLayout reports whether every object directory under basePath
+// is named by a SHA-256 hex digest. Returns true for an empty data directory.
+func objectsMatchCurrentLayout(basePath string) (bool, error) {
+	bucketsPath := filepath.Join(basePath, "buckets")
+	buckets, err := os.ReadDir(bucketsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	for _, bucket := range buckets {
+		if !bucket.IsDir() {
+			continue
+		}
+		objectsPath := filepath.Join(bucketsPath, bucket.Name(), "objects")
+		prefixes, err := os.ReadDir(objectsPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return false, err
+		}
+
+		for _, prefix := range prefixes {
+			if !prefix.IsDir() {
+				continue
+			}
+			objects, err := os.ReadDir(filepath.Join(objectsPath, prefix.Name()))
+			if err != nil {
+				return false, err
+			}
+			for _, obj := range objects {
+				if obj.IsDir() && !isSHA256Hex(obj.Name()) {
+					return false, nil
+				}
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// isSHA256Hex reports whether name is a lowercase 64-character hex string
+func isSHA256Hex(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// ErrNoSuchLifecycleConfiguration is returned when a bucket has no
+// lifecycle configuration
+var ErrNoSuchLifecycleConfiguration = errors.New("lifecycle configuration not found")
+
+// lifecycleFile holds the lifecycle rules of a bucket
+const lifecycleFile = "lifecycle.json"
+
+// LifecycleRule is a bucket lifecycle rule. It applies to the objects whose
+// key starts with Prefix and that carry all of Tags.
+type LifecycleRule struct {
+	ID      string            `json:"id,omitempty"`
+	Enabled bool              `json:"enabled"`
+	Prefix  string            `json:"prefix,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+
+	// ExpirationDays expires objects this many days after they were last
+	// modified, rounded up to the next midnight UTC as in S3
+	ExpirationDays int `json:"expiration_days,omitempty"`
+	// ExpirationDate expires objects from this time on
+	ExpirationDate time.Time `json:"expiration_date,omitzero"`
+
+	// AbortIncompleteUploadDays aborts multipart uploads this many days
+	// after they were initiated
+	AbortIncompleteUploadDays int `json:"abort_incomplete_upload_days,omitempty"`
+}
+
+// LifecycleExpiration records an object removed by a lifecycle rule
+type LifecycleExpiration struct {
+	Bucket string
+	Key    string
+	RuleID string
+}
+
+// lifecycleConfig is the content of lifecycleFile
+type lifecycleConfig struct {
+	Rules []LifecycleRule `json:"rules"`
+}
+
+// matches reports whether the rule applies to an object
+func (r *LifecycleRule) matches(key string, tags map[string]string) bool {
+	if !strings.HasPrefix(key, r.Prefix) {
+		return false
+	}
+	for k, v := range r.Tags {
+		if tag, ok := tags[k]; !ok || tag != v {
+			return false
+		}
+	}
+	return true
+}
+
+// expires reports whether the rule expires an object last modified at
+// lastModified
+func (r *LifecycleRule) expires(lastModified, now time.Time) bool {
+	if r.ExpirationDays > 0 {
+		return !now.Before(afterDays(lastModified, r.ExpirationDays))
+	}
+	if !r.ExpirationDate.IsZero() {
+		return !now.Before(r.ExpirationDate)
+	}
+	return false
+}
+
+// afterDays adds days to t and rounds up to the next midnight UTC
+func afterDays(t time.Time, days int) time.Time {
+	return t.UTC().AddDate(0, 0, days).Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// abortsUpload reports whether a lifecycle rule aborts a multipart upload
+func abortsUpload(rules []LifecycleRule, upload *s3.MultipartUploadMetadata, now time.Time) bool {
+	for _, rule := range rules {
+		if !rule.Enabled || rule.AbortIncompleteUploadDays <= 0 || !strings.HasPrefix(upload.Key, rule.Prefix) {
+			continue
+		}
+		if !now.Before(afterDays(upload.Created, rule.AbortIncompleteUploadDays)) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBucketLifecycle returns the lifecycle rules of a bucket
+func (fs *FilesystemStorage) GetBucketLifecycle(bucket string) ([]LifecycleRule, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+
+	bucketPath := fs.bucketPath(bucket)
+	var config lifecycleConfig
+	if err := readJSONFile(filepath.Join(bucketPath, lifecycleFile), &config); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading lifecycle configuration: %w", err)
+		}
+		if _, err := os.Stat(filepath.Join(bucketPath, "objects")); os.IsNotExist(err) {
+			return nil, ErrBucketNotFound
+		}
+		return nil, ErrNoSuchLifecycleConfiguration
+	}
+	return config.Rules, nil
+}
+
+// PutBucketLifecycle replaces the lifecycle rules of a bucket
+func (fs *FilesystemStorage) PutBucketLifecycle(bucket string, rules []LifecycleRule) error {
+	bucketPath, err := fs.existingBucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.syncer, filepath.Join(bucketPath, lifecycleFile), lifecycleConfig{Rules: rules})
+}
+
+// DeleteBucketLifecycle removes the lifecycle rules of a bucket
+func (fs *FilesystemStorage) DeleteBucketLifecycle(bucket string) error {
+	bucketPath, err := fs.existingBucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(bucketPath, lifecycleFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing lifecycle configuration: %w", err)
+	}
+	return nil
+}
+
+// existingBucketPath returns the directory of a bucket that exists
+func (fs *FilesystemStorage) existingBucketPath(bucket string) (string, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return "", err
+	}
+	bucketPath := fs.bucketPath(bucket)
+	if _, err := os.Stat(filepath.Join(bucketPath, "objects")); err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrBucketNotFound
+		}
+		return "", fmt.Errorf("checking bucket directory: %w", err)
+	}
+	return bucketPath, nil
+}
+
+// ExpireObjects deletes the objects expired by the lifecycle rules of every
+// bucket. In a versioned bucket the delete inserts a delete marker. Objects
+// under a legal hold are kept. The objects deleted before an error are
+// returned with it.
+func (fs *FilesystemStorage) ExpireObjects(now time.Time) ([]LifecycleExpiration, error) {
+	return expireObjects(fs, now)
+}
+
+// expirer is the storage expireObjects deletes expired objects from
+type expirer interface {
+	BucketNames() ([]string, error)
+	GetBucketLifecycle(bucket string) ([]LifecycleRule, error)
+	ListObjects(bucket string, opts ListObjectsOptions) (*ListObjectsResult, error)
+	DeleteObject(bucket, key string) error
+}
+
+// expireObjects deletes the objects in s expired by lifecycle rules, as
+// described for ExpireObjects
+func expireObjects(s expirer, now time.Time) ([]LifecycleExpiration, error) {
+	buckets, err := s.BucketNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []LifecycleExpiration
+	for _, bucket := range buckets {
+		rules, err := s.GetBucketLifecycle(bucket)
+		if err != nil {
+			if errors.Is(err, ErrNoSuchLifecycleConfiguration) || errors.Is(err, ErrBucketNotFound) || errors.Is(err, ErrInvalidBucketName) {
+				continue
+			}
+			return expired, err
+		}
+		for _, rule := range rules {
+			if !rule.Enabled || (rule.ExpirationDays <= 0 && rule.ExpirationDate.IsZero()) {
+				continue
+			}
+			removed, err := expireRule(s, bucket, rule, now)
+			expired = append(expired, removed...)
+			if err != nil {
+				return expired, err
+			}
+		}
+	}
+	return expired, nil
+}
+
+// expireRule deletes the objects in a bucket expired by one rule
+func expireRule(s expirer, bucket string, rule LifecycleRule, now time.Time) ([]LifecycleExpiration, error) {
+	var expired []LifecycleExpiration
+	opts := ListObjectsOptions{Prefix: rule.Prefix}
+	for {
+		result, err := s.ListObjects(bucket, opts)
+		if err != nil {
+			return expired, err
+		}
+		for _, obj := range result.Objects {
+			if !rule.matches(obj.Key, obj.Tags) || !rule.expires(obj.LastModified, now) {
+				continue
+			}
+			if err := s.DeleteObject(bucket, obj.Key); err != nil {
+				if errors.Is(err, ErrObjectLocked) || errors.Is(err, ErrObjectNotFound) {
+					continue
+				}
+				return expired, err
+			}
+			expired = append(expired, LifecycleExpiration{Bucket: bucket, Key: obj.Key, RuleID: rule.ID})
+		}
+		if !result.IsTruncated {
+			return expired, nil
+		}
+		opts.ContinuationToken = result.NextContinuationToken
+	}
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+func TestBucketLifecycleConfiguration(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		if _, err := storage.GetBucketLifecycle(testBucket); !errors.Is(err, ErrNoSuchLifecycleConfiguration) {
+			t.Errorf("GetBucketLifecycle error = %v, want ErrNoSuchLifecycleConfiguration", err)
+		}
+		if _, err := storage.GetBucketLifecycle("missing-bucket"); !errors.Is(err, ErrBucketNotFound) {
+			t.Errorf("missing bucket error = %v, want ErrBucketNotFound", err)
+		}
+		if err := storage.PutBucketLifecycle("missing-bucket", nil); !errors.Is(err, ErrBucketNotFound) {
+			t.Errorf("PutBucketLifecycle on missing bucket error = %v, want ErrBucketNotFound", err)
+		}
+
+		rules := []LifecycleRule{{
+			ID:             "logs",
+			Enabled:        true,
+			Prefix:         "logs/",
+			Tags:           map[string]string{"class": "temp"},
+			ExpirationDate: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		}}
+		if err := storage.PutBucketLifecycle(testBucket, rules); err != nil {
+			t.Fatalf("PutBucketLifecycle failed: %v", err)
+		}
+		got, err := storage.GetBucketLifecycle(testBucket)
+		if err != nil {
+			t.Fatalf("GetBucketLifecycle failed: %v", err)
+		}
+		if len(got) != 1 || got[0].ID != "logs" || got[0].Tags["class"] != "temp" || !got[0].ExpirationDate.Equal(rules[0].ExpirationDate) {
+			t.Errorf("rules = %+v, want %+v", got, rules)
+		}
+
+		if err := storage.DeleteBucketLifecycle(testBucket); err != nil {
+			t.Fatalf("DeleteBucketLifecycle failed: %v", err)
+		}
+		if _, err := storage.GetBucketLifecycle(testBucket); !errors.Is(err, ErrNoSuchLifecycleConfiguration) {
+			t.Errorf("after delete error = %v, want ErrNoSuchLifecycleConfiguration", err)
+		}
+		// Deleting a missing configuration succeeds, as in S3
+		if err := storage.DeleteBucketLifecycle(testBucket); err != nil {
+			t.Errorf("second DeleteBucketLifecycle failed: %v", err)
+		}
+	})
+}
+
+func TestExpireObjects(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		put := func(key string, tags map[string]string) {
+			t.Helper()
+			if _, err := storage.PutObjectWithOptions(context.Background(), testBucket, key, "text/plain", nil, PutObjectOptions{Tags: tags}, strings.NewReader("data")); err != nil {
+				t.Fatalf("PutObject(%s) failed: %v", key, err)
+			}
+		}
+		put("logs/a.log", nil)
+		put("logs/b.log", nil)
+		put("logs/held.log", nil)
+		put("tmp/scratch", map[string]string{"class": "temp"})
+		put("tmp/keep", map[string]string{"class": "durable"})
+		put("data/file", nil)
+		if err := storage.PutObjectLegalHold(testBucket, "logs/held.log", s3.LegalHoldOn); err != nil {
+			t.Fatalf("PutObjectLegalHold failed: %v", err)
+		}
+
+		rules := []LifecycleRule{
+			{ID: "logs", Enabled: true, Prefix: "logs/", ExpirationDays: 7},
+			{ID: "temp", Enabled: true, Tags: map[string]string{"class": "temp"}, ExpirationDays: 1},
+			{ID: "disabled", Enabled: false, Prefix: "data/", ExpirationDays: 1},
+		}
+		if err := storage.PutBucketLifecycle(testBucket, rules); err != nil {
+			t.Fatalf("PutBucketLifecycle failed: %v", err)
+		}
+
+		// Nothing has expired yet
+		expired, err := storage.ExpireObjects(time.Now())
+		if err != nil || len(expired) != 0 {
+			t.Fatalf("ExpireObjects = %v, %v; want nothing expired", expired, err)
+		}
+
+		expired, err = storage.ExpireObjects(time.Now().AddDate(0, 0, 9))
+		if err != nil {
+			t.Fatalf("ExpireObjects failed: %v", err)
+		}
+		var got []string
+		for _, exp := range expired {
+			got = append(got, exp.RuleID+":"+exp.Key)
+		}
+		want := "logs:logs/a.log,logs:logs/b.log,temp:tmp/scratch"
+		if strings.Join(got, ",") != want {
+			t.Errorf("expired = %v, want %s", got, want)
+		}
+
+		if keys := strings.Join(listKeys(t, storage), ","); keys != "data/file,logs/held.log,tmp/keep" {
+			t.Errorf("remaining keys = %s", keys)
+		}
+	})
+}
+
+func TestExpireObjectsDate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		if _, err := storage.PutObject(context.Background(), testBucket, "old", "text/plain", nil, strings.NewReader("data")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		date := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		if err := storage.PutBucketLifecycle(testBucket, []LifecycleRule{{Enabled: true, ExpirationDate: date}}); err != nil {
+			t.Fatalf("PutBucketLifecycle failed: %v", err)
+		}
+
+		if expired, _ := storage.ExpireObjects(date.Add(-time.Second)); len(expired) != 0 {
+			t.Errorf("expired before date: %v", expired)
+		}
+		if expired, _ := storage.ExpireObjects(date); len(expired) != 1 {
+			t.Errorf("expired on date = %v, want 1 object", expired)
+		}
+	})
+}
+
+func TestLifecycleExpiryRoundsToMidnight(t *testing.T) {
+	rule := LifecycleRule{Enabled: true, ExpirationDays: 1}
+	lastModified := time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)
+
+	// One day later rounds up to the following midnight UTC
+	if rule.expires(lastModified, time.Date(2024, 3, 11, 23, 59, 59, 0, time.UTC)) {
+		t.Error("expired before midnight")
+	}
+	if !rule.expires(lastModified, time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Error("not expired at midnight")
+	}
+}
+
+func TestCleanupStaleUploadsLifecycle(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	rules := []LifecycleRule{{ID: "uploads", Enabled: true, Prefix: "big/", AbortIncompleteUploadDays: 1}}
+	if err := storage.PutBucketLifecycle(testBucket, rules); err != nil {
+		t.Fatalf("PutBucketLifecycle failed: %v", err)
+	}
+
+	// Backdate two uploads, one under the rule's prefix
+	backdate := func(key string) string {
+		t.Helper()
+		uploadID, err := storage.CreateMultipartUpload(testBucket, key, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("CreateMultipartUpload failed: %v", err)
+		}
+		upload, err := storage.GetMultipartUpload(uploadID)
+		if err != nil {
+			t.Fatalf("GetMultipartUpload failed: %v", err)
+		}
+		upload.Created = upload.Created.AddDate(0, 0, -3)
+		if err := writeFileAtomic(storage.syncer, filepath.Join(storage.multipartPath, uploadID, "meta.json"), upload); err != nil {
+			t.Fatalf("failed to backdate upload: %v", err)
+		}
+		return uploadID
+	}
+	aborted := backdate("big/file")
+	kept := backdate("small/file")
+
+	cleaned, err := storage.CleanupStaleUploads(7 * 24 * time.Hour)
+	if err != nil {
+		t.Fatalf("CleanupStaleUploads failed: %v", err)
+	}
+	if cleaned != 1 {
+		t.Errorf("cleaned = %d, want 1", cleaned)
+	}
+	if _, err := storage.GetMultipartUpload(aborted); err == nil {
+		t.Error("upload matching the lifecycle rule should be aborted")
+	}
+	if _, err := storage.GetMultipartUpload(kept); err != nil {
+		t.Errorf("upload outside the lifecycle rule should be kept: %v", err)
+	}
+}
@@ -0,0 +1,1178 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// MemoryStorage implements MultipartStorage in memory. Nothing is persisted,
+// so it is meant for tests and ephemeral setups. Keys, bucket names,
+// ETags, versioning and object locks behave as in FilesystemStorage.
+type MemoryStorage struct {
+	// immutabilityWindows maps buckets to the period after creation during
+	// which objects cannot be overwritten or deleted
+	immutabilityWindows map[string]time.Duration
+	// mu protects buckets and uploads. Request bodies are read before it is
+	// taken, so a slow client does not block other requests.
+	mu      sync.RWMutex
+	buckets map[string]*memoryBucket
+	uploads map[string]*memoryUpload
+}
+
+// memoryBucket is a bucket of a MemoryStorage
+type memoryBucket struct {
+	// objects are the current versions by key
+	objects map[string]*memoryObject
+	// index holds the keys of objects in order, for listing
+	index keyIndex
+	// versions are the noncurrent versions and delete markers by key
+	versions   map[string][]*memoryObject
+	versioning string
+
+	lifecycle    []LifecycleRule
+	hasLifecycle bool
+	cors         []CORSRule
+	hasCORS      bool
+}
+
+// memoryObject is a version of an object. Neither its metadata nor its data
+// is modified once stored, so readers can keep using it after the lock is
+// released; changes store a new memoryObject instead.
+type memoryObject struct {
+	meta s3.ObjectMetadata
+	data []byte
+}
+
+// memoryUpload is a multipart upload in progress
+type memoryUpload struct {
+	meta  s3.MultipartUploadMetadata
+	parts map[int]*memoryPart
+}
+
+// memoryPart is an uploaded part of a multipart upload
+type memoryPart struct {
+	meta s3.PartMetadata
+	data []byte
+}
+
+// MemoryOptions contains optional settings for MemoryStorage
+type MemoryOptions struct {
+	// ImmutabilityWindows maps bucket names to a grace period after an
+	// object's creation during which it cannot be overwritten or deleted
+	ImmutabilityWindows map[string]time.Duration
+}
+
+// NewMemoryStorage creates a new in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return NewMemoryStorageWithOptions(MemoryOptions{})
+}
+
+// NewMemoryStorageWithOptions creates a new in-memory storage with optional settings
+func NewMemoryStorageWithOptions(opts MemoryOptions) *MemoryStorage {
+	return &MemoryStorage{
+		immutabilityWindows: opts.ImmutabilityWindows,
+		buckets:             make(map[string]*memoryBucket),
+		uploads:             make(map[string]*memoryUpload),
+	}
+}
+
+// cloneMetadata returns a copy of meta that shares no maps with it
+func cloneMetadata(meta *s3.ObjectMetadata) *s3.ObjectMetadata {
+	clone := *meta
+	clone.UserMetadata = maps.Clone(meta.UserMetadata)
+	clone.Tags = maps.Clone(meta.Tags)
+	clone.PartSizes = slices.Clone(meta.PartSizes)
+	if meta.ObjectLockRetainUntilDate != nil {
+		until := *meta.ObjectLockRetainUntilDate
+		clone.ObjectLockRetainUntilDate = &until
+	}
+	return &clone
+}
+
+// memoryReader serves object data from memory
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+// CheckWritable always succeeds; memory is always writable
+func (m *MemoryStorage) CheckWritable() error {
+	return nil
+}
+
+// DiskSpace returns zero capacities, as no filesystem holds the data
+func (m *MemoryStorage) DiskSpace(bucket string) (data, multipart DiskSpace, err error) {
+	return DiskSpace{}, DiskSpace{}, nil
+}
+
+// CreateBucket creates a new bucket
+func (m *MemoryStorage) CreateBucket(name string) error {
+	if err := validateNewBucketName(name); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.buckets[name]; ok {
+		return ErrBucketAlreadyExists
+	}
+	m.buckets[name] = &memoryBucket{
+		objects:  make(map[string]*memoryObject),
+		versions: make(map[string][]*memoryObject),
+	}
+	return nil
+}
+
+// BucketExists checks if a bucket exists
+func (m *MemoryStorage) BucketExists(name string) (bool, error) {
+	if err := ValidateBucketName(name); err != nil {
+		return false, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.buckets[name]
+	return ok, nil
+}
+
+// BucketNames returns the names of all buckets in sorted order
+func (m *MemoryStorage) BucketNames() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Sorted(maps.Keys(m.buckets)), nil
+}
+
+// DeleteBucket deletes a bucket (must be empty)
+func (m *MemoryStorage) DeleteBucket(name string) error {
+	return m.DeleteBucketWithOptions(name, DeleteBucketOptions{})
+}
+
+// DeleteBucketWithOptions deletes a bucket. With opts.Force a non-empty
+// bucket is removed with all its objects, unless one is under legal hold.
+func (m *MemoryStorage) DeleteBucketWithOptions(name string, opts DeleteBucketOptions) error {
+	if err := ValidateBucketName(name); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[name]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	if len(b.objects) > 0 || len(b.versions) > 0 {
+		if !opts.Force {
+			return ErrBucketNotEmpty
+		}
+		// Noncurrent versions are deleted with the bucket too, so a hold or
+		// retention on any version prevents the deletion
+		now, window := time.Now(), m.immutabilityWindows[name]
+		for key, obj := range b.objects {
+			if err := bucketDeleteLock(&obj.meta, window, now); err != nil {
+				return fmt.Errorf("%w: %s", err, key)
+			}
+		}
+		for key, versions := range b.versions {
+			for _, obj := range versions {
+				if err := bucketDeleteLock(&obj.meta, window, now); err != nil {
+					return fmt.Errorf("%w: %s version %s", err, key, obj.meta.VersionID)
+				}
+			}
+		}
+	}
+
+	delete(m.buckets, name)
+	return nil
+}
+
+// BucketUsage returns the number of objects in a bucket and their total
+// size
+func (m *MemoryStorage) BucketUsage(bucket string) (BucketUsage, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return BucketUsage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return BucketUsage{}, ErrBucketNotFound
+	}
+	var usage BucketUsage
+	for _, obj := range b.objects {
+		usage.Objects++
+		usage.Bytes += obj.meta.Size
+	}
+	return usage, nil
+}
+
+// current returns the current version of key, or nil if the bucket or the
+// object does not exist (caller must hold mu)
+func (m *MemoryStorage) current(bucket, key string) *memoryObject {
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	return b.objects[key]
+}
+
+// checkNotLocked returns ErrObjectLocked if the object exists and is under
+// legal hold, or ErrObjectImmutable if it is within its bucket's
+// immutability window (caller must hold mu)
+func (m *MemoryStorage) checkNotLocked(bucket, key string) error {
+	if obj := m.current(bucket, key); obj != nil {
+		return lockError(&obj.meta, m.immutabilityWindows[bucket], time.Now())
+	}
+	return nil
+}
+
+// PutObject stores an object with the given key
+func (m *MemoryStorage) PutObject(ctx context.Context, bucket, key string, contentType string, metadata map[string]string, body io.Reader) (*s3.ObjectMetadata, error) {
+	return m.PutObjectWithOptions(ctx, bucket, key, contentType, metadata, PutObjectOptions{}, body)
+}
+
+// PutObjectWithOptions stores an object together with optional attributes
+func (m *MemoryStorage) PutObjectWithOptions(ctx context.Context, bucket, key string, contentType string, metadata map[string]string, opts PutObjectOptions, body io.Reader) (*s3.ObjectMetadata, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+	if err := ValidateNewKey(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	err := m.checkNotLocked(bucket, key)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate MD5 while reading
+	var data bytes.Buffer
+	hash := md5.New()
+	size, err := copyContext(ctx, io.MultiWriter(&data, hash), body)
+	if err != nil {
+		return nil, fmt.Errorf("writing object data: %w", err)
+	}
+
+	return m.putCurrent(bucket, &s3.ObjectMetadata{
+		Key:          key,
+		Size:         size,
+		ContentType:  contentType,
+		ETag:         fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil))),
+		UserMetadata: maps.Clone(metadata),
+
+		ServerSideEncryption: opts.ServerSideEncryption,
+		Tags:                 maps.Clone(opts.Tags),
+	}, data.Bytes())
+}
+
+// putCurrent makes data the current version of meta.Key. The object locks
+// are checked again, since a legal hold may have been set while the data
+// was read. In a versioned bucket the replaced version is kept.
+func (m *MemoryStorage) putCurrent(bucket string, meta *s3.ObjectMetadata, data []byte) (*s3.ObjectMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+	if err := m.checkNotLocked(bucket, meta.Key); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	meta.LastModified = now
+	meta.Created = now
+	if current, ok := b.objects[meta.Key]; ok {
+		// An overwrite keeps the creation time of the object it replaces
+		meta.Created = current.meta.Created
+		if meta.Created.IsZero() {
+			meta.Created = current.meta.LastModified
+		}
+	}
+
+	if b.versioning != "" {
+		b.archiveCurrent(meta.Key)
+		meta.VersionID = newVersionID(b.versioning)
+	}
+	b.objects[meta.Key] = &memoryObject{meta: *meta, data: data}
+	b.index.add(meta.Key)
+	return cloneMetadata(meta), nil
+}
+
+// UpdateMetadata replaces the content type, user metadata and tags of an
+// object, leaving its data and ETag unchanged
+func (m *MemoryStorage) UpdateMetadata(bucket, key, contentType string, metadata, tags map[string]string) (*s3.ObjectMetadata, error) {
+	return m.updateObjectAttributes(bucket, key, CopyObjectOptions{
+		ReplaceMetadata: true,
+		ContentType:     contentType,
+		Metadata:        metadata,
+		ReplaceTags:     true,
+		Tags:            tags,
+	})
+}
+
+// updateObjectAttributes replaces the metadata, tags and server-side
+// encryption of an object as selected by opts, leaving its data in place
+func (m *MemoryStorage) updateObjectAttributes(bucket, key string, opts CopyObjectOptions) (*s3.ObjectMetadata, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := m.current(bucket, key)
+	if current == nil {
+		return nil, ErrObjectNotFound
+	}
+	if err := m.checkNotLocked(bucket, key); err != nil {
+		return nil, err
+	}
+
+	meta := cloneMetadata(&current.meta)
+	if opts.ReplaceMetadata {
+		meta.ContentType, meta.UserMetadata = opts.ContentType, maps.Clone(opts.Metadata)
+	}
+	if opts.ReplaceTags {
+		meta.Tags = maps.Clone(opts.Tags)
+	}
+	if opts.ServerSideEncryption != "" {
+		meta.ServerSideEncryption = opts.ServerSideEncryption
+	}
+	// The update counts as an overwrite, which keeps the creation time
+	if meta.Created.IsZero() {
+		meta.Created = meta.LastModified
+	}
+	meta.LastModified = time.Now().UTC()
+
+	m.buckets[bucket].objects[key] = &memoryObject{meta: *meta, data: current.data}
+	return cloneMetadata(meta), nil
+}
+
+// GetObject retrieves an object by key. Reads fail once ctx is done.
+func (m *MemoryStorage) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, *s3.ObjectMetadata, error) {
+	reader, meta, err := m.OpenObject(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &contextReadCloser{ctx: ctx, ReadCloser: reader}, meta, nil
+}
+
+// OpenObject opens an object for reading at arbitrary offsets
+func (m *MemoryStorage) OpenObject(bucket, key string) (io.ReadSeekCloser, *s3.ObjectMetadata, error) {
+	obj, err := m.getCurrent(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return memoryReader{bytes.NewReader(obj.data)}, cloneMetadata(&obj.meta), nil
+}
+
+// getCurrent returns the current version of an object
+func (m *MemoryStorage) getCurrent(bucket, key string) (*memoryObject, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	obj := m.current(bucket, key)
+	if obj == nil {
+		return nil, ErrObjectNotFound
+	}
+	return obj, nil
+}
+
+// GetObjectRange retrieves a range of bytes from an object
+func (m *MemoryStorage) GetObjectRange(ctx context.Context, bucket, key string, start, end int64) (io.ReadCloser, *s3.ObjectMetadata, error) {
+	obj, err := m.getCurrent(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := obj.meta.Size
+
+	// Handle empty objects - no valid range exists
+	if size == 0 {
+		return nil, nil, fmt.Errorf("invalid range: object is empty")
+	}
+
+	// Validate range
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+	if start > end {
+		return nil, nil, fmt.Errorf("invalid range: start > end")
+	}
+
+	reader := io.NopCloser(bytes.NewReader(obj.data[start : end+1]))
+	return &contextReadCloser{ctx: ctx, ReadCloser: reader}, cloneMetadata(&obj.meta), nil
+}
+
+// HeadObject retrieves object metadata without the body
+func (m *MemoryStorage) HeadObject(bucket, key string) (*s3.ObjectMetadata, error) {
+	obj, err := m.getCurrent(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return cloneMetadata(&obj.meta), nil
+}
+
+// ObjectExists checks if an object exists
+func (m *MemoryStorage) ObjectExists(bucket, key string) (bool, error) {
+	_, err := m.getCurrent(bucket, key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteObject removes an object by key. In a versioned bucket the object
+// is hidden behind a delete marker instead.
+func (m *MemoryStorage) DeleteObject(bucket, key string) error {
+	_, err := m.DeleteObjectVersion(bucket, key, "")
+	return err
+}
+
+// PutObjectLegalHold sets the legal hold status of an object
+func (m *MemoryStorage) PutObjectLegalHold(bucket, key, status string) error {
+	if err := ValidateBucketName(bucket); err != nil {
+		return err
+	}
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj := m.current(bucket, key)
+	if obj == nil {
+		return ErrObjectNotFound
+	}
+	meta := cloneMetadata(&obj.meta)
+	meta.ObjectLockLegalHold = status
+	m.buckets[bucket].objects[key] = &memoryObject{meta: *meta, data: obj.data}
+	return nil
+}
+
+// ListObjects lists objects with optional prefix, delimiter, and pagination
+func (m *MemoryStorage) ListObjects(bucket string, opts ListObjectsOptions) (*ListObjectsResult, error) {
+	var objects []s3.ObjectMetadata
+	var commonPrefixes []string
+	result, err := m.ListObjectsFunc(bucket, opts, func(entry ListEntry) error {
+		if entry.Object != nil {
+			objects = append(objects, *entry.Object)
+		} else {
+			commonPrefixes = append(commonPrefixes, entry.CommonPrefix)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Objects = objects
+	result.CommonPrefixes = commonPrefixes
+	return result, nil
+}
+
+// ListObjectsFunc lists objects like ListObjects, but calls fn with each
+// object and common prefix in key order instead of collecting them
+func (m *MemoryStorage) ListObjectsFunc(bucket string, opts ListObjectsOptions, fn func(ListEntry) error) (*ListObjectsResult, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	b, ok := m.buckets[bucket]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	return listObjects(&b.index, opts, func(key string) (*s3.ObjectMetadata, error) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		obj, ok := b.objects[key]
+		if !ok {
+			return nil, ErrObjectNotFound
+		}
+		return cloneMetadata(&obj.meta), nil
+	}, fn)
+}
+
+// CountObjects returns the number of objects in a bucket
+func (m *MemoryStorage) CountObjects(bucket string) (int, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return 0, ErrBucketNotFound
+	}
+	return b.index.len(), nil
+}
+
+// CopyObject copies an object from source key to destination key
+func (m *MemoryStorage) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) (*s3.ObjectMetadata, error) {
+	return m.CopyObjectWithOptions(ctx, srcBucket, srcKey, dstBucket, dstKey, CopyObjectOptions{})
+}
+
+// CopyObjectWithOptions copies an object, keeping the source's metadata,
+// tags and server-side encryption unless opts replaces them. Copying an
+// object onto itself in a bucket without versioning only updates these
+// attributes.
+func (m *MemoryStorage) CopyObjectWithOptions(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, opts CopyObjectOptions) (*s3.ObjectMetadata, error) {
+	if srcBucket == dstBucket && srcKey == dstKey {
+		status, err := m.GetBucketVersioning(dstBucket)
+		if err != nil {
+			return nil, err
+		}
+		if status == "" {
+			return m.updateObjectAttributes(dstBucket, dstKey, opts)
+		}
+	}
+
+	srcReader, srcMeta, err := m.OpenObject(srcBucket, srcKey)
+	if err != nil {
+		return nil, err
+	}
+	defer srcReader.Close()
+
+	contentType, metadata := srcMeta.ContentType, srcMeta.UserMetadata
+	if opts.ReplaceMetadata {
+		contentType, metadata = opts.ContentType, opts.Metadata
+	}
+	tags := srcMeta.Tags
+	if opts.ReplaceTags {
+		tags = opts.Tags
+	}
+	sse := srcMeta.ServerSideEncryption
+	if opts.ServerSideEncryption != "" {
+		sse = opts.ServerSideEncryption
+	}
+
+	dstMeta, err := m.PutObjectWithOptions(ctx, dstBucket, dstKey, contentType, metadata, PutObjectOptions{ServerSideEncryption: sse, Tags: tags}, srcReader)
+	if err != nil {
+		return nil, fmt.Errorf("copying object: %w", err)
+	}
+	return dstMeta, nil
+}
+
+// GetBucketVersioning returns the versioning status of a bucket
+func (m *MemoryStorage) GetBucketVersioning(bucket string) (string, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return "", ErrBucketNotFound
+	}
+	return b.versioning, nil
+}
+
+// PutBucketVersioning enables or suspends versioning for a bucket
+func (m *MemoryStorage) PutBucketVersioning(bucket, status string) error {
+	if status != VersioningEnabled && status != VersioningSuspended {
+		return ErrInvalidVersioningStatus
+	}
+	if err := ValidateBucketName(bucket); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	b.versioning = status
+	return nil
+}
+
+// HeadObjectVersion retrieves the metadata of a specific version of an object
+func (m *MemoryStorage) HeadObjectVersion(bucket, key, versionID string) (*s3.ObjectMetadata, error) {
+	obj, err := m.getVersion(bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return cloneMetadata(&obj.meta), nil
+}
+
+// OpenObjectVersion opens a specific version of an object
+func (m *MemoryStorage) OpenObjectVersion(bucket, key, versionID string) (io.ReadSeekCloser, *s3.ObjectMetadata, error) {
+	obj, err := m.getVersion(bucket, key, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := cloneMetadata(&obj.meta)
+	if meta.DeleteMarker {
+		return nil, meta, ErrDeleteMarker
+	}
+	return memoryReader{bytes.NewReader(obj.data)}, meta, nil
+}
+
+// getVersion returns a version of an object
+func (m *MemoryStorage) getVersion(bucket, key, versionID string) (*memoryObject, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, ErrNoSuchVersion
+	}
+	obj, _ := b.findVersion(key, versionID)
+	if obj == nil {
+		return nil, ErrNoSuchVersion
+	}
+	return obj, nil
+}
+
+// DeleteObjectVersion deletes an object version. Without a version ID it
+// deletes the current version: in a versioned bucket the version is kept and
+// a delete marker is returned. With a version ID that version is removed for
+// good, and the previous version becomes current if the latest is gone.
+// The deleted version's metadata is returned.
+func (m *MemoryStorage) DeleteObjectVersion(bucket, key, versionID string) (*s3.ObjectMetadata, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	if b.versioning == "" && (versionID == "" || versionID == NullVersionID) {
+		if err := m.checkNotLocked(bucket, key); err != nil {
+			return nil, err
+		}
+		b.removeCurrent(key)
+		return nil, nil
+	}
+
+	window := m.immutabilityWindows[bucket]
+	if versionID == "" {
+		return b.insertDeleteMarker(key, window)
+	}
+
+	obj, i := b.findVersion(key, versionID)
+	if obj == nil {
+		return nil, ErrNoSuchVersion
+	}
+	// Removing a delete marker brings the object back rather than losing data
+	if !obj.meta.DeleteMarker {
+		if err := lockError(&obj.meta, window, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if i < 0 {
+		b.removeCurrent(key)
+	} else {
+		b.removeVersionAt(key, i)
+	}
+	b.restoreLatestVersion(key)
+	return cloneMetadata(&obj.meta), nil
+}
+
+// ListObjectVersions lists every version and delete marker in a bucket,
+// ordered by key and then newest first
+func (m *MemoryStorage) ListObjectVersions(bucket string, opts ListObjectVersionsOptions) (*ListObjectVersionsResult, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+	if opts.MaxKeys <= 0 || opts.MaxKeys > 1000 {
+		opts.MaxKeys = 1000
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	versions := make(map[string][]s3.ObjectMetadata)
+	for key, obj := range b.objects {
+		if strings.HasPrefix(key, opts.Prefix) {
+			versions[key] = append(versions[key], *cloneMetadata(&obj.meta))
+		}
+	}
+	for key, noncurrent := range b.versions {
+		if strings.HasPrefix(key, opts.Prefix) {
+			for _, obj := range noncurrent {
+				versions[key] = append(versions[key], *cloneMetadata(&obj.meta))
+			}
+		}
+	}
+	return listVersions(versions, opts), nil
+}
+
+// findVersion returns a version of key and its position in b.versions[key],
+// -1 for the current version, or nil if there is no such version
+func (b *memoryBucket) findVersion(key, versionID string) (*memoryObject, int) {
+	if current, ok := b.objects[key]; ok {
+		if current.meta.VersionID == versionID || (current.meta.VersionID == "" && versionID == NullVersionID) {
+			return current, -1
+		}
+	}
+	if !validVersionID(versionID) {
+		return nil, 0
+	}
+	for i, obj := range b.versions[key] {
+		if obj.meta.VersionID == versionID {
+			return obj, i
+		}
+	}
+	return nil, 0
+}
+
+// archiveCurrent keeps the current version of key as a noncurrent version
+// before it is replaced. While versioning is suspended the null version is
+// replaced instead, as in S3.
+func (b *memoryBucket) archiveCurrent(key string) {
+	if b.versioning == VersioningSuspended {
+		if obj, i := b.findVersion(key, NullVersionID); obj != nil && i >= 0 {
+			b.removeVersionAt(key, i)
+		}
+	}
+
+	current, ok := b.objects[key]
+	if !ok {
+		return
+	}
+	if current.meta.VersionID == "" {
+		meta := cloneMetadata(&current.meta)
+		meta.VersionID = NullVersionID
+		current = &memoryObject{meta: *meta, data: current.data}
+	}
+	if b.versioning == VersioningSuspended && current.meta.VersionID == NullVersionID {
+		return
+	}
+	b.versions[key] = append(b.versions[key], current)
+}
+
+// insertDeleteMarker hides the current version of key behind a new delete
+// marker. window is the immutability window of the bucket.
+func (b *memoryBucket) insertDeleteMarker(key string, window time.Duration) (*s3.ObjectMetadata, error) {
+	// A suspended bucket replaces the null version, which must not be locked
+	if b.versioning == VersioningSuspended {
+		if obj, _ := b.findVersion(key, NullVersionID); obj != nil && !obj.meta.DeleteMarker {
+			if err := lockError(&obj.meta, window, time.Now()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	b.archiveCurrent(key)
+	b.removeCurrent(key)
+
+	marker := &memoryObject{meta: s3.ObjectMetadata{
+		Key:          key,
+		LastModified: time.Now().UTC(),
+		VersionID:    newVersionID(b.versioning),
+		DeleteMarker: true,
+	}}
+	b.versions[key] = append(b.versions[key], marker)
+	return cloneMetadata(&marker.meta), nil
+}
+
+// removeCurrent removes the current version of key, leaving its noncurrent
+// versions in place
+func (b *memoryBucket) removeCurrent(key string) {
+	delete(b.objects, key)
+	b.index.remove(key)
+}
+
+// removeVersionAt removes the noncurrent version of key at position i
+func (b *memoryBucket) removeVersionAt(key string, i int) {
+	versions := slices.Delete(b.versions[key], i, i+1)
+	if len(versions) == 0 {
+		delete(b.versions, key)
+		return
+	}
+	b.versions[key] = versions
+}
+
+// restoreLatestVersion makes the newest noncurrent version current when
+// key has no current version, unless that version is a delete marker
+func (b *memoryBucket) restoreLatestVersion(key string) {
+	if _, ok := b.objects[key]; ok || len(b.versions[key]) == 0 {
+		return
+	}
+
+	metas := make([]s3.ObjectMetadata, len(b.versions[key]))
+	for i, obj := range b.versions[key] {
+		metas[i] = obj.meta
+	}
+	sortVersions(metas)
+	latest, i := b.findVersion(key, metas[0].VersionID)
+	if latest.meta.DeleteMarker {
+		return
+	}
+
+	b.removeVersionAt(key, i)
+	b.objects[key] = latest
+	b.index.add(key)
+}
+
+// GetBucketLifecycle returns the lifecycle rules of a bucket
+func (m *MemoryStorage) GetBucketLifecycle(bucket string) ([]LifecycleRule, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+	if !b.hasLifecycle {
+		return nil, ErrNoSuchLifecycleConfiguration
+	}
+	return slices.Clone(b.lifecycle), nil
+}
+
+// PutBucketLifecycle replaces the lifecycle rules of a bucket
+func (m *MemoryStorage) PutBucketLifecycle(bucket string, rules []LifecycleRule) error {
+	return m.updateBucket(bucket, func(b *memoryBucket) {
+		b.lifecycle, b.hasLifecycle = slices.Clone(rules), true
+	})
+}
+
+// DeleteBucketLifecycle removes the lifecycle rules of a bucket
+func (m *MemoryStorage) DeleteBucketLifecycle(bucket string) error {
+	return m.updateBucket(bucket, func(b *memoryBucket) {
+		b.lifecycle, b.hasLifecycle = nil, false
+	})
+}
+
+// ExpireObjects deletes the objects expired by the lifecycle rules of every
+// bucket. In a versioned bucket the delete inserts a delete marker. Objects
+// under a legal hold are kept.
+func (m *MemoryStorage) ExpireObjects(now time.Time) ([]LifecycleExpiration, error) {
+	return expireObjects(m, now)
+}
+
+// GetBucketCORS returns the CORS rules of a bucket
+func (m *MemoryStorage) GetBucketCORS(bucket string) ([]CORSRule, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+	if !b.hasCORS {
+		return nil, ErrNoSuchCORSConfiguration
+	}
+	return slices.Clone(b.cors), nil
+}
+
+// PutBucketCORS replaces the CORS rules of a bucket
+func (m *MemoryStorage) PutBucketCORS(bucket string, rules []CORSRule) error {
+	return m.updateBucket(bucket, func(b *memoryBucket) {
+		b.cors, b.hasCORS = slices.Clone(rules), true
+	})
+}
+
+// DeleteBucketCORS removes the CORS rules of a bucket
+func (m *MemoryStorage) DeleteBucketCORS(bucket string) error {
+	return m.updateBucket(bucket, func(b *memoryBucket) {
+		b.cors, b.hasCORS = nil, false
+	})
+}
+
+// updateBucket calls fn with a bucket that exists while holding mu
+func (m *MemoryStorage) updateBucket(bucket string, fn func(*memoryBucket)) error {
+	if err := ValidateBucketName(bucket); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	fn(b)
+	return nil
+}
+
+// CreateMultipartUpload initializes a new multipart upload
+func (m *MemoryStorage) CreateMultipartUpload(bucket, key string, contentType string, metadata map[string]string) (string, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return "", err
+	}
+	if err := ValidateNewKey(key); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for attempt := 0; attempt < uploadIDAttempts; attempt++ {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return "", fmt.Errorf("generating upload ID: %w", err)
+		}
+		uploadID := id.String()
+		if _, ok := m.uploads[uploadID]; ok {
+			continue
+		}
+		m.uploads[uploadID] = &memoryUpload{
+			meta: s3.MultipartUploadMetadata{
+				UploadID:     uploadID,
+				Bucket:       bucket,
+				Key:          key,
+				Created:      time.Now().UTC(),
+				ContentType:  contentType,
+				UserMetadata: maps.Clone(metadata),
+			},
+			parts: make(map[int]*memoryPart),
+		}
+		return uploadID, nil
+	}
+	return "", fmt.Errorf("creating upload: no unused upload ID after %d attempts", uploadIDAttempts)
+}
+
+// UploadPart stores a part of a multipart upload
+func (m *MemoryStorage) UploadPart(ctx context.Context, uploadID string, partNumber int, body io.Reader) (*s3.PartMetadata, error) {
+	m.mu.RLock()
+	_, ok := m.uploads[uploadID]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+
+	var data bytes.Buffer
+	hash := md5.New()
+	size, err := copyContext(ctx, io.MultiWriter(&data, hash), body)
+	if err != nil {
+		return nil, fmt.Errorf("writing part data: %w", err)
+	}
+
+	part := &memoryPart{
+		meta: s3.PartMetadata{
+			PartNumber: partNumber,
+			ETag:       fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil))),
+			Size:       size,
+		},
+		data: data.Bytes(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The upload may have been completed or aborted while the part was read
+	upload, ok := m.uploads[uploadID]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	upload.parts[partNumber] = part
+	partMeta := part.meta
+	return &partMeta, nil
+}
+
+// CompleteMultipartUpload assembles all parts into the final object
+func (m *MemoryStorage) CompleteMultipartUpload(ctx context.Context, uploadID string, parts []s3.CompletedPartInput) (*s3.ObjectMetadata, error) {
+	m.mu.RLock()
+	upload, ok := m.uploads[uploadID]
+	if !ok {
+		m.mu.RUnlock()
+		return nil, ErrUploadNotFound
+	}
+	bucket, key := upload.meta.Bucket, upload.meta.Key
+	err := m.checkNotLocked(bucket, key)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate parts are in order
+	for i := 1; i < len(parts); i++ {
+		if parts[i].PartNumber <= parts[i-1].PartNumber {
+			return nil, ErrInvalidPartOrder
+		}
+	}
+
+	// Verify all parts exist and ETags match, then concatenate them
+	m.mu.RLock()
+	stored := make([]*memoryPart, len(parts))
+	for i, part := range parts {
+		stored[i] = upload.parts[part.PartNumber]
+	}
+	m.mu.RUnlock()
+
+	var partHashes [][]byte
+	var partSizes []int64
+	var data bytes.Buffer
+	for i, part := range parts {
+		if stored[i] == nil {
+			return nil, fmt.Errorf("part %d: %w", part.PartNumber, ErrPartNotFound)
+		}
+		hashBytes, err := partHash(part, stored[i].meta.ETag)
+		if err != nil {
+			return nil, err
+		}
+		partHashes = append(partHashes, hashBytes)
+		partSizes = append(partSizes, int64(len(stored[i].data)))
+		if _, err := copyContext(ctx, &data, bytes.NewReader(stored[i].data)); err != nil {
+			return nil, fmt.Errorf("copying part %d: %w", part.PartNumber, err)
+		}
+	}
+
+	m.mu.Lock()
+	// Completing and aborting the upload concurrently must not both succeed
+	_, ok = m.uploads[uploadID]
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+
+	objMeta, err := m.putCurrent(bucket, &s3.ObjectMetadata{
+		Key:          key,
+		Size:         int64(data.Len()),
+		ContentType:  upload.meta.ContentType,
+		ETag:         multipartETag(partHashes),
+		UserMetadata: maps.Clone(upload.meta.UserMetadata),
+		PartSizes:    partSizes,
+	}, data.Bytes())
+	if err != nil {
+		// Keep the upload, so that the client can retry
+		m.mu.Lock()
+		m.uploads[uploadID] = upload
+		m.mu.Unlock()
+		return nil, err
+	}
+	return objMeta, nil
+}
+
+// AbortMultipartUpload cancels a multipart upload and discards its parts
+func (m *MemoryStorage) AbortMultipartUpload(uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.uploads[uploadID]; !ok {
+		return ErrUploadNotFound
+	}
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+// GetMultipartUpload retrieves metadata about a multipart upload
+func (m *MemoryStorage) GetMultipartUpload(uploadID string) (*s3.MultipartUploadMetadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	upload, ok := m.uploads[uploadID]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	meta := upload.meta
+	meta.UserMetadata = maps.Clone(meta.UserMetadata)
+	return &meta, nil
+}
+
+// ListParts returns the parts uploaded for a multipart upload
+func (m *MemoryStorage) ListParts(uploadID string) ([]s3.PartMetadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	upload, ok := m.uploads[uploadID]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	parts := make([]s3.PartMetadata, 0, len(upload.parts))
+	for _, part := range upload.parts {
+		parts = append(parts, part.meta)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+// CleanupStaleUploads removes multipart uploads older than maxAge, and
+// uploads aborted by an AbortIncompleteUploadDays lifecycle rule of their
+// bucket. Returns the number of uploads cleaned up
+func (m *MemoryStorage) CleanupStaleUploads(maxAge time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	cutoff := now.Add(-maxAge)
+	cleaned := 0
+	for uploadID, upload := range m.uploads {
+		stale := upload.meta.Created.Before(cutoff)
+		if b, ok := m.buckets[upload.meta.Bucket]; ok && !stale {
+			stale = abortsUpload(b.lifecycle, &upload.meta, now)
+		}
+		if stale {
+			delete(m.uploads, uploadID)
+			cleaned++
+		}
+	}
+	return cleaned, nil
+}
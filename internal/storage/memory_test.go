@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+var _ MultipartStorage = (*MemoryStorage)(nil)
+
+// setupMemoryStorage creates an in-memory storage with testBucket
+func setupMemoryStorage(t *testing.T) *MemoryStorage {
+	t.Helper()
+
+	storage := NewMemoryStorage()
+	if err := storage.CreateBucket(testBucket); err != nil {
+		t.Fatalf("failed to create test bucket: %v", err)
+	}
+	return storage
+}
+
+// forEachBackend runs fn as a subtest against each storage backend, each
+// with testBucket created
+func forEachBackend(t *testing.T, fn func(t *testing.T, storage MultipartStorage)) {
+	t.Helper()
+
+	t.Run("filesystem", func(t *testing.T) {
+		storage, cleanup := setupTestStorage(t)
+		defer cleanup()
+		fn(t, storage)
+	})
+	t.Run("memory", func(t *testing.T) {
+		fn(t, setupMemoryStorage(t))
+	})
+}
+
+func TestMemoryStorageBuckets(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	if err := storage.CreateBucket("Invalid_Bucket"); !errors.Is(err, ErrInvalidBucketName) {
+		t.Errorf("CreateBucket with invalid name: err = %v, want ErrInvalidBucketName", err)
+	}
+	for _, name := range []string{"zeta", "alpha"} {
+		if err := storage.CreateBucket(name); err != nil {
+			t.Fatalf("CreateBucket(%s) failed: %v", name, err)
+		}
+	}
+	if err := storage.CreateBucket("alpha"); !errors.Is(err, ErrBucketAlreadyExists) {
+		t.Errorf("CreateBucket twice: err = %v, want ErrBucketAlreadyExists", err)
+	}
+	if names, _ := storage.BucketNames(); !slices.Equal(names, []string{"alpha", "zeta"}) {
+		t.Errorf("BucketNames = %v, want [alpha zeta]", names)
+	}
+	if _, err := storage.PutObject(context.Background(), "missing", "key", "text/plain", nil, strings.NewReader("data")); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("PutObject in missing bucket: err = %v, want ErrBucketNotFound", err)
+	}
+
+	if _, err := storage.PutObject(context.Background(), "alpha", "held", "text/plain", nil, strings.NewReader("data")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if usage, err := storage.BucketUsage("alpha"); err != nil || usage != (BucketUsage{Objects: 1, Bytes: 4}) {
+		t.Errorf("BucketUsage = %+v, %v, want 1 object of 4 bytes", usage, err)
+	}
+	if err := storage.DeleteBucket("alpha"); !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("DeleteBucket non-empty: err = %v, want ErrBucketNotEmpty", err)
+	}
+	if err := storage.PutObjectLegalHold("alpha", "held", s3.LegalHoldOn); err != nil {
+		t.Fatalf("PutObjectLegalHold failed: %v", err)
+	}
+	if err := storage.DeleteBucketWithOptions("alpha", DeleteBucketOptions{Force: true}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("force delete with legal hold: err = %v, want ErrObjectLocked", err)
+	}
+	if err := storage.PutObjectLegalHold("alpha", "held", s3.LegalHoldOff); err != nil {
+		t.Fatalf("PutObjectLegalHold failed: %v", err)
+	}
+	if err := storage.DeleteBucketWithOptions("alpha", DeleteBucketOptions{Force: true}); err != nil {
+		t.Errorf("force delete failed: %v", err)
+	}
+	if exists, _ := storage.BucketExists("alpha"); exists {
+		t.Error("bucket should not exist after force delete")
+	}
+}
+
+func TestMemoryStorageForceDeleteNoncurrentHold(t *testing.T) {
+	storage := setupMemoryStorage(t)
+	if err := storage.PutBucketVersioning(testBucket, VersioningEnabled); err != nil {
+		t.Fatalf("PutBucketVersioning failed: %v", err)
+	}
+	if _, err := storage.PutObject(context.Background(), testBucket, "held", "text/plain", nil, strings.NewReader("data")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := storage.PutObjectLegalHold(testBucket, "held", s3.LegalHoldOn); err != nil {
+		t.Fatalf("PutObjectLegalHold failed: %v", err)
+	}
+	// The delete marker makes the held version noncurrent
+	if err := storage.DeleteObject(testBucket, "held"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+
+	if err := storage.DeleteBucketWithOptions(testBucket, DeleteBucketOptions{Force: true}); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("force delete with a held noncurrent version: err = %v, want ErrObjectLocked", err)
+	}
+}
+
+// TestMemoryStorageConcurrentLegalHold checks under the race detector that
+// changing a legal hold does not modify metadata that readers are copying
+func TestMemoryStorageConcurrentLegalHold(t *testing.T) {
+	storage := setupMemoryStorage(t)
+	if _, err := storage.PutObject(context.Background(), testBucket, "key", "text/plain", nil, strings.NewReader("data")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			status := s3.LegalHoldOn
+			if i%2 == 1 {
+				status = s3.LegalHoldOff
+			}
+			if err := storage.PutObjectLegalHold(testBucket, "key", status); err != nil {
+				t.Errorf("PutObjectLegalHold failed: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := storage.HeadObject(testBucket, "key"); err != nil {
+				t.Errorf("HeadObject failed: %v", err)
+				return
+			}
+			reader, _, err := storage.OpenObject(testBucket, "key")
+			if err != nil {
+				t.Errorf("OpenObject failed: %v", err)
+				return
+			}
+			reader.Close()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestMemoryStorageImmutabilityWindow(t *testing.T) {
+	storage := NewMemoryStorageWithOptions(MemoryOptions{
+		ImmutabilityWindows: map[string]time.Duration{testBucket: time.Hour},
+	})
+	if err := storage.CreateBucket(testBucket); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := storage.PutObject(context.Background(), testBucket, "key", "text/plain", nil, strings.NewReader("v1")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	if _, err := storage.PutObject(context.Background(), testBucket, "key", "text/plain", nil, strings.NewReader("v2")); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("overwrite within window: err = %v, want ErrObjectLocked", err)
+	}
+	if err := storage.DeleteObject(testBucket, "key"); !errors.Is(err, ErrObjectImmutable) {
+		t.Errorf("delete within window: err = %v, want ErrObjectImmutable", err)
+	}
+	if err := storage.DeleteBucketWithOptions(testBucket, DeleteBucketOptions{Force: true}); !errors.Is(err, ErrObjectImmutable) {
+		t.Errorf("force delete bucket within window: err = %v, want ErrObjectImmutable", err)
+	}
+}
+
+func TestMemoryStorageReturnsCopies(t *testing.T) {
+	storage := setupMemoryStorage(t)
+
+	metadata := map[string]string{"author": "test"}
+	meta, err := storage.PutObject(context.Background(), testBucket, "key", "text/plain", metadata, strings.NewReader("data"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// Changing the caller's map or the returned metadata must not change
+	// the stored object
+	metadata["author"] = "changed"
+	meta.UserMetadata["author"] = "changed"
+	head, err := storage.HeadObject(testBucket, "key")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if head.UserMetadata["author"] != "test" {
+		t.Errorf("UserMetadata[author] = %q, want %q", head.UserMetadata["author"], "test")
+	}
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// MetadataCacheStats counts the lookups served by the metadata cache
+type MetadataCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// MetadataCacher is implemented by storage that caches object metadata
+type MetadataCacher interface {
+	// MetadataCacheStats returns the cache counters, and false when the
+	// cache is disabled
+	MetadataCacheStats() (MetadataCacheStats, bool)
+}
+
+// cachedMetadataStore is a metadataStore that keeps the most recently used
+// object metadata in memory, so that reads of hot keys do not open and
+// parse meta.json each time. Writes go through to the wrapped store and
+// then update the cache.
+//
+// Reads are not serialized with writes, so a read that misses may load
+// metadata that a concurrent write replaces before the read caches it.
+// Each write bumps the generation of its key's stripe, and a read only
+// caches what it loaded if the generation is unchanged.
+type cachedMetadataStore struct {
+	metadataStore
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *metadataCacheEntry, most recently used first
+	gens    [objectLockStripes]uint64
+	hits    uint64
+	misses  uint64
+}
+
+// metadataCacheEntry is the cached metadata of one object
+type metadataCacheEntry struct {
+	bucket string
+	id     string
+	meta   *s3.ObjectMetadata
+}
+
+func newCachedMetadataStore(meta metadataStore, maxEntries int) *cachedMetadataStore {
+	return &cachedMetadataStore{
+		metadataStore: meta,
+		maxEntries:    maxEntries,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+	}
+}
+
+// cacheID returns the cache key of an object. Keys cannot contain a null
+// byte, so IDs of different buckets cannot collide.
+func cacheID(bucket, key string) string {
+	return bucket + "\x00" + key
+}
+
+// cacheStripe returns the generation stripe of a cache ID
+func cacheStripe(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % objectLockStripes)
+}
+
+// get returns the cached metadata of key, reading it from the wrapped store
+// on a miss. A lookup without a key, as done when verifying the layout,
+// always reads the store.
+func (c *cachedMetadataStore) get(bucket, key, objPath string) (*s3.ObjectMetadata, error) {
+	if key == "" {
+		return c.metadataStore.get(bucket, key, objPath)
+	}
+	id := cacheID(bucket, key)
+	stripe := cacheStripe(id)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[id]; ok {
+		c.lru.MoveToFront(elem)
+		c.hits++
+		meta := cloneMetadata(elem.Value.(*metadataCacheEntry).meta)
+		c.mu.Unlock()
+		return meta, nil
+	}
+	c.misses++
+	gen := c.gens[stripe]
+	c.mu.Unlock()
+
+	meta, err := c.metadataStore.get(bucket, key, objPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[stripe] == gen {
+		c.store(bucket, id, meta)
+	}
+	return meta, nil
+}
+
+func (c *cachedMetadataStore) put(bucket, objPath string, meta *s3.ObjectMetadata) error {
+	err := c.metadataStore.put(bucket, objPath, meta)
+
+	id := cacheID(bucket, meta.Key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[cacheStripe(id)]++
+	if err != nil {
+		// The stored metadata is unknown after a failed write
+		c.remove(id)
+		return err
+	}
+	c.store(bucket, id, meta)
+	return nil
+}
+
+func (c *cachedMetadataStore) delete(bucket, key, objPath string) error {
+	err := c.metadataStore.delete(bucket, key, objPath)
+
+	id := cacheID(bucket, key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[cacheStripe(id)]++
+	c.remove(id)
+	return err
+}
+
+func (c *cachedMetadataStore) dropBucket(bucket string) {
+	c.metadataStore.dropBucket(bucket)
+	c.removeBucket(bucket)
+}
+
+func (c *cachedMetadataStore) reload(bucket string) error {
+	err := c.metadataStore.reload(bucket)
+	c.removeBucket(bucket)
+	return err
+}
+
+// removeBucket removes the cached metadata of every object in a bucket
+func (c *cachedMetadataStore) removeBucket(bucket string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.gens {
+		c.gens[i]++
+	}
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if entry := elem.Value.(*metadataCacheEntry); entry.bucket == bucket {
+			c.remove(entry.id)
+		}
+		elem = next
+	}
+}
+
+// store caches a copy of meta, evicting the least recently used entry when
+// the cache is full (caller must hold mu)
+func (c *cachedMetadataStore) store(bucket, id string, meta *s3.ObjectMetadata) {
+	if elem, ok := c.entries[id]; ok {
+		elem.Value.(*metadataCacheEntry).meta = cloneMetadata(meta)
+		c.lru.MoveToFront(elem)
+		return
+	}
+	if c.lru.Len() >= c.maxEntries {
+		c.remove(c.lru.Back().Value.(*metadataCacheEntry).id)
+	}
+	c.entries[id] = c.lru.PushFront(&metadataCacheEntry{bucket: bucket, id: id, meta: cloneMetadata(meta)})
+}
+
+// remove removes an entry from the cache (caller must hold mu)
+func (c *cachedMetadataStore) remove(id string) {
+	if elem, ok := c.entries[id]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, id)
+	}
+}
+
+// stats returns the cache counters
+func (c *cachedMetadataStore) stats() MetadataCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return MetadataCacheStats{Hits: c.hits, Misses: c.misses, Entries: c.lru.Len()}
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+func setupCachedStorage(t *testing.T, entries int) *FilesystemStorage {
+	t.Helper()
+	tmpDir := t.TempDir()
+	storage, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), FilesystemOptions{
+		MetadataCacheEntries: entries,
+	})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := storage.CreateBucket(testBucket); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	return storage
+}
+
+func TestMetadataCacheDisabled(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	if _, enabled := storage.MetadataCacheStats(); enabled {
+		t.Error("MetadataCacheStats reports the cache as enabled")
+	}
+}
+
+func TestMetadataCacheHits(t *testing.T) {
+	storage := setupCachedStorage(t, 10)
+	ctx := context.Background()
+
+	if _, err := storage.PutObject(ctx, testBucket, "key", "text/plain", map[string]string{"a": "1"}, strings.NewReader("v1")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	for range 3 {
+		meta, err := storage.HeadObject(testBucket, "key")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if meta.UserMetadata["a"] != "1" {
+			t.Errorf("Metadata = %v, want a=1", meta.UserMetadata)
+		}
+		// Callers must not be able to change the cached metadata
+		meta.UserMetadata["a"] = "changed"
+	}
+
+	stats, enabled := storage.MetadataCacheStats()
+	if !enabled {
+		t.Fatal("MetadataCacheStats reports the cache as disabled")
+	}
+	if stats.Hits != 3 || stats.Entries != 1 {
+		t.Errorf("stats = %+v, want 3 hits and 1 entry", stats)
+	}
+
+	// Objects that do not exist are not cached
+	if _, err := storage.HeadObject(testBucket, "missing"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("HeadObject(missing) err = %v, want ErrObjectNotFound", err)
+	}
+	if after, _ := storage.MetadataCacheStats(); after.Misses != stats.Misses+1 || after.Entries != 1 {
+		t.Errorf("stats = %+v, want one more miss and 1 entry", after)
+	}
+}
+
+func TestMetadataCacheInvalidation(t *testing.T) {
+	storage := setupCachedStorage(t, 10)
+	ctx := context.Background()
+
+	if _, err := storage.PutObject(ctx, testBucket, "key", "text/plain", nil, strings.NewReader("v1")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if _, err := storage.HeadObject(testBucket, "key"); err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+
+	t.Run("overwrite", func(t *testing.T) {
+		put, err := storage.PutObject(ctx, testBucket, "key", "text/plain", nil, strings.NewReader("version 2"))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		meta, err := storage.HeadObject(testBucket, "key")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if meta.ETag != put.ETag || meta.Size != int64(len("version 2")) {
+			t.Errorf("HeadObject = %s/%d, want %s/%d", meta.ETag, meta.Size, put.ETag, len("version 2"))
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		if _, err := storage.PutObject(ctx, testBucket, "src", "application/json", nil, strings.NewReader("{}")); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+		if _, err := storage.CopyObject(ctx, testBucket, "src", testBucket, "key"); err != nil {
+			t.Fatalf("CopyObject failed: %v", err)
+		}
+		meta, err := storage.HeadObject(testBucket, "key")
+		if err != nil {
+			t.Fatalf("HeadObject failed: %v", err)
+		}
+		if meta.ContentType != "application/json" || meta.Size != 2 {
+			t.Errorf("HeadObject = %s/%d, want the copied object", meta.ContentType, meta.Size)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := storage.DeleteObject(testBucket, "key"); err != nil {
+			t.Fatalf("DeleteObject failed: %v", err)
+		}
+		if _, err := storage.HeadObject(testBucket, "key"); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("HeadObject after delete err = %v, want ErrObjectNotFound", err)
+		}
+	})
+}
+
+func TestMetadataCacheEviction(t *testing.T) {
+	storage := setupCachedStorage(t, 2)
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := storage.PutObject(ctx, testBucket, key, "text/plain", nil, strings.NewReader(key)); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+	if stats, _ := storage.MetadataCacheStats(); stats.Entries != 2 {
+		t.Errorf("Entries = %d, want 2", stats.Entries)
+	}
+
+	// "a" was evicted first and has to be read from disk
+	before, _ := storage.MetadataCacheStats()
+	if _, err := storage.HeadObject(testBucket, "a"); err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	after, _ := storage.MetadataCacheStats()
+	if after.Misses != before.Misses+1 {
+		t.Errorf("Misses = %d, want %d", after.Misses, before.Misses+1)
+	}
+}
+
+func TestMetadataCacheDeleteBucket(t *testing.T) {
+	storage := setupCachedStorage(t, 10)
+	ctx := context.Background()
+
+	if _, err := storage.PutObject(ctx, testBucket, "key", "text/plain", nil, strings.NewReader("data")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := storage.DeleteObject(testBucket, "key"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if err := storage.DeleteBucket(testBucket); err != nil {
+		t.Fatalf("DeleteBucket failed: %v", err)
+	}
+	if stats, _ := storage.MetadataCacheStats(); stats.Entries != 0 {
+		t.Errorf("Entries = %d, want 0", stats.Entries)
+	}
+}
+
+// racingMetadataStore lets a test run a write while a read of the wrapped
+// store is in progress
+type racingMetadataStore struct {
+	metadataStore
+	duringGet func()
+}
+
+func (r *racingMetadataStore) get(bucket, key, objPath string) (*s3.ObjectMetadata, error) {
+	meta, err := r.metadataStore.get(bucket, key, objPath)
+	if r.duringGet != nil {
+		fn := r.duringGet
+		r.duringGet = nil
+		fn()
+	}
+	return meta, err
+}
+
+func TestMetadataCacheConcurrentOverwrite(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	if _, err := storage.PutObject(ctx, testBucket, "key", "text/plain", nil, strings.NewReader("v1")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	racing := &racingMetadataStore{metadataStore: storage.meta}
+	cache := newCachedMetadataStore(racing, 10)
+	objPath, err := storage.keyToPath(testBucket, "key")
+	if err != nil {
+		t.Fatalf("keyToPath failed: %v", err)
+	}
+
+	// The read loads v1, then a write of v2 completes before the read
+	// stores what it loaded
+	var written *s3.ObjectMetadata
+	racing.duringGet = func() {
+		meta, err := racing.metadataStore.get(testBucket, "key", objPath)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		meta.ETag = `"v2"`
+		if err := cache.put(testBucket, objPath, meta); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+		written = meta
+	}
+	stale, err := cache.get(testBucket, "key", objPath)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if stale.ETag == written.ETag {
+		t.Fatal("the read did not race with the write")
+	}
+
+	meta, err := cache.get(testBucket, "key", objPath)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if meta.ETag != written.ETag {
+		t.Errorf("ETag = %s, want %s", meta.ETag, written.ETag)
+	}
+}
+
+func TestMetadataCacheBypassWithoutKey(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	cache := newCachedMetadataStore(storage.meta, 10)
+	cache.get(testBucket, "", storage.bucketPath(testBucket))
+	if stats := cache.stats(); stats.Hits+stats.Misses != 0 || stats.Entries != 0 {
+		t.Errorf("stats = %+v, want no lookups", stats)
+	}
+}
@@ -0,0 +1,947 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// Metadata store modes
+const (
+	// MetadataStoreJSON keeps a meta.json file next to each object's data file
+	MetadataStoreJSON = "json"
+	// MetadataStoreKV keeps the metadata of all objects in a bucket in a single
+	// append-only log, loaded into memory on first use
+	MetadataStoreKV = "kv"
+)
+
+// metadataStoreFile is the name of the metadata store marker file in the data directory
+const metadataStoreFile = "metadata_store"
+
+// metadataLogFile is the name of the per-bucket metadata log in kv mode
+const metadataLogFile = "metadata.log"
+
+// ErrMetadataStoreMismatch is returned when the data directory was written with another metadata store
+var ErrMetadataStoreMismatch = errors.New("metadata store mismatch")
+
+// metadataStore persists object metadata. objPath is the object's directory
+// as returned by keyToPath; stores that keep metadata elsewhere ignore it.
+type metadataStore interface {
+	get(bucket, key, objPath string) (*s3.ObjectMetadata, error)
+	put(bucket, objPath string, meta *s3.ObjectMetadata) error
+	delete(bucket, key, objPath string) error
+	list(bucket string) ([]s3.ObjectMetadata, error)
+	// dropBucket releases any state held for a bucket that was deleted
+	dropBucket(bucket string)
+	// reload discards cached state for a bucket and reads it again from disk
+	reload(bucket string) error
+	// compact rewrites the stored metadata of a bucket without superseded records
+	compact(bucket string) error
+	// index returns the sorted index of the object keys in a bucket
+	index(bucket string) (*keyIndex, error)
+}
+
+// newMetadataStore returns the metadata store for a mode
+func newMetadataStore(basePath string, bucketPaths map[string]string, mode string, s syncer) (metadataStore, error) {
+	switch mode {
+	case "", MetadataStoreJSON:
+		return &jsonMetadataStore{basePath: basePath, bucketPaths: bucketPaths, syncer: s, indexes: make(map[string]*persistentKeyIndex)}, nil
+	case MetadataStoreKV:
+		return &kvMetadataStore{basePath: basePath, bucketPaths: bucketPaths, syncer: s, logs: make(map[string]*metadataLog)}, nil
+	default:
+		return nil, fmt.Errorf("unknown metadata store %q", mode)
+	}
+}
+
+// checkMetadataStore verifies that the data directory was written with the
+// requested metadata store, writing the marker when it is missing. Data
+// directories without a marker predate the kv store and use JSON files.
+func checkMetadataStore(basePath, mode string) error {
+	if mode == "" {
+		mode = MetadataStoreJSON
+	}
+
+	current, err := readMetadataStoreMarker(basePath)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		empty, err := bucketsEmpty(basePath)
+		if err != nil {
+			return fmt.Errorf("inspecting data directory: %w", err)
+		}
+		if empty {
+			return writeMetadataStoreMarker(basePath, mode)
+		}
+		current = MetadataStoreJSON
+		if err := writeMetadataStoreMarker(basePath, current); err != nil {
+			return err
+		}
+	}
+
+	if current != mode {
+		return fmt.Errorf("%w: data directory %s uses the %q metadata store, configured %q; stop the service and run migrate-metadata -data %s -to %s",
+			ErrMetadataStoreMismatch, basePath, current, mode, basePath, mode)
+	}
+	return nil
+}
+
+// readMetadataStoreMarker returns the metadata store recorded in basePath, or
+// an empty string if there is no marker
+func readMetadataStoreMarker(basePath string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(basePath, metadataStoreFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading metadata store marker: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeMetadataStoreMarker records the metadata store used by basePath
+func writeMetadataStoreMarker(basePath, mode string) error {
+	markerPath := filepath.Join(basePath, metadataStoreFile)
+	tmpPath := markerPath + ".tmp"
+
+	if err := os.WriteFile(tmpPath, []byte(mode+"\n"), 0600); err != nil {
+		return fmt.Errorf("writing metadata store marker: %w", err)
+	}
+	if err := os.Rename(tmpPath, markerPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("renaming metadata store marker: %w", err)
+	}
+	return nil
+}
+
+// bucketsEmpty reports whether no bucket under basePath contains objects
+func bucketsEmpty(basePath string) (bool, error) {
+	buckets, err := os.ReadDir(filepath.Join(basePath, "buckets"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	for _, bucket := range buckets {
+		if !bucket.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(basePath, "buckets", bucket.Name(), "objects"))
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		if len(entries) > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// writeFileAtomic writes JSON-encoded v to path via a temp file and rename,
+// syncing the file and its directory with s
+func writeFileAtomic(s syncer, path string, v any) error {
+	tmpPath := path + ".tmp." + uuid.New().String()
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("creating metadata file: %w", err)
+	}
+
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("writing metadata: %w", err)
+	}
+
+	if err := s.syncFile(file); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("syncing metadata file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("closing metadata file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("renaming metadata file: %w", err)
+	}
+
+	if err := s.syncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("syncing metadata directory: %w", err)
+	}
+
+	return nil
+}
+
+// readJSONFile decodes the JSON file at path into v. A missing file returns
+// an error for which os.IsNotExist is true.
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// jsonMetadataStore keeps a meta.json file in each object directory, and
+// a keys.index listing index per bucket
+type jsonMetadataStore struct {
+	basePath    string
+	bucketPaths map[string]string
+	syncer      syncer
+	mu          sync.Mutex
+	indexes     map[string]*persistentKeyIndex
+}
+
+// bucketIndex returns the open key index for a bucket, loading it on first
+// use and rebuilding it from the meta.json files if it is missing
+func (s *jsonMetadataStore) bucketIndex(bucket string) (*persistentKeyIndex, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index, ok := s.indexes[bucket]; ok {
+		return index, nil
+	}
+
+	bucketPath := bucketDir(s.basePath, s.bucketPaths, bucket)
+	if _, err := os.Stat(bucketPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBucketNotFound
+		}
+		return nil, fmt.Errorf("checking bucket directory: %w", err)
+	}
+
+	index, err := openKeyIndex(filepath.Join(bucketPath, keyIndexFile), s.syncer, func() ([]string, error) {
+		return s.walkKeys(bucket)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.indexes[bucket] = index
+	return index, nil
+}
+
+// walkKeys returns the keys of all objects in a bucket that have a meta.json file
+func (s *jsonMetadataStore) walkKeys(bucket string) ([]string, error) {
+	objects, err := s.list(bucket)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objects))
+	for i := range objects {
+		keys[i] = objects[i].Key
+	}
+	return keys, nil
+}
+
+func (s *jsonMetadataStore) get(bucket, key, objPath string) (*s3.ObjectMetadata, error) {
+	metaFile, err := os.Open(filepath.Join(objPath, "meta.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("opening metadata file: %w", err)
+	}
+	defer metaFile.Close()
+
+	var meta s3.ObjectMetadata
+	if err := json.NewDecoder(metaFile).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("parsing metadata: %w", err)
+	}
+
+	return &meta, nil
+}
+
+// put indexes the key before writing meta.json, so that a crash in between
+// leaves an index entry without metadata, which listings skip, rather than
+// an object that is never listed
+func (s *jsonMetadataStore) put(bucket, objPath string, meta *s3.ObjectMetadata) error {
+	for {
+		index, err := s.bucketIndex(bucket)
+		if err != nil {
+			return err
+		}
+		// The index may have been replaced by a reload while waiting for its lock
+		err = index.put(meta.Key)
+		if errors.Is(err, errKeyIndexClosed) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	return writeFileAtomic(s.syncer, filepath.Join(objPath, "meta.json"), meta)
+}
+
+// delete removes the key from the index; meta.json is removed together with
+// the object directory
+func (s *jsonMetadataStore) delete(bucket, key, objPath string) error {
+	for {
+		index, err := s.bucketIndex(bucket)
+		if err != nil {
+			if errors.Is(err, ErrBucketNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := index.delete(key); !errors.Is(err, errKeyIndexClosed) {
+			return err
+		}
+	}
+}
+
+func (s *jsonMetadataStore) list(bucket string) ([]s3.ObjectMetadata, error) {
+	objectsPath := filepath.Join(bucketDir(s.basePath, s.bucketPaths, bucket), "objects")
+	var allObjects []s3.ObjectMetadata
+
+	// Walk through all hash prefix directories
+	err := filepath.WalkDir(objectsPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip non-directories and the root
+		if !d.IsDir() {
+			return nil
+		}
+
+		// Check if this is an object directory (contains meta.json)
+		metaPath := filepath.Join(path, "meta.json")
+		if _, statErr := os.Stat(metaPath); statErr != nil {
+			return nil
+		}
+
+		// Read metadata
+		metaFile, err := os.Open(metaPath)
+		if err != nil {
+			return nil
+		}
+		defer metaFile.Close()
+
+		var meta s3.ObjectMetadata
+		if err := json.NewDecoder(metaFile).Decode(&meta); err != nil {
+			return nil
+		}
+
+		allObjects = append(allObjects, meta)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking objects directory: %w", err)
+	}
+
+	return allObjects, nil
+}
+
+func (s *jsonMetadataStore) dropBucket(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index, ok := s.indexes[bucket]; ok {
+		index.close()
+		delete(s.indexes, bucket)
+	}
+}
+
+// reload rebuilds the key index of a bucket from the meta.json files.
+// Writers are held off while the bucket is walked; keys they indexed just
+// before are kept if their data file exists, as meta.json is written last.
+func (s *jsonMetadataStore) reload(bucket string) error {
+	old, err := s.bucketIndex(bucket)
+	if err != nil {
+		return err
+	}
+	old.mu.Lock()
+	defer old.mu.Unlock()
+
+	keys, err := s.walkKeys(bucket)
+	if err != nil {
+		return err
+	}
+	found := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		found[key] = true
+	}
+	fs := &FilesystemStorage{basePath: s.basePath, bucketPaths: s.bucketPaths, syncer: s.syncer}
+	for _, key := range old.snapshot() {
+		if found[key] {
+			continue
+		}
+		objPath, err := fs.keyToPath(bucket, key)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(objPath, "data")); err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	fresh, err := writeKeyIndex(filepath.Join(bucketDir(s.basePath, s.bucketPaths, bucket), keyIndexFile), s.syncer, keys)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.indexes[bucket] = fresh
+	s.mu.Unlock()
+
+	if !old.closed {
+		old.file.Close()
+		old.closed = true
+	}
+	return nil
+}
+
+func (s *jsonMetadataStore) compact(bucket string) error {
+	index, err := s.bucketIndex(bucket)
+	if err != nil {
+		return err
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if index.closed {
+		return nil
+	}
+	return index.rewrite()
+}
+
+func (s *jsonMetadataStore) index(bucket string) (*keyIndex, error) {
+	index, err := s.bucketIndex(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &index.keyIndex, nil
+}
+
+// kvMetadataStore keeps the metadata of each bucket in one metadata log
+type kvMetadataStore struct {
+	basePath    string
+	bucketPaths map[string]string
+	syncer      syncer
+	mu          sync.Mutex
+	logs        map[string]*metadataLog
+}
+
+// bucketLog returns the open metadata log for a bucket, loading it on first use
+func (s *kvMetadataStore) bucketLog(bucket string) (*metadataLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if log, ok := s.logs[bucket]; ok {
+		return log, nil
+	}
+
+	bucketPath := bucketDir(s.basePath, s.bucketPaths, bucket)
+	if _, err := os.Stat(bucketPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBucketNotFound
+		}
+		return nil, fmt.Errorf("checking bucket directory: %w", err)
+	}
+
+	log, err := openMetadataLog(filepath.Join(bucketPath, metadataLogFile), s.syncer)
+	if err != nil {
+		return nil, err
+	}
+	s.logs[bucket] = log
+	return log, nil
+}
+
+func (s *kvMetadataStore) get(bucket, key, objPath string) (*s3.ObjectMetadata, error) {
+	log, err := s.bucketLog(bucket)
+	if err != nil {
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return log.get(key)
+}
+
+func (s *kvMetadataStore) put(bucket, objPath string, meta *s3.ObjectMetadata) error {
+	for {
+		log, err := s.bucketLog(bucket)
+		if err != nil {
+			return err
+		}
+		// The log may have been replaced by a reload while waiting for its lock
+		if err := log.put(meta); !errors.Is(err, errMetadataLogClosed) {
+			return err
+		}
+	}
+}
+
+func (s *kvMetadataStore) delete(bucket, key, objPath string) error {
+	for {
+		log, err := s.bucketLog(bucket)
+		if err != nil {
+			if errors.Is(err, ErrBucketNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := log.delete(key); !errors.Is(err, errMetadataLogClosed) {
+			return err
+		}
+	}
+}
+
+func (s *kvMetadataStore) list(bucket string) ([]s3.ObjectMetadata, error) {
+	log, err := s.bucketLog(bucket)
+	if err != nil {
+		if errors.Is(err, ErrBucketNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return log.list(), nil
+}
+
+func (s *kvMetadataStore) dropBucket(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if log, ok := s.logs[bucket]; ok {
+		log.close()
+		delete(s.logs, bucket)
+	}
+}
+
+// reload replaces the cached log of a bucket with one read from disk, for
+// example after metadata.log was restored from a backup. Writers are held
+// off while the log is read so that no record is lost.
+func (s *kvMetadataStore) reload(bucket string) error {
+	s.mu.Lock()
+	old := s.logs[bucket]
+	s.mu.Unlock()
+
+	if old != nil {
+		old.mu.Lock()
+		defer old.mu.Unlock()
+	}
+
+	path := filepath.Join(bucketDir(s.basePath, s.bucketPaths, bucket), metadataLogFile)
+	fresh, err := openMetadataLog(path, s.syncer)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.logs[bucket] = fresh
+	s.mu.Unlock()
+
+	if old != nil && !old.closed {
+		old.file.Close()
+		old.closed = true
+	}
+	return nil
+}
+
+func (s *kvMetadataStore) index(bucket string) (*keyIndex, error) {
+	log, err := s.bucketLog(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &log.index, nil
+}
+
+func (s *kvMetadataStore) compact(bucket string) error {
+	log, err := s.bucketLog(bucket)
+	if err != nil {
+		return err
+	}
+
+	return log.compact()
+}
+
+// metadataLogCompactMinRecords is the number of records below which a log is never compacted
+const metadataLogCompactMinRecords = 1000
+
+// metadataLogMaxLine is the longest record accepted when loading a log
+const metadataLogMaxLine = 1024 * 1024
+
+// errMetadataLogClosed is returned when writing to a log that was closed
+// after being dropped or reloaded
+var errMetadataLogClosed = errors.New("metadata log closed")
+
+// metadataLogRecord is one line of a metadata log. A record without
+// metadata deletes the key.
+type metadataLogRecord struct {
+	Key  string             `json:"key"`
+	Meta *s3.ObjectMetadata `json:"meta,omitempty"`
+}
+
+// errCorruptMetadataLog is returned when a metadata log has an unreadable
+// record before its last one, which a crash cannot leave behind
+var errCorruptMetadataLog = errors.New("corrupt metadata log")
+
+// metadataLog is an append-only log of metadata records with an in-memory
+// index of the latest record per key. It is compacted when superseded
+// records outnumber live ones.
+type metadataLog struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	syncer  syncer
+	entries map[string]s3.ObjectMetadata
+	index   keyIndex
+	records int
+	closed  bool
+
+	// compacting is set while a compacted copy of the log is written
+	// without holding mu. Records appended meanwhile are kept in pending
+	// and added to the copy before it replaces the log.
+	compacting bool
+	pending    []metadataLogRecord
+}
+
+// openMetadataLog loads a metadata log, creating it if it does not exist.
+// A partially written last record, left by a crash, is cut off so that the
+// next record starts on a line of its own. Any other unreadable record is
+// an error rather than silently losing the metadata in it.
+func openMetadataLog(path string, s syncer) (*metadataLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening metadata log: %w", err)
+	}
+
+	log := &metadataLog{
+		path:    path,
+		file:    file,
+		syncer:  s,
+		entries: make(map[string]s3.ObjectMetadata),
+	}
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			file.Close()
+			return nil, fmt.Errorf("reading metadata log: %w", readErr)
+		}
+		if len(line) == 0 {
+			break
+		}
+
+		var record metadataLogRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.Key == "" {
+			if readErr == io.EOF {
+				// A torn final record: the write it belongs to never succeeded
+				if err := file.Truncate(offset); err != nil {
+					file.Close()
+					return nil, fmt.Errorf("truncating metadata log: %w", err)
+				}
+				break
+			}
+			file.Close()
+			return nil, fmt.Errorf("%w: %s: unreadable record %d at offset %d", errCorruptMetadataLog, path, log.records+1, offset)
+		}
+		log.records++
+		offset += int64(len(line))
+		if record.Meta == nil {
+			delete(log.entries, record.Key)
+		} else {
+			log.entries[record.Key] = *record.Meta
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	log.index.keys = make([]string, 0, len(log.entries))
+	for key := range log.entries {
+		log.index.keys = append(log.index.keys, key)
+	}
+	sort.Strings(log.index.keys)
+
+	return log, nil
+}
+
+func (l *metadataLog) get(key string) (*s3.ObjectMetadata, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	meta, ok := l.entries[key]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return &meta, nil
+}
+
+func (l *metadataLog) put(meta *s3.ObjectMetadata) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return errMetadataLogClosed
+	}
+	if err := l.append(metadataLogRecord{Key: meta.Key, Meta: meta}); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	l.entries[meta.Key] = *meta
+	l.index.add(meta.Key)
+	compact := l.needsCompaction()
+	l.mu.Unlock()
+
+	if compact {
+		return l.compact()
+	}
+	return nil
+}
+
+func (l *metadataLog) delete(key string) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return errMetadataLogClosed
+	}
+	if _, ok := l.entries[key]; !ok {
+		l.mu.Unlock()
+		return nil
+	}
+	if err := l.append(metadataLogRecord{Key: key}); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	delete(l.entries, key)
+	l.index.remove(key)
+	compact := l.needsCompaction()
+	l.mu.Unlock()
+
+	if compact {
+		return l.compact()
+	}
+	return nil
+}
+
+func (l *metadataLog) list() []s3.ObjectMetadata {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	objects := make([]s3.ObjectMetadata, 0, len(l.entries))
+	for _, meta := range l.entries {
+		objects = append(objects, meta)
+	}
+	return objects
+}
+
+// append writes a record as a single line (caller must hold the write lock)
+func (l *metadataLog) append(record metadataLogRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding metadata record: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing metadata log: %w", err)
+	}
+	if err := l.syncer.syncFile(l.file); err != nil {
+		return fmt.Errorf("syncing metadata log: %w", err)
+	}
+	l.records++
+	if l.compacting {
+		l.pending = append(l.pending, record)
+	}
+	return nil
+}
+
+// needsCompaction reports whether superseded records outnumber live ones
+// and no compaction is running (caller must hold the write lock)
+func (l *metadataLog) needsCompaction() bool {
+	return !l.compacting && l.records >= metadataLogCompactMinRecords && l.records >= 2*len(l.entries)
+}
+
+// compact rewrites the log with only live records. The records are written
+// from a snapshot without holding the lock, so that writes continue during
+// the rewrite; the lock is only taken again to add the records appended
+// meanwhile and to swap the files.
+func (l *metadataLog) compact() error {
+	l.mu.Lock()
+	if l.closed || l.compacting {
+		l.mu.Unlock()
+		return nil
+	}
+	l.compacting = true
+	snapshot := maps.Clone(l.entries)
+	l.mu.Unlock()
+
+	err := l.rewrite(snapshot)
+
+	l.mu.Lock()
+	l.compacting = false
+	l.pending = nil
+	l.mu.Unlock()
+	return err
+}
+
+// rewrite writes the live records in snapshot to a temp file and, holding
+// the write lock, adds the pending records and renames it over the log
+func (l *metadataLog) rewrite(snapshot map[string]s3.ObjectMetadata) error {
+	// The temp file is opened for appending so that it becomes the live log
+	// after the rename without reopening it
+	tmpPath := l.path + ".tmp." + uuid.New().String()
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_APPEND|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("creating compacted metadata log: %w", err)
+	}
+	fail := func(format string, err error) error {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf(format, err)
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	for key, meta := range snapshot {
+		if err := encoder.Encode(metadataLogRecord{Key: key, Meta: &meta}); err != nil {
+			return fail("writing compacted metadata log: %w", err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		// Dropped or reloaded meanwhile; the compacted copy is stale
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return nil
+	}
+
+	for _, record := range l.pending {
+		if err := encoder.Encode(record); err != nil {
+			return fail("writing compacted metadata log: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fail("writing compacted metadata log: %w", err)
+	}
+	if err := l.syncer.syncFile(tmpFile); err != nil {
+		return fail("syncing compacted metadata log: %w", err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fail("renaming compacted metadata log: %w", err)
+	}
+
+	l.file.Close()
+	l.file = tmpFile
+	l.records = len(snapshot) + len(l.pending)
+
+	if err := l.syncer.syncDir(filepath.Dir(l.path)); err != nil {
+		return fmt.Errorf("syncing metadata log directory: %w", err)
+	}
+	return nil
+}
+
+func (l *metadataLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.file.Close()
+		l.closed = true
+	}
+}
+
+// MigrateMetadataStore copies the metadata of every object under basePath,
+// and of the buckets stored elsewhere according to bucketPaths, into the
+// metadata store named by to, switches the marker and removes the old
+// metadata. It must run while the service is stopped. Returns the number of
+// objects migrated.
+func MigrateMetadataStore(basePath string, bucketPaths map[string]string, to string) (int, error) {
+	if to != MetadataStoreJSON && to != MetadataStoreKV {
+		return 0, fmt.Errorf("unknown metadata store %q", to)
+	}
+
+	current, err := readMetadataStoreMarker(basePath)
+	if err != nil {
+		return 0, err
+	}
+	if current == "" {
+		current = MetadataStoreJSON
+	}
+	if current == to {
+		return 0, nil
+	}
+
+	src, err := newMetadataStore(basePath, bucketPaths, current, fsyncer{})
+	if err != nil {
+		return 0, err
+	}
+	dst, err := newMetadataStore(basePath, bucketPaths, to, fsyncer{})
+	if err != nil {
+		return 0, err
+	}
+	fs := &FilesystemStorage{basePath: basePath, bucketPaths: bucketPaths, syncer: fsyncer{}}
+
+	buckets, err := fs.BucketNames()
+	if err != nil {
+		return 0, err
+	}
+
+	// Copy everything before switching, so an interrupted migration can be rerun
+	migrated := make(map[string][]s3.ObjectMetadata, len(buckets))
+	count := 0
+	for _, bucket := range buckets {
+		// A key index left from an earlier json period would be stale
+		if to == MetadataStoreJSON {
+			os.Remove(filepath.Join(fs.bucketPath(bucket), keyIndexFile))
+		}
+		objects, err := src.list(bucket)
+		if err != nil {
+			return count, fmt.Errorf("listing bucket %s: %w", bucket, err)
+		}
+		for i := range objects {
+			objPath, err := fs.keyToPath(bucket, objects[i].Key)
+			if err != nil {
+				return count, fmt.Errorf("bucket %s key %q: %w", bucket, objects[i].Key, err)
+			}
+			if err := dst.put(bucket, objPath, &objects[i]); err != nil {
+				return count, fmt.Errorf("bucket %s key %q: %w", bucket, objects[i].Key, err)
+			}
+			count++
+		}
+		migrated[bucket] = objects
+		src.dropBucket(bucket)
+		dst.dropBucket(bucket)
+	}
+
+	if err := writeMetadataStoreMarker(basePath, to); err != nil {
+		return count, err
+	}
+
+	// The old metadata is no longer read; remove it
+	for _, bucket := range buckets {
+		switch current {
+		case MetadataStoreJSON:
+			for _, meta := range migrated[bucket] {
+				if objPath, err := fs.keyToPath(bucket, meta.Key); err == nil {
+					os.Remove(filepath.Join(objPath, "meta.json"))
+				}
+			}
+			os.Remove(filepath.Join(fs.bucketPath(bucket), keyIndexFile))
+		case MetadataStoreKV:
+			os.Remove(filepath.Join(fs.bucketPath(bucket), metadataLogFile))
+		}
+	}
+
+	return count, nil
+}
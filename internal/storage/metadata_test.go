@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+func setupKVStorage(t *testing.T) (*FilesystemStorage, string) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	basePath := filepath.Join(tmpDir, "data")
+	storage, err := NewFilesystemStorageWithOptions(basePath, filepath.Join(tmpDir, "multipart"), FilesystemOptions{MetadataStore: MetadataStoreKV})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := storage.CreateBucket(testBucket); err != nil {
+		t.Fatalf("failed to create test bucket: %v", err)
+	}
+	return storage, basePath
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestKVMetadataStore(t *testing.T) {
+	storage, basePath := setupKVStorage(t)
+
+	for _, key := range []string{"a.txt", "dir/b.txt", "dir/c.txt"} {
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", map[string]string{"k": "v"}, strings.NewReader("content of "+key)); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+
+	meta, err := storage.HeadObject(testBucket, "dir/b.txt")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if meta.Size != int64(len("content of dir/b.txt")) || meta.UserMetadata["k"] != "v" {
+		t.Errorf("HeadObject = %+v", meta)
+	}
+
+	reader, _, err := storage.GetObject(context.Background(), testBucket, "a.txt")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "content of a.txt" {
+		t.Errorf("GetObject data = %q", data)
+	}
+
+	// No sidecar files are written
+	objPath, _ := storage.keyToPath(testBucket, "a.txt")
+	if _, err := os.Stat(filepath.Join(objPath, "meta.json")); !os.IsNotExist(err) {
+		t.Errorf("meta.json should not exist in kv mode, stat error = %v", err)
+	}
+
+	result, err := storage.ListObjects(testBucket, ListObjectsOptions{Prefix: "dir/"})
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(result.Objects) != 2 || result.Objects[0].Key != "dir/b.txt" || result.Objects[1].Key != "dir/c.txt" {
+		t.Errorf("ListObjects = %+v", result.Objects)
+	}
+
+	if err := storage.PutObjectLegalHold(testBucket, "a.txt", s3.LegalHoldOn); err != nil {
+		t.Fatalf("PutObjectLegalHold failed: %v", err)
+	}
+	if err := storage.DeleteObject(testBucket, "a.txt"); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("DeleteObject error = %v, want ErrObjectLocked", err)
+	}
+
+	if err := storage.DeleteObject(testBucket, "dir/c.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if exists, _ := storage.ObjectExists(testBucket, "dir/c.txt"); exists {
+		t.Error("deleted object should not exist")
+	}
+
+	// A new storage instance loads the same metadata from the log
+	reopened, err := NewFilesystemStorageWithOptions(basePath, filepath.Join(filepath.Dir(basePath), "multipart"), FilesystemOptions{MetadataStore: MetadataStoreKV})
+	if err != nil {
+		t.Fatalf("failed to reopen storage: %v", err)
+	}
+	result, err = reopened.ListObjects(testBucket, ListObjectsOptions{})
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(result.Objects) != 2 {
+		t.Errorf("got %d objects after reopen, want 2", len(result.Objects))
+	}
+	meta, err = reopened.HeadObject(testBucket, "a.txt")
+	if err != nil || meta.ObjectLockLegalHold != s3.LegalHoldOn {
+		t.Errorf("HeadObject after reopen = %+v, %v", meta, err)
+	}
+}
+
+func TestKVMetadataStoreMultipart(t *testing.T) {
+	storage, _ := setupKVStorage(t)
+
+	uploadID, err := storage.CreateMultipartUpload(testBucket, "multipart.bin", "application/octet-stream", nil)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	part, err := storage.UploadPart(context.Background(), uploadID, 1, strings.NewReader("part one"))
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	if _, err := storage.CompleteMultipartUpload(context.Background(), uploadID, []s3.CompletedPartInput{{PartNumber: 1, ETag: part.ETag}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+
+	meta, err := storage.HeadObject(testBucket, "multipart.bin")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if meta.Size != int64(len("part one")) {
+		t.Errorf("Size = %d, want %d", meta.Size, len("part one"))
+	}
+}
+
+func TestKVMetadataStoreDeleteBucket(t *testing.T) {
+	storage, _ := setupKVStorage(t)
+
+	if _, err := storage.PutObject(context.Background(), testBucket, "a.txt", "text/plain", nil, strings.NewReader("a")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := storage.DeleteObject(testBucket, "a.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if err := storage.DeleteBucket(testBucket); err != nil {
+		t.Fatalf("DeleteBucket failed: %v", err)
+	}
+
+	// A recreated bucket starts with an empty log
+	if err := storage.CreateBucket(testBucket); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := storage.PutObject(context.Background(), testBucket, "b.txt", "text/plain", nil, strings.NewReader("b")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	result, err := storage.ListObjects(testBucket, ListObjectsOptions{})
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(result.Objects) != 1 || result.Objects[0].Key != "b.txt" {
+		t.Errorf("ListObjects = %+v", result.Objects)
+	}
+}
+
+func TestMetadataLogCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), metadataLogFile)
+	log, err := openMetadataLog(path, fsyncer{})
+	if err != nil {
+		t.Fatalf("openMetadataLog failed: %v", err)
+	}
+	defer log.close()
+
+	// Overwriting one key keeps a single live entry while records accumulate
+	for i := 0; i < metadataLogCompactMinRecords+10; i++ {
+		if err := log.put(&s3.ObjectMetadata{Key: "key", Size: int64(i)}); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+
+	if lines := countLines(t, path); lines >= metadataLogCompactMinRecords {
+		t.Errorf("log has %d records, expected compaction below %d", lines, metadataLogCompactMinRecords)
+	}
+
+	reopened, err := openMetadataLog(path, fsyncer{})
+	if err != nil {
+		t.Fatalf("openMetadataLog failed: %v", err)
+	}
+	defer reopened.close()
+	meta, err := reopened.get("key")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if meta.Size != metadataLogCompactMinRecords+9 {
+		t.Errorf("Size = %d, want %d", meta.Size, metadataLogCompactMinRecords+9)
+	}
+}
+
+func TestMetadataLogPartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), metadataLogFile)
+	content := `{"key":"a","meta":{"key":"a","size":1}}` + "\n" +
+		`{"key":"b","meta":{"key":"b","size":2}}` + "\n" +
+		`{"key":"a"}` + "\n" +
+		`{"key":"c","meta":{"ke`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write log: %v", err)
+	}
+
+	log, err := openMetadataLog(path, fsyncer{})
+	if err != nil {
+		t.Fatalf("openMetadataLog failed: %v", err)
+	}
+	defer log.close()
+
+	if _, err := log.get("a"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("get(a) error = %v, want ErrObjectNotFound", err)
+	}
+	if meta, err := log.get("b"); err != nil || meta.Size != 2 {
+		t.Errorf("get(b) = %+v, %v", meta, err)
+	}
+	if _, err := log.get("c"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("get(c) error = %v, want ErrObjectNotFound", err)
+	}
+
+	// The torn record is cut off, so the next record is readable
+	if err := log.put(&s3.ObjectMetadata{Key: "d", Size: 4}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	reopened, err := openMetadataLog(path, fsyncer{})
+	if err != nil {
+		t.Fatalf("openMetadataLog after put failed: %v", err)
+	}
+	defer reopened.close()
+	if meta, err := reopened.get("d"); err != nil || meta.Size != 4 {
+		t.Errorf("get(d) = %+v, %v", meta, err)
+	}
+}
+
+func TestMetadataLogCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), metadataLogFile)
+	content := `{"key":"a","meta":{"key":"a","size":1}}` + "\n" +
+		`not a record` + "\n" +
+		`{"key":"b","meta":{"key":"b","size":2}}` + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write log: %v", err)
+	}
+
+	if _, err := openMetadataLog(path, fsyncer{}); !errors.Is(err, errCorruptMetadataLog) {
+		t.Errorf("openMetadataLog error = %v, want errCorruptMetadataLog", err)
+	}
+}
+
+func TestMetadataLogCompactionKeepsConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), metadataLogFile)
+	log, err := openMetadataLog(path, fsyncer{})
+	if err != nil {
+		t.Fatalf("openMetadataLog failed: %v", err)
+	}
+	defer log.close()
+
+	// Writers keep overwriting their own key while compactions run
+	const writers, writes = 4, metadataLogCompactMinRecords
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if err := log.put(&s3.ObjectMetadata{Key: fmt.Sprintf("key-%d", w), Size: int64(i)}); err != nil {
+					t.Errorf("put failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	reopened, err := openMetadataLog(path, fsyncer{})
+	if err != nil {
+		t.Fatalf("openMetadataLog failed: %v", err)
+	}
+	defer reopened.close()
+	for w := 0; w < writers; w++ {
+		if meta, err := reopened.get(fmt.Sprintf("key-%d", w)); err != nil || meta.Size != writes-1 {
+			t.Errorf("get(key-%d) = %+v, %v; want size %d", w, meta, err, writes-1)
+		}
+	}
+	if lines := countLines(t, path); lines >= writers*writes {
+		t.Errorf("log has %d records, expected it to be compacted", lines)
+	}
+}
+
+func TestMetadataStoreMismatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	basePath := filepath.Join(tmpDir, "data")
+	multipartPath := filepath.Join(tmpDir, "multipart")
+
+	storage, err := NewFilesystemStorage(basePath, multipartPath)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := storage.CreateBucket(testBucket); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := storage.PutObject(context.Background(), testBucket, "a.txt", "text/plain", nil, strings.NewReader("a")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	_, err = NewFilesystemStorageWithOptions(basePath, multipartPath, FilesystemOptions{MetadataStore: MetadataStoreKV})
+	if !errors.Is(err, ErrMetadataStoreMismatch) {
+		t.Errorf("error = %v, want ErrMetadataStoreMismatch", err)
+	}
+}
+
+func TestMigrateMetadataStore(t *testing.T) {
+	tmpDir := t.TempDir()
+	basePath := filepath.Join(tmpDir, "data")
+	multipartPath := filepath.Join(tmpDir, "multipart")
+	// A bucket stored under another path is migrated as well
+	bucketPaths := map[string]string{"media": filepath.Join(tmpDir, "media")}
+
+	storage, err := NewFilesystemStorageWithOptions(basePath, multipartPath, FilesystemOptions{BucketPaths: bucketPaths})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	for _, bucket := range []string{testBucket, "media"} {
+		if err := storage.CreateBucket(bucket); err != nil {
+			t.Fatalf("CreateBucket failed: %v", err)
+		}
+	}
+	keys := []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"}
+	for _, key := range keys {
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader(key)); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+	if _, err := storage.PutObject(context.Background(), "media", "video.mp4", "video/mp4", nil, strings.NewReader("video")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	for _, to := range []string{MetadataStoreKV, MetadataStoreJSON} {
+		t.Run(fmt.Sprintf("to %s", to), func(t *testing.T) {
+			migrated, err := MigrateMetadataStore(basePath, bucketPaths, to)
+			if err != nil {
+				t.Fatalf("MigrateMetadataStore failed: %v", err)
+			}
+			if migrated != len(keys)+1 {
+				t.Errorf("migrated %d objects, want %d", migrated, len(keys)+1)
+			}
+
+			migratedStorage, err := NewFilesystemStorageWithOptions(basePath, multipartPath, FilesystemOptions{MetadataStore: to, BucketPaths: bucketPaths})
+			if err != nil {
+				t.Fatalf("failed to open migrated storage: %v", err)
+			}
+			result, err := migratedStorage.ListObjects(testBucket, ListObjectsOptions{})
+			if err != nil {
+				t.Fatalf("ListObjects failed: %v", err)
+			}
+			if len(result.Objects) != len(keys) {
+				t.Errorf("got %d objects, want %d", len(result.Objects), len(keys))
+			}
+			for _, key := range keys {
+				reader, _, err := migratedStorage.GetObject(context.Background(), testBucket, key)
+				if err != nil {
+					t.Errorf("GetObject(%s) failed: %v", key, err)
+					continue
+				}
+				data, _ := io.ReadAll(reader)
+				reader.Close()
+				if string(data) != key {
+					t.Errorf("GetObject(%s) = %q", key, data)
+				}
+			}
+			if _, err := migratedStorage.HeadObject("media", "video.mp4"); err != nil {
+				t.Errorf("HeadObject(media/video.mp4) failed: %v", err)
+			}
+
+			// Migrating to the current store is a no-op
+			if migrated, err := MigrateMetadataStore(basePath, bucketPaths, to); err != nil || migrated != 0 {
+				t.Errorf("second migration = %d, %v; want 0, nil", migrated, err)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -26,15 +27,13 @@ func (fs *FilesystemStorage) CreateMultipartUpload(bucket, key string, contentTy
 	}
 
 	// Validate the key upfront to fail early
-	if err := ValidateKey(key); err != nil {
+	if err := ValidateNewKey(key); err != nil {
 		return "", err
 	}
 
-	uploadID := uuid.New().String()
-	uploadPath := filepath.Join(fs.multipartPath, uploadID)
-
-	if err := os.MkdirAll(uploadPath, 0700); err != nil {
-		return "", fmt.Errorf("creating upload directory: %w", err)
+	uploadID, uploadPath, err := fs.newUploadDir()
+	if err != nil {
+		return "", err
 	}
 
 	uploadMeta := &s3.MultipartUploadMetadata{
@@ -58,12 +57,47 @@ func (fs *FilesystemStorage) CreateMultipartUpload(bucket, key string, contentTy
 		os.RemoveAll(uploadPath)
 		return "", fmt.Errorf("writing upload metadata: %w", err)
 	}
+	if err := fs.syncUploadFile(metaFile, uploadPath); err != nil {
+		os.RemoveAll(uploadPath)
+		return "", err
+	}
+	if err := fs.syncer.syncDir(fs.multipartPath); err != nil {
+		os.RemoveAll(uploadPath)
+		return "", fmt.Errorf("syncing multipart directory: %w", err)
+	}
 
 	return uploadID, nil
 }
 
+// uploadIDAttempts is the number of upload IDs tried before giving up
+const uploadIDAttempts = 3
+
+// newUploadDir creates the directory of a new multipart upload under a
+// random version 4 UUID (122 bits from crypto/rand). The directory is
+// created exclusively, so an upload can never reuse the directory, and
+// thereby the parts, of another upload.
+func (fs *FilesystemStorage) newUploadDir() (string, string, error) {
+	for attempt := 0; attempt < uploadIDAttempts; attempt++ {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return "", "", fmt.Errorf("generating upload ID: %w", err)
+		}
+		uploadID := id.String()
+		uploadPath := filepath.Join(fs.multipartPath, uploadID)
+
+		err = os.Mkdir(uploadPath, 0700)
+		if err == nil {
+			return uploadID, uploadPath, nil
+		}
+		if !os.IsExist(err) {
+			return "", "", fmt.Errorf("creating upload directory: %w", err)
+		}
+	}
+	return "", "", fmt.Errorf("creating upload directory: no unused upload ID after %d attempts", uploadIDAttempts)
+}
+
 // UploadPart stores a part of a multipart upload
-func (fs *FilesystemStorage) UploadPart(uploadID string, partNumber int, body io.Reader) (*s3.PartMetadata, error) {
+func (fs *FilesystemStorage) UploadPart(ctx context.Context, uploadID string, partNumber int, body io.Reader) (*s3.PartMetadata, error) {
 	// Use read lock to allow concurrent part uploads while preventing deletion
 	fs.uploadMu.RLock()
 	defer fs.uploadMu.RUnlock()
@@ -89,13 +123,19 @@ func (fs *FilesystemStorage) UploadPart(uploadID string, partNumber int, body io
 	hash := md5.New()
 	writer := io.MultiWriter(tmpFile, hash)
 
-	size, err := io.Copy(writer, body)
+	size, err := copyContext(ctx, writer, body)
 	if err != nil {
 		tmpFile.Close()
 		os.Remove(tmpPath)
 		return nil, fmt.Errorf("writing part data: %w", err)
 	}
 
+	if err := fs.syncer.syncFile(tmpFile); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("syncing part data: %w", err)
+	}
+
 	if err := tmpFile.Close(); err != nil {
 		os.Remove(tmpPath)
 		return nil, fmt.Errorf("closing part file: %w", err)
@@ -125,12 +165,15 @@ func (fs *FilesystemStorage) UploadPart(uploadID string, partNumber int, body io
 	if err := json.NewEncoder(partMetaFile).Encode(partMeta); err != nil {
 		return nil, fmt.Errorf("writing part metadata: %w", err)
 	}
+	if err := fs.syncUploadFile(partMetaFile, uploadPath); err != nil {
+		return nil, err
+	}
 
 	return partMeta, nil
 }
 
 // CompleteMultipartUpload assembles all parts into the final object
-func (fs *FilesystemStorage) CompleteMultipartUpload(uploadID string, parts []s3.CompletedPartInput) (*s3.ObjectMetadata, error) {
+func (fs *FilesystemStorage) CompleteMultipartUpload(ctx context.Context, uploadID string, parts []s3.CompletedPartInput) (*s3.ObjectMetadata, error) {
 	// Use exclusive lock to prevent concurrent modifications during completion
 	fs.uploadMu.Lock()
 	defer fs.uploadMu.Unlock()
@@ -143,6 +186,10 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(uploadID string, parts []s3
 		return nil, err
 	}
 
+	if err := fs.checkNotLocked(uploadMeta.Bucket, uploadMeta.Key); err != nil {
+		return nil, err
+	}
+
 	// Validate parts are in order
 	for i := 1; i < len(parts); i++ {
 		if parts[i].PartNumber <= parts[i-1].PartNumber {
@@ -152,6 +199,7 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(uploadID string, parts []s3
 
 	// Verify all parts exist and ETags match
 	var partHashes [][]byte
+	var partSizes []int64
 	var totalSize int64
 
 	for _, part := range parts {
@@ -180,39 +228,25 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(uploadID string, parts []s3
 			return nil, fmt.Errorf("part %d size mismatch: metadata claims %d bytes, file has %d bytes", part.PartNumber, partMeta.Size, partInfo.Size())
 		}
 
-		// Normalize ETags for comparison (remove quotes if present)
-		expectedETag := strings.Trim(part.ETag, "\"")
-		actualETag := strings.Trim(partMeta.ETag, "\"")
-
-		if expectedETag != actualETag {
-			return nil, fmt.Errorf("part %d ETag mismatch: expected %s, got %s", part.PartNumber, expectedETag, actualETag)
-		}
-
-		// Decode the hex MD5 for multipart ETag calculation
-		hashBytes, err := hex.DecodeString(actualETag)
+		hashBytes, err := partHash(part, partMeta.ETag)
 		if err != nil {
-			return nil, fmt.Errorf("invalid part %d ETag format: %w", part.PartNumber, err)
+			return nil, err
 		}
 		partHashes = append(partHashes, hashBytes)
+		partSizes = append(partSizes, partMeta.Size)
 		totalSize += partMeta.Size
 	}
 
-	// Create object directory
 	objPath, keyErr := fs.keyToPath(uploadMeta.Bucket, uploadMeta.Key)
 	if keyErr != nil {
 		return nil, keyErr
 	}
-	if err := os.MkdirAll(objPath, 0700); err != nil {
-		return nil, fmt.Errorf("creating object directory: %w", err)
-	}
-
 	dataPath := filepath.Join(objPath, "data")
-	tmpPath := dataPath + ".tmp"
 
 	// Concatenate all parts
-	outFile, err := os.Create(tmpPath)
+	outFile, tmpPath, err := fs.createObjectTemp(uploadMeta.Bucket, objPath)
 	if err != nil {
-		return nil, fmt.Errorf("creating output file: %w", err)
+		return nil, err
 	}
 
 	for _, part := range parts {
@@ -226,7 +260,7 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(uploadID string, parts []s3
 			return nil, fmt.Errorf("opening part %d: %w", part.PartNumber, err)
 		}
 
-		_, err = io.Copy(outFile, partFile)
+		_, err = copyContext(ctx, outFile, partFile)
 		partFile.Close()
 		if err != nil {
 			outFile.Close()
@@ -235,22 +269,34 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(uploadID string, parts []s3
 		}
 	}
 
+	if err := fs.syncer.syncFile(outFile); err != nil {
+		outFile.Close()
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("syncing output file: %w", err)
+	}
+
 	if err := outFile.Close(); err != nil {
 		os.Remove(tmpPath)
 		return nil, fmt.Errorf("closing output file: %w", err)
 	}
 
+	// Keep the version being replaced when the bucket is versioned
+	versionID, unlock, err := fs.prepareVersionedWrite(uploadMeta.Bucket, uploadMeta.Key, objPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	defer unlock()
+
 	if err := os.Rename(tmpPath, dataPath); err != nil {
 		os.Remove(tmpPath)
 		return nil, fmt.Errorf("renaming output file: %w", err)
 	}
-
-	// Calculate multipart ETag: MD5 of concatenated part MD5s, with -N suffix
-	combinedHash := md5.New()
-	for _, h := range partHashes {
-		combinedHash.Write(h)
+	if err := syncObjectDirs(fs.syncer, objPath); err != nil {
+		return nil, fmt.Errorf("syncing object directory: %w", err)
 	}
-	etag := fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(combinedHash.Sum(nil)), len(parts))
+
+	etag := multipartETag(partHashes)
 
 	// Create object metadata
 	now := time.Now().UTC()
@@ -260,19 +306,15 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(uploadID string, parts []s3
 		ContentType:  uploadMeta.ContentType,
 		ETag:         etag,
 		LastModified: now,
+		Created:      fs.createdTime(uploadMeta.Bucket, uploadMeta.Key, objPath, now),
 		UserMetadata: uploadMeta.UserMetadata,
+		PartSizes:    partSizes,
+		VersionID:    versionID,
 	}
 
 	// Write metadata
-	metaPath := filepath.Join(objPath, "meta.json")
-	metaFile, err := os.Create(metaPath)
-	if err != nil {
-		return nil, fmt.Errorf("creating metadata file: %w", err)
-	}
-	defer metaFile.Close()
-
-	if err := json.NewEncoder(metaFile).Encode(objMeta); err != nil {
-		return nil, fmt.Errorf("writing metadata: %w", err)
+	if err := fs.meta.put(uploadMeta.Bucket, objPath, objMeta); err != nil {
+		return nil, err
 	}
 
 	// Clean up multipart upload directory
@@ -281,6 +323,35 @@ func (fs *FilesystemStorage) CompleteMultipartUpload(uploadID string, parts []s3
 	return objMeta, nil
 }
 
+// partHash checks the ETag a completed part was given against the ETag
+// stored for it, and returns the part's MD5
+func partHash(part s3.CompletedPartInput, storedETag string) ([]byte, error) {
+	// Normalize ETags for comparison (remove quotes if present)
+	expectedETag := strings.Trim(part.ETag, "\"")
+	actualETag := strings.Trim(storedETag, "\"")
+
+	if expectedETag != actualETag {
+		return nil, fmt.Errorf("part %d ETag mismatch: expected %s, got %s", part.PartNumber, expectedETag, actualETag)
+	}
+
+	// Decode the hex MD5 for multipart ETag calculation
+	hashBytes, err := hex.DecodeString(actualETag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid part %d ETag format: %w", part.PartNumber, err)
+	}
+	return hashBytes, nil
+}
+
+// multipartETag returns the ETag of an object assembled from parts with
+// the given MD5s: the MD5 of the concatenated part MD5s, with a -N suffix
+func multipartETag(partHashes [][]byte) string {
+	combinedHash := md5.New()
+	for _, h := range partHashes {
+		combinedHash.Write(h)
+	}
+	return fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(combinedHash.Sum(nil)), len(partHashes))
+}
+
 // AbortMultipartUpload cancels a multipart upload and cleans up parts
 func (fs *FilesystemStorage) AbortMultipartUpload(uploadID string) error {
 	// Use exclusive lock to prevent concurrent access during deletion
@@ -387,8 +458,9 @@ func (fs *FilesystemStorage) ListParts(uploadID string) ([]s3.PartMetadata, erro
 	return parts, nil
 }
 
-// CleanupStaleUploads removes multipart uploads older than maxAge
-// Returns the number of uploads cleaned up
+// CleanupStaleUploads removes multipart uploads older than maxAge, and
+// uploads aborted by an AbortIncompleteUploadDays lifecycle rule of their
+// bucket. Returns the number of uploads cleaned up
 func (fs *FilesystemStorage) CleanupStaleUploads(maxAge time.Duration) (int, error) {
 	// Read directory listing without lock (just reading names)
 	entries, err := os.ReadDir(fs.multipartPath)
@@ -399,9 +471,24 @@ func (fs *FilesystemStorage) CleanupStaleUploads(maxAge time.Duration) (int, err
 		return 0, fmt.Errorf("reading multipart directory: %w", err)
 	}
 
-	cutoff := time.Now().UTC().Add(-maxAge)
+	now := time.Now().UTC()
+	cutoff := now.Add(-maxAge)
 	cleaned := 0
 
+	// Lifecycle rules are read once per bucket
+	rules := make(map[string][]LifecycleRule)
+	isStale := func(upload *s3.MultipartUploadMetadata) bool {
+		if upload.Created.Before(cutoff) {
+			return true
+		}
+		bucketRules, ok := rules[upload.Bucket]
+		if !ok {
+			bucketRules, _ = fs.GetBucketLifecycle(upload.Bucket)
+			rules[upload.Bucket] = bucketRules
+		}
+		return abortsUpload(bucketRules, upload, now)
+	}
+
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
@@ -410,7 +497,7 @@ func (fs *FilesystemStorage) CleanupStaleUploads(maxAge time.Duration) (int, err
 		uploadID := entry.Name()
 
 		// Check and remove with lock held to prevent races
-		if fs.cleanupUploadIfStale(uploadID, cutoff, entry) {
+		if fs.cleanupUploadIfStale(uploadID, cutoff, entry, isStale) {
 			cleaned++
 		}
 	}
@@ -419,7 +506,7 @@ func (fs *FilesystemStorage) CleanupStaleUploads(maxAge time.Duration) (int, err
 }
 
 // cleanupUploadIfStale checks if an upload is stale and removes it atomically
-func (fs *FilesystemStorage) cleanupUploadIfStale(uploadID string, cutoff time.Time, entry os.DirEntry) bool {
+func (fs *FilesystemStorage) cleanupUploadIfStale(uploadID string, cutoff time.Time, entry os.DirEntry, isStale func(*s3.MultipartUploadMetadata) bool) bool {
 	fs.uploadMu.Lock()
 	defer fs.uploadMu.Unlock()
 
@@ -445,7 +532,7 @@ func (fs *FilesystemStorage) cleanupUploadIfStale(uploadID string, cutoff time.T
 		return false
 	}
 
-	if uploadMeta.Created.Before(cutoff) {
+	if isStale(uploadMeta) {
 		if removeErr := os.RemoveAll(uploadPath); removeErr == nil {
 			return true
 		}
@@ -453,3 +540,15 @@ func (fs *FilesystemStorage) cleanupUploadIfStale(uploadID string, cutoff time.T
 
 	return false
 }
+
+// syncUploadFile syncs a file written in an upload directory together with
+// the directory, which holds the renamed part and the file's entry
+func (fs *FilesystemStorage) syncUploadFile(f *os.File, uploadPath string) error {
+	if err := fs.syncer.syncFile(f); err != nil {
+		return fmt.Errorf("syncing upload file: %w", err)
+	}
+	if err := fs.syncer.syncDir(uploadPath); err != nil {
+		return fmt.Errorf("syncing upload directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrReindexInProgress is returned when a bucket is already being reindexed
+var ErrReindexInProgress = errors.New("reindex already in progress")
+
+// reindexProgressInterval is the number of objects between progress reports
+const reindexProgressInterval = 10000
+
+// ReindexResult reports the outcome of rebuilding the index of a bucket
+type ReindexResult struct {
+	Bucket string `json:"bucket"`
+	// Objects is the number of objects indexed
+	Objects int `json:"objects"`
+	// Removed is the number of index entries dropped because the object
+	// data no longer exists on disk
+	Removed  int           `json:"removed"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Reindexer is implemented by storage backends whose indexes can be rebuilt
+// from the data on disk
+type Reindexer interface {
+	// Reindex rebuilds the index of a bucket. progress, if not nil, is called
+	// periodically with the number of objects checked so far.
+	Reindex(bucket string, progress func(checked int)) (*ReindexResult, error)
+}
+
+// reindexGuard tracks the buckets being reindexed
+type reindexGuard struct {
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// acquire marks bucket as being reindexed, failing if it already is
+func (g *reindexGuard) acquire(bucket string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.running == nil {
+		g.running = make(map[string]bool)
+	}
+	if g.running[bucket] {
+		return ErrReindexInProgress
+	}
+	g.running[bucket] = true
+	return nil
+}
+
+func (g *reindexGuard) release(bucket string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.running, bucket)
+}
+
+// Reindex rebuilds the listing index of a bucket from the metadata on disk,
+// drops entries whose data file is missing and compacts the metadata store. It is meant for
+// repairing a bucket after out-of-band changes such as a restore from
+// backup, and runs online: reads are served from the previous state until
+// it is replaced.
+func (fs *FilesystemStorage) Reindex(bucket string, progress func(checked int)) (*ReindexResult, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(fs.bucketPath(bucket)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBucketNotFound
+		}
+		return nil, fmt.Errorf("checking bucket directory: %w", err)
+	}
+
+	if err := fs.reindexing.acquire(bucket); err != nil {
+		return nil, err
+	}
+	defer fs.reindexing.release(bucket)
+
+	start := time.Now()
+	result := &ReindexResult{Bucket: bucket}
+
+	if err := fs.meta.reload(bucket); err != nil {
+		return nil, fmt.Errorf("reloading metadata: %w", err)
+	}
+
+	objects, err := fs.meta.list(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, obj := range objects {
+		if progress != nil && i > 0 && i%reindexProgressInterval == 0 {
+			progress(i)
+		}
+
+		objPath, err := fs.keyToPath(bucket, obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(filepath.Join(objPath, "data")); err == nil {
+			result.Objects++
+			continue
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("checking object data: %w", err)
+		}
+
+		// Only the stale entry goes; noncurrent versions of the key are
+		// kept in the object directory
+		if err := fs.removeCurrent(bucket, obj.Key, objPath); err != nil {
+			return nil, err
+		}
+		_ = os.Remove(objPath)               // only succeeds if empty
+		_ = os.Remove(filepath.Dir(objPath)) // only succeeds if empty
+		result.Removed++
+	}
+
+	if progress != nil {
+		progress(len(objects))
+	}
+
+	if err := fs.meta.compact(bucket); err != nil {
+		return nil, fmt.Errorf("compacting metadata: %w", err)
+	}
+
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// ReindexDataDirectory rebuilds the index of bucket in the data directory at basePath,
+// or of every bucket when bucket is empty. Buckets in bucketPaths are
+// stored under their own base path, as with STUPID_BUCKET_PATHS. The
+// metadata store is taken from the data directory's marker. It must run
+// while the service is stopped; use the admin endpoint to reindex a running
+// service.
+func ReindexDataDirectory(basePath string, bucketPaths map[string]string, bucket string, progress func(bucket string, checked int)) ([]*ReindexResult, error) {
+	mode, err := readMetadataStoreMarker(basePath)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := newMetadataStore(basePath, bucketPaths, mode, fsyncer{})
+	if err != nil {
+		return nil, err
+	}
+	fs := &FilesystemStorage{basePath: basePath, bucketPaths: bucketPaths, meta: meta, syncer: fsyncer{}}
+
+	buckets, err := fs.dataDirectoryBuckets(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*ReindexResult
+	for _, name := range buckets {
+		var report func(int)
+		if progress != nil {
+			report = func(checked int) { progress(name, checked) }
+		}
+		result, err := fs.Reindex(name, report)
+		if err != nil {
+			return results, fmt.Errorf("reindexing bucket %s: %w", name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func listKeys(t *testing.T, storage Storage) []string {
+	t.Helper()
+	result, err := storage.ListObjects(testBucket, ListObjectsOptions{})
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	keys := make([]string, 0, len(result.Objects))
+	for _, obj := range result.Objects {
+		keys = append(keys, obj.Key)
+	}
+	return keys
+}
+
+func TestReindexKVRestoredLog(t *testing.T) {
+	storage, basePath := setupKVStorage(t)
+	logPath := filepath.Join(basePath, "buckets", testBucket, metadataLogFile)
+
+	for _, key := range []string{"a.txt", "b.txt", "c.txt"} {
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("content of "+key)); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+
+	backup, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read metadata log: %v", err)
+	}
+
+	if err := storage.DeleteObject(testBucket, "b.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+
+	// Restore the old log out of band, with a torn record at the end
+	if err := os.WriteFile(logPath, append(backup, []byte(`{"key":"broken`)...), 0600); err != nil {
+		t.Fatalf("failed to restore metadata log: %v", err)
+	}
+
+	result, err := storage.Reindex(testBucket, nil)
+	if err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if result.Objects != 2 || result.Removed != 1 {
+		t.Errorf("Reindex = %+v, want 2 objects and 1 removed", result)
+	}
+
+	keys := listKeys(t, storage)
+	if strings.Join(keys, ",") != "a.txt,c.txt" {
+		t.Errorf("keys after reindex = %v, want [a.txt c.txt]", keys)
+	}
+	if lines := countLines(t, logPath); lines != 2 {
+		t.Errorf("metadata log has %d records after reindex, want 2", lines)
+	}
+
+	// Writes after the reindex go to the reloaded log
+	if _, err := storage.PutObject(context.Background(), testBucket, "d.txt", "text/plain", nil, strings.NewReader("d")); err != nil {
+		t.Fatalf("PutObject after reindex failed: %v", err)
+	}
+	reopened, err := NewFilesystemStorageWithOptions(basePath, filepath.Join(filepath.Dir(basePath), "multipart"), FilesystemOptions{MetadataStore: MetadataStoreKV})
+	if err != nil {
+		t.Fatalf("failed to reopen storage: %v", err)
+	}
+	if keys := listKeys(t, reopened); strings.Join(keys, ",") != "a.txt,c.txt,d.txt" {
+		t.Errorf("keys after reopen = %v, want [a.txt c.txt d.txt]", keys)
+	}
+}
+
+func TestReindexJSONMissingData(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("content of "+key)); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+
+	objPath, err := storage.keyToPath(testBucket, "a.txt")
+	if err != nil {
+		t.Fatalf("keyToPath failed: %v", err)
+	}
+	if err := os.Remove(filepath.Join(objPath, "data")); err != nil {
+		t.Fatalf("failed to remove data file: %v", err)
+	}
+
+	var progressCalls int
+	result, err := storage.Reindex(testBucket, func(checked int) { progressCalls++ })
+	if err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if result.Objects != 1 || result.Removed != 1 {
+		t.Errorf("Reindex = %+v, want 1 object and 1 removed", result)
+	}
+	if progressCalls == 0 {
+		t.Error("progress was not reported")
+	}
+	if keys := listKeys(t, storage); strings.Join(keys, ",") != "b.txt" {
+		t.Errorf("keys after reindex = %v, want [b.txt]", keys)
+	}
+}
+
+func TestReindexMissingDataKeepsVersions(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	if err := storage.PutBucketVersioning(testBucket, VersioningEnabled); err != nil {
+		t.Fatalf("PutBucketVersioning failed: %v", err)
+	}
+	for _, content := range []string{"v1", "v2"} {
+		if _, err := storage.PutObject(context.Background(), testBucket, "a.txt", "text/plain", nil, strings.NewReader(content)); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+
+	objPath, err := storage.keyToPath(testBucket, "a.txt")
+	if err != nil {
+		t.Fatalf("keyToPath failed: %v", err)
+	}
+	if err := os.Remove(filepath.Join(objPath, "data")); err != nil {
+		t.Fatalf("failed to remove data file: %v", err)
+	}
+
+	result, err := storage.Reindex(testBucket, nil)
+	if err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if result.Removed != 1 {
+		t.Errorf("Reindex = %+v, want 1 removed", result)
+	}
+	versions, err := readVersions(objPath)
+	if err != nil || len(versions) != 1 {
+		t.Errorf("noncurrent versions after reindex = %v, %v; want the first version kept", versions, err)
+	}
+}
+
+func TestReindexErrors(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	if _, err := storage.Reindex("missing-bucket", nil); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Reindex(missing bucket) error = %v, want ErrBucketNotFound", err)
+	}
+
+	if err := storage.reindexing.acquire(testBucket); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	if _, err := storage.Reindex(testBucket, nil); !errors.Is(err, ErrReindexInProgress) {
+		t.Errorf("concurrent Reindex error = %v, want ErrReindexInProgress", err)
+	}
+	storage.reindexing.release(testBucket)
+
+	if _, err := storage.Reindex(testBucket, nil); err != nil {
+		t.Errorf("Reindex after release failed: %v", err)
+	}
+}
+
+func TestReindexDataDirectory(t *testing.T) {
+	storage, basePath := setupKVStorage(t)
+	if err := storage.CreateBucket("other-bucket"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := storage.PutObject(context.Background(), testBucket, "a.txt", "text/plain", nil, strings.NewReader("a")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// A bucket stored under another path is reindexed as well
+	bucketPaths := map[string]string{"media": filepath.Join(t.TempDir(), "media")}
+	overridden, err := NewFilesystemStorageWithOptions(basePath, storage.multipartPath, FilesystemOptions{MetadataStore: MetadataStoreKV, BucketPaths: bucketPaths})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := overridden.CreateBucket("media"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if _, err := overridden.PutObject(context.Background(), "media", "b.txt", "text/plain", nil, strings.NewReader("b")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	results, err := ReindexDataDirectory(basePath, bucketPaths, "", nil)
+	if err != nil {
+		t.Fatalf("ReindexDataDirectory failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	total := 0
+	for _, result := range results {
+		total += result.Objects
+	}
+	if total != 2 {
+		t.Errorf("indexed %d objects, want 2", total)
+	}
+}
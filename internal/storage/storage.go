@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"io"
 	"time"
 
@@ -24,16 +25,71 @@ type ListObjectsResult struct {
 	NextContinuationToken string
 }
 
-// Storage defines the interface for object storage operations
+// ListEntry is an object or a common prefix produced while listing
+type ListEntry struct {
+	// Object is the object's metadata, nil for a common prefix
+	Object       *s3.ObjectMetadata
+	CommonPrefix string
+}
+
+// PutObjectOptions contains optional attributes stored with a new object
+type PutObjectOptions struct {
+	// ServerSideEncryption is the requested x-amz-server-side-encryption
+	// algorithm. It is recorded and echoed back but the data is not encrypted.
+	ServerSideEncryption string
+
+	// Tags are the object tags from x-amz-tagging
+	Tags map[string]string
+}
+
+// CopyObjectOptions selects which attributes of the source object a copy
+// keeps. The zero value copies everything.
+type CopyObjectOptions struct {
+	// ReplaceMetadata stores ContentType and Metadata instead of the source's
+	// (x-amz-metadata-directive: REPLACE)
+	ReplaceMetadata bool
+	ContentType     string
+	Metadata        map[string]string
+
+	// ReplaceTags stores Tags instead of the source's
+	// (x-amz-tagging-directive: REPLACE)
+	ReplaceTags bool
+	Tags        map[string]string
+
+	// ServerSideEncryption, if set, is recorded instead of the source's
+	// (x-amz-server-side-encryption)
+	ServerSideEncryption string
+}
+
+// DeleteBucketOptions changes how a bucket is deleted
+type DeleteBucketOptions struct {
+	// Force deletes a non-empty bucket together with all its objects and
+	// versions instead of returning ErrBucketNotEmpty. Versions under legal
+	// hold, within a retention period or within the bucket's immutability
+	// window still prevent the deletion.
+	Force bool
+}
+
+// Storage defines the interface for object storage operations. Operations
+// that copy object data take a context and stop with its error once it is
+// done.
 type Storage interface {
 	// PutObject stores an object with the given key
-	PutObject(bucket, key string, contentType string, metadata map[string]string, body io.Reader) (*s3.ObjectMetadata, error)
+	PutObject(ctx context.Context, bucket, key string, contentType string, metadata map[string]string, body io.Reader) (*s3.ObjectMetadata, error)
+
+	// PutObjectWithOptions stores an object together with optional attributes
+	PutObjectWithOptions(ctx context.Context, bucket, key string, contentType string, metadata map[string]string, opts PutObjectOptions, body io.Reader) (*s3.ObjectMetadata, error)
 
-	// GetObject retrieves an object by key
-	GetObject(bucket, key string) (io.ReadCloser, *s3.ObjectMetadata, error)
+	// GetObject retrieves an object by key. Reads fail once ctx is done.
+	GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, *s3.ObjectMetadata, error)
 
-	// GetObjectRange retrieves a range of bytes from an object
-	GetObjectRange(bucket, key string, start, end int64) (io.ReadCloser, *s3.ObjectMetadata, error)
+	// OpenObject opens an object for reading at arbitrary offsets, for
+	// serving it with http.ServeContent or sendfile
+	OpenObject(bucket, key string) (io.ReadSeekCloser, *s3.ObjectMetadata, error)
+
+	// GetObjectRange retrieves a range of bytes from an object. Reads fail
+	// once ctx is done.
+	GetObjectRange(ctx context.Context, bucket, key string, start, end int64) (io.ReadCloser, *s3.ObjectMetadata, error)
 
 	// HeadObject retrieves object metadata without the body
 	HeadObject(bucket, key string) (*s3.ObjectMetadata, error)
@@ -47,8 +103,23 @@ type Storage interface {
 	// ListObjects lists objects with optional prefix, delimiter, and pagination
 	ListObjects(bucket string, opts ListObjectsOptions) (*ListObjectsResult, error)
 
+	// ListObjectsFunc lists objects like ListObjects, calling fn with each
+	// object and common prefix as it is read instead of collecting them
+	ListObjectsFunc(bucket string, opts ListObjectsOptions, fn func(ListEntry) error) (*ListObjectsResult, error)
+
+	// CountObjects returns the number of objects in a bucket
+	CountObjects(bucket string) (int, error)
+
 	// CopyObject copies an object from source key to destination key
-	CopyObject(srcBucket, srcKey, dstBucket, dstKey string) (*s3.ObjectMetadata, error)
+	CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string) (*s3.ObjectMetadata, error)
+
+	// CopyObjectWithOptions copies an object, optionally replacing its
+	// metadata or tags
+	CopyObjectWithOptions(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, opts CopyObjectOptions) (*s3.ObjectMetadata, error)
+
+	// PutObjectLegalHold sets the legal hold status (ON or OFF) of an object.
+	// While ON, the object cannot be deleted or overwritten.
+	PutObjectLegalHold(bucket, key, status string) error
 }
 
 // BucketStorage defines the interface for bucket operations
@@ -59,23 +130,95 @@ type BucketStorage interface {
 	// DeleteBucket deletes a bucket (must be empty)
 	DeleteBucket(name string) error
 
+	// DeleteBucketWithOptions deletes a bucket, optionally with its objects
+	DeleteBucketWithOptions(name string, opts DeleteBucketOptions) error
+
 	// BucketExists checks if a bucket exists
 	BucketExists(name string) (bool, error)
+
+	// BucketNames returns the names of all buckets in sorted order
+	BucketNames() ([]string, error)
+
+	// BucketUsage returns the number of objects in a bucket and their
+	// total size
+	BucketUsage(bucket string) (BucketUsage, error)
+}
+
+// VersioningStorage defines the interface for bucket versioning
+type VersioningStorage interface {
+	// GetBucketVersioning returns the versioning status of a bucket, empty
+	// if versioning was never enabled
+	GetBucketVersioning(bucket string) (string, error)
+
+	// PutBucketVersioning enables or suspends versioning for a bucket
+	PutBucketVersioning(bucket, status string) error
+
+	// HeadObjectVersion retrieves the metadata of a specific object version
+	HeadObjectVersion(bucket, key, versionID string) (*s3.ObjectMetadata, error)
+
+	// OpenObjectVersion opens a specific object version for reading
+	OpenObjectVersion(bucket, key, versionID string) (io.ReadSeekCloser, *s3.ObjectMetadata, error)
+
+	// DeleteObjectVersion deletes a specific object version, or inserts a
+	// delete marker when versionID is empty and the bucket is versioned
+	DeleteObjectVersion(bucket, key, versionID string) (*s3.ObjectMetadata, error)
+
+	// ListObjectVersions lists the versions and delete markers in a bucket
+	ListObjectVersions(bucket string, opts ListObjectVersionsOptions) (*ListObjectVersionsResult, error)
+}
+
+// LifecycleStorage defines the interface for bucket lifecycle rules
+type LifecycleStorage interface {
+	// GetBucketLifecycle returns the lifecycle rules of a bucket
+	GetBucketLifecycle(bucket string) ([]LifecycleRule, error)
+
+	// PutBucketLifecycle replaces the lifecycle rules of a bucket
+	PutBucketLifecycle(bucket string, rules []LifecycleRule) error
+
+	// DeleteBucketLifecycle removes the lifecycle rules of a bucket
+	DeleteBucketLifecycle(bucket string) error
+
+	// ExpireObjects deletes the objects expired by lifecycle rules
+	ExpireObjects(now time.Time) ([]LifecycleExpiration, error)
+}
+
+// CORSStorage defines the interface for bucket CORS rules
+type CORSStorage interface {
+	// GetBucketCORS returns the CORS rules of a bucket
+	GetBucketCORS(bucket string) ([]CORSRule, error)
+
+	// PutBucketCORS replaces the CORS rules of a bucket
+	PutBucketCORS(bucket string, rules []CORSRule) error
+
+	// DeleteBucketCORS removes the CORS rules of a bucket
+	DeleteBucketCORS(bucket string) error
+}
+
+// MetadataUpdater is implemented by storage that can replace the metadata
+// of an object in place, without rewriting its data
+type MetadataUpdater interface {
+	// UpdateMetadata replaces the content type, user metadata and tags of
+	// an object, leaving its data and ETag unchanged. It does not keep the
+	// previous metadata, so it is not for buckets with versioning.
+	UpdateMetadata(bucket, key, contentType string, metadata, tags map[string]string) (*s3.ObjectMetadata, error)
 }
 
 // MultipartStorage defines the interface for multipart upload operations
 type MultipartStorage interface {
 	Storage
 	BucketStorage
+	VersioningStorage
+	LifecycleStorage
+	CORSStorage
 
 	// CreateMultipartUpload initializes a new multipart upload
 	CreateMultipartUpload(bucket, key string, contentType string, metadata map[string]string) (uploadID string, err error)
 
 	// UploadPart stores a part of a multipart upload
-	UploadPart(uploadID string, partNumber int, body io.Reader) (*s3.PartMetadata, error)
+	UploadPart(ctx context.Context, uploadID string, partNumber int, body io.Reader) (*s3.PartMetadata, error)
 
 	// CompleteMultipartUpload assembles all parts into the final object
-	CompleteMultipartUpload(uploadID string, parts []s3.CompletedPartInput) (*s3.ObjectMetadata, error)
+	CompleteMultipartUpload(ctx context.Context, uploadID string, parts []s3.CompletedPartInput) (*s3.ObjectMetadata, error)
 
 	// AbortMultipartUpload cancels a multipart upload and cleans up parts
 	AbortMultipartUpload(uploadID string) error
@@ -85,4 +228,12 @@ type MultipartStorage interface {
 
 	// CleanupStaleUploads removes multipart uploads older than maxAge
 	CleanupStaleUploads(maxAge time.Duration) (int, error)
+
+	// CheckWritable verifies that files can be created and removed in the
+	// data, multipart and bucket override paths
+	CheckWritable() error
+
+	// DiskSpace returns the capacity of the filesystems holding the data of
+	// bucket, or the data path when bucket is empty, and the multipart path
+	DiskSpace(bucket string) (data, multipart DiskSpace, err error)
 }
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// ErrObjectAlreadyExists is returned by RestoreObject when the key to
+// restore exists
+var ErrObjectAlreadyExists = errors.New("object already exists")
+
+// trashDir is the directory in a bucket directory that deleted objects are
+// kept in while trash is enabled. Each deleted object is a directory named
+// {unix nanoseconds of the delete}-{object directory name}, holding the
+// data and meta.json of the object.
+const trashDir = ".trash"
+
+// TrashStorage is implemented by storage that keeps deleted objects for a
+// while before removing them
+type TrashStorage interface {
+	// RestoreObject brings back the most recently deleted copy of key. It
+	// returns ErrObjectNotFound when the trash has no copy of key, and
+	// ErrObjectAlreadyExists when key exists.
+	RestoreObject(bucket, key string) (*s3.ObjectMetadata, error)
+
+	// PurgeTrash removes the objects deleted longer than the trash
+	// retention before now, and returns how many it removed
+	PurgeTrash(now time.Time) (int, error)
+}
+
+// trashPath returns the trash directory of a bucket
+func (fs *FilesystemStorage) trashPath(bucket string) string {
+	return filepath.Join(fs.bucketPath(bucket), trashDir)
+}
+
+// moveToTrash moves the current version of an unversioned object to the
+// trash of its bucket (caller must hold the object lock). The data is moved
+// before the metadata is removed, so that a crash in between leaves the
+// object in place rather than losing it.
+func (fs *FilesystemStorage) moveToTrash(bucket, key, objPath string) error {
+	meta, err := fs.meta.get(bucket, key, objPath)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			// Nothing to keep; remove whatever is left of the object
+			return fs.removeCurrent(bucket, key, objPath)
+		}
+		return err
+	}
+
+	entry := filepath.Join(fs.trashPath(bucket), fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(objPath)))
+	if err := os.MkdirAll(entry, 0700); err != nil {
+		return fmt.Errorf("creating trash directory: %w", err)
+	}
+	if err := writeFileAtomic(fs.syncer, filepath.Join(entry, "meta.json"), meta); err != nil {
+		os.RemoveAll(entry)
+		return err
+	}
+	if err := os.Rename(filepath.Join(objPath, "data"), filepath.Join(entry, "data")); err != nil {
+		os.RemoveAll(entry)
+		return fmt.Errorf("moving object to trash: %w", err)
+	}
+	if err := syncObjectDirs(fs.syncer, entry); err != nil {
+		return fmt.Errorf("syncing trash directory: %w", err)
+	}
+	return fs.removeCurrent(bucket, key, objPath)
+}
+
+// trashEntries returns the trash directories of an object, newest first
+func (fs *FilesystemStorage) trashEntries(bucket, objPath string) ([]string, error) {
+	entries, err := filepath.Glob(filepath.Join(fs.trashPath(bucket), "*-"+filepath.Base(objPath)))
+	if err != nil {
+		return nil, err
+	}
+	// Names start with a fixed-width timestamp, so they sort by delete time
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// RestoreObject moves the most recently deleted copy of key from the trash
+// back into the bucket, with the metadata it had when it was deleted
+func (fs *FilesystemStorage) RestoreObject(bucket, key string) (*s3.ObjectMetadata, error) {
+	objPath, err := fs.keyToPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if exists, err := fs.BucketExists(bucket); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, ErrBucketNotFound
+	}
+
+	unlock := fs.lockObject(objPath)
+	defer unlock()
+
+	if _, err := fs.meta.get(bucket, key, objPath); err == nil {
+		return nil, ErrObjectAlreadyExists
+	} else if !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	entries, err := fs.trashEntries(bucket, objPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		var meta s3.ObjectMetadata
+		if err := readJSONFile(filepath.Join(entry, "meta.json"), &meta); err != nil {
+			return nil, fmt.Errorf("reading trashed metadata: %w", err)
+		}
+		if meta.Key != key {
+			continue
+		}
+
+		if err := os.MkdirAll(objPath, 0700); err != nil {
+			return nil, fmt.Errorf("creating object directory: %w", err)
+		}
+		dataPath := filepath.Join(objPath, "data")
+		if err := os.Rename(filepath.Join(entry, "data"), dataPath); err != nil {
+			return nil, fmt.Errorf("restoring object data: %w", err)
+		}
+		if err := syncObjectDirs(fs.syncer, objPath); err != nil {
+			return nil, fmt.Errorf("syncing object directory: %w", err)
+		}
+		if err := fs.meta.put(bucket, objPath, &meta); err != nil {
+			// Keep the data in the trash so that the restore can be retried
+			os.Rename(dataPath, filepath.Join(entry, "data"))
+			return nil, err
+		}
+		if err := os.RemoveAll(entry); err != nil {
+			return nil, fmt.Errorf("removing trash entry: %w", err)
+		}
+		return &meta, nil
+	}
+	return nil, ErrObjectNotFound
+}
+
+// PurgeTrash removes the trashed objects of every bucket that were deleted
+// at least the trash retention before now. With trash disabled, everything
+// left in the trash is removed.
+func (fs *FilesystemStorage) PurgeTrash(now time.Time) (int, error) {
+	buckets, err := fs.BucketNames()
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int
+	for _, bucket := range buckets {
+		entries, err := os.ReadDir(fs.trashPath(bucket))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return purged, fmt.Errorf("reading trash of %s: %w", bucket, err)
+		}
+		for _, entry := range entries {
+			stamp, _, ok := strings.Cut(entry.Name(), "-")
+			nanos, err := strconv.ParseInt(stamp, 10, 64)
+			if !ok || err != nil {
+				continue
+			}
+			if now.Sub(time.Unix(0, nanos)) < fs.trashRetention {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(fs.trashPath(bucket), entry.Name())); err != nil {
+				return purged, fmt.Errorf("purging trash of %s: %w", bucket, err)
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupTrashStorage(t *testing.T, metadataStore string) *FilesystemStorage {
+	t.Helper()
+	tmpDir := t.TempDir()
+	storage, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), FilesystemOptions{
+		MetadataStore:  metadataStore,
+		TrashRetention: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := storage.CreateBucket(testBucket); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	return storage
+}
+
+func TestTrashRestore(t *testing.T) {
+	for _, store := range []string{MetadataStoreJSON, MetadataStoreKV} {
+		t.Run(store, func(t *testing.T) {
+			storage := setupTrashStorage(t, store)
+			ctx := context.Background()
+
+			// Each delete keeps a copy; the newest is restored
+			var put []string
+			for _, body := range []string{"first", "second"} {
+				meta, err := storage.PutObjectWithOptions(ctx, testBucket, "doc", "text/plain", nil, PutObjectOptions{Tags: map[string]string{"v": body}}, strings.NewReader(body))
+				if err != nil {
+					t.Fatalf("PutObject failed: %v", err)
+				}
+				put = append(put, meta.ETag)
+				if err := storage.DeleteObject(testBucket, "doc"); err != nil {
+					t.Fatalf("DeleteObject failed: %v", err)
+				}
+			}
+
+			// Trashed objects are gone from reads, listings and usage
+			if _, err := storage.HeadObject(testBucket, "doc"); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("HeadObject after delete err = %v, want ErrObjectNotFound", err)
+			}
+			result, err := storage.ListObjects(testBucket, ListObjectsOptions{})
+			if err != nil || len(result.Objects) != 0 {
+				t.Errorf("ListObjects = %+v, %v; want no objects", result, err)
+			}
+			if usage, err := storage.BucketUsage(testBucket); err != nil || usage.Objects != 0 {
+				t.Errorf("BucketUsage = %+v, %v; want no objects", usage, err)
+			}
+
+			meta, err := storage.RestoreObject(testBucket, "doc")
+			if err != nil {
+				t.Fatalf("RestoreObject failed: %v", err)
+			}
+			if meta.ETag != put[1] || meta.Tags["v"] != "second" {
+				t.Errorf("restored = %+v, want the second write", meta)
+			}
+			reader, _, err := storage.GetObject(ctx, testBucket, "doc")
+			if err != nil {
+				t.Fatalf("GetObject after restore failed: %v", err)
+			}
+			data, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(data) != "second" {
+				t.Errorf("restored data = %q, want %q", data, "second")
+			}
+
+			// A restore never replaces an existing object
+			if _, err := storage.RestoreObject(testBucket, "doc"); !errors.Is(err, ErrObjectAlreadyExists) {
+				t.Errorf("RestoreObject over an object err = %v, want ErrObjectAlreadyExists", err)
+			}
+			if err := storage.DeleteObject(testBucket, "doc"); err != nil {
+				t.Fatalf("DeleteObject failed: %v", err)
+			}
+			if _, err := storage.RestoreObject(testBucket, "missing"); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("RestoreObject(missing) err = %v, want ErrObjectNotFound", err)
+			}
+			if _, err := storage.RestoreObject("no-such-bucket", "doc"); !errors.Is(err, ErrBucketNotFound) {
+				t.Errorf("RestoreObject in a missing bucket err = %v, want ErrBucketNotFound", err)
+			}
+		})
+	}
+}
+
+func TestTrashRestoreAfterNullVersionDelete(t *testing.T) {
+	storage := setupTrashStorage(t, MetadataStoreJSON)
+
+	put, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	// SDKs send versionId=null for objects of buckets without versioning
+	if _, err := storage.DeleteObjectVersion(testBucket, "doc", NullVersionID); err != nil {
+		t.Fatalf("DeleteObjectVersion(null) failed: %v", err)
+	}
+	if _, err := storage.HeadObject(testBucket, "doc"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("HeadObject after delete err = %v, want ErrObjectNotFound", err)
+	}
+
+	meta, err := storage.RestoreObject(testBucket, "doc")
+	if err != nil {
+		t.Fatalf("RestoreObject failed: %v", err)
+	}
+	if meta.ETag != put.ETag {
+		t.Errorf("restored ETag = %s, want %s", meta.ETag, put.ETag)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	storage := setupTrashStorage(t, MetadataStoreJSON)
+
+	if _, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("data")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := storage.DeleteObject(testBucket, "doc"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+
+	if purged, err := storage.PurgeTrash(time.Now()); err != nil || purged != 0 {
+		t.Errorf("PurgeTrash within retention = %d, %v; want 0", purged, err)
+	}
+	if purged, err := storage.PurgeTrash(time.Now().Add(time.Hour)); err != nil || purged != 1 {
+		t.Errorf("PurgeTrash after retention = %d, %v; want 1", purged, err)
+	}
+	if _, err := storage.RestoreObject(testBucket, "doc"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("RestoreObject after purge err = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestTrashSkipsVersionedBuckets(t *testing.T) {
+	storage := setupTrashStorage(t, MetadataStoreJSON)
+
+	if err := storage.PutBucketVersioning(testBucket, VersioningEnabled); err != nil {
+		t.Fatalf("PutBucketVersioning failed: %v", err)
+	}
+	if _, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("data")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	// The delete marker keeps the object as a noncurrent version instead
+	if err := storage.DeleteObject(testBucket, "doc"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, err := storage.RestoreObject(testBucket, "doc"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("RestoreObject err = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestMemoryBackendRejectsTrash(t *testing.T) {
+	if _, err := New(BackendMemory, BackendOptions{TrashRetention: time.Hour}); err == nil {
+		t.Error("New(memory) with trash retention succeeded, want an error")
+	}
+}
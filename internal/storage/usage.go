@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// BucketUsage is the number of objects in a bucket and their total size.
+// Only current versions count; noncurrent versions and delete markers do
+// not.
+type BucketUsage struct {
+	Objects int64
+	Bytes   int64
+}
+
+// usageMetadataStore is a metadataStore that keeps the usage of each
+// bucket up to date as object metadata is written and removed. The usage
+// of a bucket is counted from its metadata the first time it is needed.
+// Callers hold the object lock, so the metadata a write replaces cannot
+// change between reading it and writing the new metadata.
+type usageMetadataStore struct {
+	metadataStore
+	mu      sync.Mutex
+	buckets map[string]*bucketUsage
+}
+
+// bucketUsage is the usage of one bucket. Writers hold mu for reading while
+// they change the bucket's metadata, and counting holds it for writing, so
+// that a count never includes a write that is also added to it.
+type bucketUsage struct {
+	mu      sync.RWMutex
+	counted bool
+	objects atomic.Int64
+	bytes   atomic.Int64
+}
+
+func newUsageMetadataStore(meta metadataStore) *usageMetadataStore {
+	return &usageMetadataStore{metadataStore: meta, buckets: make(map[string]*bucketUsage)}
+}
+
+// bucket returns the usage of a bucket, which is not counted yet when it
+// is first requested
+func (s *usageMetadataStore) bucket(name string) *bucketUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.buckets[name]
+	if !ok {
+		u = &bucketUsage{}
+		s.buckets[name] = u
+	}
+	return u
+}
+
+func (s *usageMetadataStore) put(bucket, objPath string, meta *s3.ObjectMetadata) error {
+	u := s.bucket(bucket)
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	old, _ := s.metadataStore.get(bucket, meta.Key, objPath)
+	if err := s.metadataStore.put(bucket, objPath, meta); err != nil {
+		return err
+	}
+	u.replace(old, meta)
+	return nil
+}
+
+func (s *usageMetadataStore) delete(bucket, key, objPath string) error {
+	u := s.bucket(bucket)
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	old, _ := s.metadataStore.get(bucket, key, objPath)
+	if err := s.metadataStore.delete(bucket, key, objPath); err != nil {
+		return err
+	}
+	u.replace(old, nil)
+	return nil
+}
+
+// replace applies the replacement of old by meta, either of which may be
+// nil, to a counted usage (caller must hold u.mu for reading)
+func (u *bucketUsage) replace(old, meta *s3.ObjectMetadata) {
+	if !u.counted {
+		return
+	}
+	if old != nil {
+		u.objects.Add(-1)
+		u.bytes.Add(-old.Size)
+	}
+	if meta != nil {
+		u.objects.Add(1)
+		u.bytes.Add(meta.Size)
+	}
+}
+
+func (s *usageMetadataStore) dropBucket(bucket string) {
+	s.metadataStore.dropBucket(bucket)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets, bucket)
+}
+
+// reload reloads the metadata of a bucket, which is counted again when its
+// usage is next needed
+func (s *usageMetadataStore) reload(bucket string) error {
+	if err := s.metadataStore.reload(bucket); err != nil {
+		return err
+	}
+	u := s.bucket(bucket)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.counted = false
+	return nil
+}
+
+// usage returns the usage of a bucket, counting it from the bucket's
+// metadata if it was not counted yet
+func (s *usageMetadataStore) usage(bucket string) (BucketUsage, error) {
+	u := s.bucket(bucket)
+	u.mu.RLock()
+	if u.counted {
+		defer u.mu.RUnlock()
+		return BucketUsage{Objects: u.objects.Load(), Bytes: u.bytes.Load()}, nil
+	}
+	u.mu.RUnlock()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.counted {
+		objects, err := s.metadataStore.list(bucket)
+		if err != nil {
+			return BucketUsage{}, err
+		}
+		var bytes int64
+		for i := range objects {
+			bytes += objects[i].Size
+		}
+		u.objects.Store(int64(len(objects)))
+		u.bytes.Store(bytes)
+		u.counted = true
+	}
+	return BucketUsage{Objects: u.objects.Load(), Bytes: u.bytes.Load()}, nil
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+func TestBucketUsage(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	checkUsage := func(want BucketUsage) {
+		t.Helper()
+		got, err := storage.BucketUsage(testBucket)
+		if err != nil {
+			t.Fatalf("BucketUsage failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("usage = %+v, want %+v", got, want)
+		}
+	}
+
+	// Objects written before the usage is first needed are counted
+	if _, err := storage.PutObject(ctx, testBucket, "a", "text/plain", nil, strings.NewReader("12345")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	checkUsage(BucketUsage{Objects: 1, Bytes: 5})
+
+	// An overwrite changes the size, not the count
+	if _, err := storage.PutObject(ctx, testBucket, "a", "text/plain", nil, strings.NewReader("12")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	checkUsage(BucketUsage{Objects: 1, Bytes: 2})
+
+	if _, err := storage.CopyObject(ctx, testBucket, "a", testBucket, "b"); err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+	checkUsage(BucketUsage{Objects: 2, Bytes: 4})
+
+	uploadID, err := storage.CreateMultipartUpload(testBucket, "c", "application/octet-stream", nil)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	part, err := storage.UploadPart(ctx, uploadID, 1, bytes.NewReader(make([]byte, 100)))
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	if _, err := storage.CompleteMultipartUpload(ctx, uploadID, []s3.CompletedPartInput{{PartNumber: 1, ETag: part.ETag}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	checkUsage(BucketUsage{Objects: 3, Bytes: 104})
+
+	if err := storage.DeleteObject(testBucket, "a"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	checkUsage(BucketUsage{Objects: 2, Bytes: 102})
+
+	// Noncurrent versions and delete markers are not counted
+	if err := storage.PutBucketVersioning(testBucket, VersioningEnabled); err != nil {
+		t.Fatalf("PutBucketVersioning failed: %v", err)
+	}
+	if _, err := storage.PutObject(ctx, testBucket, "b", "text/plain", nil, strings.NewReader("123")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	checkUsage(BucketUsage{Objects: 2, Bytes: 103})
+	if err := storage.DeleteObject(testBucket, "b"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	checkUsage(BucketUsage{Objects: 1, Bytes: 100})
+
+	// A reindex counts the bucket again
+	objPath, _ := storage.keyToPath(testBucket, "c")
+	if err := os.Remove(filepath.Join(objPath, "data")); err != nil {
+		t.Fatalf("removing data: %v", err)
+	}
+	if _, err := storage.Reindex(testBucket, nil); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	checkUsage(BucketUsage{})
+
+	if _, err := storage.BucketUsage("no-such-bucket"); err != ErrBucketNotFound {
+		t.Errorf("BucketUsage of missing bucket error = %v, want ErrBucketNotFound", err)
+	}
+}
+
+func TestBucketUsageConcurrentWrites(t *testing.T) {
+	for _, mode := range []string{MetadataStoreJSON, MetadataStoreKV} {
+		t.Run(mode, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			storage, err := NewFilesystemStorageWithOptions(filepath.Join(tmpDir, "data"), filepath.Join(tmpDir, "multipart"), FilesystemOptions{MetadataStore: mode, DisableSync: true})
+			if err != nil {
+				t.Fatalf("failed to create storage: %v", err)
+			}
+			if err := storage.CreateBucket(testBucket); err != nil {
+				t.Fatalf("CreateBucket failed: %v", err)
+			}
+
+			// Writers overwrite and delete a few keys while the usage is
+			// counted for the first time
+			const writers, perWriter = 8, 50
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWriter; i++ {
+						key := fmt.Sprintf("key-%d", (w+i)%5)
+						if i%7 == 0 {
+							_ = storage.DeleteObject(testBucket, key)
+							continue
+						}
+						if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader(strings.Repeat("x", w*10+i))); err != nil {
+							t.Errorf("PutObject(%s) failed: %v", key, err)
+							return
+						}
+					}
+				}(w)
+			}
+			if _, err := storage.BucketUsage(testBucket); err != nil {
+				t.Errorf("BucketUsage failed: %v", err)
+			}
+			wg.Wait()
+
+			got, err := storage.BucketUsage(testBucket)
+			if err != nil {
+				t.Fatalf("BucketUsage failed: %v", err)
+			}
+			objects, err := storage.meta.list(testBucket)
+			if err != nil {
+				t.Fatalf("list failed: %v", err)
+			}
+			want := BucketUsage{Objects: int64(len(objects))}
+			for _, obj := range objects {
+				want.Bytes += obj.Size
+			}
+			if got != want {
+				t.Errorf("usage = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// LayoutIssue is an inconsistency between object data and metadata found by
+// VerifyDataDirectory
+type LayoutIssue struct {
+	Bucket string
+	// Path is the object or version directory, relative to the base path
+	// the bucket is stored under
+	Path    string
+	Problem string
+	// Quarantined is where the object was moved to, relative to the base
+	// path the bucket is stored under, or empty when it was left in place
+	Quarantined string
+}
+
+// quarantineDir is the directory in the data directory, or in a bucket's
+// own base path, that corrupt objects are moved to
+const quarantineDir = "quarantine"
+
+// VerifyOptions are the optional checks and fixes of
+// VerifyDataDirectoryWithOptions
+type VerifyOptions struct {
+	// Checksums recomputes the MD5 of every data file and compares it to
+	// the ETag in the metadata. ETags of multipart uploads are not a hash
+	// of the data and are not checked.
+	Checksums bool
+	// Quarantine moves objects and versions whose data does not match
+	// their metadata to a timestamped directory under quarantine in the
+	// base path of their bucket, so that they are no longer served
+	Quarantine bool
+}
+
+// VerifyResult reports the outcome of verifying a bucket
+type VerifyResult struct {
+	Bucket string
+	// Objects is the number of object directories checked
+	Objects int
+	Issues  []LayoutIssue
+}
+
+// VerifyDataDirectory checks that every object of bucket in the data
+// directory at basePath, or of every bucket when bucket is empty, has both
+// a data file and readable metadata, and that the size in the metadata
+// matches the data file. Noncurrent versions are checked the same way. It
+// only reads, and must run while the service is stopped so that writes in
+// progress are not reported. Buckets in bucketPaths are stored under their
+// own base path, as with STUPID_BUCKET_PATHS.
+func VerifyDataDirectory(basePath string, bucketPaths map[string]string, bucket string) ([]*VerifyResult, error) {
+	return VerifyDataDirectoryWithOptions(basePath, bucketPaths, bucket, VerifyOptions{})
+}
+
+// VerifyDataDirectoryWithOptions verifies the data directory as
+// VerifyDataDirectory does, with the additional checks and fixes in opts.
+// Only objects whose data is corrupt are quarantined; data or metadata
+// without its counterpart is left for reindex.
+func VerifyDataDirectoryWithOptions(basePath string, bucketPaths map[string]string, bucket string, opts VerifyOptions) ([]*VerifyResult, error) {
+	mode, err := readMetadataStoreMarker(basePath)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := newMetadataStore(basePath, bucketPaths, mode, noSyncer{})
+	if err != nil {
+		return nil, err
+	}
+	fs := &FilesystemStorage{basePath: basePath, bucketPaths: bucketPaths, meta: meta, syncer: noSyncer{}}
+
+	buckets, err := fs.dataDirectoryBuckets(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var quarantineRun string
+	if opts.Quarantine {
+		quarantineRun = time.Now().UTC().Format("20060102T150405Z")
+	}
+
+	var results []*VerifyResult
+	for _, name := range buckets {
+		var quarantinePath string
+		if quarantineRun != "" {
+			// Quarantine on the bucket's own volume, so objects are renamed
+			// rather than copied
+			quarantinePath = filepath.Join(fs.bucketBase(name), quarantineDir, quarantineRun)
+		}
+		result, err := fs.verifyBucket(name, mode == MetadataStoreKV, opts.Checksums, quarantinePath)
+		if err != nil {
+			return results, fmt.Errorf("verifying bucket %s: %w", name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// dataDirectoryBuckets returns bucket, or the names of all buckets in the
+// data directory and the bucket paths when bucket is empty
+func (fs *FilesystemStorage) dataDirectoryBuckets(bucket string) ([]string, error) {
+	if bucket != "" {
+		return []string{bucket}, nil
+	}
+	if _, err := os.Stat(filepath.Join(fs.basePath, "buckets")); err != nil {
+		return nil, fmt.Errorf("reading buckets directory: %w", err)
+	}
+	return fs.BucketNames()
+}
+
+// verifyBucket checks the object directories of a bucket. With the kv
+// store, metadata comes from the bucket's log instead of meta.json files.
+// Corrupt objects are moved under quarantinePath unless it is empty.
+func (fs *FilesystemStorage) verifyBucket(bucket string, kv, checksums bool, quarantinePath string) (*VerifyResult, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(fs.bucketPath(bucket), "objects")); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrBucketNotFound
+		}
+		return nil, fmt.Errorf("checking bucket directory: %w", err)
+	}
+
+	result := &VerifyResult{Bucket: bucket}
+	report := func(dir, format string, args ...any) {
+		rel, err := filepath.Rel(fs.bucketBase(bucket), dir)
+		if err != nil {
+			rel = dir
+		}
+		result.Issues = append(result.Issues, LayoutIssue{Bucket: bucket, Path: rel, Problem: fmt.Sprintf(format, args...)})
+	}
+	// quarantine moves the object or version just reported as corrupt
+	quarantine := func(dir string, meta *s3.ObjectMetadata, version bool) {
+		if quarantinePath == "" {
+			return
+		}
+		issue := &result.Issues[len(result.Issues)-1]
+		moved, err := fs.quarantineObject(bucket, dir, meta, version, quarantinePath)
+		if err != nil {
+			issue.Problem += fmt.Sprintf("; quarantine failed: %v", err)
+			return
+		}
+		issue.Quarantined = moved
+	}
+
+	// Metadata in the kv log, by object directory. Entries left over once
+	// all directories have been checked have no object directory.
+	var logged map[string]*s3.ObjectMetadata
+	if kv {
+		objects, err := fs.meta.list(bucket)
+		if err != nil {
+			return nil, err
+		}
+		logged = make(map[string]*s3.ObjectMetadata, len(objects))
+		for i := range objects {
+			objPath, err := fs.keyToPath(bucket, objects[i].Key)
+			if err != nil {
+				return nil, err
+			}
+			logged[objPath] = &objects[i]
+		}
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(fs.bucketPath(bucket), "objects", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, objPath := range dirs {
+		if info, err := os.Stat(objPath); err != nil || !info.IsDir() {
+			continue
+		}
+		result.Objects++
+
+		var meta *s3.ObjectMetadata
+		if kv {
+			meta = logged[objPath]
+			delete(logged, objPath)
+		} else {
+			meta, err = fs.meta.get(bucket, "", objPath)
+			if errors.Is(err, ErrObjectNotFound) {
+				err = nil
+			}
+		}
+
+		// Metadata in the wrong directory belongs to another key, whose
+		// store entries must not be touched by a quarantine
+		misplaced := false
+		if meta != nil {
+			if want, err := fs.keyToPath(bucket, meta.Key); err != nil || want != objPath {
+				report(objPath, "metadata key %q does not belong in this directory", meta.Key)
+				misplaced = true
+			}
+		}
+		if err != nil {
+			report(objPath, "unreadable meta.json: %v", err)
+		} else if verifyObject(objPath, meta, checksums, report) && !misplaced {
+			quarantine(objPath, meta, false)
+		}
+
+		if err := verifyVersions(objPath, checksums, report, quarantine); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, objPath := range slices.Sorted(maps.Keys(logged)) {
+		report(objPath, "metadata without data")
+	}
+	return result, nil
+}
+
+// verifyObject checks the data file of an object or version against its
+// metadata, which is nil when missing. Delete markers have no data. It
+// returns true if the data is corrupt.
+func verifyObject(dir string, meta *s3.ObjectMetadata, checksums bool, report func(dir, format string, args ...any)) bool {
+	dataPath := filepath.Join(dir, "data")
+	info, err := os.Stat(dataPath)
+	switch {
+	case err != nil && !os.IsNotExist(err):
+		report(dir, "unreadable data file: %v", err)
+	case err == nil && meta == nil:
+		report(dir, "data without metadata")
+	case err != nil && meta != nil && !meta.DeleteMarker:
+		report(dir, "metadata without data")
+	case err == nil && meta.DeleteMarker:
+		report(dir, "delete marker with data")
+	case err == nil && info.Size() != meta.Size:
+		report(dir, "size mismatch: metadata has %d bytes, data file has %d", meta.Size, info.Size())
+		return true
+	case err == nil && checksums && !strings.Contains(meta.ETag, "-"):
+		sum, err := dataChecksum(dataPath)
+		if err != nil {
+			report(dir, "unreadable data file: %v", err)
+			return false
+		}
+		if sum != meta.ETag {
+			report(dir, "checksum mismatch: metadata has ETag %s, data hashes to %s", meta.ETag, sum)
+			return true
+		}
+	}
+	return false
+}
+
+// dataChecksum returns the MD5 of a data file, quoted like the ETag of an
+// object uploaded in a single part
+func dataChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("\"%s\"", hex.EncodeToString(hash.Sum(nil))), nil
+}
+
+// quarantineObject moves the corrupt object or version at dir to the same
+// path under quarantinePath, where it is no longer served but can still be
+// inspected. It returns the new path relative to the base path of the
+// bucket.
+func (fs *FilesystemStorage) quarantineObject(bucket, dir string, meta *s3.ObjectMetadata, version bool, quarantinePath string) (string, error) {
+	base := fs.bucketBase(bucket)
+	rel, err := filepath.Rel(base, dir)
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(quarantinePath, rel)
+
+	if version {
+		// A noncurrent version is a directory of its own
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return "", err
+		}
+		if err := os.Rename(dir, dst); err != nil {
+			return "", err
+		}
+		return filepath.Rel(base, dst)
+	}
+
+	// The metadata of a current object may live in the kv log, so it is
+	// written out next to the data before it is deleted from the store.
+	// Noncurrent versions stay in place.
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(noSyncer{}, filepath.Join(dst, "meta.json"), meta); err != nil {
+		return "", err
+	}
+	if err := os.Rename(filepath.Join(dir, "data"), filepath.Join(dst, "data")); err != nil {
+		return "", err
+	}
+	if err := fs.meta.delete(bucket, meta.Key, dir); err != nil {
+		return "", err
+	}
+	if err := os.Remove(filepath.Join(dir, "meta.json")); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	// Only succeeds when the object has no versions left
+	_ = os.Remove(dir)
+	return filepath.Rel(base, dst)
+}
+
+// verifyVersions checks the noncurrent versions of the object at objPath
+func verifyVersions(objPath string, checksums bool, report func(dir, format string, args ...any), quarantine func(dir string, meta *s3.ObjectMetadata, version bool)) error {
+	entries, err := os.ReadDir(filepath.Join(objPath, versionsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("reading object versions: %w", err)
+	}
+
+	for _, entry := range entries {
+		versionPath := filepath.Join(objPath, versionsDir, entry.Name())
+		var meta s3.ObjectMetadata
+		if err := readJSONFile(filepath.Join(versionPath, versionMetaFile), &meta); err != nil {
+			if !os.IsNotExist(err) {
+				report(versionPath, "unreadable %s: %v", versionMetaFile, err)
+				continue
+			}
+			verifyObject(versionPath, nil, checksums, report)
+			continue
+		}
+		if verifyObject(versionPath, &meta, checksums, report) {
+			quarantine(versionPath, &meta, true)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+)
+
+// verifyIssues runs VerifyDataDirectory and returns "path: problem" lines
+// with the object directory replaced by the key
+func verifyIssues(t *testing.T, storage *FilesystemStorage, keys ...string) []string {
+	t.Helper()
+	results, err := VerifyDataDirectory(storage.basePath, storage.bucketPaths, "")
+	if err != nil {
+		t.Fatalf("VerifyDataDirectory failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Bucket != testBucket {
+		t.Fatalf("results = %+v, want one for %s", results, testBucket)
+	}
+
+	var issues []string
+	for _, issue := range results[0].Issues {
+		path := issue.Path
+		for _, key := range keys {
+			objPath, _ := storage.keyToPath(testBucket, key)
+			rel, _ := filepath.Rel(storage.basePath, objPath)
+			path = strings.Replace(path, rel, key, 1)
+		}
+		issues = append(issues, path+": "+issue.Problem)
+	}
+	return issues
+}
+
+func TestVerifyDataDirectory(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	keys := []string{"ok", "truncated", "no-meta", "no-data", "garbage"}
+	for _, key := range keys {
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+	if issues := verifyIssues(t, storage); len(issues) != 0 {
+		t.Fatalf("issues in a consistent bucket: %v", issues)
+	}
+
+	objPath := func(key string) string {
+		path, _ := storage.keyToPath(testBucket, key)
+		return path
+	}
+	if err := os.Truncate(filepath.Join(objPath("truncated"), "data"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(objPath("no-meta"), "meta.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(objPath("no-data"), "data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(objPath("garbage"), "meta.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for _, issue := range verifyIssues(t, storage, keys...) {
+		key, problem, _ := strings.Cut(issue, ": ")
+		got[key+": "+strings.SplitN(problem, ":", 2)[0]] = true
+	}
+	for _, want := range []string{
+		"truncated: size mismatch",
+		"no-meta: data without metadata",
+		"no-data: metadata without data",
+		"garbage: unreadable meta.json",
+	} {
+		if !got[want] {
+			t.Errorf("missing issue %q in %v", want, got)
+		}
+	}
+	if len(got) != 4 {
+		t.Errorf("issues = %v, want 4", got)
+	}
+}
+
+func TestVerifyDataDirectoryKV(t *testing.T) {
+	storage, _ := setupKVStorage(t)
+
+	for _, key := range []string{"ok", "no-data"} {
+		if _, err := storage.PutObject(context.Background(), testBucket, key, "text/plain", nil, strings.NewReader("content")); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+	objPath, _ := storage.keyToPath(testBucket, "no-data")
+	if err := os.RemoveAll(objPath); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := verifyIssues(t, storage, "no-data")
+	if len(issues) != 1 || !strings.HasSuffix(issues[0], "no-data: metadata without data") {
+		t.Errorf("issues = %v, want metadata without data for no-data", issues)
+	}
+}
+
+func TestVerifyDataDirectoryVersions(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	if err := storage.PutBucketVersioning(testBucket, "Enabled"); err != nil {
+		t.Fatalf("PutBucketVersioning failed: %v", err)
+	}
+	for _, body := range []string{"v1", "version 2"} {
+		if _, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader(body)); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+	if err := storage.DeleteObject(testBucket, "doc"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if issues := verifyIssues(t, storage); len(issues) != 0 {
+		t.Fatalf("issues in a consistent bucket: %v", issues)
+	}
+
+	// The delete marker has no data; truncate the data of both versions
+	objPath, _ := storage.keyToPath(testBucket, "doc")
+	versions, err := filepath.Glob(filepath.Join(objPath, versionsDir, "*", "data"))
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("version data files = %v, %v; want 2", versions, err)
+	}
+	for _, data := range versions {
+		if err := os.Truncate(data, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	issues := verifyIssues(t, storage, "doc")
+	if len(issues) != 2 {
+		t.Fatalf("issues = %v, want two size mismatches", issues)
+	}
+	for _, issue := range issues {
+		if !strings.HasPrefix(issue, "doc/versions/") || !strings.Contains(issue, "size mismatch") {
+			t.Errorf("issue = %q, want a size mismatch of a version", issue)
+		}
+	}
+}
+
+func TestVerifyDataDirectoryChecksums(t *testing.T) {
+	for _, store := range []string{MetadataStoreJSON, MetadataStoreKV} {
+		t.Run(store, func(t *testing.T) {
+			storage, cleanup := setupTestStorage(t)
+			defer cleanup()
+			if store == MetadataStoreKV {
+				storage, _ = setupKVStorage(t)
+			}
+			ctx := context.Background()
+
+			for _, key := range []string{"ok", "rot"} {
+				if _, err := storage.PutObject(ctx, testBucket, key, "text/plain", nil, strings.NewReader("content")); err != nil {
+					t.Fatalf("PutObject(%s) failed: %v", key, err)
+				}
+			}
+			uploadID, err := storage.CreateMultipartUpload(testBucket, "multipart", "text/plain", nil)
+			if err != nil {
+				t.Fatalf("CreateMultipartUpload failed: %v", err)
+			}
+			part, err := storage.UploadPart(ctx, uploadID, 1, strings.NewReader("content"))
+			if err != nil {
+				t.Fatalf("UploadPart failed: %v", err)
+			}
+			if _, err := storage.CompleteMultipartUpload(ctx, uploadID, []s3.CompletedPartInput{{PartNumber: 1, ETag: part.ETag}}); err != nil {
+				t.Fatalf("CompleteMultipartUpload failed: %v", err)
+			}
+
+			// Flip the data of both objects without changing their size
+			for _, key := range []string{"rot", "multipart"} {
+				objPath, _ := storage.keyToPath(testBucket, key)
+				if err := os.WriteFile(filepath.Join(objPath, "data"), []byte("CONTENT"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if issues := verifyIssues(t, storage); len(issues) != 0 {
+				t.Fatalf("issues without checksums: %v", issues)
+			}
+
+			results, err := VerifyDataDirectoryWithOptions(storage.basePath, storage.bucketPaths, "", VerifyOptions{Checksums: true, Quarantine: true})
+			if err != nil {
+				t.Fatalf("VerifyDataDirectoryWithOptions failed: %v", err)
+			}
+			issues := results[0].Issues
+			if len(issues) != 1 || !strings.Contains(issues[0].Problem, "checksum mismatch") {
+				t.Fatalf("issues = %+v, want one checksum mismatch", issues)
+			}
+			data, err := os.ReadFile(filepath.Join(storage.basePath, issues[0].Quarantined, "data"))
+			if err != nil || string(data) != "CONTENT" {
+				t.Errorf("quarantined data = %q, %v", data, err)
+			}
+			var meta s3.ObjectMetadata
+			if err := readJSONFile(filepath.Join(storage.basePath, issues[0].Quarantined, "meta.json"), &meta); err != nil || meta.Key != "rot" {
+				t.Errorf("quarantined metadata = %+v, %v; want the metadata of rot", meta, err)
+			}
+
+			// The corrupt object is gone and the layout is consistent again
+			reopened, err := NewFilesystemStorageWithOptions(storage.basePath, t.TempDir(), FilesystemOptions{MetadataStore: store})
+			if err != nil {
+				t.Fatalf("failed to reopen storage: %v", err)
+			}
+			if _, err := reopened.HeadObject(testBucket, "rot"); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("HeadObject(rot) err = %v, want ErrObjectNotFound", err)
+			}
+			if _, err := reopened.HeadObject(testBucket, "ok"); err != nil {
+				t.Errorf("HeadObject(ok) failed: %v", err)
+			}
+			if issues := verifyIssues(t, reopened); len(issues) != 0 {
+				t.Errorf("issues after quarantine: %v", issues)
+			}
+		})
+	}
+}
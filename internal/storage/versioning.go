@@ -0,0 +1,576 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/espen/stupid-simple-s3/internal/s3"
+	"github.com/google/uuid"
+)
+
+// Bucket versioning states. A bucket that never had versioning enabled has
+// an empty status.
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+)
+
+// NullVersionID identifies the version of an object written while
+// versioning was not enabled
+const NullVersionID = "null"
+
+var (
+	// ErrNoSuchVersion is returned when an object version does not exist
+	ErrNoSuchVersion = errors.New("version not found")
+	// ErrInvalidVersioningStatus is returned for a status other than
+	// Enabled or Suspended
+	ErrInvalidVersioningStatus = errors.New("invalid versioning status")
+	// ErrDeleteMarker is returned when reading the data of a delete marker
+	ErrDeleteMarker = errors.New("version is a delete marker")
+)
+
+const (
+	// versioningFile holds the versioning status of a bucket
+	versioningFile = "versioning.json"
+	// versionsDir holds the noncurrent versions and delete markers of an
+	// object, one directory per version ID
+	versionsDir = "versions"
+	// versionMetaFile is the metadata of a noncurrent version. It is not
+	// named meta.json, so that walking the bucket only finds current versions.
+	versionMetaFile = "version.json"
+	// objectLockStripes is the number of locks serializing object writes
+	objectLockStripes = 64
+)
+
+// bucketVersioning is the content of versioningFile
+type bucketVersioning struct {
+	Status string `json:"status"`
+}
+
+// ListObjectVersionsOptions contains options for listing object versions
+type ListObjectVersionsOptions struct {
+	Prefix          string
+	KeyMarker       string
+	VersionIDMarker string
+	MaxKeys         int
+}
+
+// ObjectVersion is an entry in a version listing
+type ObjectVersion struct {
+	s3.ObjectMetadata
+	IsLatest bool
+}
+
+// ListObjectVersionsResult contains the result of listing object versions
+type ListObjectVersionsResult struct {
+	Versions            []ObjectVersion
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string
+}
+
+// GetBucketVersioning returns the versioning status of a bucket
+func (fs *FilesystemStorage) GetBucketVersioning(bucket string) (string, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return "", err
+	}
+	if status, ok := fs.versioning.Load(bucket); ok {
+		return status.(string), nil
+	}
+
+	var config bucketVersioning
+	bucketPath := fs.bucketPath(bucket)
+	if err := readJSONFile(filepath.Join(bucketPath, versioningFile), &config); err != nil {
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("reading versioning status: %w", err)
+		}
+		if _, err := os.Stat(filepath.Join(bucketPath, "objects")); err != nil {
+			if os.IsNotExist(err) {
+				return "", ErrBucketNotFound
+			}
+			return "", fmt.Errorf("checking bucket directory: %w", err)
+		}
+	}
+
+	fs.versioning.Store(bucket, config.Status)
+	return config.Status, nil
+}
+
+// PutBucketVersioning enables or suspends versioning for a bucket. Once
+// enabled, versioning can only be suspended, not turned off.
+func (fs *FilesystemStorage) PutBucketVersioning(bucket, status string) error {
+	if status != VersioningEnabled && status != VersioningSuspended {
+		return ErrInvalidVersioningStatus
+	}
+	if _, err := fs.GetBucketVersioning(bucket); err != nil {
+		return err
+	}
+
+	path := filepath.Join(fs.bucketPath(bucket), versioningFile)
+	if err := writeFileAtomic(fs.syncer, path, bucketVersioning{Status: status}); err != nil {
+		return err
+	}
+	fs.versioning.Store(bucket, status)
+	return nil
+}
+
+// HeadObjectVersion retrieves the metadata of a specific version of an object
+func (fs *FilesystemStorage) HeadObjectVersion(bucket, key, versionID string) (*s3.ObjectMetadata, error) {
+	objPath, err := fs.keyToPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	meta, _, err := fs.findVersion(bucket, key, objPath, versionID)
+	return meta, err
+}
+
+// OpenObjectVersion opens a specific version of an object
+func (fs *FilesystemStorage) OpenObjectVersion(bucket, key, versionID string) (io.ReadSeekCloser, *s3.ObjectMetadata, error) {
+	objPath, err := fs.keyToPath(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// As in OpenObject, the current version's metadata and data must not
+	// come from different writes
+	unlock := fs.rlockObject(objPath)
+	defer unlock()
+
+	meta, dir, err := fs.findVersion(bucket, key, objPath, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if meta.DeleteMarker {
+		return nil, meta, ErrDeleteMarker
+	}
+
+	file, err := os.Open(filepath.Join(dir, "data"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrNoSuchVersion
+		}
+		return nil, nil, fmt.Errorf("opening object data: %w", err)
+	}
+	return file, meta, nil
+}
+
+// DeleteObjectVersion deletes an object version. Without a version ID it
+// deletes the current version: in a versioned bucket the version is kept and
+// a delete marker is returned. With a version ID that version is removed for
+// good, and the previous version becomes current if the latest is gone.
+// The deleted version's metadata is returned.
+func (fs *FilesystemStorage) DeleteObjectVersion(bucket, key, versionID string) (*s3.ObjectMetadata, error) {
+	objPath, err := fs.keyToPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := fs.GetBucketVersioning(bucket)
+	if err != nil {
+		return nil, err
+	}
+	// In a bucket without versioning the null version is the object itself,
+	// which is deleted like any other, into the trash if it is enabled
+	if status == "" && (versionID == "" || versionID == NullVersionID) {
+		return nil, fs.deleteUnversioned(bucket, key, objPath)
+	}
+
+	unlock := fs.lockObject(objPath)
+	defer unlock()
+
+	if versionID == "" {
+		return fs.insertDeleteMarker(bucket, key, objPath, status)
+	}
+
+	meta, dir, err := fs.findVersion(bucket, key, objPath, versionID)
+	if err != nil {
+		return nil, err
+	}
+	// Removing a delete marker brings the object back rather than losing data
+	if !meta.DeleteMarker {
+		if err := lockError(meta, fs.immutabilityWindows[bucket], time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir == objPath {
+		if err := fs.removeCurrent(bucket, key, objPath); err != nil {
+			return nil, err
+		}
+	} else if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("removing object version: %w", err)
+	}
+
+	if err := fs.restoreLatestVersion(bucket, objPath); err != nil {
+		return nil, err
+	}
+	removeEmptyObjectDirs(objPath)
+	return meta, nil
+}
+
+// ListObjectVersions lists every version and delete marker in a bucket,
+// ordered by key and then newest first. Keys with noncurrent versions are
+// found by walking the bucket, so the cost grows with the bucket size.
+func (fs *FilesystemStorage) ListObjectVersions(bucket string, opts ListObjectVersionsOptions) (*ListObjectVersionsResult, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+	if opts.MaxKeys <= 0 || opts.MaxKeys > 1000 {
+		opts.MaxKeys = 1000
+	}
+
+	index, err := fs.meta.index(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string][]s3.ObjectMetadata)
+	for _, key := range index.snapshot() {
+		if !strings.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+		meta, err := fs.HeadObject(bucket, key)
+		if err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		versions[key] = append(versions[key], *meta)
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(fs.bucketPath(bucket), "objects", "*", "*", versionsDir))
+	if err != nil {
+		return nil, fmt.Errorf("finding object versions: %w", err)
+	}
+	for _, dir := range dirs {
+		noncurrent, err := readVersions(filepath.Dir(dir))
+		if err != nil {
+			return nil, err
+		}
+		for _, meta := range noncurrent {
+			if strings.HasPrefix(meta.Key, opts.Prefix) {
+				versions[meta.Key] = append(versions[meta.Key], meta)
+			}
+		}
+	}
+
+	return listVersions(versions, opts), nil
+}
+
+// listVersions returns the page of a version listing selected by opts from
+// the versions of each key
+func listVersions(versions map[string][]s3.ObjectMetadata, opts ListObjectVersionsOptions) *ListObjectVersionsResult {
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := &ListObjectVersionsResult{}
+	for _, key := range keys {
+		if key < opts.KeyMarker || (key == opts.KeyMarker && opts.VersionIDMarker == "") {
+			continue
+		}
+
+		entries := versions[key]
+		sortVersions(entries)
+		skipping := key == opts.KeyMarker
+		for i, meta := range entries {
+			if meta.VersionID == "" {
+				meta.VersionID = NullVersionID
+			}
+			if skipping {
+				if meta.VersionID == opts.VersionIDMarker {
+					skipping = false
+				}
+				continue
+			}
+			if len(result.Versions) == opts.MaxKeys {
+				last := result.Versions[len(result.Versions)-1]
+				result.IsTruncated = true
+				result.NextKeyMarker = last.Key
+				result.NextVersionIDMarker = last.VersionID
+				return result
+			}
+			result.Versions = append(result.Versions, ObjectVersion{ObjectMetadata: meta, IsLatest: i == 0})
+		}
+	}
+	return result
+}
+
+// prepareVersionedWrite is called before a new current version of key is
+// moved into place. It locks the key, so that readers never see the new
+// data with the old metadata, and in a versioned bucket keeps the current
+// version and returns the ID for the new one. Object locks are checked
+// again under the lock, since a legal hold may have been set while the data
+// was written. The returned function releases the lock.
+func (fs *FilesystemStorage) prepareVersionedWrite(bucket, key, objPath string) (string, func(), error) {
+	status, err := fs.GetBucketVersioning(bucket)
+	if err != nil {
+		return "", nil, err
+	}
+
+	unlock := fs.lockObject(objPath)
+	if err := fs.checkNotLocked(bucket, key); err != nil {
+		unlock()
+		return "", nil, err
+	}
+	if status == "" {
+		return "", unlock, nil
+	}
+	if err := fs.archiveCurrent(bucket, key, objPath, status); err != nil {
+		unlock()
+		return "", nil, err
+	}
+	return newVersionID(status), unlock, nil
+}
+
+// archiveCurrent keeps the current version of key as a noncurrent version
+// before it is replaced (caller must hold the version lock). While
+// versioning is suspended the null version is replaced instead, as in S3.
+func (fs *FilesystemStorage) archiveCurrent(bucket, key, objPath, status string) error {
+	if status == VersioningSuspended {
+		if err := os.RemoveAll(filepath.Join(objPath, versionsDir, NullVersionID)); err != nil {
+			return fmt.Errorf("removing null version: %w", err)
+		}
+	}
+
+	current, err := fs.meta.get(bucket, key, objPath)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil
+		}
+		return err
+	}
+	if current.VersionID == "" {
+		current.VersionID = NullVersionID
+	}
+	if status == VersioningSuspended && current.VersionID == NullVersionID {
+		return nil
+	}
+
+	versionPath := filepath.Join(objPath, versionsDir, current.VersionID)
+	if err := os.MkdirAll(versionPath, 0700); err != nil {
+		return fmt.Errorf("creating version directory: %w", err)
+	}
+	// A hard link keeps the data in place for readers of the current version
+	// until the new data file is renamed over it
+	if err := os.Link(filepath.Join(objPath, "data"), filepath.Join(versionPath, "data")); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("linking version data: %w", err)
+	}
+	return writeFileAtomic(fs.syncer, filepath.Join(versionPath, versionMetaFile), current)
+}
+
+// insertDeleteMarker hides the current version of key behind a new delete
+// marker (caller must hold the version lock)
+func (fs *FilesystemStorage) insertDeleteMarker(bucket, key, objPath, status string) (*s3.ObjectMetadata, error) {
+	// A suspended bucket replaces the null version, which must not be locked
+	if status == VersioningSuspended {
+		meta, _, err := fs.findVersion(bucket, key, objPath, NullVersionID)
+		if err != nil && !errors.Is(err, ErrNoSuchVersion) {
+			return nil, err
+		}
+		if err == nil && !meta.DeleteMarker {
+			if err := lockError(meta, fs.immutabilityWindows[bucket], time.Now()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := fs.archiveCurrent(bucket, key, objPath, status); err != nil {
+		return nil, err
+	}
+	if err := fs.removeCurrent(bucket, key, objPath); err != nil {
+		return nil, err
+	}
+
+	marker := &s3.ObjectMetadata{
+		Key:          key,
+		LastModified: time.Now().UTC(),
+		VersionID:    newVersionID(status),
+		DeleteMarker: true,
+	}
+	markerPath := filepath.Join(objPath, versionsDir, marker.VersionID)
+	if err := os.MkdirAll(markerPath, 0700); err != nil {
+		return nil, fmt.Errorf("creating version directory: %w", err)
+	}
+	if err := writeFileAtomic(fs.syncer, filepath.Join(markerPath, versionMetaFile), marker); err != nil {
+		return nil, err
+	}
+	return marker, nil
+}
+
+// removeCurrent removes the current version of key, leaving its noncurrent
+// versions in place
+func (fs *FilesystemStorage) removeCurrent(bucket, key, objPath string) error {
+	if err := fs.meta.delete(bucket, key, objPath); err != nil {
+		return fmt.Errorf("removing object metadata: %w", err)
+	}
+	for _, name := range []string{"data", "meta.json"} {
+		if err := os.Remove(filepath.Join(objPath, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing object: %w", err)
+		}
+	}
+	return nil
+}
+
+// restoreLatestVersion makes the newest noncurrent version current when
+// key has no current version, unless that version is a delete marker
+// (caller must hold the version lock)
+func (fs *FilesystemStorage) restoreLatestVersion(bucket, objPath string) error {
+	versions, err := readVersions(objPath)
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+	latest := versions[0]
+	if _, err := fs.meta.get(bucket, latest.Key, objPath); err == nil || !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	if latest.DeleteMarker {
+		return nil
+	}
+
+	versionPath := filepath.Join(objPath, versionsDir, latest.VersionID)
+	if err := os.Rename(filepath.Join(versionPath, "data"), filepath.Join(objPath, "data")); err != nil {
+		return fmt.Errorf("restoring version data: %w", err)
+	}
+	if err := fs.syncer.syncDir(objPath); err != nil {
+		return fmt.Errorf("syncing object directory: %w", err)
+	}
+	if err := fs.meta.put(bucket, objPath, &latest); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(versionPath); err != nil {
+		return fmt.Errorf("removing restored version: %w", err)
+	}
+	return nil
+}
+
+// findVersion returns the metadata of a version of key and the directory
+// holding its data, which is objPath for the current version
+func (fs *FilesystemStorage) findVersion(bucket, key, objPath, versionID string) (*s3.ObjectMetadata, string, error) {
+	current, err := fs.meta.get(bucket, key, objPath)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return nil, "", err
+	}
+	if current != nil && (current.VersionID == versionID || (current.VersionID == "" && versionID == NullVersionID)) {
+		return current, objPath, nil
+	}
+
+	if !validVersionID(versionID) {
+		return nil, "", ErrNoSuchVersion
+	}
+	versionPath := filepath.Join(objPath, versionsDir, versionID)
+	var meta s3.ObjectMetadata
+	if err := readJSONFile(filepath.Join(versionPath, versionMetaFile), &meta); err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", ErrNoSuchVersion
+		}
+		return nil, "", fmt.Errorf("reading version metadata: %w", err)
+	}
+	return &meta, versionPath, nil
+}
+
+// readVersions returns the noncurrent versions of the object at objPath,
+// newest first
+func readVersions(objPath string) ([]s3.ObjectMetadata, error) {
+	entries, err := os.ReadDir(filepath.Join(objPath, versionsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading object versions: %w", err)
+	}
+
+	var versions []s3.ObjectMetadata
+	for _, entry := range entries {
+		var meta s3.ObjectMetadata
+		if err := readJSONFile(filepath.Join(objPath, versionsDir, entry.Name(), versionMetaFile), &meta); err != nil {
+			// Skip versions that are being written or removed
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("reading version metadata: %w", err)
+		}
+		versions = append(versions, meta)
+	}
+	sortVersions(versions)
+	return versions, nil
+}
+
+// sortVersions orders versions of one key newest first
+func sortVersions(versions []s3.ObjectMetadata) {
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].LastModified.Equal(versions[j].LastModified) {
+			return versions[i].LastModified.After(versions[j].LastModified)
+		}
+		return versions[i].VersionID > versions[j].VersionID
+	})
+}
+
+// removeEmptyObjectDirs removes the versions, object and shard directories
+// once they are empty
+func removeEmptyObjectDirs(objPath string) {
+	_ = os.Remove(filepath.Join(objPath, versionsDir))
+	_ = os.Remove(objPath)
+	_ = os.Remove(filepath.Dir(objPath))
+}
+
+// lockObject serializes moving a new version of the object at objPath into
+// place, which replaces its data and then its metadata, against other
+// writes, deletes and legal hold changes of the same key and against reads,
+// and returns the unlock function. Keys share a fixed set of lock stripes,
+// so unrelated keys only rarely contend.
+func (fs *FilesystemStorage) lockObject(objPath string) func() {
+	mu := fs.objectLock(objPath)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// rlockObject locks the object at objPath for reading, so that its metadata
+// and data are read from the same write, and returns the unlock function.
+// Reads of the same key do not wait for each other.
+func (fs *FilesystemStorage) rlockObject(objPath string) func() {
+	mu := fs.objectLock(objPath)
+	mu.RLock()
+	return mu.RUnlock
+}
+
+// objectLock returns the lock stripe of the object at objPath
+func (fs *FilesystemStorage) objectLock(objPath string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(objPath))
+	return &fs.objectLocks[h.Sum32()%objectLockStripes]
+}
+
+// newVersionID returns the ID for a version written under status
+func newVersionID(status string) string {
+	if status == VersioningSuspended {
+		return NullVersionID
+	}
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// validVersionID reports whether id can be a version directory name
+func validVersionID(id string) bool {
+	if id == NullVersionID {
+		return true
+	}
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
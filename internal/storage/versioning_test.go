@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readVersion(t *testing.T, storage MultipartStorage, key, versionID string) string {
+	t.Helper()
+	reader, _, err := storage.OpenObjectVersion(testBucket, key, versionID)
+	if err != nil {
+		t.Fatalf("OpenObjectVersion(%s, %s) failed: %v", key, versionID, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading version failed: %v", err)
+	}
+	return string(data)
+}
+
+func readCurrent(t *testing.T, storage MultipartStorage, key string) string {
+	t.Helper()
+	reader, _, err := storage.GetObject(context.Background(), testBucket, key)
+	if err != nil {
+		t.Fatalf("GetObject(%s) failed: %v", key, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading object failed: %v", err)
+	}
+	return string(data)
+}
+
+// listVersionIDs returns "key:versionID" for each version, with a "*" suffix
+// on the latest version and "(marker)" on delete markers
+func listVersionIDs(t *testing.T, storage MultipartStorage, opts ListObjectVersionsOptions) []string {
+	t.Helper()
+	result, err := storage.ListObjectVersions(testBucket, opts)
+	if err != nil {
+		t.Fatalf("ListObjectVersions failed: %v", err)
+	}
+	var ids []string
+	for _, v := range result.Versions {
+		id := v.Key + ":" + v.VersionID
+		if v.DeleteMarker {
+			id += "(marker)"
+		}
+		if v.IsLatest {
+			id += "*"
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestBucketVersioningStatus(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	status, err := storage.GetBucketVersioning(testBucket)
+	if err != nil || status != "" {
+		t.Fatalf("initial status = %q, %v; want empty", status, err)
+	}
+
+	if err := storage.PutBucketVersioning(testBucket, "On"); !errors.Is(err, ErrInvalidVersioningStatus) {
+		t.Errorf("invalid status error = %v, want ErrInvalidVersioningStatus", err)
+	}
+	if err := storage.PutBucketVersioning("missing-bucket", VersioningEnabled); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("missing bucket error = %v, want ErrBucketNotFound", err)
+	}
+
+	if err := storage.PutBucketVersioning(testBucket, VersioningEnabled); err != nil {
+		t.Fatalf("PutBucketVersioning failed: %v", err)
+	}
+
+	// The status survives a restart
+	reopened, err := NewFilesystemStorage(storage.basePath, storage.multipartPath)
+	if err != nil {
+		t.Fatalf("failed to reopen storage: %v", err)
+	}
+	if status, _ := reopened.GetBucketVersioning(testBucket); status != VersioningEnabled {
+		t.Errorf("status after reopen = %q, want %q", status, VersioningEnabled)
+	}
+}
+
+func TestObjectVersions(t *testing.T) {
+	jsonStorage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	kvStorage, _ := setupKVStorage(t)
+
+	backends := map[string]MultipartStorage{"json": jsonStorage, "kv": kvStorage, "memory": setupMemoryStorage(t)}
+	for name, storage := range backends {
+		t.Run(name, func(t *testing.T) {
+			// An object written before versioning becomes the null version
+			if _, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("v0")); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			if err := storage.PutBucketVersioning(testBucket, VersioningEnabled); err != nil {
+				t.Fatalf("PutBucketVersioning failed: %v", err)
+			}
+
+			v1, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("v1"))
+			if err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			v2, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("v2"))
+			if err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			if v1.VersionID == "" || v1.VersionID == v2.VersionID {
+				t.Fatalf("version IDs = %q, %q; want distinct IDs", v1.VersionID, v2.VersionID)
+			}
+
+			if got := readCurrent(t, storage, "doc"); got != "v2" {
+				t.Errorf("current = %q, want v2", got)
+			}
+			if got := readVersion(t, storage, "doc", v1.VersionID); got != "v1" {
+				t.Errorf("version %s = %q, want v1", v1.VersionID, got)
+			}
+			if got := readVersion(t, storage, "doc", NullVersionID); got != "v0" {
+				t.Errorf("null version = %q, want v0", got)
+			}
+			if _, err := storage.HeadObjectVersion(testBucket, "doc", strings.Repeat("0", 32)); !errors.Is(err, ErrNoSuchVersion) {
+				t.Errorf("unknown version error = %v, want ErrNoSuchVersion", err)
+			}
+			if _, err := storage.HeadObjectVersion(testBucket, "doc", "../../doc"); !errors.Is(err, ErrNoSuchVersion) {
+				t.Errorf("malformed version error = %v, want ErrNoSuchVersion", err)
+			}
+
+			// Deleting without a version ID inserts a delete marker
+			marker, err := storage.DeleteObjectVersion(testBucket, "doc", "")
+			if err != nil {
+				t.Fatalf("DeleteObjectVersion failed: %v", err)
+			}
+			if !marker.DeleteMarker || marker.VersionID == "" {
+				t.Fatalf("delete returned %+v, want a delete marker", marker)
+			}
+			if _, err := storage.HeadObject(testBucket, "doc"); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("HeadObject after delete error = %v, want ErrObjectNotFound", err)
+			}
+			if _, _, err := storage.OpenObjectVersion(testBucket, "doc", marker.VersionID); !errors.Is(err, ErrDeleteMarker) {
+				t.Errorf("opening delete marker error = %v, want ErrDeleteMarker", err)
+			}
+			if keys := listKeys(t, storage); len(keys) != 0 {
+				t.Errorf("listed keys = %v, want none", keys)
+			}
+			if err := storage.DeleteBucket(testBucket); !errors.Is(err, ErrBucketNotEmpty) {
+				t.Errorf("DeleteBucket error = %v, want ErrBucketNotEmpty", err)
+			}
+
+			want := []string{
+				"doc:" + marker.VersionID + "(marker)*",
+				"doc:" + v2.VersionID,
+				"doc:" + v1.VersionID,
+				"doc:null",
+			}
+			if got := listVersionIDs(t, storage, ListObjectVersionsOptions{}); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("versions = %v, want %v", got, want)
+			}
+
+			// Pagination resumes after the key and version markers
+			page := listVersionIDs(t, storage, ListObjectVersionsOptions{KeyMarker: "doc", VersionIDMarker: v2.VersionID, MaxKeys: 1})
+			if len(page) != 1 || page[0] != "doc:"+v1.VersionID {
+				t.Errorf("page = %v, want [doc:%s]", page, v1.VersionID)
+			}
+
+			// Removing the delete marker makes the previous version current
+			if _, err := storage.DeleteObjectVersion(testBucket, "doc", marker.VersionID); err != nil {
+				t.Fatalf("deleting marker failed: %v", err)
+			}
+			if got := readCurrent(t, storage, "doc"); got != "v2" {
+				t.Errorf("current after removing marker = %q, want v2", got)
+			}
+
+			// Removing the current version restores the one before it
+			if _, err := storage.DeleteObjectVersion(testBucket, "doc", v2.VersionID); err != nil {
+				t.Fatalf("deleting current version failed: %v", err)
+			}
+			if got := readCurrent(t, storage, "doc"); got != "v1" {
+				t.Errorf("current after removing v2 = %q, want v1", got)
+			}
+			if keys := listKeys(t, storage); strings.Join(keys, ",") != "doc" {
+				t.Errorf("listed keys = %v, want [doc]", keys)
+			}
+
+			// Removing every version removes the object
+			for _, id := range []string{v1.VersionID, NullVersionID} {
+				if _, err := storage.DeleteObjectVersion(testBucket, "doc", id); err != nil {
+					t.Fatalf("deleting version %s failed: %v", id, err)
+				}
+			}
+			if got := listVersionIDs(t, storage, ListObjectVersionsOptions{}); len(got) != 0 {
+				t.Errorf("versions after removing all = %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestObjectVersionsSuspended(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, storage MultipartStorage) {
+		if err := storage.PutBucketVersioning(testBucket, VersioningEnabled); err != nil {
+			t.Fatalf("PutBucketVersioning failed: %v", err)
+		}
+		v1, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader("v1"))
+		if err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+
+		if err := storage.PutBucketVersioning(testBucket, VersioningSuspended); err != nil {
+			t.Fatalf("PutBucketVersioning failed: %v", err)
+		}
+		for _, content := range []string{"n1", "n2"} {
+			meta, err := storage.PutObject(context.Background(), testBucket, "doc", "text/plain", nil, strings.NewReader(content))
+			if err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			if meta.VersionID != NullVersionID {
+				t.Errorf("version ID while suspended = %q, want %q", meta.VersionID, NullVersionID)
+			}
+		}
+
+		// Writes while suspended replace the null version and keep the others
+		want := []string{"doc:null*", "doc:" + v1.VersionID}
+		if got := listVersionIDs(t, storage, ListObjectVersionsOptions{}); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("versions = %v, want %v", got, want)
+		}
+		if got := readCurrent(t, storage, "doc"); got != "n2" {
+			t.Errorf("current = %q, want n2", got)
+		}
+	})
+}
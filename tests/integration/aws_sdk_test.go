@@ -3,15 +3,22 @@ package integration
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 // TestAWSSDK_CreateBucket tests bucket creation
@@ -93,12 +100,21 @@ func TestAWSSDK_DeleteBucket(t *testing.T) {
 		}
 
 		// Delete bucket
-		_, err = client.DeleteBucket(ctx, &s3.DeleteBucketInput{
+		bucketsBefore := bucketsTotal(t, ts)
+		out, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{
 			Bucket: aws.String(bucketName),
 		})
 		if err != nil {
 			t.Fatalf("DeleteBucket failed: %v", err)
 		}
+		if resp, ok := awsmiddleware.GetRawResponse(out.ResultMetadata).(*smithyhttp.Response); !ok {
+			t.Error("no raw response")
+		} else if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusNoContent)
+		}
+		if after := bucketsTotal(t, ts); after != bucketsBefore-1 {
+			t.Errorf("buckets_total = %v, want %v", after, bucketsBefore-1)
+		}
 
 		// Verify bucket no longer exists
 		_, err = client.HeadBucket(ctx, &s3.HeadBucketInput{
@@ -173,9 +189,40 @@ func TestAWSSDK_DeleteBucket(t *testing.T) {
 		if err == nil {
 			t.Fatal("expected error with read-only credentials")
 		}
+		if !strings.Contains(err.Error(), "AccessDenied") {
+			t.Errorf("expected AccessDenied error, got: %v", err)
+		}
+		if _, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucketName)}); err != nil {
+			t.Errorf("bucket deleted with read-only credentials: %v", err)
+		}
 	})
 }
 
+// bucketsTotal scrapes the current value of the buckets gauge
+func bucketsTotal(t *testing.T, ts *TestServer) float64 {
+	t.Helper()
+	resp, err := http.Get(ts.URL() + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		if value, ok := strings.CutPrefix(line, "stupid_simple_s3_buckets_total "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("invalid buckets_total %q: %v", value, err)
+			}
+			return v
+		}
+	}
+	t.Fatal("buckets_total not found in metrics")
+	return 0
+}
+
 // TestAWSSDK_HeadBucket tests bucket existence check
 func TestAWSSDK_HeadBucket(t *testing.T) {
 	ts := NewTestServer(t)
@@ -460,6 +507,23 @@ func TestAWSSDK_ListObjects(t *testing.T) {
 		}
 	})
 
+	t.Run("list with fetch owner", func(t *testing.T) {
+		result, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:     aws.String(TestBucket),
+			Prefix:     aws.String("list/"),
+			FetchOwner: aws.Bool(true),
+		})
+		if err != nil {
+			t.Fatalf("ListObjectsV2 failed: %v", err)
+		}
+
+		for _, obj := range result.Contents {
+			if obj.Owner == nil || aws.ToString(obj.Owner.DisplayName) != TestAccessKeyID || aws.ToString(obj.Owner.ID) == "" {
+				t.Errorf("object %s has Owner %+v", aws.ToString(obj.Key), obj.Owner)
+			}
+		}
+	})
+
 	t.Run("list with max keys", func(t *testing.T) {
 		result, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
 			Bucket:  aws.String(TestBucket),
@@ -674,6 +738,58 @@ func TestAWSSDK_PresignedDownload(t *testing.T) {
 	}
 }
 
+// TestAWSSDK_PresignedExpiryLimit tests that presigned URLs are accepted with
+// an expiry of up to 7 days and rejected beyond
+func TestAWSSDK_PresignedExpiryLimit(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ctx := context.Background()
+	client := ts.AWSClient(ctx)
+	presignClient := ts.AWSPresignClient(ctx)
+
+	key := "presigned-expiry.txt"
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(TestBucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader("content"),
+	})
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		expires    time.Duration
+		wantStatus int
+		wantCode   string
+	}{
+		{7 * 24 * time.Hour, http.StatusOK, ""},
+		{7*24*time.Hour + time.Second, http.StatusBadRequest, "AuthorizationQueryParametersError"},
+	} {
+		presignResult, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(TestBucket),
+			Key:    aws.String(key),
+		}, s3.WithPresignExpires(tt.expires))
+		if err != nil {
+			t.Fatalf("PresignGetObject(%s) failed: %v", tt.expires, err)
+		}
+
+		resp, err := http.Get(presignResult.URL)
+		if err != nil {
+			t.Fatalf("HTTP GET failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("expires %s: status = %d, want %d: %s", tt.expires, resp.StatusCode, tt.wantStatus, body)
+		}
+		if tt.wantCode != "" && !strings.Contains(string(body), "<Code>"+tt.wantCode+"</Code>") {
+			t.Errorf("expires %s: body = %s, want code %s", tt.expires, body, tt.wantCode)
+		}
+	}
+}
+
 // TestAWSSDK_PresignedUpload tests presigned URL for uploading
 func TestAWSSDK_PresignedUpload(t *testing.T) {
 	ts := NewTestServer(t)
@@ -728,6 +844,91 @@ func TestAWSSDK_PresignedUpload(t *testing.T) {
 	}
 }
 
+// patternReader streams size bytes of the GenerateContent pattern without
+// holding them in memory
+type patternReader struct {
+	offset, size int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if remaining := r.size - r.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	for i := range p {
+		p[i] = byte((r.offset + int64(i)) % 256)
+	}
+	r.offset += int64(len(p))
+	return len(p), nil
+}
+
+// TestAWSSDK_PresignedLargeUpload tests that a large presigned upload, which
+// is signed with UNSIGNED-PAYLOAD, is streamed to storage rather than
+// buffered for signature verification
+func TestAWSSDK_PresignedLargeUpload(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+
+	ctx := context.Background()
+	client := ts.AWSClient(ctx)
+	presignClient := ts.AWSPresignClient(ctx)
+
+	key := "presigned-large-upload.bin"
+	const size = 50 * 1024 * 1024
+
+	presignResult, err := presignClient.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(TestBucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(time.Hour))
+	if err != nil {
+		t.Fatalf("PresignPutObject failed: %v", err)
+	}
+
+	hash := md5.New()
+	body := io.TeeReader(&patternReader{size: size}, hash)
+	req, err := http.NewRequest(http.MethodPut, presignResult.URL, body)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.ContentLength = size
+
+	// The client and server share this process, so buffering the body
+	// anywhere shows up as allocations of at least its size
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("HTTP PUT failed: %v", err)
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	runtime.ReadMemStats(&after)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 OK, got %d: %s", resp.StatusCode, string(respBody))
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/4 {
+		t.Errorf("presigned upload of %d bytes allocated %d bytes, want the body streamed", size, allocated)
+	}
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(TestBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if aws.ToInt64(head.ContentLength) != size {
+		t.Errorf("ContentLength = %d, want %d", aws.ToInt64(head.ContentLength), size)
+	}
+	if want := fmt.Sprintf("\"%x\"", hash.Sum(nil)); aws.ToString(head.ETag) != want {
+		t.Errorf("ETag = %s, want %s", aws.ToString(head.ETag), want)
+	}
+}
+
 // TestAWSSDK_MultipartUpload tests multipart upload workflow
 func TestAWSSDK_MultipartUpload(t *testing.T) {
 	ts := NewTestServer(t)
@@ -1034,6 +1235,22 @@ func TestAWSSDK_SpecialCharacterKeys(t *testing.T) {
 				t.Errorf("content mismatch for key %q", tc.key)
 			}
 
+			// List with URL-encoded keys
+			list, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+				Bucket:       aws.String(TestBucket),
+				Prefix:       aws.String(tc.key),
+				EncodingType: types.EncodingTypeUrl,
+			})
+			if err != nil {
+				t.Fatalf("ListObjectsV2 failed: %v", err)
+			}
+			if len(list.Contents) != 1 {
+				t.Fatalf("listed %d objects, want 1", len(list.Contents))
+			}
+			if key, err := url.QueryUnescape(aws.ToString(list.Contents[0].Key)); err != nil || key != tc.key {
+				t.Errorf("listed key %q decodes to %q, want %q", aws.ToString(list.Contents[0].Key), key, tc.key)
+			}
+
 			// Delete
 			_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
 				Bucket: aws.String(TestBucket),
@@ -0,0 +1,119 @@
+package integration
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// TestExpectContinue verifies that uploads sent with Expect: 100-continue
+// are rejected before the client sends the body when authentication or the
+// size limit fails, and receive 100 Continue otherwise.
+func TestExpectContinue(t *testing.T) {
+	ts := NewTestServer(t)
+	defer ts.Close()
+	ts.Config.Limits.MaxObjectSize = 1024
+
+	// send writes the request headers, without the body, and returns the
+	// first response
+	send := func(t *testing.T, conn net.Conn, br *bufio.Reader, key string, contentLength int64, sign bool) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, ts.URL()+"/"+TestBucket+"/"+key, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.ContentLength = contentLength
+		req.Header.Set("Expect", "100-continue")
+		req.Header.Set("Content-Length", fmt.Sprint(contentLength))
+		if sign {
+			req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+			creds := aws.Credentials{AccessKeyID: TestAccessKeyID, SecretAccessKey: TestSecretAccessKey}
+			signer := v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true })
+			if err := signer.SignHTTP(context.Background(), creds, req, "UNSIGNED-PAYLOAD", "s3", TestRegion, time.Now()); err != nil {
+				t.Fatalf("failed to sign request: %v", err)
+			}
+		}
+
+		var head strings.Builder
+		fmt.Fprintf(&head, "PUT %s HTTP/1.1\r\nHost: %s\r\n", req.URL.EscapedPath(), req.URL.Host)
+		if err := req.Header.Write(&head); err != nil {
+			t.Fatal(err)
+		}
+		head.WriteString("\r\n")
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte(head.String())); err != nil {
+			t.Fatalf("failed to write request: %v", err)
+		}
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		return resp
+	}
+	dial := func(t *testing.T) (net.Conn, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("tcp", ts.Server.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn, bufio.NewReader(conn)
+	}
+
+	t.Run("unauthenticated upload is rejected before the body", func(t *testing.T) {
+		conn, br := dial(t)
+		resp := send(t, conn, br, "unauthenticated", 1<<30, false)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+		}
+	})
+
+	t.Run("oversized upload is rejected before the body", func(t *testing.T) {
+		conn, br := dial(t)
+		resp := send(t, conn, br, "oversized", 1<<30, true)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	t.Run("invalid key is rejected before the body", func(t *testing.T) {
+		conn, br := dial(t)
+		resp := send(t, conn, br, "invalid%01key", 512, true)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("accepted upload receives 100 Continue", func(t *testing.T) {
+		conn, br := dial(t)
+		resp := send(t, conn, br, "accepted", 5, true)
+		if resp.StatusCode != http.StatusContinue {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusContinue)
+		}
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatalf("failed to write body: %v", err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		if _, err := ts.Storage.HeadObject(TestBucket, "accepted"); err != nil {
+			t.Errorf("HeadObject failed: %v", err)
+		}
+	})
+}
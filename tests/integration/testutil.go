@@ -135,11 +135,16 @@ func (ts *TestServer) MinioClient() (*minio.Client, error) {
 
 // MinioClientWithCreds creates a Minio client with specific credentials
 func (ts *TestServer) MinioClientWithCreds(accessKeyID, secretAccessKey string) (*minio.Client, error) {
+	return ts.MinioClientWithSessionToken(accessKeyID, secretAccessKey, "")
+}
+
+// MinioClientWithSessionToken creates a Minio client with temporary credentials
+func (ts *TestServer) MinioClientWithSessionToken(accessKeyID, secretAccessKey, sessionToken string) (*minio.Client, error) {
 	// Extract host from URL (remove http://)
 	endpoint := ts.URL()[7:] // Remove "http://"
 
 	return minio.New(endpoint, &minio.Options{
-		Creds:  miniocreds.NewStaticV4(accessKeyID, secretAccessKey, ""),
+		Creds:  miniocreds.NewStaticV4(accessKeyID, secretAccessKey, sessionToken),
 		Secure: false,
 		Region: TestRegion,
 	})